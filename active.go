@@ -0,0 +1,97 @@
+package cmds
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ActiveRequest describes a request in flight.
+type ActiveRequest struct {
+	ID        string
+	Path      []string
+	Arguments []string `json:",omitempty"`
+	Start     time.Time
+	// Identity is the identity the request is made on behalf of, see
+	// Identity.
+	Identity string `json:",omitempty"`
+}
+
+// ActiveRequests tracks the requests in flight of a server, so they can be
+// listed and canceled, e.g. to stop a runaway command without restarting the
+// server. The zero value is ready to use.
+type ActiveRequests struct {
+	l    sync.Mutex
+	reqs map[string]*activeRequest
+}
+
+type activeRequest struct {
+	info   ActiveRequest
+	cancel context.CancelFunc
+}
+
+// Track adds req, which cancel cancels, to the requests in flight. The
+// returned function removes it once it has finished. Requests without an ID,
+// see RequestID, aren't tracked.
+func (a *ActiveRequests) Track(req *Request, cancel context.CancelFunc) func() {
+	id := RequestID(req.Context)
+	if id == "" {
+		return func() {}
+	}
+
+	ar := &activeRequest{
+		info: ActiveRequest{
+			ID:        id,
+			Path:      req.Path,
+			Arguments: req.Arguments,
+			Start:     time.Now(),
+			Identity:  Identity(req.Context),
+		},
+		cancel: cancel,
+	}
+
+	a.l.Lock()
+	defer a.l.Unlock()
+	if a.reqs == nil {
+		a.reqs = make(map[string]*activeRequest)
+	}
+	a.reqs[id] = ar
+
+	return func() {
+		a.l.Lock()
+		defer a.l.Unlock()
+		if a.reqs[id] == ar {
+			delete(a.reqs, id)
+		}
+	}
+}
+
+// List returns the requests in flight, oldest first.
+func (a *ActiveRequests) List() []ActiveRequest {
+	a.l.Lock()
+	list := make([]ActiveRequest, 0, len(a.reqs))
+	for _, ar := range a.reqs {
+		list = append(list, ar.info)
+	}
+	a.l.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Start.Before(list[j].Start)
+	})
+	return list
+}
+
+// Cancel cancels the context of the request in flight with the given ID. It
+// returns an error of type ErrNotFound if there is none.
+func (a *ActiveRequests) Cancel(id string) error {
+	a.l.Lock()
+	ar, ok := a.reqs[id]
+	a.l.Unlock()
+
+	if !ok {
+		return Errorf(ErrNotFound, "no active request with ID %q", id)
+	}
+	ar.cancel()
+	return nil
+}
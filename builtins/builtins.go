@@ -0,0 +1,218 @@
+// Package builtins provides commands most daemons need, so they don't have to
+// implement them themselves and clients can rely on their paths: PingCmd to
+// check that a daemon is up, the command returned by NewShutdownCmd to stop
+// it gracefully, and the ones returned by NewActiveCmd and NewCancelCmd to
+// list and cancel the requests in flight. Mount adds them to a command tree.
+package builtins
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+// Paths of the commands added by Mount.
+const (
+	PingPath     = "ping"
+	ShutdownPath = "shutdown"
+	ActivePath   = "active"
+	CancelPath   = "cancel"
+)
+
+// GraceOpt is the name of the option of the shutdown command setting how
+// long the requests in flight may take to finish.
+const GraceOpt = "grace"
+
+// DefaultGrace is the default of the grace option.
+const DefaultGrace = 30 * time.Second
+
+var log = cmds.SubsystemLogger("cmds/builtins")
+
+// Mount adds PingCmd and a shutdown command stopping s to root. The shutdown
+// command is left out if s is nil. If s is also a RequestCanceler, like the
+// *http.Handler of the http package of this module, the active and cancel
+// commands are added too. Commands root already has at those paths are
+// replaced.
+func Mount(root *cmds.Command, s Shutdowner) {
+	if root.Subcommands == nil {
+		root.Subcommands = make(map[string]*cmds.Command)
+	}
+	root.Subcommands[PingPath] = PingCmd
+	if s != nil {
+		root.Subcommands[ShutdownPath] = NewShutdownCmd(s)
+	}
+	if rc, ok := s.(RequestCanceler); ok {
+		root.Subcommands[ActivePath] = NewActiveCmd(rc)
+		root.Subcommands[CancelPath] = NewCancelCmd(rc)
+	}
+}
+
+// Pong is the response of PingCmd.
+type Pong struct {
+	// Time is the time of the daemon when it answered.
+	Time time.Time
+}
+
+// PingCmd answers with a Pong, to check that a daemon is up and measure the
+// round trip time to it.
+var PingCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Check that the daemon is up.",
+		ShortDescription: `
+Answers with the current time of the daemon.
+`,
+	},
+	Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+		return cmds.EmitOnce(re, &Pong{Time: time.Now()})
+	},
+	Type: Pong{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, p *Pong) error {
+			_, err := fmt.Fprintln(w, "pong")
+			return err
+		}),
+	},
+}
+
+// Shutdowner is what the shutdown command stops, e.g. the *http.Handler of
+// the http package of this module, or an *http.Server.
+type Shutdowner interface {
+	Shutdown(ctx context.Context) error
+}
+
+// NewShutdownCmd returns a command that shuts s down gracefully. It answers
+// before the shutdown starts, so s may wait for the request to finish.
+func NewShutdownCmd(s Shutdowner) *cmds.Command {
+	return &cmds.Command{
+		Helptext: cmds.HelpText{
+			Tagline: "Shut the daemon down.",
+			ShortDescription: `
+Stops the daemon once the requests in flight have finished, or the grace
+period has passed.
+`,
+		},
+		Options: []cmds.Option{
+			cmds.StringOption(GraceOpt, "How long requests in flight may take to finish.").WithDefault(DefaultGrace.String()),
+		},
+		Mutating: true,
+		Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+			grace, err := grace(req)
+			if err != nil {
+				return err
+			}
+			logger := cmds.ContextLogger(req.Context, log)
+
+			// the request is in flight until Run returns, so the shutdown
+			// must not be waited for
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), grace)
+				defer cancel()
+				if err := s.Shutdown(ctx); err != nil {
+					logger.Error("error shutting down", "error", err)
+				}
+			}()
+			return cmds.EmitOnce(re, "shutting down")
+		},
+		Type: "",
+	}
+}
+
+func grace(req *cmds.Request) (time.Duration, error) {
+	d, err := time.ParseDuration(req.Options[GraceOpt].(string))
+	if err != nil {
+		return 0, cmds.Errorf(cmds.ErrClient, "invalid grace period: %s", err)
+	}
+	return d, nil
+}
+
+// RequestCanceler is what the active and cancel commands list and cancel the
+// requests of, e.g. the *http.Handler of the http package of this module.
+type RequestCanceler interface {
+	ActiveRequests() []cmds.ActiveRequest
+	CancelRequest(id string) error
+}
+
+// NewActiveCmd returns a command listing the requests in flight of rc, with
+// the IDs the cancel command takes. Only the requests of the identity of the
+// caller are listed, see cmds.Identity.
+func NewActiveCmd(rc RequestCanceler) *cmds.Command {
+	return &cmds.Command{
+		Helptext: cmds.HelpText{
+			Tagline: "List the requests in flight.",
+			ShortDescription: `
+Lists the requests the daemon is running, oldest first, with their IDs.
+`,
+		},
+		Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+			return cmds.EmitOnce(re, ownRequests(req, rc))
+		},
+		Type: []cmds.ActiveRequest{},
+		Encoders: cmds.EncoderMap{
+			cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, active []cmds.ActiveRequest) error {
+				now := time.Now()
+				for _, ar := range active {
+					line := strings.Join(append(append([]string{}, ar.Path...), ar.Arguments...), " ")
+					if _, err := fmt.Fprintf(w, "%s %s %s\n", ar.ID, now.Sub(ar.Start).Round(time.Second), line); err != nil {
+						return err
+					}
+				}
+				return nil
+			}),
+		},
+	}
+}
+
+// NewCancelCmd returns a command canceling a request in flight of rc, e.g. a
+// runaway command, by ID. Callers can only cancel the requests of their own
+// identity, those of others are reported as not found.
+func NewCancelCmd(rc RequestCanceler) *cmds.Command {
+	return &cmds.Command{
+		Helptext: cmds.HelpText{
+			Tagline: "Cancel a request in flight.",
+			ShortDescription: `
+Cancels the request with the given ID, as listed by the active command. The
+command stops once it notices, and its client gets an error.
+`,
+		},
+		Arguments: []cmds.Argument{
+			cmds.StringArg("request-id", true, false, "ID of the request to cancel."),
+		},
+		Mutating: true,
+		Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+			id := req.Arguments[0]
+			if id == cmds.RequestID(req.Context) {
+				return cmds.Errorf(cmds.ErrClient, "a request can't cancel itself")
+			}
+			own := false
+			for _, ar := range ownRequests(req, rc) {
+				own = own || ar.ID == id
+			}
+			if !own {
+				return cmds.Errorf(cmds.ErrNotFound, "no active request with ID %q", id)
+			}
+			if err := rc.CancelRequest(id); err != nil {
+				return err
+			}
+			return cmds.EmitOnce(re, "canceled "+id)
+		},
+		Type: "",
+	}
+}
+
+// ownRequests returns the requests in flight of rc made on behalf of the
+// identity of req.
+func ownRequests(req *cmds.Request, rc RequestCanceler) []cmds.ActiveRequest {
+	id := cmds.Identity(req.Context)
+	active := rc.ActiveRequests()
+	own := make([]cmds.ActiveRequest, 0, len(active))
+	for _, ar := range active {
+		if ar.Identity == id {
+			own = append(own, ar)
+		}
+	}
+	return own
+}
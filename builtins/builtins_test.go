@@ -0,0 +1,229 @@
+package builtins
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+	cmdshttp "github.com/fgeth/fg-ipfs-cmds/http"
+)
+
+func TestBuiltins(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	root := &cmds.Command{
+		Subcommands: map[string]*cmds.Command{
+			"slow": {
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+					close(started)
+					<-release
+					return cmds.EmitOnce(re, "done")
+				},
+				Type: "",
+			},
+		},
+	}
+
+	h := cmdshttp.NewHandler(nil, root, cmdshttp.NewServerConfig())
+	Mount(root, h)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	res, err := http.Post(srv.URL+"/ping", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pong Pong
+	err = json.NewDecoder(res.Body).Decode(&pong)
+	res.Body.Close()
+	if err != nil || pong.Time.IsZero() {
+		t.Fatalf("expected a pong, got %+v (%v)", pong, err)
+	}
+
+	slowDone := make(chan int)
+	go func() {
+		res, err := http.Post(srv.URL+"/slow", "", nil)
+		if err != nil {
+			t.Error(err)
+			close(slowDone)
+			return
+		}
+		res.Body.Close()
+		slowDone <- res.StatusCode
+	}()
+	<-started
+
+	res, err = http.Post(srv.URL+"/shutdown", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("shutdown: expected status 200, got %d", res.StatusCode)
+	}
+
+	// the shutdown waits for the slow request, refusing new ones
+	for {
+		res, err := http.Post(srv.URL+"/ping", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode == http.StatusServiceUnavailable {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case <-h.Done():
+		t.Fatal("shutdown finished while a request was in flight")
+	default:
+	}
+
+	close(release)
+	if status := <-slowDone; status != http.StatusOK {
+		t.Errorf("slow: expected status 200, got %d", status)
+	}
+	select {
+	case <-h.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown didn't finish")
+	}
+}
+
+type closeEnv struct{ closed int }
+
+func (env *closeEnv) Close() error {
+	env.closed++
+	return nil
+}
+
+func TestShutdownIdle(t *testing.T) {
+	env := &closeEnv{}
+	h := cmdshttp.NewHandler(env, &cmds.Command{}, cmdshttp.NewServerConfig())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i := 0; i < 2; i++ {
+		if err := h.Shutdown(ctx); err != nil {
+			t.Fatalf("idle handler: expected no error, got %v", err)
+		}
+	}
+	<-h.Done()
+	if env.closed != 1 {
+		t.Errorf("expected the environment to be closed once, got %d", env.closed)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	started := make(chan struct{})
+	canceled := make(chan struct{})
+	root := &cmds.Command{
+		Subcommands: map[string]*cmds.Command{
+			"stuck": {
+				Arguments: []cmds.Argument{
+					cmds.StringArg("what", true, false, "what is stuck"),
+				},
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+					close(started)
+					<-req.Context.Done()
+					close(canceled)
+					return req.Context.Err()
+				},
+				Type: "",
+			},
+		},
+	}
+
+	cfg := cmdshttp.NewServerConfig()
+	cfg.Identify = func(r *http.Request) string { return r.Header.Get("X-User") }
+	h := cmdshttp.NewHandler(nil, root, cfg)
+	Mount(root, h)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	post := func(user, path string) (*http.Response, error) {
+		httpReq, err := http.NewRequest("POST", srv.URL+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		httpReq.Header.Set("X-User", user)
+		return http.DefaultClient.Do(httpReq)
+	}
+	listStuck := func(user string) string {
+		res, err := post(user, "/active")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var active []cmds.ActiveRequest
+		err = json.NewDecoder(res.Body).Decode(&active)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, ar := range active {
+			if len(ar.Path) == 1 && ar.Path[0] == "stuck" {
+				if len(ar.Arguments) != 1 || ar.Arguments[0] != "forever" {
+					t.Errorf("expected the arguments of the request, got %v", ar.Arguments)
+				}
+				return ar.ID
+			}
+		}
+		return ""
+	}
+	expectNotFound := func(user, id, msg string) {
+		res, err := post(user, "/cancel?arg="+id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var e cmds.Error
+		err = json.NewDecoder(res.Body).Decode(&e)
+		res.Body.Close()
+		if err != nil || e.Code != cmds.ErrNotFound {
+			t.Errorf("expected a not found error for %s, got %+v (%v)", msg, e, err)
+		}
+	}
+
+	stuckDone := make(chan struct{})
+	go func() {
+		defer close(stuckDone)
+		res, err := post("alice", "/stuck?arg=forever")
+		if err == nil {
+			ioutil.ReadAll(res.Body)
+			res.Body.Close()
+		}
+	}()
+	<-started
+
+	id := listStuck("alice")
+	if id == "" {
+		t.Fatal("stuck request not listed")
+	}
+
+	// other identities neither see nor cancel it
+	if id := listStuck("eve"); id != "" {
+		t.Errorf("request of another identity listed")
+	}
+	expectNotFound("eve", id, "the request of another identity")
+
+	res, err := post("alice", "/cancel?arg="+id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("cancel: expected status 200, got %d", res.StatusCode)
+	}
+	select {
+	case <-canceled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("request wasn't canceled")
+	}
+	<-stuckDone
+
+	expectNotFound("alice", id, "a finished request")
+}
@@ -0,0 +1,144 @@
+package cmds
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// canonicalJSON reports whether req asks for canonical JSON, see
+// OptionEncodingCanonical.
+func canonicalJSON(req *Request) bool {
+	canonical, _ := boolOption(req, EncCanonical)
+	return canonical
+}
+
+// canonicalEncoder writes values as canonical JSON: the keys of objects are
+// sorted, numbers are written in their shortest form and strings with only
+// the escapes JSON needs, without any whitespace unless indent is set. Each
+// value is followed by a newline, as with json.Encoder.
+type canonicalEncoder struct {
+	w      io.Writer
+	indent bool
+}
+
+func (e canonicalEncoder) Encode(v interface{}) error {
+	// marshal the value first, so MarshalJSON methods and struct tags apply
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(&buf)
+	dec.UseNumber()
+	var tree interface{}
+	if err := dec.Decode(&tree); err != nil {
+		return err
+	}
+
+	var out bytes.Buffer
+	if err := writeCanonical(&out, tree); err != nil {
+		return err
+	}
+	if e.indent {
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, out.Bytes(), "", "  "); err != nil {
+			return err
+		}
+		out = indented
+	}
+	out.WriteByte('\n')
+	_, err := e.w.Write(out.Bytes())
+	return err
+}
+
+// writeCanonical writes v, a value decoded with json.Decoder.UseNumber, as
+// canonical JSON.
+func writeCanonical(buf *bytes.Buffer, v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	case json.Number:
+		n, err := canonicalNumber(v)
+		if err != nil {
+			return err
+		}
+		buf.WriteString(n)
+	case string:
+		writeCanonicalString(buf, v)
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, elem := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeCanonical(buf, elem); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		buf.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeCanonicalString(buf, k)
+			buf.WriteByte(':')
+			if err := writeCanonical(buf, v[k]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	default:
+		return fmt.Errorf("cmds: unexpected JSON value of type %T", v)
+	}
+	return nil
+}
+
+// canonicalNumber returns the canonical form of n. Integers are kept as they
+// are, so those too large for a float64 don't lose precision, while other
+// numbers are written in their shortest form, e.g. 1.50 as 1.5 and 1e3 as
+// 1000.
+func canonicalNumber(n json.Number) (string, error) {
+	s := n.String()
+	if !strings.ContainsAny(s, ".eE") {
+		if s == "-0" {
+			return "0", nil
+		}
+		return s, nil
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return "", err
+	}
+	if f == 0 {
+		// no negative zero
+		return "0", nil
+	}
+	data, err := json.Marshal(f)
+	return string(data), err
+}
+
+func writeCanonicalString(buf *bytes.Buffer, s string) {
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	// strings always encode
+	enc.Encode(s)
+	// drop the newline of the encoder
+	buf.Truncate(buf.Len() - 1)
+}
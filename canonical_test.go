@@ -0,0 +1,88 @@
+package cmds
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestCanonicalJSON(t *testing.T) {
+	type entry struct {
+		Z string
+		A float64
+		M map[string]interface{}
+		R json.RawMessage
+		U uint64
+	}
+	v := &entry{
+		Z: "<a&b>",
+		A: 1.50,
+		M: map[string]interface{}{"b": 1, "a": math.Copysign(0, -1)},
+		R: json.RawMessage(`{ "y": 1.0, "x": 2E2, "n": null }`),
+		U: math.MaxUint64,
+	}
+	cmd := &Command{
+		Options: []Option{OptionEncodingPretty, OptionEncodingCanonical},
+		Type:    entry{},
+	}
+
+	const canonical = `{"A":1.5,"M":{"a":0,"b":1},"R":{"n":null,"x":200,"y":1},"U":18446744073709551615,"Z":"<a&b>"}` + "\n"
+	for _, tc := range []struct {
+		opts    OptMap
+		encType EncodingType
+		out     string
+	}{
+		{opts: OptMap{EncCanonical: true}, encType: JSON, out: canonical},
+		// as sent by HTTP clients to commands that don't declare it
+		{opts: OptMap{EncCanonical: "true"}, encType: JSON, out: canonical},
+		{opts: OptMap{EncCanonical: true}, encType: NDJSON, out: canonical},
+		{
+			opts:    OptMap{EncCanonical: true, EncPretty: true},
+			encType: JSON,
+			out:     "{\n  \"A\": 1.5,\n  \"M\": {\n    \"a\": 0,\n    \"b\": 1\n  },\n  \"R\": {\n    \"n\": null,\n    \"x\": 200,\n    \"y\": 1\n  },\n  \"U\": 18446744073709551615,\n  \"Z\": \"<a&b>\"\n}\n",
+		},
+	} {
+		req, err := NewRequest(context.Background(), nil, nil, nil, nil, cmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Options = tc.opts
+
+		var buf bytes.Buffer
+		re, err := NewWriterResponseEmitter(writecloser{&buf, nopCloser{}}, req, StreamWithEncoding(tc.encType))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := re.Emit(v); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != tc.out {
+			t.Errorf("%v, %s: expected %q, got %q", tc.opts, tc.encType, tc.out, buf.String())
+		}
+	}
+}
+
+func TestCanonicalNumber(t *testing.T) {
+	for in, exp := range map[string]string{
+		"0":      "0",
+		"-0":     "0",
+		"-0.0":   "0",
+		"42":     "42",
+		"1.50":   "1.5",
+		"1e3":    "1000",
+		"1E-7":   "1e-7",
+		"1.0e21": "1e+21",
+		"0.1":    "0.1",
+		// integers keep their precision
+		"123456789012345678901234567890": "123456789012345678901234567890",
+	} {
+		out, err := canonicalNumber(json.Number(in))
+		if err != nil {
+			t.Errorf("%s: %s", in, err)
+		} else if out != exp {
+			t.Errorf("%s: expected %s, got %s", in, exp, out)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+//go:build !cmds_minimal
+// +build !cmds_minimal
+
+package cmds
+
+import (
+	"io"
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// cborDecMode decodes CBOR maps of unknown types like encoding/json decodes
+// JSON objects, into map[string]interface{}.
+var cborDecMode, _ = cbor.DecOptions{
+	DefaultMapType: reflect.TypeOf(map[string]interface{}(nil)),
+}.DecMode()
+
+func newCBOREncoder(req *Request) func(io.Writer) Encoder {
+	return func(w io.Writer) Encoder { return cbor.NewEncoder(w) }
+}
+
+func newCBORDecoder(r io.Reader) Decoder {
+	return cborDecMode.NewDecoder(r)
+}
+
+// UnmarshalCBOR decodes a value of a CBOR stream. Unlike JSON streams, CBOR
+// streams only carry values: errors are sent in the trailers of HTTP
+// responses, and progress reports and warnings are left out.
+func (m *MaybeError) UnmarshalCBOR(data []byte) error {
+	if m.Value == nil {
+		return cborDecMode.Unmarshal(data, &m.Value)
+	}
+
+	// make sure we are working with a pointer here
+	v := reflect.ValueOf(m.Value)
+	if v.Kind() != reflect.Ptr {
+		m.Value = reflect.New(v.Type()).Interface()
+	}
+	return cborDecMode.Unmarshal(data, m.Value)
+}
@@ -0,0 +1,35 @@
+//go:build cmds_minimal
+// +build cmds_minimal
+
+package cmds
+
+import (
+	"errors"
+	"io"
+)
+
+// errNoCBOR is returned by the CBOR encoders and decoders of builds with the
+// cmds_minimal tag, which don't depend on a CBOR implementation.
+var errNoCBOR = errors.New("cbor is not available in builds with the cmds_minimal tag")
+
+func newCBOREncoder(req *Request) func(io.Writer) Encoder {
+	return func(w io.Writer) Encoder { return unavailableCoder{errNoCBOR} }
+}
+
+func newCBORDecoder(r io.Reader) Decoder {
+	return unavailableCoder{errNoCBOR}
+}
+
+// unavailableCoder stands in for the encoders and decoders left out of
+// builds with the cmds_minimal tag.
+type unavailableCoder struct {
+	err error
+}
+
+func (c unavailableCoder) Encode(v interface{}) error {
+	return c.err
+}
+
+func (c unavailableCoder) Decode(v interface{}) error {
+	return c.err
+}
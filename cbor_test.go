@@ -0,0 +1,76 @@
+//go:build !cmds_minimal
+// +build !cmds_minimal
+
+package cmds
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestCBOR(t *testing.T) {
+	type entry struct {
+		Name string
+		Size int
+	}
+
+	cmd := &Command{
+		Run: func(req *Request, re ResponseEmitter, env Environment) error {
+			if err := re.Emit(&entry{Name: "a", Size: 1}); err != nil {
+				return err
+			}
+			// progress reports are left out of CBOR streams
+			if err := re.Emit(&Progress{ItemsDone: 1}); err != nil {
+				return err
+			}
+			return re.Emit(entry{Name: "b", Size: 2})
+		},
+		Type: entry{},
+	}
+
+	req, err := NewRequest(context.Background(), nil, nil, nil, nil, cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	re, err := NewWriterResponseEmitter(writecloser{&buf, nopCloser{}}, req, StreamWithEncoding(CBOR))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewExecutor(cmd).Execute(req, re, nil); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	res, err := NewReaderResponse(bytes.NewReader(data), req, StreamWithEncoding(CBOR))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var values []interface{}
+	for {
+		v, err := res.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		values = append(values, v)
+	}
+	if exp := []interface{}{&entry{Name: "a", Size: 1}, &entry{Name: "b", Size: 2}}; !reflect.DeepEqual(values, exp) {
+		t.Errorf("expected values %v, got %v", exp, values)
+	}
+
+	// values of commands without a type are decoded like JSON objects
+	var m MaybeError
+	if err := newCBORDecoder(bytes.NewReader(data)).Decode(&m); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := m.Get(); !reflect.DeepEqual(v, map[string]interface{}{"Name": "a", "Size": uint64(1)}) {
+		t.Errorf("unexpected untyped value %#v", v)
+	}
+}
@@ -16,7 +16,7 @@ func NewChanResponsePair(req *Request) (ResponseEmitter, Response) {
 
 	re := (*chanResponseEmitter)(r)
 
-	return re, r
+	return re, DecorateResponse(r)
 }
 
 // chanStream is the struct of both the Response and ResponseEmitter.
@@ -53,6 +53,12 @@ type chanStream struct {
 	// length is the length of the response.
 	// It can be set by calling SetLength, but only before the first call to Emit, Close or CloseWithError.
 	length uint64
+
+	// page is the page info set with SetPage. Like err, it is written under
+	// lock wl and only read once the stream is closed.
+	page *PageInfo
+	// status is the exit status set with SetStatus, like page.
+	status int
 }
 
 type chanResponse chanStream
@@ -78,6 +84,20 @@ func (r *chanResponse) Error() *Error {
 	}
 }
 
+// Trailer returns the page info and exit status set on the emitter, if the
+// stream is closed.
+func (r *chanResponse) Trailer() *Trailer {
+	select {
+	case <-r.closeCh:
+		if r.page == nil && r.status == 0 {
+			return nil
+		}
+		return &Trailer{Page: r.page, ExitStatus: r.status}
+	default:
+		return nil
+	}
+}
+
 func (r *chanResponse) Length() uint64 {
 	<-r.waitLen
 
@@ -181,6 +201,24 @@ func (re *chanResponseEmitter) SetLength(l uint64) {
 	}
 }
 
+func (re *chanResponseEmitter) SetPage(p PageInfo) {
+	re.wl.Lock()
+	defer re.wl.Unlock()
+
+	if !re.closed {
+		re.page = &p
+	}
+}
+
+func (re *chanResponseEmitter) SetStatus(code int) {
+	re.wl.Lock()
+	defer re.wl.Unlock()
+
+	if !re.closed {
+		re.status = code
+	}
+}
+
 func (re *chanResponseEmitter) CloseWithError(err error) error {
 	re.wl.Lock()
 	defer re.wl.Unlock()
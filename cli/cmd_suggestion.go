@@ -1,7 +1,6 @@
 package cli
 
 import (
-	"fmt"
 	"sort"
 	"strings"
 
@@ -9,6 +8,48 @@ import (
 	levenshtein "github.com/texttheater/golang-levenshtein/levenshtein"
 )
 
+// SuggestFunc returns the names of the subcommands of root a user may have
+// meant by the unknown command name, best first. Applications set their own
+// with SetSuggestions.
+type SuggestFunc func(name string, root *cmds.Command) []string
+
+// Levenshtein suggests the subcommands whose names contain the unknown
+// command, or else those within MaxDistance of it, by the Levenshtein
+// distance with the given costs.
+type Levenshtein struct {
+	// MaxDistance is the largest distance of the names suggested.
+	MaxDistance int
+	// InsCost, DelCost and SubCost are the costs of inserting, deleting
+	// and substituting characters of the unknown command.
+	InsCost, DelCost, SubCost int
+	// MaxSuggestions limits the number of names suggested. Zero means no
+	// limit.
+	MaxSuggestions int
+}
+
+// DefaultSuggestions makes the suggestions of root commands that don't set
+// their own with SetSuggestions.
+var DefaultSuggestions = Levenshtein{MaxDistance: 3, InsCost: 1, DelCost: 3, SubCost: 2}
+
+type suggestKey struct{}
+
+// SetSuggestions sets the function suggesting commands to users who mistype
+// one. It is set in the Extra of the root command; nil turns suggestions
+// off, e.g. for deterministic errors in embedded uses.
+func SetSuggestions(e *cmds.Extra, suggest SuggestFunc) *cmds.Extra {
+	return e.SetValue(suggestKey{}, suggest)
+}
+
+// suggestFunc returns the suggestion function of root, and nil if it turned
+// them off.
+func suggestFunc(root *cmds.Command) SuggestFunc {
+	if v, ok := root.Extra.GetValue(suggestKey{}); ok {
+		suggest, _ := v.(SuggestFunc)
+		return suggest
+	}
+	return DefaultSuggestions.Suggest
+}
+
 // Make a custom slice that can be sorted by its levenshtein value
 type suggestionSlice []*suggestion
 
@@ -26,24 +67,26 @@ func (s suggestionSlice) Swap(i, j int) {
 }
 
 func (s suggestionSlice) Less(i, j int) bool {
-	return s[i].levenshtein < s[j].levenshtein
+	if s[i].levenshtein != s[j].levenshtein {
+		return s[i].levenshtein < s[j].levenshtein
+	}
+	return s[i].cmd < s[j].cmd
 }
 
-func suggestUnknownCmd(args []string, root *cmds.Command) []string {
+// Suggest is the SuggestFunc of l.
+func (l Levenshtein) Suggest(arg string, root *cmds.Command) []string {
 	if root == nil {
 		return nil
 	}
 
-	arg := args[0]
 	var suggestions []string
 	sortableSuggestions := make(suggestionSlice, 0)
 	var sFinal []string
-	const MinLevenshtein = 3
 
 	var options levenshtein.Options = levenshtein.Options{
-		InsCost: 1,
-		DelCost: 3,
-		SubCost: 2,
+		InsCost: l.InsCost,
+		DelCost: l.DelCost,
+		SubCost: l.SubCost,
 		Matches: func(sourceCharacter rune, targetCharacter rune) bool {
 			return sourceCharacter == targetCharacter
 		},
@@ -58,12 +101,13 @@ func suggestUnknownCmd(args []string, root *cmds.Command) []string {
 
 	// If the string compare returns a match, return
 	if len(suggestions) > 0 {
-		return suggestions
+		sort.Strings(suggestions)
+		return l.limit(suggestions)
 	}
 
 	for name := range root.Subcommands {
 		lev := levenshtein.DistanceForStrings([]rune(arg), []rune(name), options)
-		if lev <= MinLevenshtein {
+		if lev <= l.MaxDistance {
 			sortableSuggestions = append(sortableSuggestions, &suggestion{name, lev})
 		}
 	}
@@ -72,24 +116,34 @@ func suggestUnknownCmd(args []string, root *cmds.Command) []string {
 	for _, j := range sortableSuggestions {
 		sFinal = append(sFinal, j.cmd)
 	}
-	return sFinal
+	return l.limit(sFinal)
 }
 
-func printSuggestions(inputs []string, root *cmds.Command) (err error) {
+// limit returns the first MaxSuggestions of suggestions.
+func (l Levenshtein) limit(suggestions []string) []string {
+	if l.MaxSuggestions > 0 && len(suggestions) > l.MaxSuggestions {
+		return suggestions[:l.MaxSuggestions]
+	}
+	return suggestions
+}
 
-	suggestions := suggestUnknownCmd(inputs, root)
+func printSuggestions(inputs []string, root *cmds.Command) (err error) {
+	var suggestions []string
+	if suggest := suggestFunc(root); suggest != nil {
+		suggestions = suggest(inputs[0], root)
+	}
 
 	if len(suggestions) > 1 {
 		//lint:ignore ST1005 user facing error
-		err = fmt.Errorf("Unknown Command \"%s\"\n\nDid you mean any of these?\n\n\t%s", inputs[0], strings.Join(suggestions, "\n\t"))
+		err = cmds.Errorf(cmds.ErrNotFound, "Unknown Command \"%s\"\n\nDid you mean any of these?\n\n\t%s", inputs[0], strings.Join(suggestions, "\n\t"))
 
 	} else if len(suggestions) > 0 {
 		//lint:ignore ST1005 user facing error
-		err = fmt.Errorf("Unknown Command \"%s\"\n\nDid you mean this?\n\n\t%s", inputs[0], suggestions[0])
+		err = cmds.Errorf(cmds.ErrNotFound, "Unknown Command \"%s\"\n\nDid you mean this?\n\n\t%s", inputs[0], suggestions[0])
 
 	} else {
 		//lint:ignore ST1005 user facing error
-		err = fmt.Errorf("Unknown Command \"%s\"\n", inputs[0])
+		err = cmds.Errorf(cmds.ErrNotFound, "Unknown Command \"%s\"\n", inputs[0])
 	}
 	return
 }
@@ -0,0 +1,64 @@
+package cli
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+func TestSuggestions(t *testing.T) {
+	run := func(*cmds.Request, cmds.ResponseEmitter, cmds.Environment) error { return nil }
+	newRoot := func(extra *cmds.Extra) *cmds.Command {
+		return &cmds.Command{
+			Subcommands: map[string]*cmds.Command{
+				"add":  {Run: run},
+				"cat":  {Run: run},
+				"car":  {Run: run},
+				"stat": {Run: run},
+			},
+			Extra: extra,
+		}
+	}
+
+	for _, tc := range []struct {
+		name  string
+		extra *cmds.Extra
+		arg   string
+		exp   string
+	}{
+		{name: "contained", arg: "addd", exp: "Did you mean this?\n\n\tadd"},
+		{name: "distance", arg: "ca", exp: "Did you mean any of these?\n\n\tcar\n\tcat"},
+		{
+			name:  "limit",
+			extra: SetSuggestions(nil, Levenshtein{MaxDistance: 3, InsCost: 1, DelCost: 3, SubCost: 2, MaxSuggestions: 1}.Suggest),
+			arg:   "ca",
+			exp:   "Did you mean this?\n\n\tcar",
+		},
+		{
+			name:  "distance too large",
+			extra: SetSuggestions(nil, Levenshtein{MaxDistance: 0, InsCost: 1, DelCost: 3, SubCost: 2}.Suggest),
+			arg:   "ca",
+			exp:   "Unknown Command \"ca\"\n",
+		},
+		{name: "disabled", extra: SetSuggestions(nil, nil), arg: "addd", exp: "Unknown Command \"addd\"\n"},
+		{
+			name: "custom",
+			extra: SetSuggestions(nil, func(name string, root *cmds.Command) []string {
+				return []string{"stat"}
+			}),
+			arg: "xyz",
+			exp: "Did you mean this?\n\n\tstat",
+		},
+	} {
+		_, err := Parse(context.Background(), []string{tc.arg}, nil, newRoot(tc.extra))
+		if err == nil {
+			t.Errorf("%s: expected an error", tc.name)
+			continue
+		}
+		if !strings.HasSuffix(err.Error(), tc.exp) {
+			t.Errorf("%s: expected the error to end with %q, got %q", tc.name, tc.exp, err)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package cli
+
+import (
+	"reflect"
+
+	"github.com/fgeth/fg-ipfs-cmds"
+)
+
+// MaxCollect is the maximum number of values buffered for commands run with
+// the collect option. Commands emitting more fail instead of exhausting the
+// memory.
+var MaxCollect = 1 << 16
+
+// collecting reports whether the output of req should be collected, i.e. if
+// it asks for it and the command supports it.
+func collecting(req *cmds.Request) bool {
+	if collect, _ := req.Options[cmds.CollectOpt].(bool); !collect || req.Root == nil {
+		return false
+	}
+	opts, err := req.Root.GetOptions(req.Path)
+	return err == nil && opts[cmds.CollectOpt] != nil
+}
+
+// collectSlice returns values as a slice of their type. If their types
+// differ, it returns them as they are.
+func collectSlice(req *cmds.Request, values []interface{}) interface{} {
+	var typ reflect.Type
+	switch {
+	case len(values) > 0:
+		typ = reflect.TypeOf(values[0])
+	case req.Command != nil && req.Command.Type != nil:
+		typ = reflect.TypeOf(req.Command.Type)
+	}
+	if typ == nil {
+		return values
+	}
+
+	slice := reflect.MakeSlice(reflect.SliceOf(typ), 0, len(values))
+	for _, v := range values {
+		if reflect.TypeOf(v) != typ {
+			return values
+		}
+		slice = reflect.Append(slice, reflect.ValueOf(v))
+	}
+	return slice.Interface()
+}
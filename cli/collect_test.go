@@ -0,0 +1,106 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+func TestCollect(t *testing.T) {
+	total := cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, sizes []int) error {
+		var sum int
+		for _, s := range sizes {
+			sum += s
+		}
+		_, err := fmt.Fprintf(w, "%d values, total %d\n", len(sizes), sum)
+		return err
+	})
+
+	root := &cmds.Command{
+		Subcommands: map[string]*cmds.Command{
+			"sizes": {
+				Options:  []cmds.Option{cmds.OptionCollect},
+				Encoders: cmds.EncoderMap{cmds.TextNewline: total},
+				Type:     0,
+			},
+			"plain": {
+				Type: "",
+			},
+		},
+	}
+
+	type testcase struct {
+		path    string
+		collect bool
+		emit    []interface{}
+		stdout  string
+		err     bool
+	}
+
+	tcs := []testcase{
+		{path: "sizes", collect: true, emit: []interface{}{1, 2, 3}, stdout: "3 values, total 6\n"},
+		{path: "sizes", collect: true, stdout: "0 values, total 0\n"},
+		{path: "sizes", collect: true, emit: []interface{}{cmds.Single{Value: 5}}, stdout: "1 values, total 5\n"},
+		// commands that don't opt in stream as usual
+		{path: "plain", collect: true, emit: []interface{}{"a", "b"}, stdout: "a\nb\n"},
+		{path: "sizes", collect: true, emit: []interface{}{strings.NewReader("data")}, err: true},
+	}
+
+	for _, tc := range tcs {
+		req, err := cmds.NewRequest(context.Background(), []string{tc.path}, cmds.OptMap{cmds.CollectOpt: tc.collect}, nil, nil, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var stdout, stderr bytes.Buffer
+		re, err := NewResponseEmitter(&stdout, &stderr, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var emitErr error
+		for _, v := range tc.emit {
+			if emitErr = re.Emit(v); emitErr != nil {
+				break
+			}
+		}
+		re.Close()
+
+		if tc.err {
+			if emitErr == nil {
+				t.Errorf("%s %v: expected error", tc.path, tc.emit)
+			}
+			continue
+		}
+		if emitErr != nil {
+			t.Errorf("%s %v: unexpected error: %s", tc.path, tc.emit, emitErr)
+		}
+		if out := stdout.String(); out != tc.stdout {
+			t.Errorf("%s %v: expected output %q, got %q", tc.path, tc.emit, tc.stdout, out)
+		}
+	}
+
+	// the number of values buffered is limited
+	defer func(max int) { MaxCollect = max }(MaxCollect)
+	MaxCollect = 2
+
+	req, err := cmds.NewRequest(context.Background(), []string{"sizes"}, cmds.OptMap{cmds.CollectOpt: true}, nil, nil, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	re, err := NewResponseEmitter(io.Discard, io.Discard, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		err = re.Emit(i)
+	}
+	if e, ok := err.(cmds.Error); !ok || e.Code != cmds.ErrClient {
+		t.Errorf("expected client error past the limit, got %v", err)
+	}
+}
@@ -0,0 +1,160 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+// YAML is the encoding of help written as YAML, see StructuredHelp.
+const YAML cmds.EncodingType = "yaml"
+
+// StructuredHelp writes the machine-readable description of the command at
+// path, see cmds.DescribeCommand, to out in encType, JSON or YAML, so tools
+// can present it without parsing the help text. HandleHelp uses it when help
+// is requested with one of these encodings.
+func StructuredHelp(root *cmds.Command, path []string, encType cmds.EncodingType, out io.Writer) error {
+	d, err := cmds.DescribeCommand(root, path)
+	if err != nil {
+		return err
+	}
+
+	switch encType {
+	case cmds.JSON:
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(d)
+	case YAML:
+		return writeYAML(out, d)
+	default:
+		return cmds.Errorf(cmds.ErrClient, "invalid help encoding: %s", encType)
+	}
+}
+
+// structuredHelp returns the encoding help is requested in by req, if it is
+// one StructuredHelp writes.
+func structuredHelp(req *cmds.Request) (cmds.EncodingType, bool) {
+	enc, _ := req.Options[cmds.EncLong].(string)
+	switch encType := cmds.EncodingType(enc); encType {
+	case cmds.JSON, YAML:
+		return encType, true
+	default:
+		return "", false
+	}
+}
+
+// yamlNode is a value decoded from JSON, keeping the order of object keys.
+type yamlNode struct {
+	scalar string
+	isMap  bool
+	keys   []string
+	values []*yamlNode
+	isList bool
+}
+
+// writeYAML writes v, as it is encoded in JSON, as a YAML document.
+func writeYAML(w io.Writer, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	n, err := decodeYAMLNode(dec)
+	if err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	if n.inline() {
+		b.WriteString(n.inlineValue() + "\n")
+	} else {
+		n.write(&b, 0)
+	}
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+func decodeYAMLNode(dec *json.Decoder) (*yamlNode, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tok := tok.(type) {
+	case json.Delim:
+		n := &yamlNode{isMap: tok == '{', isList: tok == '['}
+		for dec.More() {
+			if n.isMap {
+				key, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				n.keys = append(n.keys, fmt.Sprint(key))
+			}
+			v, err := decodeYAMLNode(dec)
+			if err != nil {
+				return nil, err
+			}
+			n.values = append(n.values, v)
+		}
+		// the closing delimiter
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		return n, nil
+	case string:
+		// JSON strings are valid YAML double-quoted strings
+		s, err := json.Marshal(tok)
+		return &yamlNode{scalar: string(s)}, err
+	case nil:
+		return &yamlNode{scalar: "null"}, nil
+	default:
+		return &yamlNode{scalar: fmt.Sprint(tok)}, nil
+	}
+}
+
+// inline reports whether n is written on the line of its key.
+func (n *yamlNode) inline() bool {
+	return len(n.values) == 0
+}
+
+func (n *yamlNode) inlineValue() string {
+	switch {
+	case n.isMap:
+		return "{}"
+	case n.isList:
+		return "[]"
+	default:
+		return n.scalar
+	}
+}
+
+// write writes the map or list n with the given indentation.
+func (n *yamlNode) write(b *strings.Builder, indent int) {
+	pad := strings.Repeat(" ", indent)
+	for i, v := range n.values {
+		if n.isMap {
+			b.WriteString(pad + n.keys[i] + ":")
+		} else {
+			b.WriteString(pad + "-")
+		}
+
+		switch {
+		case v.inline():
+			b.WriteString(" " + v.inlineValue() + "\n")
+		case n.isMap:
+			b.WriteString("\n")
+			v.write(b, indent+2)
+		default:
+			// the first line of the item follows the dash
+			var item strings.Builder
+			v.write(&item, indent+2)
+			b.WriteString(" " + item.String()[indent+2:])
+		}
+	}
+}
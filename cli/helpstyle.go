@@ -0,0 +1,88 @@
+package cli
+
+import (
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+// HelpWidthEnv and HelpPlainEnv are the environment variables setting the
+// width of help text and selecting plain help, like cmds.OptionHelpWidth and
+// cmds.OptionHelpPlain. The options take precedence.
+const (
+	HelpWidthEnv = "CMDS_HELP_WIDTH"
+	HelpPlainEnv = "CMDS_HELP_PLAIN"
+)
+
+// helpStyle is how help text is written.
+type helpStyle struct {
+	// width overrides the width of the terminal if it's positive.
+	width int
+	// plain turns off terminal detection and strips escape sequences.
+	plain bool
+}
+
+// helpStyleOf returns the style of the help for req, which may be nil.
+func helpStyleOf(req *cmds.Request) helpStyle {
+	var s helpStyle
+	if w, err := strconv.Atoi(os.Getenv(HelpWidthEnv)); err == nil {
+		s.width = w
+	}
+	if plain, err := strconv.ParseBool(os.Getenv(HelpPlainEnv)); err == nil {
+		s.plain = plain
+	}
+
+	if req != nil {
+		if w, ok := req.Options[cmds.HelpWidthOpt].(int); ok {
+			s.width = w
+		}
+		if plain, ok := req.Options[cmds.HelpPlainOpt].(bool); ok {
+			s.plain = plain
+		}
+	}
+	return s
+}
+
+// styledWriter is the output of help text written in a style.
+type styledWriter struct {
+	io.Writer
+	style helpStyle
+}
+
+// withHelpStyle returns out writing help in the style for req, which may be
+// nil, unless it already does.
+func withHelpStyle(req *cmds.Request, out io.Writer) io.Writer {
+	if _, ok := out.(*styledWriter); ok {
+		return out
+	}
+	return &styledWriter{Writer: out, style: helpStyleOf(req)}
+}
+
+// ansiEscape matches ANSI escape sequences, e.g. colors.
+var ansiEscape = regexp.MustCompile("\x1b\\[[0-9;?]*[ -/]*[@-~]")
+
+func (w *styledWriter) Write(p []byte) (int, error) {
+	if !w.style.plain {
+		return w.Writer.Write(p)
+	}
+	if _, err := w.Writer.Write(ansiEscape.ReplaceAll(p, nil)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// width returns the width help is wrapped at, and false if it is that of the
+// terminal.
+func (w *styledWriter) width() (int, bool) {
+	switch {
+	case w.style.width > 0:
+		return w.style.width, true
+	case w.style.plain:
+		return defaultTerminalWidth, true
+	default:
+		return 0, false
+	}
+}
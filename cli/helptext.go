@@ -37,6 +37,8 @@ type helpFields struct {
 	Synopsis    string
 	Subcommands string
 	Description string
+	Examples    string
+	DocsURL     string
 	MoreHelp    bool
 }
 
@@ -57,6 +59,7 @@ func (f *helpFields) TrimNewlines() {
 	f.Synopsis = strings.Trim(f.Synopsis, "\n")
 	f.Subcommands = strings.Trim(f.Subcommands, "\n")
 	f.Description = strings.Trim(f.Description, "\n")
+	f.Examples = strings.Trim(f.Examples, "\n")
 }
 
 // Indent adds whitespace the lines of fields.
@@ -70,7 +73,8 @@ func (f *helpFields) IndentAll() {
 
 	f.Usage = indent(f.Usage)
 	f.Arguments = indent(f.Arguments)
-	f.Options = indent(f.Options)
+	// the blank lines between option groups stay blank
+	f.Options = strings.Replace(indent(f.Options), "\n"+indentStr+"\n", "\n\n", -1)
 	f.Synopsis = indent(f.Synopsis)
 	f.Subcommands = indent(f.Subcommands)
 	f.Description = indent(f.Description)
@@ -94,11 +98,17 @@ const longHelpFormat = `USAGE
 
 {{.Description}}
 
+{{end}}{{if .Examples}}EXAMPLES
+
+{{.Examples}}
+
 {{end}}{{if .Subcommands}}SUBCOMMANDS
 {{.Subcommands}}
 
 {{.Indent}}For more information about each command, use:
 {{.Indent}}'{{.Path}} <subcmd> --help'
+{{end}}{{if .DocsURL}}
+{{.Indent}}see {{.DocsURL}} for more
 {{end}}
 `
 const shortHelpFormat = `USAGE
@@ -113,6 +123,8 @@ SUBCOMMANDS
 {{end}}{{if .MoreHelp}}
 {{.Indent}}For more information about each command, use:
 {{.Indent}}'{{.Path}} <subcmd> --help'
+{{end}}{{if .DocsURL}}
+{{.Indent}}see {{.DocsURL}} for more
 {{end}}
 `
 
@@ -120,18 +132,28 @@ var longHelpTemplate *template.Template
 var shortHelpTemplate *template.Template
 
 func getTerminalWidth(out io.Writer) int {
-	file, ok := out.(*os.File)
-	if ok {
-		if terminal.IsTerminal(int(file.Fd())) {
-			width, _, err := terminal.GetSize(int(file.Fd()))
-			if err == nil {
-				return width
-			}
+	if sw, ok := out.(*styledWriter); ok {
+		if width, ok := sw.width(); ok {
+			return width
 		}
+		out = sw.Writer
+	}
+	if width, ok := terminalWidth(out); ok {
+		return width
 	}
 	return defaultTerminalWidth
 }
 
+// terminalWidth returns the width of the terminal w is, if it is one.
+func terminalWidth(w io.Writer) (int, bool) {
+	file, ok := w.(*os.File)
+	if !ok || !terminal.IsTerminal(int(file.Fd())) {
+		return 0, false
+	}
+	width, _, err := terminal.GetSize(int(file.Fd()))
+	return width, err == nil
+}
+
 func init() {
 	longHelpTemplate = template.Must(template.New("longHelp").Parse(longHelpFormat))
 	shortHelpTemplate = template.Must(template.New("shortHelp").Parse(shortHelpFormat))
@@ -142,10 +164,17 @@ func init() {
 var ErrNoHelpRequested = errors.New("no help requested")
 
 // HandleHelp writes help to a writer for the given request's command.
+// Help requested with the JSON or YAML encoding is written with
+// StructuredHelp.
 func HandleHelp(appName string, req *cmds.Request, out io.Writer) error {
 	long, _ := req.Options[cmds.OptLongHelp].(bool)
 	short, _ := req.Options[cmds.OptShortHelp].(bool)
 
+	if encType, ok := structuredHelp(req); ok && (long || short) {
+		return StructuredHelp(req.Root, req.Path, encType, out)
+	}
+	out = withHelpStyle(req, out)
+
 	switch {
 	case long:
 		return LongHelp(appName, req.Root, req.Path, out)
@@ -156,8 +185,11 @@ func HandleHelp(appName string, req *cmds.Request, out io.Writer) error {
 	}
 }
 
-// LongHelp writes a formatted CLI helptext string to a Writer for the given command
+// LongHelp writes a formatted CLI helptext string to a Writer for the given command.
+// It is wrapped at the width of the terminal unless the environment sets
+// another, see HelpWidthEnv and HelpPlainEnv.
 func LongHelp(rootName string, root *cmds.Command, path []string, out io.Writer) error {
+	out = withHelpStyle(nil, out)
 	cmd, err := root.Get(path)
 	if err != nil {
 		return err
@@ -178,6 +210,7 @@ func LongHelp(rootName string, root *cmds.Command, path []string, out io.Writer)
 		Subcommands: cmd.Helptext.Subcommands,
 		Description: cmd.Helptext.ShortDescription,
 		Usage:       cmd.Helptext.Usage,
+		DocsURL:     cmd.DocsURL,
 		MoreHelp:    (cmd != root),
 	}
 
@@ -205,6 +238,7 @@ func LongHelp(rootName string, root *cmds.Command, path []string, out io.Writer)
 	if len(fields.Synopsis) == 0 {
 		fields.Synopsis = generateSynopsis(width, cmd, pathStr)
 	}
+	fields.Examples = exampleText(cmd)
 
 	// trim the extra newlines (see TrimNewlines doc)
 	fields.TrimNewlines()
@@ -215,8 +249,10 @@ func LongHelp(rootName string, root *cmds.Command, path []string, out io.Writer)
 	return longHelpTemplate.Execute(out, fields)
 }
 
-// ShortHelp writes a formatted CLI helptext string to a Writer for the given command
+// ShortHelp writes a formatted CLI helptext string to a Writer for the given command.
+// It is wrapped like LongHelp.
 func ShortHelp(rootName string, root *cmds.Command, path []string, out io.Writer) error {
+	out = withHelpStyle(nil, out)
 	cmd, err := root.Get(path)
 	if err != nil {
 		return err
@@ -239,6 +275,7 @@ func ShortHelp(rootName string, root *cmds.Command, path []string, out io.Writer
 		Synopsis:    cmd.Helptext.Synopsis,
 		Description: cmd.Helptext.ShortDescription,
 		Subcommands: cmd.Helptext.Subcommands,
+		DocsURL:     cmd.DocsURL,
 		MoreHelp:    (cmd != root),
 	}
 
@@ -396,7 +433,11 @@ func optionText(width int, cmd ...*cmds.Command) []string {
 
 	// add option types to output
 	for i, opt := range options {
-		lines[i] += "  " + fmt.Sprintf("%v", opt.Type())
+		typ := fmt.Sprintf("%v", opt.Type())
+		if kind := cmds.OptionKindOf(opt); kind != nil {
+			typ = kind.Name
+		}
+		lines[i] += "  " + typ
 	}
 	lines = align(lines)
 
@@ -406,7 +447,35 @@ func optionText(width int, cmd ...*cmds.Command) []string {
 		lines[i] = appendWrapped(lines[i], opt.Description(), width)
 	}
 
-	return lines
+	return groupOptionText(options, lines)
+}
+
+// groupOptionText lists the lines of the options that have a group, see
+// cmds.GroupedOption, under a heading per group, after those that don't.
+// Columns stay aligned across groups.
+func groupOptionText(options []cmds.Option, lines []string) []string {
+	var groups []string
+	byGroup := make(map[string][]string)
+	for i, opt := range options {
+		g := cmds.OptionGroup(opt)
+		if _, ok := byGroup[g]; !ok && g != "" {
+			groups = append(groups, g)
+		}
+		byGroup[g] = append(byGroup[g], lines[i])
+	}
+	if len(groups) == 0 {
+		return lines
+	}
+
+	out := byGroup[""]
+	for _, g := range groups {
+		if len(out) > 0 {
+			out = append(out, "")
+		}
+		out = append(out, g+":")
+		out = append(out, byGroup[g]...)
+	}
+	return out
 }
 
 func subcommandText(width int, cmd *cmds.Command, rootName string, path []string) []string {
@@ -503,6 +572,21 @@ func align(lines []string) []string {
 	return lines
 }
 
+// exampleText lists the examples of cmd, each command line below its
+// description. It is indented already, leaving the blank lines between the
+// examples empty.
+func exampleText(cmd *cmds.Command) string {
+	examples := make([]string, 0, len(cmd.Helptext.Examples))
+	for _, ex := range cmd.Helptext.Examples {
+		text := indentString("$ "+ex.CmdLine, indentStr+indentStr)
+		if desc := strings.Trim(ex.Description, whitespace); desc != "" {
+			text = indentString(desc, indentStr) + "\n\n" + text
+		}
+		examples = append(examples, text)
+	}
+	return strings.Join(examples, "\n\n")
+}
+
 func indentString(line string, prefix string) string {
 	return prefix + strings.Replace(line, "\n", "\n"+prefix, -1)
 }
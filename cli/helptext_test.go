@@ -1,6 +1,9 @@
 package cli
 
 import (
+	"context"
+	"encoding/json"
+	"io"
 	"strings"
 	"testing"
 
@@ -48,3 +51,228 @@ func TestSynopsisGenerator(t *testing.T) {
 		t.Fatal("Synopsis should contain options finalizer")
 	}
 }
+
+func TestLongHelpExamples(t *testing.T) {
+	root := &cmds.Command{
+		Subcommands: map[string]*cmds.Command{
+			"add": {
+				Helptext: cmds.HelpText{
+					Tagline: "Add files.",
+					Examples: []cmds.Example{
+						{CmdLine: "app add -r dir", Description: "Add a directory:"},
+						{CmdLine: "echo hi | app add"},
+					},
+				},
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+					return nil
+				},
+			},
+		},
+	}
+
+	var buf strings.Builder
+	if err := LongHelp("app", root, []string{"add"}, &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `EXAMPLES
+
+  Add a directory:
+
+    $ app add -r dir
+
+    $ echo hi | app add
+`
+	if !strings.Contains(buf.String(), expected) {
+		t.Errorf("expected help to contain\n%s\ngot\n%s", expected, buf.String())
+	}
+
+	buf.Reset()
+	if err := ShortHelp("app", root, []string{"add"}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "EXAMPLES") {
+		t.Errorf("short help shouldn't contain examples:\n%s", buf.String())
+	}
+}
+
+func TestHelpDocsURL(t *testing.T) {
+	root := &cmds.Command{
+		Subcommands: map[string]*cmds.Command{
+			"add": {
+				Helptext: cmds.HelpText{Tagline: "Add files."},
+				DocsURL:  "https://docs.example.com/add",
+			},
+		},
+	}
+
+	for name, help := range map[string]func(string, *cmds.Command, []string, io.Writer) error{
+		"long":  LongHelp,
+		"short": ShortHelp,
+	} {
+		var buf strings.Builder
+		if err := help("app", root, []string{"add"}, &buf); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasSuffix(buf.String(), "\n  see https://docs.example.com/add for more\n\n") {
+			t.Errorf("%s help doesn't end with the docs URL:\n%s", name, buf.String())
+		}
+	}
+}
+
+func TestStructuredHelp(t *testing.T) {
+	root := &cmds.Command{
+		Options: []cmds.Option{cmds.OptionEncodingType, cmds.BoolOption(cmds.OptLongHelp, "Show the full help")},
+		Subcommands: map[string]*cmds.Command{
+			"files": {
+				Helptext: cmds.HelpText{Tagline: "Manage files."},
+				Subcommands: map[string]*cmds.Command{
+					"add": {
+						Helptext: cmds.HelpText{Tagline: "Add files."},
+						Options:  []cmds.Option{cmds.BoolOption("quiet", "q", "Print less")},
+						Run:      func(*cmds.Request, cmds.ResponseEmitter, cmds.Environment) error { return nil },
+						Subcommands: map[string]*cmds.Command{
+							"dir": {},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	help := func(enc string) string {
+		req, err := cmds.NewRequest(context.Background(), []string{"files"}, cmds.OptMap{
+			cmds.OptLongHelp: true,
+			cmds.EncLong:     enc,
+		}, nil, nil, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf strings.Builder
+		if err := HandleHelp("app", req, &buf); err != nil {
+			t.Fatal(err)
+		}
+		return buf.String()
+	}
+
+	exp := `Name: "files"
+Path:
+  - "files"
+Tagline: "Manage files."
+Callable: false
+Subcommands:
+  - Name: "add"
+    Path:
+      - "files"
+      - "add"
+    Tagline: "Add files."
+    Options:
+      - Names:
+          - "quiet"
+          - "q"
+        Type: "bool"
+        Description: "Print less."
+    Callable: true
+`
+	if out := help("yaml"); out != exp {
+		t.Errorf("expected YAML help:\n%s\ngot:\n%s", exp, out)
+	}
+
+	var d cmds.CommandDescription
+	if err := json.Unmarshal([]byte(help("json")), &d); err != nil {
+		t.Fatal(err)
+	}
+	if d.Name != "files" || len(d.Subcommands) != 1 || d.Subcommands[0].Subcommands != nil {
+		t.Errorf("unexpected JSON help %+v", d)
+	}
+
+	// other encodings get the help text
+	if out := help("text"); !strings.HasPrefix(out, "USAGE\n") {
+		t.Errorf("expected the help text, got:\n%s", out)
+	}
+}
+
+func TestHelpStyle(t *testing.T) {
+	root := &cmds.Command{
+		Options: []cmds.Option{
+			cmds.BoolOption(cmds.OptShortHelp, "Show the help"),
+			cmds.OptionHelpWidth,
+			cmds.OptionHelpPlain,
+		},
+		Subcommands: map[string]*cmds.Command{
+			"add": {
+				Helptext: cmds.HelpText{Tagline: "Add \x1b[1mfiles\x1b[0m."},
+				Options: []cmds.Option{
+					cmds.BoolOption("recursive", "r", "Add directories"),
+					cmds.BoolOption("quiet", "q", "Print less"),
+					cmds.StringOption("hash-function", "Hash with this function"),
+				},
+			},
+		},
+	}
+
+	help := func(opts cmds.OptMap) string {
+		opts[cmds.OptShortHelp] = true
+		req, err := cmds.NewRequest(context.Background(), []string{"add"}, opts, nil, nil, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf strings.Builder
+		if err := HandleHelp("app", req, &buf); err != nil {
+			t.Fatal(err)
+		}
+		return buf.String()
+	}
+
+	wide := help(cmds.OptMap{cmds.HelpWidthOpt: 200})
+	narrow := help(cmds.OptMap{cmds.HelpWidthOpt: 40})
+	if strings.Count(narrow, "\n") <= strings.Count(wide, "\n") {
+		t.Errorf("expected narrow help to wrap more lines:\n%s\nthan wide help:\n%s", narrow, wide)
+	}
+
+	if out := help(cmds.OptMap{}); !strings.Contains(out, "\x1b[1mfiles") {
+		t.Errorf("expected escape sequences to be kept, got:\n%q", out)
+	}
+	if out := help(cmds.OptMap{cmds.HelpPlainOpt: true}); !strings.Contains(out, "Add files.") || strings.Contains(out, "\x1b") {
+		t.Errorf("expected plain help without escape sequences, got:\n%q", out)
+	}
+}
+
+func TestHelpOptionGroups(t *testing.T) {
+	root := &cmds.Command{
+		Subcommands: map[string]*cmds.Command{
+			"get": {
+				Helptext: cmds.HelpText{Tagline: "Get a file."},
+				Options: []cmds.Option{
+					cmds.GroupedOption("Output options", cmds.StringOption("output", "o", "The path to write to")),
+					cmds.BoolOption("verify", "Verify the file"),
+					cmds.GroupedOption("Network options", cmds.IntOption("peers", "How many peers to ask")),
+					cmds.GroupedOption("Output options", cmds.BoolOption("archive", "a", "Write a tar archive")),
+				},
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+					return nil
+				},
+			},
+		},
+	}
+
+	var buf strings.Builder
+	if err := LongHelp("app", root, []string{"get"}, &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `OPTIONS
+
+  --verify       bool   - Verify the file.
+
+  Output options:
+  -o, --output   string - The path to write to.
+  -a, --archive  bool   - Write a tar archive.
+
+  Network options:
+  --peers        int    - How many peers to ask.
+`
+	if !strings.Contains(buf.String(), expected) {
+		t.Errorf("expected help to contain\n%s\ngot\n%s", expected, buf.String())
+	}
+}
@@ -0,0 +1,281 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+// HistoryPath is the path of the command added by NewHistoryCmd, by
+// convention.
+const HistoryPath = "history"
+
+// Options of the command returned by NewHistoryCmd.
+const (
+	HistoryLimitOpt  = "limit"
+	HistoryFailedOpt = "failed"
+)
+
+// redacted replaces the values of sensitive options in the history.
+const redacted = "<redacted>"
+
+// HistoryEntry is a command line run by Run, as recorded in the history.
+type HistoryEntry struct {
+	Time time.Time
+	// Command is the command line, with the values of sensitive options
+	// redacted.
+	Command []string
+	// Status is the exit code of the command, see ExitCode.
+	Status   int
+	Duration time.Duration
+}
+
+// History records the command lines run by Run, when it is set on the root
+// command with SetHistory, so operators can find out what they ran. Entries
+// are appended to a file as JSON lines. The values of the options marked
+// with cmds.SensitiveOption, and of those listed in Redact, are left out.
+type History struct {
+	// Path is the path of the file. By default it is named history, in
+	// the directory of the program in the configuration directory of the
+	// user, see os.UserConfigDir.
+	Path string
+	// Redact lists the names of other options whose values are left out.
+	Redact []string
+}
+
+type historyKey struct{}
+
+// SetHistory makes Run record the command lines of the commands of root in h.
+// It is set in the Extra of the root command. History is off by default.
+func SetHistory(e *cmds.Extra, h History) *cmds.Extra {
+	return e.SetValue(historyKey{}, h)
+}
+
+// historyOf returns the history of root, if it has one.
+func historyOf(root *cmds.Command) (History, bool) {
+	v, ok := root.Extra.GetValue(historyKey{})
+	if !ok {
+		return History{}, false
+	}
+	h, ok := v.(History)
+	return h, ok
+}
+
+func (h History) path() (string, error) {
+	if h.Path != "" {
+		return h.Path, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, filepath.Base(os.Args[0]), "history"), nil
+}
+
+// record appends e to the history.
+func (h History) record(e HistoryEntry) error {
+	path, err := h.path()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	// entries are written at once, so processes appending at the same
+	// time don't interleave them
+	_, err = f.Write(append(data, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Entries returns the entries of the history, oldest first.
+func (h History) Entries() ([]HistoryEntry, error) {
+	path, err := h.path()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []HistoryEntry
+	dec := json.NewDecoder(f)
+	for {
+		var e HistoryEntry
+		if err := dec.Decode(&e); err == io.EOF {
+			return entries, nil
+		} else if err != nil {
+			return entries, fmt.Errorf("reading history %s: %w", path, err)
+		}
+		entries = append(entries, e)
+	}
+}
+
+// redact returns the command line args of root with the values of sensitive
+// options replaced, following the rules of Parse.
+func (h History) redact(root *cmds.Command, args []string) []string {
+	out := append([]string(nil), args...)
+	cmd := root
+	var path []string
+	optDefs, _ := root.GetOptions(path)
+
+	sensitive := func(opt cmds.Option) bool {
+		if cmds.IsSensitive(opt) {
+			return true
+		}
+		for _, name := range h.Redact {
+			for _, n := range opt.Names() {
+				if n == name {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	for i := 0; i < len(out); i++ {
+		arg := out[i]
+		switch {
+		case arg == "--":
+			return out
+		case strings.HasPrefix(arg, "--"):
+			name, _, hasValue := splitkv(arg[2:])
+			opt, ok := optDefs[name]
+			switch {
+			case !ok:
+			case hasValue:
+				if sensitive(opt) {
+					out[i] = "--" + name + "=" + redacted
+				}
+			case opt.Type() != cmds.Bool && i < len(out)-1:
+				// the value is the next word
+				i++
+				if sensitive(opt) {
+					out[i] = redacted
+				}
+			}
+		case strings.HasPrefix(arg, "-") && arg != "-":
+			flags, _, hasValue := splitkv(arg[1:])
+			if hasValue {
+				if opt, ok := optDefs[flags]; ok && sensitive(opt) {
+					out[i] = "-" + flags + "=" + redacted
+				}
+				continue
+			}
+			// boolean flags may be grouped, the first other one takes
+			// the rest of the word or the next word as its value
+			for j := 0; j < len(flags); j++ {
+				opt, ok := optDefs[flags[j:j+1]]
+				if !ok || opt.Type() == cmds.Bool {
+					continue
+				}
+				switch {
+				case j < len(flags)-1:
+					if sensitive(opt) {
+						out[i] = "-" + flags[:j+1] + redacted
+					}
+				case i < len(out)-1:
+					i++
+					if sensitive(opt) {
+						out[i] = redacted
+					}
+				}
+				break
+			}
+		default:
+			sub := cmd.Subcommands[arg]
+			if sub == nil {
+				continue
+			}
+			cmd = sub
+			path = append(path, arg)
+			optDefs, _ = root.GetOptions(path)
+			if cmd.External {
+				return out
+			}
+		}
+	}
+	return out
+}
+
+// NewHistoryCmd returns a command listing the entries of h, oldest first. It
+// is mounted at HistoryPath by convention.
+func NewHistoryCmd(h History) *cmds.Command {
+	return &cmds.Command{
+		Helptext: cmds.HelpText{
+			Tagline: "List the commands run.",
+			ShortDescription: `
+Lists the command lines run, with their exit status and duration. The values
+of sensitive options are redacted.
+`,
+		},
+		NoRemote: true,
+		Options: []cmds.Option{
+			cmds.IntOption(HistoryLimitOpt, "n", "Only list the last entries."),
+			cmds.BoolOption(HistoryFailedOpt, "Only list the commands that failed."),
+		},
+		Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+			entries, err := h.Entries()
+			if err != nil {
+				return err
+			}
+
+			if failed, _ := req.Options[HistoryFailedOpt].(bool); failed {
+				var kept []HistoryEntry
+				for _, e := range entries {
+					if e.Status != 0 {
+						kept = append(kept, e)
+					}
+				}
+				entries = kept
+			}
+			if limit, _ := req.Options[HistoryLimitOpt].(int); limit > 0 && limit < len(entries) {
+				entries = entries[len(entries)-limit:]
+			}
+
+			for i := range entries {
+				if err := re.Emit(&entries[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Type: HistoryEntry{},
+		Encoders: cmds.EncoderMap{
+			cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, e *HistoryEntry) error {
+				words := make([]string, len(e.Command))
+				for i, word := range e.Command {
+					if word == "" || strings.ContainsAny(word, " \t\n\"'") {
+						word = strconv.Quote(word)
+					}
+					words[i] = word
+				}
+				_, err := fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", e.Time.Format(time.RFC3339), e.Status, e.Duration, strings.Join(words, " "))
+				return err
+			}),
+		},
+	}
+}
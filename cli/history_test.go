@@ -0,0 +1,110 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+func TestHistory(t *testing.T) {
+	h := History{
+		Path:   filepath.Join(t.TempDir(), "history"),
+		Redact: []string{"key"},
+	}
+	root := &cmds.Command{
+		Options: []cmds.Option{
+			cmds.SensitiveOption(cmds.StringOption("token", "t", "An API token.")),
+			cmds.BoolOption("verbose", "v", "Be verbose."),
+			cmds.BoolOption(cmds.OptLongHelp, "Show the full help."),
+			cmds.OptionEncodingType,
+		},
+		Subcommands: map[string]*cmds.Command{
+			"put": {
+				Options: []cmds.Option{
+					cmds.StringOption("key", "The key."),
+					cmds.StringOption("name", "The name."),
+				},
+				Arguments: []cmds.Argument{cmds.StringArg("value", false, true, "The values.")},
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+					if req.Arguments[0] == "fail" {
+						return cmds.Errorf(cmds.ErrClient, "bad value")
+					}
+					return nil
+				},
+			},
+			HistoryPath: NewHistoryCmd(h),
+		},
+		Extra: SetHistory(nil, h),
+	}
+
+	devnull, err := os.OpenFile(os.DevNull, os.O_RDWR, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devnull.Close()
+
+	run := func(out *os.File, cmdline ...string) error {
+		return Run(context.Background(), root, append([]string{"prog"}, cmdline...), devnull, out, devnull,
+			func(ctx context.Context, req *cmds.Request) (cmds.Environment, error) {
+				return nil, nil
+			},
+			func(req *cmds.Request, env interface{}) (cmds.Executor, error) {
+				return cmds.NewExecutor(req.Root), nil
+			},
+		)
+	}
+
+	run(devnull, "-vt", "secret", "put", "--key=k", "--name", "n", "value")
+	run(devnull, "--token", "secret", "put", "--key", "k", "--", "fail")
+	// help isn't recorded
+	run(devnull, "put", "--help")
+
+	entries, err := h.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %v", entries)
+	}
+	exp := [][]string{
+		{"prog", "-vt", "<redacted>", "put", "--key=<redacted>", "--name", "n", "value"},
+		{"prog", "--token", "<redacted>", "put", "--key", "<redacted>", "--", "fail"},
+	}
+	for i, e := range entries {
+		if !reflect.DeepEqual(e.Command, exp[i]) {
+			t.Errorf("expected command %q, got %q", exp[i], e.Command)
+		}
+		if e.Time.IsZero() {
+			t.Error("entry has no time")
+		}
+	}
+	if entries[0].Status != 0 || entries[1].Status != ExitUsage {
+		t.Errorf("unexpected statuses %d and %d", entries[0].Status, entries[1].Status)
+	}
+
+	out, err := os.Create(filepath.Join(t.TempDir(), "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	if err := run(out, HistoryPath, "--failed"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(out.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 1 || !strings.HasSuffix(lines[0], "\t2\t"+entries[1].Duration.String()+"\tprog --token <redacted> put --key <redacted> -- fail") {
+		t.Errorf("unexpected history %q", data)
+	}
+	if !bytes.Contains(data, []byte(entries[1].Time.Format("2006-01-02T15:04:05"))) {
+		t.Errorf("history lacks the time of the entry: %q", data)
+	}
+}
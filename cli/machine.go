@@ -0,0 +1,93 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/fgeth/fg-ipfs-cmds"
+)
+
+// machineEmitter is the emitter of commands whose output is also written in
+// JSON, see cmds.OptionMachineOutput. The values go to both emitters, while
+// the exit status and the writers are those of the CLI emitter. For
+// commands with a PostRun function for the CLI, the values the function
+// reads are written instead of its output, see cmds.ValueTee.
+type machineEmitter struct {
+	ResponseEmitter
+	other cmds.ResponseEmitter
+	tee   cmds.ResponseEmitter
+}
+
+// withMachineOutput returns re writing the output in JSON to the file req
+// names as well, if it names one.
+func withMachineOutput(req *cmds.Request, re ResponseEmitter) (ResponseEmitter, error) {
+	dest, _ := req.Options[cmds.MachineOutOpt].(string)
+	if dest == "" {
+		return re, nil
+	}
+
+	f, err := openMachineOutput(dest)
+	if err != nil {
+		return re, err
+	}
+	other, err := cmds.NewWriterResponseEmitter(f, req, cmds.StreamWithEncoding(cmds.JSON))
+	if err != nil {
+		f.Close()
+		return re, err
+	}
+	return &machineEmitter{ResponseEmitter: re, other: other, tee: cmds.Tee(re, other)}, nil
+}
+
+// openMachineOutput opens the file dest names: fd:N for the file descriptor
+// N, a path otherwise.
+func openMachineOutput(dest string) (io.WriteCloser, error) {
+	if !strings.HasPrefix(dest, "fd:") {
+		return os.Create(dest)
+	}
+	fd, err := strconv.ParseUint(dest[len("fd:"):], 10, 0)
+	if err != nil {
+		return nil, cmds.Errorf(cmds.ErrClient, "invalid machine output %q", dest)
+	}
+	f := os.NewFile(uintptr(fd), dest)
+	if f == nil {
+		return nil, fmt.Errorf("invalid file descriptor %d", fd)
+	}
+	return f, nil
+}
+
+// TeeValues makes the output of the PostRun function go to the CLI only.
+func (re *machineEmitter) TeeValues() cmds.ResponseEmitter {
+	re.tee = re.ResponseEmitter
+	return re.other
+}
+
+func (re *machineEmitter) Emit(v interface{}) error {
+	return re.tee.Emit(v)
+}
+
+func (re *machineEmitter) Close() error {
+	return re.tee.Close()
+}
+
+func (re *machineEmitter) CloseWithError(err error) error {
+	return re.tee.CloseWithError(err)
+}
+
+func (re *machineEmitter) SetLength(l uint64) {
+	re.tee.SetLength(l)
+}
+
+func (re *machineEmitter) Warn(msg string) {
+	cmds.Warn(re.tee, msg)
+}
+
+func (re *machineEmitter) SetPage(p cmds.PageInfo) {
+	cmds.SetPage(re.tee, p)
+}
+
+func (re *machineEmitter) Type() cmds.PostRunType {
+	return cmds.CLI
+}
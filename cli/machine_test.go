@@ -0,0 +1,145 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+func TestMachineOutput(t *testing.T) {
+	type entry struct {
+		Name string
+		Size int
+	}
+	root := &cmds.Command{
+		Options: []cmds.Option{cmds.OptionMachineOutput, cmds.OptionEncodingType},
+		Subcommands: map[string]*cmds.Command{
+			"ls": {
+				Encoders: cmds.EncoderMap{
+					cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, e *entry) error {
+						_, err := io.WriteString(w, e.Name+"\n")
+						return err
+					}),
+				},
+				Type: entry{},
+			},
+		},
+	}
+
+	path := filepath.Join(t.TempDir(), "out.json")
+	req, err := cmds.NewRequest(context.Background(), []string{"ls"}, cmds.OptMap{
+		cmds.MachineOutOpt: path,
+		cmds.EncLong:       cmds.Text,
+	}, nil, nil, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	re, err := NewResponseEmitter(&stdout, &stderr, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if re, err = withMachineOutput(req, re); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []interface{}{&entry{"a", 1}, &entry{"b", 2}} {
+		if err := re.Emit(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cmds.SetStatus(re, 3)
+	if err := re.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if stdout.String() != "a\nb\n" {
+		t.Errorf("unexpected stdout %q", stdout.String())
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out := `{"Name":"a","Size":1}` + "\n" + `{"Name":"b","Size":2}` + "\n"; string(data) != out {
+		t.Errorf("expected machine output %q, got %q", out, data)
+	}
+	if re.Status() != 3 {
+		t.Errorf("expected status 3, got %d", re.Status())
+	}
+
+	req.Options[cmds.MachineOutOpt] = "fd:three"
+	if _, err := withMachineOutput(req, re); err == nil || err.Error() != `invalid machine output "fd:three"` {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestMachineOutputPostRun(t *testing.T) {
+	type entry struct {
+		Name string
+	}
+	root := &cmds.Command{
+		Options: []cmds.Option{cmds.OptionMachineOutput},
+		Subcommands: map[string]*cmds.Command{
+			"ls": {
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+					for _, name := range []string{"a", "b"} {
+						if err := re.Emit(&entry{name}); err != nil {
+							return err
+						}
+					}
+					return nil
+				},
+				PostRun: cmds.PostRunMap{
+					cmds.CLI: func(res cmds.Response, re cmds.ResponseEmitter) error {
+						w := re.(ResponseEmitter).Stdout()
+						for {
+							v, err := res.Next()
+							if err == io.EOF {
+								return nil
+							} else if err != nil {
+								return err
+							}
+							io.WriteString(w, "- "+v.(*entry).Name+"\n")
+						}
+					},
+				},
+				Type: entry{},
+			},
+		},
+	}
+
+	path := filepath.Join(t.TempDir(), "out.json")
+	req, err := cmds.NewRequest(context.Background(), []string{"ls"}, cmds.OptMap{cmds.MachineOutOpt: path}, nil, nil, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	re, err := NewResponseEmitter(&stdout, &stderr, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if re, err = withMachineOutput(req, re); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmds.NewExecutor(root).Execute(req, re, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// the file gets the values, the terminal the output of PostRun
+	if stdout.String() != "- a\n- b\n" {
+		t.Errorf("unexpected stdout %q", stdout.String())
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out := `{"Name":"a"}` + "\n" + `{"Name":"b"}` + "\n"; string(data) != out {
+		t.Errorf("expected machine output %q, got %q", out, data)
+	}
+}
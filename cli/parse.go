@@ -14,10 +14,13 @@ import (
 	osh "github.com/Kubuxu/go-os-helper"
 	cmds "github.com/fgeth/fg-ipfs-cmds"
 	files "github.com/fgeth/fg-ipfs-files"
-	logging "github.com/ipfs/go-log"
 )
 
-var log = logging.Logger("cmds/cli")
+var log = cmds.SubsystemLogger("cmds/cli")
+
+// MaxOptionFileSize is the size limit of option values read from files, see
+// cmds.FileValueOption.
+var MaxOptionFileSize int64 = 1 << 20
 var msgStdinInfo = "ipfs: Reading from %s; send Ctrl-d to stop."
 
 func init() {
@@ -29,13 +32,44 @@ func init() {
 // Parse parses the input commandline string (cmd, flags, and args).
 // returns the corresponding command Request object.
 //
-// This function never returns nil, even on error.
+// This function never returns nil, even on error. Errors are of type
+// cmds.ErrNotFound if the command doesn't exist, and cmds.ErrClient
+// otherwise.
 func Parse(ctx context.Context, input []string, stdin *os.File, root *cmds.Command) (*cmds.Request, error) {
+	req, err := parseRequest(ctx, input, stdin, root)
+	return req, usageError(err)
+}
+
+// usageError marks err as an error of the user, unless it has a type
+// already.
+func usageError(err error) error {
+	switch err.(type) {
+	case nil, cmds.Error, *cmds.Error:
+		return err
+	default:
+		return cmds.Errorf(cmds.ErrClient, "%s", err)
+	}
+}
+
+func parseRequest(ctx context.Context, input []string, stdin *os.File, root *cmds.Command) (*cmds.Request, error) {
 	req := &cmds.Request{Context: ctx}
 
 	if err := parse(req, input, root); err != nil {
 		return req, err
 	}
+	if err := req.RenameDeprecatedOptions(); err != nil {
+		return req, err
+	}
+	_, explicitEnc := req.Options[cmds.EncLong]
+
+	readStdin, err := readOptionFiles(req, stdin)
+	if err != nil {
+		return req, err
+	}
+	if readStdin {
+		// nothing left for the arguments
+		stdin = nil
+	}
 
 	if err := req.FillDefaults(); err != nil {
 		return req, err
@@ -55,9 +89,89 @@ func Parse(ctx context.Context, input []string, stdin *os.File, root *cmds.Comma
 		}
 	}
 
+	if err := req.Command.CheckEncoding(cmds.GetEncoding(req, "")); err != nil {
+		supported := req.Command.SupportedEncodings()
+		if explicitEnc || len(supported) == 0 {
+			return req, err
+		}
+		// the default encoding isn't supported, prefer JSON over the others
+		enc := supported[0]
+		for _, s := range supported {
+			if s == cmds.JSON {
+				enc = s
+			}
+		}
+		req.SetOption(cmds.EncLong, enc)
+	}
+
 	return req, nil
 }
 
+// readOptionFiles replaces the values of options accepting values from files
+// with the contents of the files they name. It returns whether stdin was
+// read.
+func readOptionFiles(req *cmds.Request, stdin *os.File) (bool, error) {
+	optDefs, err := req.Root.GetOptions(req.Path)
+	if err != nil {
+		return false, err
+	}
+
+	var readStdin bool
+	read := func(name, v string) (string, error) {
+		switch {
+		case !strings.HasPrefix(v, "@"):
+			return v, nil
+		case strings.HasPrefix(v, "@@"):
+			return v[1:], nil
+		}
+
+		var r io.Reader
+		if v == "@-" {
+			if stdin == nil || readStdin {
+				return "", fmt.Errorf("cannot read value of option %q from stdin, it is not available", name)
+			}
+			readStdin = true
+			r = stdin
+		} else {
+			f, err := os.Open(v[1:])
+			if err != nil {
+				return "", fmt.Errorf("cannot read value of option %q: %s", name, err)
+			}
+			defer f.Close()
+			r = f
+		}
+
+		data, err := io.ReadAll(io.LimitReader(r, MaxOptionFileSize+1))
+		if err != nil {
+			return "", fmt.Errorf("cannot read value of option %q: %s", name, err)
+		}
+		if int64(len(data)) > MaxOptionFileSize {
+			return "", fmt.Errorf("value of option %q is larger than %d bytes", name, MaxOptionFileSize)
+		}
+		return string(data), nil
+	}
+
+	for k, v := range req.Options {
+		if optDef, ok := optDefs[k]; !ok || !cmds.AcceptsFileValue(optDef) {
+			continue
+		}
+
+		switch v := v.(type) {
+		case string:
+			if req.Options[k], err = read(k, v); err != nil {
+				return readStdin, err
+			}
+		case []string:
+			for i := range v {
+				if v[i], err = read(k, v[i]); err != nil {
+					return readStdin, err
+				}
+			}
+		}
+	}
+	return readStdin, nil
+}
+
 func isHidden(req *cmds.Request) bool {
 	h, ok := req.Options[cmds.Hidden].(bool)
 	return h && ok
@@ -157,7 +271,9 @@ L:
 			}
 
 			for _, kv := range kvs {
-				kv.Key = optDefs[kv.Key].Names()[0]
+				if optDef := optDefs[kv.Key]; !cmds.IsDeprecatedName(optDef, kv.Key) {
+					kv.Key = optDef.Names()[0]
+				}
 
 				kvType, err := getOptType(kv.Key, optDefs)
 				if err != nil {
@@ -197,6 +313,13 @@ L:
 		st.i++
 	}
 
+	// options given before the sub command may not apply to it
+	for k := range opts {
+		if err := root.CheckOption(path, k); err != nil {
+			return err
+		}
+	}
+
 	req.Root = root
 	req.Command = cmd
 	req.Path = path
@@ -362,11 +485,15 @@ func parseArgs(req *cmds.Request, root *cmds.Command, stdin *os.File) error {
 
 	// check to make sure we didn't miss any required arguments
 	if len(argDefs) > iArgDef {
+		var verr cmds.ValidationError
 		for _, argDef := range argDefs[iArgDef:] {
 			if argDef.Required {
-				return fmt.Errorf("argument %q is required", argDef.Name)
+				verr.Add(argDef.Name, "argument is required")
 			}
 		}
+		if err := verr.Err(); err != nil {
+			return err
+		}
 	}
 
 	req.Arguments = stringArgs
@@ -420,6 +547,10 @@ func parseOpt(opt, value string, opts map[string]cmds.Option) (string, interface
 	if err != nil {
 		return "", nil, err
 	}
+	if cmds.IsDeprecatedName(optDef, opt) {
+		// RenameDeprecatedOptions renames it, with a warning
+		return opt, v, nil
+	}
 	return optDef.Name(), v, nil
 }
 
@@ -453,8 +584,12 @@ func (st *parseState) parseShortOpts(optDefs map[string]cmds.Option) ([]kv, erro
 
 			case od.Type() == cmds.Bool:
 				// single char flags for bools
+				key := od.Name()
+				if cmds.IsDeprecatedName(od, flag) {
+					key = flag
+				}
 				kvs = append(kvs, kv{
-					Key:   od.Name(),
+					Key:   key,
 					Value: true,
 				})
 				j++
@@ -570,16 +705,6 @@ func maybeWrapStdin(f *os.File, msg string) (io.ReadCloser, error) {
 	return f, nil
 }
 
-func isTty(f *os.File) (bool, error) {
-	fInfo, err := f.Stat()
-	if err != nil {
-		log.Error(err)
-		return false, err
-	}
-
-	return (fInfo.Mode() & os.ModeCharDevice) != 0, nil
-}
-
 type messageReader struct {
 	r       io.ReadCloser
 	done    bool
@@ -8,6 +8,7 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 
@@ -103,7 +104,9 @@ func TestOptionParsing(t *testing.T) {
 			cmds.BoolOption("bool", "b", "a bool"),
 			cmds.StringsOption("strings", "r", "strings array"),
 			cmds.DelimitedStringsOption(",", "delimstrings", "d", "comma delimited string array"),
+			cmds.BoolOption("local", "l", "a root-only bool"),
 		},
+		LocalOptions: []string{"local"},
 		Subcommands: map[string]*cmds.Command{
 			"test": {},
 			"defaults": {
@@ -111,6 +114,9 @@ func TestOptionParsing(t *testing.T) {
 					cmds.StringOption("opt", "o", "an option").WithDefault("def"),
 				},
 			},
+			"nobool": {
+				ExcludedOptions: []string{"b"},
+			},
 		},
 	}
 
@@ -171,6 +177,13 @@ func TestOptionParsing(t *testing.T) {
 	testFail("--flag=bar --alias=foo")
 	testFail("--alias=bar --flag=foo")
 
+	test("--local", kvs{"local": true}, words{})
+	testFail("--local test")
+	testFail("test --local")
+	test("nobool -s foo", kvs{"string": "foo"}, words{})
+	testFail("--bool nobool")
+	testFail("nobool -b")
+
 	testFail("--bad-flag")
 	testFail("--bad-flag=")
 	testFail("--bad-flag=xyz")
@@ -586,12 +599,12 @@ func TestBodyArgs(t *testing.T) {
 		{
 			cmd: words{"stdinenablednotvariadic2args"}, f: fstdin1,
 			posArgs: words{}, varArgs: words{},
-			parseErr: fmt.Errorf(`argument %q is required`, "a"), bodyArgs: true,
+			parseErr: fmt.Errorf(`invalid request: a: argument is required; b: argument is required`), bodyArgs: true,
 		},
 		{
 			cmd: words{"stdinenablednotvariadic2args", "value1"}, f: nil,
 			posArgs: words{"value1"}, varArgs: words{},
-			parseErr: fmt.Errorf(`argument %q is required`, "b"), bodyArgs: true,
+			parseErr: fmt.Errorf(`b: argument is required`), bodyArgs: true,
 		},
 		{
 			cmd: words{"noarg"}, f: fstdin1,
@@ -616,7 +629,7 @@ func TestBodyArgs(t *testing.T) {
 		{
 			cmd: words{"optionalstdin"}, f: fstdin1,
 			posArgs: words{"value1"}, varArgs: words{},
-			parseErr: fmt.Errorf(`argument %q is required`, "a"), bodyArgs: false,
+			parseErr: fmt.Errorf(`a: argument is required`), bodyArgs: false,
 		},
 		{
 			cmd: words{"optionalvariadicstdin", "value1"}, f: nil,
@@ -787,7 +800,7 @@ func TestFileArgs(t *testing.T) {
 		{
 			cmd:      words{"fileOp"},
 			args:     nil,
-			parseErr: fmt.Errorf("argument %q is required", "path"),
+			parseErr: fmt.Errorf("path: argument is required"),
 		},
 		{
 			cmd: words{"fileOp", "--ignore", filepath.Base(tmpFile2.Name()), tmpDir1, tmpFile1.Name()}, f: nil,
@@ -867,3 +880,159 @@ func TestFileArgs(t *testing.T) {
 		}
 	}
 }
+
+func TestEncodingParsing(t *testing.T) {
+	root := &cmds.Command{
+		Options: []cmds.Option{cmds.OptionEncodingType},
+		Subcommands: map[string]*cmds.Command{
+			"cat": {
+				ForbiddenEncodings: []cmds.EncodingType{cmds.Text, cmds.TextNewline},
+			},
+			"proto": {
+				Encodings: []cmds.EncodingType{cmds.Protobuf},
+			},
+		},
+	}
+
+	type testcase struct {
+		cmdline string
+		enc     cmds.EncodingType
+		err     string
+	}
+
+	tcs := []testcase{
+		// the default encoding falls back to a supported one
+		{cmdline: "cat", enc: cmds.JSON},
+		{cmdline: "cat --enc=xml", enc: cmds.XML},
+		{cmdline: "cat --encoding=text", err: `encoding "text" is not supported by this command, supported encodings are: cbor, json, merge-patch, ndjson, raw, xml`},
+		{cmdline: "proto", enc: cmds.Protobuf},
+		{cmdline: "proto --enc=json", err: `encoding "json" is not supported by this command, supported encodings are: protobuf`},
+	}
+
+	for _, tc := range tcs {
+		req, err := Parse(context.Background(), strings.Split(tc.cmdline, " "), nil, root)
+		if tc.err != "" {
+			if err == nil || err.Error() != tc.err {
+				t.Errorf("%s: expected error %q, got %v", tc.cmdline, tc.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tc.cmdline, err)
+			continue
+		}
+		if enc := cmds.GetEncoding(req, ""); enc != tc.enc {
+			t.Errorf("%s: expected encoding %s, got %s", tc.cmdline, tc.enc, enc)
+		}
+	}
+}
+
+func TestOptionFileValues(t *testing.T) {
+	root := &cmds.Command{
+		Options: []cmds.Option{
+			cmds.FileValueOption(cmds.StringOption("config", "a config")),
+			cmds.FileValueOption(cmds.StringsOption("tag", "tags")),
+			cmds.StringOption("plain", "a plain string"),
+		},
+	}
+
+	dir, err := ioutil.TempDir("", "option-files")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfgPath := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(cfgPath, []byte(`{"a": 1}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	bigPath := filepath.Join(dir, "big")
+	if err := ioutil.WriteFile(bigPath, make([]byte, MaxOptionFileSize+1), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	type testcase struct {
+		cmdline []string
+		stdin   string
+		opts    cmds.OptMap
+		err     bool
+	}
+
+	tcs := []testcase{
+		{cmdline: []string{"--config", "@" + cfgPath}, opts: cmds.OptMap{"config": `{"a": 1}`}},
+		{cmdline: []string{"--config=@-"}, stdin: "from stdin", opts: cmds.OptMap{"config": "from stdin"}},
+		{cmdline: []string{"--config", "@@user"}, opts: cmds.OptMap{"config": "@user"}},
+		{cmdline: []string{"--tag", "a", "--tag", "@" + cfgPath}, opts: cmds.OptMap{"tag": []string{"a", `{"a": 1}`}}},
+		// only options accepting files are expanded
+		{cmdline: []string{"--plain", "@" + cfgPath}, opts: cmds.OptMap{"plain": "@" + cfgPath}},
+		{cmdline: []string{"--config", "@" + filepath.Join(dir, "missing")}, err: true},
+		{cmdline: []string{"--config", "@" + bigPath}, err: true},
+		// stdin can only be read once
+		{cmdline: []string{"--config=@-", "--tag=@-"}, stdin: "x", err: true},
+	}
+
+	for _, tc := range tcs {
+		var stdin *os.File
+		if tc.stdin != "" {
+			f, err := ioutil.TempFile(dir, "stdin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.WriteString(f, tc.stdin); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			stdin = f
+		}
+
+		req, err := Parse(context.Background(), tc.cmdline, stdin, root)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%v: expected error", tc.cmdline)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: unexpected error: %s", tc.cmdline, err)
+			continue
+		}
+		for k, v := range tc.opts {
+			if !reflect.DeepEqual(req.Options[k], v) {
+				t.Errorf("%v: expected option %s to be %#v, got %#v", tc.cmdline, k, v, req.Options[k])
+			}
+		}
+	}
+}
+
+func TestRenamedOptionParsing(t *testing.T) {
+	root := &cmds.Command{
+		Subcommands: map[string]*cmds.Command{
+			"test": {
+				Options: []cmds.Option{
+					cmds.RenamedOption(cmds.StringOption("color", "c", "a color"), "colour"),
+				},
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+					return nil
+				},
+			},
+		},
+	}
+
+	req, err := Parse(context.Background(), []string{"test", "--colour", "red"}, nil, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := req.Options["colour"]; ok || req.Options["color"] != "red" {
+		t.Errorf("expected the option to be renamed, got %v", req.Options)
+	}
+	if len(req.Warnings()) != 1 {
+		t.Errorf("expected a deprecation warning, got %q", req.Warnings())
+	}
+
+	if _, err := Parse(context.Background(), []string{"test", "--colour=red", "-c", "blue"}, nil, root); err == nil {
+		t.Error("expected an error for an option set by both names")
+	}
+}
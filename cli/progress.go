@@ -0,0 +1,187 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/fgeth/fg-ipfs-cmds"
+)
+
+const (
+	progressWidth    = 30
+	progressInterval = 100 * time.Millisecond
+)
+
+// progressWriter renders a progress bar for the bytes written to it, out of
+// a known total.
+type progressWriter struct {
+	w     io.Writer
+	total uint64
+
+	done uint64
+	last time.Time
+}
+
+func newProgressWriter(w io.Writer, total uint64) *progressWriter {
+	return &progressWriter{w: w, total: total}
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	p.done += uint64(len(b))
+
+	// redraw at most every progressInterval, but always show completion
+	if now := time.Now(); now.Sub(p.last) >= progressInterval || p.done >= p.total {
+		p.last = now
+		p.render()
+	}
+	return len(b), nil
+}
+
+// finish draws the final state of the bar and ends its line.
+func (p *progressWriter) finish() {
+	p.render()
+	fmt.Fprintln(p.w)
+}
+
+func (p *progressWriter) render() {
+	fmt.Fprintf(p.w, "\r%s / %s %s", formatBytes(p.done), formatBytes(p.total), bar(p.done, p.total))
+}
+
+// bar returns a progress bar for done out of total, with its percentage.
+func bar(done, total uint64) string {
+	pct := done * 100 / total
+	if pct > 100 {
+		pct = 100
+	}
+	filled := int(pct) * progressWidth / 100
+	return fmt.Sprintf("[%s%s] %3d%%", strings.Repeat("=", filled), strings.Repeat(" ", progressWidth-filled), pct)
+}
+
+// progressLine renders the cmds.Progress reports of a command on a line,
+// redrawn as they come, and starts a new line when the stage changes.
+type progressLine struct {
+	w io.Writer
+
+	last  time.Time
+	cur   cmds.Progress
+	drawn bool
+	width int
+}
+
+func (l *progressLine) update(p cmds.Progress) {
+	if l.drawn && p.Stage != l.cur.Stage {
+		l.render()
+		l.finish()
+	}
+	l.cur = p
+
+	// like progressWriter, skip redraws in between but show completion
+	complete := (p.BytesTotal > 0 && p.BytesDone >= p.BytesTotal) ||
+		(p.BytesTotal == 0 && p.ItemsTotal > 0 && p.ItemsDone >= p.ItemsTotal)
+	if now := time.Now(); now.Sub(l.last) >= progressInterval || complete || !l.drawn {
+		l.last = now
+		l.render()
+	}
+}
+
+func (l *progressLine) render() {
+	p := l.cur
+
+	// the bar shows bytes if their total is known, items otherwise
+	var parts []string
+	switch {
+	case p.ItemsTotal > 0 && p.BytesTotal == 0:
+		parts = append(parts, fmt.Sprintf("%d / %d items %s", p.ItemsDone, p.ItemsTotal, bar(p.ItemsDone, p.ItemsTotal)))
+	case p.ItemsTotal > 0:
+		parts = append(parts, fmt.Sprintf("%d / %d items", p.ItemsDone, p.ItemsTotal))
+	case p.ItemsDone > 0:
+		parts = append(parts, fmt.Sprintf("%d items", p.ItemsDone))
+	}
+	switch {
+	case p.BytesTotal > 0:
+		parts = append(parts, fmt.Sprintf("%s / %s %s", formatBytes(p.BytesDone), formatBytes(p.BytesTotal), bar(p.BytesDone, p.BytesTotal)))
+	case p.BytesDone > 0:
+		parts = append(parts, formatBytes(p.BytesDone))
+	}
+	s := strings.Join(parts, ", ")
+	if p.Stage != "" {
+		s = strings.TrimSuffix(p.Stage+": "+s, ": ")
+	}
+
+	// pad with spaces to clear what is left of a longer previous line
+	pad := l.width - len(s)
+	if pad < 0 {
+		pad = 0
+	}
+	l.width = len(s)
+	fmt.Fprintf(l.w, "\r%s%s", s, strings.Repeat(" ", pad))
+	l.drawn = true
+}
+
+// finish ends the line, if anything was drawn.
+func (l *progressLine) finish() {
+	if !l.drawn {
+		return
+	}
+	fmt.Fprintln(l.w)
+	l.drawn = false
+	l.width = 0
+}
+
+// formatBytes formats n with a binary unit, e.g. "1.5 MiB".
+func formatBytes(n uint64) string {
+	return cmds.DefaultLocale.FormatBytes(n)
+}
+
+// showProgress reports whether a progress bar should be drawn on stderr. It
+// is only drawn if stderr is a terminal that doesn't also show the output.
+func showProgress(stdout, stderr io.Writer) bool {
+	return isTerminal(stderr) && !isTerminal(stdout)
+}
+
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	tty, _ := isTty(f)
+	return tty
+}
+
+// emitProgress handles the progress reports of text responses, see
+// cmds.AsProgress: they are rendered on stderr if it is a terminal, instead
+// of being printed with the output. Progress values are dropped otherwise,
+// while the progress reports of other types are printed as usual. It
+// reports whether v was handled.
+func (re *responseEmitter) emitProgress(v interface{}) bool {
+	if re.encType != cmds.Text && re.encType != cmds.TextNewline {
+		return false
+	}
+	p, ok := cmds.AsProgress(re.req.Command, v)
+	if !ok {
+		return false
+	}
+	if !isTerminal(re.stderr) {
+		switch v.(type) {
+		case cmds.Progress, *cmds.Progress:
+			return true
+		}
+		return false
+	}
+	if re.progress == nil {
+		re.progress = &progressLine{w: re.stderr}
+	}
+	re.progress.update(p)
+	return true
+}
+
+// endProgress ends the line of the progress reports, if any, before
+// something else is printed.
+func (re *responseEmitter) endProgress() {
+	if re.progress != nil {
+		re.progress.finish()
+	}
+}
@@ -0,0 +1,90 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+func TestProgressWriter(t *testing.T) {
+	var buf bytes.Buffer
+	pw := newProgressWriter(&buf, 2048)
+
+	pw.Write(make([]byte, 1024))
+	if !strings.HasSuffix(buf.String(), "1.0 KiB / 2.0 KiB [===============               ]  50%") {
+		t.Errorf("unexpected progress %q", buf.String())
+	}
+
+	pw.Write(make([]byte, 1024))
+	pw.finish()
+	if !strings.HasSuffix(buf.String(), "2.0 KiB / 2.0 KiB [==============================] 100%\n") {
+		t.Errorf("unexpected progress %q", buf.String())
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	for n, s := range map[uint64]string{
+		0:         "0 B",
+		1023:      "1023 B",
+		1024:      "1.0 KiB",
+		3 << 19:   "1.5 MiB",
+		5 << 40:   "5.0 TiB",
+		1<<64 - 1: "16.0 EiB",
+	} {
+		if f := formatBytes(n); f != s {
+			t.Errorf("%d: expected %q, got %q", n, s, f)
+		}
+	}
+}
+
+func TestProgressLine(t *testing.T) {
+	var buf bytes.Buffer
+	l := &progressLine{w: &buf}
+
+	l.update(cmds.Progress{Stage: "hashing", BytesDone: 1024, BytesTotal: 2048})
+	if exp := "\rhashing: 1.0 KiB / 2.0 KiB [===============               ]  50%"; buf.String() != exp {
+		t.Errorf("expected %q, got %q", exp, buf.String())
+	}
+
+	// a new stage starts a new line
+	buf.Reset()
+	l.update(cmds.Progress{Stage: "pinning", ItemsDone: 1, ItemsTotal: 4})
+	if !strings.HasSuffix(buf.String(), "\n\rpinning: 1 / 4 items [=======                       ]  25%") {
+		t.Errorf("unexpected progress %q", buf.String())
+	}
+
+	buf.Reset()
+	l.finish()
+	l.finish()
+	if buf.String() != "\n" {
+		t.Errorf("expected the line to be ended once, got %q", buf.String())
+	}
+}
+
+func TestEmitProgress(t *testing.T) {
+	cmd := &cmds.Command{
+		Type: "",
+	}
+	req, err := cmds.NewRequest(context.Background(), nil, map[string]interface{}{
+		cmds.EncLong: cmds.Text,
+	}, nil, nil, cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// without a terminal, progress reports are left out of the output
+	var stdout, stderr bytes.Buffer
+	re, err := NewResponseEmitter(&stdout, &stderr, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	re.Emit(&cmds.Progress{BytesDone: 5})
+	re.Emit("done")
+	re.Close()
+	if stdout.String() != "done" || stderr.Len() != 0 {
+		t.Errorf("unexpected output %q, errors %q", stdout.String(), stderr.String())
+	}
+}
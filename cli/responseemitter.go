@@ -6,6 +6,7 @@ import (
 	"io"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/fgeth/fg-ipfs-cmds"
 )
@@ -15,6 +16,11 @@ var _ ResponseEmitter = &responseEmitter{}
 // NewResponseEmitter constructs a new response emitter that writes results to
 // the console.
 func NewResponseEmitter(stdout, stderr io.Writer, req *cmds.Request) (ResponseEmitter, error) {
+	// tables are fit in the terminal
+	if width, ok := terminalWidth(stdout); ok && req.Context != nil && cmds.OutputWidth(req.Context) == 0 {
+		req.Context = cmds.ContextWithOutputWidth(req.Context, width)
+	}
+	stdout = withTimestamps(req, stdout)
 	encType, enc, err := cmds.GetEncoder(req, stdout, cmds.TextNewline)
 
 	return &responseEmitter{
@@ -22,6 +28,9 @@ func NewResponseEmitter(stdout, stderr io.Writer, req *cmds.Request) (ResponseEm
 		stderr:  stderr,
 		encType: encType,
 		enc:     enc,
+		req:     req,
+		collect: collecting(req),
+		start:   time.Now(),
 	}, err
 }
 
@@ -49,12 +58,34 @@ type responseEmitter struct {
 	encType cmds.EncodingType
 	exit    int
 	closed  bool
+
+	// collect is set if values are buffered and encoded as one slice when
+	// the emitter is closed.
+	req       *cmds.Request
+	collect   bool
+	collected []interface{}
+
+	// start is when the emitter was created, for the show-duration option.
+	start time.Time
+
+	// progress renders the progress reports of the command, once there is
+	// one.
+	progress *progressLine
 }
 
 func (re *responseEmitter) Type() cmds.PostRunType {
 	return cmds.CLI
 }
 
+// Warn prints a warning on stderr, e.g. one a server sent.
+func (re *responseEmitter) Warn(msg string) {
+	if re.isClosed() {
+		return
+	}
+	re.endProgress()
+	fmt.Fprintln(re.stderr, "Warning:", msg)
+}
+
 func (re *responseEmitter) SetLength(l uint64) {
 	re.length = l
 }
@@ -78,12 +109,22 @@ func (re *responseEmitter) CloseWithError(err error) error {
 		return cmds.ErrClosingClosedEmitter
 	}
 	re.closed = true
+	re.endProgress()
+
+	if re.collect && re.enc != nil {
+		if encErr := re.enc.Encode(collectSlice(re.req, re.collected)); encErr != nil && err == nil {
+			err = encErr
+		}
+		re.collected = nil
+	}
+	if encErr := cmds.FinishEncoder(re.enc, err); encErr != nil && err == nil {
+		err = encErr
+	}
 
 	var msg string
 	if err != nil {
 		if re.exit == 0 {
-			// Default "error" exit code.
-			re.exit = 1
+			re.exit = ExitCode(err)
 		}
 		switch err {
 		case context.Canceled:
@@ -91,11 +132,18 @@ func (re *responseEmitter) CloseWithError(err error) error {
 		case context.DeadlineExceeded:
 			msg = "timed out"
 		default:
-			msg = err.Error()
+			msg = errorMessage(err)
 		}
 
-		fmt.Fprintln(re.stderr, "Error:", msg)
+		if ExitCode(err) == 0 {
+			// the response was truncated, the output is still valid
+			fmt.Fprintln(re.stderr, "Warning:", msg)
+		} else {
+			fmt.Fprintln(re.stderr, "Error:", msg)
+		}
+		printHelpURL(re.stderr, err)
 	}
+	printDuration(re.req, re.stderr, re.start)
 
 	defer func() {
 		re.stdout = nil
@@ -106,7 +154,7 @@ func (re *responseEmitter) CloseWithError(err error) error {
 	if f, ok := re.stderr.(*os.File); ok {
 		errStderr = f.Sync()
 	}
-	if f, ok := re.stdout.(*os.File); ok {
+	if f, ok := unwrapWriter(re.stdout).(*os.File); ok {
 		errStdout = f.Sync()
 	}
 
@@ -150,11 +198,30 @@ func (re *responseEmitter) Emit(v interface{}) error {
 		return cmds.ErrClosedEmitter
 	}
 
+	if re.emitProgress(v) {
+		if isSingle {
+			return re.Close()
+		}
+		return nil
+	}
+	re.endProgress()
+
+	if re.collect {
+		return re.collectValue(v, isSingle)
+	}
+
 	var err error
 
 	switch t := v.(type) {
 	case io.Reader:
-		_, err = io.Copy(re.stdout, t)
+		// show progress if the length is known
+		if re.length > 0 && showProgress(unwrapWriter(re.stdout), re.stderr) {
+			pw := newProgressWriter(re.stderr, re.length)
+			_, err = io.Copy(io.MultiWriter(re.stdout, pw), t)
+			pw.finish()
+		} else {
+			_, err = io.Copy(re.stdout, t)
+		}
 		if err != nil {
 			return err
 		}
@@ -173,6 +240,25 @@ func (re *responseEmitter) Emit(v interface{}) error {
 	return err
 }
 
+func (re *responseEmitter) collectValue(v interface{}, isSingle bool) error {
+	if _, ok := v.(io.Reader); ok {
+		return cmds.Errorf(cmds.ErrClient, "collecting is not supported for streamed output")
+	}
+
+	re.l.Lock()
+	if len(re.collected) >= MaxCollect {
+		re.l.Unlock()
+		return cmds.Errorf(cmds.ErrClient, "too many values to collect, the limit is %d", MaxCollect)
+	}
+	re.collected = append(re.collected, v)
+	re.l.Unlock()
+
+	if isSingle {
+		return re.Close()
+	}
+	return nil
+}
+
 // Stderr returns the ResponseWriter's stderr
 func (re *responseEmitter) Stderr() io.Writer {
 	return re.stderr
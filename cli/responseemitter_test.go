@@ -74,6 +74,17 @@ func TestCloseWithError(t *testing.T) {
 				}
 			},
 		},
+		{
+			stdout:   bytes.NewBuffer(nil),
+			stderr:   bytes.NewBuffer(nil),
+			exStdout: "a\n",
+			exStderr: "Warning: too long\n",
+			exExit:   0,
+			f: func(re ResponseEmitter, t *testing.T) {
+				re.Emit("a")
+				re.CloseWithError(cmds.Errorf(cmds.ErrTruncated, "too long"))
+			},
+		},
 	}
 
 	for i, tc := range tcs {
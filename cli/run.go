@@ -2,6 +2,7 @@ package cli
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -18,6 +19,71 @@ func (e ExitError) Error() string {
 	return fmt.Sprintf("exit code %d", int(e))
 }
 
+// Exit codes of failed commands, depending on the type of the error.
+const (
+	// ExitFailure is the exit code of commands that failed to execute.
+	ExitFailure = 1
+	// ExitUsage is the exit code of invalid command lines, e.g. with
+	// unknown options or bad arguments (cmds.ErrClient).
+	ExitUsage = 2
+	// ExitNotFound is the exit code of unknown commands (cmds.ErrNotFound).
+	ExitNotFound = 3
+)
+
+// ExitCode returns the exit code for err, the error returned by Run.
+// Truncated responses (cmds.ErrTruncated) are only warned about, so their
+// exit code is 0.
+func ExitCode(err error) int {
+	var code cmds.ErrorType
+	switch e := err.(type) {
+	case nil:
+		return 0
+	case ExitError:
+		return int(e)
+	case *cmds.Error:
+		code = e.Code
+	case cmds.Error:
+		code = e.Code
+	case *cmds.ValidationError:
+		code = cmds.ErrClient
+	default:
+		return ExitFailure
+	}
+
+	switch code {
+	case cmds.ErrClient:
+		return ExitUsage
+	case cmds.ErrNotFound:
+		return ExitNotFound
+	case cmds.ErrTruncated:
+		return 0
+	default:
+		return ExitFailure
+	}
+}
+
+// printHelpURL points users to the help URL of err, if it has one.
+func printHelpURL(w io.Writer, err error) {
+	if url := cmds.HelpURL(err); url != "" {
+		fmt.Fprintf(w, "see %s for more\n", url)
+	}
+}
+
+// errorMessage returns the message of err, listing the problems of requests
+// with several invalid options or arguments on lines of their own.
+func errorMessage(err error) string {
+	fields := cmds.FieldErrors(err)
+	if len(fields) < 2 {
+		return err.Error()
+	}
+	var b strings.Builder
+	b.WriteString("invalid request:")
+	for _, f := range fields {
+		fmt.Fprintf(&b, "\n  - %s: %s", f.Field, f.Message)
+	}
+	return b.String()
+}
+
 // Closer is a helper interface to check if the env supports closing
 type Closer interface {
 	Close()
@@ -25,13 +91,17 @@ type Closer interface {
 
 func Run(ctx context.Context, root *cmds.Command,
 	cmdline []string, stdin, stdout, stderr *os.File,
-	buildEnv cmds.MakeEnvironment, makeExecutor cmds.MakeExecutor) error {
+	buildEnv cmds.MakeEnvironment, makeExecutor cmds.MakeExecutor) (err error) {
 
 	printErr := func(err error) {
-		fmt.Fprintf(stderr, "Error: %s\n", err)
+		fmt.Fprintf(stderr, "Error: %s\n", errorMessage(err))
+		printHelpURL(stderr, err)
 	}
 
 	req, errParse := Parse(ctx, cmdline[1:], stdin, root)
+	for _, w := range req.Warnings() {
+		fmt.Fprintf(stderr, "Warning: %s\n", w)
+	}
 
 	// Handle the timeout up front.
 	var cancel func()
@@ -64,7 +134,7 @@ func Run(ctx context.Context, root *cmds.Command,
 			path = req.Path
 		}
 
-		if err := helpFunc(cmdline[0], root, path, w); err != nil {
+		if err := helpFunc(cmdline[0], root, path, withHelpStyle(req, w)); err != nil {
 			// This should not happen
 			panic(err)
 		}
@@ -72,7 +142,7 @@ func Run(ctx context.Context, root *cmds.Command,
 
 	// BEFORE handling the parse error, if we have enough information
 	// AND the user requested help, print it out and exit
-	err := HandleHelp(cmdline[0], req, stdout)
+	err = HandleHelp(cmdline[0], req, stdout)
 	if err == nil {
 		return nil
 	} else if err != ErrNoHelpRequested {
@@ -80,6 +150,21 @@ func Run(ctx context.Context, root *cmds.Command,
 	}
 	// no help requested, continue.
 
+	if h, ok := historyOf(root); ok {
+		start := time.Now()
+		defer func() {
+			e := HistoryEntry{
+				Time:     start,
+				Command:  append(cmdline[:1:1], h.redact(root, cmdline[1:])...),
+				Status:   ExitCode(err),
+				Duration: time.Since(start),
+			}
+			if err := h.record(e); err != nil {
+				log.Error("error recording command history", "error", err)
+			}
+		}()
+	}
+
 	// ok now handle parse error (which means cli input was wrong,
 	// e.g. incorrect number of args, or nonexistent subcommand)
 	if errParse != nil {
@@ -111,6 +196,12 @@ func Run(ctx context.Context, root *cmds.Command,
 	}
 	if c, ok := env.(Closer); ok {
 		defer c.Close()
+	} else {
+		defer func() {
+			if err := cmds.CloseEnvironment(env); err != nil {
+				printErr(err)
+			}
+		}()
 	}
 
 	exctr, err := makeExecutor(req, env)
@@ -132,6 +223,10 @@ func Run(ctx context.Context, root *cmds.Command,
 		printErr(err)
 		return err
 	}
+	if re, err = withMachineOutput(req, re); err != nil {
+		printErr(err)
+		return err
+	}
 
 	// Execute the command.
 	err = exctr.Execute(req, re, env)
@@ -143,7 +238,7 @@ func Run(ctx context.Context, root *cmds.Command,
 		if kiterr, ok := err.(*cmds.Error); ok {
 			err = *kiterr
 		}
-		if kiterr, ok := err.(cmds.Error); ok && kiterr.Code == cmds.ErrClient {
+		if errors.Is(err, cmds.ErrClient) {
 			printMetaHelp(stderr)
 		}
 
@@ -2,7 +2,10 @@ package cli
 
 import (
 	"context"
+	"errors"
+	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -58,3 +61,114 @@ func TestRunWaits(t *testing.T) {
 		t.Fatal("expected flag to be raised")
 	}
 }
+
+func TestRunExitCode(t *testing.T) {
+	root := &cmds.Command{
+		Subcommands: map[string]*cmds.Command{
+			"fail": {
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, e cmds.Environment) error {
+					return errors.New("failed")
+				},
+			},
+			"reject": {
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, e cmds.Environment) error {
+					return cmds.ClientError("rejected")
+				},
+			},
+			"copy": {
+				Arguments: []cmds.Argument{
+					cmds.StringArg("src", true, false, ""),
+					cmds.StringArg("dst", true, false, ""),
+				},
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, e cmds.Environment) error {
+					return nil
+				},
+			},
+		},
+	}
+
+	devnull, err := os.OpenFile(os.DevNull, os.O_RDWR, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devnull.Close()
+
+	for _, tc := range []struct {
+		cmdline []string
+		code    int
+	}{
+		{cmdline: []string{"fail"}, code: ExitFailure},
+		{cmdline: []string{"reject"}, code: ExitUsage},
+		{cmdline: []string{"fail", "--unknown"}, code: ExitUsage},
+		{cmdline: []string{"fail", "arg"}, code: ExitUsage},
+		{cmdline: []string{"missing"}, code: ExitNotFound},
+		{cmdline: []string{"copy"}, code: ExitUsage},
+	} {
+		err := Run(
+			context.Background(),
+			root,
+			append([]string{"test"}, tc.cmdline...),
+			devnull, devnull, devnull,
+			func(ctx context.Context, req *cmds.Request) (cmds.Environment, error) {
+				return nil, nil
+			},
+			func(req *cmds.Request, env interface{}) (cmds.Executor, error) {
+				return cmds.NewExecutor(req.Root), nil
+			},
+		)
+		if code := ExitCode(err); code != tc.code {
+			t.Errorf("%v: expected exit code %d, got %d (%v)", tc.cmdline, tc.code, code, err)
+		}
+	}
+}
+
+func TestRunValidationError(t *testing.T) {
+	root := &cmds.Command{
+		Subcommands: map[string]*cmds.Command{
+			"check": {
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, e cmds.Environment) error {
+					var verr cmds.ValidationError
+					verr.Add("a", "too long")
+					verr.Add("b", "too short")
+					return verr.Err()
+				},
+			},
+		},
+	}
+
+	devnull, err := os.OpenFile(os.DevNull, os.O_RDWR, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devnull.Close()
+	stderr, err := ioutil.TempFile("", "stderr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(stderr.Name())
+	defer stderr.Close()
+
+	err = Run(
+		context.Background(),
+		root,
+		[]string{"test", "check"},
+		devnull, devnull, stderr,
+		func(ctx context.Context, req *cmds.Request) (cmds.Environment, error) {
+			return nil, nil
+		},
+		func(req *cmds.Request, env interface{}) (cmds.Executor, error) {
+			return cmds.NewExecutor(req.Root), nil
+		},
+	)
+	if code := ExitCode(err); code != ExitUsage {
+		t.Errorf("expected exit code %d, got %d (%v)", ExitUsage, code, err)
+	}
+
+	out, err := ioutil.ReadFile(stderr.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := "Error: invalid request:\n  - a: too long\n  - b: too short\n"; !strings.HasPrefix(string(out), exp) {
+		t.Errorf("expected stderr to start with %q, got %q", exp, out)
+	}
+}
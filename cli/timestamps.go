@@ -0,0 +1,72 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/fgeth/fg-ipfs-cmds"
+)
+
+// TimestampFormat is the layout of the timestamps the timestamps option
+// prefixes output lines with.
+var TimestampFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// timestampWriter prefixes every line written to w with the time it starts.
+type timestampWriter struct {
+	w       io.Writer
+	midLine bool
+}
+
+func (tw *timestampWriter) Write(p []byte) (int, error) {
+	var n int
+	for len(p) > 0 {
+		if !tw.midLine {
+			if _, err := io.WriteString(tw.w, time.Now().Format(TimestampFormat)+" "); err != nil {
+				return n, err
+			}
+			tw.midLine = true
+		}
+
+		line := p
+		if i := bytes.IndexByte(p, '\n'); i >= 0 {
+			line = p[:i+1]
+		}
+		m, err := tw.w.Write(line)
+		n += m
+		if err != nil {
+			return n, err
+		}
+		if line[len(line)-1] == '\n' {
+			tw.midLine = false
+		}
+		p = p[len(line):]
+	}
+	return n, nil
+}
+
+// withTimestamps returns stdout prefixing lines with timestamps if req asks
+// for it.
+func withTimestamps(req *cmds.Request, stdout io.Writer) io.Writer {
+	if on, _ := req.Options[cmds.TimestampsOpt].(bool); on {
+		return &timestampWriter{w: stdout}
+	}
+	return stdout
+}
+
+// unwrapWriter returns the writer w writes to, e.g. to check whether it is a
+// terminal.
+func unwrapWriter(w io.Writer) io.Writer {
+	if tw, ok := w.(*timestampWriter); ok {
+		return tw.w
+	}
+	return w
+}
+
+// printDuration prints the time since start to w if req asks for it.
+func printDuration(req *cmds.Request, w io.Writer, start time.Time) {
+	if on, _ := req.Options[cmds.ShowDurationOpt].(bool); on {
+		fmt.Fprintln(w, "Took", time.Since(start).Round(time.Millisecond))
+	}
+}
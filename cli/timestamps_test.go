@@ -0,0 +1,81 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+func TestTimestamps(t *testing.T) {
+	root := &cmds.Command{
+		Options: []cmds.Option{cmds.OptionTimestamps, cmds.OptionShowDuration},
+		Subcommands: map[string]*cmds.Command{
+			"lines": {Type: ""},
+		},
+	}
+
+	type testcase struct {
+		opts   cmds.OptMap
+		emit   []interface{}
+		stdout *regexp.Regexp
+		stderr *regexp.Regexp
+	}
+
+	ts := `\d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{3}\S* `
+	tcs := []testcase{
+		{
+			emit:   []interface{}{"a", "b"},
+			stdout: regexp.MustCompile(`^a\nb\n$`),
+			stderr: regexp.MustCompile(`^$`),
+		},
+		{
+			opts:   cmds.OptMap{cmds.TimestampsOpt: true},
+			emit:   []interface{}{"a", "b\nc"},
+			stdout: regexp.MustCompile(`^` + ts + `a\n` + ts + `b\n` + ts + `c\n$`),
+			stderr: regexp.MustCompile(`^$`),
+		},
+		{
+			// lines written in pieces get one timestamp
+			opts:   cmds.OptMap{cmds.TimestampsOpt: true},
+			emit:   []interface{}{strings.NewReader("par"), strings.NewReader("tial\n")},
+			stdout: regexp.MustCompile(`^` + ts + `partial\n$`),
+			stderr: regexp.MustCompile(`^$`),
+		},
+		{
+			opts:   cmds.OptMap{cmds.ShowDurationOpt: true},
+			emit:   []interface{}{"a"},
+			stdout: regexp.MustCompile(`^a\n$`),
+			stderr: regexp.MustCompile(`^Took \d+(\.\d+)?m?s\n$`),
+		},
+	}
+
+	for i, tc := range tcs {
+		req, err := cmds.NewRequest(context.Background(), []string{"lines"}, tc.opts, nil, nil, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var stdout, stderr bytes.Buffer
+		re, err := NewResponseEmitter(&stdout, &stderr, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range tc.emit {
+			if err := re.Emit(v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		re.Close()
+
+		if !tc.stdout.MatchString(stdout.String()) {
+			t.Errorf("%d: unexpected stdout %q", i, stdout.String())
+		}
+		if !tc.stderr.MatchString(stderr.String()) {
+			t.Errorf("%d: unexpected stderr %q", i, stderr.String())
+		}
+	}
+}
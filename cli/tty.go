@@ -0,0 +1,18 @@
+//go:build !js
+// +build !js
+
+package cli
+
+import (
+	"os"
+)
+
+func isTty(f *os.File) (bool, error) {
+	fInfo, err := f.Stat()
+	if err != nil {
+		log.Error("could not stat file", "file", f.Name(), "error", err)
+		return false, err
+	}
+
+	return (fInfo.Mode() & os.ModeCharDevice) != 0, nil
+}
@@ -0,0 +1,16 @@
+package cli
+
+import (
+	"os"
+)
+
+// isTty reports whether f is a terminal. In browsers files can't be stat'ed;
+// they are never terminals there.
+func isTty(f *os.File) (bool, error) {
+	fInfo, err := f.Stat()
+	if err != nil {
+		return false, nil
+	}
+
+	return (fInfo.Mode() & os.ModeCharDevice) != 0, nil
+}
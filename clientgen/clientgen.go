@@ -0,0 +1,606 @@
+// Package clientgen generates typed Go clients of the HTTP API serving a
+// command tree, so programs consuming the API of a daemon call methods
+// rather than build requests by hand.
+//
+// The generated package has a method per command that can be run remotely,
+// taking the arguments of the command and a struct of its options, and
+// returning the values the command emits as its Type. It embeds a copy of
+// the tree without Run functions, so requests are checked as they are by the
+// server, and runs them with the client of the http package. Applications
+// generate it from a program importing their command tree, e.g. with
+// go:generate:
+//
+//	src, err := clientgen.Generate(app.RootCmd, clientgen.Config{Package: "client"})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	err = ioutil.WriteFile("client/client.go", src, 0644)
+//
+// The types of the values of commands must be importable: commands whose
+// Type is declared in a main package, or isn't a named type, a pointer to
+// one or a slice or map of them, return values as interface{}.
+//
+// GenerateTypeScript generates clients for web UIs in TypeScript the same
+// way.
+package clientgen
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+// Config configures the generated package.
+type Config struct {
+	// Package is the name of the generated package.
+	Package string
+	// Generator names the program generating the package in the header
+	// of the file, so readers know how to update it. It defaults to
+	// clientgen.
+	Generator string
+}
+
+// Generate returns the source of the client package of the commands of
+// root, formatted with gofmt.
+func Generate(root *cmds.Command, cfg Config) ([]byte, error) {
+	if !token.IsIdentifier(cfg.Package) {
+		return nil, fmt.Errorf("invalid package name %q", cfg.Package)
+	}
+	if cfg.Generator == "" {
+		cfg.Generator = "clientgen"
+	}
+
+	g := &generator{
+		imports: make(map[string]string),
+		names:   make(map[string]bool),
+	}
+	for _, name := range reservedNames {
+		g.names[name] = true
+	}
+	if err := g.collect(root); err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	g.writeTree(&body, root)
+	for _, c := range g.cmds {
+		g.writeCommand(&body, c)
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Code generated by %s. DO NOT EDIT.\n\n", cfg.Generator)
+	fmt.Fprintf(&out, "// Package %s is a client of the HTTP API of the commands.\n", cfg.Package)
+	fmt.Fprintf(&out, "package %s\n\n", cfg.Package)
+	g.writeImports(&out)
+	out.WriteString(clientSource)
+	out.Write(body.Bytes())
+
+	src, err := format.Source(out.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("formatting the generated client: %w", err)
+	}
+	return src, nil
+}
+
+// reservedNames are the names of the generated package that don't depend
+// on the commands, which import names must not shadow.
+var reservedNames = []string{"cmds", "cmdshttp", "context", "fmt", "Client", "NewClient", "NewClientWithExecutor", "root"}
+
+// clientSource is the part of the generated package that doesn't depend on
+// the commands.
+const clientSource = `
+// Client runs the commands of the API.
+type Client struct {
+	exe cmds.Executor
+}
+
+// NewClient returns a client of the API served at address.
+func NewClient(address string, opts ...cmdshttp.ClientOpt) *Client {
+	return NewClientWithExecutor(cmdshttp.NewClient(address, opts...))
+}
+
+// NewClientWithExecutor returns a client running the commands with exe,
+// e.g. one returned by cmds.NewLocalClient in tests.
+func NewClientWithExecutor(exe cmds.Executor) *Client {
+	return &Client{exe: exe}
+}
+
+// call runs the command at path, and returns its response. Errors running
+// it are returned by the Next method of the response.
+func (c *Client) call(ctx context.Context, path []string, opts cmds.OptMap, args []string, files cmds.Directory) (cmds.Response, error) {
+	req, err := cmds.NewRequest(ctx, path, opts, args, files, root)
+	if err != nil {
+		return nil, err
+	}
+	re, res := cmds.NewChanResponsePair(req)
+	go func() {
+		// the emitter is closed already, unless Execute failed early
+		_ = re.CloseWithError(c.exe.Execute(req, re, nil))
+	}()
+	return res, nil
+}
+
+// unexpected returns the error about a value of the wrong type.
+func unexpected(v interface{}) error {
+	return fmt.Errorf("unexpected value of type %T", v)
+}
+`
+
+type generator struct {
+	cmds []*command
+
+	// imports maps the paths of the packages imported to their names.
+	imports map[string]string
+	// names are the identifiers taken at the top level of the package.
+	names map[string]bool
+}
+
+// command is a command of the generated client.
+type command struct {
+	path []string
+	cmd  *cmds.Command
+	name string
+
+	// options are those of the command and its parents.
+	options []cmds.Option
+	// fields are the names of the fields of the options.
+	fields []string
+
+	args  []arg
+	files bool
+
+	// result is the type of the values returned, and value the one of
+	// the values the client decodes, see Response.Next of the http
+	// package. Both are empty if the values are returned as interface{}.
+	result, value string
+}
+
+type arg struct {
+	name     string
+	param    string
+	variadic bool
+}
+
+// collect adds the commands of the tree below root to g.cmds.
+func (g *generator) collect(root *cmds.Command) error {
+	return walk(nil, root, nil, func(pth []string, cmd *cmds.Command, opts []cmds.Option) error {
+		c, err := g.newCommand(pth, cmd, opts)
+		if err != nil {
+			return err
+		}
+		g.cmds = append(g.cmds, c)
+		return nil
+	})
+}
+
+// walk calls fn with the commands of the tree below cmd, at pth, that can
+// be run remotely, sorted by path, and the options they accept. parentOpts
+// are the options cmd inherits.
+func walk(pth []string, cmd *cmds.Command, parentOpts []cmds.Option, fn func(pth []string, cmd *cmds.Command, opts []cmds.Option) error) error {
+	if cmd.NoRemote {
+		return nil
+	}
+	opts := append(parentOpts[:len(parentOpts):len(parentOpts)], cmd.Options...)
+
+	if cmd.Run != nil {
+		if err := fn(pth, cmd, opts); err != nil {
+			return err
+		}
+	}
+
+	names := make([]string, 0, len(cmd.Subcommands))
+	for name := range cmd.Subcommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := walk(append(pth[:len(pth):len(pth)], name), cmd.Subcommands[name], opts, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *generator) newCommand(pth []string, cmd *cmds.Command, opts []cmds.Option) (*command, error) {
+	name := "Root"
+	if len(pth) > 0 {
+		name = exportedName(strings.Join(pth, " "))
+	}
+	for _, n := range []string{name, name + "Options", name + "Response"} {
+		if g.names[n] {
+			return nil, fmt.Errorf("command %q: name %s is taken", strings.Join(pth, " "), n)
+		}
+		g.names[n] = true
+	}
+
+	c := &command{path: pth, cmd: cmd, name: name}
+
+	seen := make(map[string]bool)
+	for _, opt := range opts {
+		if _, ok := optionKinds[opt.Type()]; !ok || seen[opt.Name()] {
+			continue
+		}
+		field := exportedName(opt.Name())
+		if field == "" || seen[field] {
+			continue
+		}
+		seen[opt.Name()], seen[field] = true, true
+		c.options = append(c.options, opt)
+		c.fields = append(c.fields, field)
+	}
+
+	params := map[string]bool{"ctx": true, "opts": true, "files": true, "c": true}
+	for _, a := range cmd.Arguments {
+		if a.Type == cmds.ArgFile {
+			c.files = true
+			continue
+		}
+		param := unexportedName(a.Name)
+		for params[param] || token.IsKeyword(param) || param == "" {
+			param += "Arg"
+		}
+		params[param] = true
+		c.args = append(c.args, arg{name: a.Name, param: param, variadic: a.Variadic})
+	}
+
+	if cmd.Type != nil {
+		typ := reflect.TypeOf(cmd.Type)
+		if typ.Kind() == reflect.Ptr {
+			typ = typ.Elem()
+		}
+		if expr, ok := g.typeExpr(typ); ok {
+			c.value = "*" + expr
+			c.result = expr
+			if typ.Kind() == reflect.Struct {
+				c.result = "*" + expr
+			}
+		}
+	}
+	return c, nil
+}
+
+// typeExpr returns the expression of typ in the generated package, adding
+// the imports it needs, or false if it can't be referred to.
+func (g *generator) typeExpr(typ reflect.Type) (string, bool) {
+	if typ.Name() != "" {
+		if typ.PkgPath() == "" {
+			// predeclared
+			return typ.Name(), true
+		}
+		if typ.PkgPath() == "main" || !token.IsExported(typ.Name()) {
+			return "", false
+		}
+		return g.importName(typ.PkgPath()) + "." + typ.Name(), true
+	}
+
+	switch typ.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		elem, ok := g.typeExpr(typ.Elem())
+		if !ok {
+			return "", false
+		}
+		switch typ.Kind() {
+		case reflect.Ptr:
+			return "*" + elem, true
+		case reflect.Slice:
+			return "[]" + elem, true
+		default:
+			return fmt.Sprintf("[%d]%s", typ.Len(), elem), true
+		}
+	case reflect.Map:
+		key, ok := g.typeExpr(typ.Key())
+		if !ok {
+			return "", false
+		}
+		elem, ok := g.typeExpr(typ.Elem())
+		if !ok {
+			return "", false
+		}
+		return "map[" + key + "]" + elem, true
+	case reflect.Interface:
+		if typ.NumMethod() == 0 {
+			return "interface{}", true
+		}
+	}
+	return "", false
+}
+
+// importName returns the name the package at pkgPath is imported as.
+func (g *generator) importName(pkgPath string) string {
+	if name, ok := g.imports[pkgPath]; ok {
+		return name
+	}
+
+	base := path.Base(pkgPath)
+	if len(base) > 1 && base[0] == 'v' && strings.Trim(base[1:], "0123456789") == "" {
+		// major version suffix
+		base = path.Base(path.Dir(pkgPath))
+	}
+	base = strings.TrimPrefix(base, "go-")
+	name := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, base)
+	if name == "" || !unicode.IsLetter(rune(name[0])) {
+		name = "pkg" + name
+	}
+
+	unique := name
+	for i := 2; g.names[unique] || token.IsKeyword(unique); i++ {
+		unique = name + strconv.Itoa(i)
+	}
+	g.names[unique] = true
+	g.imports[pkgPath] = unique
+	return unique
+}
+
+func (g *generator) writeImports(w *bytes.Buffer) {
+	w.WriteString("import (\n\t\"context\"\n\t\"fmt\"\n\n")
+	w.WriteString("\tcmds \"github.com/fgeth/fg-ipfs-cmds\"\n")
+	w.WriteString("\tcmdshttp \"github.com/fgeth/fg-ipfs-cmds/http\"\n")
+
+	paths := make([]string, 0, len(g.imports))
+	for p := range g.imports {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	if len(paths) > 0 {
+		w.WriteString("\n")
+	}
+	for _, p := range paths {
+		fmt.Fprintf(w, "\t%s %q\n", g.imports[p], p)
+	}
+	w.WriteString(")\n")
+}
+
+// optionKinds are the functions declaring options of the kinds the client
+// supports, and the types of the fields of their values.
+var optionKinds = map[reflect.Kind]struct{ fn, typ string }{
+	cmds.Bool:    {"BoolOption", "*bool"},
+	cmds.Int:     {"IntOption", "*int"},
+	cmds.Uint:    {"UintOption", "*uint"},
+	cmds.Int64:   {"Int64Option", "*int64"},
+	cmds.Uint64:  {"Uint64Option", "*uint64"},
+	cmds.Float:   {"FloatOption", "*float64"},
+	cmds.String:  {"StringOption", "*string"},
+	cmds.Strings: {"StringsOption", "[]string"},
+}
+
+// writeTree writes root, the copy of the tree requests are checked against:
+// the commands, with their options, arguments and types.
+func (g *generator) writeTree(w *bytes.Buffer, root *cmds.Command) {
+	w.WriteString("\n// root is the command tree of the API, without the functions running\n// the commands.\nvar root = ")
+	g.writeCommandTree(w, root, true)
+	w.WriteString("\n")
+}
+
+func (g *generator) writeCommandTree(w *bytes.Buffer, cmd *cmds.Command, top bool) {
+	if top {
+		w.WriteString("&cmds.Command")
+	}
+	w.WriteString("{\n")
+
+	var opts []string
+	for _, opt := range cmd.Options {
+		kind, ok := optionKinds[opt.Type()]
+		if !ok {
+			continue
+		}
+		var args []string
+		for _, name := range opt.Names() {
+			args = append(args, strconv.Quote(name))
+		}
+		args = append(args, strconv.Quote(opt.Description()))
+		opts = append(opts, fmt.Sprintf("cmds.%s(%s)", kind.fn, strings.Join(args, ", ")))
+	}
+	if len(opts) > 0 {
+		fmt.Fprintf(w, "Options: []cmds.Option{\n%s,\n},\n", strings.Join(opts, ",\n"))
+	}
+
+	if len(cmd.Arguments) > 0 {
+		w.WriteString("Arguments: []cmds.Argument{\n")
+		for _, a := range cmd.Arguments {
+			fn := "StringArg"
+			if a.Type == cmds.ArgFile {
+				fn = "FileArg"
+			}
+			fmt.Fprintf(w, "cmds.%s(%q, %t, %t, \"\"),\n", fn, a.Name, a.Required, a.Variadic)
+		}
+		w.WriteString("},\n")
+	}
+
+	if cmd.Run != nil {
+		// a command without a Run function can't be called
+		w.WriteString("Run: func(*cmds.Request, cmds.ResponseEmitter, cmds.Environment) error { return nil },\n")
+	}
+	if cmd.Type != nil {
+		typ := reflect.TypeOf(cmd.Type)
+		base := typ
+		if base.Kind() == reflect.Ptr {
+			base = base.Elem()
+		}
+		if expr, ok := g.typeExpr(base); ok {
+			fmt.Fprintf(w, "Type: new(%s),\n", expr)
+		}
+	}
+
+	var subs []string
+	for name, sub := range cmd.Subcommands {
+		if !sub.NoRemote {
+			subs = append(subs, name)
+		}
+	}
+	sort.Strings(subs)
+	if len(subs) > 0 {
+		w.WriteString("Subcommands: map[string]*cmds.Command{\n")
+		for _, name := range subs {
+			fmt.Fprintf(w, "%q: ", name)
+			g.writeCommandTree(w, cmd.Subcommands[name], false)
+			w.WriteString(",\n")
+		}
+		w.WriteString("},\n")
+	}
+
+	w.WriteString("}")
+}
+
+func (g *generator) writeCommand(w *bytes.Buffer, c *command) {
+	cmdline := strings.Join(c.path, " ")
+	if cmdline == "" {
+		cmdline = "the root command"
+	}
+
+	if len(c.options) > 0 {
+		fmt.Fprintf(w, "\n// %sOptions are the options of %s. Options left nil aren't sent.\n", c.name, cmdline)
+		fmt.Fprintf(w, "type %sOptions struct {\n", c.name)
+		for i, opt := range c.options {
+			if desc := docLine(opt.Description()); desc != "" {
+				fmt.Fprintf(w, "// %s: %s\n", c.fields[i], desc)
+			}
+			fmt.Fprintf(w, "%s %s\n", c.fields[i], optionKinds[opt.Type()].typ)
+		}
+		w.WriteString("}\n")
+	}
+
+	// the method
+	var params []string
+	params = append(params, "ctx context.Context")
+	for _, a := range c.args {
+		typ := "string"
+		if a.variadic {
+			typ = "[]string"
+		}
+		params = append(params, a.param+" "+typ)
+	}
+	if c.files {
+		params = append(params, "files cmds.Directory")
+	}
+	if len(c.options) > 0 {
+		params = append(params, fmt.Sprintf("opts *%sOptions", c.name))
+	}
+
+	fmt.Fprintf(w, "\n// %s runs %s.", c.name, cmdline)
+	if desc := docLine(c.cmd.Helptext.Tagline); desc != "" {
+		fmt.Fprintf(w, " %s", strings.TrimSuffix(desc, ".")+".")
+	}
+	for _, a := range c.args {
+		if !a.variadic {
+			w.WriteString(" Empty arguments aren't sent.")
+			break
+		}
+	}
+	w.WriteString("\n")
+	fmt.Fprintf(w, "func (c *Client) %s(%s) (*%sResponse, error) {\n", c.name, strings.Join(params, ", "), c.name)
+
+	w.WriteString("var args []string\n")
+	for _, a := range c.args {
+		if a.variadic {
+			fmt.Fprintf(w, "args = append(args, %s...)\n", a.param)
+		} else {
+			fmt.Fprintf(w, "if %s != \"\" {\nargs = append(args, %s)\n}\n", a.param, a.param)
+		}
+	}
+
+	w.WriteString("optMap := cmds.OptMap{}\n")
+	if len(c.options) > 0 {
+		w.WriteString("if opts != nil {\n")
+		for i, opt := range c.options {
+			value := "*opts." + c.fields[i]
+			if opt.Type() == cmds.Strings {
+				value = "opts." + c.fields[i]
+			}
+			fmt.Fprintf(w, "if opts.%s != nil {\noptMap[%q] = %s\n}\n", c.fields[i], opt.Name(), value)
+		}
+		w.WriteString("}\n")
+	}
+
+	files := "nil"
+	if c.files {
+		files = "files"
+	}
+	var quoted []string
+	for _, p := range c.path {
+		quoted = append(quoted, strconv.Quote(p))
+	}
+	fmt.Fprintf(w, "res, err := c.call(ctx, []string{%s}, optMap, args, %s)\n", strings.Join(quoted, ", "), files)
+	w.WriteString("if err != nil {\nreturn nil, err\n}\n")
+	fmt.Fprintf(w, "return &%sResponse{res: res}, nil\n}\n", c.name)
+
+	// the response
+	result := c.result
+	if result == "" {
+		result = "interface{}"
+	}
+	fmt.Fprintf(w, "\n// %sResponse is the response of %s.\n", c.name, cmdline)
+	fmt.Fprintf(w, "type %sResponse struct {\nres cmds.Response\n}\n", c.name)
+	fmt.Fprintf(w, "\n// Next returns the next value of the response, and io.EOF after the\n// last one.\n")
+	fmt.Fprintf(w, "func (r *%sResponse) Next() (%s, error) {\n", c.name, result)
+	w.WriteString("v, err := r.res.Next()\n")
+	if c.result == "" {
+		w.WriteString("return v, err\n}\n")
+	} else {
+		zero := "nil"
+		if !strings.HasPrefix(c.result, "*") {
+			fmt.Fprintf(w, "var zero %s\n", c.result)
+			zero = "zero"
+		}
+		fmt.Fprintf(w, "if err != nil {\nreturn %s, err\n}\n", zero)
+		w.WriteString("switch v := v.(type) {\n")
+		if c.result == c.value {
+			// pointers to structs are returned as they are
+			fmt.Fprintf(w, "case %s:\nreturn v, nil\n", c.value)
+			fmt.Fprintf(w, "case %s:\nreturn &v, nil\n", strings.TrimPrefix(c.value, "*"))
+		} else {
+			fmt.Fprintf(w, "case %s:\nreturn *v, nil\n", c.value)
+			fmt.Fprintf(w, "case %s:\nreturn v, nil\n", c.result)
+		}
+		fmt.Fprintf(w, "default:\nreturn %s, unexpected(v)\n}\n}\n", zero)
+	}
+
+	fmt.Fprintf(w, "\n// Response returns the underlying response, e.g. for its warnings.\n")
+	fmt.Fprintf(w, "func (r *%sResponse) Response() cmds.Response {\nreturn r.res\n}\n", c.name)
+}
+
+// exportedName returns name in CamelCase, e.g. PinAdd for "pin add" and
+// StreamChannels for stream-channels.
+func exportedName(name string) string {
+	var b strings.Builder
+	for _, part := range strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	s := b.String()
+	if s != "" && !unicode.IsLetter(rune(s[0])) {
+		s = "X" + s
+	}
+	return s
+}
+
+// unexportedName returns name in camelCase.
+func unexportedName(name string) string {
+	s := exportedName(name)
+	if s == "" {
+		return s
+	}
+	return strings.ToLower(s[:1]) + s[1:]
+}
+
+// docLine returns the first line of s, for doc comments.
+func docLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = s[:i]
+	}
+	return strings.TrimSpace(s)
+}
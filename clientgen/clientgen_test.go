@@ -0,0 +1,157 @@
+package clientgen
+
+import (
+	"bytes"
+	"context"
+	"go/parser"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+	"github.com/fgeth/fg-ipfs-cmds/examples/adder"
+	"github.com/fgeth/fg-ipfs-cmds/examples/adder/client"
+	cmdshttp "github.com/fgeth/fg-ipfs-cmds/http"
+)
+
+func TestGenerateAdder(t *testing.T) {
+	src, err := Generate(adder.RootCmd, Config{
+		Package:   "client",
+		Generator: "github.com/fgeth/fg-ipfs-cmds/examples/adder/gen",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp, err := ioutil.ReadFile("../examples/adder/client/client.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(src, exp) {
+		t.Error("the client of the adder example is outdated, run go generate in examples/adder/client")
+	}
+}
+
+func TestGeneratedClient(t *testing.T) {
+	srv := httptest.NewServer(cmdshttp.NewHandler(nil, adder.RootCmd, cmdshttp.NewServerConfig()))
+	defer srv.Close()
+
+	for name, c := range map[string]*client.Client{
+		"local": client.NewClientWithExecutor(cmds.NewLocalClient(adder.RootCmd, nil)),
+		"http":  client.NewClient(srv.URL),
+	} {
+		res, err := c.EncodeAdd(context.Background(), []string{"1", "2"})
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		var got []adder.AddStatus
+		for {
+			v, err := res.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%s: %s", name, err)
+			}
+			got = append(got, *v)
+		}
+		exp := []adder.AddStatus{{Current: 1, Left: 1}, {Current: 3, Left: 0}}
+		if len(got) != len(exp) || got[0] != exp[0] || got[1] != exp[1] {
+			t.Errorf("%s: expected %v, got %v", name, exp, got)
+		}
+
+		// errors of the command are returned by Next
+		res, err = c.EncodeAdd(context.Background(), []string{"x"})
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if _, err := res.Next(); err == nil || err == io.EOF {
+			t.Errorf("%s: expected the error of the command, got %v", name, err)
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	run := func(*cmds.Request, cmds.ResponseEmitter, cmds.Environment) error { return nil }
+	root := &cmds.Command{
+		Options: []cmds.Option{
+			cmds.BoolOption("verbose", "v", "print more"),
+			cmds.OptionEncodingType,
+		},
+		Subcommands: map[string]*cmds.Command{
+			"files": {
+				Subcommands: map[string]*cmds.Command{
+					"ls": {
+						Options: []cmds.Option{
+							cmds.StringsOption("filter", "only list these"),
+							cmds.IntOption("max-depth", "how deep to list"),
+						},
+						Arguments: []cmds.Argument{
+							cmds.StringArg("type", true, false, "the type of the entries"),
+						},
+						Run:  run,
+						Type: time.Time{},
+					},
+					"add": {
+						Arguments: []cmds.Argument{
+							cmds.FileArg("file", true, true, "the files to add"),
+						},
+						Run:  run,
+						Type: []int{},
+					},
+				},
+			},
+			"info": {
+				Run:  run,
+				Type: struct{ ID string }{},
+			},
+			"local": {
+				Run:      run,
+				NoRemote: true,
+			},
+		},
+	}
+
+	src, err := Generate(root, Config{Package: "api"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parser.ParseFile(token.NewFileSet(), "api.go", src, 0); err != nil {
+		t.Fatalf("the generated source doesn't parse: %s", err)
+	}
+
+	for _, exp := range []string{
+		"// Code generated by clientgen. DO NOT EDIT.",
+		"package api",
+		`"time"`,
+		"func (c *Client) FilesLs(ctx context.Context, typeArg string, opts *FilesLsOptions) (*FilesLsResponse, error)",
+		"Filter []string",
+		"MaxDepth *int",
+		"Verbose *bool",
+		"func (r *FilesLsResponse) Next() (*time.Time, error)",
+		"func (c *Client) FilesAdd(ctx context.Context, files cmds.Directory, opts *FilesAddOptions) (*FilesAddResponse, error)",
+		"func (r *FilesAddResponse) Next() ([]int, error)",
+		// unnamed structs can't be referred to
+		"func (r *InfoResponse) Next() (interface{}, error)",
+	} {
+		if !strings.Contains(string(src), exp) {
+			t.Errorf("expected the generated source to contain %q", exp)
+		}
+	}
+	if strings.Contains(string(src), "Local(") {
+		t.Error("the generated source has a method for a NoRemote command")
+	}
+
+	if _, err := Generate(root, Config{Package: "my-api"}); err == nil {
+		t.Error("expected an error for an invalid package name")
+	}
+
+	// commands whose names collide
+	root.Subcommands["files-ls"] = &cmds.Command{Run: run}
+	if _, err := Generate(root, Config{Package: "api"}); err == nil {
+		t.Error("expected an error for colliding command names")
+	}
+}
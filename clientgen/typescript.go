@@ -0,0 +1,769 @@
+package clientgen
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+	cmdshttp "github.com/fgeth/fg-ipfs-cmds/http"
+)
+
+// TypeScriptConfig configures the generated TypeScript client.
+type TypeScriptConfig struct {
+	// Generator names the program generating the client in the header of
+	// the file, so readers know how to update it. It defaults to
+	// clientgen.
+	Generator string
+}
+
+// GenerateTypeScript returns the source of a TypeScript client of the HTTP
+// API serving the commands of root, so web UIs call methods rather than
+// reverse-engineer the wire format. The client sends requests with fetch,
+// and parses the JSON streams of responses into values of interfaces
+// declared after the Go types of the commands, their output types, progress
+// reports and warnings included. It needs no dependencies, and runs in
+// browsers as well as in Node.js 18 and later.
+//
+// Errors of commands that already emitted values are thrown as the stream
+// is read, the server sends them in the stream to clients speaking version 2
+// of the protocol. Cross-origin UIs need the server to allow the
+// X-Protocol-Version request header.
+func GenerateTypeScript(root *cmds.Command, cfg TypeScriptConfig) ([]byte, error) {
+	if cfg.Generator == "" {
+		cfg.Generator = "clientgen"
+	}
+
+	g := &tsGenerator{
+		types:   make(map[reflect.Type]string),
+		names:   make(map[string]bool),
+		methods: make(map[string]bool),
+	}
+	for _, name := range tsReservedNames {
+		g.names[name] = true
+	}
+	for _, name := range []string{"call", "constructor"} {
+		g.methods[name] = true
+	}
+	// the types of the frames the client handles itself
+	g.declareAs(reflect.TypeOf(cmds.Progress{}), "Progress")
+	g.declareAs(reflect.TypeOf(cmds.Trailer{}), "Trailer")
+
+	var methods bytes.Buffer
+	err := walk(nil, root, nil, func(pth []string, cmd *cmds.Command, opts []cmds.Option) error {
+		return g.writeMethod(&methods, pth, cmd, opts)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Code generated by %s. DO NOT EDIT.\n", cfg.Generator)
+	out.WriteString(tsClientSource)
+	g.writeDecls(&out)
+	out.WriteString(tsClientClass)
+	out.Write(methods.Bytes())
+	out.WriteString("}\n")
+	return out.Bytes(), nil
+}
+
+// tsReservedNames are the names of the generated module that don't depend
+// on the commands.
+var tsReservedNames = []string{
+	"CommandError", "FieldError", "Output", "FileEntry", "ClientOptions", "CallOptions",
+	"OptionValue", "Stream", "Client", "responseError",
+	// globals the client uses
+	"Array", "Blob", "Error", "FormData", "Number", "Object", "Promise",
+	"Record", "Response", "String", "TextDecoder", "URLSearchParams",
+}
+
+// tsKeywords are the reserved words of TypeScript, which parameters can't
+// be named after.
+var tsKeywords = map[string]bool{
+	"arguments": true, "await": true, "break": true, "case": true,
+	"catch": true, "class": true, "const": true, "continue": true,
+	"debugger": true, "default": true, "delete": true, "do": true,
+	"else": true, "enum": true, "eval": true, "export": true,
+	"extends": true, "false": true, "finally": true, "for": true,
+	"function": true, "if": true, "implements": true, "import": true,
+	"in": true, "instanceof": true, "interface": true, "let": true,
+	"new": true, "null": true, "package": true, "private": true,
+	"protected": true, "public": true, "return": true, "static": true,
+	"super": true, "switch": true, "this": true, "throw": true,
+	"true": true, "try": true, "typeof": true, "var": true, "void": true,
+	"while": true, "with": true, "yield": true,
+}
+
+// tsClientSource is the part of the client that doesn't depend on the
+// commands, but for the class of the client.
+const tsClientSource = `
+/** The error of a command, or of the server running it. */
+export class CommandError extends Error {
+  /** The cmds.ErrorType of the error, e.g. 1 for client errors. */
+  code: number;
+  details?: unknown;
+  helpURL?: string;
+  /** The problems with the options and arguments of invalid requests. */
+  fields?: FieldError[];
+
+  constructor(message: string, code: number, details?: unknown, helpURL?: string, fields?: FieldError[]) {
+    super(message);
+    this.name = "CommandError";
+    this.code = code;
+    this.details = details;
+    this.helpURL = helpURL;
+    this.fields = fields;
+  }
+}
+
+/** A problem with an option or argument of a request. */
+export interface FieldError {
+  Field: string;
+  Message: string;
+}
+
+/** A value of one of the output types of a command. */
+export interface Output<N extends string, T> {
+  output: N;
+  value: T;
+}
+
+/** A file sent to a command taking files. */
+export interface FileEntry {
+  name: string;
+  content: Blob | string;
+}
+
+export interface ClientOptions {
+  /** The prefix of the paths of the API, e.g. /api/v0. */
+  apiPrefix?: string;
+  /** The function requests are sent with, the global fetch by default. */
+  fetch?: typeof fetch;
+  /** Headers added to every request, e.g. for authentication. */
+  headers?: Record<string, string>;
+}
+
+export interface CallOptions {
+  /** Aborts the request, and the stream of its response. */
+  signal?: AbortSignal;
+  /** Called with the progress reports of the command. */
+  onProgress?: (progress: Progress) => void;
+}
+
+type OptionValue = boolean | number | string | string[] | undefined;
+
+/**
+ * The values a command emits, read with for await. The warnings and the
+ * trailer of the command are set as the values are read.
+ */
+export class Stream<T> implements AsyncIterable<T> {
+  /** The warnings of the command. */
+  readonly warnings: string[] = [];
+  /** The trailer sent after the last value, if any. */
+  trailer?: Trailer;
+  /** The HTTP response, e.g. for its headers, or for its body if it isn't JSON. */
+  readonly response: Response;
+  private readonly onProgress?: (progress: Progress) => void;
+
+  constructor(response: Response, onProgress?: (progress: Progress) => void) {
+    this.response = response;
+    this.onProgress = onProgress;
+    // warnings sent before the response started, joined by fetch
+    const warning = response.headers.get("X-Command-Warning");
+    if (warning !== null) {
+      this.warnings.push(warning);
+    }
+  }
+
+  async *[Symbol.asyncIterator](): AsyncIterator<T> {
+    const body = this.response.body;
+    if (body === null) {
+      return;
+    }
+    if (!(this.response.headers.get("Content-Type") ?? "").startsWith("application/json")) {
+      throw new CommandError("the response isn't a JSON stream, read it from the body of the response", 0);
+    }
+
+    const reader = body.getReader();
+    const decoder = new TextDecoder();
+    let buf = "";
+    try {
+      for (;;) {
+        const { done, value } = await reader.read();
+        buf += done ? decoder.decode() : decoder.decode(value, { stream: true });
+        const lines = buf.split("\n");
+        buf = done ? "" : lines.pop() ?? "";
+        for (const line of lines) {
+          if (line.trim() === "") {
+            continue;
+          }
+          const frame = this.frame(JSON.parse(line));
+          if (frame !== undefined) {
+            yield frame.value as T;
+          }
+        }
+        if (done) {
+          return;
+        }
+      }
+    } finally {
+      await reader.cancel().catch(() => undefined);
+    }
+  }
+
+  /** Returns all the values of the command. */
+  async all(): Promise<T[]> {
+    const values: T[] = [];
+    for await (const v of this) {
+      values.push(v);
+    }
+    return values;
+  }
+
+  // frame handles the frames of the stream that aren't values, and returns
+  // the others.
+  private frame(v: unknown): { value: unknown } | undefined {
+    if (typeof v !== "object" || v === null || Array.isArray(v)) {
+      return { value: v };
+    }
+    const f = v as Record<string, unknown>;
+    switch (f.Type) {
+      case "error":
+        throw new CommandError(String(f.Message), Number(f.Code), f.Details, f.HelpURL as string | undefined, f.Fields as FieldError[] | undefined);
+      case "warning":
+        this.warnings.push(String(f.Message));
+        return undefined;
+      case "trailer":
+        this.trailer = f as unknown as Trailer;
+        return undefined;
+      case "heartbeat":
+        return undefined;
+      case "progress":
+        this.onProgress?.(f as Progress);
+        return undefined;
+      case "output":
+        if (typeof f.Name === "string") {
+          return { value: { output: f.Name, value: f.Value } };
+        }
+    }
+    return { value: v };
+  }
+}
+
+async function responseError(response: Response): Promise<CommandError> {
+  const text = await response.text();
+  try {
+    const e = JSON.parse(text);
+    if (typeof e?.Message === "string") {
+      return new CommandError(e.Message, Number(e.Code) || 0, e.Details, e.HelpURL, e.Fields);
+    }
+  } catch {
+    // not an error of the commands
+  }
+  return new CommandError(text.trim() || response.statusText, 0);
+}
+`
+
+// tsClientClass starts the class of the client, which the methods of the
+// commands end.
+const tsClientClass = `
+/** A client of the API, with a method per command. */
+export class Client {
+  private readonly address: string;
+  private readonly options: ClientOptions;
+
+  /** Returns a client of the API served at address, e.g. http://127.0.0.1:5001. */
+  constructor(address: string, options: ClientOptions = {}) {
+    this.address = address.replace(/\/+$/, "");
+    this.options = options;
+  }
+
+  private async call<T>(
+    path: string[],
+    args: string[],
+    options: Record<string, OptionValue>,
+    files: FileEntry[] | undefined,
+    init: CallOptions,
+  ): Promise<Stream<T>> {
+    const query = new URLSearchParams({ encoding: "json", "stream-channels": "true" });
+    for (const [name, value] of Object.entries(options)) {
+      if (Array.isArray(value)) {
+        value.forEach((v) => query.append(name, v));
+      } else if (value !== undefined) {
+        query.set(name, String(value));
+      }
+    }
+    args.forEach((arg) => query.append("arg", arg));
+
+    let body: FormData | undefined;
+    if (files !== undefined) {
+      body = new FormData();
+      for (const file of files) {
+        const content = typeof file.content === "string" ? new Blob([file.content]) : file.content;
+        body.append("file", content, encodeURIComponent(file.name));
+      }
+    }
+
+    const fetchFn = this.options.fetch ?? fetch;
+    const prefix = this.options.apiPrefix ?? "";
+    const url = this.address + prefix + "/" + path.map(encodeURIComponent).join("/") + "?" + query.toString();
+    const response = await fetchFn(url, {
+      method: "POST",
+      headers: { ...this.options.headers, "X-Protocol-Version": "2" },
+      body,
+      signal: init.signal,
+    });
+    if (!response.ok) {
+      throw await responseError(response);
+    }
+    return new Stream<T>(response, init.onProgress);
+  }
+`
+
+// tsSkipOptions are the options the client sets itself, which it doesn't let
+// callers set, like the cmds.ClientSideOptions.
+var tsSkipOptions = map[string]bool{
+	cmds.EncLong: true,
+	cmds.ChanOpt: true,
+}
+
+// tsOptionTypes are the types of the values of the options of the kinds the
+// client supports.
+var tsOptionTypes = map[reflect.Kind]string{
+	cmds.Bool:    "boolean",
+	cmds.Int:     "number",
+	cmds.Uint:    "number",
+	cmds.Int64:   "number",
+	cmds.Uint64:  "number",
+	cmds.Float:   "number",
+	cmds.String:  "string",
+	cmds.Strings: "string[]",
+}
+
+type tsGenerator struct {
+	// types maps the Go types declared as interfaces to their names, and
+	// pending are those whose declarations aren't written yet.
+	types   map[reflect.Type]string
+	pending []reflect.Type
+
+	// names are the names taken at the top level of the module, and
+	// methods those of the methods of the client.
+	names   map[string]bool
+	methods map[string]bool
+
+	// decls are the declarations of the module written so far.
+	decls []string
+}
+
+// tsParam is a parameter of a method of the client.
+type tsParam struct {
+	name, typ string
+	optional  bool
+}
+
+func (g *tsGenerator) writeMethod(w *bytes.Buffer, pth []string, cmd *cmds.Command, opts []cmds.Option) error {
+	cmdline := strings.Join(pth, " ")
+	method := "root"
+	if len(pth) > 0 {
+		method = unexportedName(cmdline)
+	}
+	optsName := exportedName(method) + "Options"
+	if g.methods[method] || g.names[optsName] {
+		return fmt.Errorf("command %q: name %s is taken", cmdline, method)
+	}
+	g.methods[method] = true
+	if cmdline == "" {
+		cmdline = "the root command"
+	}
+
+	// the options, keyed by their names in camelCase
+	var optNames, optKeys []string
+	var optDecls bytes.Buffer
+	seen := make(map[string]bool)
+	for _, opt := range opts {
+		typ, ok := tsOptionTypes[opt.Type()]
+		if !ok || tsSkipOptions[opt.Name()] || cmds.ClientSideOptions[opt.Name()] || cmdshttp.OptionSkipMap[opt.Name()] || seen[opt.Name()] {
+			continue
+		}
+		key := unexportedName(opt.Name())
+		if key == "" || seen[key] {
+			continue
+		}
+		seen[opt.Name()], seen[key] = true, true
+		if desc := docLine(opt.Description()); desc != "" {
+			fmt.Fprintf(&optDecls, "  /** %s */\n", tsComment(desc))
+		}
+		fmt.Fprintf(&optDecls, "  %s?: %s;\n", key, typ)
+		optNames = append(optNames, opt.Name())
+		optKeys = append(optKeys, key)
+	}
+	if len(optNames) > 0 {
+		g.names[optsName] = true
+		g.writeDecl(fmt.Sprintf("/** The options of %s. */\nexport interface %s {\n%s}\n", tsComment(cmdline), optsName, optDecls.String()))
+	}
+
+	// the parameters
+	var params []tsParam
+	var pushArgs bytes.Buffer
+	taken := map[string]bool{"options": true, "init": true, "files": true, "args": true}
+	files := false
+	for _, a := range cmd.Arguments {
+		if a.Type == cmds.ArgFile {
+			if !files {
+				params = append(params, tsParam{name: "files", typ: "FileEntry[]", optional: !a.Required})
+				files = true
+			}
+			continue
+		}
+		name := unexportedName(a.Name)
+		for taken[name] || tsKeywords[name] || name == "" {
+			name += "Arg"
+		}
+		taken[name] = true
+		switch {
+		case a.Variadic && a.Required:
+			params = append(params, tsParam{name: name, typ: "string[]"})
+			fmt.Fprintf(&pushArgs, "    args.push(...%s);\n", name)
+		case a.Variadic:
+			params = append(params, tsParam{name: name, typ: "string[]", optional: true})
+			fmt.Fprintf(&pushArgs, "    args.push(...(%s ?? []));\n", name)
+		case a.Required:
+			params = append(params, tsParam{name: name, typ: "string"})
+			fmt.Fprintf(&pushArgs, "    args.push(%s);\n", name)
+		default:
+			params = append(params, tsParam{name: name, typ: "string", optional: true})
+			fmt.Fprintf(&pushArgs, "    if (%s !== undefined) {\n      args.push(%s);\n    }\n", name, name)
+		}
+	}
+	// optional parameters before required ones take undefined
+	var decls []string
+	required := false
+	for i := len(params) - 1; i >= 0; i-- {
+		p := params[i]
+		switch {
+		case !p.optional:
+			required = true
+			decls = append(decls, p.name+": "+p.typ)
+		case required:
+			decls = append(decls, p.name+": "+p.typ+" | undefined")
+		default:
+			decls = append(decls, p.name+"?: "+p.typ)
+		}
+	}
+	for i, j := 0, len(decls)-1; i < j; i, j = i+1, j-1 {
+		decls[i], decls[j] = decls[j], decls[i]
+	}
+	if len(optNames) > 0 {
+		decls = append(decls, "options: "+optsName+" = {}")
+	}
+	decls = append(decls, "init: CallOptions = {}")
+
+	// the values
+	result := "unknown"
+	if cmd.Type != nil {
+		typ := reflect.TypeOf(cmd.Type)
+		if typ.Kind() == reflect.Ptr {
+			typ = typ.Elem()
+		}
+		result = g.typeOf(typ)
+	}
+	for _, ot := range cmd.OutputTypes {
+		typ := "unknown"
+		if t := reflect.TypeOf(ot.Type); t != nil {
+			if t.Kind() == reflect.Ptr {
+				t = t.Elem()
+			}
+			typ = g.typeOf(t)
+		}
+		result += fmt.Sprintf(" | Output<%s, %s>", strconv.Quote(ot.Name), typ)
+	}
+
+	fmt.Fprintf(w, "\n  /** Runs %s.", tsComment(cmdline))
+	if desc := docLine(cmd.Helptext.Tagline); desc != "" {
+		fmt.Fprintf(w, " %s", tsComment(strings.TrimSuffix(desc, ".")+"."))
+	}
+	w.WriteString(" */\n")
+	fmt.Fprintf(w, "  %s(%s): Promise<Stream<%s>> {\n", method, strings.Join(decls, ", "), result)
+	w.WriteString("    const args: string[] = [];\n")
+	w.Write(pushArgs.Bytes())
+
+	var quoted []string
+	for _, p := range pth {
+		quoted = append(quoted, strconv.Quote(p))
+	}
+	optMap := "{}"
+	if len(optNames) > 0 {
+		var b strings.Builder
+		b.WriteString("{\n")
+		for i, name := range optNames {
+			fmt.Fprintf(&b, "      %s: options.%s,\n", strconv.Quote(name), optKeys[i])
+		}
+		b.WriteString("    }")
+		optMap = b.String()
+	}
+	filesArg := "undefined"
+	if files {
+		filesArg = "files"
+	}
+	fmt.Fprintf(w, "    return this.call<%s>([%s], args, %s, %s, init);\n  }\n", result, strings.Join(quoted, ", "), optMap, filesArg)
+	return nil
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// typeOf returns the TypeScript type of the JSON of values of typ,
+// declaring the interfaces of the named structs it refers to.
+func (g *tsGenerator) typeOf(typ reflect.Type) string {
+	if name, ok := g.types[typ]; ok {
+		return name
+	}
+	switch {
+	case typ == timeType:
+		return "string"
+	case implements(typ, jsonMarshalerType):
+		return "unknown"
+	case implements(typ, textMarshalerType):
+		return "string"
+	}
+
+	switch typ.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.String:
+		return "string"
+	case reflect.Ptr:
+		return nullable(g.typeOf(typ.Elem()))
+	case reflect.Slice:
+		if typ.Elem().Kind() == reflect.Uint8 && !implements(typ.Elem(), jsonMarshalerType) && !implements(typ.Elem(), textMarshalerType) {
+			// base64
+			return "string"
+		}
+		return nullable(arrayOf(g.typeOf(typ.Elem())))
+	case reflect.Array:
+		return arrayOf(g.typeOf(typ.Elem()))
+	case reflect.Map:
+		return nullable("Record<string, " + g.typeOf(typ.Elem()) + ">")
+	case reflect.Struct:
+		if typ.Name() == "" {
+			var fields []string
+			for _, f := range g.fields(typ) {
+				fields = append(fields, f+";")
+			}
+			if len(fields) == 0 {
+				return "Record<string, never>"
+			}
+			return "{ " + strings.Join(fields, " ") + " }"
+		}
+		return g.declareAs(typ, typ.Name())
+	}
+	return "unknown"
+}
+
+func implements(typ, iface reflect.Type) bool {
+	return typ.Implements(iface) || typ.Kind() != reflect.Ptr && reflect.PtrTo(typ).Implements(iface)
+}
+
+func nullable(typ string) string {
+	if strings.HasSuffix(typ, " | null") {
+		return typ
+	}
+	return typ + " | null"
+}
+
+func arrayOf(typ string) string {
+	if strings.Contains(typ, "|") {
+		return "(" + typ + ")[]"
+	}
+	return typ + "[]"
+}
+
+// declareAs declares the interface of the struct typ, named after name, and
+// returns the name it got.
+func (g *tsGenerator) declareAs(typ reflect.Type, name string) string {
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' {
+			return r
+		}
+		return -1
+	}, name)
+	if name == "" || unicode.IsDigit(rune(name[0])) {
+		name = "T" + name
+	}
+	unique := name
+	for i := 2; g.names[unique]; i++ {
+		unique = name + strconv.Itoa(i)
+	}
+	g.names[unique] = true
+	g.types[typ] = unique
+	g.pending = append(g.pending, typ)
+	return unique
+}
+
+func (g *tsGenerator) writeDecl(decl string) {
+	g.decls = append(g.decls, decl)
+}
+
+// writeDecls writes the declarations of the options of the commands and of
+// the types of their values.
+func (g *tsGenerator) writeDecls(w *bytes.Buffer) {
+	for len(g.pending) > 0 {
+		typ := g.pending[0]
+		g.pending = g.pending[1:]
+		fields := g.fields(typ)
+
+		var b strings.Builder
+		fmt.Fprintf(&b, "/** The JSON of %s values. */\n", tsComment(typ.String()))
+		fmt.Fprintf(&b, "export interface %s {\n", g.types[typ])
+		for _, f := range fields {
+			fmt.Fprintf(&b, "  %s;\n", f)
+		}
+		b.WriteString("}\n")
+		g.decls = append(g.decls, b.String())
+	}
+	for _, decl := range g.decls {
+		w.WriteString("\n")
+		w.WriteString(decl)
+	}
+}
+
+// tsField is a field of the JSON of a struct, at the depth of the embedded
+// structs it is promoted from.
+type tsField struct {
+	name   string
+	decl   string
+	depth  int
+	tagged bool
+}
+
+// fields returns the declarations of the fields of the JSON of the struct
+// typ, following the rules of encoding/json.
+func (g *tsGenerator) fields(typ reflect.Type) []string {
+	var all []tsField
+	g.collectFields(&all, typ, 0, map[reflect.Type]bool{})
+
+	// of the fields of a name, the shallowest wins, and the tagged one of
+	// those at the same depth, as encoding/json does
+	byName := make(map[string][]tsField)
+	var order []string
+	for _, f := range all {
+		if _, ok := byName[f.name]; !ok {
+			order = append(order, f.name)
+		}
+		byName[f.name] = append(byName[f.name], f)
+	}
+	var decls []string
+	for _, name := range order {
+		fs := byName[name]
+		sort.SliceStable(fs, func(i, j int) bool { return fs[i].depth < fs[j].depth })
+		var dominant []tsField
+		for _, f := range fs {
+			if f.depth == fs[0].depth {
+				dominant = append(dominant, f)
+			}
+		}
+		if len(dominant) > 1 {
+			var tagged []tsField
+			for _, f := range dominant {
+				if f.tagged {
+					tagged = append(tagged, f)
+				}
+			}
+			if len(tagged) != 1 {
+				continue
+			}
+			dominant = tagged
+		}
+		decls = append(decls, dominant[0].decl)
+	}
+	return decls
+}
+
+func (g *tsGenerator) collectFields(fields *[]tsField, typ reflect.Type, depth int, visiting map[reflect.Type]bool) {
+	if visiting[typ] {
+		return
+	}
+	visiting[typ] = true
+	defer delete(visiting, typ)
+
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if j := strings.IndexByte(tag, ','); j >= 0 {
+			name, opts = tag[:j], tag[j:]
+		}
+
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				g.collectFields(fields, ft, depth+1, visiting)
+				continue
+			}
+		}
+		if f.PkgPath != "" {
+			// unexported
+			continue
+		}
+
+		tagged := name != ""
+		if name == "" {
+			name = f.Name
+		}
+		typ := g.typeOf(f.Type)
+		if strings.Contains(opts, ",string") {
+			switch f.Type.Kind() {
+			case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+				reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+				reflect.Float32, reflect.Float64, reflect.String:
+				typ = "string"
+			}
+		}
+		key := name
+		if !isTSIdentifier(name) {
+			key = strconv.Quote(name)
+		}
+		decl := key + ": " + typ
+		if strings.Contains(opts, ",omitempty") {
+			decl = key + "?: " + strings.TrimSuffix(typ, " | null")
+		}
+		*fields = append(*fields, tsField{name: name, decl: decl, depth: depth, tagged: tagged})
+	}
+}
+
+func isTSIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		if !(unicode.IsLetter(r) || r == '_' || r == '$' || i > 0 && unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return true
+}
+
+// tsComment returns s with the sequences ending comments escaped.
+func tsComment(s string) string {
+	return strings.ReplaceAll(s, "*/", "*\\/")
+}
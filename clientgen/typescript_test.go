@@ -0,0 +1,130 @@
+package clientgen
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+	"github.com/fgeth/fg-ipfs-cmds/examples/adder"
+)
+
+func TestGenerateTypeScriptAdder(t *testing.T) {
+	src, err := GenerateTypeScript(adder.RootCmd, TypeScriptConfig{
+		Generator: "github.com/fgeth/fg-ipfs-cmds/examples/adder/gen",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp, err := ioutil.ReadFile("../examples/adder/client/client.ts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(src, exp) {
+		t.Error("the TypeScript client of the adder example is outdated, run go generate in examples/adder/client")
+	}
+}
+
+type tsMeta struct {
+	ID      string
+	Private string `json:"-"`
+}
+
+type TSEntry struct {
+	tsMeta
+	Name     string     `json:"name"`
+	Size     int64      `json:",omitempty"`
+	Count    uint64     `json:",string"`
+	Children []*TSEntry `json:",omitempty"`
+	Labels   map[string]string
+	Data     []byte
+	Anything interface{}
+	Location struct{ X, Y float64 }
+	hidden   bool
+}
+
+type TSTick struct {
+	N int
+}
+
+func TestGenerateTypeScript(t *testing.T) {
+	run := func(*cmds.Request, cmds.ResponseEmitter, cmds.Environment) error { return nil }
+	root := &cmds.Command{
+		Options: []cmds.Option{
+			cmds.BoolOption("verbose", "v", "print more"),
+			cmds.OptionEncodingType,
+		},
+		Subcommands: map[string]*cmds.Command{
+			"files": {
+				Subcommands: map[string]*cmds.Command{
+					"ls": {
+						Helptext: cmds.HelpText{Tagline: "List */ entries"},
+						Options: []cmds.Option{
+							cmds.StringsOption("filter", "only list these"),
+							cmds.IntOption("max-depth", "how deep to list"),
+						},
+						Arguments: []cmds.Argument{
+							cmds.StringArg("default", false, false, "the type of the entries"),
+							cmds.StringArg("path", true, true, "the paths"),
+						},
+						Run:         run,
+						Type:        &TSEntry{},
+						OutputTypes: []cmds.OutputType{{Name: "tick", Type: TSTick{}}},
+					},
+					"add": {
+						Arguments: []cmds.Argument{
+							cmds.FileArg("file", true, true, "the files to add"),
+						},
+						Run: run,
+					},
+				},
+			},
+			"local": {
+				Run:      run,
+				NoRemote: true,
+			},
+		},
+	}
+
+	src, err := GenerateTypeScript(root, TypeScriptConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, exp := range []string{
+		"// Code generated by clientgen. DO NOT EDIT.",
+		"export interface FilesLsOptions {\n  /** print more. */\n  verbose?: boolean;\n  /** only list these. */\n  filter?: string[];\n  /** how deep to list. */\n  maxDepth?: number;\n}",
+		// optional parameters before required ones take undefined
+		`/** Runs files ls. List *\/ entries. */`,
+		`filesLs(defaultArg: string | undefined, path: string[], options: FilesLsOptions = {}, init: CallOptions = {}): Promise<Stream<TSEntry | Output<"tick", TSTick>>>`,
+		`"max-depth": options.maxDepth,`,
+		`filesAdd(files: FileEntry[], options: FilesAddOptions = {}, init: CallOptions = {}): Promise<Stream<unknown>>`,
+		"export interface TSEntry {\n" +
+			"  ID: string;\n" +
+			"  name: string;\n" +
+			"  Size?: number;\n" +
+			"  Count: string;\n" +
+			"  Children?: (TSEntry | null)[];\n" +
+			"  Labels: Record<string, string> | null;\n" +
+			"  Data: string;\n" +
+			"  Anything: unknown;\n" +
+			"  Location: { X: number; Y: number; };\n" +
+			"}",
+		"export interface TSTick {\n  N: number;\n}",
+	} {
+		if !strings.Contains(string(src), exp) {
+			t.Errorf("expected the generated source to contain %q", exp)
+		}
+	}
+	for _, unexp := range []string{"encoding?:", "local(", "Private", "hidden"} {
+		if strings.Contains(string(src), unexp) {
+			t.Errorf("expected the generated source not to contain %q", unexp)
+		}
+	}
+
+	// commands whose names collide
+	root.Subcommands["files-ls"] = &cmds.Command{Run: run}
+	if _, err := GenerateTypeScript(root, TypeScriptConfig{}); err == nil {
+		t.Error("expected an error for colliding command names")
+	}
+}
@@ -11,17 +11,14 @@ package cmds
 import (
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
-
-	files "github.com/fgeth/fg-ipfs-files"
-
-	logging "github.com/ipfs/go-log"
 )
 
 // DefaultOutputEncoding defines the default API output encoding.
 const DefaultOutputEncoding = JSON
 
-var log = logging.Logger("cmds")
+var log = SubsystemLogger("cmds")
 
 // Function is the type of function that Commands use.
 // It reads from the Request, and writes results to the ResponseEmitter.
@@ -37,6 +34,14 @@ type Command struct {
 	// on parent commands are inherited by sub commands.
 	Options []Option
 
+	// LocalOptions names options of the command that its sub commands don't
+	// inherit.
+	LocalOptions []string
+
+	// ExcludedOptions names inherited options that don't apply to the
+	// command, nor to its sub commands. Requests using them are rejected.
+	ExcludedOptions []string
+
 	// Arguments defines the positional arguments for the command. These
 	// arguments can be strings and/or files.
 	//
@@ -57,6 +62,12 @@ type Command struct {
 	// available for reading after the HTTP connection has been written to.
 	Run Function
 
+	// Simulate is run instead of Run in dry runs, see OptionDryRun. It
+	// should emit what Run would do, without doing it. Its values go
+	// through PostRun and the encoders like the ones of Run. Without
+	// Simulate, dry runs emit nothing.
+	Simulate Function
+
 	// PostRun is run after Run, and can transform results returned by run.
 	// When executing a command on a remote daemon, PostRun is always run in
 	// the local process.
@@ -66,9 +77,45 @@ type Command struct {
 	// encoding.
 	Encoders EncoderMap
 
+	// Decoders decode the values of the command in encodings of its own,
+	// e.g. custom wire encodings of its Encoders, for clients. Unlike the
+	// global Decoders, they are passed new values of Type to decode into:
+	// streams in these encodings only carry values, errors being sent in
+	// the trailers of HTTP responses.
+	Decoders DecoderMap
+
+	// WireEncoding is the encoding the HTTP client asks servers to send
+	// the values of the command in, which it decodes with Decoders or the
+	// global decoder of the encoding, so PostRun gets values of Type. It
+	// defaults to JSON.
+	WireEncoding EncodingType
+
+	// Encodings lists the encodings the output of the command supports.
+	// When empty, all encodings with an encoder are supported, except the
+	// ones in ForbiddenEncodings. Requests for other encodings are
+	// rejected when they are parsed.
+	Encodings []EncodingType
+
+	// ForbiddenEncodings lists encodings the output of the command doesn't
+	// support, e.g. Text for a command emitting binary data.
+	ForbiddenEncodings []EncodingType
+
+	// EncodingFallbacks lists the encodings the output is encoded in when
+	// the command has no encoder of its own for the requested encoding,
+	// in order, e.g. JSON then Text. The first one the command or Encoders
+	// has an encoder for is used, and requests are rejected when they are
+	// parsed if there is none. When empty, the global encoder of the
+	// requested encoding is used.
+	EncodingFallbacks []EncodingType
+
 	// Helptext is the command's help text.
 	Helptext HelpText
 
+	// DocsURL is the URL of the documentation of the command. The CLI
+	// shows it in the help text, and the usage errors of the command,
+	// those of type ErrClient, carry it as their help URL.
+	DocsURL string
+
 	// External denotes that a command is actually an external binary.
 	// fewer checks and validations will be performed on such commands.
 	External bool
@@ -78,8 +125,19 @@ type Command struct {
 	// the Run Function.
 	//
 	// ie. If command Run returns &Block{}, then Command.Type == &Block{}
+	//
+	// Commands whose Type is a protobuf message support the Protobuf
+	// encoding: values are sent as messages prefixed with their length,
+	// and clients decode them into new messages of that type.
 	Type interface{}
 
+	// OutputTypes declares the other types of the values the command
+	// emits, e.g. progress reports sent before a result of Type. Values of
+	// these types are tagged with the name of their type in JSON streams,
+	// so responses return them decoded into their type, and they are
+	// encoded with the encoders of their type.
+	OutputTypes []OutputType
+
 	// Subcommands allow attaching sub commands to a command.
 	//
 	// Note: A command can specify both a Run function and Subcommands. If
@@ -97,8 +155,66 @@ type Command struct {
 	// NoLocal denotes that a command cannot be executed in a local environment
 	NoLocal bool
 
+	// Mutating denotes that a command changes state. Read-only servers
+	// refuse to run such commands.
+	Mutating bool
+
+	// Subscription denotes a long-lived command that streams values until
+	// the client goes away, e.g. one tailing a log. The HTTP handler
+	// doesn't apply timeouts to it, and sends heartbeats while it is idle.
+	Subscription bool
+
+	// MaxResponseItems and MaxResponseBytes limit the number of values
+	// and bytes the command sends over HTTP. Responses exceeding them are
+	// cut short with an ErrTruncated error. Zero means no limit; the
+	// limits of the server apply too.
+	MaxResponseItems uint64
+	MaxResponseBytes uint64
+
+	// Exclusive makes requests to the command run one at a time. Requests
+	// made while it runs wait for their turn, or fail with ErrRateLimited
+	// if FailIfBusy is set.
+	Exclusive  bool
+	FailIfBusy bool
+
+	// Singleflight makes concurrent identical requests to the command,
+	// with the same arguments and options and of the same identity, see
+	// Identity, share one execution: requests made while it runs get the
+	// values of the running one. Readers emitted while no other request
+	// follows the execution aren't shared, and neither are outputs too
+	// large to keep in memory.
+	Singleflight bool
+
+	// FanOut makes concurrent identical requests to a streaming command,
+	// e.g. one tailing a log, share one execution like Singleflight does
+	// for requests of the same identity: every request receives the values
+	// emitted from when it joins on, and the execution is canceled once
+	// the last request has gone away. Unlike Singleflight,
+	// values aren't kept for requests joining late, so it suits commands
+	// that never end.
+	FanOut bool
+
+	// SingleOutput declares that the command emits exactly one value,
+	// besides progress reports. The framework sends it as Single, so
+	// transports know no other value follows, and fails the request with an
+	// ErrImplementation error if Run emits another one or returns without
+	// emitting any.
+	SingleOutput bool
+
+	// Requires lists the interfaces the environment of the command must
+	// implement, as nil pointers to them, e.g. (*CoreEnv)(nil). Requests
+	// run in an environment lacking one fail with an error naming it
+	// before Run is called. See ValidateEnvironment to check a tree when
+	// the environment is built.
+	Requires []interface{}
+
 	// Extra contains a set of other command-specific parameters
 	Extra *Extra
+
+	// guard is the concurrency state of the command, created when it first
+	// runs, see guardRun. It goes away with the command, e.g. when a tree
+	// is replaced.
+	guard *commandGuard
 }
 
 // Extra is a set of tag information for a command
@@ -139,38 +255,69 @@ var (
 // Call invokes the command for the given Request
 func (c *Command) Call(req *Request, re ResponseEmitter, env Environment) {
 	var closeErr error
+	log := ContextLogger(req.Context, log)
+	defer removeTempDir(req)
+	re = DecorateEmitter(req, re)
+	for _, w := range req.Warnings() {
+		Warn(re, w)
+	}
 
-	err := c.call(req, re, env)
+	re, err := applySort(req, re)
+	re = applyThrottle(req, re)
+	if err == nil {
+		err = c.call(req, re, env)
+	}
+	err = withDocsURL(req.Command, err)
 	if err != nil {
-		log.Debugf("error occured in call, closing with error: %s", err)
+		log.Debug("error occured in call, closing with error", "error", err)
 	}
 
 	closeErr = re.CloseWithError(err)
 	// ignore double close errors
 	if closeErr != nil && closeErr != ErrClosingClosedEmitter {
-		log.Errorf("error closing ResponseEmitter: %s", closeErr)
+		log.Error("error closing ResponseEmitter", "error", closeErr)
 	}
 }
 
 func (c *Command) call(req *Request, re ResponseEmitter, env Environment) error {
+	log := ContextLogger(req.Context, log)
+
 	cmd, err := c.Get(req.Path)
 	if err != nil {
-		log.Errorf("could not get cmd from path %q: %q", req.Path, err)
+		log.Error("could not get cmd from path", "path", req.Path, "error", err)
 		return err
 	}
 
 	if cmd.Run == nil {
-		log.Errorf("returned command has nil Run function")
+		log.Error("returned command has nil Run function", "path", req.Path)
 		return err
 	}
 
 	err = cmd.CheckArguments(req)
 	if err != nil {
-		log.Errorf("CheckArguments returned an error for path %q: %q", req.Path, err)
+		log.Error("CheckArguments returned an error", "path", req.Path, "error", err)
+		return err
+	}
+
+	releaseQuota, err := acquireQuota(req, env)
+	if err != nil {
+		return err
+	}
+	defer releaseQuota()
+
+	defer startInput(req, env)()
+
+	env, release, err := prepareEnvironment(req, env)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if err := checkRequirements(cmd, req, env); err != nil {
 		return err
 	}
 
-	return cmd.Run(req, re, env)
+	return runFunc(cmd, req)(req, re, env)
 }
 
 // Resolve returns the subcommands at the given path
@@ -203,7 +350,8 @@ func (c *Command) Get(path []string) (*Command, error) {
 	return cmds[len(cmds)-1], nil
 }
 
-// GetOptions returns the options in the given path of commands
+// GetOptions returns the options in the given path of commands, by all their
+// names, including the deprecated ones, see RenamedOption.
 func (c *Command) GetOptions(path []string) (map[string]Option, error) {
 	options := make([]Option, 0, len(c.Options))
 
@@ -212,13 +360,18 @@ func (c *Command) GetOptions(path []string) (map[string]Option, error) {
 		return nil, err
 	}
 
-	for _, cmd := range cmds {
-		options = append(options, cmd.Options...)
+	for i, cmd := range cmds {
+		options = excludeOptions(options, cmd.ExcludedOptions)
+		if i < len(cmds)-1 {
+			options = append(options, excludeOptions(cmd.Options, cmd.LocalOptions)...)
+		} else {
+			options = append(options, cmd.Options...)
+		}
 	}
 
 	optionsMap := make(map[string]Option)
 	for _, opt := range options {
-		for _, name := range opt.Names() {
+		for _, name := range optionNames(opt) {
 			if _, found := optionsMap[name]; found {
 				return nil, fmt.Errorf("option name %q used multiple times", name)
 			}
@@ -230,6 +383,57 @@ func (c *Command) GetOptions(path []string) (map[string]Option, error) {
 	return optionsMap, nil
 }
 
+// excludeOptions returns the options of opts that have none of the given
+// names.
+func excludeOptions(opts []Option, names []string) []Option {
+	if len(names) == 0 {
+		return opts
+	}
+
+	res := make([]Option, 0, len(opts))
+Outer:
+	for _, opt := range opts {
+		for _, name := range opt.Names() {
+			for _, excluded := range names {
+				if name == excluded {
+					continue Outer
+				}
+			}
+		}
+		res = append(res, opt)
+	}
+	return res
+}
+
+// CheckOption returns an error of type ErrClient if the option name is
+// defined on the given path of commands, but isn't available to the last
+// one, see LocalOptions and ExcludedOptions. Options that aren't defined at
+// all are not checked.
+func (c *Command) CheckOption(path []string, name string) error {
+	cmds, err := c.Resolve(path)
+	if err != nil {
+		return err
+	}
+	options, err := c.GetOptions(path)
+	if err != nil {
+		return err
+	}
+	if _, ok := options[name]; ok {
+		return nil
+	}
+
+	for _, cmd := range cmds {
+		for _, opt := range cmd.Options {
+			for _, n := range optionNames(opt) {
+				if n == name {
+					return Errorf(ErrClient, "option %q can't be used with command %q", name, strings.Join(path, " "))
+				}
+			}
+		}
+	}
+	return nil
+}
+
 // DebugValidate checks if the command tree is well-formed.
 //
 // This operation is slow and should be called from tests only.
@@ -237,7 +441,7 @@ func (c *Command) DebugValidate() map[string][]error {
 	errs := make(map[string][]error)
 	var visit func(path string, cm *Command)
 
-	liveOptions := make(map[string]struct{})
+	liveOptions := make(map[string]Option)
 	visit = func(path string, cm *Command) {
 		expectOptional := false
 		for i, argDef := range cm.Arguments {
@@ -257,17 +461,50 @@ func (c *Command) DebugValidate() map[string][]error {
 			}
 		}
 
+		if _, err := requiredTypes(cm); err != nil {
+			errs[path] = append(errs[path], err)
+		}
+
+		// excluded options can be defined again below cm
+		var excluded []Option
+		for _, name := range cm.ExcludedOptions {
+			option, ok := liveOptions[name]
+			if !ok {
+				errs[path] = append(errs[path], fmt.Errorf("excluded option %s is not inherited", name))
+				continue
+			}
+			excluded = append(excluded, option)
+			for _, n := range optionNames(option) {
+				delete(liveOptions, n)
+			}
+		}
+
 		var goodOptions []string
 		for _, option := range cm.Options {
-			for _, name := range option.Names() {
+			for _, name := range optionNames(option) {
 				if _, ok := liveOptions[name]; ok {
 					errs[path] = append(errs[path], fmt.Errorf("duplicate option name %s", name))
 				} else {
 					goodOptions = append(goodOptions, name)
-					liveOptions[name] = struct{}{}
+					liveOptions[name] = option
+				}
+			}
+		}
+
+		// local options are removed before visiting the sub commands, and
+		// not restored, as they are removed along with cm's options anyway
+		for _, name := range cm.LocalOptions {
+			if len(excludeOptions(cm.Options, []string{name})) == len(cm.Options) {
+				errs[path] = append(errs[path], fmt.Errorf("local option %s is not an option of the command", name))
+				continue
+			}
+			if option, ok := liveOptions[name]; ok {
+				for _, n := range optionNames(option) {
+					delete(liveOptions, n)
 				}
 			}
 		}
+
 		for scName, sc := range cm.Subcommands {
 			visit(fmt.Sprintf("%s/%s", path, scName), sc)
 		}
@@ -275,6 +512,11 @@ func (c *Command) DebugValidate() map[string][]error {
 		for _, name := range goodOptions {
 			delete(liveOptions, name)
 		}
+		for _, option := range excluded {
+			for _, n := range optionNames(option) {
+				liveOptions[n] = option
+			}
+		}
 	}
 	visit("", c)
 	if len(errs) == 0 {
@@ -283,8 +525,70 @@ func (c *Command) DebugValidate() map[string][]error {
 	return errs
 }
 
+// SupportedEncodings returns the encodings the output of the command
+// supports, sorted by name. See Encodings and ForbiddenEncodings.
+func (c *Command) SupportedEncodings() []EncodingType {
+	var candidates []EncodingType
+	if len(c.Encodings) > 0 {
+		candidates = c.Encodings
+	} else {
+		for enc := range Encoders {
+			if _, ok := encoderFunc(c, enc); ok {
+				candidates = append(candidates, enc)
+			}
+		}
+		for enc := range c.Encoders {
+			if _, ok := Encoders[enc]; !ok {
+				candidates = append(candidates, enc)
+			}
+		}
+	}
+
+	out := make([]EncodingType, 0, len(candidates))
+	for _, enc := range candidates {
+		if !c.forbidsEncoding(enc) {
+			out = append(out, enc)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
+
+func (c *Command) forbidsEncoding(enc EncodingType) bool {
+	for _, f := range c.ForbiddenEncodings {
+		if f == enc {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckEncoding returns an error listing the supported encodings if the
+// output of the command doesn't support enc.
+func (c *Command) CheckEncoding(enc EncodingType) error {
+	if len(c.EncodingFallbacks) > 0 {
+		if _, _, err := resolveEncoder(c, enc); err != nil {
+			return err
+		}
+	}
+	if len(c.Encodings) == 0 && len(c.ForbiddenEncodings) == 0 {
+		return nil
+	}
+
+	supported := c.SupportedEncodings()
+	names := make([]string, len(supported))
+	for i, s := range supported {
+		if s == enc {
+			return nil
+		}
+		names[i] = string(s)
+	}
+	return Errorf(ErrClient, "encoding %q is not supported by this command, supported encodings are: %s", enc, strings.Join(names, ", "))
+}
+
 // CheckArguments checks that we have all the required string arguments, loading
-// any from stdin if necessary.
+// any from stdin if necessary. The missing ones are returned together in a
+// *ValidationError.
 func (c *Command) CheckArguments(req *Request) error {
 	if len(c.Arguments) == 0 {
 		return nil
@@ -296,19 +600,19 @@ func (c *Command) CheckArguments(req *Request) error {
 		lastArg.Type == ArgString &&
 		req.Files != nil {
 
-		it := req.Files.Entries()
-		if it.Next() {
-			req.bodyArgs = newArguments(files.FileFromEntry(it))
+		f, err := firstFile(req.Files)
+		if err != nil {
+			return err
+		}
+		if f != nil {
+			req.bodyArgs = newArguments(f)
 			// Can't pass files and stdin arguments.
 			req.Files = nil
-		} else {
-			if it.Err() != nil {
-				return it.Err()
-			}
 		}
 	}
 
 	// iterate over the arg definitions
+	var verr ValidationError
 	requiredStringArgs := 0 // number of required string arguments
 	for _, argDef := range req.Command.Arguments {
 		// Is this a string?
@@ -342,10 +646,10 @@ func (c *Command) CheckArguments(req *Request) error {
 			}
 			// No, just missing.
 		}
-		return fmt.Errorf("argument %q is required", argDef.Name)
+		verr.Add(argDef.Name, "argument is required")
 	}
 
-	return nil
+	return verr.Err()
 }
 
 type CommandVisitor func(*Command)
@@ -5,6 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"reflect"
+	"sort"
 	"testing"
 	"time"
 )
@@ -50,7 +52,7 @@ func TestOptionValidation(t *testing.T) {
 	tcs := []testcase{
 		{
 			opts:            map[string]interface{}{"boop": true},
-			NewRequestError: `option "boop" should be type "string", but got type "bool"`,
+			NewRequestError: `boop: should be type "string", but got type "bool"`,
 		},
 		{opts: map[string]interface{}{"beep": 5}},
 		{opts: map[string]interface{}{"beep": 5, "boop": "test"}},
@@ -61,10 +63,18 @@ func TestOptionValidation(t *testing.T) {
 		{opts: map[string]interface{}{"S": [2]string{"a", "b"}}},
 		{
 			opts:            map[string]interface{}{"S": true},
-			NewRequestError: `option "S" should be type "array", but got type "bool"`},
+			NewRequestError: `S: should be type "array", but got type "bool"`},
 		{
 			opts:            map[string]interface{}{"beep": ":)"},
-			NewRequestError: `could not convert value ":)" to type "int" (for option "-beep")`,
+			NewRequestError: `beep: could not convert value ":)" to type "int"`,
+		},
+		{
+			opts:            map[string]interface{}{"boop": true, "beep": ":)"},
+			NewRequestError: `invalid request: beep: could not convert value ":)" to type "int"; boop: should be type "string", but got type "bool"`,
+		},
+		{
+			opts:            map[string]interface{}{"beep": 5, "b": 6},
+			NewRequestError: `b: duplicate of option "beep"`,
 		},
 	}
 
@@ -379,3 +389,154 @@ func TestEmitterExpectError(t *testing.T) {
 		t.Errorf("expected SetError to be called once, but was called %d times", re.errorCount)
 	}
 }
+
+func TestCheckEncoding(t *testing.T) {
+	type testcase struct {
+		cmd       *Command
+		enc       EncodingType
+		supported []EncodingType
+		err       string
+	}
+
+	tcs := []testcase{
+		{cmd: &Command{}, enc: Text},
+		{
+			cmd:       &Command{ForbiddenEncodings: []EncodingType{Text, TextNewline}},
+			enc:       Text,
+			supported: []EncodingType{CBOR, JSON, MergePatch, NDJSON, Raw, XML},
+			err:       `encoding "text" is not supported by this command, supported encodings are: cbor, json, merge-patch, ndjson, raw, xml`,
+		},
+		{
+			cmd:       &Command{ForbiddenEncodings: []EncodingType{Text, TextNewline}},
+			enc:       XML,
+			supported: []EncodingType{CBOR, JSON, MergePatch, NDJSON, Raw, XML},
+		},
+		{
+			cmd:       &Command{Encodings: []EncodingType{JSON, Protobuf}},
+			enc:       XML,
+			supported: []EncodingType{JSON, Protobuf},
+			err:       `encoding "xml" is not supported by this command, supported encodings are: json, protobuf`,
+		},
+		{
+			cmd:       &Command{Encodings: []EncodingType{JSON, Protobuf}, ForbiddenEncodings: []EncodingType{JSON}},
+			enc:       JSON,
+			supported: []EncodingType{Protobuf},
+			err:       `encoding "json" is not supported by this command, supported encodings are: protobuf`,
+		},
+	}
+
+	for i, tc := range tcs {
+		err := tc.cmd.CheckEncoding(tc.enc)
+		if (err == nil && tc.err != "") || (err != nil && err.Error() != tc.err) {
+			t.Errorf("%d: expected error %q, got %v", i, tc.err, err)
+		}
+		if tc.supported != nil && !reflect.DeepEqual(tc.cmd.SupportedEncodings(), tc.supported) {
+			t.Errorf("%d: expected supported encodings %v, got %v", i, tc.supported, tc.cmd.SupportedEncodings())
+		}
+	}
+}
+
+func TestOptionInheritance(t *testing.T) {
+	root := &Command{
+		Options: []Option{
+			BoolOption("global", "g", "an inherited option"),
+			BoolOption("local", "l", "a root-only option"),
+		},
+		LocalOptions: []string{"local"},
+		Subcommands: map[string]*Command{
+			"a": {
+				ExcludedOptions: []string{"g"},
+				Options:         []Option{StringOption("global", "redefined")},
+				Subcommands: map[string]*Command{
+					"b": {},
+				},
+			},
+			"c": {},
+		},
+	}
+
+	type testcase struct {
+		path   []string
+		opts   []string
+		denied []string
+	}
+
+	tcs := []testcase{
+		{path: nil, opts: []string{"g", "global", "l", "local"}},
+		{path: []string{"c"}, opts: []string{"g", "global"}, denied: []string{"local"}},
+		{path: []string{"a"}, opts: []string{"global"}, denied: []string{"g", "l"}},
+		{path: []string{"a", "b"}, opts: []string{"global"}, denied: []string{"g", "local"}},
+	}
+
+	for _, tc := range tcs {
+		opts, err := root.GetOptions(tc.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for name := range opts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if !reflect.DeepEqual(names, tc.opts) {
+			t.Errorf("%v: expected options %v, got %v", tc.path, tc.opts, names)
+		}
+
+		for _, name := range tc.denied {
+			err := root.CheckOption(tc.path, name)
+			if e, ok := err.(Error); !ok || e.Code != ErrClient {
+				t.Errorf("%v: expected option %q to be denied, got %v", tc.path, name, err)
+			}
+		}
+		if err := root.CheckOption(tc.path, "unknown"); err != nil {
+			t.Errorf("%v: unknown options aren't checked, got %v", tc.path, err)
+		}
+	}
+
+	if errs := root.DebugValidate(); errs != nil {
+		t.Errorf("unexpected validation errors: %v", errs)
+	}
+
+	root.Subcommands["c"].ExcludedOptions = []string{"local"}
+	root.Subcommands["c"].LocalOptions = []string{"global"}
+	if errs := root.DebugValidate(); len(errs["/c"]) != 2 {
+		t.Errorf("expected 2 validation errors, got %v", errs)
+	}
+}
+
+func TestCommandCost(t *testing.T) {
+	for i, tc := range []struct {
+		cmd    *Command
+		weight uint64
+	}{
+		{cmd: &Command{}, weight: 1},
+		{cmd: &Command{Extra: new(Extra).SetCost(Cost{Expensive: true})}, weight: ExpensiveWeight},
+		{cmd: &Command{Extra: new(Extra).SetCost(Cost{Streaming: true})}, weight: StreamingWeight},
+		{cmd: &Command{Subscription: true}, weight: StreamingWeight},
+		{
+			cmd:    &Command{Extra: new(Extra).SetCost(Cost{Expensive: true, Streaming: true})},
+			weight: ExpensiveWeight * StreamingWeight,
+		},
+	} {
+		if w := CommandCost(tc.cmd).Weight(); w != tc.weight {
+			t.Errorf("%d: expected weight %d, got %d", i, tc.weight, w)
+		}
+	}
+}
+
+func TestHelpURL(t *testing.T) {
+	base := errors.New("disk full")
+	err := WithHelpURL(fmt.Errorf("writing: %w", base), "https://docs.example.com/disk")
+	if HelpURL(err) != "https://docs.example.com/disk" || !errors.Is(err, base) {
+		t.Errorf("expected the error to keep its cause and carry its help URL, got %v", err)
+	}
+
+	err = WithHelpURL(Errorf(ErrClient, "bad"), "https://docs.example.com/bad")
+	if e, ok := err.(Error); !ok || e.HelpURL != "https://docs.example.com/bad" || HelpURL(err) != e.HelpURL {
+		t.Errorf("expected an Error with a help URL, got %#v", err)
+	}
+
+	if HelpURL(base) != "" || WithHelpURL(nil, "https://docs.example.com") != nil {
+		t.Error("expected plain errors to have no help URL, and nil to stay nil")
+	}
+}
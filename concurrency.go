@@ -0,0 +1,413 @@
+package cmds
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+)
+
+// Commands can restrict how their requests run concurrently, see
+// Command.Exclusive, Command.Singleflight and Command.FanOut. The restrictions hold within
+// the process running the commands, for all requests made through
+// Command.Call and local executors.
+
+// commandGuard holds the concurrency state of a command.
+type commandGuard struct {
+	// running holds a token while an exclusive command runs.
+	running chan struct{}
+
+	l sync.Mutex
+	// flights and fanOuts are the executions shared by the requests of
+	// Singleflight and FanOut commands, by flightKey.
+	flights map[string]*flight
+	fanOuts map[string]*flight
+}
+
+// guardsLk guards the guard field of all commands. Commands are copied by
+// value, so they can't hold a lock of their own.
+var guardsLk sync.Mutex
+
+func getGuard(cmd *Command) *commandGuard {
+	guardsLk.Lock()
+	defer guardsLk.Unlock()
+
+	if cmd.guard == nil {
+		cmd.guard = &commandGuard{
+			running: make(chan struct{}, 1),
+			flights: make(map[string]*flight),
+			fanOuts: make(map[string]*flight),
+		}
+	}
+	return cmd.guard
+}
+
+// guardRun returns run restricted by the concurrency settings of cmd.
+func guardRun(cmd *Command, run Function) Function {
+	if !cmd.Exclusive && !cmd.Singleflight && !cmd.FanOut {
+		return run
+	}
+
+	g := getGuard(cmd)
+	if cmd.Exclusive {
+		run = g.exclusive(run, cmd.FailIfBusy)
+	}
+	if cmd.Singleflight {
+		run = g.singleflight(run)
+	}
+	if cmd.FanOut {
+		run = g.fanOut(run)
+	}
+	return run
+}
+
+// exclusive returns run restricted to one request at a time.
+func (g *commandGuard) exclusive(run Function, failIfBusy bool) Function {
+	return func(req *Request, re ResponseEmitter, env Environment) error {
+		if failIfBusy {
+			select {
+			case g.running <- struct{}{}:
+			default:
+				return Errorf(ErrRateLimited, "command %q is already running", strings.Join(req.Path, " "))
+			}
+		} else {
+			select {
+			case g.running <- struct{}{}:
+			case <-req.Context.Done():
+				return req.Context.Err()
+			}
+		}
+		defer func() { <-g.running }()
+
+		return run(req, re, env)
+	}
+}
+
+// flightKey identifies the requests that can share an execution: those of
+// the same identity, see Identity, with the same arguments and options.
+// Requests sending files can't.
+func flightKey(req *Request) (string, bool) {
+	if req.Files != nil || req.BodyArgs() != nil {
+		return "", false
+	}
+	key, err := json.Marshal(struct {
+		Identity string
+		Request  interface{}
+	}{Identity(req.Context), recordRequest(req)})
+	if err != nil {
+		return "", false
+	}
+	return string(key), true
+}
+
+// singleflight returns run sharing its execution between concurrent
+// identical requests.
+func (g *commandGuard) singleflight(run Function) Function {
+	return func(req *Request, re ResponseEmitter, env Environment) error {
+		key, ok := flightKey(req)
+		if !ok {
+			return run(req, re, env)
+		}
+
+		g.l.Lock()
+		f, ok := g.flights[key]
+		if ok {
+			if fl := f.join(); fl != nil {
+				g.l.Unlock()
+				return f.follow(fl, req, re)
+			}
+		}
+		f = newFlight(false)
+		g.flights[key] = f
+		g.l.Unlock()
+
+		err := run(req, WrapEmitter(&flightEmitter{EmitterWrapper: EmitterWrapper{re}, f: f}, re), env)
+
+		g.l.Lock()
+		if g.flights[key] == f {
+			delete(g.flights, key)
+		}
+		g.l.Unlock()
+		f.finish(err)
+		return err
+	}
+}
+
+// The values a flight keeps for the requests following it are limited, so
+// long outputs don't pile up in memory. Requests following a flight whose
+// values exceed the limits fail, and requests made from then on run on their
+// own.
+const (
+	maxFlightValues = 1 << 12
+	maxFlightBytes  = 16 << 20
+)
+
+// errFlightTooLarge fails the requests following a flight whose values
+// exceed the limits.
+var errFlightTooLarge = Errorf(ErrNormal, "the output of the command is too large to share with identical requests, retry the request")
+
+// flight is an execution shared by identical requests, which follow it. It
+// keeps the values emitted so requests joining late receive all of them,
+// until it is closed. The flights of FanOut commands only keep the values
+// until all their followers got them, and followers receive the values
+// emitted from when they join on.
+type flight struct {
+	fanOut bool
+
+	l      sync.Mutex
+	values []interface{}
+	// base is the index of the first value kept, those before it were
+	// dropped.
+	base   int
+	size   int
+	length uint64
+	done   bool
+	err    error
+	// followers are the requests following the flight.
+	followers map[*follower]struct{}
+	// closed is set once the flight stopped keeping values: when a reader
+	// was emitted without followers, when the values exceeded the limits,
+	// or when the last follower of a fan-out left. Closed flights don't
+	// take new followers, and fail those they have.
+	closed bool
+	// cancel cancels the execution of a fan-out.
+	cancel context.CancelFunc
+	// changed is closed and replaced whenever the flight changes.
+	changed chan struct{}
+}
+
+// follower is a request following a flight.
+type follower struct {
+	// next is the index of the next value to send to the request.
+	next int
+}
+
+func newFlight(fanOut bool) *flight {
+	return &flight{
+		fanOut:    fanOut,
+		followers: make(map[*follower]struct{}),
+		changed:   make(chan struct{}),
+	}
+}
+
+// update applies f to the flight and wakes up its followers.
+func (f *flight) update(fn func()) {
+	f.l.Lock()
+	defer f.l.Unlock()
+	fn()
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+// close drops the values of the flight. It must be called with the lock of
+// the flight held.
+func (f *flight) close() {
+	f.closed = true
+	f.values = nil
+	f.size = 0
+}
+
+// trim drops the values of a fan-out all followers got. It must be called
+// with the lock of the flight held.
+func (f *flight) trim() {
+	next := f.base + len(f.values)
+	for fl := range f.followers {
+		if fl.next < next {
+			next = fl.next
+		}
+	}
+	f.values = append([]interface{}(nil), f.values[next-f.base:]...)
+	f.base = next
+}
+
+func (f *flight) finish(err error) {
+	f.update(func() {
+		f.done = true
+		f.err = err
+	})
+}
+
+// join adds a follower to the flight, unless it is closed or done.
+func (f *flight) join() *follower {
+	f.l.Lock()
+	defer f.l.Unlock()
+	if f.closed || f.done {
+		return nil
+	}
+	fl := &follower{next: f.base}
+	if f.fanOut {
+		fl.next += len(f.values)
+	}
+	f.followers[fl] = struct{}{}
+	return fl
+}
+
+// leave removes a follower from the flight. Fan-outs are canceled once their
+// last follower left.
+func (f *flight) leave(fl *follower) {
+	f.update(func() {
+		delete(f.followers, fl)
+		if !f.fanOut {
+			return
+		}
+		f.trim()
+		if len(f.followers) == 0 {
+			f.close()
+			if f.cancel != nil {
+				f.cancel()
+			}
+		}
+	})
+}
+
+// follow sends the values of the flight to re, until the flight is done.
+func (f *flight) follow(fl *follower, req *Request, re ResponseEmitter) error {
+	defer f.leave(fl)
+
+	for {
+		f.l.Lock()
+		if f.closed {
+			f.l.Unlock()
+			return errFlightTooLarge
+		}
+		values := f.values[fl.next-f.base:]
+		length := f.length
+		f.l.Unlock()
+
+		if fl.next == 0 && length > 0 {
+			re.SetLength(length)
+		}
+		for _, v := range values {
+			if w, ok := v.(Warning); ok {
+				Warn(re, w.Message)
+				continue
+			}
+			if err := re.Emit(replay(v)); err != nil {
+				return err
+			}
+		}
+		if len(values) > 0 {
+			// wakes up fan-outs waiting for their followers
+			f.update(func() {
+				fl.next += len(values)
+				if f.fanOut {
+					f.trim()
+				}
+			})
+		}
+
+		f.l.Lock()
+		pending := f.closed || fl.next < f.base+len(f.values)
+		done, err, changed := f.done, f.err, f.changed
+		f.l.Unlock()
+		if pending {
+			continue
+		}
+		if done {
+			return err
+		}
+
+		select {
+		case <-changed:
+		case <-req.Context.Done():
+			return req.Context.Err()
+		}
+	}
+}
+
+// flightEmitter records the values emitted by the execution of a flight.
+type flightEmitter struct {
+	EmitterWrapper
+	f *flight
+}
+
+func (re *flightEmitter) Emit(v interface{}) error {
+	if ch, ok := v.(chan interface{}); ok {
+		v = (<-chan interface{})(ch)
+	}
+	if ch, isChan := v.(<-chan interface{}); isChan {
+		return EmitChan(re, ch)
+	}
+
+	// readers can only be read once, so their data is kept and every
+	// request gets a reader of its own. Without followers, they are sent
+	// as they are, and the flight takes no more.
+	orig := v
+	single, isSingle := v.(Single)
+	if isSingle {
+		v = single.Value
+	}
+	r, isReader := v.(io.Reader)
+
+	f := re.f
+	f.l.Lock()
+	if isReader && len(f.followers) == 0 {
+		f.close()
+	}
+	closed, budget := f.closed, maxFlightBytes-f.size
+	f.l.Unlock()
+	if closed {
+		return re.ResponseEmitter.Emit(orig)
+	}
+
+	var size int
+	if isReader {
+		data, err := ioutil.ReadAll(io.LimitReader(r, int64(budget)+1))
+		if err != nil {
+			return err
+		}
+		if len(data) > budget {
+			f.update(f.close)
+			return re.ResponseEmitter.Emit(wrapSingle(io.MultiReader(bytes.NewReader(data), r), isSingle))
+		}
+		v, size = sharedData(data), len(data)
+	}
+	v = wrapSingle(v, isSingle)
+
+	f.update(func() {
+		if f.closed {
+			return
+		}
+		if len(f.values) >= maxFlightValues {
+			f.close()
+			return
+		}
+		f.values = append(f.values, v)
+		f.size += size
+	})
+	return re.ResponseEmitter.Emit(replay(v))
+}
+
+// wrapSingle returns v as a Single if single is set.
+func wrapSingle(v interface{}, single bool) interface{} {
+	if single {
+		return Single{v}
+	}
+	return v
+}
+
+// sharedData is the data of a reader emitted in a flight.
+type sharedData []byte
+
+// replay returns v as it is emitted to a request of a flight.
+func replay(v interface{}) interface{} {
+	switch v := v.(type) {
+	case sharedData:
+		return bytes.NewReader(v)
+	case Single:
+		if data, ok := v.Value.(sharedData); ok {
+			return Single{bytes.NewReader(data)}
+		}
+	}
+	return v
+}
+
+func (re *flightEmitter) SetLength(l uint64) {
+	re.f.update(func() {
+		re.f.length = l
+	})
+	re.ResponseEmitter.SetLength(l)
+}
@@ -0,0 +1,353 @@
+package cmds
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// runAll executes req and returns the values received.
+func runAll(t *testing.T, root *Command, req *Request) ([]interface{}, error) {
+	re, res := NewChanResponsePair(req)
+	go func() {
+		if err := NewExecutor(root).Execute(req, re, nil); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	var values []interface{}
+	for {
+		v, err := res.Next()
+		if err == io.EOF {
+			return values, nil
+		}
+		if err != nil {
+			return values, err
+		}
+		values = append(values, v)
+	}
+}
+
+func TestExclusive(t *testing.T) {
+	var running, maxRunning int32
+	started := make(chan struct{}, 4)
+	release := make(chan struct{})
+
+	run := func(req *Request, re ResponseEmitter, env Environment) error {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		if n > atomic.LoadInt32(&maxRunning) {
+			atomic.StoreInt32(&maxRunning, n)
+		}
+		started <- struct{}{}
+		<-release
+		return nil
+	}
+	root := &Command{
+		Subcommands: map[string]*Command{
+			"gc":      {Exclusive: true, Run: run},
+			"gc-fail": {Exclusive: true, FailIfBusy: true, Run: run},
+		},
+	}
+
+	newReq := func(path string) *Request {
+		req, err := NewRequest(context.Background(), []string{path}, nil, nil, nil, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return req
+	}
+
+	var wg sync.WaitGroup
+	for _, path := range []string{"gc", "gc", "gc", "gc-fail"} {
+		wg.Add(1)
+		go func(req *Request) {
+			defer wg.Done()
+			if _, err := runAll(t, root, req); err != nil {
+				t.Error(err)
+			}
+		}(newReq(path))
+	}
+
+	// wait for one gc and the gc-fail to run
+	<-started
+	<-started
+
+	// with FailIfBusy, requests fail instead of waiting
+	_, err := runAll(t, root, newReq("gc-fail"))
+	if e, ok := err.(*Error); !ok || e.Code != ErrRateLimited {
+		t.Errorf("expected rate limited error, got %#v", err)
+	}
+
+	close(release)
+	wg.Wait()
+
+	// the exclusive commands only ran alongside each other
+	if m := atomic.LoadInt32(&maxRunning); m != 2 {
+		t.Errorf("expected two requests at a time, got %d", m)
+	}
+}
+
+func TestSingleflight(t *testing.T) {
+	var runs int32
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	root := &Command{
+		Subcommands: map[string]*Command{
+			"migrate": {
+				Singleflight: true,
+				Arguments:    []Argument{StringArg("repo", true, false, "")},
+				Run: func(req *Request, re ResponseEmitter, env Environment) error {
+					atomic.AddInt32(&runs, 1)
+					if err := re.Emit(req.Arguments[0] + " 1"); err != nil {
+						return err
+					}
+					if req.Arguments[0] == "a" && Identity(req.Context) == "" {
+						close(started)
+						<-release
+					}
+					return re.Emit(req.Arguments[0] + " 2")
+				},
+				Type: "",
+			},
+		},
+	}
+
+	run := func(ctx context.Context, arg string, out *[]interface{}, wg *sync.WaitGroup) {
+		defer wg.Done()
+		req, err := NewRequest(ctx, []string{"migrate"}, nil, []string{arg}, nil, root)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if *out, err = runAll(t, root, req); err != nil {
+			t.Error(err)
+		}
+	}
+
+	var (
+		wg             sync.WaitGroup
+		leader, joined []interface{}
+		other, eve     []interface{}
+	)
+	ctx := context.Background()
+	wg.Add(1)
+	go run(ctx, "a", &leader, &wg)
+	<-started
+
+	wg.Add(3)
+	go run(ctx, "a", &joined, &wg)
+	// requests with other arguments or of other identities run on their own
+	go run(ctx, "b", &other, &wg)
+	go run(ContextWithIdentity(ctx, "eve"), "a", &eve, &wg)
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	exp := []interface{}{"a 1", "a 2"}
+	if !reflect.DeepEqual(leader, exp) || !reflect.DeepEqual(joined, exp) || !reflect.DeepEqual(eve, exp) {
+		t.Errorf("expected %v for all requests, got %v, %v and %v", exp, leader, joined, eve)
+	}
+	if exp := []interface{}{"b 1", "b 2"}; !reflect.DeepEqual(other, exp) {
+		t.Errorf("expected %v, got %v", exp, other)
+	}
+	if n := atomic.LoadInt32(&runs); n != 3 {
+		t.Errorf("expected 3 runs, got %d", n)
+	}
+}
+
+func TestSingleflightReader(t *testing.T) {
+	var runs int32
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	root := &Command{
+		Subcommands: map[string]*Command{
+			"cat": {
+				Singleflight: true,
+				Run: func(req *Request, re ResponseEmitter, env Environment) error {
+					if atomic.AddInt32(&runs, 1) == 1 {
+						close(started)
+						<-release
+					}
+					return re.Emit(strings.NewReader("data"))
+				},
+			},
+		},
+	}
+
+	read := func(out *string, wg *sync.WaitGroup) {
+		defer wg.Done()
+		req, err := NewRequest(context.Background(), []string{"cat"}, nil, nil, nil, root)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		values, err := runAll(t, root, req)
+		if err != nil || len(values) != 1 {
+			t.Errorf("expected a reader, got %v, %v", values, err)
+			return
+		}
+		data, _ := ioutil.ReadAll(values[0].(io.Reader))
+		*out = string(data)
+	}
+
+	// the reader is shared with the request following the flight
+	var (
+		wg             sync.WaitGroup
+		leader, joined string
+	)
+	wg.Add(1)
+	go read(&leader, &wg)
+	<-started
+	wg.Add(1)
+	go read(&joined, &wg)
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if leader != "data" || joined != "data" {
+		t.Errorf("expected the data for both requests, got %q and %q", leader, joined)
+	}
+	if n := atomic.LoadInt32(&runs); n != 1 {
+		t.Errorf("expected 1 run, got %d", n)
+	}
+
+	// without followers, readers aren't kept
+	req, err := NewRequest(context.Background(), []string{"cat"}, nil, nil, nil, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cre, res := NewChanResponsePair(req)
+	go res.Next()
+	f := newFlight(false)
+	if err := (&flightEmitter{EmitterWrapper: EmitterWrapper{cre}, f: f}).Emit(strings.NewReader("data")); err != nil {
+		t.Fatal(err)
+	}
+	if f.values != nil || f.join() != nil {
+		t.Errorf("expected the flight to take no followers, got %+v", f)
+	}
+}
+
+func TestFanOut(t *testing.T) {
+	var (
+		runs    int32
+		tempDir atomic.Value
+	)
+	feed := make(chan string)
+	stopped := make(chan struct{})
+
+	tail := &Command{
+		FanOut: true,
+		Run: func(req *Request, re ResponseEmitter, env Environment) error {
+			atomic.AddInt32(&runs, 1)
+			defer close(stopped)
+			dir, err := req.TempDir()
+			if err != nil {
+				return err
+			}
+			tempDir.Store(dir)
+			for {
+				select {
+				case line := <-feed:
+					if err := re.Emit(line); err != nil {
+						return err
+					}
+				case <-req.Context.Done():
+					return req.Context.Err()
+				}
+			}
+		},
+		Type: "",
+	}
+	root := &Command{Subcommands: map[string]*Command{"tail": tail}}
+
+	type client struct {
+		cancel context.CancelFunc
+		res    Response
+	}
+	attach := func() client {
+		ctx, cancel := context.WithCancel(context.Background())
+		req, err := NewRequest(ctx, []string{"tail"}, nil, nil, nil, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		re, res := NewChanResponsePair(req)
+		go root.Call(req, re, nil)
+		return client{cancel: cancel, res: res}
+	}
+	// waitSubs waits until n requests are attached to the execution.
+	waitSubs := func(n int) {
+		g := getGuard(tail)
+		for i := 0; ; i++ {
+			g.l.Lock()
+			attached := 0
+			for _, f := range g.fanOuts {
+				f.l.Lock()
+				attached += len(f.followers)
+				f.l.Unlock()
+			}
+			g.l.Unlock()
+			if attached == n {
+				return
+			}
+			if i == 100 {
+				t.Fatalf("expected %d requests attached, got %d", n, attached)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	expect := func(c client, exp string) {
+		v, err := c.res.Next()
+		if err != nil || v != exp {
+			t.Fatalf("expected %q, got %v, %v", exp, v, err)
+		}
+	}
+
+	a := attach()
+	waitSubs(1)
+	feed <- "1"
+	expect(a, "1")
+
+	// b only receives the values emitted after it joined
+	b := attach()
+	waitSubs(2)
+	feed <- "2"
+	expect(a, "2")
+	expect(b, "2")
+
+	// the execution goes on while requests are attached
+	a.cancel()
+	waitSubs(1)
+	feed <- "3"
+	expect(b, "3")
+
+	b.cancel()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("expected the execution to be canceled without requests")
+	}
+	if n := atomic.LoadInt32(&runs); n != 1 {
+		t.Errorf("expected 1 run, got %d", n)
+	}
+
+	// the execution has a temp dir of its own, removed once it's done
+	for i := 0; ; i++ {
+		if _, err := os.Stat(tempDir.Load().(string)); os.IsNotExist(err) {
+			break
+		}
+		if i == 100 {
+			t.Fatal("expected the temp dir of the execution to be removed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,52 @@
+package cmds
+
+// Weights of the expected costs of commands, see Cost.Weight.
+const (
+	ExpensiveWeight = 10
+	StreamingWeight = 4
+)
+
+// Cost is the expected cost of the requests to a command, declared in its
+// Extra with SetCost. Quota and concurrency policies weigh requests by it,
+// so walking a whole DAG doesn't count the same as asking for the version.
+// Commands that don't declare a cost are cheap and bounded.
+type Cost struct {
+	// Expensive marks commands doing a lot of work per request.
+	Expensive bool
+	// Streaming marks commands whose responses aren't bounded, e.g. ones
+	// emitting values as they find them. Subscriptions always stream.
+	Streaming bool
+}
+
+// Weight returns the weight of a request with cost c: 1 for cheap, bounded
+// requests, multiplied by ExpensiveWeight and StreamingWeight for expensive
+// and streaming ones.
+func (c Cost) Weight() uint64 {
+	w := uint64(1)
+	if c.Expensive {
+		w *= ExpensiveWeight
+	}
+	if c.Streaming {
+		w *= StreamingWeight
+	}
+	return w
+}
+
+type costKey struct{}
+
+// SetCost declares the expected cost of the requests to the command.
+func (e *Extra) SetCost(c Cost) *Extra {
+	return e.SetValue(costKey{}, c)
+}
+
+// CommandCost returns the expected cost of the requests to cmd.
+func CommandCost(cmd *Command) Cost {
+	var c Cost
+	if v, ok := cmd.Extra.GetValue(costKey{}); ok {
+		c, _ = v.(Cost)
+	}
+	if cmd.Subscription {
+		c.Streaming = true
+	}
+	return c
+}
@@ -0,0 +1,277 @@
+package debug
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+// LifecycleEnv is the environment variable that enables lifecycle tracking
+// on start up.
+const LifecycleEnv = "CMDS_DEBUG_LIFECYCLE"
+
+// DefaultIdle is the default of the idle option of EmittersCmd.
+const DefaultIdle = 5 * time.Minute
+
+// IdleOpt is the name of the option of EmittersCmd setting how long an
+// emitter must have been idle to be listed.
+const IdleOpt = "idle"
+
+// Emitter lifecycle events.
+const (
+	EventCreated    = "created"
+	EventFirstEmit  = "first emit"
+	EventSetLength  = "set length"
+	EventClosed     = "closed"
+	EventCloseError = "close error"
+)
+
+var lifecycleEnabled int32
+
+func init() {
+	if on, err := strconv.ParseBool(os.Getenv(LifecycleEnv)); err == nil && on {
+		EnableLifecycle()
+	}
+
+	cmds.RegisterEmitterDecorator(trackEmitter)
+}
+
+// EnableLifecycle starts tracking the lifecycle of the emitters of commands
+// started from now on. Unlike tracing, tracking doesn't log anything: the
+// emitters that are still open can be listed with OpenEmitters or
+// EmittersCmd, e.g. to find the commands a hung client is waiting on.
+func EnableLifecycle() {
+	atomic.StoreInt32(&lifecycleEnabled, 1)
+}
+
+// DisableLifecycle stops tracking the emitters of commands started from now
+// on. Emitters that are already tracked are tracked until they are closed.
+func DisableLifecycle() {
+	atomic.StoreInt32(&lifecycleEnabled, 0)
+}
+
+// LifecycleEnabled reports whether lifecycle tracking is enabled.
+func LifecycleEnabled() bool {
+	return atomic.LoadInt32(&lifecycleEnabled) == 1
+}
+
+// EmitterEvent is a state transition of an emitter.
+type EmitterEvent struct {
+	Time   time.Time
+	Event  string
+	Length uint64 `json:",omitempty"`
+	Error  string `json:",omitempty"`
+}
+
+// EmitterState is the lifecycle of the emitter of a request.
+type EmitterState struct {
+	RequestID    string `json:",omitempty"`
+	Path         []string
+	Created      time.Time
+	LastActivity time.Time
+	Emitted      uint64
+	Events       []EmitterEvent
+}
+
+// Idle returns how long the emitter has been inactive at now.
+func (s *EmitterState) Idle(now time.Time) time.Duration {
+	return now.Sub(s.LastActivity)
+}
+
+var (
+	trackedLk sync.Mutex
+	tracked   = make(map[*trackedEmitter]struct{})
+)
+
+// OpenEmitters returns the state of the tracked emitters that are still open,
+// or that failed to close, and have been inactive for at least idle, longest
+// idle first.
+func OpenEmitters(idle time.Duration) []*EmitterState {
+	trackedLk.Lock()
+	all := make([]*trackedEmitter, 0, len(tracked))
+	for re := range tracked {
+		all = append(all, re)
+	}
+	trackedLk.Unlock()
+
+	now := time.Now()
+	states := make([]*EmitterState, 0, len(all))
+	for _, re := range all {
+		if s := re.snapshot(); s.Idle(now) >= idle {
+			states = append(states, s)
+		}
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].LastActivity.Before(states[j].LastActivity)
+	})
+	return states
+}
+
+func trackEmitter(req *cmds.Request, re cmds.ResponseEmitter) cmds.ResponseEmitter {
+	if !LifecycleEnabled() {
+		return re
+	}
+
+	now := time.Now()
+	t := &trackedEmitter{
+		ResponseEmitter: re,
+		state: EmitterState{
+			Path:         req.Path,
+			Created:      now,
+			LastActivity: now,
+			Events:       []EmitterEvent{{Time: now, Event: EventCreated}},
+		},
+	}
+	if req.Context != nil {
+		t.state.RequestID = cmds.RequestID(req.Context)
+	}
+
+	trackedLk.Lock()
+	tracked[t] = struct{}{}
+	trackedLk.Unlock()
+	return t
+}
+
+type trackedEmitter struct {
+	cmds.ResponseEmitter
+
+	l      sync.Mutex
+	state  EmitterState
+	closed bool
+}
+
+// event records ev, if the emitter is still open.
+func (re *trackedEmitter) event(ev EmitterEvent) {
+	re.l.Lock()
+	defer re.l.Unlock()
+	if re.closed {
+		return
+	}
+	re.state.LastActivity = ev.Time
+	re.state.Events = append(re.state.Events, ev)
+}
+
+func (re *trackedEmitter) snapshot() *EmitterState {
+	re.l.Lock()
+	defer re.l.Unlock()
+	s := re.state
+	s.Events = append([]EmitterEvent(nil), s.Events...)
+	return &s
+}
+
+func (re *trackedEmitter) Emit(v interface{}) error {
+	err := re.ResponseEmitter.Emit(v)
+
+	now := time.Now()
+	re.l.Lock()
+	defer re.l.Unlock()
+	if re.closed {
+		return err
+	}
+	re.state.Emitted++
+	re.state.LastActivity = now
+	if re.state.Emitted == 1 {
+		re.state.Events = append(re.state.Events, EmitterEvent{Time: now, Event: EventFirstEmit})
+	}
+	return err
+}
+
+func (re *trackedEmitter) SetLength(length uint64) {
+	re.ResponseEmitter.SetLength(length)
+	re.event(EmitterEvent{Time: time.Now(), Event: EventSetLength, Length: length})
+}
+
+func (re *trackedEmitter) Close() error {
+	return re.CloseWithError(nil)
+}
+
+func (re *trackedEmitter) CloseWithError(err error) error {
+	closeErr := re.ResponseEmitter.CloseWithError(err)
+
+	ev := EmitterEvent{Time: time.Now(), Event: EventClosed}
+	if err != nil {
+		ev.Error = err.Error()
+	}
+	if closeErr != nil && closeErr != cmds.ErrClosingClosedEmitter {
+		ev.Event = EventCloseError
+		ev.Error = closeErr.Error()
+	}
+	re.event(ev)
+
+	re.l.Lock()
+	re.closed = true
+	re.l.Unlock()
+
+	// emitters that failed to close stay listed, as whatever they were
+	// writing to may still be waiting for them
+	if ev.Event == EventClosed {
+		trackedLk.Lock()
+		delete(tracked, re)
+		trackedLk.Unlock()
+	}
+	return closeErr
+}
+
+func (re *trackedEmitter) Warn(msg string) {
+	cmds.Warn(re.ResponseEmitter, msg)
+}
+
+// EmittersCmd lists the open emitters that have been idle for a while, see
+// EnableLifecycle. Applications can mount it anywhere in their command tree,
+// which also makes it available over HTTP.
+var EmittersCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "List idle open response emitters.",
+		ShortDescription: `
+Lists the requests whose response emitters are still open but have been
+inactive for at least the idle duration, with the events of their lifecycle.
+Only emitters created while lifecycle tracking is enabled are listed.
+`,
+	},
+	Options: []cmds.Option{
+		cmds.StringOption(IdleOpt, "List emitters inactive for at least this long.").WithDefault(DefaultIdle.String()),
+	},
+	Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+		idle, err := time.ParseDuration(req.Options[IdleOpt].(string))
+		if err != nil {
+			return cmds.Errorf(cmds.ErrClient, "invalid idle duration: %s", err)
+		}
+		if !LifecycleEnabled() {
+			cmds.Warn(re, "lifecycle tracking is disabled")
+		}
+		return cmds.EmitOnce(re, OpenEmitters(idle))
+	},
+	Type: []*EmitterState{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, states []*EmitterState) error {
+			now := time.Now()
+			for _, s := range states {
+				if _, err := fmt.Fprintf(w, "%s %s: idle %s, %d emitted\n",
+					s.RequestID, strings.Join(s.Path, " "), s.Idle(now).Round(time.Second), s.Emitted); err != nil {
+					return err
+				}
+				for _, ev := range s.Events {
+					line := fmt.Sprintf("  %s %s", ev.Time.Format(time.RFC3339), ev.Event)
+					if ev.Event == EventSetLength {
+						line += fmt.Sprintf(" %d", ev.Length)
+					}
+					if ev.Error != "" {
+						line += ": " + ev.Error
+					}
+					if _, err := fmt.Fprintln(w, line); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		}),
+	},
+}
@@ -0,0 +1,78 @@
+package debug
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+func TestLifecycle(t *testing.T) {
+	block := make(chan struct{})
+	root := &cmds.Command{
+		Subcommands: map[string]*cmds.Command{
+			"hang": {
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+					re.SetLength(3)
+					if err := re.Emit(1); err != nil {
+						return err
+					}
+					<-block
+					return nil
+				},
+			},
+		},
+	}
+
+	EnableLifecycle()
+	defer DisableLifecycle()
+
+	req, err := cmds.NewRequest(context.Background(), []string{"hang"}, nil, nil, nil, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	re, res := cmds.NewChanResponsePair(req)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := cmds.NewExecutor(root).Execute(req, re, nil); err != nil {
+			t.Error(err)
+		}
+	}()
+	if _, err := res.Next(); err != nil {
+		t.Fatal(err)
+	}
+
+	if states := OpenEmitters(time.Hour); len(states) != 0 {
+		t.Errorf("expected no emitter idle for an hour, got %d", len(states))
+	}
+
+	states := OpenEmitters(0)
+	if len(states) != 1 {
+		t.Fatalf("expected 1 open emitter, got %d", len(states))
+	}
+	s := states[0]
+	if !reflect.DeepEqual(s.Path, []string{"hang"}) || s.Emitted != 1 {
+		t.Errorf("unexpected state %+v", s)
+	}
+	var events []string
+	for _, ev := range s.Events {
+		events = append(events, ev.Event)
+	}
+	if exp := []string{EventCreated, EventSetLength, EventFirstEmit}; !reflect.DeepEqual(events, exp) {
+		t.Errorf("expected events %v, got %v", exp, events)
+	}
+
+	close(block)
+	if _, err := res.Next(); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+	<-done
+
+	if states := OpenEmitters(0); len(states) != 0 {
+		t.Errorf("expected closed emitters not to be listed, got %d", len(states))
+	}
+}
@@ -0,0 +1,126 @@
+package debug
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+// StrictEnv is the environment variable that enables strict type checking
+// on start up. "panic" enables it with panics, other true values without.
+const StrictEnv = "CMDS_DEBUG_STRICT"
+
+// strictMode is 0 if strict type checking is off, 1 if mismatches are
+// returned as errors and 2 if they panic.
+var strictMode int32
+
+func init() {
+	if v := os.Getenv(StrictEnv); v == "panic" {
+		EnableStrict(true)
+	} else if on, err := strconv.ParseBool(v); err == nil && on {
+		EnableStrict(false)
+	}
+
+	cmds.RegisterEmitterDecorator(strictEmitter)
+}
+
+// EnableStrict starts checking that the values emitted by commands started
+// from now on are of the Type of the command, or of one of its OutputTypes.
+// Emitting a value of another type, which clients fail to decode, is
+// reported right away: Emit returns an error of type ErrImplementation, or
+// panics if panics is set, e.g. in tests. Errors, readers and progress
+// reports can always be emitted, and so can anything by commands without a
+// Type.
+func EnableStrict(panics bool) {
+	mode := int32(1)
+	if panics {
+		mode = 2
+	}
+	atomic.StoreInt32(&strictMode, mode)
+}
+
+// DisableStrict stops checking the values of commands started from now on.
+func DisableStrict() {
+	atomic.StoreInt32(&strictMode, 0)
+}
+
+// StrictEnabled reports whether strict type checking is enabled.
+func StrictEnabled() bool {
+	return atomic.LoadInt32(&strictMode) != 0
+}
+
+func strictEmitter(req *cmds.Request, re cmds.ResponseEmitter) cmds.ResponseEmitter {
+	mode := atomic.LoadInt32(&strictMode)
+	if mode == 0 || req.Command == nil || req.Command.Type == nil {
+		return re
+	}
+	return cmds.WrapEmitter(&checkedEmitter{EmitterWrapper: cmds.EmitterWrapper{ResponseEmitter: re}, req: req, panics: mode == 2}, re)
+}
+
+type checkedEmitter struct {
+	cmds.EmitterWrapper
+	req    *cmds.Request
+	panics bool
+}
+
+func (re *checkedEmitter) Emit(v interface{}) error {
+	if ch, ok := v.(chan interface{}); ok {
+		v = (<-chan interface{})(ch)
+	}
+	if ch, isChan := v.(<-chan interface{}); isChan {
+		return cmds.EmitChan(re, ch)
+	}
+
+	value := v
+	if single, ok := v.(cmds.Single); ok {
+		value = single.Value
+	}
+	if err := checkType(re.req.Command, value); err != nil {
+		err = cmds.Errorf(cmds.ErrImplementation, "command %q: %s", strings.Join(re.req.Path, " "), err)
+		if re.panics {
+			panic(err)
+		}
+		return err
+	}
+	return re.ResponseEmitter.Emit(v)
+}
+
+// checkType returns an error if cmd may not emit v.
+func checkType(cmd *cmds.Command, v interface{}) error {
+	switch v.(type) {
+	case nil, error, io.Reader:
+		return nil
+	}
+	if _, ok := cmds.AsProgress(cmd, v); ok {
+		return nil
+	}
+
+	t := reflect.TypeOf(v)
+	if assignable(t, reflect.TypeOf(cmd.Type)) {
+		return nil
+	}
+	for _, ot := range cmd.OutputTypes {
+		if assignable(t, reflect.TypeOf(ot.Type)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("emitted a value of type %s, expected %s", t, reflect.TypeOf(cmd.Type))
+}
+
+// assignable reports whether values of type t can be decoded into values of
+// type typ, taking pointers and the values they point to alike.
+func assignable(t, typ reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	return t.AssignableTo(typ)
+}
@@ -0,0 +1,86 @@
+package debug
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+type entry struct{ Name string }
+
+type otherEntry struct{ Size int }
+
+func TestStrict(t *testing.T) {
+	emit := func(values ...interface{}) *cmds.Command {
+		return &cmds.Command{
+			Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+				for _, v := range values {
+					if err := re.Emit(v); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			Type:        &entry{},
+			OutputTypes: []cmds.OutputType{{Name: "size", Type: otherEntry{}}},
+		}
+	}
+	root := &cmds.Command{
+		Subcommands: map[string]*cmds.Command{
+			"good": emit(&entry{"a"}, entry{"b"}, &otherEntry{1}, cmds.Progress{ItemsDone: 1}, strings.NewReader("c")),
+			"bad":  emit(&entry{"a"}, "b"),
+		},
+	}
+
+	run := func(path string) error {
+		req, err := cmds.NewRequest(context.Background(), []string{path}, nil, nil, nil, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		re, res := cmds.NewChanResponsePair(req)
+		go root.Call(req, re, nil)
+		for {
+			if _, err := res.Next(); err != nil {
+				if err == io.EOF {
+					return nil
+				}
+				return err
+			}
+		}
+	}
+
+	// the mismatch goes unnoticed without strict checking
+	if err := run("bad"); err != nil {
+		t.Fatal(err)
+	}
+
+	EnableStrict(false)
+	defer DisableStrict()
+
+	if err := run("good"); err != nil {
+		t.Errorf("unexpected error %s", err)
+	}
+	err := run("bad")
+	if e, ok := err.(*cmds.Error); !ok || e.Code != cmds.ErrImplementation || !strings.Contains(e.Message, "of type string") {
+		t.Errorf("expected an implementation error, got %v", err)
+	}
+
+	EnableStrict(true)
+	req, err := cmds.NewRequest(context.Background(), []string{"bad"}, nil, nil, nil, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	re, _ := cmds.NewChanResponsePair(req)
+	re = strictEmitter(req, re)
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected a panic")
+			}
+		}()
+		re.Emit("b")
+	}()
+}
@@ -0,0 +1,200 @@
+// Package debug provides tracing of the values that flow through commands.
+//
+// Importing the package installs the tracing hooks. Tracing is off until it
+// is enabled with EnableTracing or by setting the environment variable named
+// by TraceEnv to a true value, e.g. CMDS_DEBUG_TRACE=1. Traces are written at
+// debug level to the logger of the request, see cmds.SetLogger.
+//
+// The package also tracks the lifecycle of response emitters, to find the
+// commands hung clients are waiting on. Tracking is off until it is enabled
+// with EnableLifecycle or by setting the environment variable named by
+// LifecycleEnv, and the emitters it finds idle are listed by EmittersCmd.
+//
+// Strict type checking, enabled with EnableStrict or StrictEnv, makes
+// emitting values of the wrong type fail right away, instead of on the
+// client trying to decode them.
+package debug
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"runtime"
+	rdebug "runtime/debug"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+// TraceEnv is the environment variable that enables tracing on start up.
+const TraceEnv = "CMDS_DEBUG_TRACE"
+
+// DefaultStallTimeout is the default of StallTimeout.
+const DefaultStallTimeout = 5 * time.Second
+
+var log = cmds.SubsystemLogger("cmds/debug")
+
+var (
+	enabled      int32
+	stallTimeout = int64(DefaultStallTimeout)
+)
+
+func init() {
+	if on, err := strconv.ParseBool(os.Getenv(TraceEnv)); err == nil && on {
+		EnableTracing()
+	}
+
+	cmds.RegisterEmitterDecorator(traceEmitter)
+	cmds.RegisterResponseDecorator(traceResponse)
+}
+
+// EnableTracing starts tracing all Emit and Next calls of commands started
+// from now on.
+func EnableTracing() {
+	atomic.StoreInt32(&enabled, 1)
+}
+
+// DisableTracing stops tracing commands started from now on. Commands that
+// are already running keep being traced.
+func DisableTracing() {
+	atomic.StoreInt32(&enabled, 0)
+}
+
+// TracingEnabled reports whether tracing is enabled.
+func TracingEnabled() bool {
+	return atomic.LoadInt32(&enabled) == 1
+}
+
+// SetStallTimeout sets the time after which an Emit or Next call that
+// hasn't returned yet is reported as stalled. Zero disables the reports.
+func SetStallTimeout(d time.Duration) {
+	atomic.StoreInt64(&stallTimeout, int64(d))
+}
+
+// tracer holds what is common to the traces of a request.
+type tracer struct {
+	log    cmds.Logger
+	fields []interface{}
+}
+
+func newTracer(req *cmds.Request, side string) *tracer {
+	fields := []interface{}{"side", side}
+	if req != nil {
+		fields = append(fields, "path", strings.Join(req.Path, "/"))
+		if id := cmds.RequestID(req.Context); id != "" {
+			fields = append(fields, "requestId", id)
+		}
+		return &tracer{log: cmds.ContextLogger(req.Context, log), fields: fields}
+	}
+	return &tracer{log: log, fields: fields}
+}
+
+// with returns the tracer's fields, the calling goroutine and kv.
+func (t *tracer) with(kv ...interface{}) []interface{} {
+	fields := make([]interface{}, 0, len(t.fields)+2+len(kv))
+	fields = append(fields, t.fields...)
+	fields = append(fields, "goroutine", goroutineID())
+	return append(fields, kv...)
+}
+
+// watch reports op as stalled if the returned function isn't called within
+// the stall timeout.
+func (t *tracer) watch(op string) func() {
+	d := time.Duration(atomic.LoadInt64(&stallTimeout))
+	if d <= 0 {
+		return func() {}
+	}
+
+	fields := t.with("op", op, "waiting", d)
+	timer := time.AfterFunc(d, func() {
+		t.log.Warn("call stalled", fields...)
+	})
+	return func() { timer.Stop() }
+}
+
+func traceEmitter(req *cmds.Request, re cmds.ResponseEmitter) cmds.ResponseEmitter {
+	if !TracingEnabled() {
+		return re
+	}
+	return cmds.WrapEmitter(&tracedEmitter{EmitterWrapper: cmds.EmitterWrapper{ResponseEmitter: re}, t: newTracer(req, "emitter")}, re)
+}
+
+type tracedEmitter struct {
+	cmds.EmitterWrapper
+	t *tracer
+	n uint64
+}
+
+func (re *tracedEmitter) Emit(v interface{}) error {
+	n := atomic.AddUint64(&re.n, 1)
+	done := re.t.watch("Emit")
+	start := time.Now()
+	err := re.ResponseEmitter.Emit(v)
+	done()
+
+	re.t.log.Debug("Emit", re.t.with("n", n, "type", fmt.Sprintf("%T", v), "duration", time.Since(start), "error", err)...)
+	return err
+}
+
+func (re *tracedEmitter) Close() error {
+	return re.CloseWithError(nil)
+}
+
+func (re *tracedEmitter) CloseWithError(err error) error {
+	closeErr := re.ResponseEmitter.CloseWithError(err)
+	if closeErr == cmds.ErrClosingClosedEmitter {
+		re.t.log.Warn("double close", re.t.with("error", err, "stack", string(rdebug.Stack()))...)
+		return closeErr
+	}
+
+	re.t.log.Debug("CloseWithError", re.t.with("emitted", atomic.LoadUint64(&re.n), "error", err, "closeError", closeErr)...)
+	return closeErr
+}
+
+func traceResponse(res cmds.Response) cmds.Response {
+	if !TracingEnabled() {
+		return res
+	}
+	return &tracedResponse{Response: res, t: newTracer(res.Request(), "response")}
+}
+
+type tracedResponse struct {
+	cmds.Response
+	t *tracer
+	n uint64
+}
+
+func (res *tracedResponse) Next() (interface{}, error) {
+	done := res.t.watch("Next")
+	start := time.Now()
+	v, err := res.Response.Next()
+	done()
+
+	if err == nil {
+		res.n++
+		res.t.log.Debug("Next", res.t.with("n", res.n, "type", fmt.Sprintf("%T", v), "duration", time.Since(start))...)
+	} else {
+		res.t.log.Debug("Next", res.t.with("received", res.n, "duration", time.Since(start), "error", err)...)
+	}
+	return v, err
+}
+
+func (res *tracedResponse) Trailer() *cmds.Trailer {
+	return cmds.GetTrailer(res.Response)
+}
+
+// goroutineID returns the ID of the calling goroutine, parsed from its stack
+// trace header "goroutine N [...]".
+func goroutineID() uint64 {
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+	buf = bytes.TrimPrefix(buf, []byte("goroutine "))
+	if i := bytes.IndexByte(buf, ' '); i >= 0 {
+		buf = buf[:i]
+	}
+	id, _ := strconv.ParseUint(string(buf), 10, 64)
+	return id
+}
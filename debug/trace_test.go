@@ -0,0 +1,113 @@
+package debug
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+type testLogger struct {
+	l    sync.Mutex
+	msgs []string
+}
+
+func (l *testLogger) log(level, msg string) {
+	l.l.Lock()
+	defer l.l.Unlock()
+	l.msgs = append(l.msgs, level+" "+msg)
+}
+
+func (l *testLogger) count(msg string) int {
+	l.l.Lock()
+	defer l.l.Unlock()
+	var n int
+	for _, m := range l.msgs {
+		if m == msg {
+			n++
+		}
+	}
+	return n
+}
+
+func (l *testLogger) Debug(msg string, kv ...interface{}) { l.log("debug", msg) }
+func (l *testLogger) Info(msg string, kv ...interface{})  { l.log("info", msg) }
+func (l *testLogger) Warn(msg string, kv ...interface{})  { l.log("warn", msg) }
+func (l *testLogger) Error(msg string, kv ...interface{}) { l.log("error", msg) }
+
+func TestTracing(t *testing.T) {
+	root := &cmds.Command{
+		Subcommands: map[string]*cmds.Command{
+			"emit": {
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+					for i := 0; i < 3; i++ {
+						if err := re.Emit(i); err != nil {
+							return err
+						}
+					}
+					cmds.SetStatus(re, 3)
+					return re.Close()
+				},
+			},
+		},
+	}
+
+	run := func() *testLogger {
+		l := new(testLogger)
+		ctx := cmds.ContextWithLogger(context.Background(), l)
+		req, err := cmds.NewRequest(ctx, []string{"emit"}, nil, nil, nil, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		re, res := cmds.NewChanResponsePair(req)
+		go func() {
+			if err := cmds.NewExecutor(root).Execute(req, re, nil); err != nil {
+				t.Error(err)
+			}
+		}()
+		for {
+			// stall the emitter
+			time.Sleep(5 * time.Millisecond)
+			if _, err := res.Next(); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+		}
+		// the optional methods of the emitter are traced through
+		if tr := cmds.GetTrailer(res); tr == nil || tr.ExitStatus != 3 {
+			t.Errorf("expected exit status 3, got trailer %+v", tr)
+		}
+		return l
+	}
+
+	if l := run(); len(l.msgs) != 0 {
+		t.Errorf("expected no traces while disabled, got %v", l.msgs)
+	}
+
+	EnableTracing()
+	SetStallTimeout(time.Millisecond)
+	defer func() {
+		DisableTracing()
+		SetStallTimeout(DefaultStallTimeout)
+	}()
+
+	l := run()
+	for msg, exp := range map[string]int{
+		"debug Emit":           3,
+		"debug Next":           4,
+		"debug CloseWithError": 1,
+		"warn double close":    1,
+	} {
+		if n := l.count(msg); n != exp {
+			t.Errorf("expected %d %q traces, got %d", exp, msg, n)
+		}
+	}
+	if l.count("warn call stalled") == 0 {
+		t.Error("expected stalled calls to be reported")
+	}
+}
@@ -0,0 +1,220 @@
+package cmds
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// CommandDescription is a machine-readable description of a command and its
+// subcommands. Options are listed on the command that defines them and are
+// inherited by all subcommands.
+type CommandDescription struct {
+	Name             string
+	Path             []string
+	Tagline          string                `json:",omitempty"`
+	ShortDescription string                `json:",omitempty"`
+	LongDescription  string                `json:",omitempty"`
+	DocsURL          string                `json:",omitempty"`
+	Arguments        []ArgumentDescription `json:",omitempty"`
+	Options          []OptionDescription   `json:",omitempty"`
+	Examples         []Example             `json:",omitempty"`
+	Type             string                `json:",omitempty"`
+	Callable         bool
+	NoRemote         bool                  `json:",omitempty"`
+	NoLocal          bool                  `json:",omitempty"`
+	Mutating         bool                  `json:",omitempty"`
+	Subscription     bool                  `json:",omitempty"`
+	Simulate         bool                  `json:",omitempty"`
+	Exclusive        bool                  `json:",omitempty"`
+	Singleflight     bool                  `json:",omitempty"`
+	FanOut           bool                  `json:",omitempty"`
+	SingleOutput     bool                  `json:",omitempty"`
+	Requires         []string              `json:",omitempty"`
+	Subcommands      []*CommandDescription `json:",omitempty"`
+}
+
+// ArgumentDescription is a machine-readable description of an Argument.
+type ArgumentDescription struct {
+	Name          string
+	Type          string
+	Required      bool
+	Variadic      bool
+	SupportsStdin bool
+	Recursive     bool
+	Description   string `json:",omitempty"`
+}
+
+// OptionDescription is a machine-readable description of an Option.
+type OptionDescription struct {
+	Names []string
+	// DeprecatedNames are the old names the option still accepts, see
+	// RenamedOption.
+	DeprecatedNames []string `json:",omitempty"`
+	Type            string
+	Default         interface{} `json:",omitempty"`
+	Description     string      `json:",omitempty"`
+	// Group is the section the option is listed in, see GroupedOption.
+	Group string `json:",omitempty"`
+}
+
+// Describe returns the description of the command tree starting at root.
+// Subcommands are sorted by name, so the result is deterministic.
+func Describe(root *Command) *CommandDescription {
+	return describe(root, "", nil)
+}
+
+// DescribeCommand returns the description of the command at path in the tree
+// starting at root, e.g. for help output. Its subcommands are described
+// without theirs.
+func DescribeCommand(root *Command, path []string) (*CommandDescription, error) {
+	cmd, err := root.Get(path)
+	if err != nil {
+		return nil, err
+	}
+
+	name := ""
+	if len(path) > 0 {
+		name = path[len(path)-1]
+	}
+	d := describe(cmd, name, path)
+	for _, sub := range d.Subcommands {
+		sub.Subcommands = nil
+	}
+	return d, nil
+}
+
+// CommandsJSON returns the JSON encoded description of the command tree
+// starting at root.
+func CommandsJSON(root *Command) ([]byte, error) {
+	return json.Marshal(Describe(root))
+}
+
+func describe(cmd *Command, name string, path []string) *CommandDescription {
+	d := &CommandDescription{
+		Name:             name,
+		Path:             path,
+		Tagline:          cmd.Helptext.Tagline,
+		ShortDescription: cmd.Helptext.ShortDescription,
+		LongDescription:  cmd.Helptext.LongDescription,
+		DocsURL:          cmd.DocsURL,
+		Examples:         cmd.Helptext.Examples,
+		Type:             typeName(cmd.Type),
+		Callable:         cmd.Run != nil,
+		NoRemote:         cmd.NoRemote,
+		NoLocal:          cmd.NoLocal,
+		Mutating:         cmd.Mutating,
+		Subscription:     cmd.Subscription,
+		Simulate:         cmd.Simulate != nil,
+		Exclusive:        cmd.Exclusive,
+		Singleflight:     cmd.Singleflight,
+		FanOut:           cmd.FanOut,
+		SingleOutput:     cmd.SingleOutput,
+	}
+	if d.Path == nil {
+		d.Path = []string{}
+	}
+	for _, r := range cmd.Requires {
+		d.Requires = append(d.Requires, typeName(r))
+	}
+
+	for _, arg := range cmd.Arguments {
+		argType := "string"
+		if arg.Type == ArgFile {
+			argType = "file"
+		}
+		d.Arguments = append(d.Arguments, ArgumentDescription{
+			Name:          arg.Name,
+			Type:          argType,
+			Required:      arg.Required,
+			Variadic:      arg.Variadic,
+			SupportsStdin: arg.SupportsStdin,
+			Recursive:     arg.Recursive,
+			Description:   arg.Description,
+		})
+	}
+
+	for _, opt := range cmd.Options {
+		d.Options = append(d.Options, OptionDescription{
+			Names:           opt.Names(),
+			DeprecatedNames: DeprecatedNames(opt),
+			Type:            OptionTypeName(opt),
+			Default:         opt.Default(),
+			Description:     opt.Description(),
+			Group:           OptionGroup(opt),
+		})
+	}
+
+	names := make([]string, 0, len(cmd.Subcommands))
+	for name := range cmd.Subcommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		subPath := make([]string, len(path), len(path)+1)
+		copy(subPath, path)
+		subPath = append(subPath, name)
+		d.Subcommands = append(d.Subcommands, describe(cmd.Subcommands[name], name, subPath))
+	}
+
+	return d
+}
+
+func typeName(v interface{}) string {
+	t := reflect.TypeOf(v)
+	if t == nil {
+		return ""
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.String()
+}
+
+// JSONOpt is the name of the option that makes CommandsCmd print JSON.
+const JSONOpt = "json"
+
+// CommandsCmd lists the command tree it is mounted in. With --json the full
+// machine-readable description is printed, otherwise just the command paths.
+var CommandsCmd = &Command{
+	Helptext: HelpText{
+		Tagline:          "List all available commands.",
+		ShortDescription: "Lists all available commands and their subcommands.",
+	},
+	Options: []Option{
+		BoolOption(JSONOpt, "Print the full command tree as JSON."),
+	},
+	Run: func(req *Request, re ResponseEmitter, env Environment) error {
+		return EmitOnce(re, Describe(req.Root))
+	},
+	Type: &CommandDescription{},
+	Encoders: EncoderMap{
+		Text: MakeTypedEncoder(func(req *Request, w io.Writer, d *CommandDescription) error {
+			if asJSON, _ := req.Options[JSONOpt].(bool); asJSON {
+				enc := json.NewEncoder(w)
+				enc.SetIndent("", "  ")
+				return enc.Encode(d)
+			}
+
+			var print func(d *CommandDescription) error
+			print = func(d *CommandDescription) error {
+				if d.Callable && len(d.Path) > 0 {
+					if _, err := fmt.Fprintln(w, strings.Join(d.Path, " ")); err != nil {
+						return err
+					}
+				}
+				for _, sub := range d.Subcommands {
+					if err := print(sub); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+			return print(d)
+		}),
+	},
+}
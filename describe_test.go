@@ -0,0 +1,137 @@
+package cmds
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestDescribe(t *testing.T) {
+	root := &Command{
+		Options: []Option{
+			OptionEncodingType,
+		},
+		Subcommands: map[string]*Command{
+			"b": {
+				Helptext: HelpText{
+					Tagline:  "b command",
+					Examples: []Example{{CmdLine: "app b name", Description: "Run b."}},
+				},
+				Run:  noop,
+				Type: &Foo{},
+				Arguments: []Argument{
+					StringArg("name", true, false, "a name"),
+					FileArg("file", false, true, "files").EnableRecursive(),
+				},
+				Options: []Option{
+					IntOption("count", "c", "how many").WithDefault(3),
+					StringsOption("tag", "tags"),
+				},
+			},
+			"a": {
+				Subcommands: map[string]*Command{
+					"sub": {Run: noop},
+				},
+			},
+		},
+	}
+
+	d := Describe(root)
+	if len(d.Options) != 1 || d.Options[0].Type != "string" || d.Options[0].Default != "text" {
+		t.Errorf("unexpected root options: %+v", d.Options)
+	}
+	if len(d.Subcommands) != 2 || d.Subcommands[0].Name != "a" || d.Subcommands[1].Name != "b" {
+		t.Fatalf("expected sorted subcommands a, b, got %+v", d.Subcommands)
+	}
+
+	sub := d.Subcommands[0].Subcommands[0]
+	if !reflect.DeepEqual(sub.Path, []string{"a", "sub"}) || !sub.Callable {
+		t.Errorf("unexpected description of a/sub: %+v", sub)
+	}
+
+	b := d.Subcommands[1]
+	if b.Type != "cmds.Foo" || b.Tagline != "b command" {
+		t.Errorf("unexpected description of b: %+v", b)
+	}
+	if len(b.Examples) != 1 || b.Examples[0].CmdLine != "app b name" {
+		t.Errorf("unexpected examples of b: %+v", b.Examples)
+	}
+	expArgs := []ArgumentDescription{
+		{Name: "name", Type: "string", Required: true, Description: "a name"},
+		{Name: "file", Type: "file", Variadic: true, Recursive: true, Description: "files"},
+	}
+	if !reflect.DeepEqual(b.Arguments, expArgs) {
+		t.Errorf("expected arguments %+v, got %+v", expArgs, b.Arguments)
+	}
+	expOpts := []OptionDescription{
+		{Names: []string{"count", "c"}, Type: "int", Default: 3, Description: "how many. Default: 3."},
+		{Names: []string{"tag"}, Type: "strings", Description: "tags."},
+	}
+	if !reflect.DeepEqual(b.Options, expOpts) {
+		t.Errorf("expected options %+v, got %+v", expOpts, b.Options)
+	}
+
+	data, err := CommandsJSON(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded CommandDescription
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Subcommands) != 2 {
+		t.Errorf("expected 2 subcommands after round trip, got %d", len(decoded.Subcommands))
+	}
+}
+
+func TestDescribeGroupedOption(t *testing.T) {
+	root := &Command{
+		Options: []Option{
+			GroupedOption("Filter options", StringsOption("tag", "tags")),
+			BoolOption("quiet", "q", "less output"),
+		},
+	}
+
+	expOpts := []OptionDescription{
+		{Names: []string{"tag"}, Type: "strings", Description: "tags.", Group: "Filter options"},
+		{Names: []string{"quiet", "q"}, Type: "bool", Description: "less output."},
+	}
+	if d := Describe(root); !reflect.DeepEqual(d.Options, expOpts) {
+		t.Errorf("expected options %+v, got %+v", expOpts, d.Options)
+	}
+}
+
+func TestCommandsCmd(t *testing.T) {
+	root := &Command{
+		Options: []Option{OptionEncodingType},
+		Subcommands: map[string]*Command{
+			"commands": CommandsCmd,
+			"foo": {
+				Run: noop,
+				Subcommands: map[string]*Command{
+					"bar": {Run: noop},
+				},
+			},
+		},
+	}
+
+	req, err := NewRequest(context.Background(), []string{"commands"}, OptMap{EncLong: Text}, nil, nil, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	re, err := NewWriterResponseEmitter(wc{&buf, nopCloser{}}, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewExecutor(root).Execute(req, re, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if exp := "commands\nfoo\nfoo bar\n"; buf.String() != exp {
+		t.Errorf("expected %q, got %q", exp, buf.String())
+	}
+}
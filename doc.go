@@ -83,6 +83,18 @@
 	can also return other errors, e.g. if there are errors on the
 	network.
 
+	Transports
+
+	Other connections between clients and servers, e.g.
+	websockets or named pipes, implement the Transport interface:
+	the server side accepts requests with their emitters, and the
+	client side sends requests and returns their responses. Serve
+	and NewTransportExecutor run commands over any transport, so
+	transports only have to carry requests and responses.
+	NewLocalTransport connects goroutines of the same process.
+	The HTTP client is a ClientTransport, while the HTTP handler,
+	which net/http calls for every request, runs commands itself.
+
 	Examples
 
 	To get a better idea of what's going on, take a look at the
@@ -0,0 +1,33 @@
+package cmds
+
+// A dry run goes through everything a request normally goes through:
+// argument checks, PreRun, the emitter decorators, PostRun and, over HTTP,
+// the handler's checks. Only Run is replaced, by the command's Simulate
+// function, so tooling can see whether and how a request would be executed
+// before executing it.
+
+// IsDryRun reports whether req asks for a dry run. Requests with values of
+// the option that aren't booleans are dry runs too, so they never execute;
+// Call fails them.
+func IsDryRun(req *Request) bool {
+	dry, err := boolOption(req, DryRunOpt)
+	return dry || err != nil
+}
+
+// runFunc returns the function executing req on cmd.
+func runFunc(cmd *Command, req *Request) Function {
+	if _, err := boolOption(req, DryRunOpt); err != nil {
+		return func(*Request, ResponseEmitter, Environment) error {
+			return err
+		}
+	}
+	if !IsDryRun(req) {
+		return singleOutput(cmd, guardRun(cmd, cmd.Run))
+	}
+	if cmd.Simulate != nil {
+		return cmd.Simulate
+	}
+	return func(*Request, ResponseEmitter, Environment) error {
+		return nil
+	}
+}
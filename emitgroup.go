@@ -0,0 +1,127 @@
+package cmds
+
+import (
+	"sync"
+)
+
+// EmitGroup lets the goroutines of a command emit to the same
+// ResponseEmitter, which are not safe for concurrent use. Emit and the other
+// methods of the group are serialized: every value is emitted whole,
+// readers included, and the values each goroutine emits arrive in the order
+// it emits them, while those of different goroutines are interleaved.
+//
+// Goroutines are started with Go, and Wait returns the first error they
+// return, for Run to return it: the emitter is closed by the executor, once.
+// After a goroutine fails, or the group is closed, Emit returns an error, so
+// the others stop early.
+//
+//	g := cmds.NewEmitGroup(re)
+//	for _, p := range paths {
+//		p := p
+//		g.Go(func() error { return g.Emit(stat(p)) })
+//	}
+//	return g.Wait()
+type EmitGroup struct {
+	re ResponseEmitter
+	wg sync.WaitGroup
+
+	l      sync.Mutex
+	err    error
+	closed bool
+}
+
+// NewEmitGroup returns a group emitting to re.
+func NewEmitGroup(re ResponseEmitter) *EmitGroup {
+	return &EmitGroup{re: re}
+}
+
+// Go runs fn in a new goroutine of the group.
+func (g *EmitGroup) Go(fn func() error) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		if err := fn(); err != nil {
+			g.fail(err)
+		}
+	}()
+}
+
+// Wait waits for the goroutines started with Go, and returns the first
+// error one of them returned.
+func (g *EmitGroup) Wait() error {
+	g.wg.Wait()
+	g.l.Lock()
+	defer g.l.Unlock()
+	return g.err
+}
+
+func (g *EmitGroup) fail(err error) {
+	g.l.Lock()
+	defer g.l.Unlock()
+	if g.err == nil {
+		g.err = err
+	}
+}
+
+// Emit emits v to the emitter of the group, waiting for the values other
+// goroutines are emitting.
+func (g *EmitGroup) Emit(v interface{}) error {
+	g.l.Lock()
+	defer g.l.Unlock()
+
+	if g.closed {
+		return ErrClosedEmitter
+	}
+	if g.err != nil {
+		return g.err
+	}
+	if _, ok := v.(Single); ok {
+		// the emitter closes itself after the value
+		g.closed = true
+	}
+	return g.re.Emit(v)
+}
+
+func (g *EmitGroup) SetLength(length uint64) {
+	g.l.Lock()
+	defer g.l.Unlock()
+	g.re.SetLength(length)
+}
+
+// Close closes the emitter of the group. Only the first call to Close or
+// CloseWithError closes it, the others return ErrClosingClosedEmitter.
+func (g *EmitGroup) Close() error {
+	return g.CloseWithError(nil)
+}
+
+func (g *EmitGroup) CloseWithError(err error) error {
+	g.l.Lock()
+	defer g.l.Unlock()
+
+	if g.closed {
+		return ErrClosingClosedEmitter
+	}
+	g.closed = true
+	if err == nil {
+		return g.re.Close()
+	}
+	return g.re.CloseWithError(err)
+}
+
+func (g *EmitGroup) Warn(msg string) {
+	g.l.Lock()
+	defer g.l.Unlock()
+	Warn(g.re, msg)
+}
+
+func (g *EmitGroup) SetPage(p PageInfo) {
+	g.l.Lock()
+	defer g.l.Unlock()
+	SetPage(g.re, p)
+}
+
+func (g *EmitGroup) SetStatus(code int) {
+	g.l.Lock()
+	defer g.l.Unlock()
+	SetStatus(g.re, code)
+}
@@ -0,0 +1,90 @@
+package cmds
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestEmitGroup(t *testing.T) {
+	req, err := NewRequest(context.Background(), nil, nil, nil, nil, &Command{Type: ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	re, err := NewWriterResponseEmitter(writecloser{&buf, nopCloser{}}, req, StreamWithEncoding(TextNewline))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const workers, values = 8, 100
+	g := NewEmitGroup(re)
+	for i := 0; i < workers; i++ {
+		i := i
+		g.Go(func() error {
+			for j := 0; j < values; j++ {
+				// readers are emitted whole too
+				var v interface{} = fmt.Sprintf("%d %d", i, j)
+				if j%2 == 1 {
+					v = strings.NewReader(fmt.Sprintf("%d %d\n", i, j))
+				}
+				if err := g.Emit(v); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Close(); err != ErrClosingClosedEmitter {
+		t.Errorf("expected closing twice to fail, got %v", err)
+	}
+
+	next := make([]int, workers)
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	for _, line := range lines {
+		var i, j int
+		if _, err := fmt.Sscanf(line, "%d %d", &i, &j); err != nil {
+			t.Fatalf("invalid line %q", line)
+		}
+		if j != next[i] {
+			t.Fatalf("goroutine %d: expected value %d, got %d", i, next[i], j)
+		}
+		next[i]++
+	}
+	if len(lines) != workers*values {
+		t.Errorf("expected %d lines, got %d", workers*values, len(lines))
+	}
+
+	// the first error stops the other goroutines
+	re, err = NewWriterResponseEmitter(writecloser{&bytes.Buffer{}, nopCloser{}}, req, StreamWithEncoding(TextNewline))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g = NewEmitGroup(re)
+	fail := errors.New("failed")
+	failed := make(chan struct{})
+	g.Go(func() error {
+		defer close(failed)
+		return fail
+	})
+	g.Go(func() error {
+		<-failed
+		for {
+			if err := g.Emit("x"); err != nil {
+				return err
+			}
+		}
+	})
+	if err := g.Wait(); err != fail {
+		t.Errorf("expected %v, got %v", fail, err)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"reflect"
+	"strings"
 )
 
 // Encoder encodes values onto e.g. an io.Writer. Examples are json.Encoder and xml.Encoder.
@@ -21,6 +22,12 @@ type Decoder interface {
 // EncodingType defines a supported encoding
 type EncodingType string
 
+// IsJSON reports whether t is a stream of JSON values: JSON, NDJSON or
+// MergePatch.
+func (t EncodingType) IsJSON() bool {
+	return t == JSON || t == NDJSON || t == MergePatch
+}
+
 // PostRunType defines which PostRunFunc should be used
 type PostRunType string
 
@@ -30,23 +37,49 @@ const (
 	Undefined = ""
 
 	// EncodingTypes
-	JSON        = "json"
+	JSON = "json"
+	// NDJSON is newline-delimited JSON: a stream of compact JSON values,
+	// one per line, that clients decode as the lines arrive. Unlike JSON,
+	// values are encoded by the global encoder rather than the JSON
+	// encoder of the command, which may not write one line per value.
+	// Warnings, progress reports and trailers are sent as in JSON streams.
+	NDJSON = "ndjson"
+	// MergePatch is a stream of JSON values for commands emitting large,
+	// mostly unchanged values, e.g. ones watching a state: values are sent
+	// as the JSON Merge Patch (RFC 7386) from the previous value when it is
+	// smaller, and in full every MergePatchSnapshotInterval values.
+	MergePatch  = "merge-patch"
 	XML         = "xml"
+	CBOR        = "cbor"
 	Protobuf    = "protobuf"
 	Text        = "text"
 	TextNewline = "textnl"
+	// Raw writes emitted byte slices, strings and readers verbatim, e.g.
+	// the contents of files, sent as application/octet-stream over HTTP.
+	// Other values can't be encoded in it.
+	Raw = "raw"
 
 	// PostRunTypes
 	CLI = "cli"
 )
 
-var Decoders = map[EncodingType]func(w io.Reader) Decoder{
+// DecoderMap maps encodings to the functions returning decoders of streams
+// in them.
+type DecoderMap map[EncodingType]func(r io.Reader) Decoder
+
+var Decoders = DecoderMap{
 	XML: func(r io.Reader) Decoder {
 		return xml.NewDecoder(r)
 	},
 	JSON: func(r io.Reader) Decoder {
 		return json.NewDecoder(r)
 	},
+	NDJSON: func(r io.Reader) Decoder {
+		return json.NewDecoder(r)
+	},
+	MergePatch: newMergePatchDecoder,
+	CBOR:       newCBORDecoder,
+	Protobuf:   newProtobufDecoder,
 }
 
 type EncoderFunc func(req *Request) func(w io.Writer) Encoder
@@ -57,14 +90,114 @@ var Encoders = EncoderMap{
 		return func(w io.Writer) Encoder { return xml.NewEncoder(w) }
 	},
 	JSON: func(req *Request) func(io.Writer) Encoder {
-		return func(w io.Writer) Encoder { return json.NewEncoder(w) }
+		return func(w io.Writer) Encoder {
+			if canonicalJSON(req) {
+				return canonicalEncoder{w: w, indent: prettyJSON(req)}
+			}
+			enc := json.NewEncoder(w)
+			if prettyJSON(req) {
+				enc.SetIndent("", "  ")
+			}
+			return enc
+		}
+	},
+	NDJSON: func(req *Request) func(io.Writer) Encoder {
+		return func(w io.Writer) Encoder {
+			if canonicalJSON(req) {
+				return canonicalEncoder{w: w}
+			}
+			return json.NewEncoder(w)
+		}
 	},
+	MergePatch: newMergePatchEncoder,
+	CBOR:       newCBOREncoder,
+	Protobuf:   newProtobufEncoder,
 	Text: func(req *Request) func(io.Writer) Encoder {
 		return func(w io.Writer) Encoder { return TextEncoder{w: w} }
 	},
 	TextNewline: func(req *Request) func(io.Writer) Encoder {
 		return func(w io.Writer) Encoder { return TextEncoder{w: w, suffix: "\n"} }
 	},
+	Raw: func(req *Request) func(io.Writer) Encoder {
+		return func(w io.Writer) Encoder { return rawEncoder{w: w} }
+	},
+}
+
+// mimeTypes are the MIME types HTTP responses in the encodings are sent with,
+// including those of the encodings added with RegisterEncoding. No two
+// encodings share one.
+var mimeTypes = map[EncodingType]string{
+	Protobuf:   "application/protobuf",
+	JSON:       "application/json",
+	NDJSON:     "application/x-ndjson",
+	MergePatch: "application/x-merge-patch-stream+json",
+	XML:        "application/xml",
+	CBOR:       "application/cbor",
+	Text:       "text/plain",
+	Raw:        "application/octet-stream",
+}
+
+// RegisterEncoding adds the encoding name to Encoders, so applications can
+// add wire formats of their own: the CLI and HTTP layers then accept it like
+// the built-in encodings, and HTTP responses in it are sent with the MIME
+// type mime. Clients decode responses in it if a decoder is registered with
+// RegisterDecoder.
+//
+// Like Encoders, the registry isn't guarded against concurrent use, so
+// encodings are registered before commands run, e.g. from init functions.
+// RegisterEncoding panics if the encoding exists already, or if another
+// encoding has the MIME type.
+func RegisterEncoding(name EncodingType, encoder EncoderFunc, mime string) {
+	if name == Undefined || encoder == nil {
+		panic("cmds: RegisterEncoding needs a name and an encoder")
+	}
+	if _, ok := Encoders[name]; ok {
+		panic(fmt.Sprintf("cmds: encoding %q registered twice", name))
+	}
+	if enc, ok := EncodingOfMIMEType(mime); ok && mime != "" {
+		panic(fmt.Sprintf("cmds: MIME type %q of encoding %q is that of %q", mime, name, enc))
+	}
+	Encoders[name] = encoder
+	if mime != "" {
+		mimeTypes[name] = mime
+	}
+}
+
+// RegisterDecoder adds the decoder of the encoding name to Decoders, e.g. of
+// one added with RegisterEncoding. It panics if the encoding has one already.
+func RegisterDecoder(name EncodingType, decoder func(io.Reader) Decoder) {
+	if name == Undefined || decoder == nil {
+		panic("cmds: RegisterDecoder needs a name and a decoder")
+	}
+	if _, ok := Decoders[name]; ok {
+		panic(fmt.Sprintf("cmds: decoder of encoding %q registered twice", name))
+	}
+	Decoders[name] = decoder
+}
+
+// MIMEType returns the MIME type of the encoding enc, built in or added with
+// RegisterEncoding.
+func MIMEType(enc EncodingType) (string, bool) {
+	mime, ok := mimeTypes[enc]
+	return mime, ok
+}
+
+// EncodingOfMIMEType returns the encoding, built in or added with
+// RegisterEncoding, whose MIME type is mime.
+func EncodingOfMIMEType(mime string) (EncodingType, bool) {
+	for enc, m := range mimeTypes {
+		if m == mime {
+			return enc, true
+		}
+	}
+	return Undefined, false
+}
+
+// prettyJSON reports whether req asks for indented JSON, see
+// OptionEncodingPretty.
+func prettyJSON(req *Request) bool {
+	pretty, _ := boolOption(req, EncPretty)
+	return pretty
 }
 
 func MakeEncoder(f func(*Request, io.Writer, interface{}) error) func(*Request) func(io.Writer) Encoder {
@@ -90,36 +223,10 @@ func MakeTypedEncoder(f interface{}) func(*Request) func(io.Writer) Encoder {
 		panic("MakeTypedEncoder must receive a function matching func(*Request, io.Writer, ...)")
 	}
 
-	var (
-		valType, valTypeAlt reflect.Type
-	)
-
-	valType = t.In(2)
-	valTypeIsPtr := valType.Kind() == reflect.Ptr
-	if valTypeIsPtr {
-		valTypeAlt = valType.Elem()
-	} else {
-		valTypeAlt = reflect.PtrTo(valType)
-	}
-
+	valType := t.In(2)
 	return MakeEncoder(func(req *Request, w io.Writer, i interface{}) error {
-		iType := reflect.TypeOf(i)
-		iValue := reflect.ValueOf(i)
-		switch iType {
-		case valType:
-		case valTypeAlt:
-			if valTypeIsPtr {
-				if iValue.CanAddr() {
-					iValue = iValue.Addr()
-				} else {
-					oldValue := iValue
-					iValue = reflect.New(iType)
-					iValue.Elem().Set(oldValue)
-				}
-			} else {
-				iValue = iValue.Elem()
-			}
-		default:
+		iValue, ok := typedValue(valType, i)
+		if !ok {
 			return fmt.Errorf("unexpected type %T, expected %v", i, valType)
 		}
 
@@ -137,6 +244,57 @@ func MakeTypedEncoder(f interface{}) func(*Request) func(io.Writer) Encoder {
 	})
 }
 
+// typedValue returns i as a value of valType, converting values to pointers
+// to them and back. It returns false if i is of another type.
+func typedValue(valType reflect.Type, i interface{}) (reflect.Value, bool) {
+	iType := reflect.TypeOf(i)
+	iValue := reflect.ValueOf(i)
+	switch {
+	case iType == valType:
+		return iValue, true
+	case valType.Kind() == reflect.Ptr && iType == valType.Elem():
+		if iValue.CanAddr() {
+			return iValue.Addr(), true
+		}
+		ptr := reflect.New(iType)
+		ptr.Elem().Set(iValue)
+		return ptr, true
+	case iType != nil && iType.Kind() == reflect.Ptr && iType.Elem() == valType:
+		return iValue.Elem(), true
+	}
+	return reflect.Value{}, false
+}
+
+// MakeStatefulEncoder returns an encoder function creating an encoder with
+// newEnc for every response, so it can keep state across the values of the
+// response, e.g. to print a header once or to add up totals. Encoders that
+// implement FinishingEncoder are also told when the response ends.
+func MakeStatefulEncoder(newEnc func(req *Request, w io.Writer) Encoder) func(*Request) func(io.Writer) Encoder {
+	return func(req *Request) func(io.Writer) Encoder {
+		return func(w io.Writer) Encoder { return newEnc(req, w) }
+	}
+}
+
+// FinishingEncoder is an Encoder that writes something after the last value
+// of a response, e.g. a footer with totals.
+type FinishingEncoder interface {
+	Encoder
+
+	// Finish is called once the response is closed, with the error it is
+	// closed with, if any. It isn't called if the error replaces the
+	// response body, as in HTTP responses failing before their first value.
+	Finish(err error) error
+}
+
+// FinishEncoder calls Finish on enc if it is a FinishingEncoder. Response
+// emitters call it when they are closed.
+func FinishEncoder(enc Encoder, err error) error {
+	if f, ok := enc.(FinishingEncoder); ok {
+		return f.Finish(err)
+	}
+	return nil
+}
+
 type genericEncoder struct {
 	f   func(*Request, io.Writer, interface{}) error
 	w   io.Writer
@@ -157,22 +315,128 @@ func (e TextEncoder) Encode(v interface{}) error {
 	return err
 }
 
+// rawEncoder writes values of the Raw encoding as they are.
+type rawEncoder struct {
+	w io.Writer
+}
+
+func (e rawEncoder) Encode(v interface{}) error {
+	var err error
+	switch v := v.(type) {
+	case []byte:
+		_, err = e.w.Write(v)
+	case string:
+		_, err = io.WriteString(e.w, v)
+	case io.Reader:
+		_, err = io.Copy(e.w, v)
+	default:
+		return Errorf(ErrClient, "values of type %T can't be encoded in the raw encoding", v)
+	}
+	return err
+}
+
 // GetEncoder takes a request and returns returns the encoding type and the encoder.
 func GetEncoder(req *Request, w io.Writer, def EncodingType) (encType EncodingType, enc Encoder, err error) {
-	encType = GetEncoding(req, def)
+	encType, fn, err := resolveEncoder(req.Command, GetEncoding(req, def))
+	if err != nil {
+		return encType, nil, err
+	}
+	var table bool
+	if encType == Text || encType == TextNewline {
+		cols, err := tableColumns(req)
+		if err != nil {
+			return encType, nil, err
+		}
+		if cols != nil {
+			fn = func(req *Request) func(io.Writer) Encoder {
+				return func(w io.Writer) Encoder { return newTableEncoder(req, w, cols) }
+			}
+			table = true
+		}
+	}
+	oe := newEncoder(req, w, encType, fn).(*outputEncoder)
+	oe.defOnly = table
+	if oe.selErr != nil {
+		return encType, nil, oe.selErr
+	}
+	if oe.arrErr != nil {
+		return encType, nil, oe.arrErr
+	}
+	return encType, oe, nil
+}
 
-	var (
-		fn EncoderFunc
-		ok bool
-	)
-	if req.Command != nil {
-		fn, ok = req.Command.Encoders[encType]
+// resolveEncoder returns the encoding the output of cmd is encoded in when
+// encType is requested, which differs if cmd falls back to another one, see
+// Command.EncodingFallbacks, and its encoder.
+func resolveEncoder(cmd *Command, encType EncodingType) (EncodingType, EncoderFunc, error) {
+	if cmd == nil || len(cmd.EncodingFallbacks) == 0 {
+		fn, ok := encoderFunc(cmd, encType)
+		if !ok {
+			return encType, nil, Errorf(ErrClient, "invalid encoding: %s", encType)
+		}
+		return encType, fn, nil
 	}
-	if !ok {
-		fn, ok = Encoders[encType]
+
+	if fn, ok := cmd.Encoders[encType]; ok {
+		return encType, fn, nil
 	}
+	names := make([]string, len(cmd.EncodingFallbacks))
+	for i, fallback := range cmd.EncodingFallbacks {
+		if fn, ok := encoderFunc(cmd, fallback); ok {
+			return fallback, fn, nil
+		}
+		names[i] = string(fallback)
+	}
+	return encType, nil, Errorf(ErrClient, "no encoder for encoding %s, nor for its fallbacks %s", encType, strings.Join(names, ", "))
+}
+
+// encoderFunc returns the encoder of cmd for encType, or the global one. The
+// global protobuf encoder only encodes commands with a protobuf Type.
+func encoderFunc(cmd *Command, encType EncodingType) (EncoderFunc, bool) {
+	if cmd != nil {
+		if fn, ok := cmd.Encoders[encType]; ok {
+			return fn, true
+		}
+	}
+	if encType == Protobuf && !encodesProtobuf(cmd) {
+		return nil, false
+	}
+	fn, ok := Encoders[encType]
+	return fn, ok
+}
+
+// Decoder returns the function returning decoders of the values of the
+// command in enc: the one in Decoders, or else the global one.
+func (c *Command) Decoder(enc EncodingType) (func(io.Reader) Decoder, bool) {
+	if c != nil {
+		if fn, ok := c.Decoders[enc]; ok {
+			return func(r io.Reader) Decoder { return valueDecoder{fn(r)} }, true
+		}
+	}
+	fn, ok := Decoders[enc]
+	return fn, ok
+}
+
+// valueDecoder decodes values with a decoder of a command, passing it new
+// values of the Type of the command rather than the MaybeError responses
+// decode, like the CBOR decoder does.
+type valueDecoder struct {
+	dec Decoder
+}
+
+func (d valueDecoder) Decode(v interface{}) error {
+	m, ok := v.(*MaybeError)
 	if !ok {
-		return encType, nil, Errorf(ErrClient, "invalid encoding: %s", encType)
+		return d.dec.Decode(v)
+	}
+	if m.Value == nil {
+		return d.dec.Decode(&m.Value)
+	}
+
+	t := reflect.TypeOf(m.Value)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
 	}
-	return encType, fn(req)(w), nil
+	m.Value = reflect.New(t).Interface()
+	return d.dec.Decode(m.Value)
 }
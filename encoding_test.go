@@ -1,9 +1,14 @@
 package cmds
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"io"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -105,3 +110,428 @@ func TestMakeTypedEncoderArrays(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+// totalEncoder prints a header before the first value and the total of the
+// values at the end.
+type totalEncoder struct {
+	w     io.Writer
+	count int
+	total int
+}
+
+func (e *totalEncoder) Encode(v interface{}) error {
+	if e.count == 0 {
+		fmt.Fprintln(e.w, "SIZE")
+	}
+	e.count++
+	e.total += v.(int)
+	_, err := fmt.Fprintln(e.w, v)
+	return err
+}
+
+func (e *totalEncoder) Finish(err error) error {
+	if err != nil {
+		_, err = fmt.Fprintf(e.w, "incomplete: %d so far\n", e.total)
+		return err
+	}
+	_, err = fmt.Fprintf(e.w, "total %d in %d values\n", e.total, e.count)
+	return err
+}
+
+func TestStatefulEncoder(t *testing.T) {
+	type testcase struct {
+		emit []int
+		err  error
+		out  string
+	}
+
+	tcs := []testcase{
+		{emit: []int{1, 2, 3}, out: "SIZE\n1\n2\n3\ntotal 6 in 3 values\n"},
+		{out: "total 0 in 0 values\n"},
+		{emit: []int{4}, err: fmt.Errorf("failed"), out: "SIZE\n4\nincomplete: 4 so far\n"},
+	}
+
+	for i, tc := range tcs {
+		cmd := &Command{
+			Run: func(req *Request, re ResponseEmitter, env Environment) error {
+				for _, v := range tc.emit {
+					if err := re.Emit(v); err != nil {
+						return err
+					}
+				}
+				return tc.err
+			},
+			Encoders: EncoderMap{
+				Text: MakeStatefulEncoder(func(req *Request, w io.Writer) Encoder {
+					return &totalEncoder{w: w}
+				}),
+			},
+			Type: 0,
+		}
+
+		req, err := NewRequest(context.Background(), nil, nil, nil, nil, cmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		re, err := NewWriterResponseEmitter(writecloser{&buf, nopCloser{}}, req, StreamWithEncoding(Text))
+		if err != nil {
+			t.Fatal(err)
+		}
+		NewExecutor(cmd).Execute(req, re, nil)
+
+		if buf.String() != tc.out {
+			t.Errorf("%d: expected output %q, got %q", i, tc.out, buf.String())
+		}
+	}
+}
+
+func TestEncodingFallbacks(t *testing.T) {
+	type result struct{ Hash string }
+	cmd := &Command{
+		Run: func(req *Request, re ResponseEmitter, env Environment) error {
+			return re.Emit(&result{Hash: "Qm"})
+		},
+		Type:              result{},
+		EncodingFallbacks: []EncodingType{Protobuf, JSON, Text},
+	}
+
+	// text isn't encoded by the command, so the output falls back to JSON
+	req, err := NewRequest(context.Background(), nil, map[string]interface{}{EncLong: Text}, nil, nil, cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	encType, _, err := GetEncoder(req, &buf, Undefined)
+	if err != nil || encType != JSON {
+		t.Fatalf("expected the JSON fallback, got %q (%v)", encType, err)
+	}
+	re, err := NewWriterResponseEmitter(writecloser{&buf, nopCloser{}}, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewExecutor(cmd).Execute(req, re, nil); err != nil {
+		t.Fatal(err)
+	}
+	if exp := `{"Hash":"Qm"}` + "\n"; buf.String() != exp {
+		t.Errorf("expected %q, got %q", exp, buf.String())
+	}
+	res, err := NewReaderResponse(&buf, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := res.Next(); err != nil || v.(*result).Hash != "Qm" {
+		t.Errorf("expected the result back, got %v (%v)", v, err)
+	}
+
+	// encoders of the command for the requested encoding come first
+	cmd.Encoders = EncoderMap{
+		Text: MakeTypedEncoder(func(req *Request, w io.Writer, r *result) error {
+			_, err := io.WriteString(w, r.Hash)
+			return err
+		}),
+	}
+	if encType, _, err := GetEncoder(req, &buf, Undefined); err != nil || encType != Text {
+		t.Errorf("expected the text encoder of the command, got %q (%v)", encType, err)
+	}
+
+	// without a match, requests are rejected
+	cmd.EncodingFallbacks = []EncodingType{Protobuf}
+	err = cmd.CheckEncoding(XML)
+	if e, ok := err.(Error); !ok || e.Code != ErrClient {
+		t.Fatalf("expected a client error, got %v", err)
+	}
+	if exp := "no encoder for encoding xml, nor for its fallbacks protobuf"; err.Error() != exp {
+		t.Errorf("expected %q, got %q", exp, err)
+	}
+}
+
+func TestNDJSON(t *testing.T) {
+	type entry struct {
+		Name string
+	}
+	cmd := &Command{
+		Run: func(req *Request, re ResponseEmitter, env Environment) error {
+			if err := re.Emit(&entry{Name: "a"}); err != nil {
+				return err
+			}
+			return re.Emit(&entry{Name: "b"})
+		},
+		Type: entry{},
+		// the JSON encoder of the command isn't used for NDJSON
+		Encoders: EncoderMap{
+			JSON: func(req *Request) func(io.Writer) Encoder {
+				return func(w io.Writer) Encoder {
+					enc := json.NewEncoder(w)
+					enc.SetIndent("", "  ")
+					return enc
+				}
+			},
+		},
+	}
+
+	req, err := NewRequest(context.Background(), nil, nil, nil, nil, cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	re, err := NewWriterResponseEmitter(writecloser{&buf, nopCloser{}}, req, StreamWithEncoding(NDJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewExecutor(cmd).Execute(req, re, nil); err != nil {
+		t.Fatal(err)
+	}
+	if exp := "{\"Name\":\"a\"}\n{\"Name\":\"b\"}\n"; buf.String() != exp {
+		t.Errorf("expected %q, got %q", exp, buf.String())
+	}
+
+	res, err := NewReaderResponse(&buf, req, StreamWithEncoding(NDJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b"} {
+		if v, err := res.Next(); err != nil || v.(*entry).Name != name {
+			t.Fatalf("expected entry %s, got %v (%v)", name, v, err)
+		}
+	}
+	if _, err := res.Next(); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+}
+
+func TestPrettyJSON(t *testing.T) {
+	type entry struct {
+		Name string
+	}
+	cmd := &Command{
+		Options: []Option{OptionEncodingPretty},
+		Type:    entry{},
+	}
+
+	for _, tc := range []struct {
+		opts    OptMap
+		encType EncodingType
+		out     string
+	}{
+		{encType: JSON, out: "{\"Name\":\"a\"}\n"},
+		{opts: OptMap{EncPretty: true}, encType: JSON, out: "{\n  \"Name\": \"a\"\n}\n"},
+		// as sent by HTTP clients to commands that don't declare it
+		{opts: OptMap{EncPretty: "true"}, encType: JSON, out: "{\n  \"Name\": \"a\"\n}\n"},
+		// NDJSON stays one value per line
+		{opts: OptMap{EncPretty: true}, encType: NDJSON, out: "{\"Name\":\"a\"}\n"},
+	} {
+		req, err := NewRequest(context.Background(), nil, nil, nil, nil, cmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Options = tc.opts
+
+		var buf bytes.Buffer
+		re, err := NewWriterResponseEmitter(writecloser{&buf, nopCloser{}}, req, StreamWithEncoding(tc.encType))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := re.Emit(&entry{Name: "a"}); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != tc.out {
+			t.Errorf("%v, %s: expected %q, got %q", tc.opts, tc.encType, tc.out, buf.String())
+		}
+	}
+}
+
+func TestRawEncoding(t *testing.T) {
+	req, err := NewRequest(context.Background(), nil, nil, nil, nil, &Command{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	re, err := NewWriterResponseEmitter(writecloser{&buf, nopCloser{}}, req, StreamWithEncoding(Raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []interface{}{[]byte("\x00\x01"), "two", strings.NewReader("three\n")} {
+		if err := re.Emit(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if exp := "\x00\x01twothree\n"; buf.String() != exp {
+		t.Errorf("expected %q, got %q", exp, buf.String())
+	}
+
+	// other values aren't wrapped in anything
+	err = re.Emit(struct{ Name string }{"a"})
+	if e, ok := err.(Error); !ok || e.Code != ErrClient {
+		t.Errorf("expected a client error, got %v", err)
+	}
+}
+
+// lineCodec is a wire format of test encodings: one string per line.
+type lineCodec struct {
+	w io.Writer
+	r *bufio.Reader
+}
+
+func (c lineCodec) Encode(v interface{}) error {
+	_, err := fmt.Fprintf(c.w, "%v\n", v)
+	return err
+}
+
+func (c lineCodec) Decode(v interface{}) error {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if m, ok := v.(*MaybeError); ok {
+		m.Value = strings.TrimSuffix(line, "\n")
+		return nil
+	}
+	return fmt.Errorf("cannot decode into %T", v)
+}
+
+func registerLineEncoding(t *testing.T, name EncodingType) {
+	RegisterEncoding(name, func(req *Request) func(io.Writer) Encoder {
+		return func(w io.Writer) Encoder { return lineCodec{w: w} }
+	}, "application/x-lines")
+	RegisterDecoder(name, func(r io.Reader) Decoder { return lineCodec{r: bufio.NewReader(r)} })
+	t.Cleanup(func() {
+		delete(Encoders, name)
+		delete(Decoders, name)
+		delete(mimeTypes, name)
+	})
+}
+
+func TestRegisterEncoding(t *testing.T) {
+	const lines EncodingType = "lines"
+	registerLineEncoding(t, lines)
+
+	cmd := &Command{Type: ""}
+	if err := cmd.CheckEncoding(lines); err != nil {
+		t.Error(err)
+	}
+	found := false
+	for _, enc := range cmd.SupportedEncodings() {
+		found = found || enc == lines
+	}
+	if !found {
+		t.Errorf("%s isn't among the supported encodings %v", lines, cmd.SupportedEncodings())
+	}
+	if mime, ok := MIMEType(lines); !ok || mime != "application/x-lines" {
+		t.Errorf("unexpected MIME type %q", mime)
+	}
+	if enc, ok := EncodingOfMIMEType("application/x-lines"); !ok || enc != lines {
+		t.Errorf("unexpected encoding %q", enc)
+	}
+
+	req, err := NewRequest(context.Background(), nil, OptMap{EncLong: string(lines)}, nil, nil, cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	re, err := NewWriterResponseEmitter(writecloser{&buf, nopCloser{}}, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []string{"a", "b"} {
+		if err := re.Emit(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	re.Close()
+	if buf.String() != "a\nb\n" {
+		t.Errorf("unexpected output %q", buf.String())
+	}
+
+	res, err := NewReaderResponse(&buf, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, exp := range []string{"a", "b"} {
+		if v, err := res.Next(); err != nil || v != exp {
+			t.Errorf("expected %q, got %v (%v)", exp, v, err)
+		}
+	}
+
+	// built-in encodings have their MIME types too
+	if enc, ok := EncodingOfMIMEType("application/json"); !ok || enc != JSON {
+		t.Errorf("unexpected encoding %q of application/json", enc)
+	}
+
+	for name, register := range map[string]func(){
+		"encoding":  func() { RegisterEncoding(JSON, Encoders[JSON], "") },
+		"decoder":   func() { RegisterDecoder(lines, Decoders[lines]) },
+		"MIME type": func() { RegisterEncoding("json2", Encoders[JSON], "application/json") },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("registering the %s twice didn't panic", name)
+				}
+			}()
+			register()
+		}()
+	}
+}
+
+// kvDecoder decodes lines of a name and a size into kvEntry values.
+type kvDecoder struct {
+	s *bufio.Scanner
+}
+
+type kvEntry struct {
+	Name string
+	Size int
+}
+
+func (d kvDecoder) Decode(v interface{}) error {
+	if !d.s.Scan() {
+		if err := d.s.Err(); err != nil {
+			return err
+		}
+		return io.EOF
+	}
+	e, ok := v.(*kvEntry)
+	if !ok {
+		return fmt.Errorf("cannot decode into %T", v)
+	}
+	_, err := fmt.Sscan(d.s.Text(), &e.Name, &e.Size)
+	return err
+}
+
+func TestCommandDecoder(t *testing.T) {
+	cmd := &Command{
+		Decoders: DecoderMap{
+			"kv": func(r io.Reader) Decoder { return kvDecoder{bufio.NewScanner(r)} },
+		},
+		Type: kvEntry{},
+	}
+
+	makeDec, ok := cmd.Decoder("kv")
+	if !ok {
+		t.Fatal("expected the decoder of the command")
+	}
+	// decoders of commands are passed new values of the type of the command
+	dec := makeDec(strings.NewReader("a 1\nb 2\n"))
+	for _, exp := range []*kvEntry{{"a", 1}, {"b", 2}} {
+		m := &MaybeError{Value: cmd.Type}
+		if err := dec.Decode(m); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(m.Value, exp) {
+			t.Errorf("expected %v, got %v", exp, m.Value)
+		}
+	}
+	if err := dec.Decode(&MaybeError{Value: cmd.Type}); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+
+	// the global decoders apply to the other encodings
+	if _, ok := cmd.Decoder(JSON); !ok {
+		t.Error("expected the global JSON decoder")
+	}
+	if _, ok := cmd.Decoder(Text); ok {
+		t.Error("expected no decoder for text")
+	}
+}
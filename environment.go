@@ -0,0 +1,138 @@
+package cmds
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"sync"
+)
+
+// Environments can take part in the lifecycle of the commands they are
+// passed to by implementing Initializer, Scoper and io.Closer. The
+// interfaces are honored by the local Executor and by Command.Call, which
+// the HTTP handler uses to run commands.
+
+// Initializer is implemented by environments that must be set up before
+// commands run in them, e.g. to open a repository only when a command is run
+// locally rather than sent to a daemon. Environments embedding an InitState
+// are initialized once: Init is called before the first command runs in the
+// environment, and if it fails, again before the next one. Others are
+// initialized before every command.
+type Initializer interface {
+	Init(ctx context.Context) error
+}
+
+// InitState records whether an environment implementing Initializer was
+// initialized. Environments embed it, so they are initialized once, and
+// closed by CloseEnvironment only if they were.
+type InitState struct {
+	l           sync.Mutex
+	initialized bool
+}
+
+func (s *InitState) initState() *InitState {
+	return s
+}
+
+// initStater is implemented by environments embedding an InitState.
+type initStater interface {
+	initState() *InitState
+}
+
+// Scoper is implemented by environments that give commands a view of their
+// own, e.g. to only open the resources the command needs. Scope is called
+// with the path of every command before it runs, and the command is passed
+// the returned environment instead. If that implements io.Closer and isn't
+// the environment itself, it is closed once the command has returned.
+type Scoper interface {
+	Scope(ctx context.Context, path []string) (Environment, error)
+}
+
+// sameEnvironment reports whether a and b are the same environment. Values
+// of comparable types holding values that aren't, e.g. structs with a slice
+// in an interface field, are never the same.
+func sameEnvironment(a, b Environment) (same bool) {
+	if a == nil || b == nil || !reflect.TypeOf(a).Comparable() {
+		return false
+	}
+	defer func() {
+		if recover() != nil {
+			same = false
+		}
+	}()
+	return a == b
+}
+
+// initEnvironment initializes env, unless it already is.
+func initEnvironment(ctx context.Context, env Environment) error {
+	in, ok := env.(Initializer)
+	if !ok {
+		return nil
+	}
+	st, ok := env.(initStater)
+	if !ok {
+		return in.Init(ctx)
+	}
+
+	s := st.initState()
+	s.l.Lock()
+	defer s.l.Unlock()
+	if s.initialized {
+		return nil
+	}
+	if err := in.Init(ctx); err != nil {
+		return err
+	}
+	s.initialized = true
+	return nil
+}
+
+// prepareEnvironment initializes env and scopes it to the command of req.
+// The returned function must be called once the command has returned.
+func prepareEnvironment(req *Request, env Environment) (Environment, func(), error) {
+	if err := initEnvironment(req.Context, env); err != nil {
+		return nil, nil, err
+	}
+
+	s, ok := env.(Scoper)
+	if !ok {
+		return env, func() {}, nil
+	}
+	scoped, err := s.Scope(req.Context, req.Path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c, ok := scoped.(io.Closer)
+	if !ok || sameEnvironment(scoped, env) {
+		return scoped, func() {}, nil
+	}
+	return scoped, func() {
+		if err := c.Close(); err != nil {
+			ContextLogger(req.Context, log).Error("error closing scoped environment", "error", err)
+		}
+	}, nil
+}
+
+// CloseEnvironment closes env if it implements io.Closer. Environments that
+// embed an InitState are only closed if they were initialized. Their owner
+// calls it once no more commands run in them, as cli.Run and the Shutdown
+// method of the HTTP handler do.
+func CloseEnvironment(env Environment) error {
+	c, ok := env.(io.Closer)
+	if !ok {
+		return nil
+	}
+
+	if st, ok := env.(initStater); ok {
+		s := st.initState()
+		s.l.Lock()
+		initialized := s.initialized
+		s.initialized = false
+		s.l.Unlock()
+		if !initialized {
+			return nil
+		}
+	}
+	return c.Close()
+}
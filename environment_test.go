@@ -0,0 +1,219 @@
+package cmds
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type lifecycleEnv struct {
+	InitState
+
+	l       sync.Mutex
+	failing bool
+	inits   int
+	closed  bool
+	scopes  []string
+	// closedScopes counts the closed scoped environments.
+	closedScopes int
+}
+
+func (env *lifecycleEnv) Init(ctx context.Context) error {
+	env.l.Lock()
+	defer env.l.Unlock()
+	if env.failing {
+		return errors.New("init failed")
+	}
+	env.inits++
+	return nil
+}
+
+func (env *lifecycleEnv) Close() error {
+	env.closed = true
+	return nil
+}
+
+func (env *lifecycleEnv) Scope(ctx context.Context, path []string) (Environment, error) {
+	env.l.Lock()
+	defer env.l.Unlock()
+	p := strings.Join(path, " ")
+	env.scopes = append(env.scopes, p)
+	if p == "plain" {
+		return env, nil
+	}
+	return &scopedEnv{env: env, path: p}, nil
+}
+
+type scopedEnv struct {
+	env  *lifecycleEnv
+	path string
+}
+
+func (s *scopedEnv) Close() error {
+	s.env.l.Lock()
+	defer s.env.l.Unlock()
+	s.env.closedScopes++
+	return nil
+}
+
+func TestEnvironmentLifecycle(t *testing.T) {
+	var seen []Environment
+	run := func(req *Request, re ResponseEmitter, env Environment) error {
+		seen = append(seen, env)
+		return nil
+	}
+	root := &Command{
+		Subcommands: map[string]*Command{
+			"data":  {Run: run},
+			"plain": {Run: run},
+		},
+	}
+
+	env := &lifecycleEnv{failing: true}
+	execute := func(path string) error {
+		req, err := NewRequest(context.Background(), []string{path}, nil, nil, nil, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return NewExecutor(root).Execute(req, newTestEmitter(t), env)
+	}
+
+	if err := CloseEnvironment(env); err != nil || env.closed {
+		t.Fatalf("uninitialized environment was closed (%v)", err)
+	}
+
+	if err := execute("data"); err == nil || err.Error() != "init failed" {
+		t.Fatalf("expected init error, got %v", err)
+	}
+	env.failing = false
+
+	for _, path := range []string{"data", "plain"} {
+		if err := execute(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req, err := NewRequest(context.Background(), []string{"data"}, nil, nil, nil, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root.Call(req, newTestEmitter(t), env)
+
+	if env.inits != 1 {
+		t.Errorf("expected 1 init, got %d", env.inits)
+	}
+	if exp := []string{"data", "plain", "data"}; !reflect.DeepEqual(env.scopes, exp) {
+		t.Errorf("expected scopes %v, got %v", exp, env.scopes)
+	}
+	if len(seen) != 3 || seen[1] != env {
+		t.Fatalf("unexpected environments %v", seen)
+	}
+	if s, ok := seen[0].(*scopedEnv); !ok || s.path != "data" {
+		t.Errorf("expected scoped environment, got %#v", seen[0])
+	}
+	// the environment itself isn't closed when it is its own scope
+	if env.closedScopes != 2 || env.closed {
+		t.Errorf("expected 2 closed scopes, got %d (closed %v)", env.closedScopes, env.closed)
+	}
+
+	if err := CloseEnvironment(env); err != nil || !env.closed {
+		t.Errorf("initialized environment wasn't closed (%v)", err)
+	}
+}
+
+// valueEnv is of a comparable type, but its values aren't when v holds a
+// slice.
+type valueEnv struct {
+	v     interface{}
+	inits *int
+}
+
+func (env valueEnv) Init(ctx context.Context) error {
+	*env.inits++
+	return nil
+}
+
+func (env valueEnv) Scope(ctx context.Context, path []string) (Environment, error) {
+	return env, nil
+}
+
+func TestEnvironmentWithoutState(t *testing.T) {
+	root := &Command{
+		Subcommands: map[string]*Command{
+			"data": {Run: func(req *Request, re ResponseEmitter, env Environment) error { return nil }},
+		},
+	}
+
+	// environments without an InitState are initialized before every
+	// command
+	var inits int
+	env := valueEnv{v: []string{"a"}, inits: &inits}
+	for i := 0; i < 2; i++ {
+		req, err := NewRequest(context.Background(), []string{"data"}, nil, nil, nil, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := NewExecutor(root).Execute(req, newTestEmitter(t), env); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if inits != 2 {
+		t.Errorf("expected 2 inits, got %d", inits)
+	}
+}
+
+type closerEnv struct{}
+
+func (closerEnv) Close() error { return nil }
+
+func TestRequires(t *testing.T) {
+	ran := false
+	root := &Command{
+		Subcommands: map[string]*Command{
+			"closing": {
+				Requires: []interface{}{(*io.Closer)(nil)},
+				Run: func(req *Request, re ResponseEmitter, env Environment) error {
+					ran = true
+					return nil
+				},
+			},
+			"bad": {Requires: []interface{}{closerEnv{}}},
+		},
+	}
+
+	errs := root.DebugValidate()
+	if len(errs) != 1 || len(errs["/bad"]) != 1 {
+		t.Fatalf("expected an error for the bad requirement, got %v", errs)
+	}
+	delete(root.Subcommands, "bad")
+
+	if errs := ValidateEnvironment(root, closerEnv{}); errs != nil {
+		t.Errorf("unexpected errors %v", errs)
+	}
+	errs = ValidateEnvironment(root, "env")
+	if len(errs) != 1 || len(errs["/closing"]) != 1 || errs["/closing"][0].Error() != "command unavailable: requires io.Closer" {
+		t.Errorf("expected the closing command to be unavailable, got %v", errs)
+	}
+
+	execute := func(env Environment) error {
+		req, err := NewRequest(context.Background(), []string{"closing"}, nil, nil, nil, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return NewExecutor(root).Execute(req, newTestEmitter(t), env)
+	}
+
+	err := execute("env")
+	if e, ok := err.(Error); !ok || e.Code != ErrNormal || e.Message != `command "closing" unavailable: requires io.Closer` {
+		t.Fatalf("expected unavailable command error, got %#v", err)
+	}
+	if ran {
+		t.Fatal("command ran without its requirements")
+	}
+	if err := execute(closerEnv{}); err != nil || !ran {
+		t.Fatalf("command didn't run (%v)", err)
+	}
+}
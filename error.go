@@ -13,7 +13,8 @@ type ErrorType uint
 const (
 	// ErrNormal is a normal error. The command failed for some reason that's not a bug.
 	ErrNormal ErrorType = iota
-	// ErrClient means the client made an invalid request.
+	// ErrClient means the client made an invalid request, e.g. it passed an
+	// unknown option or a bad argument.
 	ErrClient
 	// ErrImplementation means there's a bug in the implementation.
 	ErrImplementation
@@ -22,6 +23,15 @@ const (
 	// ErrForbidden is returned when the client doesn't have permission to
 	// perform the requested operation.
 	ErrForbidden
+	// ErrTimeout is returned when the server stopped the request because
+	// its deadline passed, either the one sent by the client or the one
+	// set with the timeout option.
+	ErrTimeout
+	// ErrNotFound means the requested command doesn't exist.
+	ErrNotFound
+	// ErrTruncated means the response was cut short because it exceeded
+	// a size limit. The values received before it are complete.
+	ErrTruncated
 )
 
 func (e ErrorType) Error() string {
@@ -40,6 +50,12 @@ func (e ErrorType) String() string {
 		return "rate limited"
 	case ErrForbidden:
 		return "request forbidden"
+	case ErrTimeout:
+		return "request timed out"
+	case ErrNotFound:
+		return "command not found"
+	case ErrTruncated:
+		return "response truncated"
 	default:
 		return "unknown error code"
 	}
@@ -49,6 +65,15 @@ func (e ErrorType) String() string {
 type Error struct {
 	Message string
 	Code    ErrorType
+	// Details is optional, JSON-encodable data about the error. Errors
+	// decoded from JSON carry it as decoded by encoding/json.
+	Details interface{}
+	// HelpURL is the URL of documentation helping users with the error,
+	// see WithHelpURL.
+	HelpURL string
+	// Fields are the problems with the options and arguments of the
+	// request, for errors sent from a ValidationError.
+	Fields []FieldError
 }
 
 // Errorf returns an Error with the given code and format specification
@@ -74,10 +99,16 @@ func (e Error) MarshalJSON() ([]byte, error) {
 		Message string
 		Code    ErrorType
 		Type    string
+		Details interface{}  `json:",omitempty"`
+		HelpURL string       `json:",omitempty"`
+		Fields  []FieldError `json:",omitempty"`
 	}{
 		Message: e.Message,
 		Code:    e.Code,
 		Type:    "error",
+		Details: e.Details,
+		HelpURL: e.HelpURL,
+		Fields:  e.Fields,
 	})
 }
 
@@ -86,6 +117,9 @@ func (e *Error) UnmarshalJSON(data []byte) error {
 		Message string
 		Code    ErrorType
 		Type    string
+		Details interface{}
+		HelpURL string
+		Fields  []FieldError
 	}
 
 	err := json.Unmarshal(data, &w)
@@ -99,6 +133,69 @@ func (e *Error) UnmarshalJSON(data []byte) error {
 
 	e.Message = w.Message
 	e.Code = w.Code
+	e.Details = w.Details
+	e.HelpURL = w.HelpURL
+	e.Fields = w.Fields
 
 	return nil
 }
+
+// WithHelpURL returns err carrying url, the URL of documentation helping
+// users with it, e.g. a troubleshooting page for a common failure. The CLI
+// points users to it under the error, and HTTP servers send it along.
+func WithHelpURL(err error, url string) error {
+	switch e := err.(type) {
+	case nil:
+		return nil
+	case Error:
+		e.HelpURL = url
+		return e
+	case *Error:
+		c := *e
+		c.HelpURL = url
+		return &c
+	}
+	return &helpError{err: err, url: url}
+}
+
+// helpError is an error carrying a help URL, for errors that aren't of type
+// Error.
+type helpError struct {
+	err error
+	url string
+}
+
+func (e *helpError) Error() string {
+	return e.err.Error()
+}
+
+func (e *helpError) Unwrap() error {
+	return e.err
+}
+
+// HelpURL returns the help URL err carries, or "" if it has none. See
+// WithHelpURL.
+func HelpURL(err error) string {
+	var he *helpError
+	if errors.As(err, &he) {
+		return he.url
+	}
+	var pe *Error
+	if errors.As(err, &pe) {
+		return pe.HelpURL
+	}
+	var e Error
+	if errors.As(err, &e) {
+		return e.HelpURL
+	}
+	return ""
+}
+
+// withDocsURL returns err with the documentation URL of cmd as its help URL,
+// if it is a usage error without one.
+func withDocsURL(cmd *Command, err error) error {
+	if err == nil || cmd == nil || cmd.DocsURL == "" || !errors.Is(err, ErrClient) || HelpURL(err) != "" {
+		return err
+	}
+	return WithHelpURL(err, cmd.DocsURL)
+}
@@ -0,0 +1,4 @@
+package client
+
+//go:generate go run ../gen client.go
+//go:generate go run ../gen client.ts
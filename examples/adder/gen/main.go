@@ -0,0 +1,36 @@
+// Command gen generates the clients of the adder commands: the Go client
+// package, or the TypeScript client if the output file ends in .ts.
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/fgeth/fg-ipfs-cmds/clientgen"
+	"github.com/fgeth/fg-ipfs-cmds/examples/adder"
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		log.Fatal("usage: gen <output file>")
+	}
+
+	const generator = "github.com/fgeth/fg-ipfs-cmds/examples/adder/gen"
+	var (
+		src []byte
+		err error
+	)
+	if strings.HasSuffix(os.Args[1], ".ts") {
+		src, err = clientgen.GenerateTypeScript(adder.RootCmd, clientgen.TypeScriptConfig{Generator: generator})
+	} else {
+		src, err = clientgen.Generate(adder.RootCmd, clientgen.Config{Package: "client", Generator: generator})
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile(os.Args[1], src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -34,6 +34,7 @@ type executor struct {
 
 func (x *executor) Execute(req *Request, re ResponseEmitter, env Environment) error {
 	cmd := req.Command
+	defer removeTempDir(req)
 
 	if cmd.Run == nil {
 		return ErrNotCallable
@@ -41,6 +42,24 @@ func (x *executor) Execute(req *Request, re ResponseEmitter, env Environment) er
 
 	err := cmd.CheckArguments(req)
 	if err != nil {
+		return withDocsURL(cmd, err)
+	}
+
+	releaseQuota, err := acquireQuota(req, env)
+	if err != nil {
+		return err
+	}
+	defer releaseQuota()
+
+	defer startInput(req, env)()
+
+	env, release, err := prepareEnvironment(req, env)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if err := checkRequirements(cmd, req, env); err != nil {
 		return err
 	}
 
@@ -77,15 +96,25 @@ func (x *executor) Execute(req *Request, re ResponseEmitter, env Environment) er
 			postEmitter = re
 		)
 		re, postRes = NewChanResponsePair(req)
+		postRes, finishTee := teeValues(postEmitter, postRes)
 		go func() {
 			defer close(postRunCh)
-			postRunCh <- postEmitter.CloseWithError(postRun(postRes, postEmitter))
+			err := postRun(postRes, postEmitter)
+			finishTee(err)
+			applyStatus(postEmitter, postRes)
+			postRunCh <- postEmitter.CloseWithError(err)
 		}()
 		return postRunCh
 	}
 
 	postRunCh := maybeStartPostRun(cmd.PostRun)
-	runCloseErr := re.CloseWithError(cmd.Run(req, re, env))
+	re = DecorateEmitter(req, re)
+	re, err = applySort(req, re)
+	re = applyThrottle(req, re)
+	if err == nil {
+		err = runFunc(cmd, req)(req, re, env)
+	}
+	runCloseErr := re.CloseWithError(withDocsURL(cmd, err))
 	postCloseErr := <-postRunCh
 	switch runCloseErr {
 	case ErrClosingClosedEmitter, nil:
@@ -5,6 +5,7 @@ import (
 	"context"
 	"errors"
 	"io"
+	"reflect"
 	"testing"
 )
 
@@ -177,3 +178,114 @@ func TestExecutorPostRun(t *testing.T) {
 type cliMockEmitter struct{ ResponseEmitter }
 
 func (cliMockEmitter) Type() PostRunType { return CLI }
+
+func TestExecutorDryRun(t *testing.T) {
+	var preRun, ran bool
+	testCmd := &Command{
+		Options: []Option{OptionDryRun},
+		PreRun: func(*Request, Environment) error {
+			preRun = true
+			return nil
+		},
+		Run: func(req *Request, re ResponseEmitter, env Environment) error {
+			ran = true
+			return re.Emit("done")
+		},
+		Type: "",
+	}
+	testRoot := &Command{
+		Subcommands: map[string]*Command{
+			"test": testCmd,
+		},
+	}
+
+	type testcase struct {
+		dryRun   bool
+		simulate Function
+		out      []interface{}
+	}
+
+	simulate := func(req *Request, re ResponseEmitter, env Environment) error {
+		return re.Emit("would do")
+	}
+
+	tcs := []testcase{
+		{out: []interface{}{"done"}},
+		{simulate: simulate, out: []interface{}{"done"}},
+		{dryRun: true},
+		{dryRun: true, simulate: simulate, out: []interface{}{"would do"}},
+	}
+
+	for _, tc := range tcs {
+		preRun, ran = false, false
+		testCmd.Simulate = tc.simulate
+
+		req, err := NewRequest(context.Background(), []string{"test"}, OptMap{DryRunOpt: tc.dryRun}, nil, nil, testRoot)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		re, res := NewChanResponsePair(req)
+		go func() {
+			if err := NewExecutor(testRoot).Execute(req, re, nil); err != nil {
+				t.Error(err)
+			}
+		}()
+
+		var out []interface{}
+		for {
+			v, err := res.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			out = append(out, v)
+		}
+
+		if !preRun {
+			t.Errorf("dry run %v, simulate %v: PreRun wasn't run", tc.dryRun, tc.simulate != nil)
+		}
+		if ran == tc.dryRun {
+			t.Errorf("dry run %v, simulate %v: Run ran: %v", tc.dryRun, tc.simulate != nil, ran)
+		}
+		if !reflect.DeepEqual(out, tc.out) {
+			t.Errorf("dry run %v, simulate %v: expected %v, got %v", tc.dryRun, tc.simulate != nil, tc.out, out)
+		}
+	}
+}
+
+func TestExecutorDryRunUndeclared(t *testing.T) {
+	var ran bool
+	root := &Command{
+		Subcommands: map[string]*Command{
+			"test": {
+				Run: func(req *Request, re ResponseEmitter, env Environment) error {
+					ran = true
+					return nil
+				},
+				Mutating: true,
+			},
+		},
+	}
+
+	// as sent over HTTP to commands that don't declare the option
+	for value, fails := range map[string]bool{"true": false, "1": false, "maybe": true} {
+		ran = false
+		req, err := NewRequest(context.Background(), []string{"test"}, OptMap{DryRunOpt: value}, nil, nil, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		re, res := NewChanResponsePair(req)
+		go NewExecutor(root).Execute(req, re, nil)
+
+		_, err = res.Next()
+		if fails != (err != io.EOF) {
+			t.Errorf("%s: unexpected error %v", value, err)
+		}
+		if ran {
+			t.Errorf("%s: the command ran", value)
+		}
+	}
+}
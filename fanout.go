@@ -0,0 +1,141 @@
+package cmds
+
+import (
+	"context"
+	"io"
+	"strings"
+	"time"
+)
+
+// fanOut returns run sharing its execution between concurrent identical
+// requests, which receive the values emitted while they follow it. The
+// execution is a flight of its own, see singleflight, which runs apart from
+// the requests following it.
+func (g *commandGuard) fanOut(run Function) Function {
+	return func(req *Request, re ResponseEmitter, env Environment) error {
+		key, ok := flightKey(req)
+		if !ok {
+			return run(req, re, env)
+		}
+
+		g.l.Lock()
+		var fl *follower
+		f, ok := g.fanOuts[key]
+		if ok {
+			fl = f.join()
+		}
+		if fl == nil {
+			f = newFlight(true)
+			g.fanOuts[key] = f
+			fl = f.join()
+			go g.runFanOut(key, f, req, run, env)
+		}
+		g.l.Unlock()
+
+		return f.follow(fl, req, re)
+	}
+}
+
+// runFanOut runs the command of req for the followers of f. It runs on a
+// copy of req whose context isn't canceled with that of req, so it outlives
+// the request starting it if others follow it, and which has a temp dir of
+// its own.
+func (g *commandGuard) runFanOut(key string, f *flight, req *Request, run Function, env Environment) {
+	ctx, cancel := context.WithCancel(detachedContext{req.Context})
+	freq := *req
+	freq.Context = ctx
+	freq.tempDir = ""
+	defer removeTempDir(&freq)
+
+	f.l.Lock()
+	f.cancel = cancel
+	if f.closed {
+		cancel()
+	}
+	f.l.Unlock()
+
+	err := run(&freq, &fanOutEmitter{f: f, req: &freq}, env)
+	cancel()
+
+	g.l.Lock()
+	if g.fanOuts[key] == f {
+		delete(g.fanOuts, key)
+	}
+	g.l.Unlock()
+	f.finish(err)
+}
+
+// fanOutEmitter sends the values emitted by the execution of a fan-out to
+// its followers. It waits for every follower to take them, so the slowest
+// sets the pace.
+type fanOutEmitter struct {
+	f   *flight
+	req *Request
+}
+
+func (re *fanOutEmitter) Emit(v interface{}) error {
+	if ch, ok := v.(chan interface{}); ok {
+		v = (<-chan interface{})(ch)
+	}
+	if ch, isChan := v.(<-chan interface{}); isChan {
+		return EmitChan(re, ch)
+	}
+
+	if single, ok := v.(Single); ok {
+		v = single.Value
+	}
+	if _, ok := v.(io.Reader); ok {
+		return Errorf(ErrImplementation, "command %q emitted a reader, which can't be shared", strings.Join(re.req.Path, " "))
+	}
+	return re.send(v)
+}
+
+// send adds v to the values of the flight, and waits until all followers got
+// it.
+func (re *fanOutEmitter) send(v interface{}) error {
+	f := re.f
+	f.update(func() {
+		f.values = append(f.values, v)
+		f.trim()
+	})
+
+	ctx := re.req.Context
+	for {
+		f.l.Lock()
+		sent, changed := len(f.values) == 0, f.changed
+		f.l.Unlock()
+		if sent {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (re *fanOutEmitter) Warn(msg string) {
+	re.send(Warning{Message: msg})
+}
+
+func (re *fanOutEmitter) SetLength(l uint64) {}
+
+func (re *fanOutEmitter) Close() error {
+	return nil
+}
+
+func (re *fanOutEmitter) CloseWithError(err error) error {
+	return nil
+}
+
+// detachedContext carries the values of a context, but not its deadline and
+// cancelation.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
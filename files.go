@@ -0,0 +1,22 @@
+//go:build !cmds_minimal
+// +build !cmds_minimal
+
+package cmds
+
+import (
+	"io"
+
+	files "github.com/fgeth/fg-ipfs-files"
+)
+
+// Directory holds the files sent with a request.
+type Directory = files.Directory
+
+// firstFile returns the first file in dir, or nil if dir is empty.
+func firstFile(dir Directory) (io.ReadCloser, error) {
+	it := dir.Entries()
+	if it.Next() {
+		return files.FileFromEntry(it), nil
+	}
+	return nil, it.Err()
+}
@@ -0,0 +1,19 @@
+//go:build cmds_minimal
+// +build cmds_minimal
+
+package cmds
+
+import (
+	"io"
+)
+
+// Directory stands in for the files sent with a request. Builds with the
+// cmds_minimal tag don't depend on go-ipfs-files, so requests can't carry
+// files; the http and cli packages are not available in such builds.
+type Directory interface {
+	Close() error
+}
+
+func firstFile(dir Directory) (io.ReadCloser, error) {
+	return nil, nil
+}
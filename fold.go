@@ -0,0 +1,224 @@
+package cmds
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+)
+
+// FoldFunc folds the value v into the accumulator acc, and returns the new
+// accumulator.
+type FoldFunc func(acc, v interface{}) (interface{}, error)
+
+// Fold folds the values of res into acc with fn, in the order they were
+// emitted, and returns the result. Values are read one at a time, so only the
+// accumulator is kept in memory, however many values the command emits.
+func Fold(res Response, acc interface{}, fn FoldFunc) (interface{}, error) {
+	for {
+		v, err := res.Next()
+		if err == io.EOF {
+			return acc, nil
+		}
+		if err != nil {
+			return acc, err
+		}
+		if acc, err = fn(acc, v); err != nil {
+			return acc, err
+		}
+	}
+}
+
+// FoldPostRun returns a PostRun function emitting the summary of the values
+// of the response computed by fn, e.g. a count or a total size, rather than
+// the values. init returns the initial accumulator of every response.
+// Warnings are passed on as they arrive.
+func FoldPostRun(init func() interface{}, fn FoldFunc) func(Response, ResponseEmitter) error {
+	return func(res Response, re ResponseEmitter) error {
+		acc, err := Fold(res, init(), func(acc, v interface{}) (interface{}, error) {
+			forwardWarnings(re, res)
+			return fn(acc, v)
+		})
+		forwardWarnings(re, res)
+		if err != nil {
+			return re.CloseWithError(err)
+		}
+		applyStatus(re, res)
+		return EmitOnce(re, acc)
+	}
+}
+
+// DefaultSpoolMemory is the number of values a Spool keeps in memory when
+// NewSpool isn't given a limit.
+const DefaultSpoolMemory = 1024
+
+// Spool keeps values for PostRun functions that need more than one pass
+// over the values of a response, e.g. to compute a total, then the share of
+// each value in it. It keeps the first values in memory and spills the
+// others to a file in the temp dir of the request, encoded in JSON, so the
+// values it gets back from the file are those their JSON decodes into.
+// Values of types that can't be encoded in JSON can only be kept in memory.
+//
+// Spools aren't safe for concurrent use.
+type Spool struct {
+	req *Request
+	max int
+
+	mem []interface{}
+	n   int
+
+	f     *os.File
+	w     *bufio.Writer
+	enc   *json.Encoder
+	types []reflect.Type
+}
+
+// spoolRecord is a value spilled to the file of a spool: the index of its
+// type in the types of the spool, and its JSON.
+type spoolRecord struct {
+	T int
+	V json.RawMessage
+}
+
+// NewSpool returns a spool keeping up to max values of req in memory, or
+// DefaultSpoolMemory if max is 0. Close removes the file of the spool, which
+// is otherwise removed with the temp dir of the request.
+func NewSpool(req *Request, max int) *Spool {
+	if max <= 0 {
+		max = DefaultSpoolMemory
+	}
+	return &Spool{req: req, max: max}
+}
+
+// Add adds v to the spool.
+func (s *Spool) Add(v interface{}) error {
+	if len(s.mem) < s.max {
+		s.mem = append(s.mem, v)
+		s.n++
+		return nil
+	}
+
+	if s.f == nil {
+		dir, err := s.req.TempDir()
+		if err != nil {
+			return err
+		}
+		if s.f, err = ioutil.TempFile(dir, "spool-"); err != nil {
+			return err
+		}
+		s.w = bufio.NewWriter(s.f)
+		s.enc = json.NewEncoder(s.w)
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("spilling value of type %T: %w", v, err)
+	}
+	if err := s.enc.Encode(spoolRecord{T: s.typeIndex(reflect.TypeOf(v)), V: data}); err != nil {
+		return err
+	}
+	s.n++
+	return nil
+}
+
+func (s *Spool) typeIndex(typ reflect.Type) int {
+	for i, t := range s.types {
+		if t == typ {
+			return i
+		}
+	}
+	s.types = append(s.types, typ)
+	return len(s.types) - 1
+}
+
+// Len returns the number of values in the spool.
+func (s *Spool) Len() int {
+	return s.n
+}
+
+// Spilled reports whether some of the values were spilled to disk.
+func (s *Spool) Spilled() bool {
+	return s.f != nil
+}
+
+// Fold folds the values of the spool into acc with fn, in the order they
+// were added, and returns the result. It can be called any number of times,
+// and values can be added between calls.
+func (s *Spool) Fold(acc interface{}, fn FoldFunc) (interface{}, error) {
+	var err error
+	for _, v := range s.mem {
+		if acc, err = fn(acc, v); err != nil {
+			return acc, err
+		}
+	}
+	if s.f == nil {
+		return acc, nil
+	}
+
+	if err := s.w.Flush(); err != nil {
+		return acc, err
+	}
+	f, err := os.Open(s.f.Name())
+	if err != nil {
+		return acc, err
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for {
+		var rec spoolRecord
+		if err := dec.Decode(&rec); err == io.EOF {
+			return acc, nil
+		} else if err != nil {
+			return acc, err
+		}
+		v, err := s.decode(rec)
+		if err != nil {
+			return acc, err
+		}
+		if acc, err = fn(acc, v); err != nil {
+			return acc, err
+		}
+	}
+}
+
+// decode returns the value spilled as rec, of the type it was added with.
+func (s *Spool) decode(rec spoolRecord) (interface{}, error) {
+	if rec.T < 0 || rec.T >= len(s.types) {
+		return nil, fmt.Errorf("spool: invalid type index %d", rec.T)
+	}
+	typ := s.types[rec.T]
+	if typ == nil {
+		var v interface{}
+		err := json.Unmarshal(rec.V, &v)
+		return v, err
+	}
+	if typ.Kind() == reflect.Ptr {
+		v := reflect.New(typ.Elem())
+		err := json.Unmarshal(rec.V, v.Interface())
+		return v.Interface(), err
+	}
+	v := reflect.New(typ)
+	err := json.Unmarshal(rec.V, v.Interface())
+	return v.Elem().Interface(), err
+}
+
+// Close removes the file the spool spilled values to, if any, and drops the
+// values it kept in memory.
+func (s *Spool) Close() error {
+	s.mem, s.types = nil, nil
+	s.n = 0
+	if s.f == nil {
+		return nil
+	}
+	f := s.f
+	s.f, s.w, s.enc = nil, nil, nil
+	err := f.Close()
+	if rerr := os.Remove(f.Name()); err == nil {
+		err = rerr
+	}
+	return err
+}
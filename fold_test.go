@@ -0,0 +1,105 @@
+package cmds
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+)
+
+type foldEntry struct {
+	Name string
+	Size int
+}
+
+func TestFoldPostRun(t *testing.T) {
+	req, err := NewRequest(context.Background(), nil, nil, nil, nil, &Command{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	re, res := NewChanResponsePair(req)
+	go func() {
+		for i := 1; i <= 1000; i++ {
+			if err := re.Emit(&foldEntry{Size: i}); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+		re.Close()
+	}()
+
+	total := FoldPostRun(
+		func() interface{} { return 0 },
+		func(acc, v interface{}) (interface{}, error) {
+			return acc.(int) + v.(*foldEntry).Size, nil
+		},
+	)
+
+	outRe, outRes := NewChanResponsePair(req)
+	go func() {
+		if err := total(res, outRe); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	v, err := outRes.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != 500500 {
+		t.Errorf("expected total 500500, got %v", v)
+	}
+	if _, err := outRes.Next(); err == nil {
+		t.Error("expected the end of the response after the total")
+	}
+}
+
+func TestSpool(t *testing.T) {
+	req, err := NewRequest(context.Background(), []string{"spool"}, nil, nil, nil, &Command{
+		Subcommands: map[string]*Command{"spool": {}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer req.RemoveTempDir()
+
+	s := NewSpool(req, 2)
+	added := []interface{}{
+		&foldEntry{"a", 1},
+		&foldEntry{"b", 2},
+		&foldEntry{"c", 3},
+		foldEntry{"d", 4},
+		&foldEntry{"e", 5},
+	}
+	for _, v := range added {
+		if err := s.Add(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !s.Spilled() || s.Len() != len(added) {
+		t.Fatalf("expected %d values, some spilled, got %d, spilled %t", len(added), s.Len(), s.Spilled())
+	}
+
+	collect := func(acc, v interface{}) (interface{}, error) {
+		return append(acc.([]interface{}), v), nil
+	}
+	// the values can be folded several times, and come back with their types
+	for i := 0; i < 2; i++ {
+		out, err := s.Fold([]interface{}(nil), collect)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(out, added) {
+			t.Errorf("expected values %v, got %v", added, out)
+		}
+	}
+
+	f := s.f.Name()
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(f); !os.IsNotExist(err) {
+		t.Errorf("expected the spool file to be removed, got %v", err)
+	}
+}
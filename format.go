@@ -0,0 +1,146 @@
+package cmds
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Locale is how numbers are written in a language. Text encoders format
+// numbers, sizes and durations with the locale of the request, see LocaleOf,
+// so commands render them the same way.
+type Locale struct {
+	// Thousands separates groups of three digits, e.g. "," in 1,234.
+	Thousands string
+	// Decimal separates the integer and fractional parts of numbers.
+	Decimal string
+}
+
+// DefaultLocale is the locale of requests that don't set one.
+var DefaultLocale = Locale{Thousands: ",", Decimal: "."}
+
+// Locales are the locales the locale option selects, by language. Tags of
+// regional variants, e.g. de-CH, fall back to their language.
+var Locales = map[string]Locale{
+	"en": DefaultLocale,
+	"ja": DefaultLocale,
+	"zh": DefaultLocale,
+	"de": {Thousands: ".", Decimal: ","},
+	"es": {Thousands: ".", Decimal: ","},
+	"it": {Thousands: ".", Decimal: ","},
+	"nl": {Thousands: ".", Decimal: ","},
+	"pt": {Thousands: ".", Decimal: ","},
+	"fr": {Thousands: "\u202f", Decimal: ","},
+	"ru": {Thousands: "\u00a0", Decimal: ","},
+}
+
+// LocaleOf returns the locale of req, set with the locale option if the
+// application supports it, see OptionLocale, and DefaultLocale otherwise.
+func LocaleOf(req *Request) Locale {
+	tag, _ := req.Options[LocaleOpt].(string)
+	if tag == "" {
+		return DefaultLocale
+	}
+	tag = strings.ToLower(strings.ReplaceAll(tag, "_", "-"))
+	if l, ok := Locales[tag]; ok {
+		return l
+	}
+	if i := strings.IndexByte(tag, '-'); i > 0 {
+		if l, ok := Locales[tag[:i]]; ok {
+			return l
+		}
+	}
+	return DefaultLocale
+}
+
+// FormatInt formats n with thousands separators, e.g. "1,234,567".
+func (l Locale) FormatInt(n int64) string {
+	s := strconv.FormatInt(n, 10)
+	if n < 0 {
+		return "-" + l.group(s[1:])
+	}
+	return l.group(s)
+}
+
+// FormatUint is FormatInt for unsigned numbers.
+func (l Locale) FormatUint(n uint64) string {
+	return l.group(strconv.FormatUint(n, 10))
+}
+
+// FormatFloat formats f with prec decimals and thousands separators, e.g.
+// "1,234.5".
+func (l Locale) FormatFloat(f float64, prec int) string {
+	s := strconv.FormatFloat(f, 'f', prec, 64)
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+	intPart, frac := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		intPart, frac = s[:i], s[i+1:]
+	}
+	s = sign + l.group(intPart)
+	if frac != "" {
+		s += l.Decimal + frac
+	}
+	return s
+}
+
+// group inserts thousands separators into the digits s.
+func (l Locale) group(s string) string {
+	if len(s) <= 3 || l.Thousands == "" {
+		return s
+	}
+	var b strings.Builder
+	first := len(s) % 3
+	if first == 0 {
+		first = 3
+	}
+	b.WriteString(s[:first])
+	for i := first; i < len(s); i += 3 {
+		b.WriteString(l.Thousands)
+		b.WriteString(s[i : i+3])
+	}
+	return b.String()
+}
+
+// FormatBytes formats n with a binary unit and one decimal, e.g. "1.5 MiB".
+func (l Locale) FormatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit && exp < 5; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%s %ciB", l.FormatFloat(float64(n)/float64(div), 1), "KMGTPE"[exp])
+}
+
+// FormatDuration formats d in its two largest units, e.g. "1h 5m" or
+// "2m 30s", and with one decimal below a minute, e.g. "1.5s" or "250.0ms".
+func (l Locale) FormatDuration(d time.Duration) string {
+	if d < 0 {
+		return "-" + l.FormatDuration(-d)
+	}
+
+	const day = 24 * time.Hour
+	switch {
+	case d < time.Microsecond:
+		return fmt.Sprintf("%dns", d)
+	case d < time.Millisecond:
+		return l.FormatFloat(float64(d)/float64(time.Microsecond), 1) + "µs"
+	case d < time.Second:
+		return l.FormatFloat(float64(d)/float64(time.Millisecond), 1) + "ms"
+	case d < time.Minute:
+		return l.FormatFloat(d.Seconds(), 1) + "s"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm %ds", d/time.Minute, d%time.Minute/time.Second)
+	case d < day:
+		return fmt.Sprintf("%dh %dm", d/time.Hour, d%time.Hour/time.Minute)
+	default:
+		return fmt.Sprintf("%sd %dh", l.FormatInt(int64(d/day)), d%day/time.Hour)
+	}
+}
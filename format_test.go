@@ -0,0 +1,57 @@
+package cmds
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLocaleFormat(t *testing.T) {
+	en, de := Locales["en"], Locales["de"]
+	for _, tc := range []struct {
+		got, exp string
+	}{
+		{en.FormatInt(0), "0"},
+		{en.FormatInt(999), "999"},
+		{en.FormatInt(-1234567), "-1,234,567"},
+		{de.FormatUint(1000000), "1.000.000"},
+		{en.FormatFloat(1234.5, 2), "1,234.50"},
+		{de.FormatFloat(-1234.5, 1), "-1.234,5"},
+		{en.FormatBytes(1023), "1023 B"},
+		{en.FormatBytes(3 << 19), "1.5 MiB"},
+		{de.FormatBytes(3 << 19), "1,5 MiB"},
+		{en.FormatDuration(500 * time.Nanosecond), "500ns"},
+		{en.FormatDuration(1500 * time.Microsecond), "1.5ms"},
+		{de.FormatDuration(2500 * time.Millisecond), "2,5s"},
+		{en.FormatDuration(150 * time.Second), "2m 30s"},
+		{en.FormatDuration(-65 * time.Minute), "-1h 5m"},
+		{en.FormatDuration(1000*24*time.Hour + 3*time.Hour), "1,000d 3h"},
+	} {
+		if tc.got != tc.exp {
+			t.Errorf("expected %q, got %q", tc.exp, tc.got)
+		}
+	}
+}
+
+func TestLocaleOf(t *testing.T) {
+	cmd := &Command{Options: []Option{OptionLocale}}
+	for tag, exp := range map[string]Locale{
+		"":      DefaultLocale,
+		"de":    Locales["de"],
+		"de_CH": Locales["de"],
+		"FR-ca": Locales["fr"],
+		"xx":    DefaultLocale,
+	} {
+		opts := map[string]interface{}{}
+		if tag != "" {
+			opts[LocaleOpt] = tag
+		}
+		req, err := NewRequest(context.Background(), nil, opts, nil, nil, cmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if l := LocaleOf(req); l != exp {
+			t.Errorf("%q: expected %+v, got %+v", tag, exp, l)
+		}
+	}
+}
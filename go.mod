@@ -5,10 +5,13 @@ go 1.17
 require (
 	github.com/Kubuxu/go-os-helper v0.0.1
 	github.com/fgeth/fg-ipfs-files v0.0.9
+	github.com/fxamacker/cbor/v2 v2.5.0
 	github.com/ipfs/go-log v1.0.5
 	github.com/rs/cors v1.8.0
 	github.com/texttheater/golang-levenshtein/levenshtein v0.0.0-20200805054039-cae8b0eaed6c
+	go.uber.org/zap v1.16.0
 	golang.org/x/crypto v0.0.0-20210921155107-089bfa567519
+	google.golang.org/protobuf v1.34.1
 )
 
 require (
@@ -16,9 +19,9 @@ require (
 	github.com/gogo/protobuf v1.3.2 // indirect
 	github.com/ipfs/go-log/v2 v2.1.3 // indirect
 	github.com/opentracing/opentracing-go v1.2.0 // indirect
+	github.com/x448/float16 v0.8.4 // indirect
 	go.uber.org/atomic v1.7.0 // indirect
 	go.uber.org/multierr v1.6.0 // indirect
-	go.uber.org/zap v1.16.0 // indirect
 	golang.org/x/sys v0.0.0-20210923061019-b8560ed6a9b7 // indirect
 	golang.org/x/term v0.0.0-20201126162022-7de9c90e9dd1 // indirect
 )
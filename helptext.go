@@ -15,4 +15,16 @@ type HelpText struct {
 	Arguments       string // overrides ARGUMENTS section
 	Subcommands     string // overrides SUBCOMMANDS section
 	Synopsis        string // overrides SYNOPSIS field
+
+	// optional - examples of using the command, listed in EXAMPLES
+	Examples []Example
+}
+
+// Example is an example of using a command.
+type Example struct {
+	// CmdLine is the command line of the example, including the name of
+	// the program, e.g. "ipfs add -r dir".
+	CmdLine string
+	// Description says what the example does.
+	Description string `json:",omitempty"`
 }
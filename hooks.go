@@ -0,0 +1,62 @@
+package cmds
+
+import (
+	"sync"
+)
+
+// EmitterDecorator wraps the ResponseEmitter a command emits to. It may
+// return re unchanged.
+type EmitterDecorator func(req *Request, re ResponseEmitter) ResponseEmitter
+
+// ResponseDecorator wraps a Response before it is read. It may return res
+// unchanged.
+type ResponseDecorator func(res Response) Response
+
+var (
+	decoratorsLk       sync.RWMutex
+	emitterDecorators  []EmitterDecorator
+	responseDecorators []ResponseDecorator
+)
+
+// RegisterEmitterDecorator adds d to the decorators applied to the emitters
+// of all commands run by Command.Call and local executors. Decorators are
+// applied in the order they are registered.
+//
+// Decorated emitters generally don't implement the optional interfaces of
+// the emitter they wrap, so decorators should only wrap emitters while they
+// are needed, e.g. while debugging.
+func RegisterEmitterDecorator(d EmitterDecorator) {
+	decoratorsLk.Lock()
+	defer decoratorsLk.Unlock()
+	emitterDecorators = append(emitterDecorators, d)
+}
+
+// RegisterResponseDecorator adds d to the decorators applied to channel
+// responses and responses received from remote servers.
+func RegisterResponseDecorator(d ResponseDecorator) {
+	decoratorsLk.Lock()
+	defer decoratorsLk.Unlock()
+	responseDecorators = append(responseDecorators, d)
+}
+
+// DecorateEmitter applies the registered emitter decorators to re.
+func DecorateEmitter(req *Request, re ResponseEmitter) ResponseEmitter {
+	decoratorsLk.RLock()
+	defer decoratorsLk.RUnlock()
+
+	for _, d := range emitterDecorators {
+		re = d(req, re)
+	}
+	return re
+}
+
+// DecorateResponse applies the registered response decorators to res.
+func DecorateResponse(res Response) Response {
+	decoratorsLk.RLock()
+	defer decoratorsLk.RUnlock()
+
+	for _, d := range responseDecorators {
+		res = d(res)
+	}
+	return res
+}
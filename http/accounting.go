@@ -0,0 +1,139 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+// IdentityFunc returns the identity a request is made on behalf of, e.g. the
+// owner of the API key or token sent with it. The empty string is the
+// anonymous identity.
+type IdentityFunc func(r *http.Request) string
+
+// Usage describes the resources used by a single command request.
+type Usage struct {
+	Identity string
+	Path     []string
+	// Bytes is the size of the response body, or of the values sent for
+	// requests that are part of a batch.
+	Bytes uint64
+	// Duration is the time the command ran.
+	Duration time.Duration
+	// Weight is the weight of the request, see cmds.Cost.
+	Weight uint64
+}
+
+// Accountant tracks the usage of identities, e.g. to enforce quotas or to
+// bill tenants. It is called concurrently.
+type Accountant interface {
+	// Admit is called before a command runs. Returning an error rejects
+	// the request, which is answered with 429 Too Many Requests. Quotas can
+	// weigh requests by the cost of their command, see cmds.CommandCost.
+	Admit(ctx context.Context, identity string, req *cmds.Request) error
+	// Record is called once an admitted command has finished.
+	Record(u Usage)
+}
+
+// ContextWithIdentity returns a copy of ctx carrying the identity id, see
+// cmds.ContextWithIdentity.
+func ContextWithIdentity(ctx context.Context, id string) context.Context {
+	return cmds.ContextWithIdentity(ctx, id)
+}
+
+// Identity returns the identity of the request with context ctx, as returned
+// by ServerConfig.Identify, see cmds.Identity.
+func Identity(ctx context.Context) string {
+	return cmds.Identity(ctx)
+}
+
+// errBusy rejects requests that would exceed ServerConfig.MaxInflightWeight.
+var errBusy = errors.New("server is busy, try again later")
+
+// admit asks the accountant whether req may run, and reserves its weight
+// within ServerConfig.MaxInflightWeight. The returned function releases the
+// weight and records the usage once the command has finished; it is passed
+// the number of bytes sent.
+func (h *handler) admit(req *cmds.Request) (func(bytes uint64), error) {
+	weight := cmds.CommandCost(req.Command).Weight()
+	if !h.inflight.acquire(weight) {
+		return nil, errBusy
+	}
+
+	acct := h.cfg.Accountant
+	if acct == nil {
+		return func(uint64) { h.inflight.release(weight) }, nil
+	}
+
+	id := Identity(req.Context)
+	if err := acct.Admit(req.Context, id, req); err != nil {
+		h.inflight.release(weight)
+		return nil, err
+	}
+
+	start := time.Now()
+	return func(bytes uint64) {
+		h.inflight.release(weight)
+		acct.Record(Usage{
+			Identity: id,
+			Path:     req.Path,
+			Bytes:    bytes,
+			Duration: time.Since(start),
+			Weight:   weight,
+		})
+	}, nil
+}
+
+// inflight is the total weight of the requests running. A max of zero means
+// no limit.
+type inflight struct {
+	max uint64
+
+	l      sync.Mutex
+	weight uint64
+}
+
+// acquire reserves weight, unless that exceeds the limit. Requests heavier
+// than the limit itself run when nothing else does.
+func (in *inflight) acquire(weight uint64) bool {
+	in.l.Lock()
+	defer in.l.Unlock()
+	if in.max > 0 && in.weight > 0 && in.weight+weight > in.max {
+		return false
+	}
+	in.weight += weight
+	return true
+}
+
+func (in *inflight) release(weight uint64) {
+	in.l.Lock()
+	defer in.l.Unlock()
+	in.weight -= weight
+}
+
+// countingResponseWriter counts the bytes of the response body.
+type countingResponseWriter struct {
+	http.ResponseWriter
+	n uint64
+}
+
+func (cw *countingResponseWriter) Write(p []byte) (int, error) {
+	n, err := cw.ResponseWriter.Write(p)
+	atomic.AddUint64(&cw.n, uint64(n))
+	return n, err
+}
+
+func (cw *countingResponseWriter) Flush() {
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (cw *countingResponseWriter) written() uint64 {
+	return atomic.LoadUint64(&cw.n)
+}
@@ -0,0 +1,200 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+// quotaAccountant admits a fixed number of requests per identity.
+type quotaAccountant struct {
+	quota int
+
+	l      sync.Mutex
+	counts map[string]int
+	usage  []Usage
+}
+
+func (a *quotaAccountant) Admit(ctx context.Context, id string, req *cmds.Request) error {
+	a.l.Lock()
+	defer a.l.Unlock()
+	if a.counts[id] >= a.quota {
+		return fmt.Errorf("quota of %s exceeded", id)
+	}
+	a.counts[id]++
+	return nil
+}
+
+func (a *quotaAccountant) Record(u Usage) {
+	a.l.Lock()
+	defer a.l.Unlock()
+	a.usage = append(a.usage, u)
+}
+
+func TestAccounting(t *testing.T) {
+	env, _ := getTestServer(t, nil, false)
+
+	acct := &quotaAccountant{quota: 2, counts: make(map[string]int)}
+	srvCfg := originCfg(defaultOrigins)
+	srvCfg.AllowBatch = true
+	srvCfg.Identify = func(r *http.Request) string {
+		return r.Header.Get(uaHeader)
+	}
+	srvCfg.Accountant = acct
+	srv := httptest.NewServer(NewHandler(env, cmdRoot, srvCfg))
+	defer srv.Close()
+
+	send := func(ua string) error {
+		req, err := cmds.NewRequest(context.Background(), []string{"version"}, nil, nil, nil, cmdRoot)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := NewClient(srv.URL, ClientWithUserAgent(ua)).(*client).send(req)
+		if err != nil {
+			return err
+		}
+		for {
+			if _, err := res.Next(); err != nil {
+				if err == io.EOF {
+					return nil
+				}
+				return err
+			}
+		}
+	}
+
+	for i, tc := range []struct {
+		ua   string
+		fail bool
+	}{
+		{ua: "alice"},
+		{ua: "alice"},
+		{ua: "bob"},
+		{ua: "alice", fail: true},
+	} {
+		err := send(tc.ua)
+		if tc.fail {
+			e, ok := err.(*cmds.Error)
+			if !ok || e.Code != cmds.ErrRateLimited {
+				t.Errorf("%d: expected rate limit error, got %v", i, err)
+			}
+		} else if err != nil {
+			t.Errorf("%d: unexpected error: %s", i, err)
+		}
+	}
+
+	// requests in batches are accounted separately
+	req, err := cmds.NewRequest(context.Background(), []string{"version"}, nil, nil, nil, cmdRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ress, err := NewClient(srv.URL, ClientWithUserAgent("bob")).(Batcher).Batch(context.Background(), req, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// batch requests run concurrently, so either can exceed the quota
+	var failed int
+	for _, res := range ress {
+		if _, err := res.Next(); err != nil {
+			failed++
+		}
+	}
+	if failed != 1 {
+		t.Errorf("expected one batch request to exceed the quota, %d did", failed)
+	}
+
+	// wait for the handlers to record the usage
+	srv.Close()
+
+	acct.l.Lock()
+	defer acct.l.Unlock()
+
+	if len(acct.usage) != 4 {
+		t.Fatalf("expected 4 usage records, got %d", len(acct.usage))
+	}
+	ids := map[string]int{}
+	for _, u := range acct.usage {
+		ids[u.Identity]++
+		if len(u.Path) != 1 || u.Path[0] != "version" {
+			t.Errorf("unexpected path %v", u.Path)
+		}
+		if u.Bytes == 0 || u.Duration <= 0 || u.Weight != 1 {
+			t.Errorf("usage not measured: %+v", u)
+		}
+	}
+	if ids["alice"] != 2 || ids["bob"] != 2 {
+		t.Errorf("unexpected usage per identity: %v", ids)
+	}
+}
+
+func TestInflightWeight(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	root := &cmds.Command{
+		Subcommands: map[string]*cmds.Command{
+			"walk": {
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+					started <- struct{}{}
+					<-release
+					return cmds.EmitOnce(re, "done")
+				},
+				Type:  "",
+				Extra: new(cmds.Extra).SetCost(cmds.Cost{Expensive: true}),
+			},
+			"version": {
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+					return cmds.EmitOnce(re, "1.0")
+				},
+				Type: "",
+			},
+		},
+	}
+
+	srvCfg := originCfg(defaultOrigins)
+	srvCfg.MaxInflightWeight = cmds.ExpensiveWeight + 1
+	srv := httptest.NewServer(NewHandler(nil, root, srvCfg))
+	defer srv.Close()
+
+	send := func(path string) error {
+		req, err := cmds.NewRequest(context.Background(), []string{path}, nil, nil, nil, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := NewClient(srv.URL).(*client).send(req)
+		if err != nil {
+			return err
+		}
+		_, err = res.Next()
+		return err
+	}
+
+	// an expensive request takes most of the weight
+	done := make(chan error, 1)
+	go func() { done <- send("walk") }()
+	<-started
+
+	// a cheap request still fits, another expensive one doesn't
+	if err := send("version"); err != nil {
+		t.Errorf("cheap request: unexpected error: %s", err)
+	}
+	if e, ok := send("walk").(*cmds.Error); !ok || e.Code != cmds.ErrRateLimited {
+		t.Errorf("expected the second expensive request to be rejected, got %v", e)
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// the weight is released once requests finish
+	go func() { done <- send("walk") }()
+	if err := <-done; err != nil {
+		t.Errorf("expensive request after release: %s", err)
+	}
+}
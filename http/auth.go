@@ -0,0 +1,48 @@
+package http
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+const (
+	authorizationHeader   = "Authorization"
+	wwwAuthenticateHeader = "WWW-Authenticate"
+	bearerScheme          = "Bearer"
+)
+
+// ClientWithAuthToken makes the client send token as a bearer token with
+// every request, see ServerConfig.AuthTokens.
+func ClientWithAuthToken(token string) ClientOpt {
+	return func(c *client) {
+		c.authToken = token
+	}
+}
+
+// setAuth adds the auth token of the client to r, if it has one.
+func (c *client) setAuth(r *http.Request) {
+	if c.authToken != "" {
+		r.Header.Set(authorizationHeader, bearerScheme+" "+c.authToken)
+	}
+}
+
+// authorized reports whether r carries one of the tokens of cfg, or whether
+// cfg accepts requests without any.
+func authorized(r *http.Request, cfg *ServerConfig) bool {
+	if len(cfg.AuthTokens) == 0 {
+		return true
+	}
+	auth := r.Header.Get(authorizationHeader)
+	if len(auth) <= len(bearerScheme) || !strings.EqualFold(auth[:len(bearerScheme)], bearerScheme) || auth[len(bearerScheme)] != ' ' {
+		return false
+	}
+	token := []byte(strings.TrimSpace(auth[len(bearerScheme)+1:]))
+
+	// compare with all tokens, so the time taken doesn't tell which matched
+	ok := 0
+	for _, t := range cfg.AuthTokens {
+		ok |= subtle.ConstantTimeCompare(token, []byte(t))
+	}
+	return ok == 1
+}
@@ -0,0 +1,66 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+func TestAuthTokens(t *testing.T) {
+	root := &cmds.Command{
+		Subcommands: map[string]*cmds.Command{
+			"echo": {
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+					return cmds.EmitOnce(re, "hi")
+				},
+				Type: "",
+			},
+		},
+	}
+	cfg := originCfg(defaultOrigins)
+	cfg.AuthTokens = []string{"t1", "t2"}
+	srv := httptest.NewServer(NewHandler(nil, root, cfg))
+	defer srv.Close()
+
+	for auth, exp := range map[string]int{
+		"":           http.StatusUnauthorized,
+		"Bearer t0":  http.StatusUnauthorized,
+		"Basic t1":   http.StatusUnauthorized,
+		"Bearer":     http.StatusUnauthorized,
+		"Bearer t2":  http.StatusOK,
+		"bearer  t1": http.StatusOK,
+	} {
+		httpReq, err := http.NewRequest(http.MethodPost, srv.URL+"/echo", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if auth != "" {
+			httpReq.Header.Set(authorizationHeader, auth)
+		}
+		httpRes, err := http.DefaultClient.Do(httpReq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		httpRes.Body.Close()
+		if httpRes.StatusCode != exp {
+			t.Errorf("%q: expected status %d, got %d", auth, exp, httpRes.StatusCode)
+		}
+		if exp == http.StatusUnauthorized && httpRes.Header.Get(wwwAuthenticateHeader) != bearerScheme {
+			t.Errorf("%q: expected a %s challenge", auth, bearerScheme)
+		}
+	}
+
+	for token, ok := range map[string]bool{"t1": true, "": false} {
+		req, err := cmds.NewRequest(context.Background(), []string{"echo"}, nil, nil, nil, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = NewClient(srv.URL, ClientWithAuthToken(token)).(*client).send(req)
+		if (err == nil) != ok {
+			t.Errorf("token %q: unexpected error %v", token, err)
+		}
+	}
+}
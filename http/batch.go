@@ -0,0 +1,618 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+const (
+	// BatchPath is the path, relative to the API prefix, of the batch
+	// endpoint. It is only served if ServerConfig.AllowBatch is set and
+	// shadows any command of the same name.
+	BatchPath = "batch"
+
+	// DefaultMaxBatchSize is the default limit of requests in one batch.
+	DefaultMaxBatchSize = 64
+
+	// DefaultMaxBatchBytes is the default limit of the size of the body of
+	// a batch.
+	DefaultMaxBatchBytes = 1 << 20
+)
+
+// ErrBatchReader is returned when a batched command emits an io.Reader.
+// Batched responses are multiplexed and can't carry raw streams.
+var ErrBatchReader = errors.New("batched requests do not support streamed output")
+
+// BatchRequest is a single request inside a batch.
+type BatchRequest struct {
+	// ID labels the frames of this request's response.
+	ID        string
+	Path      []string
+	Arguments []string            `json:",omitempty"`
+	Options   map[string][]string `json:",omitempty"`
+}
+
+// BatchFrame is one frame of a batch response. The frames of all requests
+// in the batch are interleaved; the ID says which request a frame belongs to.
+// The last frame of every request has Done set, and carries the error the
+// request failed with, if any.
+type BatchFrame struct {
+	ID    string
+	Value json.RawMessage `json:",omitempty"`
+	Error *cmds.Error     `json:",omitempty"`
+	Done  bool            `json:",omitempty"`
+}
+
+// Batcher is implemented by executors that can send several requests in a
+// single round trip.
+type Batcher interface {
+	// Batch sends reqs and returns their responses, in the same order.
+	// PreRun and PostRun functions are not run for batched requests.
+	Batch(ctx context.Context, reqs ...*cmds.Request) ([]cmds.Response, error)
+}
+
+var _ Batcher = &client{}
+
+func (h *handler) serveBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		setAllowHeader(w, false)
+		http.Error(w, "405 - Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	maxSize := h.cfg.MaxBatchSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxBatchSize
+	}
+	maxBytes := h.cfg.MaxBatchBytes
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxBatchBytes
+	}
+
+	breqs, err := decodeBatch(http.MaxBytesReader(w, r.Body, maxBytes), maxSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// the session is resolved before the headers are written, so unknown
+	// sessions fail with a status
+	env, r, release, err := h.sessionEnv(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	defer release()
+
+	for k, v := range h.cfg.Headers {
+		if !skipAPIHeader(k) {
+			w.Header()[k] = v
+		}
+	}
+	w.Header().Set(contentTypeHeader, applicationJSON)
+	w.Header().Set(channelHeader, "1")
+	w.WriteHeader(http.StatusOK)
+
+	// the requests share the response, so they are all canceled when a
+	// write of it stalls
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	r = r.WithContext(ctx)
+	if h.cfg.EmitTimeout > 0 {
+		logger := cmds.ContextLogger(ctx, log)
+		w = newStallWriter(w, h.cfg.EmitTimeout, func() {
+			logger.Warn("batch response write stalled, canceling its requests",
+				"timeout", h.cfg.EmitTimeout)
+			cancel()
+		})
+	}
+
+	bw := &batchWriter{w: w, enc: json.NewEncoder(w)}
+
+	root := h.getRoot()
+
+	var wg sync.WaitGroup
+	for _, breq := range breqs {
+		wg.Add(1)
+		go func(breq BatchRequest) {
+			defer wg.Done()
+			h.serveBatchRequest(r, root, env, breq, &batchEmitter{id: breq.ID, bw: bw})
+		}(breq)
+	}
+	wg.Wait()
+}
+
+// decodeBatch decodes the requests of a batch from body, failing as soon as
+// there are more than maxSize.
+func decodeBatch(body io.Reader, maxSize int) ([]BatchRequest, error) {
+	dec := json.NewDecoder(body)
+	if t, err := dec.Token(); err != nil || t != json.Delim('[') {
+		if err == nil {
+			err = errors.New("expected an array of requests")
+		}
+		return nil, fmt.Errorf("invalid batch: %s", err)
+	}
+
+	var breqs []BatchRequest
+	for dec.More() {
+		if len(breqs) == maxSize {
+			return nil, fmt.Errorf("batch too large: maximum is %d requests", maxSize)
+		}
+		var breq BatchRequest
+		if err := dec.Decode(&breq); err != nil {
+			return nil, fmt.Errorf("invalid batch: %s", err)
+		}
+		breqs = append(breqs, breq)
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, fmt.Errorf("invalid batch: %s", err)
+	}
+	return breqs, nil
+}
+
+func (h *handler) serveBatchRequest(r *http.Request, root *cmds.Command, env cmds.Environment, breq BatchRequest, re *batchEmitter) {
+	query := url.Values{}
+	for k, v := range breq.Options {
+		query[k] = v
+	}
+	query["arg"] = breq.Arguments
+
+	subr := &http.Request{
+		Method: http.MethodPost,
+		URL: &url.URL{
+			Path:     "/" + strings.Join(breq.Path, "/"),
+			RawQuery: query.Encode(),
+		},
+		Header: http.Header{},
+		Body:   http.NoBody,
+	}
+	subr = subr.WithContext(r.Context())
+
+	if h.cfg.StrictValidation {
+		if verr := validateRequest(subr, root); verr != nil {
+			re.CloseWithError(cmds.Errorf(cmds.ErrClient, "%s", verr))
+			return
+		}
+	}
+
+	req, err := parseRequest(subr, root)
+	if err != nil {
+		if err == ErrNotFound {
+			err = cmds.Errorf(cmds.ErrNotFound, "command not found")
+		}
+		re.CloseWithError(err)
+		return
+	}
+
+	cancel, err := setRequestTimeout(req, r.Header.Get(TimeoutHeader), h.cfg.MaxRequestTimeout)
+	if err != nil {
+		re.CloseWithError(err)
+		return
+	}
+	defer cancel()
+	defer h.active.Track(req, cancel)()
+
+	if err := h.checkReadOnly(req); err != nil {
+		re.CloseWithError(err)
+		return
+	}
+
+	record, err := h.admit(req)
+	if err != nil {
+		re.CloseWithError(cmds.Errorf(cmds.ErrRateLimited, "%s", err))
+		return
+	}
+	defer func() { record(re.written()) }()
+	re.limits = h.responseLimits(req)
+	re.cmd = req.Command
+	re.untagged = cmds.TagsDisabled(req.Context)
+
+	if reqLogger, ok := env.(requestLogger); ok {
+		done := reqLogger.LogRequest(req)
+		defer done()
+	}
+
+	root.Call(req, re, env)
+}
+
+// batchWriter serializes the frames written by concurrent batchEmitters.
+type batchWriter struct {
+	l   sync.Mutex
+	w   http.ResponseWriter
+	enc *json.Encoder
+}
+
+func (bw *batchWriter) write(f BatchFrame) error {
+	bw.l.Lock()
+	defer bw.l.Unlock()
+
+	err := bw.enc.Encode(f)
+	if f, ok := bw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return err
+}
+
+type batchEmitter struct {
+	id string
+	bw *batchWriter
+
+	// limits are the limits of the values sent.
+	limits responseLimits
+	// cmd is the command run, see cmds.TagValue.
+	cmd *cmds.Command
+	// untagged is set if the client predates tags, see
+	// cmds.ContextWithoutTags.
+	untagged bool
+
+	l      sync.Mutex
+	closed bool
+	count  uint64
+	bytes  uint64
+}
+
+// written returns the size of the values sent.
+func (re *batchEmitter) written() uint64 {
+	re.l.Lock()
+	defer re.l.Unlock()
+	return re.bytes
+}
+
+func (re *batchEmitter) SetLength(uint64) {}
+
+func (re *batchEmitter) Close() error {
+	return re.CloseWithError(nil)
+}
+
+func (re *batchEmitter) CloseWithError(err error) error {
+	re.l.Lock()
+	defer re.l.Unlock()
+
+	if re.closed {
+		return cmds.ErrClosingClosedEmitter
+	}
+	re.closed = true
+
+	f := BatchFrame{ID: re.id, Done: true}
+	switch e := err.(type) {
+	case nil:
+	case cmds.Error:
+		f.Error = &e
+	case *cmds.Error:
+		f.Error = e
+	default:
+		if err != io.EOF {
+			f.Error = &cmds.Error{Message: err.Error(), Code: cmds.ErrNormal, HelpURL: cmds.HelpURL(err)}
+		}
+	}
+
+	return re.bw.write(f)
+}
+
+func (re *batchEmitter) Emit(value interface{}) error {
+	if ch, ok := value.(chan interface{}); ok {
+		value = (<-chan interface{})(ch)
+	}
+	if ch, isChan := value.(<-chan interface{}); isChan {
+		return cmds.EmitChan(re, ch)
+	}
+
+	var isSingle bool
+	if single, ok := value.(cmds.Single); ok {
+		value = single.Value
+		isSingle = true
+	}
+
+	if _, ok := value.(io.Reader); ok {
+		return ErrBatchReader
+	}
+
+	re.l.Lock()
+	if re.closed {
+		re.l.Unlock()
+		return cmds.ErrClosedEmitter
+	}
+	if re.limits.exceeded(re.count, re.bytes) {
+		err := truncatedError(re.count)
+		re.l.Unlock()
+		re.CloseWithError(err)
+		return err
+	}
+
+	if re.untagged {
+		switch value.(type) {
+		case cmds.Progress, *cmds.Progress:
+			re.l.Unlock()
+			return nil
+		}
+	} else {
+		value = cmds.TagValue(re.cmd, value)
+	}
+	data, err := json.Marshal(value)
+	if err == nil {
+		err = re.bw.write(BatchFrame{ID: re.id, Value: data})
+		re.count++
+		re.bytes += uint64(len(data))
+	}
+	re.l.Unlock()
+
+	if isSingle && err == nil {
+		err = re.Close()
+	}
+	return err
+}
+
+// Batch sends reqs to the server's batch endpoint in a single HTTP request.
+// The responses can be read in any order; values are buffered until read.
+// If the client verifies signatures, see ClientWithVerifyKey, the responses
+// only end once the whole batch has been received and its signature checked.
+func (c *client) Batch(ctx context.Context, reqs ...*cmds.Request) ([]cmds.Response, error) {
+	breqs := make([]BatchRequest, len(reqs))
+	ress := make(map[string]*batchResponse, len(reqs))
+	out := make([]cmds.Response, len(reqs))
+
+	for i, req := range reqs {
+		if req.Files != nil || req.BodyArgs() != nil {
+			return nil, fmt.Errorf("batched request %q can't send files", strings.Join(req.Path, " "))
+		}
+		if err := req.Command.CheckArguments(req); err != nil {
+			return nil, err
+		}
+		if req.Context == nil {
+			req.Context = ctx
+		}
+		if err := c.checkCapabilities(req); err != nil {
+			return nil, err
+		}
+
+		query, err := getQueryValues(req)
+		if err != nil {
+			return nil, err
+		}
+		// values are sent in frames of JSON, whatever the encoding of req
+		query.Set(cmds.EncLong, string(cmds.JSON))
+
+		id := strconv.Itoa(i)
+		breqs[i] = BatchRequest{
+			ID:        id,
+			Path:      req.Path,
+			Arguments: query["arg"],
+		}
+		delete(query, "arg")
+		breqs[i].Options = query
+
+		res := newBatchResponse(req)
+		ress[id] = res
+		out[i] = res
+	}
+
+	body, err := json.Marshal(breqs)
+	if err != nil {
+		return nil, err
+	}
+
+	url := c.serverAddress + c.apiPrefix + "/" + BatchPath
+	httpReq, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set(contentTypeHeader, applicationJSON)
+	httpReq.Header.Set(uaHeader, c.ua)
+	c.setAuth(httpReq)
+	httpReq.Header.Set(protocolHeader, strconv.Itoa(ProtocolVersion))
+	setSessionHeader(httpReq, ctx)
+	httpReq = httpReq.WithContext(ctx)
+
+	var digest *responseDigest
+	if c.verifyKey != nil {
+		digest, err = c.setSignatureNonce(httpReq)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	httpRes, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+
+	if httpRes.StatusCode != http.StatusOK {
+		defer httpRes.Body.Close()
+		msg, _ := ioutil.ReadAll(httpRes.Body)
+		return nil, &cmds.Error{
+			Message: strings.TrimSpace(string(msg)),
+			Code:    cmds.ErrClient,
+		}
+	}
+
+	if digest != nil {
+		verifyResponse(httpRes, digest, c.verifyKey)
+	}
+	go demuxBatch(httpRes.Body, ress, digest != nil)
+
+	return out, nil
+}
+
+// demuxBatch reads frames from body and dispatches them to the responses.
+// If the body is verified, the responses only end once the whole body has
+// been read, as the signature is checked at its end: values are passed on
+// as they are received, but the end of each response is held until then.
+func demuxBatch(body io.ReadCloser, ress map[string]*batchResponse, verified bool) {
+	defer body.Close()
+
+	var held map[*batchResponse]error
+	if verified {
+		held = make(map[*batchResponse]error, len(ress))
+	}
+	end := func(res *batchResponse, err error) {
+		if held != nil {
+			held[res] = err
+			return
+		}
+		res.finish(err)
+	}
+
+	dec := json.NewDecoder(body)
+	for len(ress) > 0 {
+		var f BatchFrame
+		if err := dec.Decode(&f); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			for _, res := range ress {
+				res.finish(err)
+			}
+			for res := range held {
+				res.finish(err)
+			}
+			return
+		}
+
+		res, ok := ress[f.ID]
+		if !ok {
+			log.Error("received batch frame for unknown request", "id", f.ID)
+			continue
+		}
+
+		if f.Value != nil {
+			var (
+				v   interface{}
+				err error
+			)
+			if dec := frameDecoder(res.req.Context); dec != nil {
+				v, err = dec(f.Value)
+			} else {
+				v, err = decodeValue(res.req.Command, f.Value)
+			}
+			if err != nil {
+				end(res, err)
+				delete(ress, f.ID)
+				continue
+			}
+			res.push(v)
+		}
+
+		if f.Done {
+			var err error = io.EOF
+			if f.Error != nil {
+				err = f.Error
+			}
+			end(res, err)
+			delete(ress, f.ID)
+		}
+	}
+
+	if held != nil {
+		// reading to the end of the body checks the signature
+		_, err := io.Copy(ioutil.Discard, body)
+		for res, resErr := range held {
+			if err != nil {
+				resErr = err
+			}
+			res.finish(resErr)
+		}
+	}
+}
+
+// decodeValue decodes data into a new value of the type cmd emits.
+func decodeValue(cmd *cmds.Command, data []byte) (interface{}, error) {
+	var value interface{}
+	if valueType := reflect.TypeOf(cmd.Type); valueType != nil {
+		if valueType.Kind() == reflect.Ptr {
+			valueType = valueType.Elem()
+		}
+		value = reflect.New(valueType).Interface()
+	}
+
+	m := &cmds.MaybeError{Value: value, Outputs: cmd.OutputTypes}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	return m.Get()
+}
+
+// batchResponse is a cmds.Response that buffers values until they are read.
+type batchResponse struct {
+	req *cmds.Request
+
+	l      sync.Mutex
+	cond   *sync.Cond
+	values []interface{}
+	err    error
+}
+
+func newBatchResponse(req *cmds.Request) *batchResponse {
+	res := &batchResponse{req: req}
+	res.cond = sync.NewCond(&res.l)
+	return res
+}
+
+func (res *batchResponse) push(v interface{}) {
+	res.l.Lock()
+	defer res.l.Unlock()
+
+	res.values = append(res.values, v)
+	res.cond.Signal()
+}
+
+func (res *batchResponse) finish(err error) {
+	res.l.Lock()
+	defer res.l.Unlock()
+
+	res.err = err
+	res.cond.Signal()
+}
+
+func (res *batchResponse) Request() *cmds.Request {
+	return res.req
+}
+
+func (res *batchResponse) Length() uint64 {
+	return 0
+}
+
+func (res *batchResponse) Error() *cmds.Error {
+	res.l.Lock()
+	defer res.l.Unlock()
+
+	switch err := res.err.(type) {
+	case nil:
+		return nil
+	case *cmds.Error:
+		return err
+	default:
+		if err == io.EOF {
+			return nil
+		}
+		return &cmds.Error{Message: err.Error()}
+	}
+}
+
+func (res *batchResponse) Next() (interface{}, error) {
+	res.l.Lock()
+	defer res.l.Unlock()
+
+	for len(res.values) == 0 && res.err == nil {
+		res.cond.Wait()
+	}
+
+	if len(res.values) > 0 {
+		v := res.values[0]
+		res.values = res.values[1:]
+		return v, nil
+	}
+	return nil, res.err
+}
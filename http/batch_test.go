@@ -0,0 +1,137 @@
+package http
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+func TestBatch(t *testing.T) {
+	env, _ := getTestServer(t, nil, false)
+
+	srvCfg := originCfg(defaultOrigins)
+	srvCfg.AllowBatch = true
+	srv := httptest.NewServer(NewHandler(env, cmdRoot, srvCfg))
+	defer srv.Close()
+
+	mkReq := func(path ...string) *cmds.Request {
+		req, err := cmds.NewRequest(context.Background(), path, nil, nil, nil, cmdRoot)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return req
+	}
+
+	c := NewClient(srv.URL).(Batcher)
+	ress, err := c.Batch(context.Background(),
+		mkReq("lateerror"),
+		mkReq("version"),
+		mkReq("error"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ress) != 3 {
+		t.Fatalf("expected 3 responses, got %d", len(ress))
+	}
+
+	// read the responses out of order to check buffering
+	v, err := ress[1].Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expVersion := &VersionOutput{
+		Version: "0.1.2",
+		Commit:  "c0mm17",
+		Repo:    "4",
+		System:  runtime.GOARCH + "/" + runtime.GOOS,
+		Golang:  runtime.Version(),
+	}
+	if vo, ok := v.(*VersionOutput); !ok || *vo != *expVersion {
+		t.Errorf("expected %#v, got %#v", expVersion, v)
+	}
+	if _, err := ress[1].Next(); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+
+	if _, err := ress[2].Next(); err == nil || err.Error() != "an error occurred" {
+		t.Errorf("expected error %q, got %v", "an error occurred", err)
+	}
+
+	v, err = ress[0].Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, ok := v.(*string); !ok || *s != "some value" {
+		t.Errorf("expected %q, got %#v", "some value", v)
+	}
+	if _, err := ress[0].Next(); err == nil || err.Error() != "an error occurred" {
+		t.Errorf("expected error %q, got %v", "an error occurred", err)
+	}
+}
+
+func TestBatchDisabled(t *testing.T) {
+	_, srv := getTestServer(t, nil, false)
+	defer srv.Close()
+
+	req, err := cmds.NewRequest(context.Background(), []string{"version"}, nil, nil, nil, cmdRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = NewClient(srv.URL).(Batcher).Batch(context.Background(), req)
+	if err == nil {
+		t.Fatal("expected an error when batching is disabled")
+	}
+}
+
+func TestBatchLimits(t *testing.T) {
+	env, _ := getTestServer(t, nil, false)
+
+	srvCfg := originCfg(defaultOrigins)
+	srvCfg.AllowBatch = true
+	srvCfg.MaxBatchSize = 2
+	srvCfg.MaxBatchBytes = 256
+	srv := httptest.NewServer(NewHandler(env, cmdRoot, srvCfg))
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		name string
+		body string
+	}{
+		{"too many requests", `[{"Path":["version"]},{"Path":["version"]},{"Path":["version"]}]`},
+		{"too large", `[{"Path":["` + strings.Repeat("x", 256) + `"]}]`},
+		{"not an array", `{"Path":["version"]}`},
+	} {
+		res, err := http.Post(srv.URL+"/"+BatchPath, applicationJSON, strings.NewReader(tc.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", tc.name, http.StatusBadRequest, res.StatusCode)
+		}
+	}
+
+	// the encoding of the batched request is left as it was
+	req, err := cmds.NewRequest(context.Background(), []string{"version"}, cmds.OptMap{cmds.EncLong: cmds.Text}, nil, nil, cmdRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ress, err := NewClient(srv.URL).(Batcher).Batch(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ress[0].Next(); err != nil {
+		t.Fatal(err)
+	}
+	if enc := req.Options[cmds.EncLong]; enc != string(cmds.Text) {
+		t.Errorf("expected the encoding of the request to stay %q, got %q", cmds.Text, enc)
+	}
+}
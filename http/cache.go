@@ -0,0 +1,294 @@
+package http
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+// ClientWithCache makes the client keep the responses of commands that don't
+// change state in dir, so repeating a request doesn't reach the server, and
+// recently fetched responses can still be read while it is unreachable.
+//
+// Responses are fresh for ttl, unless the server sends a Cache-Control
+// header: max-age sets how long they are fresh, no-cache makes the client
+// revalidate them every time and no-store keeps them out of the cache.
+// Responses with an ETag are revalidated with If-None-Match once they are
+// stale. Requests with files, to mutating or subscription commands, and
+// requests of clients verifying signatures or using flow control are never
+// cached.
+func ClientWithCache(dir string, ttl time.Duration) ClientOpt {
+	return func(c *client) {
+		c.cache = &responseCache{dir: dir, ttl: ttl}
+	}
+}
+
+const (
+	cacheControlHeader = "Cache-Control"
+	etagHeader         = "ETag"
+	ifNoneMatchHeader  = "If-None-Match"
+)
+
+// responseCache keeps responses on disk. Every response is stored in two
+// files named after the hash of its request: the body, and its metadata.
+type responseCache struct {
+	dir string
+	ttl time.Duration
+
+	// l serializes the updates of entries.
+	l sync.Mutex
+}
+
+// cacheEntry is the metadata of a cached response.
+type cacheEntry struct {
+	Status  int
+	Header  http.Header
+	Expires time.Time
+}
+
+// cacheable reports whether the response to req can be cached.
+func (c *client) cacheable(req *cmds.Request, httpReq *http.Request) bool {
+	if c.cache == nil || c.verifyKey != nil || c.flowWindow > 0 {
+		return false
+	}
+	if httpReq.Body != nil && httpReq.Body != http.NoBody {
+		return false
+	}
+	return req.Command != nil && !req.Command.Mutating && !req.Command.Subscription
+}
+
+// do sends httpReq, or answers it from the cache.
+func (c *client) do(req *cmds.Request, httpReq *http.Request) (*http.Response, error) {
+	if !c.cacheable(req, httpReq) {
+		return c.httpClient.Do(httpReq)
+	}
+	return c.cache.do(c.httpClient, httpReq)
+}
+
+func (rc *responseCache) key(httpReq *http.Request) string {
+	h := sha256.New()
+	io.WriteString(h, httpReq.URL.String())
+	io.WriteString(h, "\n"+httpReq.Header.Get(sessionHeader))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (rc *responseCache) paths(key string) (meta, body string) {
+	p := filepath.Join(rc.dir, key)
+	return p + ".json", p + ".body"
+}
+
+func (rc *responseCache) do(hc *http.Client, httpReq *http.Request) (*http.Response, error) {
+	key := rc.key(httpReq)
+	entry, _ := rc.load(key)
+
+	if entry != nil && time.Now().Before(entry.Expires) {
+		if res, err := rc.response(key, entry, httpReq); err == nil {
+			return res, nil
+		}
+	}
+	if entry != nil {
+		if etag := entry.Header.Get(etagHeader); etag != "" {
+			httpReq.Header.Set(ifNoneMatchHeader, etag)
+		}
+	}
+
+	res, err := hc.Do(httpReq)
+	if err != nil {
+		if entry == nil || httpReq.Context().Err() != nil {
+			return nil, err
+		}
+		// serve what we have while the server can't be reached
+		log.Warn("server unreachable, using cached response", "url", httpReq.URL.String(), "error", err)
+		return rc.response(key, entry, httpReq)
+	}
+
+	switch {
+	case res.StatusCode == http.StatusNotModified && entry != nil:
+		res.Body.Close()
+		entry.Expires = rc.expires(res.Header)
+		if err := rc.storeEntry(key, entry); err != nil {
+			log.Error("error updating cached response", "error", err)
+		}
+		return rc.response(key, entry, httpReq)
+	case res.StatusCode != http.StatusOK || noStore(res.Header):
+		return res, nil
+	}
+
+	f, err := ioutil.TempFile(rc.dir, "body-")
+	if err != nil {
+		log.Error("error caching response", "error", err)
+		return res, nil
+	}
+	res.Body = &cachingBody{
+		ReadCloser: res.Body,
+		res:        res,
+		f:          f,
+		commit: func() error {
+			return rc.store(key, &cacheEntry{
+				Status:  res.StatusCode,
+				Header:  res.Header,
+				Expires: rc.expires(res.Header),
+			}, f.Name())
+		},
+	}
+	return res, nil
+}
+
+// expires returns when a response with header h becomes stale.
+func (rc *responseCache) expires(h http.Header) time.Time {
+	now := time.Now()
+	for _, d := range strings.Split(h.Get(cacheControlHeader), ",") {
+		d = strings.TrimSpace(d)
+		switch {
+		case d == "no-cache":
+			return now
+		case strings.HasPrefix(d, "max-age="):
+			if secs, err := strconv.Atoi(strings.TrimPrefix(d, "max-age=")); err == nil {
+				return now.Add(time.Duration(secs) * time.Second)
+			}
+		}
+	}
+	return now.Add(rc.ttl)
+}
+
+func noStore(h http.Header) bool {
+	for _, d := range strings.Split(h.Get(cacheControlHeader), ",") {
+		if strings.TrimSpace(d) == "no-store" {
+			return true
+		}
+	}
+	return false
+}
+
+func (rc *responseCache) load(key string) (*cacheEntry, error) {
+	metaPath, _ := rc.paths(key)
+	data, err := ioutil.ReadFile(metaPath)
+	if err != nil {
+		return nil, err
+	}
+	entry := &cacheEntry{}
+	if err := json.Unmarshal(data, entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// response returns the cached response to httpReq.
+func (rc *responseCache) response(key string, entry *cacheEntry, httpReq *http.Request) (*http.Response, error) {
+	_, bodyPath := rc.paths(key)
+	f, err := os.Open(bodyPath)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &http.Response{
+		Status:        http.StatusText(entry.Status),
+		StatusCode:    entry.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        entry.Header.Clone(),
+		Trailer:       http.Header{},
+		Body:          f,
+		ContentLength: fi.Size(),
+		Request:       httpReq,
+	}, nil
+}
+
+// store moves the body at bodyPath into the cache, with entry.
+func (rc *responseCache) store(key string, entry *cacheEntry, bodyPath string) error {
+	rc.l.Lock()
+	defer rc.l.Unlock()
+
+	_, cachedBody := rc.paths(key)
+	if err := os.Rename(bodyPath, cachedBody); err != nil {
+		os.Remove(bodyPath)
+		return err
+	}
+	return rc.writeEntry(key, entry)
+}
+
+func (rc *responseCache) storeEntry(key string, entry *cacheEntry) error {
+	rc.l.Lock()
+	defer rc.l.Unlock()
+	return rc.writeEntry(key, entry)
+}
+
+func (rc *responseCache) writeEntry(key string, entry *cacheEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	metaPath, _ := rc.paths(key)
+	return ioutil.WriteFile(metaPath, data, 0600)
+}
+
+// cachingBody copies the body of a response to f while it is read. The copy
+// is committed to the cache once the body has been read completely without
+// a stream error, and dropped otherwise.
+type cachingBody struct {
+	io.ReadCloser
+	res    *http.Response
+	f      *os.File
+	commit func() error
+
+	done bool
+}
+
+func (b *cachingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if b.done {
+		return n, err
+	}
+	if n > 0 {
+		if _, werr := b.f.Write(p[:n]); werr != nil {
+			log.Error("error caching response", "error", werr)
+			b.drop()
+		}
+	}
+	switch {
+	case err == io.EOF:
+		// the trailers are only known at the end of the body
+		if b.res.Trailer.Get(StreamErrHeader) != "" {
+			b.drop()
+			break
+		}
+		b.done = true
+		b.f.Close()
+		if cerr := b.commit(); cerr != nil {
+			log.Error("error caching response", "error", cerr)
+		}
+	case err != nil:
+		b.drop()
+	}
+	return n, err
+}
+
+func (b *cachingBody) Close() error {
+	b.drop()
+	return b.ReadCloser.Close()
+}
+
+func (b *cachingBody) drop() {
+	if b.done {
+		return
+	}
+	b.done = true
+	b.f.Close()
+	os.Remove(b.f.Name())
+}
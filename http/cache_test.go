@@ -0,0 +1,107 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+func TestClientCache(t *testing.T) {
+	var runs int32
+	run := func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+		n := atomic.AddInt32(&runs, 1)
+		return cmds.EmitOnce(re, n)
+	}
+	root := &cmds.Command{
+		Subcommands: map[string]*cmds.Command{
+			"get": {Run: run, Type: int32(0)},
+			"set": {Run: run, Type: int32(0), Mutating: true},
+		},
+	}
+
+	type testcase struct {
+		name string
+		path string
+		ttl  time.Duration
+		// header is set on the responses of the server.
+		header http.Header
+		// etag makes the server answer If-None-Match with 304.
+		etag    bool
+		offline bool
+
+		first, second int32
+	}
+
+	tcs := []testcase{
+		{name: "fresh", path: "get", ttl: time.Minute, first: 1, second: 1},
+		{name: "mutating", path: "set", ttl: time.Minute, first: 1, second: 2},
+		{name: "expired", path: "get", first: 1, second: 2},
+		{name: "offline", path: "get", offline: true, first: 1, second: 1},
+		{name: "max-age", path: "get", header: http.Header{cacheControlHeader: {"max-age=60"}}, first: 1, second: 1},
+		{name: "no-store", path: "get", ttl: time.Minute, header: http.Header{cacheControlHeader: {"no-store"}}, first: 1, second: 2},
+		{
+			name:   "etag",
+			path:   "get",
+			ttl:    time.Minute,
+			header: http.Header{cacheControlHeader: {"no-cache"}, etagHeader: {`"v1"`}},
+			etag:   true,
+			first:  1, second: 1,
+		},
+	}
+
+	for _, tc := range tcs {
+		atomic.StoreInt32(&runs, 0)
+
+		h := NewHandler(nil, root, originCfg(defaultOrigins))
+		var revalidated int
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if tc.etag && r.Header.Get(ifNoneMatchHeader) == `"v1"` {
+				revalidated++
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			for k, v := range tc.header {
+				w.Header()[k] = v
+			}
+			h.ServeHTTP(w, r)
+		}))
+
+		c := NewClient(srv.URL, ClientWithCache(t.TempDir(), tc.ttl)).(*client)
+		get := func() (int32, error) {
+			req, err := cmds.NewRequest(context.Background(), []string{tc.path}, nil, nil, nil, root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := c.send(req)
+			if err != nil {
+				return 0, err
+			}
+			v, err := res.Next()
+			if err != nil {
+				return 0, err
+			}
+			// reach the end, so the response is stored
+			res.Next()
+			return *v.(*int32), nil
+		}
+
+		if v, err := get(); err != nil || v != tc.first {
+			t.Errorf("%s: expected %d first, got %d, %v", tc.name, tc.first, v, err)
+		}
+		if tc.offline {
+			srv.Close()
+		}
+		if v, err := get(); err != nil || v != tc.second {
+			t.Errorf("%s: expected %d second, got %d, %v", tc.name, tc.second, v, err)
+		}
+		if tc.etag && revalidated != 1 {
+			t.Errorf("%s: expected one revalidation, got %d", tc.name, revalidated)
+		}
+		srv.Close()
+	}
+}
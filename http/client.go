@@ -2,12 +2,17 @@ package http
 
 import (
 	"context"
+	"crypto/ed25519"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/fgeth/fg-ipfs-cmds"
 
@@ -18,16 +23,43 @@ const (
 	ApiUrlFormat = "%s%s/%s?%s"
 )
 
-var OptionSkipMap = map[string]bool{
-	"api": true,
-}
+// OptionSkipMap are the options the client doesn't send: "api", and the
+// cmds.ClientSideOptions it applies to the values it decodes.
+var OptionSkipMap = func() map[string]bool {
+	skip := map[string]bool{"api": true}
+	for name := range cmds.ClientSideOptions {
+		skip[name] = true
+	}
+	return skip
+}()
 
 type client struct {
 	serverAddress string
 	httpClient    *http.Client
 	ua            string
 	apiPrefix     string
+	authToken     string
 	fallback      cmds.Executor
+
+	handshake bool
+	version   string
+	capsLk    sync.Mutex
+	capsDone  bool
+	caps      *Capabilities
+
+	// skew is the skew found by the handshake, see checkCapabilities
+	skew          *versionSkew
+	skewDone      bool
+	skewWarned    bool
+	noSkewWarning bool
+
+	payloadKey []byte
+	verifyKey  ed25519.PublicKey
+	flowWindow int
+
+	uploadChunk int
+
+	cache *responseCache
 }
 
 // ClientOpt is an option that can be passed to the HTTP client constructor.
@@ -66,7 +98,7 @@ func ClientWithFallback(exe cmds.Executor) ClientOpt {
 
 // NewClient constructs a new HTTP-backed command executor.
 func NewClient(address string, opts ...ClientOpt) cmds.Executor {
-	if !strings.HasPrefix(address, "http://") {
+	if !strings.HasPrefix(address, "http://") && !strings.HasPrefix(address, "https://") {
 		address = "http://" + address
 	}
 
@@ -83,22 +115,30 @@ func NewClient(address string, opts ...ClientOpt) cmds.Executor {
 	return c
 }
 
-func (c *client) Execute(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
-	cmd := req.Command
+// errCannotConnect is returned by Send if the server isn't running, in which
+// case Execute runs the request with the fallback executor if there is one.
+var errCannotConnect = errors.New("cannot connect to the api. Is the daemon running? To run as a standalone CLI command remove the api file in `$IPFS_PATH/api`")
 
-	err := cmd.CheckArguments(req)
-	if err != nil {
-		return err
+// Execute runs req on the server, see cmds.NewTransportExecutor.
+func (c *client) Execute(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+	err := cmds.NewTransportExecutor(c).Execute(req, re, env)
+	if err == errCannotConnect && c.fallback != nil {
+		// XXX: this runs the PreRun twice
+		return c.fallback.Execute(req, re, env)
 	}
+	return err
+}
 
-	if cmd.PreRun != nil {
-		err := cmd.PreRun(req, env)
-		if err != nil {
-			return err
-		}
+// Send sends req to the server and returns its response, which makes the
+// client a cmds.ClientTransport.
+func (c *client) Send(req *cmds.Request) (cmds.Response, error) {
+	var res cmds.Response
+	var err error
+	if all, _ := req.Options[cmds.AllOpt].(bool); all {
+		res, err = c.sendAllPages(req)
+	} else {
+		res, err = c.send(req)
 	}
-
-	res, err := c.send(req)
 	if err != nil {
 		// Unwrap any URL errors. We don't really need to expose the
 		// underlying HTTP nonsense to the user.
@@ -108,31 +148,11 @@ func (c *client) Execute(req *cmds.Request, re cmds.ResponseEmitter, env cmds.En
 
 		if netoperr, ok := err.(*net.OpError); ok && netoperr.Op == "dial" {
 			// Connection refused.
-			if c.fallback != nil {
-				// XXX: this runs the PreRun twice
-				return c.fallback.Execute(req, re, env)
-			}
-			err = fmt.Errorf("cannot connect to the api. Is the daemon running? To run as a standalone CLI command remove the api file in `$IPFS_PATH/api`")
-		}
-		return err
-	}
-
-	if cmd.PostRun != nil {
-		if typer, ok := re.(interface {
-			Type() cmds.PostRunType
-		}); ok && cmd.PostRun[typer.Type()] != nil {
-			err := cmd.PostRun[typer.Type()](res, re)
-			closeErr := re.CloseWithError(err)
-			if closeErr == cmds.ErrClosingClosedEmitter {
-				// ignore double close errors
-				return nil
-			}
-
-			return closeErr
+			return nil, errCannotConnect
 		}
+		return nil, err
 	}
-
-	return cmds.Copy(re, res)
+	return res, nil
 }
 
 func (c *client) toHTTPRequest(req *cmds.Request) (*http.Request, error) {
@@ -172,6 +192,16 @@ func (c *client) toHTTPRequest(req *cmds.Request) (*http.Request, error) {
 		httpReq.Header.Set(contentTypeHeader, applicationOctetStream)
 	}
 	httpReq.Header.Set(uaHeader, c.ua)
+	c.setAuth(httpReq)
+	httpReq.Header.Set(acceptEncodingHeader, acceptedEncodings())
+	httpReq.Header.Set(protocolHeader, strconv.Itoa(ProtocolVersion))
+	setSessionHeader(httpReq, req.Context)
+	if c.flowWindow > 0 {
+		httpReq.Header.Set(flowWindowHeader, strconv.Itoa(c.flowWindow))
+	}
+	if deadline, ok := req.Context.Deadline(); ok {
+		httpReq.Header.Set(TimeoutHeader, serverTimeout(time.Until(deadline)).String())
+	}
 
 	httpReq = httpReq.WithContext(req.Context)
 	httpReq.Close = true
@@ -181,15 +211,29 @@ func (c *client) toHTTPRequest(req *cmds.Request) (*http.Request, error) {
 
 func (c *client) send(req *cmds.Request) (cmds.Response, error) {
 	if req.Context == nil {
-		log.Warnf("no context set in request")
+		log.Warn("no context set in request")
 		req.Context = context.Background()
 	}
 
+	if err := c.checkCapabilities(req); err != nil {
+		return nil, err
+	}
+
 	// save user-provided encoding
 	previousUserProvidedEncoding, found := req.Options[cmds.EncLong].(string)
+	if found {
+		// the server checks the wire encoding only
+		if err := req.Command.CheckEncoding(cmds.EncodingType(previousUserProvidedEncoding)); err != nil {
+			return nil, err
+		}
+	}
 
-	// override with json to send to server
-	req.SetOption(cmds.EncLong, cmds.JSON)
+	// override with the wire encoding of the command to send to server
+	wire := wireEncoding(req.Command)
+	if _, ok := req.Command.Decoder(wire); !ok {
+		return nil, cmds.Errorf(cmds.ErrImplementation, "no decoder for the wire encoding %s of the command", wire)
+	}
+	req.SetOption(cmds.EncLong, string(wire))
 
 	// stream channel output
 	req.SetOption(cmds.ChanOpt, true)
@@ -200,17 +244,65 @@ func (c *client) send(req *cmds.Request) (cmds.Response, error) {
 		return nil, err
 	}
 
+	// uploads aren't sealed, the body is sent with the sealed request
+	if c.uploadChunk > 0 && c.payloadKey == nil && httpReq.Body != nil && httpReq.Body != http.NoBody {
+		if err := c.upload(req.Context, httpReq); err != nil {
+			return nil, err
+		}
+	}
+
+	var digest *responseDigest
+	if c.verifyKey != nil {
+		digest, err = c.setSignatureNonce(httpReq)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var open func(*http.Response) (*http.Response, error)
+	if c.payloadKey != nil {
+		httpReq, open, err = c.sealRequest(httpReq)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// send http request
-	httpRes, err := c.httpClient.Do(httpReq)
+	httpRes, err := c.do(req, httpReq)
 	if err != nil {
 		return nil, err
 	}
 
-	// parse using the overridden JSON encoding in request
-	res, err := parseResponse(httpRes, req)
+	if open != nil {
+		httpRes, err = open(httpRes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := decompressResponse(httpRes); err != nil {
+		return nil, err
+	}
+
+	if digest != nil {
+		verifyResponse(httpRes, digest, c.verifyKey)
+	}
+
+	// parse using the overridden encoding in request
+	var warnings []string
+	if w := c.skewWarning(); w != "" {
+		warnings = append(warnings, w)
+	}
+	res, err := parseResponse(httpRes, req, warnings...)
 	if err != nil {
+		if c.skew != nil {
+			err = c.skew.annotate(err)
+		}
 		return nil, err
 	}
+	if c.flowWindow > 0 {
+		res = c.newFlowResponse(req.Context, res, httpRes)
+	}
 
 	// reset request encoding to what it was before
 	if found && len(previousUserProvidedEncoding) > 0 {
@@ -224,13 +316,34 @@ func (c *client) send(req *cmds.Request) (cmds.Response, error) {
 }
 
 func getQuery(req *cmds.Request) (string, error) {
+	query, err := getQueryValues(req)
+	if err != nil {
+		return "", err
+	}
+	return query.Encode(), nil
+}
+
+// getQueryValues encodes the options and string arguments of req the way the
+// handler expects them in the URL query.
+func getQueryValues(req *cmds.Request) (url.Values, error) {
 	query := url.Values{}
 
+	// options of registered kinds are sent the way they are parsed
+	var optDefs map[string]cmds.Option
+	if req.Root != nil {
+		optDefs, _ = req.Root.GetOptions(req.Path)
+	}
+
 	for k, v := range req.Options {
 		if OptionSkipMap[k] {
 			continue
 		}
 
+		if kind := cmds.OptionKindOf(optDefs[k]); kind != nil {
+			query.Set(k, kind.FormatValue(v))
+			continue
+		}
+
 		switch val := v.(type) {
 		case []string:
 			for _, o := range val {
@@ -240,7 +353,7 @@ func getQuery(req *cmds.Request) (string, error) {
 			str := fmt.Sprintf("%v", v)
 			query.Set(k, str)
 		default:
-			return "", fmt.Errorf("unsupported query parameter type. key: %s, value: %v", k, v)
+			return nil, fmt.Errorf("unsupported query parameter type. key: %s, value: %v", k, v)
 		}
 	}
 
@@ -264,5 +377,17 @@ func getQuery(req *cmds.Request) (string, error) {
 		}
 	}
 
-	return query.Encode(), nil
+	return query, nil
+}
+
+// serverTimeout returns the timeout the server is asked to enforce, given the
+// time left until the client's deadline. Part of that time is reserved for
+// the server's timeout error to arrive, so the client can tell it from the
+// request being cut off.
+func serverTimeout(left time.Duration) time.Duration {
+	margin := left / 10
+	if margin > time.Second {
+		margin = time.Second
+	}
+	return left - margin
 }
@@ -1,6 +1,9 @@
 package http
 
 import (
+	"context"
+	"errors"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -91,3 +94,113 @@ func TestClientAPIPrefix(t *testing.T) {
 		}
 	}
 }
+
+func TestClientAddress(t *testing.T) {
+	for addr, exp := range map[string]string{
+		"localhost:5001":         "http://localhost:5001",
+		"http://localhost:5001":  "http://localhost:5001",
+		"https://localhost:5001": "https://localhost:5001",
+	} {
+		if c := NewClient(addr).(*client); c.serverAddress != exp {
+			t.Errorf("expected address %q for %q, got %q", exp, addr, c.serverAddress)
+		}
+	}
+}
+
+func TestClientCommandEncodings(t *testing.T) {
+	root := &cmds.Command{
+		Subcommands: map[string]*cmds.Command{
+			"text": {
+				Encodings: []cmds.EncodingType{cmds.Text},
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+					return cmds.EmitOnce(re, "hello")
+				},
+				Type: "",
+			},
+		},
+	}
+	srv := httptest.NewServer(NewHandler(nil, root, originCfg(defaultOrigins)))
+	defer srv.Close()
+	c := NewClient(srv.URL).(*client)
+
+	// the values are sent in the wire encoding, and encoded as text by the
+	// caller
+	req, err := cmds.NewRequest(context.Background(), []string{"text"}, cmds.OptMap{cmds.EncLong: "text"}, nil, nil, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := c.send(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := res.Next(); err != nil {
+		t.Fatal(err)
+	} else if s, _ := v.(*string); s == nil || *s != "hello" {
+		t.Errorf("expected hello, got %v", v)
+	}
+
+	// encodings the command doesn't support are rejected by the client
+	req, err = cmds.NewRequest(context.Background(), []string{"text"}, cmds.OptMap{cmds.EncLong: "xml"}, nil, nil, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.send(req); !errors.Is(err, cmds.ErrClient) {
+		t.Errorf("expected a client error, got %v", err)
+	}
+}
+
+func TestClientTransport(t *testing.T) {
+	root := &cmds.Command{
+		Subcommands: map[string]*cmds.Command{
+			"hello": {
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+					return cmds.EmitOnce(re, "hello")
+				},
+				Type: "",
+			},
+		},
+	}
+	srv := httptest.NewServer(NewHandler(nil, root, originCfg(defaultOrigins)))
+	c := NewClient(srv.URL, ClientWithFallback(cmds.NewExecutor(root)))
+
+	expectHello := func(res cmds.Response) {
+		t.Helper()
+		v, err := res.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s, ok := v.(*string); ok {
+			v = *s
+		}
+		if v != "hello" {
+			t.Errorf("expected hello, got %v", v)
+		}
+		if _, err := res.Next(); err != io.EOF {
+			t.Errorf("expected EOF, got %v", err)
+		}
+	}
+
+	req, err := cmds.NewRequest(context.Background(), []string{"hello"}, nil, nil, nil, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := c.(cmds.ClientTransport).Send(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectHello(res)
+
+	// requests run with the fallback if the server is down
+	srv.Close()
+	req, err = cmds.NewRequest(context.Background(), []string{"hello"}, nil, nil, nil, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	re, res := cmds.NewChanResponsePair(req)
+	go func() {
+		if err := c.Execute(req, re, nil); err != nil {
+			re.CloseWithError(err)
+		}
+	}()
+	expectHello(res)
+}
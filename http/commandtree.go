@@ -0,0 +1,56 @@
+package http
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+// CommandTreePath is the path, relative to the API prefix, at which the
+// description of the served command tree is available if
+// ServerConfig.ServeCommandTree is set.
+const CommandTreePath = "commands.json"
+
+const (
+	// commandTreeHashHeader carries the hex encoded SHA-256 hash of the
+	// command tree description.
+	commandTreeHashHeader = "X-Command-Tree-Hash"
+	// serverVersionHeader carries ServerConfig.Version.
+	serverVersionHeader = "X-Server-Version"
+)
+
+func (h *handler) serveCommandTree(w http.ResponseWriter, r *http.Request) {
+	data, err := cmds.CommandsJSON(h.getRoot())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	for k, v := range h.cfg.Headers {
+		if !skipAPIHeader(k) {
+			w.Header()[k] = v
+		}
+	}
+	w.Header().Set(commandTreeHashHeader, treeHash(data))
+	if h.cfg.Version != "" {
+		w.Header().Set(serverVersionHeader, h.cfg.Version)
+	}
+	w.Header().Set(contentTypeHeader, applicationJSON)
+	w.WriteHeader(http.StatusOK)
+
+	if r.Method == http.MethodHead {
+		return
+	}
+	if _, err := w.Write(data); err != nil {
+		h.logger().Error("error sending command tree", "error", err)
+	}
+}
+
+// treeHash returns the value of the commandTreeHashHeader of the command tree
+// description data.
+func treeHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,55 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+func TestCommandTree(t *testing.T) {
+	env, _ := getTestServer(t, nil, false)
+
+	for _, enabled := range []bool{true, false} {
+		srvCfg := originCfg(defaultOrigins)
+		srvCfg.ServeCommandTree = enabled
+		srv := httptest.NewServer(NewHandler(env, cmdRoot, srvCfg))
+
+		res, err := http.Get(srv.URL + "/" + CommandTreePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !enabled {
+			if res.StatusCode != http.StatusNotFound {
+				t.Errorf("expected status 404, got %d", res.StatusCode)
+			}
+			res.Body.Close()
+			srv.Close()
+			continue
+		}
+
+		var d cmds.CommandDescription
+		err = json.NewDecoder(res.Body).Decode(&d)
+		res.Body.Close()
+		srv.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var found bool
+		for _, sub := range d.Subcommands {
+			if sub.Name == "version" {
+				found = true
+				if sub.Type != "http.VersionOutput" {
+					t.Errorf("expected type http.VersionOutput, got %q", sub.Type)
+				}
+			}
+		}
+		if !found {
+			t.Error("version command missing from command tree")
+		}
+	}
+}
@@ -0,0 +1,304 @@
+package http
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	acceptEncodingHeader  = "Accept-Encoding"
+	contentEncodingHeader = "Content-Encoding"
+)
+
+// CompressWriter compresses what is written to it. Flush writes what was
+// compressed so far, so that it can be decompressed without the rest of the
+// stream.
+type CompressWriter interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// Compressor is a content coding responses can be compressed with, see
+// RegisterCompressor.
+type Compressor struct {
+	NewWriter func(w io.Writer) (CompressWriter, error)
+	NewReader func(r io.Reader) (io.ReadCloser, error)
+}
+
+var (
+	compressorsLk sync.RWMutex
+	compressors   = map[string]Compressor{
+		"gzip": {
+			NewWriter: func(w io.Writer) (CompressWriter, error) {
+				return gzip.NewWriter(w), nil
+			},
+			NewReader: func(r io.Reader) (io.ReadCloser, error) {
+				return gzip.NewReader(r)
+			},
+		},
+	}
+	// compressorNames are the names of the compressors in the order they
+	// were registered, which is the order the client asks for them.
+	compressorNames = []string{"gzip"}
+)
+
+// RegisterCompressor registers the compressor of the content coding name,
+// with which responses are compressed when the handler is configured with
+// ServerConfig.Compress, and which the client accepts and decompresses. It
+// must be called before serving or sending requests, e.g. from an init
+// function, and by both the server and the client.
+//
+// Only gzip is registered by default, as the standard library has no zstd.
+// Programs wanting zstd register it with the package of their choice, e.g.
+// with github.com/klauspost/compress/zstd:
+//
+//	cmdshttp.RegisterCompressor("zstd", cmdshttp.Compressor{
+//		NewWriter: func(w io.Writer) (cmdshttp.CompressWriter, error) {
+//			return zstd.NewWriter(w)
+//		},
+//		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+//			d, err := zstd.NewReader(r)
+//			if err != nil {
+//				return nil, err
+//			}
+//			return d.IOReadCloser(), nil
+//		},
+//	})
+func RegisterCompressor(name string, c Compressor) {
+	compressorsLk.Lock()
+	defer compressorsLk.Unlock()
+
+	name = strings.ToLower(name)
+	if _, ok := compressors[name]; !ok {
+		compressorNames = append(compressorNames, name)
+	}
+	compressors[name] = c
+}
+
+func compressor(name string) (Compressor, bool) {
+	compressorsLk.RLock()
+	defer compressorsLk.RUnlock()
+	c, ok := compressors[strings.ToLower(name)]
+	return c, ok
+}
+
+// acceptedEncodings returns the Accept-Encoding header of the client.
+func acceptedEncodings() string {
+	compressorsLk.RLock()
+	defer compressorsLk.RUnlock()
+	return strings.Join(compressorNames, ", ")
+}
+
+// negotiateCompression returns the registered content coding the request r
+// accepts with the highest quality, or the empty string if it accepts none.
+// The Accept-Encoding header is parsed like an Accept header, with the
+// codings as types.
+func negotiateCompression(r *http.Request) string {
+	ranges := parseAccept(r.Header.Get(acceptEncodingHeader))
+	refused := make(map[string]bool)
+	for _, m := range ranges {
+		if m.q == 0 {
+			refused[m.typ] = true
+		}
+	}
+	for _, m := range ranges {
+		if m.q == 0 {
+			break
+		}
+		if m.typ == "*" {
+			// any other coding, so the first registered one
+			compressorsLk.RLock()
+			defer compressorsLk.RUnlock()
+			for _, name := range compressorNames {
+				if !refused[name] {
+					return name
+				}
+			}
+			return ""
+		}
+		if _, ok := compressor(m.typ); ok {
+			return m.typ
+		}
+	}
+	return ""
+}
+
+// compressFlushInterval is the shortest time between two flushes of the
+// compressor. Every flush ends a compressed block, so flushing after each of
+// many small values would make the response larger than uncompressed;
+// flushes within the interval are coalesced into one at its end instead.
+const compressFlushInterval = 20 * time.Millisecond
+
+// compressResponseWriter compresses the response body with the content
+// coding negotiated with the client. Flushing it flushes the compressor, at
+// most once every compressFlushInterval, so streaming commands still deliver
+// values as they are emitted.
+type compressResponseWriter struct {
+	http.ResponseWriter
+	encoding string
+	c        Compressor
+
+	l           sync.Mutex
+	cw          CompressWriter
+	wroteHeader bool
+	err         error
+	lastFlush   time.Time
+	flushTimer  *time.Timer
+	finished    bool
+}
+
+// newCompressResponseWriter returns a writer compressing the response to r,
+// or nil if r doesn't accept any of the registered content codings.
+func newCompressResponseWriter(w http.ResponseWriter, r *http.Request) *compressResponseWriter {
+	if r.Method == http.MethodHead {
+		return nil
+	}
+	encoding := negotiateCompression(r)
+	if encoding == "" {
+		return nil
+	}
+	c, _ := compressor(encoding)
+	return &compressResponseWriter{ResponseWriter: w, encoding: encoding, c: c}
+}
+
+func (cw *compressResponseWriter) WriteHeader(status int) {
+	cw.l.Lock()
+	defer cw.l.Unlock()
+	cw.writeHeader(status)
+}
+
+func (cw *compressResponseWriter) writeHeader(status int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+
+	h := cw.Header()
+	h.Add(varyHeader, acceptEncodingHeader)
+	// bodiless responses and those that are already encoded are sent as
+	// they are
+	if status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified && h.Get(contentEncodingHeader) == "" {
+		// the response is sent uncompressed if the compressor fails
+		if w, err := cw.c.NewWriter(cw.ResponseWriter); err == nil {
+			cw.cw = w
+			h.Set(contentEncodingHeader, cw.encoding)
+			h.Del(contentLengthHeader)
+		}
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *compressResponseWriter) Write(p []byte) (int, error) {
+	cw.l.Lock()
+	defer cw.l.Unlock()
+
+	cw.writeHeader(http.StatusOK)
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	if cw.cw == nil {
+		return cw.ResponseWriter.Write(p)
+	}
+	return cw.cw.Write(p)
+}
+
+func (cw *compressResponseWriter) Flush() {
+	cw.l.Lock()
+	defer cw.l.Unlock()
+
+	if cw.cw == nil {
+		cw.flush()
+		return
+	}
+	if wait := compressFlushInterval - time.Since(cw.lastFlush); wait > 0 {
+		if cw.flushTimer == nil {
+			cw.flushTimer = time.AfterFunc(wait, func() {
+				cw.l.Lock()
+				defer cw.l.Unlock()
+				cw.flushTimer = nil
+				if !cw.finished {
+					cw.flush()
+				}
+			})
+		}
+		return
+	}
+	cw.flush()
+}
+
+func (cw *compressResponseWriter) flush() {
+	if cw.cw != nil && cw.err == nil {
+		cw.err = cw.cw.Flush()
+		cw.lastFlush = time.Now()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// finish writes the end of the compressed stream. It is called once the
+// response is complete.
+func (cw *compressResponseWriter) finish() {
+	cw.l.Lock()
+	defer cw.l.Unlock()
+
+	cw.finished = true
+	if cw.flushTimer != nil {
+		cw.flushTimer.Stop()
+	}
+	if cw.cw != nil && cw.err == nil {
+		cw.err = cw.cw.Close()
+	}
+}
+
+// decompressResponse makes the client read the body of res decompressed,
+// as the http.Transport does for gzip when it asks for it itself.
+func decompressResponse(res *http.Response) error {
+	encoding := res.Header.Get(contentEncodingHeader)
+	if encoding == "" || strings.EqualFold(encoding, "identity") {
+		return nil
+	}
+	c, ok := compressor(encoding)
+	if !ok {
+		res.Body.Close()
+		return fmt.Errorf("unsupported content encoding of the response: %s", encoding)
+	}
+	res.Body = &decompressBody{body: res.Body, c: c}
+	res.Header.Del(contentEncodingHeader)
+	res.Header.Del(contentLengthHeader)
+	res.ContentLength = -1
+	res.Uncompressed = true
+	return nil
+}
+
+// decompressBody decompresses a response body. The decompressor is created
+// on the first read, as it may block until the start of the stream has been
+// received.
+type decompressBody struct {
+	body io.ReadCloser
+	c    Compressor
+	r    io.ReadCloser
+	err  error
+}
+
+func (b *decompressBody) Read(p []byte) (int, error) {
+	if b.r == nil && b.err == nil {
+		b.r, b.err = b.c.NewReader(b.body)
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+	return b.r.Read(p)
+}
+
+func (b *decompressBody) Close() error {
+	if b.r != nil {
+		b.r.Close()
+	}
+	return b.body.Close()
+}
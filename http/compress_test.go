@@ -0,0 +1,179 @@
+package http
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+func init() {
+	// a coding only the tests know, to check the registry
+	RegisterCompressor("x-deflate", Compressor{
+		NewWriter: func(w io.Writer) (CompressWriter, error) {
+			return flate.NewWriter(w, flate.DefaultCompression)
+		},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			return flate.NewReader(r), nil
+		},
+	})
+}
+
+func TestNegotiateCompression(t *testing.T) {
+	for accept, exp := range map[string]string{
+		"":                          "",
+		"identity":                  "",
+		"gzip":                      "gzip",
+		"br, GZIP":                  "gzip",
+		"gzip;q=0.5, x-deflate":     "x-deflate",
+		"*":                         "gzip",
+		"gzip;q=0, *;q=0.1":         "x-deflate",
+		"gzip;q=0, x-deflate;q=0":   "",
+		"x-deflate;q=1, gzip;q=1.0": "x-deflate",
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		r.Header.Set(acceptEncodingHeader, accept)
+		if got := negotiateCompression(r); got != exp {
+			t.Errorf("%q: expected %q, got %q", accept, exp, got)
+		}
+	}
+}
+
+func TestCompress(t *testing.T) {
+	type entry struct {
+		Name string
+		Size int
+	}
+	release := make(chan struct{})
+	root := &cmds.Command{
+		Subcommands: map[string]*cmds.Command{
+			"ls": {
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+					for i := 0; i < 1000; i++ {
+						if err := re.Emit(&entry{Name: fmt.Sprintf("file-%d.txt", i), Size: i}); err != nil {
+							return err
+						}
+					}
+					return nil
+				},
+				Type: entry{},
+			},
+			"wait": {
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+					if err := re.Emit(&entry{Name: "first"}); err != nil {
+						return err
+					}
+					<-release
+					return re.Emit(&entry{Name: "second"})
+				},
+				Type: entry{},
+			},
+		},
+	}
+	cfg := originCfg(defaultOrigins)
+	cfg.Compress = true
+	srv := httptest.NewServer(NewHandler(nil, root, cfg))
+	defer srv.Close()
+
+	// the transport would decompress gzip itself
+	hc := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	get := func(accept string) (*http.Response, []byte) {
+		httpReq, err := http.NewRequest(http.MethodPost, srv.URL+"/ls?stream-channels=true", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if accept != "" {
+			httpReq.Header.Set(acceptEncodingHeader, accept)
+		}
+		httpRes, err := hc.Do(httpReq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer httpRes.Body.Close()
+		body, err := ioutil.ReadAll(httpRes.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return httpRes, body
+	}
+
+	httpRes, plain := get("")
+	if enc := httpRes.Header.Get(contentEncodingHeader); enc != "" {
+		t.Fatalf("expected an uncompressed response, got %s", enc)
+	}
+	httpRes, compressed := get("gzip")
+	if enc := httpRes.Header.Get(contentEncodingHeader); enc != "gzip" {
+		t.Fatalf("expected a gzip response, got %q", enc)
+	}
+	if vary := strings.Join(httpRes.Header.Values(varyHeader), ", "); !strings.Contains(vary, acceptEncodingHeader) {
+		t.Errorf("expected the response to vary with %s, got %q", acceptEncodingHeader, vary)
+	}
+	if len(compressed)*5 > len(plain) {
+		t.Errorf("expected the response to compress well, got %d bytes from %d", len(compressed), len(plain))
+	}
+	zr, err := gzip.NewReader(strings.NewReader(string(compressed)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decompressed, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decompressed) != string(plain) {
+		t.Error("the decompressed response differs from the uncompressed one")
+	}
+
+	// the client decompresses every registered coding, and streamed values
+	// arrive as they are emitted
+	req, err := cmds.NewRequest(context.Background(), []string{"wait"}, nil, nil, nil, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewClient(srv.URL).(*client)
+	httpReq, err := c.toHTTPRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accept := httpReq.Header.Get(acceptEncodingHeader); accept != "gzip, x-deflate" {
+		t.Errorf("unexpected %s %q", acceptEncodingHeader, accept)
+	}
+	res, err := c.send(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := make(chan interface{})
+	go func() {
+		for {
+			v, err := res.Next()
+			if err != nil {
+				close(next)
+				return
+			}
+			next <- v
+		}
+	}()
+	select {
+	case v := <-next:
+		if v.(*entry).Name != "first" {
+			t.Errorf("expected the first value, got %v", v)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the first value wasn't delivered before the command finished")
+	}
+	close(release)
+	if v := <-next; v == nil || v.(*entry).Name != "second" {
+		t.Errorf("expected the second value, got %v", v)
+	}
+	if _, ok := <-next; ok {
+		t.Error("expected the end of the response")
+	}
+}
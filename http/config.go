@@ -1,11 +1,14 @@
 package http
 
 import (
+	"crypto/ed25519"
 	"net/http"
 	"net/url"
 	"strings"
 	"sync"
+	"time"
 
+	cmds "github.com/fgeth/fg-ipfs-cmds"
 	cors "github.com/rs/cors"
 )
 
@@ -16,6 +19,10 @@ const (
 )
 
 type ServerConfig struct {
+	// Version is the version of the server. It is reported in response
+	// trailers.
+	Version string
+
 	// APIPath is the prefix of all request paths.
 	// Example: host:port/api/v0/add. Here the APIPath is /api/v0
 	APIPath string
@@ -31,6 +38,145 @@ type ServerConfig struct {
 	// websites to include resources from the API but not _read_ them.
 	AllowGet bool
 
+	// AuthTokens, when set, restricts the API to requests carrying one of
+	// these tokens as a bearer token in their Authorization header, see
+	// ClientWithAuthToken. Other requests are answered with 401
+	// Unauthorized.
+	AuthTokens []string
+
+	// TLSCertFile and TLSKeyFile are the paths of the certificate and key
+	// of the API. The handler doesn't serve TLS itself; daemons pass them
+	// to http.Server.ListenAndServeTLS.
+	TLSCertFile string
+	TLSKeyFile  string
+
+	// Compress makes the handler compress responses with the content coding
+	// the client accepts best: gzip, or those added with RegisterCompressor,
+	// e.g. zstd. Streaming responses are flushed as usual, so clients still
+	// receive every value as it is emitted.
+	Compress bool
+
+	// AllowBatch enables the batch endpoint at BatchPath. It accepts several
+	// requests in one call and streams back their multiplexed responses.
+	AllowBatch bool
+
+	// MaxBatchSize limits the number of requests in a single batch. When
+	// unset, DefaultMaxBatchSize is used.
+	MaxBatchSize int
+
+	// MaxBatchBytes limits the size of the body of a batch in bytes. When
+	// unset, DefaultMaxBatchBytes is used.
+	MaxBatchBytes int64
+
+	// ServeCommandTree enables the endpoint at CommandTreePath, which
+	// describes all commands, arguments and options of the served tree.
+	ServeCommandTree bool
+
+	// SessionFactory enables sessions. Requests that are part of a session
+	// share the environment created by the factory when the session was
+	// opened, see SessionOpenPath.
+	SessionFactory SessionFactory
+
+	// SessionTTL is the time after which idle sessions expire. When unset,
+	// DefaultSessionTTL is used.
+	SessionTTL time.Duration
+
+	// MaxSessions limits the sessions each identity, see Identify, has open
+	// at once. When unset, DefaultMaxSessions is used. Sessions can only be
+	// used by the identity that opened them.
+	MaxSessions int
+
+	// UploadDir enables resumable uploads, see ClientWithResumableUploads.
+	// Uploads are stored in this directory until the request they belong
+	// to is made, or they expire.
+	UploadDir string
+
+	// UploadTTL is the time after which idle uploads expire. When unset,
+	// DefaultUploadTTL is used.
+	UploadTTL time.Duration
+
+	// MaxUploadSize limits the size of an upload in bytes. When unset,
+	// DefaultMaxUploadSize is used.
+	MaxUploadSize int64
+
+	// MaxUploads limits the uploads each identity, see Identify, has open
+	// at once. When unset, DefaultMaxUploads is used.
+	MaxUploads int
+
+	// PayloadKey enables sealed requests at SealedPath, which are
+	// encrypted end-to-end with this pre-shared key. It must be 16, 24 or
+	// 32 bytes long, see ClientWithPayloadKey.
+	PayloadKey []byte
+
+	// RequireSealed rejects all requests that aren't sealed. It requires
+	// PayloadKey to be set.
+	RequireSealed bool
+
+	// SigningKey makes the handler sign its responses, including those to
+	// batches, so clients fetching through intermediaries can detect
+	// tampering, see ClientWithVerifyKey. The signature is sent in the
+	// SignatureHeader trailer.
+	SigningKey ed25519.PrivateKey
+
+	// Identify returns the identity of a request, which commands can
+	// retrieve with Identity. It is added to the messages of the loggers
+	// of requests, see cmds.LoggerFrom.
+	Identify IdentityFunc
+
+	// Accountant is told about the usage of every command request. It can
+	// reject requests, e.g. to enforce quotas. Requests are attributed to
+	// the identities returned by Identify.
+	Accountant Accountant
+
+	// MaxInflightWeight limits the total weight of the requests running at
+	// once, see cmds.Cost. Requests that would exceed it are answered with
+	// 429 Too Many Requests. Zero means no limit.
+	MaxInflightWeight uint64
+
+	// MaxRequestTimeout caps the timeouts requested by clients, with the
+	// timeout option or TimeoutHeader. Zero means no cap.
+	MaxRequestTimeout time.Duration
+
+	// FlowWindow enables flow control, see ClientWithFlowControl. It is the
+	// largest number of values the handler sends ahead of the client; Emit
+	// blocks once that many values haven't been consumed yet.
+	FlowWindow int
+
+	// HeartbeatInterval is the time after which idle responses of
+	// subscription commands get a heartbeat, see cmds.Command.Subscription.
+	// When unset, DefaultHeartbeatInterval is used.
+	HeartbeatInterval time.Duration
+
+	// EmitTimeout is the longest a write of the response may block, e.g.
+	// because the client is a dead peer that stopped reading. When a write
+	// stalls for longer, the context of the request is canceled so the
+	// command stops working for a client that will never read the response.
+	// The stalled write itself only returns once the connection fails. Zero
+	// means no timeout.
+	EmitTimeout time.Duration
+
+	// MaxResponseItems and MaxResponseBytes limit the number of values and
+	// bytes of the responses of all commands, see
+	// cmds.Command.MaxResponseItems. Zero means no limit.
+	MaxResponseItems uint64
+	MaxResponseBytes uint64
+
+	// StrictValidation makes the handler check the arguments and options
+	// of requests against the ones the command declares before parsing
+	// them. Requests with unknown options, values of the wrong type, or
+	// missing or extra arguments are rejected with 400 Bad Request and a
+	// ValidationError listing all their problems.
+	StrictValidation bool
+
+	// ReadOnly makes the handler refuse to run commands marked as
+	// Mutating. They are answered with 403 Forbidden and an error of type
+	// cmds.ErrForbidden.
+	ReadOnly bool
+
+	// Logger is the logger of the handler and the commands it runs. When
+	// unset, the global logger is used, see cmds.SetLogger.
+	Logger cmds.Logger
+
 	// corsOpts is a set of options for CORS headers.
 	corsOpts *cors.Options
 
@@ -0,0 +1,59 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+// FrameDecoder decodes a value of a response from its frame, the JSON
+// encoding of the value sent by the server. It replaces the decoding into
+// the type of the command, so clients can decode values into types of their
+// own, e.g. of servers whose types they don't import, or process the raw
+// frames. Errors, trailers and heartbeats are handled before.
+type FrameDecoder func(frame json.RawMessage) (interface{}, error)
+
+// wireEncoding returns the encoding the client asks servers to send the
+// values of cmd in, see cmds.Command.WireEncoding.
+func wireEncoding(cmd *cmds.Command) cmds.EncodingType {
+	if cmd == nil || cmd.WireEncoding == "" {
+		return cmds.JSON
+	}
+	return cmd.WireEncoding
+}
+
+type frameDecoderKey struct{}
+
+// ContextWithFrameDecoder returns a copy of ctx making the client decode the
+// values of the responses to requests with that context with dec. It only
+// applies to JSON responses.
+func ContextWithFrameDecoder(ctx context.Context, dec FrameDecoder) context.Context {
+	return context.WithValue(ctx, frameDecoderKey{}, dec)
+}
+
+func frameDecoder(ctx context.Context) FrameDecoder {
+	if ctx == nil {
+		return nil
+	}
+	dec, _ := ctx.Value(frameDecoderKey{}).(FrameDecoder)
+	return dec
+}
+
+// DecodeInto returns a FrameDecoder decoding values into new values of the
+// type of example. Like with the type of a command, pointers to values of
+// that type are returned.
+func DecodeInto(example interface{}) FrameDecoder {
+	typ := reflect.TypeOf(example)
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	return func(frame json.RawMessage) (interface{}, error) {
+		v := reflect.New(typ).Interface()
+		if err := json.Unmarshal(frame, v); err != nil {
+			return nil, err
+		}
+		return v, nil
+	}
+}
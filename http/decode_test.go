@@ -0,0 +1,96 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+func TestFrameDecoder(t *testing.T) {
+	type entry struct {
+		Name string
+		Size int
+	}
+	// what a client that doesn't import entry decodes into
+	type clientEntry struct {
+		Name string
+	}
+
+	root := &cmds.Command{
+		Subcommands: map[string]*cmds.Command{
+			"ls": {
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+					if err := re.Emit(&entry{Name: "a", Size: 1}); err != nil {
+						return err
+					}
+					return re.Emit(&entry{Name: "b", Size: 2})
+				},
+				Type: entry{},
+			},
+		},
+	}
+
+	srvCfg := originCfg(defaultOrigins)
+	srvCfg.AllowBatch = true
+	srv := httptest.NewServer(NewHandler(nil, root, srvCfg))
+	defer srv.Close()
+
+	raw := func(frame json.RawMessage) (interface{}, error) {
+		return string(frame), nil
+	}
+
+	type testcase struct {
+		name string
+		dec  FrameDecoder
+		exp  []interface{}
+	}
+
+	tcs := []testcase{
+		{name: "type", exp: []interface{}{&entry{Name: "a", Size: 1}, &entry{Name: "b", Size: 2}}},
+		{name: "into", dec: DecodeInto(clientEntry{}), exp: []interface{}{&clientEntry{Name: "a"}, &clientEntry{Name: "b"}}},
+		{name: "raw", dec: raw, exp: []interface{}{`{"Name":"a","Size":1}`, `{"Name":"b","Size":2}`}},
+	}
+
+	for _, tc := range tcs {
+		ctx := context.Background()
+		if tc.dec != nil {
+			ctx = ContextWithFrameDecoder(ctx, tc.dec)
+		}
+		req, err := cmds.NewRequest(ctx, []string{"ls"}, nil, nil, nil, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c := NewClient(srv.URL)
+		res, err := c.(*client).send(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		batchRes, err := c.(Batcher).Batch(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, res := range []cmds.Response{res, batchRes[0]} {
+			var values []interface{}
+			for {
+				v, err := res.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("%s: %s", tc.name, err)
+				}
+				values = append(values, v)
+			}
+			if !reflect.DeepEqual(values, tc.exp) {
+				t.Errorf("%s: expected %#v, got %#v", tc.name, tc.exp, values)
+			}
+		}
+	}
+}
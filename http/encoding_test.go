@@ -0,0 +1,355 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type encodingEntry struct {
+	Name string
+	Size int
+}
+
+type encodingCreds struct {
+	User  string
+	Token string `cmds:"redact"`
+}
+
+// lineDecoder decodes the lines of the "lines" encoding as strings.
+type lineDecoder struct {
+	r *bufio.Reader
+}
+
+func (d lineDecoder) Decode(v interface{}) error {
+	line, err := d.r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if m, ok := v.(*cmds.MaybeError); ok {
+		m.Value = strings.TrimSuffix(line, "\n")
+		return nil
+	}
+	return fmt.Errorf("cannot decode into %T", v)
+}
+
+// kvDecoder decodes the "kv" wire encoding of a command as encodingEntries.
+type kvDecoder struct {
+	s *bufio.Scanner
+}
+
+func (d kvDecoder) Decode(v interface{}) error {
+	if !d.s.Scan() {
+		if err := d.s.Err(); err != nil {
+			return err
+		}
+		return io.EOF
+	}
+	e, ok := v.(*encodingEntry)
+	if !ok {
+		return fmt.Errorf("cannot decode into %T", v)
+	}
+	_, err := fmt.Sscan(d.s.Text(), &e.Name, &e.Size)
+	return err
+}
+
+// lines is an encoding registered by the tests, once, as the MIME types of
+// registered encodings can't be unregistered.
+const lines cmds.EncodingType = "lines"
+
+func init() {
+	cmds.RegisterEncoding(lines, cmds.MakeEncoder(func(req *cmds.Request, w io.Writer, v interface{}) error {
+		_, err := fmt.Fprintf(w, "%v\n", v)
+		return err
+	}), "application/x-lines")
+	cmds.RegisterDecoder(lines, func(r io.Reader) cmds.Decoder { return lineDecoder{bufio.NewReader(r)} })
+}
+
+// TestEncodingRoundTrip sends the values a command emits through the handler
+// in every encoding, and checks the response either byte for byte or as
+// decoded by the client. The commands wait for the first value to be read
+// before emitting the others, so values must be sent as they are emitted.
+func TestEncodingRoundTrip(t *testing.T) {
+	entries := []interface{}{&encodingEntry{"a", 1}, &encodingEntry{"b", 2}}
+	creds := []interface{}{&encodingCreds{User: "bob", Token: "t0k3n"}}
+
+	for _, tc := range []struct {
+		name string
+		// the command emits values, and fails with err if it's set
+		values []interface{}
+		err    string
+		cmd    cmds.Command
+
+		query, accept string
+		contentType   string
+		// the body of the response, if it's checked byte for byte
+		body string
+		// the values decoded by the client otherwise, those emitted if nil
+		decoded []interface{}
+	}{{
+		name:        "json",
+		values:      entries,
+		cmd:         cmds.Command{Type: encodingEntry{}},
+		query:       "enc=json&stream-channels=true",
+		contentType: "application/json",
+	}, {
+		name:        "ndjson",
+		values:      entries,
+		cmd:         cmds.Command{Type: encodingEntry{}},
+		query:       "enc=ndjson",
+		contentType: "application/x-ndjson",
+	}, {
+		name:        "cbor",
+		values:      entries,
+		err:         "failed",
+		cmd:         cmds.Command{Type: encodingEntry{}},
+		query:       "enc=cbor&stream-channels=true",
+		contentType: "application/cbor",
+	}, {
+		name:        "protobuf",
+		values:      []interface{}{wrapperspb.String("a"), wrapperspb.String("b")},
+		err:         "failed",
+		cmd:         cmds.Command{Type: &wrapperspb.StringValue{}},
+		query:       "enc=protobuf&stream-channels=true",
+		contentType: "application/protobuf",
+	}, {
+		name:        "registered",
+		values:      []interface{}{"a", "b"},
+		cmd:         cmds.Command{Type: ""},
+		query:       "enc=lines",
+		contentType: "application/x-lines",
+	}, {
+		name:   "wire",
+		values: entries,
+		cmd: cmds.Command{
+			Encoders: cmds.EncoderMap{
+				"kv": cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, e *encodingEntry) error {
+					_, err := fmt.Fprintf(w, "%s %d\n", e.Name, e.Size)
+					return err
+				}),
+			},
+			Decoders: cmds.DecoderMap{
+				"kv": func(r io.Reader) cmds.Decoder { return kvDecoder{bufio.NewScanner(r)} },
+			},
+			WireEncoding: "kv",
+			Type:         encodingEntry{},
+		},
+		query:       "enc=kv",
+		contentType: "text/plain",
+	}, {
+		name:        "raw",
+		values:      []interface{}{[]byte("\x00\x01"), []byte("\x02")},
+		query:       "encoding=raw",
+		contentType: "application/octet-stream",
+		body:        "\x00\x01\x02",
+	}, {
+		name:        "raw accepted",
+		values:      []interface{}{[]byte("\x00\x01"), []byte("\x02")},
+		accept:      "application/octet-stream",
+		contentType: "application/octet-stream",
+		body:        "\x00\x01\x02",
+	}, {
+		name:        "raw reader",
+		values:      []interface{}{strings.NewReader("<html>")},
+		query:       "encoding=raw",
+		contentType: "application/octet-stream",
+		body:        "<html>",
+	}, {
+		// readers are still sent as text otherwise
+		name:        "text reader",
+		values:      []interface{}{strings.NewReader("<html>")},
+		contentType: "text/plain",
+		body:        "<html>",
+	}, {
+		name:        "redacted",
+		values:      creds,
+		cmd:         cmds.Command{Type: encodingCreds{}},
+		contentType: "application/json",
+		decoded:     []interface{}{&encodingCreds{User: "bob", Token: cmds.Redacted}},
+	}, {
+		name:        "secrets",
+		values:      creds,
+		cmd:         cmds.Command{Type: encodingCreds{}},
+		query:       "show-secrets=true",
+		contentType: "application/json",
+	}, {
+		name:        "stream array",
+		values:      entries,
+		cmd:         cmds.Command{Type: encodingEntry{}},
+		query:       "encoding=json&stream-channels=true&stream-array=true",
+		contentType: "application/json",
+		body:        "[\n" + `{"Name":"a","Size":1},` + "\n" + `{"Name":"b","Size":2}` + "\n]\n",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			release := make(chan struct{})
+			cmd := tc.cmd
+			cmd.Run = func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+				for i, v := range tc.values {
+					if i == 1 {
+						<-release
+					}
+					if err := re.Emit(v); err != nil {
+						return err
+					}
+				}
+				if tc.err != "" {
+					return cmds.Errorf(cmds.ErrClient, tc.err)
+				}
+				return nil
+			}
+			root := &cmds.Command{
+				Options: []cmds.Option{
+					cmds.OptionEncodingType,
+					cmds.OptionStreamChannels,
+					cmds.OptionShowSecrets,
+					cmds.OptionStreamArray,
+				},
+				Subcommands: map[string]*cmds.Command{"run": &cmd},
+			}
+			srv := httptest.NewServer(NewHandler(nil, root, originCfg(defaultOrigins)))
+			defer srv.Close()
+
+			httpReq, err := http.NewRequest("POST", srv.URL+"/run?"+tc.query, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.accept != "" {
+				httpReq.Header.Set("Accept", tc.accept)
+			}
+			httpReq.Header.Set("Origin", "http://localhost")
+			httpRes, err := http.DefaultClient.Do(httpReq)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer httpRes.Body.Close()
+			if ct := httpRes.Header.Get(contentTypeHeader); ct != tc.contentType {
+				t.Errorf("expected content type %q, got %q", tc.contentType, ct)
+			}
+
+			if tc.body != "" {
+				close(release)
+				body, err := ioutil.ReadAll(httpRes.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(body) != tc.body {
+					t.Errorf("expected body %q, got %q", tc.body, body)
+				}
+				return
+			}
+
+			req, err := cmds.NewRequest(context.Background(), []string{"run"}, nil, nil, nil, root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := parseResponse(httpRes, req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded := tc.decoded
+			if decoded == nil {
+				decoded = tc.values
+			}
+			for i, exp := range decoded {
+				v, err := res.Next()
+				if err != nil {
+					t.Fatalf("expected %v, got %v", exp, err)
+				}
+				if !sameValue(v, exp) {
+					t.Errorf("expected %v, got %v", exp, v)
+				}
+				if i == 0 {
+					close(release)
+				}
+			}
+			// errors after values are sent in the trailer
+			if _, err := res.Next(); tc.err == "" && err != io.EOF {
+				t.Errorf("expected EOF, got %v", err)
+			} else if tc.err != "" && (err == nil || err == io.EOF || err.Error() != tc.err) {
+				t.Errorf("expected the error of the command, got %v", err)
+			}
+		})
+	}
+}
+
+func sameValue(v, exp interface{}) bool {
+	if m, ok := exp.(proto.Message); ok {
+		vm, ok := v.(proto.Message)
+		return ok && proto.Equal(vm, m)
+	}
+	return reflect.DeepEqual(v, exp)
+}
+
+// TestClientSideOptionsNotSent checks the client sends the options the server
+// needs, but not those it applies to the decoded values itself.
+func TestClientSideOptionsNotSent(t *testing.T) {
+	root := &cmds.Command{
+		Options: []cmds.Option{
+			cmds.OptionShowSecrets,
+			cmds.OptionStreamArray,
+			cmds.OptionEncodingPretty,
+			cmds.OptionEncodingCanonical,
+			cmds.OptionSelect,
+		},
+		Subcommands: map[string]*cmds.Command{"creds": {}},
+	}
+	req, err := cmds.NewRequest(context.Background(), []string{"creds"}, cmds.OptMap{
+		cmds.SecretsOpt:   true,
+		cmds.ArrayOpt:     true,
+		cmds.EncPretty:    true,
+		cmds.EncCanonical: true,
+		cmds.SelectOpt:    ".User",
+	}, nil, nil, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpReq, err := NewClient("http://localhost:5001").(*client).toHTTPRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := httpReq.URL.Query()
+	// the server masks secrets otherwise
+	if query.Get(cmds.SecretsOpt) != "true" {
+		t.Errorf("the client didn't send %s", cmds.SecretsOpt)
+	}
+	for name := range cmds.ClientSideOptions {
+		if _, ok := query[name]; ok {
+			t.Errorf("the client sent %s", name)
+		}
+	}
+}
+
+func TestUndecodableWireEncoding(t *testing.T) {
+	root := &cmds.Command{
+		Subcommands: map[string]*cmds.Command{
+			"undecodable": {
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+					return nil
+				},
+				WireEncoding: cmds.Text,
+				Type:         encodingEntry{},
+			},
+		},
+	}
+	srv := httptest.NewServer(NewHandler(nil, root, originCfg(defaultOrigins)))
+	defer srv.Close()
+
+	req, err := cmds.NewRequest(context.Background(), []string{"undecodable"}, nil, nil, nil, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewClient(srv.URL).(*client).send(req); err == nil {
+		t.Error("expected an error for a wire encoding without a decoder")
+	}
+}
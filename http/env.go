@@ -0,0 +1,288 @@
+package http
+
+import (
+	"bufio"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The variables read by ServerConfigFromEnv, after the prefix passed to it.
+// Lists are separated by commas, and unset or empty variables leave the
+// defaults of NewServerConfig.
+const (
+	// APIPathEnv sets ServerConfig.APIPath, e.g. /api/v0.
+	APIPathEnv = "API_PATH"
+	// AllowedOriginsEnv sets the CORS origins allowed, e.g.
+	// https://webui.example.com,http://localhost:3000, or * for all.
+	AllowedOriginsEnv = "ALLOWED_ORIGINS"
+	// AllowedMethodsEnv sets the CORS methods allowed, e.g. GET,POST.
+	AllowedMethodsEnv = "ALLOWED_METHODS"
+	// AllowCredentialsEnv allows CORS requests with credentials.
+	AllowCredentialsEnv = "ALLOW_CREDENTIALS"
+	// AllowGetEnv sets ServerConfig.AllowGet.
+	AllowGetEnv = "ALLOW_GET"
+	// AllowBatchEnv sets ServerConfig.AllowBatch.
+	AllowBatchEnv = "ALLOW_BATCH"
+	// ServeCommandTreeEnv sets ServerConfig.ServeCommandTree.
+	ServeCommandTreeEnv = "SERVE_COMMAND_TREE"
+	// ReadOnlyEnv sets ServerConfig.ReadOnly.
+	ReadOnlyEnv = "READ_ONLY"
+	// StrictValidationEnv sets ServerConfig.StrictValidation.
+	StrictValidationEnv = "STRICT_VALIDATION"
+	// CompressEnv sets ServerConfig.Compress.
+	CompressEnv = "COMPRESS"
+	// AuthTokensEnv sets ServerConfig.AuthTokens.
+	AuthTokensEnv = "AUTH_TOKENS"
+	// AuthTokensFileEnv is the path of a file with one auth token per
+	// line, e.g. a mounted secret. Its tokens are added to those of
+	// AuthTokensEnv. Empty lines and lines starting with # are skipped.
+	AuthTokensFileEnv = "AUTH_TOKENS_FILE"
+	// MaxBatchSizeEnv sets ServerConfig.MaxBatchSize.
+	MaxBatchSizeEnv = "MAX_BATCH_SIZE"
+	// MaxInflightWeightEnv sets ServerConfig.MaxInflightWeight.
+	MaxInflightWeightEnv = "MAX_INFLIGHT_WEIGHT"
+	// MaxRequestTimeoutEnv sets ServerConfig.MaxRequestTimeout, e.g. 5m.
+	MaxRequestTimeoutEnv = "MAX_REQUEST_TIMEOUT"
+	// MaxResponseItemsEnv sets ServerConfig.MaxResponseItems.
+	MaxResponseItemsEnv = "MAX_RESPONSE_ITEMS"
+	// MaxResponseBytesEnv sets ServerConfig.MaxResponseBytes.
+	MaxResponseBytesEnv = "MAX_RESPONSE_BYTES"
+	// EmitTimeoutEnv sets ServerConfig.EmitTimeout, e.g. 30s.
+	EmitTimeoutEnv = "EMIT_TIMEOUT"
+	// TLSCertFileEnv and TLSKeyFileEnv set ServerConfig.TLSCertFile and
+	// ServerConfig.TLSKeyFile. They must be set together.
+	TLSCertFileEnv = "TLS_CERT_FILE"
+	TLSKeyFileEnv  = "TLS_KEY_FILE"
+)
+
+// EnvError is the error of ServerConfigFromEnv. It lists all problems of
+// the variables read, with the Field of each being the full name of the
+// variable.
+type EnvError struct {
+	Vars []FieldError
+}
+
+func (e *EnvError) Error() string {
+	msgs := make([]string, len(e.Vars))
+	for i, v := range e.Vars {
+		msgs[i] = v.Field + ": " + v.Message
+	}
+	return "invalid server config: " + strings.Join(msgs, "; ")
+}
+
+// envReader reads the variables with a prefix, collecting their problems.
+type envReader struct {
+	prefix string
+	err    EnvError
+}
+
+func (e *envReader) lookup(name string) (string, string, bool) {
+	name = e.prefix + name
+	v := strings.TrimSpace(os.Getenv(name))
+	return name, v, v != ""
+}
+
+func (e *envReader) fail(name, msg string) {
+	e.err.Vars = append(e.err.Vars, FieldError{Field: name, Message: msg})
+}
+
+func (e *envReader) string(name string, dst *string) {
+	if _, v, ok := e.lookup(name); ok {
+		*dst = v
+	}
+}
+
+func (e *envReader) list(name string) []string {
+	_, v, ok := e.lookup(name)
+	if !ok {
+		return nil
+	}
+	var l []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			l = append(l, s)
+		}
+	}
+	return l
+}
+
+func (e *envReader) bool(name string, dst *bool) {
+	name, v, ok := e.lookup(name)
+	if !ok {
+		return
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		e.fail(name, "expected a boolean, got "+strconv.Quote(v))
+		return
+	}
+	*dst = b
+}
+
+func (e *envReader) int(name string, dst *int) {
+	name, v, ok := e.lookup(name)
+	if !ok {
+		return
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		e.fail(name, "expected a non-negative integer, got "+strconv.Quote(v))
+		return
+	}
+	*dst = n
+}
+
+func (e *envReader) uint(name string, dst *uint64) {
+	name, v, ok := e.lookup(name)
+	if !ok {
+		return
+	}
+	n, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		e.fail(name, "expected a non-negative integer, got "+strconv.Quote(v))
+		return
+	}
+	*dst = n
+}
+
+func (e *envReader) duration(name string, dst *time.Duration) {
+	name, v, ok := e.lookup(name)
+	if !ok {
+		return
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		e.fail(name, "expected a non-negative duration such as 30s, got "+strconv.Quote(v))
+		return
+	}
+	*dst = d
+}
+
+// file reads the path of an existing regular file.
+func (e *envReader) file(name string, dst *string) {
+	name, v, ok := e.lookup(name)
+	if !ok {
+		return
+	}
+	if fi, err := os.Stat(v); err != nil {
+		e.fail(name, err.Error())
+		return
+	} else if !fi.Mode().IsRegular() {
+		e.fail(name, v+" is not a regular file")
+		return
+	}
+	*dst = v
+}
+
+// ServerConfigFromEnv returns a new ServerConfig configured by the
+// environment variables whose names are prefix followed by an underscore
+// and one of the ...Env constants, e.g. MYAPP_ALLOWED_ORIGINS for the prefix
+// MYAPP. It lets deployments, e.g. in containers, configure the API without
+// code or config files. Settings that can't be expressed in variables, such
+// as SessionFactory or Identify, can be set on the returned config.
+//
+// All variables are read and validated before returning, and the problems
+// of invalid ones are reported together in an *EnvError.
+func ServerConfigFromEnv(prefix string) (*ServerConfig, error) {
+	if prefix != "" && !strings.HasSuffix(prefix, "_") {
+		prefix += "_"
+	}
+	e := &envReader{prefix: prefix}
+	cfg := NewServerConfig()
+
+	e.string(APIPathEnv, &cfg.APIPath)
+	if cfg.APIPath != "" {
+		if !strings.HasPrefix(cfg.APIPath, "/") {
+			e.fail(prefix+APIPathEnv, "must start with /")
+		}
+		cfg.APIPath = strings.TrimSuffix(cfg.APIPath, "/")
+	}
+
+	if origins := e.list(AllowedOriginsEnv); origins != nil {
+		for _, o := range origins {
+			if o == "*" {
+				continue
+			}
+			if u, err := url.Parse(o); err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" || u.RawQuery != "" {
+				e.fail(prefix+AllowedOriginsEnv, "expected * or origins such as https://example.com, got "+strconv.Quote(o))
+			}
+		}
+		cfg.SetAllowedOrigins(origins...)
+	}
+	if methods := e.list(AllowedMethodsEnv); methods != nil {
+		for i, m := range methods {
+			methods[i] = strings.ToUpper(m)
+			if strings.ContainsAny(m, " \t/") {
+				e.fail(prefix+AllowedMethodsEnv, "invalid method "+strconv.Quote(m))
+			}
+		}
+		cfg.SetAllowedMethods(methods...)
+	}
+	var allowCredentials bool
+	e.bool(AllowCredentialsEnv, &allowCredentials)
+	cfg.SetAllowCredentials(allowCredentials)
+
+	e.bool(AllowGetEnv, &cfg.AllowGet)
+	e.bool(AllowBatchEnv, &cfg.AllowBatch)
+	e.bool(ServeCommandTreeEnv, &cfg.ServeCommandTree)
+	e.bool(ReadOnlyEnv, &cfg.ReadOnly)
+	e.bool(StrictValidationEnv, &cfg.StrictValidation)
+	e.bool(CompressEnv, &cfg.Compress)
+
+	cfg.AuthTokens = e.list(AuthTokensEnv)
+	if name, path, ok := e.lookup(AuthTokensFileEnv); ok {
+		tokens, err := readTokens(path)
+		if err != nil {
+			e.fail(name, err.Error())
+		} else if len(tokens) == 0 {
+			// an empty file would leave the API open by mistake
+			e.fail(name, path+" has no tokens")
+		}
+		cfg.AuthTokens = append(cfg.AuthTokens, tokens...)
+	}
+
+	e.int(MaxBatchSizeEnv, &cfg.MaxBatchSize)
+	e.uint(MaxInflightWeightEnv, &cfg.MaxInflightWeight)
+	e.duration(MaxRequestTimeoutEnv, &cfg.MaxRequestTimeout)
+	e.uint(MaxResponseItemsEnv, &cfg.MaxResponseItems)
+	e.uint(MaxResponseBytesEnv, &cfg.MaxResponseBytes)
+	e.duration(EmitTimeoutEnv, &cfg.EmitTimeout)
+
+	e.file(TLSCertFileEnv, &cfg.TLSCertFile)
+	e.file(TLSKeyFileEnv, &cfg.TLSKeyFile)
+	certName, _, hasCert := e.lookup(TLSCertFileEnv)
+	keyName, _, hasKey := e.lookup(TLSKeyFileEnv)
+	if hasCert && !hasKey {
+		e.fail(keyName, "must be set along with "+certName)
+	} else if hasKey && !hasCert {
+		e.fail(certName, "must be set along with "+keyName)
+	}
+
+	if len(e.err.Vars) > 0 {
+		return nil, &e.err
+	}
+	return cfg, nil
+}
+
+// readTokens reads the auth tokens of a file, one per line.
+func readTokens(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var tokens []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		tokens = append(tokens, line)
+	}
+	return tokens, s.Err()
+}
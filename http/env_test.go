@@ -0,0 +1,120 @@
+package http
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestServerConfigFromEnv(t *testing.T) {
+	dir := t.TempDir()
+	tokens := filepath.Join(dir, "tokens")
+	if err := os.WriteFile(tokens, []byte("# tokens of the API\nt2\n\nt3\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cert := filepath.Join(dir, "cert.pem")
+	key := filepath.Join(dir, "key.pem")
+	for _, f := range []string{cert, key} {
+		if err := os.WriteFile(f, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, v := range map[string]string{
+		"API_PATH":            "/api/v0/",
+		"ALLOWED_ORIGINS":     "https://example.com, http://localhost:3000",
+		"ALLOWED_METHODS":     "get,post",
+		"ALLOW_GET":           "true",
+		"READ_ONLY":           "1",
+		"COMPRESS":            "true",
+		"AUTH_TOKENS":         "t1",
+		"AUTH_TOKENS_FILE":    tokens,
+		"MAX_BATCH_SIZE":      "10",
+		"MAX_REQUEST_TIMEOUT": "5m",
+		"MAX_RESPONSE_BYTES":  "1048576",
+		"TLS_CERT_FILE":       cert,
+		"TLS_KEY_FILE":        key,
+		// other prefixes are ignored
+		"STRICT_VALIDATION": "true",
+	} {
+		if name != "STRICT_VALIDATION" {
+			name = "TEST_API_" + name
+		}
+		t.Setenv(name, v)
+	}
+
+	cfg, err := ServerConfigFromEnv("TEST_API")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.APIPath != "/api/v0" {
+		t.Errorf("expected the API path /api/v0, got %s", cfg.APIPath)
+	}
+	if exp := []string{"https://example.com", "http://localhost:3000"}; !reflect.DeepEqual(cfg.AllowedOrigins(), exp) {
+		t.Errorf("expected the origins %v, got %v", exp, cfg.AllowedOrigins())
+	}
+	if exp := []string{"GET", "POST"}; !reflect.DeepEqual(cfg.AllowedMethods(), exp) {
+		t.Errorf("expected the methods %v, got %v", exp, cfg.AllowedMethods())
+	}
+	if exp := []string{"t1", "t2", "t3"}; !reflect.DeepEqual(cfg.AuthTokens, exp) {
+		t.Errorf("expected the tokens %v, got %v", exp, cfg.AuthTokens)
+	}
+	if !cfg.AllowGet || !cfg.ReadOnly || !cfg.Compress || cfg.StrictValidation || cfg.AllowBatch {
+		t.Errorf("wrong flags: AllowGet=%t ReadOnly=%t StrictValidation=%t AllowBatch=%t", cfg.AllowGet, cfg.ReadOnly, cfg.StrictValidation, cfg.AllowBatch)
+	}
+	if cfg.MaxBatchSize != 10 || cfg.MaxRequestTimeout != 5*time.Minute || cfg.MaxResponseBytes != 1<<20 || cfg.MaxResponseItems != 0 {
+		t.Errorf("wrong limits: %d %s %d %d", cfg.MaxBatchSize, cfg.MaxRequestTimeout, cfg.MaxResponseBytes, cfg.MaxResponseItems)
+	}
+	if cfg.TLSCertFile != cert || cfg.TLSKeyFile != key {
+		t.Errorf("wrong TLS paths: %s %s", cfg.TLSCertFile, cfg.TLSKeyFile)
+	}
+
+	// all problems are reported together
+	for name, v := range map[string]string{
+		"TEST_API_API_PATH":        "api",
+		"TEST_API_ALLOWED_ORIGINS": "example.com",
+		"TEST_API_ALLOW_GET":       "maybe",
+		"TEST_API_MAX_BATCH_SIZE":  "-1",
+		"TEST_API_EMIT_TIMEOUT":    "10",
+		"TEST_API_TLS_KEY_FILE":    "",
+		"TEST_API_TLS_CERT_FILE":   filepath.Join(dir, "missing.pem"),
+	} {
+		t.Setenv(name, v)
+	}
+	_, err = ServerConfigFromEnv("TEST_API_")
+	var envErr *EnvError
+	if !errors.As(err, &envErr) {
+		t.Fatalf("expected an EnvError, got %v", err)
+	}
+	var vars []string
+	for _, f := range envErr.Vars {
+		vars = append(vars, f.Field)
+	}
+	exp := []string{
+		"TEST_API_API_PATH",
+		"TEST_API_ALLOWED_ORIGINS",
+		"TEST_API_ALLOW_GET",
+		"TEST_API_MAX_BATCH_SIZE",
+		"TEST_API_EMIT_TIMEOUT",
+		"TEST_API_TLS_CERT_FILE",
+		"TEST_API_TLS_KEY_FILE",
+	}
+	if !reflect.DeepEqual(vars, exp) {
+		t.Errorf("expected problems with %v, got %s", exp, err)
+	}
+
+	// a tokens file without tokens would leave the API open
+	for _, name := range exp {
+		t.Setenv(name, "")
+	}
+	if err := os.WriteFile(tokens, []byte("# no tokens yet\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err = ServerConfigFromEnv("TEST_API")
+	if !errors.As(err, &envErr) || len(envErr.Vars) != 1 || envErr.Vars[0].Field != "TEST_API_AUTH_TOKENS_FILE" {
+		t.Errorf("expected an error about the empty tokens file, got %v", err)
+	}
+}
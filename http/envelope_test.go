@@ -0,0 +1,160 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+func TestEnvelope(t *testing.T) {
+	env, _ := getTestServer(t, nil, false)
+
+	srvCfg := originCfg(defaultOrigins)
+	srvCfg.Version = "0.1.2"
+	srv := httptest.NewServer(NewHandler(env, cmdRoot, srvCfg))
+	defer srv.Close()
+
+	for _, envelope := range []bool{true, false} {
+		req, err := cmds.NewRequest(context.Background(), []string{"version"},
+			cmds.OptMap{cmds.EnvelopeOpt: envelope}, nil, nil, cmdRoot)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res, err := NewClient(srv.URL).(*client).send(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := res.Next(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := res.Next(); err != io.EOF {
+			t.Fatal("expected EOF, got:", err)
+		}
+
+		tr := cmds.GetTrailer(res)
+		if !envelope {
+			if tr != nil {
+				t.Errorf("expected no trailer, got %+v", tr)
+			}
+			continue
+		}
+
+		if tr == nil {
+			t.Fatal("expected a trailer")
+		}
+		if tr.Count != 1 {
+			t.Errorf("expected count 1, got %d", tr.Count)
+		}
+		if tr.ServerVersion != "0.1.2" {
+			t.Errorf("expected server version %q, got %q", "0.1.2", tr.ServerVersion)
+		}
+		if tr.RequestID == "" {
+			t.Error("expected a request id")
+		}
+		if tr.End.Before(tr.Start) {
+			t.Errorf("end %s before start %s", tr.End, tr.Start)
+		}
+	}
+}
+
+func TestExitStatus(t *testing.T) {
+	root := &cmds.Command{
+		Subcommands: map[string]*cmds.Command{
+			"check": {
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+					if err := re.Emit("bad"); err != nil {
+						return err
+					}
+					cmds.SetStatus(re, 3)
+					return nil
+				},
+				Type: "",
+			},
+		},
+	}
+	srv := httptest.NewServer(NewHandler(nil, root, originCfg(defaultOrigins)))
+	defer srv.Close()
+
+	for _, envelope := range []bool{false, true} {
+		req, err := cmds.NewRequest(context.Background(), []string{"check"},
+			cmds.OptMap{cmds.EnvelopeOpt: envelope}, nil, nil, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		re, res := cmds.NewChanResponsePair(req)
+		go func() {
+			if err := NewClient(srv.URL).Execute(req, re, nil); err != nil {
+				re.CloseWithError(err)
+			}
+		}()
+		for {
+			_, err := res.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if tr := cmds.GetTrailer(res); tr == nil || tr.ExitStatus != 3 {
+			t.Errorf("envelope %t: expected exit status 3, got trailer %+v", envelope, tr)
+		}
+	}
+}
+
+func TestEnvelopeStreamArray(t *testing.T) {
+	type entry struct{ Name string }
+	root := &cmds.Command{
+		Options: []cmds.Option{cmds.OptionStreamArray, cmds.OptionEnvelope},
+		Subcommands: map[string]*cmds.Command{
+			"ls": {
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+					if err := re.Emit(&entry{"a"}); err != nil {
+						return err
+					}
+					return re.Emit(&entry{"b"})
+				},
+				Type: entry{},
+			},
+		},
+	}
+	srv := httptest.NewServer(NewHandler(nil, root, originCfg(defaultOrigins)))
+	defer srv.Close()
+
+	httpRes, err := http.Post(srv.URL+"/ls?encoding=json&stream-channels=true&stream-array=true&envelope=true", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(httpRes.Body)
+	httpRes.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var elems []map[string]interface{}
+	if err := json.Unmarshal(body, &elems); err != nil {
+		t.Fatalf("the response isn't a JSON array: %s\n%s", err, body)
+	}
+	// the trailer is the last element
+	var kinds []string
+	for _, e := range elems {
+		kind, _ := e["Type"].(string)
+		if name, ok := e["Name"].(string); ok {
+			kind = name
+		}
+		kinds = append(kinds, kind)
+	}
+	if exp := "a,b,trailer"; strings.Join(kinds, ",") != exp {
+		t.Errorf("expected the elements %s, got %s", exp, body)
+	}
+}
@@ -0,0 +1,111 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"sync"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+var (
+	errorStatusesLk sync.RWMutex
+	codeStatuses    = make(map[cmds.ErrorType]int)
+	typeStatuses    = make(map[reflect.Type]int)
+)
+
+// RegisterErrorCode makes handlers reply with status to errors of type code,
+// instead of the default status of the type. Like all error statuses, it is
+// only used if the error occurs before the response started; later errors
+// are sent in the trailer.
+func RegisterErrorCode(code cmds.ErrorType, status int) {
+	errorStatusesLk.Lock()
+	defer errorStatusesLk.Unlock()
+	codeStatuses[code] = status
+}
+
+// RegisterErrorType makes handlers reply with status to errors of the type
+// of example, or wrapping one. It takes precedence over the status of the
+// error code.
+func RegisterErrorType(example error, status int) {
+	errorStatusesLk.Lock()
+	defer errorStatusesLk.Unlock()
+	typeStatuses[reflect.TypeOf(example)] = status
+}
+
+// statusOf returns the HTTP status of err, looking up the registered
+// statuses first. Errors without a type are of type def.
+func statusOf(err error, def cmds.ErrorType) int {
+	errorStatusesLk.RLock()
+	defer errorStatusesLk.RUnlock()
+
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		if status, ok := typeStatuses[reflect.TypeOf(e)]; ok {
+			return status
+		}
+	}
+
+	code := errorType(err, def)
+	if status, ok := codeStatuses[code]; ok {
+		return status
+	}
+	return errorStatus(code)
+}
+
+// errorStatus returns the default HTTP status of errors of type code.
+func errorStatus(code cmds.ErrorType) int {
+	switch code {
+	case cmds.ErrClient:
+		return http.StatusBadRequest
+	case cmds.ErrNotFound:
+		return http.StatusNotFound
+	case cmds.ErrForbidden:
+		return http.StatusForbidden
+	case cmds.ErrRateLimited:
+		return http.StatusTooManyRequests
+	case cmds.ErrTimeout:
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// statusErrorType is the inverse of errorStatus, for errors that were sent
+// without a code.
+func statusErrorType(status int) cmds.ErrorType {
+	switch status {
+	case http.StatusBadRequest:
+		return cmds.ErrClient
+	case http.StatusNotFound:
+		return cmds.ErrNotFound
+	case http.StatusForbidden:
+		return cmds.ErrForbidden
+	case http.StatusTooManyRequests:
+		return cmds.ErrRateLimited
+	case http.StatusGatewayTimeout:
+		return cmds.ErrTimeout
+	default:
+		return cmds.ErrNormal
+	}
+}
+
+// errorType returns the type of err, or def if it has none.
+func errorType(err error, def cmds.ErrorType) cmds.ErrorType {
+	switch e := err.(type) {
+	case *cmds.Error:
+		return e.Code
+	case cmds.Error:
+		return e.Code
+	case *cmds.ValidationError:
+		return cmds.ErrClient
+	default:
+		return def
+	}
+}
+
+// writeError replies to the request with err as plain text, with the status
+// of err. Errors without a type are of type def.
+func writeError(w http.ResponseWriter, err error, def cmds.ErrorType) {
+	http.Error(w, err.Error(), statusOf(err, def))
+}
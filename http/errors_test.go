@@ -3,8 +3,11 @@ package http
 import (
 	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"reflect"
 	"runtime"
 	"strings"
 	"testing"
@@ -43,8 +46,8 @@ func TestErrors(t *testing.T) {
 		{
 			path:       []string{"lateerror"},
 			status:     "200 OK",
-			bodyStr:    `"some value"` + "\n",
-			errTrailer: "an error occurred",
+			bodyStr:    `"some value"` + "\n" + `{"Message":"an error occurred","Code":0,"Type":"error"}` + "\n",
+			errTrailer: `{"Message":"an error occurred","Code":0,"Type":"error"}`,
 		},
 
 		{
@@ -63,7 +66,7 @@ func TestErrors(t *testing.T) {
 			},
 			status:     "200 OK",
 			bodyStr:    "hello\n",
-			errTrailer: "an error occurred",
+			errTrailer: `{"Message":"an error occurred","Code":0,"Type":"error"}`,
 		},
 
 		{
@@ -82,7 +85,7 @@ func TestErrors(t *testing.T) {
 			},
 			status:     "200 OK",
 			bodyStr:    "hello\n",
-			errTrailer: "an error occurred",
+			errTrailer: `{"Message":"an error occurred","Code":0,"Type":"error"}`,
 		},
 
 		{
@@ -218,3 +221,234 @@ func TestDisallowedUserAgents(t *testing.T) {
 		tc.test(t)
 	}
 }
+
+func TestErrorTypes(t *testing.T) {
+	root := &cmds.Command{
+		Subcommands: map[string]*cmds.Command{
+			"fail": {
+				Options: []cmds.Option{cmds.IntOption("n", "a number")},
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+					return fmt.Errorf("failed")
+				},
+			},
+			"reject": {
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+					return cmds.ClientError("rejected")
+				},
+			},
+		},
+	}
+
+	srv := httptest.NewServer(NewHandler(nil, root, originCfg(defaultOrigins)))
+	defer srv.Close()
+
+	type testcase struct {
+		path   string
+		status int
+		code   cmds.ErrorType
+	}
+
+	tcs := []testcase{
+		{path: "fail", status: http.StatusInternalServerError, code: cmds.ErrNormal},
+		{path: "reject", status: http.StatusBadRequest, code: cmds.ErrClient},
+		{path: "fail?n=abc", status: http.StatusBadRequest, code: cmds.ErrClient},
+		{path: "missing", status: http.StatusNotFound, code: cmds.ErrNotFound},
+	}
+
+	for _, tc := range tcs {
+		httpRes, err := http.Post(srv.URL+"/"+tc.path, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if httpRes.StatusCode != tc.status {
+			t.Errorf("%s: expected status %d, got %d", tc.path, tc.status, httpRes.StatusCode)
+		}
+
+		_, err = parseResponse(httpRes, nil)
+		httpRes.Body.Close()
+		e, ok := err.(*cmds.Error)
+		if !ok || e.Code != tc.code {
+			t.Errorf("%s: expected error code %s, got %#v", tc.path, tc.code, err)
+		}
+	}
+}
+
+type conflictError struct{}
+
+func (conflictError) Error() string { return "conflict" }
+
+func TestErrorStatusRegistry(t *testing.T) {
+	const errInvalidEntity cmds.ErrorType = 100
+
+	RegisterErrorType(conflictError{}, http.StatusConflict)
+	RegisterErrorCode(errInvalidEntity, http.StatusUnprocessableEntity)
+
+	root := &cmds.Command{
+		Subcommands: map[string]*cmds.Command{
+			"conflict": {
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+					return conflictError{}
+				},
+			},
+			"wrapped": {
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+					return fmt.Errorf("while updating: %w", conflictError{})
+				},
+			},
+			"invalid": {
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+					return cmds.Errorf(errInvalidEntity, "invalid entity")
+				},
+			},
+			"late": {
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+					if err := re.Emit("value"); err != nil {
+						return err
+					}
+					return conflictError{}
+				},
+				Type: "",
+			},
+		},
+	}
+
+	srv := httptest.NewServer(NewHandler(nil, root, originCfg(defaultOrigins)))
+	defer srv.Close()
+
+	for path, status := range map[string]int{
+		"conflict": http.StatusConflict,
+		"wrapped":  http.StatusConflict,
+		"invalid":  http.StatusUnprocessableEntity,
+		// the head was sent before the error
+		"late": http.StatusOK,
+	} {
+		httpRes, err := http.Post(srv.URL+"/"+path, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.ReadAll(httpRes.Body)
+		httpRes.Body.Close()
+		if httpRes.StatusCode != status {
+			t.Errorf("%s: expected status %d, got %d", path, status, httpRes.StatusCode)
+		}
+	}
+}
+
+func TestStreamErrorTrailer(t *testing.T) {
+	lateErr := cmds.Error{
+		Message: "quota exceeded",
+		Code:    cmds.ErrRateLimited,
+		Details: map[string]interface{}{"limit": float64(10)},
+	}
+
+	root := &cmds.Command{
+		Subcommands: map[string]*cmds.Command{
+			"values": {
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+					if err := re.Emit("value"); err != nil {
+						return err
+					}
+					return lateErr
+				},
+				Type: "",
+			},
+			"reader": {
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+					if err := re.Emit(strings.NewReader("data")); err != nil {
+						return err
+					}
+					return lateErr
+				},
+			},
+		},
+	}
+
+	srv := httptest.NewServer(NewHandler(nil, root, originCfg(defaultOrigins)))
+	defer srv.Close()
+
+	for _, path := range []string{"values", "reader"} {
+		req, err := cmds.NewRequest(context.Background(), []string{path}, nil, nil, nil, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := NewClient(srv.URL).(*client).send(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for {
+			var v interface{}
+			v, err = res.Next()
+			if err != nil {
+				break
+			}
+			if r, ok := v.(io.Reader); ok {
+				if _, err = ioutil.ReadAll(r); err != nil {
+					break
+				}
+			}
+		}
+
+		if e, ok := err.(*cmds.Error); !ok || !reflect.DeepEqual(*e, lateErr) {
+			t.Errorf("%s: expected error %#v, got %#v", path, lateErr, err)
+		}
+	}
+
+	// clients of version 1 of the protocol get the message and the code in
+	// separate trailers, and no error in the stream
+	httpRes, err := http.Post(srv.URL+"/values", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(httpRes.Body)
+	httpRes.Body.Close()
+	if string(body) != `"value"`+"\n" {
+		t.Errorf("expected just the value, got %q", body)
+	}
+	if msg := httpRes.Trailer.Get(StreamErrHeader); msg != lateErr.Message {
+		t.Errorf("expected error message %q, got %q", lateErr.Message, msg)
+	}
+	e, ok := decodeStreamError(httpRes.Trailer).(*cmds.Error)
+	if !ok || e.Message != lateErr.Message || e.Code != lateErr.Code {
+		t.Errorf("expected error %q with code %d, got %#v", lateErr.Message, lateErr.Code, e)
+	}
+}
+
+func TestErrorHelpURL(t *testing.T) {
+	root := &cmds.Command{
+		Subcommands: map[string]*cmds.Command{
+			"reject": {
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+					return cmds.ClientError("rejected")
+				},
+				DocsURL: "https://docs.example.com/reject",
+			},
+			"fail": {
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+					return cmds.WithHelpURL(fmt.Errorf("disk full"), "https://docs.example.com/disk")
+				},
+				DocsURL: "https://docs.example.com/fail",
+			},
+		},
+	}
+
+	srv := httptest.NewServer(NewHandler(nil, root, originCfg(defaultOrigins)))
+	defer srv.Close()
+
+	for path, url := range map[string]string{
+		// usage errors point to the docs of the command
+		"reject": "https://docs.example.com/reject",
+		// others to their own help
+		"fail": "https://docs.example.com/disk",
+	} {
+		httpRes, err := http.Post(srv.URL+"/"+path, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = parseResponse(httpRes, nil)
+		httpRes.Body.Close()
+		if got := cmds.HelpURL(err); got != url {
+			t.Errorf("%s: expected help URL %q, got %q (%v)", path, url, got, err)
+		}
+	}
+}
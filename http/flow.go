@@ -0,0 +1,228 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+const (
+	// FlowCreditPath is the path, relative to the API prefix, of the
+	// endpoint granting credit to flow-controlled responses. It is only
+	// served if ServerConfig.FlowWindow is set.
+	FlowCreditPath = "flow/credit"
+
+	// flowWindowHeader carries the window requested by the client, and the
+	// window granted by the server in the response.
+	flowWindowHeader = "X-Flow-Window"
+)
+
+// Flow control lets slow clients make the command block in Emit, instead of
+// having the response pile up in the buffers of proxies between client and
+// server.
+//
+// A client asks for flow control by sending the number of values it is
+// willing to buffer in the flowWindowHeader. The server answers with the
+// window it granted, which is the initial credit of the response: every
+// value emitted uses up one unit, and Emit blocks while there is none left.
+// As the client consumes values, it grants more credit by posting the
+// request ID and the number of values consumed to FlowCreditPath. Readers
+// are streamed as usual and don't use credit.
+
+// flowStream is the credit of a flow-controlled response.
+type flowStream struct {
+	window int
+
+	l      sync.Mutex
+	credit int
+	avail  chan struct{}
+}
+
+func newFlowStream(window int) *flowStream {
+	return &flowStream{window: window, credit: window, avail: make(chan struct{}, 1)}
+}
+
+// acquire takes one unit of credit, waiting for it if necessary.
+func (s *flowStream) acquire(ctx context.Context) error {
+	for {
+		s.l.Lock()
+		if s.credit > 0 {
+			s.credit--
+			s.l.Unlock()
+			return nil
+		}
+		s.l.Unlock()
+
+		select {
+		case <-s.avail:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// grant adds n units of credit, never exceeding the window.
+func (s *flowStream) grant(n int) {
+	s.l.Lock()
+	s.credit += n
+	if s.credit > s.window {
+		s.credit = s.window
+	}
+	s.l.Unlock()
+
+	select {
+	case s.avail <- struct{}{}:
+	default:
+	}
+}
+
+// flowManager keeps track of the flow-controlled responses of a handler.
+type flowManager struct {
+	max int
+
+	l       sync.Mutex
+	streams map[string]*flowStream
+}
+
+func newFlowManager(max int) *flowManager {
+	return &flowManager{max: max, streams: make(map[string]*flowStream)}
+}
+
+// open starts flow control for the response to the request with ID id, if
+// the client asked for it in r. It returns nil otherwise. The returned
+// function removes the stream once the response is done.
+func (m *flowManager) open(r *http.Request, id string) (*flowStream, func()) {
+	window, err := strconv.Atoi(r.Header.Get(flowWindowHeader))
+	if err != nil || window <= 0 || id == "" {
+		return nil, func() {}
+	}
+	if window > m.max {
+		window = m.max
+	}
+
+	s := newFlowStream(window)
+	m.l.Lock()
+	m.streams[id] = s
+	m.l.Unlock()
+
+	return s, func() {
+		m.l.Lock()
+		delete(m.streams, id)
+		m.l.Unlock()
+	}
+}
+
+func (h *handler) serveFlowCredit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		setAllowHeader(w, false)
+		http.Error(w, "405 - Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	n, err := strconv.Atoi(q.Get("n"))
+	if err != nil || n <= 0 {
+		http.Error(w, "invalid credit", http.StatusBadRequest)
+		return
+	}
+
+	h.flows.l.Lock()
+	s, ok := h.flows.streams[q.Get("id")]
+	h.flows.l.Unlock()
+	if !ok {
+		// the response may have ended already
+		http.Error(w, "unknown stream", http.StatusNotFound)
+		return
+	}
+
+	s.grant(n)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// withFlowControl returns a ResponseEmitterOption that makes Emit wait for
+// credit from s.
+func withFlowControl(s *flowStream) ResponseEmitterOption {
+	return func(re *responseEmitter) {
+		re.flow = s
+	}
+}
+
+// ClientWithFlowControl makes the client ask the server to keep at most
+// window values of a response in flight. The server may grant a smaller
+// window, or ignore the request if it doesn't support flow control.
+func ClientWithFlowControl(window int) ClientOpt {
+	return func(c *client) {
+		c.flowWindow = window
+	}
+}
+
+// flowResponse grants credit to the server as values are read.
+type flowResponse struct {
+	cmds.Response
+	c      *client
+	ctx    context.Context
+	id     string
+	window int
+
+	consumed int
+}
+
+// newFlowResponse wraps res if the server granted flow control in httpRes.
+func (c *client) newFlowResponse(ctx context.Context, res cmds.Response, httpRes *http.Response) cmds.Response {
+	window, err := strconv.Atoi(httpRes.Header.Get(flowWindowHeader))
+	id := httpRes.Header.Get(requestIDHeader)
+	if err != nil || window <= 0 || id == "" {
+		return res
+	}
+	return &flowResponse{Response: res, c: c, ctx: ctx, id: id, window: window}
+}
+
+func (res *flowResponse) Next() (interface{}, error) {
+	v, err := res.Response.Next()
+	if err != nil {
+		return v, err
+	}
+
+	// grant credit in batches of half the window, so the server can keep
+	// sending while the credit is on its way
+	res.consumed++
+	if res.consumed >= (res.window+1)/2 {
+		go res.c.grantCredit(res.ctx, res.id, res.consumed)
+		res.consumed = 0
+	}
+	return v, nil
+}
+
+func (res *flowResponse) Trailer() *cmds.Trailer {
+	if t, ok := res.Response.(interface{ Trailer() *cmds.Trailer }); ok {
+		return t.Trailer()
+	}
+	return nil
+}
+
+func (c *client) grantCredit(ctx context.Context, id string, n int) {
+	q := url.Values{"id": {id}, "n": {strconv.Itoa(n)}}
+	u := fmt.Sprintf(ApiUrlFormat, c.serverAddress, c.apiPrefix, FlowCreditPath, q.Encode())
+
+	httpReq, err := http.NewRequest("POST", u, nil)
+	if err != nil {
+		log.Error("error granting flow credit", "error", err)
+		return
+	}
+	httpReq.Header.Set(uaHeader, c.ua)
+	c.setAuth(httpReq)
+
+	httpRes, err := c.httpClient.Do(httpReq.WithContext(ctx))
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Error("error granting flow credit", "error", err)
+		}
+		return
+	}
+	httpRes.Body.Close()
+}
@@ -0,0 +1,90 @@
+package http
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+func TestFlowControl(t *testing.T) {
+	const n = 50
+
+	var emitted int32
+	root := &cmds.Command{
+		Subcommands: map[string]*cmds.Command{
+			"count": {
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+					for i := 0; i < n; i++ {
+						if err := re.Emit(i); err != nil {
+							return err
+						}
+						atomic.AddInt32(&emitted, 1)
+					}
+					return nil
+				},
+				Type: 0,
+			},
+		},
+	}
+
+	srvCfg := originCfg(defaultOrigins)
+	srvCfg.FlowWindow = 8
+	srv := httptest.NewServer(NewHandler(nil, root, srvCfg))
+	defer srv.Close()
+
+	type testcase struct {
+		window int
+		// max is the most values emitted while the client reads only one
+		max int32
+	}
+
+	tcs := []testcase{
+		{window: 0, max: n},
+		{window: 4, max: 4},
+		// the server caps the window
+		{window: 100, max: 8},
+	}
+
+	for _, tc := range tcs {
+		atomic.StoreInt32(&emitted, 0)
+
+		req, err := cmds.NewRequest(context.Background(), []string{"count"}, nil, nil, nil, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := NewClient(srv.URL, ClientWithFlowControl(tc.window)).(*client).send(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := res.Next(); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(100 * time.Millisecond)
+		if e := atomic.LoadInt32(&emitted); e > tc.max || (tc.window == 0 && e != n) {
+			t.Errorf("window %d: %d values emitted ahead of the client", tc.window, e)
+		}
+
+		// the rest arrives in order once the client reads on
+		for i := 1; ; i++ {
+			v, err := res.Next()
+			if err == io.EOF {
+				if i != n {
+					t.Errorf("window %d: expected %d values, got %d", tc.window, n, i)
+				}
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *v.(*int) != i {
+				t.Fatalf("window %d: expected %d, got %d", tc.window, i, *v.(*int))
+			}
+		}
+	}
+}
@@ -2,19 +2,22 @@ package http
 
 import (
 	"context"
+	"crypto/cipher"
 	"errors"
+	"fmt"
 	"net/http"
 	"runtime/debug"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	cmds "github.com/fgeth/fg-ipfs-cmds"
-	logging "github.com/ipfs/go-log"
 	cors "github.com/rs/cors"
 )
 
-var log = logging.Logger("cmds/http")
+var log = cmds.SubsystemLogger("cmds/http")
 
 var (
 	// ErrNotFound is returned when the endpoint does not exist.
@@ -22,11 +25,30 @@ var (
 )
 
 const (
-	// StreamErrHeader is used as trailer when stream errors happen.
-	StreamErrHeader          = "X-Stream-Error"
+	// StreamErrHeader is used as trailer when stream errors happen. It
+	// carries the error as a JSON encoded cmds.Error, with its code and
+	// details.
+	StreamErrHeader = "X-Stream-Error"
+	// StreamErrCodeHeader is the trailer carrying the code of the stream
+	// error to clients of version 1 of the protocol, unless it is
+	// cmds.ErrNormal. Their StreamErrHeader carries just the message.
+	StreamErrCodeHeader = "X-Stream-Error-Code"
+	// TimeoutHeader carries the time left until the deadline of the
+	// client, as a duration like "1.5s". The handler stops the request
+	// when that time has passed.
+	TimeoutHeader = "X-Request-Timeout"
+	// ExitStatusHeader is the trailer carrying the exit status the command
+	// suggested for the CLI, see cmds.SetStatus.
+	ExitStatusHeader = "X-Exit-Status"
+	// WarningHeader carries the warnings sent before the response started,
+	// e.g. about deprecated options, one per header.
+	WarningHeader = "X-Command-Warning"
+
 	streamHeader             = "X-Stream-Output"
 	channelHeader            = "X-Chunked-Output"
+	contentLengthHeader      = "Content-Length"
 	extraContentLengthHeader = "X-Content-Length"
+	requestIDHeader          = "X-Request-Id"
 	uaHeader                 = "User-Agent"
 	contentTypeHeader        = "Content-Type"
 	contentDispHeader        = "Content-Disposition"
@@ -53,13 +75,32 @@ func skipAPIHeader(h string) bool {
 
 // the internal handler for the API
 type handler struct {
-	root *cmds.Command
-	cfg  *ServerConfig
-	env  cmds.Environment
+	cfg      *ServerConfig
+	env      cmds.Environment
+	sessions *sessionManager
+	aead     cipher.AEAD
+	// exchanges are the sealed exchanges served, see sealWindow.
+	exchanges *exchangeLog
+	flows     *flowManager
+	uploads   *uploadManager
+	drain     drain
+	inflight  inflight
+	active    cmds.ActiveRequests
+
+	// root holds the *cmds.Command served. rootLk serializes updates.
+	root   atomic.Value
+	rootLk sync.Mutex
+}
+
+// Handler is the http.Handler serving a command tree. The tree can be
+// replaced while the handler is running.
+type Handler struct {
+	http.Handler
+	h *handler
 }
 
 // NewHandler creates the http.Handler for the given commands.
-func NewHandler(env cmds.Environment, root *cmds.Command, cfg *ServerConfig) http.Handler {
+func NewHandler(env cmds.Environment, root *cmds.Command, cfg *ServerConfig) *Handler {
 	if cfg == nil {
 		panic("must provide a valid ServerConfig")
 	}
@@ -68,32 +109,89 @@ func NewHandler(env cmds.Environment, root *cmds.Command, cfg *ServerConfig) htt
 
 	var h http.Handler
 
-	h = &handler{
-		env:  env,
-		root: root,
-		cfg:  cfg,
+	hdlr := &handler{
+		env:   env,
+		cfg:   cfg,
+		drain: newDrain(),
 	}
+	hdlr.inflight.max = cfg.MaxInflightWeight
+	hdlr.root.Store(root)
+	if cfg.SessionFactory != nil {
+		hdlr.sessions = newSessionManager(cfg)
+	}
+	if cfg.FlowWindow > 0 {
+		hdlr.flows = newFlowManager(cfg.FlowWindow)
+	}
+	if cfg.UploadDir != "" {
+		hdlr.uploads = newUploadManager(cfg)
+	}
+	if cfg.PayloadKey != nil {
+		aead, err := newPayloadAEAD(cfg.PayloadKey)
+		if err != nil {
+			panic(err)
+		}
+		hdlr.aead = aead
+		hdlr.exchanges = newExchangeLog()
+	}
+	h = hdlr
 
 	if cfg.APIPath != "" {
 		h = newPrefixHandler(cfg.APIPath, h) // wrap with path prefix checker and trimmer
 	}
 	h = c.Handler(h) // wrap with CORS handler
 
-	return h
+	return &Handler{Handler: h, h: hdlr}
+}
+
+// Root returns the command tree currently served.
+func (h *Handler) Root() *cmds.Command {
+	return h.h.getRoot()
+}
+
+// SetRoot atomically replaces the command tree served. Requests that have
+// already started keep running against the previous tree. root must not be
+// modified once it has been passed to SetRoot; build a new tree instead.
+func (h *Handler) SetRoot(root *cmds.Command) {
+	h.UpdateRoot(func(*cmds.Command) *cmds.Command { return root })
+}
+
+// UpdateRoot atomically replaces the command tree served with the one
+// returned by update, which is passed the current tree. Concurrent updates
+// are serialized, so update can be used to add or remove subcommands
+// without losing other updates. update must not modify the tree it is
+// passed.
+func (h *Handler) UpdateRoot(update func(old *cmds.Command) *cmds.Command) {
+	h.h.rootLk.Lock()
+	defer h.h.rootLk.Unlock()
+	h.h.root.Store(update(h.h.getRoot()))
+}
+
+func (h *handler) getRoot() *cmds.Command {
+	return h.root.Load().(*cmds.Command)
 }
 
 type requestLogger interface {
 	LogRequest(*cmds.Request) func()
 }
 
+// logger returns the logger of the handler.
+func (h *handler) logger() cmds.Logger {
+	if h.cfg.Logger != nil {
+		return h.cfg.Logger
+	}
+	return log
+}
+
 func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	log.Debug("incoming API request: ", r.URL)
+	log := h.logger()
+	log.Debug("incoming API request", "url", r.URL)
+	if h.cfg.Logger != nil {
+		r = r.WithContext(cmds.ContextWithLogger(r.Context(), h.cfg.Logger))
+	}
 
 	defer func() {
 		if r := recover(); r != nil {
-			log.Error("a panic has occurred in the commands handler!")
-			log.Error(r)
-			log.Errorf("stack trace:\n%s", debug.Stack())
+			log.Error("a panic has occurred in the commands handler!", "panic", r, "stack", string(debug.Stack()))
 		}
 	}()
 
@@ -119,15 +217,115 @@ func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	default:
 		setAllowHeader(w, h.cfg.AllowGet)
 		http.Error(w, "405 - Method Not Allowed", http.StatusMethodNotAllowed)
-		log.Warnf("The IPFS API does not support %s requests.", r.Method)
+		log.Warn("The IPFS API does not support this request method.", "method", r.Method)
 		return
 	}
 
 	if !allowOrigin(r, h.cfg) || !allowReferer(r, h.cfg) || !allowUserAgent(r, h.cfg) {
 		http.Error(w, "403 - Forbidden", http.StatusForbidden)
-		log.Warnf("API blocked request to %s. (possible CSRF)", r.URL)
+		log.Warn("API blocked request. (possible CSRF)", "url", r.URL)
+		return
+	}
+
+	if !authorized(r, h.cfg) {
+		w.Header().Set(wwwAuthenticateHeader, bearerScheme)
+		http.Error(w, "401 - Unauthorized", http.StatusUnauthorized)
+		log.Warn("API blocked request without a valid auth token", "url", r.URL)
+		return
+	}
+
+	if !h.drain.enter() {
+		http.Error(w, "503 - Service Unavailable: server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	defer h.drain.leave()
+
+	if h.cfg.Identify != nil {
+		id := h.cfg.Identify(r)
+		ctx := ContextWithIdentity(r.Context(), id)
+		// the commands log the identity along with the request, through the
+		// logger the request already carries if any
+		ctx = cmds.ContextWithLogger(ctx, cmds.LoggerWith(cmds.ContextLogger(ctx, log), "identity", id))
+		r = r.WithContext(ctx)
+	}
+
+	r, err := withProtocol(w, r)
+	if err != nil {
+		writeError(w, err, cmds.ErrClient)
+		return
+	}
+
+	path := strings.Trim(r.URL.Path, "/")
+	if h.cfg.RequireSealed && path != SealedPath && !isSealed(r) {
+		http.Error(w, "403 - Forbidden: requests must be sealed", http.StatusForbidden)
+		return
+	}
+
+	// sealed responses are encrypted, so they don't compress
+	if h.cfg.Compress && path != SealedPath && !isSealed(r) {
+		if cw := newCompressResponseWriter(w, r); cw != nil {
+			defer cw.finish()
+			w = cw
+		}
+	}
+
+	// the inner requests of sealed exchanges are signed, not the sealed
+	// responses carrying them
+	if h.cfg.SigningKey != nil && (path != SealedPath || isSealed(r)) {
+		sw := newSigningResponseWriter(w, r, h.cfg.SigningKey)
+		defer sw.finish()
+		w = sw
+	}
+
+	switch path {
+	case SealedPath:
+		if h.aead != nil && !isSealed(r) {
+			h.serveSealed(w, r)
+			return
+		}
+	case BatchPath:
+		if h.cfg.AllowBatch {
+			h.serveBatch(w, r)
+			return
+		}
+	case CommandTreePath:
+		if h.cfg.ServeCommandTree {
+			h.serveCommandTree(w, r)
+			return
+		}
+	case FlowCreditPath:
+		if h.flows != nil {
+			h.serveFlowCredit(w, r)
+			return
+		}
+	case SessionOpenPath, SessionClosePath:
+		if h.sessions != nil {
+			h.serveSession(w, r, path)
+			return
+		}
+	case UploadOpenPath, UploadChunkPath, UploadStatusPath:
+		if h.uploads != nil {
+			h.serveUpload(w, r, path)
+			return
+		}
+	}
+
+	env, r, release, err := h.sessionEnv(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	defer release()
+
+	r, uploadDone, err := h.uploadBody(r)
+	if err == ErrUnknownUpload {
+		writeError(w, err, cmds.ErrNotFound)
+		return
+	} else if err != nil {
+		writeError(w, err, cmds.ErrNormal)
 		return
 	}
+	defer uploadDone()
 
 	// If we have a request body, make sure the preamble
 	// knows that it should close the body if it wants to
@@ -147,14 +345,26 @@ func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		r.Body = bw
 	}
 
-	req, err := parseRequest(r, h.root)
-	if err != nil {
-		status := http.StatusBadRequest
-		if err == ErrNotFound {
-			status = http.StatusNotFound
+	// use the same tree for the whole request
+	root := h.getRoot()
+
+	if h.cfg.StrictValidation {
+		if verr := validateRequest(r, root); verr != nil {
+			writeValidationError(w, verr)
+			return
 		}
+	}
 
-		http.Error(w, err.Error(), status)
+	req, err := parseRequest(r, root)
+	if err != nil {
+		var verr *ValidationError
+		if errors.As(err, &verr) {
+			writeValidationError(w, verr)
+		} else if err == ErrNotFound {
+			writeError(w, err, cmds.ErrNotFound)
+		} else {
+			writeError(w, err, cmds.ErrClient)
+		}
 		return
 	}
 
@@ -164,32 +374,129 @@ func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			w.Header()[k] = v
 		}
 	}
+	// the response depends on the Accept header, see negotiateEncoding
+	if negotiatesEncoding(r) {
+		w.Header().Add(varyHeader, acceptHeader)
+	}
 
 	// Handle the timeout up front.
-	var cancel func()
-	if timeoutStr, ok := req.Options[cmds.TimeoutOpt]; ok {
-		timeout, err := time.ParseDuration(timeoutStr.(string))
-		if err != nil {
+	cancel, err := setRequestTimeout(req, r.Header.Get(TimeoutHeader), h.cfg.MaxRequestTimeout)
+	if err != nil {
+		writeError(w, err, cmds.ErrClient)
+		return
+	}
+	defer cancel()
+	defer h.active.Track(req, cancel)()
+
+	if err := h.checkReadOnly(req); err != nil {
+		re, reErr := NewResponseEmitter(w, r.Method, req)
+		if reErr != nil {
+			writeError(w, err, cmds.ErrForbidden)
 			return
 		}
-		req.Context, cancel = context.WithTimeout(req.Context, timeout)
-	} else {
-		req.Context, cancel = context.WithCancel(req.Context)
+		re.CloseWithError(err)
+		return
 	}
-	defer cancel()
 
-	re, err := NewResponseEmitter(w, r.Method, req, withRequestBodyEOFChan(bodyEOFChan))
+	record, err := h.admit(req)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, err, cmds.ErrRateLimited)
 		return
 	}
+	cw := &countingResponseWriter{ResponseWriter: w}
+	defer func() { record(cw.written()) }()
+	w = cw
+	if h.cfg.EmitTimeout > 0 {
+		logger := cmds.ContextLogger(req.Context, log)
+		w = newStallWriter(w, h.cfg.EmitTimeout, func() {
+			logger.Warn("response write stalled, canceling request",
+				"path", strings.Join(req.Path, " "), "timeout", h.cfg.EmitTimeout)
+			cancel()
+		})
+	}
 
-	if reqLogger, ok := h.env.(requestLogger); ok {
+	var flow *flowStream
+	if h.flows != nil {
+		var done func()
+		flow, done = h.flows.open(r, cmds.RequestID(req.Context))
+		defer done()
+		if flow != nil {
+			w.Header().Set(flowWindowHeader, strconv.Itoa(flow.window))
+		}
+	}
+
+	re, err := NewResponseEmitter(w, r.Method, req,
+		withRequestBodyEOFChan(bodyEOFChan),
+		withServerVersion(h.cfg.Version),
+		withFlowControl(flow),
+		withHeartbeats(h.heartbeatInterval(req)),
+		withResponseLimits(h.responseLimits(req), cw),
+	)
+	if err != nil {
+		writeError(w, err, cmds.ErrClient)
+		return
+	}
+
+	if reqLogger, ok := env.(requestLogger); ok {
 		done := reqLogger.LogRequest(req)
 		defer done()
 	}
 
-	h.root.Call(req, re, h.env)
+	root.Call(req, re, env)
+}
+
+// checkReadOnly returns an error if req may not run because the handler is
+// read-only.
+func (h *handler) checkReadOnly(req *cmds.Request) error {
+	if h.cfg.ReadOnly && req.Command.Mutating {
+		return cmds.Errorf(cmds.ErrForbidden, "command %q is not available on a read-only server", strings.Join(req.Path, " "))
+	}
+	return nil
+}
+
+// setRequestTimeout derives the request context from the timeout option and
+// the client's timeout header, whichever is shorter. Both are capped at max,
+// unless it is zero. The returned function must be called to release the
+// context.
+func setRequestTimeout(req *cmds.Request, header string, max time.Duration) (context.CancelFunc, error) {
+	// subscriptions run until the client goes away
+	if req.Command.Subscription {
+		var cancel context.CancelFunc
+		req.Context, cancel = context.WithCancel(req.Context)
+		return cancel, nil
+	}
+
+	var (
+		timeout time.Duration
+		set     bool
+	)
+	if timeoutStr, ok := req.Options[cmds.TimeoutOpt]; ok {
+		t, err := time.ParseDuration(timeoutStr.(string))
+		if err != nil {
+			return nil, err
+		}
+		timeout, set = t, true
+	}
+	if header != "" {
+		t, err := time.ParseDuration(header)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s header: %s", TimeoutHeader, err)
+		}
+		if !set || t < timeout {
+			timeout, set = t, true
+		}
+	}
+	if set && max > 0 && timeout > max {
+		timeout = max
+	}
+
+	var cancel context.CancelFunc
+	if set {
+		req.Context, cancel = context.WithTimeout(req.Context, timeout)
+	} else {
+		req.Context, cancel = context.WithCancel(req.Context)
+	}
+	return cancel, nil
 }
 
 func setAllowHeader(w http.ResponseWriter, allowGet bool) {
@@ -6,8 +6,10 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
 	"net/http/httptest"
 	"runtime"
+	"sync"
 
 	"testing"
 
@@ -62,6 +64,7 @@ var (
 			cmds.OptionEncodingType,
 			cmds.OptionStreamChannels,
 			cmds.OptionTimeout,
+			cmds.OptionEnvelope,
 		},
 
 		Subcommands: map[string]*cmds.Command{
@@ -322,3 +325,54 @@ func errEq(err1, err2 error) bool {
 
 	return err1.Error() == err2.Error()
 }
+
+type kvLogger struct {
+	l  sync.Mutex
+	kv [][]interface{}
+}
+
+func (l *kvLogger) log(kv []interface{}) {
+	l.l.Lock()
+	defer l.l.Unlock()
+	l.kv = append(l.kv, kv)
+}
+
+func (l *kvLogger) Debug(msg string, kv ...interface{}) { l.log(kv) }
+func (l *kvLogger) Info(msg string, kv ...interface{})  { l.log(kv) }
+func (l *kvLogger) Warn(msg string, kv ...interface{})  { l.log(kv) }
+func (l *kvLogger) Error(msg string, kv ...interface{}) { l.log(kv) }
+
+func TestIdentityLogger(t *testing.T) {
+	root := &cmds.Command{
+		Subcommands: map[string]*cmds.Command{
+			"add": {
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+					cmds.LoggerFrom(req).Info("adding")
+					return nil
+				},
+			},
+		},
+	}
+	cfg := originCfg(defaultOrigins)
+	cfg.Identify = func(r *http.Request) string { return "alice" }
+	h := NewHandler(nil, root, cfg)
+
+	// the identity is added to the logger the request carries
+	l := new(kvLogger)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r.WithContext(cmds.ContextWithLogger(r.Context(), l)))
+	}))
+	defer srv.Close()
+
+	res, err := http.Post(srv.URL+"/add", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	l.l.Lock()
+	defer l.l.Unlock()
+	if len(l.kv) == 0 || len(l.kv[0]) < 2 || l.kv[0][0] != "identity" || l.kv[0][1] != "alice" {
+		t.Errorf("expected a message with the identity, got %v", l.kv)
+	}
+}
@@ -0,0 +1,202 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+// Capabilities describes what a server supports. It is fetched from the
+// server's command tree endpoint during the handshake.
+type Capabilities struct {
+	// Version is the server's ServerConfig.Version. It may be empty.
+	Version string
+	// ProtocolVersion is the version of the wire protocol the server
+	// answers this client with, see ProtocolVersion.
+	ProtocolVersion int
+	// TreeHash is the hex encoded SHA-256 hash of the server's command tree.
+	TreeHash string
+	// Tree is the description of the server's command tree.
+	Tree *cmds.CommandDescription
+}
+
+// Handshaker is implemented by executors that can query the capabilities of
+// the server they talk to.
+type Handshaker interface {
+	// Capabilities returns the capabilities of the server. It returns nil
+	// and no error if the server doesn't serve its command tree.
+	Capabilities(ctx context.Context) (*Capabilities, error)
+}
+
+var _ Handshaker = &client{}
+
+// handlerOptions are handled by the HTTP handler itself and are supported
+// even if the served command tree doesn't declare them.
+var handlerOptions = map[string]bool{
+	cmds.EncLong:    true,
+	cmds.ChanOpt:    true,
+	cmds.TimeoutOpt: true,
+}
+
+// ClientWithHandshake makes the client fetch the server's command tree before
+// sending the first request, and reject requests for commands and options the
+// server doesn't know about. version is the version of the local command
+// tree and is mentioned in the errors; it may be empty.
+//
+// If the server has commands or options the local tree doesn't have, or the
+// other way around, a warning about the version skew is added to the first
+// response, see ClientWithoutSkewWarning, and client errors mention it.
+//
+// If the server doesn't serve its command tree, requests are sent unchecked.
+func ClientWithHandshake(version string) ClientOpt {
+	return func(c *client) {
+		c.handshake = true
+		c.version = version
+	}
+}
+
+// Capabilities fetches the server's capabilities. Successful results, as well
+// as the server not supporting the handshake, are cached.
+func (c *client) Capabilities(ctx context.Context) (*Capabilities, error) {
+	c.capsLk.Lock()
+	defer c.capsLk.Unlock()
+
+	if c.capsDone {
+		return c.caps, nil
+	}
+
+	caps, err := c.fetchCapabilities(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.caps = caps
+	c.capsDone = true
+	return caps, nil
+}
+
+func (c *client) fetchCapabilities(ctx context.Context) (*Capabilities, error) {
+	url := c.serverAddress + c.apiPrefix + "/" + CommandTreePath
+	httpReq, err := http.NewRequest(http.MethodPost, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set(uaHeader, c.ua)
+	c.setAuth(httpReq)
+	httpReq.Header.Set(protocolHeader, strconv.Itoa(ProtocolVersion))
+	httpReq = httpReq.WithContext(ctx)
+
+	httpRes, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer httpRes.Body.Close()
+
+	if httpRes.StatusCode != http.StatusOK {
+		// older servers and servers that don't enable the endpoint
+		// treat it as an unknown command
+		msg, _ := ioutil.ReadAll(httpRes.Body)
+		log.Debug("server does not support the handshake", "status", httpRes.StatusCode, "message", strings.TrimSpace(string(msg)))
+		return nil, nil
+	}
+
+	caps := &Capabilities{
+		Version:         httpRes.Header.Get(serverVersionHeader),
+		ProtocolVersion: responseProtocol(httpRes),
+		TreeHash:        httpRes.Header.Get(commandTreeHashHeader),
+		Tree:            new(cmds.CommandDescription),
+	}
+	if err := json.NewDecoder(httpRes.Body).Decode(caps.Tree); err != nil {
+		return nil, fmt.Errorf("invalid command tree: %s", err)
+	}
+	return caps, nil
+}
+
+// checkCapabilities performs the handshake if it is enabled and checks that
+// the server supports req. Its errors mention the skew between the command
+// tree of the server and that of req, if any.
+func (c *client) checkCapabilities(req *cmds.Request) error {
+	if !c.handshake {
+		return nil
+	}
+
+	caps, err := c.Capabilities(req.Context)
+	if err != nil || caps == nil {
+		return err
+	}
+
+	c.capsLk.Lock()
+	if !c.skewDone {
+		c.skew = caps.skew(req.Root, c.version)
+		c.skewDone = true
+	}
+	skew := c.skew
+	c.capsLk.Unlock()
+
+	err = caps.Check(req, c.version)
+	if err != nil && skew != nil {
+		err = skew.annotate(err)
+	}
+	return err
+}
+
+// Check returns an error if the server doesn't support the command or one of
+// the options of req. version is the version of the local command tree; if
+// set, it is mentioned in the error.
+func (caps *Capabilities) Check(req *cmds.Request, version string) error {
+	unsupported := func(format string, args ...interface{}) error {
+		server := "server"
+		if caps.Version != "" {
+			server += " " + caps.Version
+		}
+		msg := fmt.Sprintf("%s does not support %s", server, fmt.Sprintf(format, args...))
+		if version != "" {
+			msg += fmt.Sprintf(" (requires %s)", version)
+		}
+		return cmds.Error{Message: msg, Code: cmds.ErrClient}
+	}
+
+	known := make(map[string]bool)
+	addOptions := func(d *cmds.CommandDescription) {
+		for _, opt := range d.Options {
+			for _, name := range opt.Names {
+				known[name] = true
+			}
+		}
+	}
+
+	d := caps.Tree
+	addOptions(d)
+	for i, name := range req.Path {
+		var sub *cmds.CommandDescription
+		for _, s := range d.Subcommands {
+			if s.Name == name {
+				sub = s
+				break
+			}
+		}
+		if sub == nil {
+			return unsupported("command %q", strings.Join(req.Path[:i+1], " "))
+		}
+		d = sub
+		addOptions(d)
+	}
+
+	names := make([]string, 0, len(req.Options))
+	for name := range req.Options {
+		if !OptionSkipMap[name] && !handlerOptions[name] && !known[name] {
+			names = append(names, name)
+		}
+	}
+	if len(names) > 0 {
+		sort.Strings(names)
+		return unsupported("option --%s", names[0])
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package http
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+func TestHandshake(t *testing.T) {
+	env, _ := getTestServer(t, nil, false)
+
+	// the local tree is newer than the one served
+	localRoot := &cmds.Command{
+		Options: cmdRoot.Options,
+		Subcommands: map[string]*cmds.Command{
+			"version": {
+				Options: []cmds.Option{cmds.BoolOption("verbose", "")},
+				Run:     cmdRoot.Subcommands["version"].Run,
+				Type:    cmdRoot.Subcommands["version"].Type,
+			},
+			"newcmd": {
+				Run: func(*cmds.Request, cmds.ResponseEmitter, cmds.Environment) error { return nil },
+			},
+		},
+	}
+
+	type testcase struct {
+		path      []string
+		opts      cmds.OptMap
+		serveTree bool
+		err       string
+	}
+
+	tcs := []testcase{
+		{path: []string{"version"}, serveTree: true},
+		{path: []string{"version"}, opts: cmds.OptMap{cmds.TimeoutOpt: "10s"}, serveTree: true},
+		{
+			path:      []string{"version"},
+			opts:      cmds.OptMap{"verbose": true},
+			serveTree: true,
+			err:       "server 0.1.2 does not support option --verbose (requires 0.2.0) (version skew: server is older than this client)",
+		},
+		{
+			path:      []string{"newcmd"},
+			serveTree: true,
+			err:       `server 0.1.2 does not support command "newcmd" (requires 0.2.0) (version skew: server is older than this client)`,
+		},
+		// without the endpoint, requests are sent unchecked
+		{path: []string{"version"}, opts: cmds.OptMap{"verbose": true}},
+	}
+
+	for i, tc := range tcs {
+		srvCfg := originCfg(defaultOrigins)
+		srvCfg.Version = "0.1.2"
+		srvCfg.ServeCommandTree = tc.serveTree
+		srv := httptest.NewServer(NewHandler(env, cmdRoot, srvCfg))
+
+		req, err := cmds.NewRequest(context.Background(), tc.path, tc.opts, nil, nil, localRoot)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c := NewClient(srv.URL, ClientWithHandshake("0.2.0"))
+		res, err := c.(*client).send(req)
+		if tc.err != "" {
+			if err == nil || err.Error() != tc.err {
+				t.Errorf("%d: expected error %q, got %v", i, tc.err, err)
+			}
+		} else if err != nil {
+			t.Errorf("%d: unexpected error: %s", i, err)
+		} else if _, err := res.Next(); err != nil {
+			t.Errorf("%d: unexpected error reading response: %s", i, err)
+		}
+
+		caps, err := c.(Handshaker).Capabilities(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.serveTree && (caps == nil || caps.Version != "0.1.2" || len(caps.TreeHash) != 64) {
+			t.Errorf("%d: unexpected capabilities: %+v", i, caps)
+		}
+		if !tc.serveTree && caps != nil {
+			t.Errorf("%d: expected no capabilities, got %+v", i, caps)
+		}
+
+		srv.Close()
+	}
+}
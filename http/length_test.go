@@ -0,0 +1,91 @@
+package http
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+func TestSetLength(t *testing.T) {
+	const data = "some data"
+
+	root := &cmds.Command{
+		Subcommands: map[string]*cmds.Command{
+			"single": {
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+					re.SetLength(uint64(len(data)))
+					return cmds.EmitOnce(re, strings.NewReader(data))
+				},
+			},
+			"stream": {
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+					re.SetLength(uint64(len(data)))
+					return re.Emit(strings.NewReader(data))
+				},
+			},
+		},
+	}
+
+	srv := httptest.NewServer(NewHandler(nil, root, originCfg(defaultOrigins)))
+	defer srv.Close()
+
+	type testcase struct {
+		path          string
+		contentLength int64
+	}
+
+	tcs := []testcase{
+		{path: "single", contentLength: int64(len(data))},
+		// more values might follow, so the body is chunked
+		{path: "stream", contentLength: -1},
+	}
+
+	for _, tc := range tcs {
+		req, err := cmds.NewRequest(context.Background(), []string{tc.path}, nil, nil, nil, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c := NewClient(srv.URL).(*client)
+		httpReq, err := c.toHTTPRequest(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		httpRes, err := c.httpClient.Do(httpReq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		httpRes.Body.Close()
+		if httpRes.ContentLength != tc.contentLength {
+			t.Errorf("%s: expected Content-Length %d, got %d", tc.path, tc.contentLength, httpRes.ContentLength)
+		}
+
+		res, err := c.send(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Length() != uint64(len(data)) {
+			t.Errorf("%s: expected length %d, got %d", tc.path, len(data), res.Length())
+		}
+		v, err := res.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, ok := v.(io.Reader)
+		if !ok {
+			t.Fatalf("%s: expected a reader, got %T", tc.path, v)
+		}
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != data {
+			t.Errorf("%s: expected %q, got %q", tc.path, data, b)
+		}
+	}
+}
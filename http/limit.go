@@ -0,0 +1,82 @@
+package http
+
+import (
+	"io"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+// responseLimits are the largest number of values and bytes of a response.
+// Zero means no limit.
+type responseLimits struct {
+	items uint64
+	bytes uint64
+}
+
+// responseLimits returns the limits of the response to req, the smaller of
+// the ones of the command and of the server.
+func (h *handler) responseLimits(req *cmds.Request) responseLimits {
+	return responseLimits{
+		items: minLimit(req.Command.MaxResponseItems, h.cfg.MaxResponseItems),
+		bytes: minLimit(req.Command.MaxResponseBytes, h.cfg.MaxResponseBytes),
+	}
+}
+
+func minLimit(a, b uint64) uint64 {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// withResponseLimits returns a ResponseEmitterOption that cuts the response
+// short once it exceeds limits. The bytes sent are those counted by cw.
+func withResponseLimits(limits responseLimits, cw *countingResponseWriter) ResponseEmitterOption {
+	return func(re *responseEmitter) {
+		re.limits = limits
+		re.counter = cw
+	}
+}
+
+// exceeded reports whether sending another value after items values and
+// bytes bytes exceeds the limits.
+func (l responseLimits) exceeded(items, bytes uint64) bool {
+	return (l.items > 0 && items >= l.items) || (l.bytes > 0 && bytes >= l.bytes)
+}
+
+// truncatedError is the error responses are closed with when they exceed
+// their limits after items values.
+func truncatedError(items uint64) error {
+	return cmds.Errorf(cmds.ErrTruncated, "response exceeds the size limit, truncated after %d values", items)
+}
+
+// written returns the number of bytes of the response body sent.
+func (re *responseEmitter) written() uint64 {
+	if re.counter == nil {
+		return 0
+	}
+	return re.counter.written()
+}
+
+// truncate closes the response with an ErrTruncated error, which is also
+// returned so the command stops emitting.
+func (re *responseEmitter) truncate() error {
+	err := truncatedError(re.trailer.Count)
+	re.trailer.Truncated = true
+	re.closeWithError(err)
+	return err
+}
+
+// copyLimited copies r to the response, up to the byte limit.
+func (re *responseEmitter) copyLimited(r io.Reader) error {
+	remaining := re.limits.bytes - re.written()
+	if err := flushCopy(re.w, io.LimitReader(r, int64(remaining))); err != nil {
+		return err
+	}
+
+	// check whether there was more to copy
+	if n, _ := r.Read(make([]byte, 1)); n > 0 {
+		return re.truncate()
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+func TestResponseLimits(t *testing.T) {
+	count := func(n int) cmds.Function {
+		return func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+			for i := 0; n < 0 || i < n; i++ {
+				if err := re.Emit(i); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+
+	root := &cmds.Command{
+		Options: []cmds.Option{cmds.OptionEnvelope},
+		Subcommands: map[string]*cmds.Command{
+			// runs until its response is cut short
+			"forever": {
+				Run:              count(-1),
+				Type:             0,
+				MaxResponseItems: 5,
+			},
+			"many": {
+				Run:  count(20),
+				Type: 0,
+			},
+			"few": {
+				Run:  count(3),
+				Type: 0,
+			},
+			"read": {
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+					return re.Emit(bytes.NewReader(make([]byte, 100)))
+				},
+			},
+		},
+	}
+
+	srvCfg := originCfg(defaultOrigins)
+	srvCfg.AllowBatch = true
+	srvCfg.MaxResponseItems = 10
+	srvCfg.MaxResponseBytes = 64
+	srv := httptest.NewServer(NewHandler(nil, root, srvCfg))
+	defer srv.Close()
+
+	type testcase struct {
+		path      string
+		values    int
+		truncated bool
+	}
+
+	tcs := []testcase{
+		// the smaller of the limits of the command and server applies
+		{path: "forever", values: 5, truncated: true},
+		{path: "many", values: 10, truncated: true},
+		{path: "few", values: 3},
+	}
+
+	for _, tc := range tcs {
+		req, err := cmds.NewRequest(context.Background(), []string{tc.path}, cmds.OptMap{cmds.EnvelopeOpt: true}, nil, nil, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := NewClient(srv.URL)
+		res, err := c.(*client).send(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		batchRes, err := c.(Batcher).Batch(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, res := range []cmds.Response{res, batchRes[0]} {
+			var n int
+			for {
+				_, err = res.Next()
+				if err != nil {
+					break
+				}
+				n++
+			}
+
+			if n != tc.values {
+				t.Errorf("%s: expected %d values, got %d", tc.path, tc.values, n)
+			}
+			if !tc.truncated {
+				if err != io.EOF {
+					t.Errorf("%s: unexpected error: %v", tc.path, err)
+				}
+				continue
+			}
+			if e, ok := err.(*cmds.Error); !ok || e.Code != cmds.ErrTruncated {
+				t.Errorf("%s: expected truncated error, got %#v", tc.path, err)
+			}
+		}
+		if tr := cmds.GetTrailer(res); tc.truncated && (tr == nil || !tr.Truncated) {
+			t.Errorf("%s: expected trailer of truncated response, got %+v", tc.path, tr)
+		}
+	}
+
+	// readers are cut at the byte limit
+	req, err := cmds.NewRequest(context.Background(), []string{"read"}, nil, nil, nil, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := NewClient(srv.URL).(*client).send(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := res.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(v.(io.Reader))
+	if len(data) != 64 {
+		t.Errorf("expected 64 bytes, got %d", len(data))
+	}
+	if e, ok := err.(*cmds.Error); !ok || e.Code != cmds.ErrTruncated {
+		t.Errorf("expected truncated error, got %#v", err)
+	}
+}
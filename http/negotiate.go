@@ -0,0 +1,175 @@
+package http
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+const (
+	acceptHeader = "Accept"
+	varyHeader   = "Vary"
+)
+
+// mediaRange is a media range of an Accept header, e.g. text/*;q=0.5.
+type mediaRange struct {
+	typ, subtype string
+	q            float64
+}
+
+func (m mediaRange) matches(mime string) bool {
+	typ, subtype := mime, ""
+	if i := strings.IndexByte(mime, '/'); i >= 0 {
+		typ, subtype = mime[:i], mime[i+1:]
+	}
+	if m.typ == "*" {
+		return true
+	}
+	return m.typ == typ && (m.subtype == "*" || m.subtype == subtype)
+}
+
+func (m mediaRange) wildcard() bool {
+	return m.typ == "*" || m.subtype == "*"
+}
+
+// parseAccept returns the media ranges of an Accept header, the ones the
+// client prefers first. Ranges with an invalid quality are left out.
+func parseAccept(accept string) []mediaRange {
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		mime := strings.ToLower(strings.TrimSpace(params[0]))
+		if mime == "" {
+			continue
+		}
+		m := mediaRange{typ: mime, q: 1}
+		if i := strings.IndexByte(mime, '/'); i >= 0 {
+			m.typ, m.subtype = mime[:i], mime[i+1:]
+		}
+		valid := true
+		for _, p := range params[1:] {
+			p = strings.TrimSpace(p)
+			if !strings.HasPrefix(p, "q=") && !strings.HasPrefix(p, "Q=") {
+				continue
+			}
+			q, err := strconv.ParseFloat(p[2:], 64)
+			if err != nil || q < 0 || q > 1 {
+				valid = false
+			}
+			m.q = q
+		}
+		if valid {
+			ranges = append(ranges, m)
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].q > ranges[j].q
+	})
+	return ranges
+}
+
+// browserAccept reports whether ranges come from the Accept header of a
+// browser, listing the types it can display along with */*, which are no
+// preference for the output of commands.
+func browserAccept(ranges []mediaRange) bool {
+	if len(ranges) < 2 {
+		return false
+	}
+	for _, m := range ranges {
+		if m.typ == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultEncoding returns the encoding of the responses to requests for cmd
+// that don't ask for one: its wire encoding if it sets one, JSON if it
+// supports it, and the first encoding it supports otherwise, in the order of
+// its Encodings.
+func defaultEncoding(cmd *cmds.Command) cmds.EncodingType {
+	if cmd.WireEncoding != "" {
+		return cmd.WireEncoding
+	}
+	supported := cmd.SupportedEncodings()
+	isSupported := make(map[cmds.EncodingType]bool)
+	for _, enc := range supported {
+		isSupported[enc] = true
+	}
+	if isSupported[cmds.JSON] {
+		return cmds.JSON
+	}
+	for _, enc := range cmd.Encodings {
+		if isSupported[enc] {
+			return enc
+		}
+	}
+	if len(supported) > 0 {
+		return supported[0]
+	}
+	return cmds.JSON
+}
+
+// negotiateEncoding returns the encoding of the response to a request for
+// cmd that doesn't set the encoding option, from its Accept header: the
+// supported encoding whose MIME type the client prefers, or the default
+// encoding of cmd when the client accepts none of them or doesn't say.
+func negotiateEncoding(accept string, cmd *cmds.Command) cmds.EncodingType {
+	def := defaultEncoding(cmd)
+	ranges := parseAccept(accept)
+	if len(ranges) == 0 || browserAccept(ranges) {
+		return def
+	}
+
+	// the default is tried first, e.g. for */*
+	var candidates []cmds.EncodingType
+	supported := make(map[cmds.EncodingType]bool)
+	for _, enc := range cmd.SupportedEncodings() {
+		if enc == def {
+			candidates = append([]cmds.EncodingType{enc}, candidates...)
+		} else {
+			candidates = append(candidates, enc)
+		}
+		supported[enc] = true
+	}
+
+	// types the client refuses, with q=0
+	refused := make(map[string]bool)
+	for _, m := range ranges {
+		if m.q == 0 && !m.wildcard() {
+			refused[m.typ+"/"+m.subtype] = true
+		}
+	}
+
+	for _, m := range ranges {
+		if m.q == 0 {
+			break
+		}
+		if !m.wildcard() {
+			enc, ok := cmds.EncodingOfMIMEType(m.typ + "/" + m.subtype)
+			if ok && supported[enc] {
+				return enc
+			}
+			continue
+		}
+		for _, enc := range candidates {
+			mime, ok := cmds.MIMEType(enc)
+			if ok && m.matches(mime) && !refused[mime] {
+				return enc
+			}
+		}
+	}
+	return def
+}
+
+// negotiatesEncoding reports whether the encoding of the response to r is
+// negotiated from its Accept header, as it doesn't set the encoding option.
+func negotiatesEncoding(r *http.Request) bool {
+	query := r.URL.Query()
+	_, long := query[cmds.EncLong]
+	_, short := query[cmds.EncShort]
+	return !long && !short
+}
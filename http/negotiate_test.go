@@ -0,0 +1,108 @@
+package http
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	cmd := &cmds.Command{}
+	textOnly := &cmds.Command{Encodings: []cmds.EncodingType{cmds.Text, cmds.CBOR}}
+	wire := &cmds.Command{WireEncoding: cmds.CBOR}
+
+	tcs := []struct {
+		accept string
+		cmd    *cmds.Command
+		enc    cmds.EncodingType
+	}{
+		{"", cmd, cmds.JSON},
+		{"application/json", cmd, cmds.JSON},
+		{"text/plain", cmd, cmds.Text},
+		{"application/cbor", cmd, cmds.CBOR},
+		{"Application/CBOR; charset=binary", cmd, cmds.CBOR},
+		{"application/xml;q=0.5, application/cbor", cmd, cmds.CBOR},
+		{"application/xml;q=0.5, application/cbor;q=0.2", cmd, cmds.XML},
+		{"image/png", cmd, cmds.JSON},
+		{"image/png, text/*", cmd, cmds.Text},
+		{"*/*", cmd, cmds.JSON},
+		{"*/*", textOnly, cmds.Text},
+		{"", textOnly, cmds.Text},
+		{"image/png", textOnly, cmds.Text},
+		{"application/json, text/plain;q=0.5", textOnly, cmds.Text},
+		{"application/cbor;q=0.5, text/plain", textOnly, cmds.Text},
+		{"", wire, cmds.CBOR},
+		{"*/*", wire, cmds.CBOR},
+		{"application/json", wire, cmds.JSON},
+		{"text/*, text/plain;q=0", cmd, cmds.JSON},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", cmd, cmds.JSON},
+		{"text/plain;q=abc", cmd, cmds.JSON},
+	}
+	for _, tc := range tcs {
+		if enc := negotiateEncoding(tc.accept, tc.cmd); enc != tc.enc {
+			t.Errorf("negotiateEncoding(%q) = %s, expected %s", tc.accept, enc, tc.enc)
+		}
+	}
+}
+
+func TestAcceptHeader(t *testing.T) {
+	root := &cmds.Command{
+		Subcommands: map[string]*cmds.Command{
+			"hello": {
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+					return cmds.EmitOnce(re, "hello")
+				},
+			},
+		},
+	}
+	srv := httptest.NewServer(NewHandler(nil, root, originCfg(defaultOrigins)))
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		query, accept     string
+		contentType, body string
+		varies            bool
+	}{
+		{"", "text/plain", "text/plain", "hello", true},
+		{"", "", "application/json", `"hello"` + "\n", true},
+		{"?encoding=json", "text/plain", "application/json", `"hello"` + "\n", false},
+	} {
+		httpReq, err := http.NewRequest("POST", srv.URL+"/hello"+tc.query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.accept != "" {
+			httpReq.Header.Set("Accept", tc.accept)
+		}
+		httpReq.Header.Set("Origin", "http://localhost")
+		httpRes, err := http.DefaultClient.Do(httpReq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(httpRes.Body)
+		httpRes.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ct := strings.Split(httpRes.Header.Get(contentTypeHeader), ";")[0]; ct != tc.contentType {
+			t.Errorf("Accept %q: expected content type %q, got %q", tc.accept, tc.contentType, ct)
+		}
+		if string(body) != tc.body {
+			t.Errorf("Accept %q: expected body %q, got %q", tc.accept, tc.body, body)
+		}
+		varies := false
+		for _, v := range httpRes.Header.Values("Vary") {
+			if strings.Contains(v, "Accept") {
+				varies = true
+			}
+		}
+		if varies != tc.varies {
+			t.Errorf("Accept %q%s: expected Vary: Accept %t, got %q", tc.accept, tc.query, tc.varies, httpRes.Header.Values("Vary"))
+		}
+	}
+}
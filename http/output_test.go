@@ -0,0 +1,95 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+type addProgress struct {
+	Bytes int64
+}
+
+type addResult struct {
+	Name string
+}
+
+func TestOutputTypes(t *testing.T) {
+	root := &cmds.Command{
+		Subcommands: map[string]*cmds.Command{
+			"add": {
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+					if err := re.Emit(&addProgress{Bytes: 10}); err != nil {
+						return err
+					}
+					return re.Emit(&addResult{Name: "a"})
+				},
+				Type:        addResult{},
+				OutputTypes: []cmds.OutputType{{Name: "progress", Type: addProgress{}}},
+			},
+		},
+	}
+
+	srvCfg := originCfg(defaultOrigins)
+	srvCfg.AllowBatch = true
+	srv := httptest.NewServer(NewHandler(nil, root, srvCfg))
+	defer srv.Close()
+
+	exp := []interface{}{&addProgress{Bytes: 10}, &addResult{Name: "a"}}
+	readAll := func(res cmds.Response) []interface{} {
+		var values []interface{}
+		for {
+			v, err := res.Next()
+			if err == io.EOF {
+				return values
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			values = append(values, v)
+		}
+	}
+
+	req, err := cmds.NewRequest(context.Background(), []string{"add"}, nil, nil, nil, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewClient(srv.URL)
+	res, err := c.(*client).send(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values := readAll(res); !reflect.DeepEqual(values, exp) {
+		t.Errorf("expected %#v, got %#v", exp, values)
+	}
+
+	// batched responses are tagged too
+	ress, err := c.(Batcher).Batch(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values := readAll(ress[0]); !reflect.DeepEqual(values, exp) {
+		t.Errorf("batch: expected %#v, got %#v", exp, values)
+	}
+
+	// frame decoders get the tagged frames
+	req, err = cmds.NewRequest(ContextWithFrameDecoder(context.Background(), func(frame json.RawMessage) (interface{}, error) {
+		return string(frame), nil
+	}), []string{"add"}, nil, nil, nil, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = c.(*client).send(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames := []interface{}{`{"Type":"output","Name":"progress","Value":{"Bytes":10}}`, `{"Name":"a"}`}
+	if values := readAll(res); !reflect.DeepEqual(values, frames) {
+		t.Errorf("frames: expected %#v, got %#v", frames, values)
+	}
+}
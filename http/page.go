@@ -0,0 +1,72 @@
+package http
+
+import (
+	"errors"
+	"io"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+// sendAllPages sends req without the all option, and keeps requesting the
+// next page as long as the server reports that there are more entries. It
+// returns a response with the entries of all pages.
+func (c *client) sendAllPages(req *cmds.Request) (cmds.Response, error) {
+	preq := *req
+	preq.Options = make(cmds.OptMap, len(req.Options))
+	for k, v := range req.Options {
+		preq.Options[k] = v
+	}
+	delete(preq.Options, cmds.AllOpt)
+
+	// send the first page synchronously, so errors connecting to the
+	// server are returned
+	res, err := c.send(&preq)
+	if err != nil {
+		return nil, err
+	}
+
+	re, allRes := cmds.NewChanResponsePair(req)
+	go func() {
+		offset := cmds.GetPage(&preq).Offset
+		for {
+			var n int
+			for {
+				v, err := res.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					re.CloseWithError(err)
+					return
+				}
+				if err := re.Emit(v); err != nil {
+					return
+				}
+				n++
+			}
+
+			tr := cmds.GetTrailer(res)
+			switch {
+			case tr == nil || tr.Page == nil || !tr.Page.More:
+				re.Close()
+				return
+			case tr.Page.NextCursor != "":
+				preq.SetOption(cmds.CursorOpt, tr.Page.NextCursor)
+			case n == 0:
+				re.CloseWithError(errors.New("server reported more entries but sent none"))
+				return
+			default:
+				offset += n
+				preq.SetOption(cmds.OffsetOpt, offset)
+			}
+
+			res, err = c.send(&preq)
+			if err != nil {
+				re.CloseWithError(err)
+				return
+			}
+		}
+	}()
+
+	return allRes, nil
+}
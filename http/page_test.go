@@ -0,0 +1,115 @@
+package http
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+func TestAllPages(t *testing.T) {
+	entries := []string{"a", "b", "c", "d", "e"}
+	const maxPage = 2
+
+	root := &cmds.Command{
+		Options: []cmds.Option{
+			cmds.OptionEncodingType,
+			cmds.OptionLimit,
+			cmds.OptionOffset,
+			cmds.OptionCursor,
+			cmds.OptionAll,
+		},
+		Subcommands: map[string]*cmds.Command{
+			// pages by offset, at most maxPage entries at a time
+			"offset": {
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+					p := cmds.GetPage(req)
+					if p.All {
+						return cmds.Errorf(cmds.ErrImplementation, "all option sent to the server")
+					}
+					if p.Limit == 0 || p.Limit > maxPage {
+						p.Limit = maxPage
+					}
+					start, end, info := p.Slice(len(entries))
+					for _, e := range entries[start:end] {
+						if err := re.Emit(e); err != nil {
+							return err
+						}
+					}
+					cmds.SetPage(re, info)
+					return nil
+				},
+				Type: "",
+			},
+			// pages by cursor, one entry at a time
+			"cursor": {
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+					var i int
+					if c := cmds.GetPage(req).Cursor; c != "" {
+						var err error
+						if i, err = strconv.Atoi(c); err != nil {
+							return err
+						}
+					}
+					if err := re.Emit(entries[i]); err != nil {
+						return err
+					}
+					if i+1 < len(entries) {
+						cmds.SetPage(re, cmds.PageInfo{More: true, NextCursor: strconv.Itoa(i + 1)})
+					}
+					return nil
+				},
+				Type: "",
+			},
+		},
+	}
+
+	srv := httptest.NewServer(NewHandler(nil, root, originCfg(defaultOrigins)))
+	defer srv.Close()
+
+	for _, name := range []string{"offset", "cursor"} {
+		for _, all := range []bool{true, false} {
+			req, err := cmds.NewRequest(context.Background(), []string{name}, cmds.OptMap{cmds.AllOpt: all}, nil, nil, root)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			re, res := cmds.NewChanResponsePair(req)
+			go func() {
+				if err := NewClient(srv.URL).Execute(req, re, nil); err != nil {
+					re.CloseWithError(err)
+				}
+			}()
+
+			var got []string
+			for {
+				v, err := res.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, *v.(*string))
+			}
+
+			exp := entries
+			if !all {
+				exp = entries[:maxPage]
+				if name == "cursor" {
+					exp = entries[:1]
+				}
+				if tr := cmds.GetTrailer(res); tr == nil || tr.Page == nil || !tr.Page.More {
+					t.Errorf("%s: expected page info reporting more entries, got %+v", name, tr)
+				}
+			}
+			if !reflect.DeepEqual(got, exp) {
+				t.Errorf("%s, all=%v: expected %v, got %v", name, all, exp, got)
+			}
+		}
+	}
+}
@@ -1,10 +1,10 @@
 package http
 
 import (
+	"crypto/rand"
 	"encoding/base32"
 	"fmt"
 	"io/ioutil"
-	"math/rand"
 	"mime"
 	"net/http"
 	"strconv"
@@ -16,27 +16,27 @@ import (
 	logging "github.com/ipfs/go-log"
 )
 
-// parseRequest parses the data in a http.Request and returns a command Request object
-func parseRequest(r *http.Request, root *cmds.Command) (*cmds.Request, error) {
-	if r.URL.Path[0] == '/' {
-		r.URL.Path = r.URL.Path[1:]
-	}
-
+// resolveCommand returns the command a request path without the API path
+// is for, and its path in root. Paths ending in a string that isn't a
+// subcommand pass it as an argument, e.g. /objects/Qabc12345 passes
+// "Qabc12345" to the "objects" command. It returns ErrNotFound if there is
+// no command at that path, or it can't be run remotely.
+func resolveCommand(root *cmds.Command, urlPath string) ([]string, *cmds.Command, *string, error) {
 	var (
-		stringArgs []string
-		pth        = strings.Split(r.URL.Path, "/")
-		getPath    = pth[:len(pth)-1]
+		arg     *string
+		pth     = strings.Split(strings.TrimPrefix(urlPath, "/"), "/")
+		getPath = pth[:len(pth)-1]
 	)
 
 	cmdPath, err := root.Resolve(getPath)
 	if err != nil {
 		// 404 if there is no command at that path
-		return nil, ErrNotFound
+		return nil, nil, nil, ErrNotFound
 	}
 
 	for _, c := range cmdPath {
 		if c.NoRemote {
-			return nil, ErrNotFound
+			return nil, nil, nil, ErrNotFound
 		}
 	}
 
@@ -45,19 +45,35 @@ func parseRequest(r *http.Request, root *cmds.Command) (*cmds.Request, error) {
 
 	if sub == nil {
 		if cmd.Run == nil {
-			return nil, ErrNotFound
+			return nil, nil, nil, ErrNotFound
 		}
 
-		// if the last string in the path isn't a subcommand, use it as an argument
-		// e.g. /objects/Qabc12345 (we are passing "Qabc12345" to the "objects" command)
-		stringArgs = append(stringArgs, pth[len(pth)-1])
+		arg = &pth[len(pth)-1]
 		pth = pth[:len(pth)-1]
 	} else {
 		cmd = sub
 	}
 
 	if cmd.NoRemote {
-		return nil, ErrNotFound
+		return nil, nil, nil, ErrNotFound
+	}
+	return pth, cmd, arg, nil
+}
+
+// parseRequest parses the data in a http.Request and returns a command Request object
+func parseRequest(r *http.Request, root *cmds.Command) (*cmds.Request, error) {
+	if r.URL.Path[0] == '/' {
+		r.URL.Path = r.URL.Path[1:]
+	}
+
+	var stringArgs []string
+	pth, cmd, arg, err := resolveCommand(root, r.URL.Path)
+	if err != nil {
+		return nil, err
+	}
+	if arg != nil {
+		// the last string in the path isn't a subcommand, it's an argument
+		stringArgs = append(stringArgs, *arg)
 	}
 
 	opts := make(map[string]interface{})
@@ -74,11 +90,18 @@ func parseRequest(r *http.Request, root *cmds.Command) (*cmds.Request, error) {
 		} else {
 			optDef, ok := optDefs[k]
 			if !ok {
+				if err := root.CheckOption(pth, k); err != nil {
+					return nil, err
+				}
 				opts[k] = v[0]
 				continue
 			}
 
 			name := optDef.Names()[0]
+			if cmds.IsDeprecatedName(optDef, k) {
+				// NewRequest renames it, with a warning
+				name = k
+			}
 			opts[name] = v
 
 			switch optType := optDef.Type(); optType {
@@ -94,9 +117,20 @@ func parseRequest(r *http.Request, root *cmds.Command) (*cmds.Request, error) {
 			}
 		}
 	}
-	// default to setting encoding to JSON
+	// default to the encoding the Accept header asks for, or that of the
+	// command
 	if _, ok := opts[cmds.EncLong]; !ok {
-		opts[cmds.EncLong] = cmds.JSON
+		opts[cmds.EncLong] = string(defaultEncoding(cmd))
+		if negotiatesEncoding(r) {
+			opts[cmds.EncLong] = string(negotiateEncoding(r.Header.Get(acceptHeader), cmd))
+		}
+	}
+	// the encodings of the command are those of its output, the client
+	// asks for the values in the wire encoding and encodes them itself
+	if enc, ok := opts[cmds.EncLong].(string); ok && cmds.EncodingType(enc) != wireEncoding(cmd) {
+		if err := cmd.CheckEncoding(cmds.EncodingType(enc)); err != nil {
+			return nil, err
+		}
 	}
 
 	// count required argument definitions
@@ -162,10 +196,16 @@ func parseRequest(r *http.Request, root *cmds.Command) (*cmds.Request, error) {
 
 	// if there is a required filearg, error if no files were provided
 	if len(requiredFile) > 0 && f == nil {
-		return nil, fmt.Errorf("file argument '%s' is required", requiredFile)
+		var verr ValidationError
+		verr.Add(requiredFile, "file argument is required")
+		return nil, &verr
 	}
 
-	ctx := logging.ContextWithLoggable(r.Context(), uuidLoggable())
+	reqID := newRequestID()
+	ctx := logging.ContextWithLoggable(r.Context(), logging.Metadata{
+		"requestId": reqID,
+	})
+	ctx = cmds.ContextWithRequestID(ctx, reqID)
 	req, err := cmds.NewRequest(ctx, pth, opts, args, f, root)
 	if err != nil {
 		return nil, err
@@ -180,13 +220,17 @@ func parseRequest(r *http.Request, root *cmds.Command) (*cmds.Request, error) {
 	return req, err
 }
 
-// parseResponse decodes a http.Response to create a cmds.Response
-func parseResponse(httpRes *http.Response, req *cmds.Request) (cmds.Response, error) {
+// parseResponse decodes a http.Response to create a cmds.Response. warnings
+// are added to the response before those the server sent.
+func parseResponse(httpRes *http.Response, req *cmds.Request, warnings ...string) (cmds.Response, error) {
 	res := &Response{
-		res: httpRes,
-		req: req,
-		rr:  &responseReader{httpRes},
+		res:      httpRes,
+		req:      req,
+		rr:       &responseReader{httpRes},
+		protocol: responseProtocol(httpRes),
 	}
+	res.addWarnings(warnings...)
+	res.addWarnings(httpRes.Header.Values(WarningHeader)...)
 
 	lengthHeader := httpRes.Header.Get(extraContentLengthHeader)
 	if len(lengthHeader) > 0 {
@@ -195,21 +239,35 @@ func parseResponse(httpRes *http.Response, req *cmds.Request) (cmds.Response, er
 			return nil, err
 		}
 		res.length = length
+	} else if httpRes.Header.Get(streamHeader) != "" && httpRes.ContentLength > 0 {
+		// proxies may drop the extra header, but not the real one
+		res.length = uint64(httpRes.ContentLength)
 	}
 
 	contentType := httpRes.Header.Get(contentTypeHeader)
 	contentType = strings.Split(contentType, ";")[0]
 
-	encType, found := MIMEEncodings[contentType]
+	encType, found := cmds.EncodingOfMIMEType(contentType)
+	var cmd *cmds.Command
+	if req != nil {
+		cmd = req.Command
+	}
+	if wire := wireEncoding(cmd); wire != cmds.JSON && contentType == plainText &&
+		httpRes.StatusCode < http.StatusBadRequest && httpRes.Header.Get(streamHeader) == "" {
+		// values in encodings of commands without a MIME type are sent
+		// as text
+		encType, found = wire, true
+	}
 	if found {
-		makeDec, ok := cmds.Decoders[encType]
+		makeDec, ok := cmd.Decoder(encType)
 		if ok {
 			res.dec = makeDec(res.rr)
-		} else if encType != "text" {
-			log.Errorf("could not find decoder for encoding %q", encType)
+			res.encType = encType
+		} else if encType != cmds.Text && encType != cmds.Raw {
+			log.Error("could not find decoder for encoding", "encoding", encType)
 		} // else we have an io.Reader, which is okay
 	} else {
-		log.Errorf("could not guess encoding from content type %q", contentType)
+		log.Error("could not guess encoding from content type", "contentType", contentType)
 	}
 
 	// If we ran into an error
@@ -220,7 +278,7 @@ func parseResponse(httpRes *http.Response, req *cmds.Request) (cmds.Response, er
 		case httpRes.StatusCode == http.StatusNotFound:
 			// handle 404s
 			e.Message = "Command not found."
-			e.Code = cmds.ErrClient
+			e.Code = cmds.ErrNotFound
 		case contentType == plainText:
 			// handle non-marshalled errors
 			mes, err := ioutil.ReadAll(res.rr)
@@ -228,37 +286,28 @@ func parseResponse(httpRes *http.Response, req *cmds.Request) (cmds.Response, er
 				return nil, err
 			}
 			e.Message = string(mes)
-			switch httpRes.StatusCode {
-			case http.StatusNotFound, http.StatusBadRequest:
-				e.Code = cmds.ErrClient
-			case http.StatusTooManyRequests:
-				e.Code = cmds.ErrRateLimited
-			case http.StatusForbidden:
-				e.Code = cmds.ErrForbidden
-			default:
-				e.Code = cmds.ErrNormal
-			}
+			e.Code = statusErrorType(httpRes.StatusCode)
 		case res.dec == nil:
 			return nil, fmt.Errorf("unknown error content type: %s", contentType)
 		default:
 			// handle errors from value
 			err := res.dec.Decode(e)
 			if err != nil {
-				log.Errorf("error parsing error %q", err.Error())
+				log.Error("error parsing error", "error", err)
 			}
 		}
 
 		return nil, e
 	}
 
-	return res, nil
+	return cmds.DecorateResponse(res), nil
 }
 
-func uuidLoggable() logging.Loggable {
+// newRequestID returns a random ID. The IDs of sessions and uploads grant
+// access to them, so they come from crypto/rand.
+func newRequestID() string {
 	ids := make([]byte, 16)
 	rand.Read(ids)
 
-	return logging.Metadata{
-		"requestId": base32.HexEncoding.EncodeToString(ids),
-	}
+	return base32.HexEncoding.EncodeToString(ids)
 }
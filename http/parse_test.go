@@ -213,3 +213,58 @@ func TestParseResponse(t *testing.T) {
 		tc.test(t)
 	}
 }
+
+func TestParseRequestEncoding(t *testing.T) {
+	root := &cmds.Command{
+		Subcommands: map[string]*cmds.Command{
+			"cat": {
+				ForbiddenEncodings: []cmds.EncodingType{cmds.Text},
+			},
+		},
+	}
+
+	for enc, ok := range map[string]bool{"": true, "json": true, "text": false} {
+		httpReq, err := http.NewRequest("POST", "http://127.0.0.1:5001/cat", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if enc != "" {
+			httpReq.URL.RawQuery = url.Values{cmds.EncLong: {enc}}.Encode()
+		}
+
+		_, err = parseRequest(httpReq, root)
+		if ok && err != nil {
+			t.Errorf("encoding %q: unexpected error: %s", enc, err)
+		}
+		if e, isErr := err.(cmds.Error); !ok && (!isErr || e.Code != cmds.ErrClient) {
+			t.Errorf("encoding %q: expected client error, got %v", enc, err)
+		}
+	}
+}
+
+func TestParseRequestRenamedOption(t *testing.T) {
+	root := &cmds.Command{
+		Subcommands: map[string]*cmds.Command{
+			"paint": {
+				Options: []cmds.Option{
+					cmds.RenamedOption(cmds.StringOption("color", "a color"), "colour"),
+				},
+			},
+		},
+	}
+
+	httpReq, err := http.NewRequest("POST", "http://127.0.0.1:5001/paint?colour=red", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := parseRequest(httpReq, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Options["color"] != "red" {
+		t.Errorf("expected the option to be renamed, got %v", req.Options)
+	}
+	if len(req.Warnings()) != 1 {
+		t.Errorf("expected a deprecation warning, got %q", req.Warnings())
+	}
+}
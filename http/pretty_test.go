@@ -0,0 +1,105 @@
+package http
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+func TestPrettyJSON(t *testing.T) {
+	type entry struct {
+		Name string
+	}
+	root := &cmds.Command{
+		Options: []cmds.Option{cmds.OptionEncodingType},
+		Subcommands: map[string]*cmds.Command{
+			"get": {
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+					return cmds.EmitOnce(re, &entry{Name: "a"})
+				},
+				Type: entry{},
+			},
+		},
+	}
+
+	for _, strict := range []bool{false, true} {
+		srvCfg := originCfg(defaultOrigins)
+		srvCfg.StrictValidation = strict
+		srv := httptest.NewServer(NewHandler(nil, root, srvCfg))
+
+		httpRes, err := http.Post(srv.URL+"/get?enc=json&enc-pretty=true", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(httpRes.Body)
+		httpRes.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exp := "{\n  \"Name\": \"a\"\n}\n"; string(body) != exp {
+			t.Errorf("strict=%t: expected %q, got %q", strict, exp, body)
+		}
+
+		// the client doesn't send it, and decodes the values as usual
+		req, err := cmds.NewRequest(context.Background(), []string{"get"}, cmds.OptMap{cmds.EncPretty: true}, nil, nil, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		httpReq, err := NewClient(srv.URL).(*client).toHTTPRequest(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if httpReq.URL.Query().Get(cmds.EncPretty) != "" {
+			t.Errorf("the client sent %s", cmds.EncPretty)
+		}
+
+		srv.Close()
+	}
+}
+
+func TestCanonicalJSON(t *testing.T) {
+	root := &cmds.Command{
+		Options: []cmds.Option{cmds.OptionEncodingType},
+		Subcommands: map[string]*cmds.Command{
+			"get": {
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+					return cmds.EmitOnce(re, map[string]interface{}{"b": 1.50, "a": "<x>"})
+				},
+			},
+		},
+	}
+	srvCfg := originCfg(defaultOrigins)
+	srvCfg.StrictValidation = true
+	srv := httptest.NewServer(NewHandler(nil, root, srvCfg))
+	defer srv.Close()
+
+	httpRes, err := http.Post(srv.URL+"/get?enc=json&enc-canonical=true", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(httpRes.Body)
+	httpRes.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := `{"a":"<x>","b":1.5}` + "\n"; string(body) != exp {
+		t.Errorf("expected %q, got %q", exp, body)
+	}
+
+	// the client doesn't send it
+	req, err := cmds.NewRequest(context.Background(), []string{"get"}, cmds.OptMap{cmds.EncCanonical: true}, nil, nil, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpReq, err := NewClient(srv.URL).(*client).toHTTPRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if httpReq.URL.Query().Get(cmds.EncCanonical) != "" {
+		t.Errorf("the client sent %s", cmds.EncCanonical)
+	}
+}
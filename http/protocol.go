@@ -0,0 +1,100 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+// Versions of the wire protocol, the framing of requests and responses.
+// Clients send the version they speak in a header, and handlers answer with
+// the version of the response, the lowest of theirs and the client's, so
+// changes to the framing roll out without breaking clients and servers of
+// other versions. Clients and servers that don't send the header speak
+// version 1.
+//
+// Version 2 tags the values of output types, see cmds.OutputType, and sends
+// cmds.Progress, cmds.Warning and cmds.Heartbeat values, and the errors of
+// commands that already emitted values as cmds.Error values, before the
+// trailer. Those errors are also sent JSON encoded in the StreamErrHeader
+// trailer. Version 1 responses send the values of output types as they are,
+// leave Progress, Warning and Heartbeat values out, and send just the message
+// of errors in the StreamErrHeader trailer, and their code in the
+// StreamErrCodeHeader one.
+const (
+	// ProtocolVersion is the version this package speaks.
+	ProtocolVersion = 2
+	// MinProtocolVersion is the oldest version handlers still answer.
+	MinProtocolVersion = 1
+)
+
+const protocolHeader = "X-Protocol-Version"
+
+// negotiateProtocol returns the version of the protocol of the response to
+// r, and an error if the handler doesn't speak that of the client anymore.
+func negotiateProtocol(r *http.Request) (int, error) {
+	s := r.Header.Get(protocolHeader)
+	if s == "" {
+		return 1, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < 1 {
+		return 0, fmt.Errorf("invalid %s header: %q", protocolHeader, s)
+	}
+	if v < MinProtocolVersion {
+		return 0, fmt.Errorf("protocol version %d is not supported anymore, the oldest supported version is %d", v, MinProtocolVersion)
+	}
+	if v > ProtocolVersion {
+		v = ProtocolVersion
+	}
+	return v, nil
+}
+
+// withProtocol negotiates the protocol of the response to r, and announces it
+// in the headers of w. The returned request carries the compatibility
+// settings of older versions.
+func withProtocol(w http.ResponseWriter, r *http.Request) (*http.Request, error) {
+	v, err := negotiateProtocol(r)
+	if err != nil {
+		return r, err
+	}
+	w.Header().Set(protocolHeader, strconv.Itoa(v))
+	r = r.WithContext(context.WithValue(r.Context(), protocolKey{}, v))
+	if v < 2 {
+		r = r.WithContext(cmds.ContextWithoutTags(r.Context()))
+	}
+	return r, nil
+}
+
+type protocolKey struct{}
+
+// requestProtocol returns the version of the protocol negotiated for the
+// request of ctx, ProtocolVersion if it wasn't negotiated.
+func requestProtocol(ctx context.Context) int {
+	if v, ok := ctx.Value(protocolKey{}).(int); ok {
+		return v
+	}
+	return ProtocolVersion
+}
+
+// responseProtocol returns the version of the protocol of res, 1 for servers
+// that don't announce it.
+func responseProtocol(res *http.Response) int {
+	v, err := strconv.Atoi(res.Header.Get(protocolHeader))
+	if err != nil || v < 1 {
+		return 1
+	}
+	return v
+}
+
+// ProtocolVersionOf returns the version of the wire protocol of res, a
+// response returned by a client of this package, or 0 if it isn't one.
+func ProtocolVersionOf(res cmds.Response) int {
+	if r, ok := res.(interface{ ProtocolVersion() int }); ok {
+		return r.ProtocolVersion()
+	}
+	return 0
+}
@@ -0,0 +1,96 @@
+package http
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+func TestProtocolNegotiation(t *testing.T) {
+	root := &cmds.Command{
+		Subcommands: map[string]*cmds.Command{
+			"add": {
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+					if err := re.Emit(&cmds.Progress{BytesDone: 10}); err != nil {
+						return err
+					}
+					if err := re.Emit(&addProgress{Bytes: 10}); err != nil {
+						return err
+					}
+					return re.Emit(&addResult{Name: "a"})
+				},
+				Type:        addResult{},
+				OutputTypes: []cmds.OutputType{{Name: "progress", Type: addProgress{}}},
+			},
+		},
+	}
+	cfg := originCfg(defaultOrigins)
+	cfg.ServeCommandTree = true
+	srv := httptest.NewServer(NewHandler(nil, root, cfg))
+	defer srv.Close()
+
+	post := func(version string) (*http.Response, string) {
+		req, err := http.NewRequest(http.MethodPost, srv.URL+"/add", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if version != "" {
+			req.Header.Set(protocolHeader, version)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		return res, string(body)
+	}
+
+	// clients without the header get the framing of version 1
+	res, body := post("")
+	if v := res.Header.Get(protocolHeader); v != "1" {
+		t.Errorf("expected version 1, got %q", v)
+	}
+	if exp := "{\"Bytes\":10}\n{\"Name\":\"a\"}\n"; body != exp {
+		t.Errorf("expected untagged values %q, got %q", exp, body)
+	}
+
+	// newer clients are answered with the version of the handler
+	res, body = post("3")
+	if v := res.Header.Get(protocolHeader); v != "2" {
+		t.Errorf("expected version 2, got %q", v)
+	}
+	if !strings.Contains(body, `"Type":"progress"`) || !strings.Contains(body, `"Type":"output"`) {
+		t.Errorf("expected tagged values, got %q", body)
+	}
+
+	res, _ = post("x")
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected invalid versions to be rejected, got status %d", res.StatusCode)
+	}
+
+	c := NewClient(srv.URL, ClientWithHandshake("")).(*client)
+	req, err := cmds.NewRequest(context.Background(), []string{"add"}, nil, nil, nil, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cres, err := c.send(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := ProtocolVersionOf(cres); v != ProtocolVersion {
+		t.Errorf("expected the client to speak version %d, got %d", ProtocolVersion, v)
+	}
+	caps, err := c.Capabilities(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if caps.ProtocolVersion != ProtocolVersion {
+		t.Errorf("expected the handshake to report version %d, got %d", ProtocolVersion, caps.ProtocolVersion)
+	}
+}
@@ -0,0 +1,78 @@
+package http
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+func TestReadOnly(t *testing.T) {
+	root := &cmds.Command{
+		Subcommands: map[string]*cmds.Command{
+			"get": {
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+					return cmds.EmitOnce(re, "value")
+				},
+				Type: "",
+			},
+			"set": {
+				Mutating: true,
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+					return cmds.EmitOnce(re, "done")
+				},
+				Type: "",
+			},
+		},
+	}
+
+	type testcase struct {
+		readOnly bool
+		path     string
+		err      bool
+	}
+
+	tcs := []testcase{
+		{readOnly: false, path: "get"},
+		{readOnly: false, path: "set"},
+		{readOnly: true, path: "get"},
+		{readOnly: true, path: "set", err: true},
+	}
+
+	for _, tc := range tcs {
+		srvCfg := originCfg(defaultOrigins)
+		srvCfg.ReadOnly = tc.readOnly
+		srvCfg.AllowBatch = true
+		srv := httptest.NewServer(NewHandler(nil, root, srvCfg))
+
+		req, err := cmds.NewRequest(context.Background(), []string{tc.path}, nil, nil, nil, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c := NewClient(srv.URL)
+		res, err := c.(*client).send(req)
+		if err == nil {
+			_, err = res.Next()
+		}
+		batchRes, batchErr := c.(Batcher).Batch(context.Background(), req)
+		if batchErr == nil {
+			_, batchErr = batchRes[0].Next()
+		}
+		srv.Close()
+
+		for _, err := range []error{err, batchErr} {
+			if !tc.err {
+				if err != nil {
+					t.Errorf("read-only %v, %s: unexpected error: %s", tc.readOnly, tc.path, err)
+				}
+				continue
+			}
+			e, ok := err.(*cmds.Error)
+			if !ok || e.Code != cmds.ErrForbidden {
+				t.Errorf("read-only %v, %s: expected forbidden error, got %#v", tc.readOnly, tc.path, err)
+			}
+		}
+	}
+}
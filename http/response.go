@@ -1,23 +1,16 @@
 package http
 
 import (
-	"errors"
+	"encoding/json"
 	"io"
 	"net/http"
 	"reflect"
+	"strconv"
 	"strings"
 
 	"github.com/fgeth/fg-ipfs-cmds"
 )
 
-var (
-	MIMEEncodings = map[string]cmds.EncodingType{
-		"application/json": cmds.JSON,
-		"application/xml":  cmds.XML,
-		"text/plain":       cmds.Text,
-	}
-)
-
 type Response struct {
 	length uint64
 	err    error
@@ -25,10 +18,60 @@ type Response struct {
 	res *http.Response
 	req *cmds.Request
 
-	rr  *responseReader
-	dec cmds.Decoder
+	rr      *responseReader
+	dec     cmds.Decoder
+	encType cmds.EncodingType
 
 	initErr *cmds.Error
+
+	trailer *cmds.Trailer
+
+	// warnings are the warnings received and not yet returned by
+	// NextWarning, of the nWarnings received in all.
+	warnings  []string
+	nWarnings int
+
+	// protocol is the version of the wire protocol of the response.
+	protocol int
+}
+
+// NextWarning returns the next warning received so far, sent in headers, in
+// the stream or in trailers, see cmds.NextWarning.
+func (res *Response) NextWarning() (string, bool) {
+	if len(res.warnings) == 0 {
+		return "", false
+	}
+	w := res.warnings[0]
+	res.warnings = res.warnings[1:]
+	return w, true
+}
+
+func (res *Response) addWarnings(ws ...string) {
+	res.warnings = append(res.warnings, ws...)
+	res.nWarnings += len(ws)
+}
+
+// ProtocolVersion returns the version of the wire protocol the server
+// answered with, see ProtocolVersion.
+func (res *Response) ProtocolVersion() int {
+	return res.protocol
+}
+
+// Trailer returns the trailer sent after the last value, if any. The exit
+// status sent in the ExitStatusHeader trailer is added to it.
+func (res *Response) Trailer() *cmds.Trailer {
+	if res.res == nil {
+		return res.trailer
+	}
+	code, err := strconv.Atoi(res.res.Trailer.Get(ExitStatusHeader))
+	if err != nil || code == 0 {
+		return res.trailer
+	}
+	if res.trailer == nil {
+		res.trailer = &cmds.Trailer{}
+	}
+	res.trailer.ExitStatus = code
+	return res.trailer
 }
 
 func (res *Response) Request() *cmds.Request {
@@ -75,22 +118,47 @@ func (res *Response) Next() (interface{}, error) {
 		return rr, nil
 	}
 
+	// decode into raw frames for the frame decoder of the request, if any
+	frameDec := frameDecoder(res.req.Context)
+	if !res.encType.IsJSON() || res.encType == cmds.MergePatch {
+		frameDec = nil
+	}
+
 	var value interface{}
-	if valueType := reflect.TypeOf(res.req.Command.Type); valueType != nil {
+	if frameDec != nil {
+		value = new(json.RawMessage)
+	} else if valueType := reflect.TypeOf(res.req.Command.Type); valueType != nil {
 		if valueType.Kind() == reflect.Ptr {
 			valueType = valueType.Elem()
 		}
 		value = reflect.New(valueType).Interface()
 	}
 
-	m := &cmds.MaybeError{Value: value}
+	m := &cmds.MaybeError{Value: value, Outputs: res.req.Command.OutputTypes}
 	err := res.dec.Decode(m)
+	if err == nil && m.IsTrailer() {
+		res.trailer = m.Trailer
+		// the trailer lists all warnings, those received already first
+		if ws := m.Trailer.Warnings; len(ws) > res.nWarnings {
+			res.addWarnings(ws[res.nWarnings:]...)
+		}
+		return res.Next()
+	}
+	if err == nil && m.IsWarning() {
+		res.addWarnings(m.Warning.Message)
+		return res.Next()
+	}
+	if err == nil && m.IsHeartbeat() {
+		return res.Next()
+	}
 	if err != nil {
 		if err == io.EOF {
+			// warnings the stream couldn't carry follow it
+			res.addWarnings(res.res.Trailer.Values(WarningHeader)...)
+
 			// handle errors from headers
-			errStr := res.res.Header.Get(StreamErrHeader)
-			if errStr != "" {
-				err = &cmds.Error{Message: errStr}
+			if res.res.Header.Get(StreamErrHeader) != "" {
+				err = decodeStreamError(res.res.Header)
 			}
 
 			res.err = err
@@ -109,9 +177,36 @@ func (res *Response) Next() (interface{}, error) {
 		} else {
 			res.err = &cmds.Error{Message: err.Error()}
 		}
+		res.readTrailer()
+		return v, err
+	}
+
+	if frameDec != nil {
+		v, err = frameDec(*v.(*json.RawMessage))
+		if err != nil {
+			res.err = err
+			return nil, err
+		}
 	}
 
-	return v, err
+	return v, nil
+}
+
+// readTrailer reads the trailer sent after an error in the stream, if any.
+func (res *Response) readTrailer() {
+	for {
+		m := &cmds.MaybeError{}
+		if err := res.dec.Decode(m); err != nil {
+			return
+		}
+		if m.IsTrailer() {
+			res.trailer = m.Trailer
+			if ws := m.Trailer.Warnings; len(ws) > res.nWarnings {
+				res.addWarnings(ws[res.nWarnings:]...)
+			}
+			return
+		}
+	}
 }
 
 // responseReader reads from the response body, and checks for an error
@@ -143,12 +238,25 @@ func (r *responseReader) Read(b []byte) (int, error) {
 }
 
 func (r *responseReader) checkError() error {
-	if e := r.resp.Trailer.Get(StreamErrHeader); e != "" {
-		return errors.New(e)
+	if r.resp.Trailer.Get(StreamErrHeader) != "" {
+		return decodeStreamError(r.resp.Trailer)
 	}
 	return nil
 }
 
+// decodeStreamError returns the error sent in the StreamErrHeader trailer of
+// h. Servers of version 1 of the protocol send just the message, and the
+// code in the StreamErrCodeHeader trailer.
+func decodeStreamError(h http.Header) error {
+	s := h.Get(StreamErrHeader)
+	e := &cmds.Error{}
+	if err := json.Unmarshal([]byte(s), e); err != nil {
+		code, _ := strconv.Atoi(h.Get(StreamErrCodeHeader))
+		return &cmds.Error{Message: s, Code: cmds.ErrorType(code)}
+	}
+	return e
+}
+
 func (r *responseReader) Close() error {
 	return r.resp.Body.Close()
 }
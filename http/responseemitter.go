@@ -1,28 +1,25 @@
 package http
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	cmds "github.com/fgeth/fg-ipfs-cmds"
 )
 
 var (
 	// AllowedExposedHeadersArr defines the default Access-Control-Expose-Headers.
-	AllowedExposedHeadersArr = []string{streamHeader, channelHeader, extraContentLengthHeader}
+	AllowedExposedHeadersArr = []string{streamHeader, channelHeader, extraContentLengthHeader, requestIDHeader, protocolHeader, WarningHeader}
 	// AllowedExposedHeaders is the list of defaults Access-Control-Expose-Headers separated by comma.
 	AllowedExposedHeaders = strings.Join(AllowedExposedHeadersArr, ", ")
-
-	mimeTypes = map[cmds.EncodingType]string{
-		cmds.Protobuf: "application/protobuf",
-		cmds.JSON:     "application/json",
-		cmds.XML:      "application/xml",
-		cmds.Text:     "text/plain",
-	}
 )
 
 // NewResponseEmitter returns a new ResponseEmitter.
@@ -31,12 +28,19 @@ func NewResponseEmitter(w http.ResponseWriter, method string, req *cmds.Request,
 	if err != nil {
 		return nil, err
 	}
+	envelope, _ := req.Options[cmds.EnvelopeOpt].(bool)
 	re := &responseEmitter{
-		w:       w,
-		encType: encType,
-		enc:     enc,
-		method:  method,
-		req:     req,
+		w:        w,
+		encType:  encType,
+		enc:      enc,
+		method:   method,
+		req:      req,
+		protocol: requestProtocol(req.Context),
+		envelope: envelope,
+		trailer: cmds.Trailer{
+			RequestID: cmds.RequestID(req.Context),
+			Start:     time.Now(),
+		},
 	}
 
 	// apply functional options
@@ -44,6 +48,11 @@ func NewResponseEmitter(w http.ResponseWriter, method string, req *cmds.Request,
 		opt(re)
 	}
 
+	// heartbeats aren't values to clients of version 1 of the protocol
+	if re.heartbeat > 0 && re.protocol >= 2 && re.encType.IsJSON() && method != http.MethodHead {
+		go re.sendHeartbeats(re.heartbeat)
+	}
+
 	return re, nil
 }
 
@@ -61,6 +70,14 @@ func withRequestBodyEOFChan(ch <-chan struct{}) ResponseEmitterOption {
 	}
 }
 
+// withServerVersion returns a ResponseEmitterOption that sets the server
+// version reported in the trailer.
+func withServerVersion(version string) ResponseEmitterOption {
+	return func(re *responseEmitter) {
+		re.trailer.ServerVersion = version
+	}
+}
+
 // ResponseEmitter interface defines the components that can care of sending
 // the response to HTTP Requests.
 type ResponseEmitter interface {
@@ -80,10 +97,39 @@ type responseEmitter struct {
 
 	bodyEOFChan <-chan struct{}
 
+	// protocol is the version of the wire protocol of the response.
+	protocol int
+
+	// envelope is set if the trailer should be sent after the last value.
+	envelope bool
+	trailer  cmds.Trailer
+	// lateWarnings are the warnings sent after the response started that
+	// the stream can't carry. They are sent in WarningHeader trailers.
+	lateWarnings []string
+
+	// errStatus is the status of the error the emitter was closed with,
+	// determined before it was converted to a *cmds.Error.
+	errStatus int
+
 	streaming bool
 	closed    bool
 	once      sync.Once
 	method    string
+
+	// started is set once the headers were written.
+	started bool
+
+	// flow is set for flow-controlled responses.
+	flow *flowStream
+
+	// heartbeat is the interval of heartbeats on idle responses, if set.
+	heartbeat time.Duration
+	// lastSend is the time the last value or heartbeat was sent.
+	lastSend time.Time
+
+	// limits are the limits of the response; counter counts its bytes.
+	limits  responseLimits
+	counter *countingResponseWriter
 }
 
 func (re *responseEmitter) Emit(value interface{}) error {
@@ -97,6 +143,18 @@ func (re *responseEmitter) Emit(value interface{}) error {
 
 	re.once.Do(func() { re.preamble(value) })
 
+	if re.flow != nil && value != nil && re.method != http.MethodHead {
+		v := value
+		if single, ok := v.(cmds.Single); ok {
+			v = single.Value
+		}
+		if _, isReader := v.(io.Reader); !isReader {
+			if err := re.flow.acquire(re.req.Context); err != nil {
+				return err
+			}
+		}
+	}
+
 	re.l.Lock()
 	defer re.l.Unlock()
 
@@ -122,6 +180,10 @@ func (re *responseEmitter) Emit(value interface{}) error {
 		isSingle = true
 	}
 
+	if re.limits.exceeded(re.trailer.Count, re.written()) {
+		return re.truncate()
+	}
+
 	if f, ok := re.w.(http.Flusher); ok {
 		defer f.Flush()
 	}
@@ -130,10 +192,18 @@ func (re *responseEmitter) Emit(value interface{}) error {
 	case error:
 		return re.closeWithError(v)
 	case io.Reader:
-		err = flushCopy(re.w, v)
+		if re.limits.bytes > 0 && re.counter != nil {
+			err = re.copyLimited(v)
+		} else {
+			err = flushCopy(re.w, v)
+		}
 	default:
 		err = re.enc.Encode(value)
+		if err == nil {
+			re.trailer.Count++
+		}
 	}
+	re.lastSend = time.Now()
 
 	if isSingle && err == nil {
 		// only close when there were no encoding errors
@@ -148,7 +218,7 @@ func (re *responseEmitter) SetLength(l uint64) {
 	defer re.l.Unlock()
 
 	h := re.w.Header()
-	h.Set("X-Content-Length", strconv.FormatUint(l, 10))
+	h.Set(extraContentLengthHeader, strconv.FormatUint(l, 10))
 
 	re.length = l
 }
@@ -181,9 +251,17 @@ func (re *responseEmitter) closeWithError(err error) error {
 		case cmds.Error:
 			err = &e
 		case *cmds.Error:
+		case *cmds.ValidationError:
+			re.errStatus = statusOf(err, cmds.ErrClient)
+			err = &cmds.Error{Message: e.Error(), Code: cmds.ErrClient, Fields: e.Fields}
 		case nil:
 		default:
-			err = &cmds.Error{Message: err.Error(), Code: cmds.ErrNormal}
+			code := cmds.ErrNormal
+			if errors.Is(err, context.DeadlineExceeded) || re.req.Context.Err() == context.DeadlineExceeded {
+				code = cmds.ErrTimeout
+			}
+			re.errStatus = statusOf(err, code)
+			err = &cmds.Error{Message: err.Error(), Code: code, HelpURL: cmds.HelpURL(err)}
 		}
 	}
 
@@ -198,8 +276,42 @@ func (re *responseEmitter) closeWithError(err error) error {
 		setErrTrailer = false
 	})
 
+	// errors of commands that already emitted values are sent in the stream
+	// too, to clients that don't read HTTP trailers, like browsers
+	if setErrTrailer && err != nil && re.sendStreamError() {
+		if eerr := re.enc.Encode(err); eerr != nil {
+			cmds.ContextLogger(re.req.Context, log).Error("error sending stream error", "error", eerr)
+		}
+	}
+
+	// the trailer is encoded before the encoder is finished, so it is part
+	// of JSON arrays, see cmds.OptionStreamArray
+	bodyTrailer := re.sendTrailer()
+	if bodyTrailer {
+		re.trailer.End = time.Now()
+		if err := re.enc.Encode(re.trailer); err != nil {
+			cmds.ContextLogger(re.req.Context, log).Error("error sending response trailer", "error", err)
+		}
+	}
+
+	// errors sent in the preamble are the whole body
+	if (setErrTrailer || err == nil) && re.method != http.MethodHead {
+		if ferr := cmds.FinishEncoder(re.enc, err); ferr != nil {
+			cmds.ContextLogger(re.req.Context, log).Error("error finishing response", "error", ferr)
+		}
+	}
+
 	if setErrTrailer && err != nil {
-		re.w.Header().Set(StreamErrHeader, err.Error())
+		re.setStreamError(err.(*cmds.Error))
+	}
+	if code := re.trailer.ExitStatus; code != 0 && re.method != http.MethodHead {
+		re.w.Header().Set(http.TrailerPrefix+ExitStatusHeader, strconv.Itoa(code))
+	}
+	// the trailer in the body lists them already
+	if !bodyTrailer {
+		for _, msg := range re.lateWarnings {
+			re.w.Header().Add(http.TrailerPrefix+WarningHeader, headerValue(msg))
+		}
 	}
 
 	re.closed = true
@@ -207,6 +319,98 @@ func (re *responseEmitter) closeWithError(err error) error {
 	return nil
 }
 
+// setStreamError sets the StreamErrHeader trailer, and the
+// StreamErrCodeHeader one for clients of version 1 of the protocol.
+func (re *responseEmitter) setStreamError(err *cmds.Error) {
+	if re.protocol < 2 {
+		re.w.Header().Set(StreamErrHeader, err.Message)
+		if err.Code != cmds.ErrNormal {
+			re.w.Header().Set(StreamErrCodeHeader, strconv.Itoa(int(err.Code)))
+		}
+		return
+	}
+	re.w.Header().Set(StreamErrHeader, encodeStreamError(err))
+}
+
+// encodeStreamError returns the value of the StreamErrHeader trailer for err.
+func encodeStreamError(err *cmds.Error) string {
+	b, jerr := json.Marshal(err)
+	if jerr != nil {
+		// details that can't be encoded are dropped rather than the error
+		b, _ = json.Marshal(&cmds.Error{Message: err.Message, Code: err.Code, HelpURL: err.HelpURL})
+	}
+	return string(b)
+}
+
+// sendTrailer returns whether the trailer should be written to the body.
+// Readers and errors sent in the preamble are never followed by a trailer.
+func (re *responseEmitter) sendTrailer() bool {
+	if !(re.envelope || re.trailer.Page != nil) || re.closed || re.streaming || re.method == http.MethodHead {
+		return false
+	}
+	return re.encType.IsJSON() || re.encType == cmds.XML
+}
+
+// sendStreamError returns whether errors of commands that already emitted
+// values are written to the body, as cmds.Error values in the JSON streams
+// of clients that understand them, see ProtocolVersion.
+func (re *responseEmitter) sendStreamError() bool {
+	return re.protocol >= 2 && re.encType.IsJSON() && !re.closed && !re.streaming && re.method != http.MethodHead
+}
+
+// Warn sends a warning: in a WarningHeader if the response hasn't started
+// yet, as a cmds.Warning value in the JSON streams of clients that
+// understand them, see ProtocolVersion, and in a WarningHeader trailer
+// otherwise, e.g. for Text responses. Warnings are also recorded in the
+// trailer of the body, if it is sent, where older clients find all of them.
+func (re *responseEmitter) Warn(msg string) {
+	re.l.Lock()
+	defer re.l.Unlock()
+
+	re.trailer.Warnings = append(re.trailer.Warnings, msg)
+	if re.closed || re.method == http.MethodHead {
+		return
+	}
+	if !re.started {
+		re.w.Header().Add(WarningHeader, headerValue(msg))
+		return
+	}
+	if !re.encType.IsJSON() || re.streaming || cmds.TagsDisabled(re.req.Context) {
+		re.lateWarnings = append(re.lateWarnings, msg)
+		return
+	}
+	if err := re.enc.Encode(cmds.Warning{Message: msg}); err != nil {
+		cmds.ContextLogger(re.req.Context, log).Error("error sending warning", "error", err)
+		return
+	}
+	if f, ok := re.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// headerValue returns msg on a single line, as header values can't span
+// several.
+func headerValue(msg string) string {
+	return strings.Join(strings.Fields(msg), " ")
+}
+
+// SetStatus records the exit status, which is sent in the ExitStatusHeader
+// trailer, and in the trailer of the body.
+func (re *responseEmitter) SetStatus(code int) {
+	re.l.Lock()
+	defer re.l.Unlock()
+
+	re.trailer.ExitStatus = code
+}
+
+// SetPage records the page info, which is sent in the trailer.
+func (re *responseEmitter) SetPage(p cmds.PageInfo) {
+	re.l.Lock()
+	defer re.l.Unlock()
+
+	re.trailer.Page = &p
+}
+
 // Flush the http connection
 func (re *responseEmitter) Flush() {
 	re.once.Do(func() { re.preamble(nil) })
@@ -225,27 +429,29 @@ func (re *responseEmitter) preamble(value interface{}) {
 
 func (re *responseEmitter) sendErr(err *cmds.Error) {
 	// Handle error encoding. *Try* to obey the requested encoding, fallback
-	// on json.
+	// on json. Protobuf streams only carry the messages of the command, and
+	// raw ones the bytes it emits.
 	encType := re.encType
 	enc, ok := cmds.Encoders[encType]
-	if !ok {
+	if !ok || encType == cmds.Protobuf || encType == cmds.Raw {
 		encType = cmds.JSON
 		enc = cmds.Encoders[encType]
 	}
 
 	// Set the appropriate MIME Type
-	re.w.Header().Set(contentTypeHeader, mimeTypes[encType])
+	mime, _ := cmds.MIMEType(encType)
+	re.w.Header().Set(contentTypeHeader, mime)
 
-	// Set the status from the error code.
-	status := http.StatusInternalServerError
-	if err.Code == cmds.ErrClient {
-		status = http.StatusBadRequest
+	// Set the status from the error.
+	status := re.errStatus
+	if status == 0 {
+		status = statusOf(err, cmds.ErrNormal)
 	}
 	re.w.WriteHeader(status)
 
 	// Finally, send the errr
 	if err := enc(re.req)(re.w).Encode(err); err != nil {
-		log.Error("error sending error value after non-200 response", err)
+		cmds.ContextLogger(re.req.Context, log).Error("error sending error value after non-200 response", "error", err)
 	}
 
 	re.closed = true
@@ -256,6 +462,7 @@ func (re *responseEmitter) doPreamble(value interface{}) {
 		h    = re.w.Header()
 		mime string
 	)
+	re.started = true
 
 	// Common Headers
 
@@ -266,6 +473,9 @@ func (re *responseEmitter) doPreamble(value interface{}) {
 
 	// Set up our potential trailer
 	h.Set("Trailer", StreamErrHeader)
+	if re.protocol < 2 {
+		h.Add("Trailer", StreamErrCodeHeader)
+	}
 
 	// If we have a request body, make sure we close the body
 	// if we want to write before completing reading.
@@ -288,15 +498,34 @@ func (re *responseEmitter) doPreamble(value interface{}) {
 		re.sendErr(v)
 		return
 	case io.Reader:
-		// set streams output type to text to avoid issues with browsers rendering
-		// html pages on priveleged api ports
 		h.Set(streamHeader, "1")
 		re.streaming = true
 
-		mime = "text/plain"
+		mime = re.readerMIMEType()
 	case cmds.Single:
-		// don't set stream/channel header
+		// don't set channel header
+		if _, ok := v.Value.(io.Reader); !ok {
+			break
+		}
+
+		// a single reader is the whole body, so its length can be sent as
+		// Content-Length. Trailers are lost then: errors while copying
+		// cut the response short instead. Readers longer than the byte
+		// limit are sent without it, so their truncation is signaled.
+		h.Set(streamHeader, "1")
+		re.streaming = true
+		if re.length > 0 && (re.limits.bytes == 0 || re.length <= re.limits.bytes) {
+			h.Set(contentLengthHeader, strconv.FormatUint(re.length, 10))
+		}
+
+		mime = re.readerMIMEType()
 	default:
+		if re.encType == cmds.Raw {
+			// the values are written one after the other, as a stream
+			h.Set(streamHeader, "1")
+			re.streaming = true
+			break
+		}
 		h.Set(channelHeader, "1")
 	}
 
@@ -304,7 +533,7 @@ func (re *responseEmitter) doPreamble(value interface{}) {
 		var ok bool
 
 		// lookup mime type from map
-		mime, ok = mimeTypes[re.encType]
+		mime, ok = cmds.MIMEType(re.encType)
 		if !ok {
 			// catch-all, set to text as default
 			mime = "text/plain"
@@ -313,9 +542,23 @@ func (re *responseEmitter) doPreamble(value interface{}) {
 
 	h.Set(contentTypeHeader, mime)
 
+	if id := re.trailer.RequestID; id != "" {
+		h.Set(requestIDHeader, id)
+	}
+
 	re.w.WriteHeader(http.StatusOK)
 }
 
+// readerMIMEType returns the MIME type of responses streaming a reader:
+// text, to avoid issues with browsers rendering html pages on privileged API
+// ports, unless raw output was requested.
+func (re *responseEmitter) readerMIMEType() string {
+	if re.encType == cmds.Raw {
+		return applicationOctetStream
+	}
+	return plainText
+}
+
 func flushCopy(w io.Writer, r io.Reader) error {
 	buf := make([]byte, 4096)
 	f, ok := w.(http.Flusher)
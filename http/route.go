@@ -0,0 +1,107 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+// Route is the HTTP route of a command, for middleware wrapping the handler,
+// e.g. to label metrics by command rather than by URL, or to make decisions
+// based on the Extra values of the command.
+type Route struct {
+	// Pattern is the path the command is served at, including the APIPath
+	// of the server, e.g. /api/v0/pin/add. Commands that take arguments
+	// also accept one as an extra path segment, which isn't part of it.
+	Pattern string
+	// Path is the path of the command in the tree, e.g. ["pin", "add"].
+	Path []string
+	// Command is the command served.
+	Command *cmds.Command
+}
+
+// Value returns the value of key in the Extra of the command, see
+// cmds.Extra.SetValue.
+func (rt Route) Value(key interface{}) (interface{}, bool) {
+	return rt.Command.Extra.GetValue(key)
+}
+
+// Routes returns the routes of the commands served, sorted by pattern.
+// Commands that can't be run remotely, and those without a Run function,
+// have none.
+func (h *Handler) Routes() []Route {
+	var routes []Route
+	var walk func(pth []string, cmd *cmds.Command)
+	walk = func(pth []string, cmd *cmds.Command) {
+		if cmd.NoRemote {
+			return
+		}
+		if cmd.Run != nil {
+			routes = append(routes, h.route(pth, cmd))
+		}
+		for name, sub := range cmd.Subcommands {
+			walk(append(pth[:len(pth):len(pth)], name), sub)
+		}
+	}
+	walk(nil, h.Root())
+
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].Pattern < routes[j].Pattern
+	})
+	return routes
+}
+
+// Route returns the route of the command r is for, as the handler resolves
+// it. It returns false if r isn't for a command, e.g. if no command is
+// served at its path.
+func (h *Handler) Route(r *http.Request) (Route, bool) {
+	urlPath := r.URL.Path
+	if prefix := h.h.cfg.APIPath; prefix != "" {
+		if !strings.HasPrefix(urlPath, prefix) {
+			return Route{}, false
+		}
+		urlPath = strings.TrimPrefix(urlPath, prefix)
+	}
+
+	pth, cmd, _, err := resolveCommand(h.Root(), urlPath)
+	if err != nil {
+		return Route{}, false
+	}
+	return h.route(pth, cmd), true
+}
+
+func (h *Handler) route(pth []string, cmd *cmds.Command) Route {
+	return Route{
+		Pattern: h.h.cfg.APIPath + "/" + strings.Join(pth, "/"),
+		Path:    pth,
+		Command: cmd,
+	}
+}
+
+type routeKey struct{}
+
+// RouteFromContext returns the route added to ctx by RouteMiddleware.
+func RouteFromContext(ctx context.Context) (Route, bool) {
+	rt, ok := ctx.Value(routeKey{}).(Route)
+	return rt, ok
+}
+
+// RouteMiddleware returns middleware adding the route of requests for
+// commands of h to their context, for the middleware it wraps to read with
+// RouteFromContext. It fits the middleware chains of routers like chi or
+// gorilla/mux, and is meant to be first:
+//
+//	r := chi.NewRouter()
+//	r.Use(h.RouteMiddleware, metrics)
+//	r.Handle("/api/v0/*", h)
+func (h *Handler) RouteMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rt, ok := h.Route(r); ok {
+			r = r.WithContext(context.WithValue(r.Context(), routeKey{}, rt))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
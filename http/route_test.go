@@ -0,0 +1,93 @@
+package http
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+func TestRoutes(t *testing.T) {
+	type scopeKey struct{}
+
+	run := func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+		return cmds.EmitOnce(re, "ok")
+	}
+	root := &cmds.Command{
+		Subcommands: map[string]*cmds.Command{
+			"pin": {
+				Subcommands: map[string]*cmds.Command{
+					"add": {
+						Run:   run,
+						Extra: (&cmds.Extra{}).SetValue(scopeKey{}, "write"),
+					},
+					"ls": {Run: run},
+				},
+			},
+			"cat": {
+				Arguments: []cmds.Argument{cmds.StringArg("path", true, false, "")},
+				Run:       run,
+			},
+			"local": {Run: run, NoRemote: true},
+		},
+	}
+
+	cfg := originCfg(defaultOrigins)
+	cfg.APIPath = "/api/v0"
+	h := NewHandler(nil, root, cfg)
+
+	var patterns []string
+	for _, rt := range h.Routes() {
+		patterns = append(patterns, rt.Pattern)
+	}
+	expected := []string{"/api/v0/cat", "/api/v0/pin/add", "/api/v0/pin/ls"}
+	if !reflect.DeepEqual(patterns, expected) {
+		t.Errorf("expected routes %q, got %q", expected, patterns)
+	}
+
+	// middleware sees the route of the command, arguments in the path
+	// included, and its extra values
+	var seen []string
+	var scope interface{}
+	scoped := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rt, ok := RouteFromContext(r.Context())
+			if !ok {
+				seen = append(seen, "")
+			} else {
+				seen = append(seen, rt.Pattern)
+				if v, ok := rt.Value(scopeKey{}); ok {
+					scope = v
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+	srv := httptest.NewServer(h.RouteMiddleware(scoped(h)))
+	defer srv.Close()
+
+	for _, p := range []string{"/api/v0/pin/add", "/api/v0/cat/Qabc", "/api/v0/local", "/api/v0/nope", "/other"} {
+		httpReq, err := http.NewRequest("POST", srv.URL+p, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		httpReq.Header.Set("Origin", "http://localhost")
+		httpRes, err := http.DefaultClient.Do(httpReq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.ReadAll(httpRes.Body)
+		httpRes.Body.Close()
+	}
+
+	expected = []string{"/api/v0/pin/add", "/api/v0/cat", "", "", ""}
+	if !reflect.DeepEqual(seen, expected) {
+		t.Errorf("expected middleware to see routes %q, got %q", expected, seen)
+	}
+	if scope != "write" {
+		t.Errorf("expected the extra value of pin add, got %v", scope)
+	}
+}
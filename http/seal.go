@@ -0,0 +1,534 @@
+package http
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SealedPath is the path, relative to the API prefix, of the endpoint
+// accepting sealed requests. It is only served if ServerConfig.PayloadKey is
+// set.
+//
+// A sealed request wraps a complete command request: its path, query,
+// headers and body are encrypted with the pre-shared payload key, and so is
+// the response, including status, headers and trailers. Relays between
+// client and server only see that a sealed request was made, and how much
+// data was exchanged. A sealed request is served at most once, and only
+// within five minutes of the time it was sealed at, so relays can't replay
+// it; the clocks of client and server must agree to within that.
+const SealedPath = "sealed"
+
+const (
+	// sealIDLen is the length of the random exchange ID that starts the
+	// body of a sealed request. It binds the response to the request.
+	sealIDLen = 16
+
+	// maxSealedChunk is the maximum payload size of a frame.
+	maxSealedChunk = 64 << 10
+
+	// sealWindow is how far the time a request was sealed at may be from
+	// the time of the server. The server remembers the exchange IDs it
+	// served for that long, so a captured sealed request can't be replayed:
+	// within the window its ID is known, and after it the request is stale.
+	sealWindow = 5 * time.Minute
+
+	sealDirRequest  = 'q'
+	sealDirResponse = 's'
+)
+
+// ErrInvalidSealed is returned when a sealed message can't be decrypted or
+// is malformed, e.g. because the keys of client and server differ or the
+// message was tampered with.
+var ErrInvalidSealed = errors.New("invalid sealed message")
+
+// frame kinds, sent as the first byte of the plaintext of a frame
+const (
+	sealHead byte = iota
+	sealData
+	sealEnd
+)
+
+// sealedHead is the payload of the first frame of a sealed message.
+type sealedHead struct {
+	// URL is the path and query of a request, relative to the API prefix.
+	URL    string `json:",omitempty"`
+	Status int    `json:",omitempty"`
+	Header http.Header
+	// Time is the Unix time a request was sealed at, see sealWindow.
+	Time int64 `json:",omitempty"`
+}
+
+// newPayloadAEAD returns the cipher used to seal payloads with key.
+func newPayloadAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid payload key: %s", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// frameCodec encrypts or decrypts the frames of one direction of an
+// exchange. Every frame is authenticated along with the exchange ID, the
+// direction and its sequence number, so frames can't be dropped, reordered
+// or moved to another exchange unnoticed.
+//
+// On the wire, a frame is the 4-byte big-endian length of the sealed data,
+// followed by the random nonce and the ciphertext.
+type frameCodec struct {
+	aead cipher.AEAD
+	id   []byte
+	dir  byte
+	seq  uint64
+}
+
+func (c *frameCodec) additionalData() []byte {
+	ad := make([]byte, 1+len(c.id)+8)
+	ad[0] = c.dir
+	copy(ad[1:], c.id)
+	binary.BigEndian.PutUint64(ad[1+len(c.id):], c.seq)
+	c.seq++
+	return ad
+}
+
+func (c *frameCodec) writeFrame(w io.Writer, kind byte, data []byte) error {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	plain := append([]byte{kind}, data...)
+	sealed := c.aead.Seal(nonce, nonce, plain, c.additionalData())
+
+	frame := make([]byte, 4, 4+len(sealed))
+	binary.BigEndian.PutUint32(frame, uint32(len(sealed)))
+	_, err := w.Write(append(frame, sealed...))
+	return err
+}
+
+// writeData writes data as one or more data frames.
+func (c *frameCodec) writeData(w io.Writer, data []byte) error {
+	for len(data) > 0 {
+		n := len(data)
+		if n > maxSealedChunk {
+			n = maxSealedChunk
+		}
+		if err := c.writeFrame(w, sealData, data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+func (c *frameCodec) readFrame(r io.Reader) (byte, []byte, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		if err == io.EOF {
+			// the end frame is missing
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, nil, err
+	}
+
+	n := binary.BigEndian.Uint32(size[:])
+	ns := uint32(c.aead.NonceSize())
+	if n < ns+uint32(c.aead.Overhead())+1 || n > ns+uint32(c.aead.Overhead())+1+maxSealedChunk {
+		return 0, nil, ErrInvalidSealed
+	}
+
+	sealed := make([]byte, n)
+	if _, err := io.ReadFull(r, sealed); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, nil, err
+	}
+
+	plain, err := c.aead.Open(nil, sealed[:ns], sealed[ns:], c.additionalData())
+	if err != nil {
+		return 0, nil, ErrInvalidSealed
+	}
+	return plain[0], plain[1:], nil
+}
+
+// readHead reads the head frame that starts every sealed message.
+func (c *frameCodec) readHead(r io.Reader) (*sealedHead, error) {
+	kind, data, err := c.readFrame(r)
+	if err != nil {
+		return nil, err
+	}
+	if kind != sealHead {
+		return nil, ErrInvalidSealed
+	}
+
+	var head sealedHead
+	if err := json.Unmarshal(data, &head); err != nil {
+		return nil, ErrInvalidSealed
+	}
+	return &head, nil
+}
+
+// sealedBody decrypts the data frames following the head of a sealed
+// message.
+type sealedBody struct {
+	c   *frameCodec
+	r   io.ReadCloser
+	buf []byte
+	err error
+
+	// onEnd is called with the payload of the end frame.
+	onEnd func(data []byte) error
+}
+
+func (b *sealedBody) Read(p []byte) (int, error) {
+	for len(b.buf) == 0 && b.err == nil {
+		kind, data, err := b.c.readFrame(b.r)
+		switch {
+		case err != nil:
+			b.err = err
+		case kind == sealData:
+			b.buf = data
+		case kind == sealEnd:
+			b.err = io.EOF
+			if b.onEnd != nil {
+				if err := b.onEnd(data); err != nil {
+					b.err = err
+				}
+			}
+		default:
+			b.err = ErrInvalidSealed
+		}
+	}
+
+	if len(b.buf) > 0 {
+		n := copy(p, b.buf)
+		b.buf = b.buf[n:]
+		return n, nil
+	}
+	return 0, b.err
+}
+
+func (b *sealedBody) Close() error {
+	return b.r.Close()
+}
+
+// sealedResponseWriter seals everything written to it, including the status
+// and headers, and writes it to the response of the sealed request.
+type sealedResponseWriter struct {
+	w      http.ResponseWriter
+	c      *frameCodec
+	header http.Header
+
+	wroteHeader bool
+	err         error
+}
+
+func (sw *sealedResponseWriter) Header() http.Header {
+	return sw.header
+}
+
+func (sw *sealedResponseWriter) WriteHeader(status int) {
+	if sw.wroteHeader {
+		return
+	}
+	sw.wroteHeader = true
+
+	data, err := json.Marshal(sealedHead{Status: status, Header: sw.header.Clone()})
+	if err != nil {
+		sw.err = err
+		return
+	}
+	sw.err = sw.c.writeFrame(sw.w, sealHead, data)
+}
+
+func (sw *sealedResponseWriter) Write(p []byte) (int, error) {
+	sw.WriteHeader(http.StatusOK)
+	if sw.err != nil {
+		return 0, sw.err
+	}
+	if sw.err = sw.c.writeData(sw.w, p); sw.err != nil {
+		return 0, sw.err
+	}
+	return len(p), nil
+}
+
+func (sw *sealedResponseWriter) Flush() {
+	if f, ok := sw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// finish writes the end frame, carrying the trailers.
+func (sw *sealedResponseWriter) finish() error {
+	sw.WriteHeader(http.StatusOK)
+	if sw.err != nil {
+		return sw.err
+	}
+
+	trailer := make(http.Header)
+	for _, keys := range sw.header.Values("Trailer") {
+		for _, k := range strings.Split(keys, ",") {
+			k = http.CanonicalHeaderKey(strings.TrimSpace(k))
+			if v, ok := sw.header[k]; ok {
+				trailer[k] = v
+			}
+		}
+	}
+	for k, v := range sw.header {
+		if strings.HasPrefix(k, http.TrailerPrefix) {
+			trailer[strings.TrimPrefix(k, http.TrailerPrefix)] = v
+		}
+	}
+
+	data, err := json.Marshal(trailer)
+	if err != nil {
+		return err
+	}
+	return sw.c.writeFrame(sw.w, sealEnd, data)
+}
+
+// exchangeLog remembers the IDs of the sealed exchanges served within
+// sealWindow.
+type exchangeLog struct {
+	l         sync.Mutex
+	expiries  map[string]time.Time
+	nextPrune time.Time
+}
+
+func newExchangeLog() *exchangeLog {
+	return &exchangeLog{expiries: make(map[string]time.Time)}
+}
+
+// add records the exchange id until expiry. It returns false if id was
+// recorded already.
+func (el *exchangeLog) add(id []byte, expiry time.Time) bool {
+	el.l.Lock()
+	defer el.l.Unlock()
+
+	now := time.Now()
+	if now.After(el.nextPrune) {
+		for k, e := range el.expiries {
+			if now.After(e) {
+				delete(el.expiries, k)
+			}
+		}
+		el.nextPrune = now.Add(sealWindow)
+	}
+
+	if _, ok := el.expiries[string(id)]; ok {
+		return false
+	}
+	el.expiries[string(id)] = expiry
+	return true
+}
+
+type sealedKey struct{}
+
+// isSealed reports whether r was unwrapped from a sealed request.
+func isSealed(r *http.Request) bool {
+	sealed, _ := r.Context().Value(sealedKey{}).(bool)
+	return sealed
+}
+
+// serveSealed unwraps a sealed request, serves it and seals the response.
+func (h *handler) serveSealed(w http.ResponseWriter, r *http.Request) {
+	id := make([]byte, sealIDLen)
+	if _, err := io.ReadFull(r.Body, id); err != nil {
+		http.Error(w, ErrInvalidSealed.Error(), http.StatusBadRequest)
+		return
+	}
+
+	in := &frameCodec{aead: h.aead, id: id, dir: sealDirRequest}
+	head, err := in.readHead(r.Body)
+	if err != nil {
+		h.logger().Debug("rejected sealed request", "error", err)
+		http.Error(w, ErrInvalidSealed.Error(), http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	sealedAt := time.Unix(head.Time, 0)
+	if sealedAt.Before(now.Add(-sealWindow)) || sealedAt.After(now.Add(sealWindow)) {
+		h.logger().Debug("rejected stale sealed request", "sealed", sealedAt)
+		http.Error(w, ErrInvalidSealed.Error(), http.StatusBadRequest)
+		return
+	}
+	if !h.exchanges.add(id, sealedAt.Add(sealWindow)) {
+		h.logger().Debug("rejected replayed sealed request")
+		http.Error(w, ErrInvalidSealed.Error(), http.StatusBadRequest)
+		return
+	}
+
+	u, err := url.ParseRequestURI(head.URL)
+	if err != nil || strings.Trim(u.Path, "/") == SealedPath {
+		http.Error(w, ErrInvalidSealed.Error(), http.StatusBadRequest)
+		return
+	}
+
+	inner := r.Clone(context.WithValue(r.Context(), sealedKey{}, true))
+	inner.Method = http.MethodPost
+	inner.URL = u
+	inner.RequestURI = u.RequestURI()
+	for k, v := range head.Header {
+		inner.Header[k] = v
+	}
+	inner.Body = &sealedBody{c: in, r: r.Body}
+	inner.ContentLength = -1
+
+	w.Header().Set(contentTypeHeader, applicationOctetStream)
+	sw := &sealedResponseWriter{
+		w:      w,
+		c:      &frameCodec{aead: h.aead, id: id, dir: sealDirResponse},
+		header: make(http.Header),
+	}
+	h.ServeHTTP(sw, inner)
+	if err := sw.finish(); err != nil {
+		h.logger().Error("error sealing response", "error", err)
+	}
+}
+
+// ClientWithPayloadKey makes the client seal its requests with key, see
+// SealedPath. The server has to be configured with the same key. key must
+// be 16, 24 or 32 bytes long, selecting AES-128, AES-192 or AES-256 in GCM
+// mode.
+//
+// Only command requests are sealed; batches, sessions and the capability
+// handshake are sent as usual.
+func ClientWithPayloadKey(key []byte) ClientOpt {
+	return func(c *client) {
+		c.payloadKey = key
+	}
+}
+
+// sealRequest wraps httpReq in a sealed request. The returned function
+// unwraps the response.
+func (c *client) sealRequest(httpReq *http.Request) (*http.Request, func(*http.Response) (*http.Response, error), error) {
+	aead, err := newPayloadAEAD(c.payloadKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	id := make([]byte, sealIDLen)
+	if _, err := rand.Read(id); err != nil {
+		return nil, nil, err
+	}
+
+	u := *httpReq.URL
+	u.Path = strings.TrimPrefix(u.Path, c.apiPrefix)
+	head, err := json.Marshal(sealedHead{URL: u.RequestURI(), Header: httpReq.Header, Time: time.Now().Unix()})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeSealedRequest(pw, &frameCodec{aead: aead, id: id, dir: sealDirRequest}, head, httpReq.Body))
+	}()
+
+	outer, err := http.NewRequest("POST", c.serverAddress+c.apiPrefix+"/"+SealedPath, pr)
+	if err != nil {
+		pr.Close()
+		return nil, nil, err
+	}
+	outer.Header.Set(contentTypeHeader, applicationOctetStream)
+	outer.Header.Set(uaHeader, c.ua)
+	c.setAuth(outer)
+	outer = outer.WithContext(httpReq.Context())
+	outer.Close = true
+
+	open := func(res *http.Response) (*http.Response, error) {
+		return openSealedResponse(res, &frameCodec{aead: aead, id: id, dir: sealDirResponse})
+	}
+	return outer, open, nil
+}
+
+func writeSealedRequest(w io.Writer, c *frameCodec, head []byte, body io.ReadCloser) error {
+	if _, err := w.Write(c.id); err != nil {
+		return err
+	}
+	if err := c.writeFrame(w, sealHead, head); err != nil {
+		return err
+	}
+
+	if body != nil {
+		defer body.Close()
+
+		buf := make([]byte, maxSealedChunk)
+		for {
+			n, err := body.Read(buf)
+			if n > 0 {
+				if err := c.writeData(w, buf[:n]); err != nil {
+					return err
+				}
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return c.writeFrame(w, sealEnd, nil)
+}
+
+// openSealedResponse unwraps the response to a sealed request.
+func openSealedResponse(res *http.Response, c *frameCodec) (*http.Response, error) {
+	if res.StatusCode != http.StatusOK {
+		// the sealed request failed as a whole, e.g. because the server
+		// doesn't accept sealed requests
+		defer res.Body.Close()
+		msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+		return nil, fmt.Errorf("sealed request failed: %s: %s", res.Status, strings.TrimSpace(string(msg)))
+	}
+
+	head, err := c.readHead(res.Body)
+	if err != nil {
+		res.Body.Close()
+		return nil, err
+	}
+
+	inner := &http.Response{
+		Status:        fmt.Sprintf("%d %s", head.Status, http.StatusText(head.Status)),
+		StatusCode:    head.Status,
+		Proto:         res.Proto,
+		ProtoMajor:    res.ProtoMajor,
+		ProtoMinor:    res.ProtoMinor,
+		Header:        head.Header,
+		Trailer:       make(http.Header),
+		ContentLength: -1,
+		Request:       res.Request,
+	}
+	if inner.Header == nil {
+		inner.Header = make(http.Header)
+	}
+	inner.Body = &sealedBody{
+		c: c,
+		r: res.Body,
+		onEnd: func(data []byte) error {
+			var trailer http.Header
+			if err := json.Unmarshal(data, &trailer); err != nil {
+				return ErrInvalidSealed
+			}
+			for k, v := range trailer {
+				inner.Trailer[k] = v
+			}
+			return nil
+		},
+	}
+	return inner, nil
+}
@@ -0,0 +1,184 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+// wireRecorder records the bodies of the requests and responses passing
+// through it, the way an untrusted relay would see them.
+type wireRecorder struct {
+	h http.Handler
+
+	l    sync.Mutex
+	wire bytes.Buffer
+	// tamper is called with every response frame before it is sent.
+	tamper func([]byte) []byte
+}
+
+type recordingWriter struct {
+	http.ResponseWriter
+	rec *wireRecorder
+}
+
+func (w recordingWriter) Write(p []byte) (int, error) {
+	w.rec.l.Lock()
+	if w.rec.tamper != nil {
+		p = w.rec.tamper(append([]byte(nil), p...))
+	}
+	w.rec.wire.Write(p)
+	w.rec.l.Unlock()
+	return w.ResponseWriter.Write(p)
+}
+
+func (w recordingWriter) Flush() {
+	w.ResponseWriter.(http.Flusher).Flush()
+}
+
+func (rec *wireRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	rec.l.Lock()
+	rec.wire.WriteString(r.URL.String())
+	rec.wire.Write(body)
+	rec.l.Unlock()
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	rec.h.ServeHTTP(recordingWriter{w, rec}, r)
+}
+
+func TestSealed(t *testing.T) {
+	env, _ := getTestServer(t, nil, false)
+	key := []byte("0123456789abcdef0123456789abcdef")
+
+	srvCfg := originCfg(defaultOrigins)
+	srvCfg.PayloadKey = key
+	srvCfg.RequireSealed = true
+	rec := &wireRecorder{h: NewHandler(env, cmdRoot, srvCfg)}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	send := func(c cmds.Executor, path string) (interface{}, error) {
+		req, err := cmds.NewRequest(context.Background(), []string{path}, nil, nil, nil, cmdRoot)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := c.(*client).send(req)
+		if err != nil {
+			return nil, err
+		}
+		v, err := res.Next()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := res.Next(); err != io.EOF {
+			return v, err
+		}
+		return v, nil
+	}
+
+	c := NewClient(srv.URL, ClientWithPayloadKey(key))
+	v, err := send(c, "version")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.(*VersionOutput).Version != "0.1.2" {
+		t.Fatalf("unexpected version %v", v)
+	}
+
+	// errors in the response and in the trailer arrive
+	if _, err := send(c, "error"); err == nil || err.Error() != "an error occurred" {
+		t.Errorf("expected error, got %v", err)
+	}
+	if _, err := send(c, "lateerror"); err == nil || err.Error() != "an error occurred" {
+		t.Errorf("expected trailer error, got %v", err)
+	}
+
+	rec.l.Lock()
+	wire := rec.wire.String()
+	rec.l.Unlock()
+	for _, s := range []string{"version", "0.1.2", "c0mm17", "lateerror", "an error occurred"} {
+		if strings.Contains(wire, s) {
+			t.Errorf("relay saw %q", s)
+		}
+	}
+
+	// plaintext requests are rejected
+	if _, err := send(NewClient(srv.URL), "version"); err == nil || !strings.Contains(err.Error(), "must be sealed") {
+		t.Errorf("expected plaintext request to be rejected, got %v", err)
+	}
+
+	// a client with a different key can't talk to the server
+	other := NewClient(srv.URL, ClientWithPayloadKey([]byte("fedcba9876543210fedcba9876543210")))
+	if _, err := send(other, "version"); err == nil || !strings.Contains(err.Error(), ErrInvalidSealed.Error()) {
+		t.Errorf("expected wrong key to fail, got %v", err)
+	}
+
+	// tampered responses are detected
+	rec.tamper = func(p []byte) []byte {
+		p[len(p)-1] ^= 1
+		return p
+	}
+	if _, err := send(c, "version"); err != ErrInvalidSealed {
+		t.Errorf("expected tampering to be detected, got %v", err)
+	}
+}
+
+func TestSealedReplay(t *testing.T) {
+	env, _ := getTestServer(t, nil, false)
+	key := []byte("0123456789abcdef0123456789abcdef")
+
+	srvCfg := originCfg(defaultOrigins)
+	srvCfg.PayloadKey = key
+	srv := httptest.NewServer(NewHandler(env, cmdRoot, srvCfg))
+	defer srv.Close()
+
+	aead, err := newPayloadAEAD(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// seal returns the body of a sealed request for version, sealed at
+	// sealedAt
+	seal := func(id string, sealedAt time.Time) []byte {
+		head, err := json.Marshal(sealedHead{URL: "/version", Header: http.Header{}, Time: sealedAt.Unix()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		c := &frameCodec{aead: aead, id: []byte(id), dir: sealDirRequest}
+		if err := writeSealedRequest(&buf, c, head, nil); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	post := func(body []byte) int {
+		t.Helper()
+		res, err := http.Post(srv.URL+"/"+SealedPath, applicationOctetStream, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	body := seal("0123456789abcdef", time.Now())
+	if code := post(body); code != http.StatusOK {
+		t.Fatalf("unexpected status %d", code)
+	}
+	if code := post(body); code != http.StatusBadRequest {
+		t.Errorf("expected a replayed request to be rejected, got status %d", code)
+	}
+	if code := post(seal("fedcba9876543210", time.Now().Add(-2*sealWindow))); code != http.StatusBadRequest {
+		t.Errorf("expected a stale request to be rejected, got status %d", code)
+	}
+}
@@ -0,0 +1,77 @@
+package http
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+func TestSelect(t *testing.T) {
+	type entry struct {
+		Name string
+		Size int
+	}
+	root := &cmds.Command{
+		Subcommands: map[string]*cmds.Command{
+			"get": {
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+					return cmds.EmitOnce(re, &entry{Name: "a", Size: 3})
+				},
+				Type: entry{},
+			},
+		},
+	}
+
+	for _, strict := range []bool{false, true} {
+		srvCfg := originCfg(defaultOrigins)
+		srvCfg.StrictValidation = strict
+		srv := httptest.NewServer(NewHandler(nil, root, srvCfg))
+
+		for query, exp := range map[string]string{
+			"/get?select=.Size":               "3\n",
+			"/get?encoding=text&select=.Name": "a",
+		} {
+			httpRes, err := http.Post(srv.URL+query, "", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := ioutil.ReadAll(httpRes.Body)
+			httpRes.Body.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != exp {
+				t.Errorf("strict=%t, %s: expected %q, got %q", strict, query, exp, body)
+			}
+		}
+
+		// invalid selectors are rejected before the command runs
+		httpRes, err := http.Post(srv.URL+"/get?select=Name", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		httpRes.Body.Close()
+		if httpRes.StatusCode != http.StatusBadRequest {
+			t.Errorf("strict=%t: expected status %d for an invalid selector, got %d", strict, http.StatusBadRequest, httpRes.StatusCode)
+		}
+
+		// the client doesn't send it, and decodes the values as usual
+		req, err := cmds.NewRequest(context.Background(), []string{"get"}, cmds.OptMap{cmds.SelectOpt: ".Name"}, nil, nil, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		httpReq, err := NewClient(srv.URL).(*client).toHTTPRequest(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if httpReq.URL.Query().Get(cmds.SelectOpt) != "" {
+			t.Errorf("the client sent %s", cmds.SelectOpt)
+		}
+
+		srv.Close()
+	}
+}
@@ -0,0 +1,337 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+const (
+	// SessionOpenPath and SessionClosePath are the paths, relative to the
+	// API prefix, of the endpoints opening and closing sessions. They are
+	// only served if ServerConfig.SessionFactory is set.
+	SessionOpenPath  = "session/open"
+	SessionClosePath = "session/close"
+
+	// DefaultSessionTTL is the default time after which idle sessions
+	// expire.
+	DefaultSessionTTL = 10 * time.Minute
+
+	// DefaultMaxSessions is the default limit of the sessions an identity
+	// has open at once.
+	DefaultMaxSessions = 16
+
+	sessionHeader = "X-Session-Id"
+)
+
+// ErrUnknownSession is returned for requests tagged with a session that
+// doesn't exist, either because it was closed or because it expired.
+var ErrUnknownSession = errors.New("unknown or expired session")
+
+var errTooManySessions = errors.New("too many open sessions")
+
+// SessionFactory creates the environment of a new session. The handler's
+// environment is passed as env. If the returned environment implements
+// io.Closer, it is closed when the session is closed or expires.
+type SessionFactory func(ctx context.Context, env cmds.Environment) (cmds.Environment, error)
+
+// SessionOpener is implemented by executors that can open sessions on the
+// server. Requests whose context carries a session ID, see
+// ContextWithSession, share the environment of that session.
+type SessionOpener interface {
+	OpenSession(ctx context.Context) (string, error)
+	CloseSession(ctx context.Context, id string) error
+}
+
+var _ SessionOpener = &client{}
+
+type sessionKey struct{}
+
+// ContextWithSession returns a copy of ctx that tags requests with the
+// session id.
+func ContextWithSession(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, sessionKey{}, id)
+}
+
+// SessionID returns the session the request with context ctx belongs to, or
+// the empty string. On the server it is set for requests that are part of a
+// session.
+func SessionID(ctx context.Context) string {
+	id, _ := ctx.Value(sessionKey{}).(string)
+	return id
+}
+
+type session struct {
+	// owner is the identity of the client that opened the session, see
+	// ServerConfig.Identify. Other clients can't use it.
+	owner  string
+	env    cmds.Environment
+	timer  *time.Timer
+	active int
+	closed bool
+}
+
+// sessionManager keeps track of the open sessions of a handler.
+type sessionManager struct {
+	factory     SessionFactory
+	ttl         time.Duration
+	maxSessions int
+
+	l        sync.Mutex
+	sessions map[string]*session
+	// opening counts the sessions of each identity whose environment is
+	// being created, which count towards the limit.
+	opening map[string]int
+}
+
+func newSessionManager(cfg *ServerConfig) *sessionManager {
+	m := &sessionManager{
+		factory:     cfg.SessionFactory,
+		ttl:         cfg.SessionTTL,
+		maxSessions: cfg.MaxSessions,
+		sessions:    make(map[string]*session),
+		opening:     make(map[string]int),
+	}
+	if m.ttl <= 0 {
+		m.ttl = DefaultSessionTTL
+	}
+	if m.maxSessions <= 0 {
+		m.maxSessions = DefaultMaxSessions
+	}
+	return m
+}
+
+// get returns session id if it belongs to owner. It is called with the lock
+// held.
+func (m *sessionManager) get(owner, id string) (*session, bool) {
+	s, ok := m.sessions[id]
+	if !ok || s.owner != owner {
+		return nil, false
+	}
+	return s, true
+}
+
+func (m *sessionManager) open(ctx context.Context, env cmds.Environment) (string, error) {
+	owner := Identity(ctx)
+
+	m.l.Lock()
+	open := m.opening[owner]
+	for _, s := range m.sessions {
+		if s.owner == owner {
+			open++
+		}
+	}
+	if open >= m.maxSessions {
+		m.l.Unlock()
+		return "", errTooManySessions
+	}
+	m.opening[owner]++
+	m.l.Unlock()
+
+	senv, err := m.factory(ctx, env)
+
+	m.l.Lock()
+	defer m.l.Unlock()
+	if m.opening[owner]--; m.opening[owner] == 0 {
+		delete(m.opening, owner)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	id := newRequestID()
+	s := &session{owner: owner, env: senv}
+	m.sessions[id] = s
+	s.timer = time.AfterFunc(m.ttl, func() { m.expire(id) })
+	return id, nil
+}
+
+// acquire returns the environment of session id, if it belongs to owner.
+// The returned function must be called once the request is done; the
+// session doesn't expire before.
+func (m *sessionManager) acquire(owner, id string) (cmds.Environment, func(), error) {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	s, ok := m.get(owner, id)
+	if !ok {
+		return nil, nil, ErrUnknownSession
+	}
+	s.active++
+	s.timer.Stop()
+
+	release := func() {
+		m.l.Lock()
+		defer m.l.Unlock()
+
+		s.active--
+		if s.active == 0 && !s.closed {
+			s.timer.Reset(m.ttl)
+		}
+	}
+	return s.env, release, nil
+}
+
+func (m *sessionManager) expire(id string) {
+	// expiry isn't tied to a request, so the handler's logger isn't
+	// available here
+	m.l.Lock()
+	s, ok := m.sessions[id]
+	if !ok || s.active > 0 {
+		m.l.Unlock()
+		return
+	}
+	delete(m.sessions, id)
+	s.closed = true
+	m.l.Unlock()
+
+	log.Debug("session expired", "session", id)
+	closeSessionEnv(s.env)
+}
+
+func (m *sessionManager) close(owner, id string) error {
+	m.l.Lock()
+	s, ok := m.get(owner, id)
+	if !ok {
+		m.l.Unlock()
+		return ErrUnknownSession
+	}
+	delete(m.sessions, id)
+	s.closed = true
+	s.timer.Stop()
+	m.l.Unlock()
+
+	// running requests keep using the environment; closing it is up to
+	// the implementation
+	return closeSessionEnv(s.env)
+}
+
+func closeSessionEnv(env cmds.Environment) error {
+	if c, ok := env.(io.Closer); ok {
+		err := c.Close()
+		if err != nil {
+			log.Error("error closing session", "error", err)
+		}
+		return err
+	}
+	return nil
+}
+
+// sessionEnv returns the environment for r. For requests outside of a
+// session, that is the handler's environment.
+func (h *handler) sessionEnv(r *http.Request) (cmds.Environment, *http.Request, func(), error) {
+	id := r.Header.Get(sessionHeader)
+	if id == "" || h.sessions == nil {
+		return h.env, r, func() {}, nil
+	}
+
+	env, release, err := h.sessions.acquire(Identity(r.Context()), id)
+	if err != nil {
+		return nil, r, nil, err
+	}
+	return env, r.WithContext(ContextWithSession(r.Context(), id)), release, nil
+}
+
+func (h *handler) serveSession(w http.ResponseWriter, r *http.Request, path string) {
+	if r.Method != http.MethodPost {
+		setAllowHeader(w, false)
+		http.Error(w, "405 - Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	for k, v := range h.cfg.Headers {
+		if !skipAPIHeader(k) {
+			w.Header()[k] = v
+		}
+	}
+
+	switch path {
+	case SessionOpenPath:
+		id, err := h.sessions.open(r.Context(), h.env)
+		switch err {
+		case nil:
+		case errTooManySessions:
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set(sessionHeader, id)
+		w.WriteHeader(http.StatusOK)
+	case SessionClosePath:
+		err := h.sessions.close(Identity(r.Context()), r.Header.Get(sessionHeader))
+		switch err {
+		case nil:
+			w.WriteHeader(http.StatusOK)
+		case ErrUnknownSession:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// OpenSession opens a new session on the server and returns its ID.
+func (c *client) OpenSession(ctx context.Context) (string, error) {
+	httpRes, err := c.sessionRequest(ctx, SessionOpenPath, "")
+	if err != nil {
+		return "", err
+	}
+
+	id := httpRes.Header.Get(sessionHeader)
+	if id == "" {
+		return "", errors.New("server did not return a session ID")
+	}
+	return id, nil
+}
+
+// CloseSession closes session id on the server.
+func (c *client) CloseSession(ctx context.Context, id string) error {
+	_, err := c.sessionRequest(ctx, SessionClosePath, id)
+	return err
+}
+
+func (c *client) sessionRequest(ctx context.Context, path, id string) (*http.Response, error) {
+	url := c.serverAddress + c.apiPrefix + "/" + path
+	httpReq, err := http.NewRequest(http.MethodPost, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set(uaHeader, c.ua)
+	c.setAuth(httpReq)
+	if id != "" {
+		httpReq.Header.Set(sessionHeader, id)
+	}
+	httpReq = httpReq.WithContext(ctx)
+
+	httpRes, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer httpRes.Body.Close()
+
+	if httpRes.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(httpRes.Body)
+		return nil, &cmds.Error{
+			Message: fmt.Sprintf("session request failed: %s", strings.TrimSpace(string(msg))),
+			Code:    cmds.ErrClient,
+		}
+	}
+	return httpRes, nil
+}
+
+// setSessionHeader tags httpReq with the session of ctx, if any.
+func setSessionHeader(httpReq *http.Request, ctx context.Context) {
+	if id := SessionID(ctx); id != "" {
+		httpReq.Header.Set(sessionHeader, id)
+	}
+}
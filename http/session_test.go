@@ -0,0 +1,207 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+type counterEnv struct {
+	l      sync.Mutex
+	n      int
+	closed chan struct{}
+}
+
+func (e *counterEnv) Close() error {
+	close(e.closed)
+	return nil
+}
+
+func TestSession(t *testing.T) {
+	root := &cmds.Command{
+		Subcommands: map[string]*cmds.Command{
+			"incr": {
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+					cenv, ok := env.(*counterEnv)
+					if !ok {
+						return cmds.Errorf(cmds.ErrClient, "not in a session")
+					}
+					if SessionID(req.Context) == "" {
+						return cmds.Errorf(cmds.ErrImplementation, "session id not set")
+					}
+					cenv.l.Lock()
+					defer cenv.l.Unlock()
+					cenv.n++
+					return cmds.EmitOnce(re, cenv.n)
+				},
+				Type: 0,
+			},
+		},
+	}
+
+	envs := make(chan *counterEnv, 2)
+	srvCfg := originCfg(defaultOrigins)
+	srvCfg.SessionTTL = 50 * time.Millisecond
+	srvCfg.AllowBatch = true
+	srvCfg.SessionFactory = func(ctx context.Context, env cmds.Environment) (cmds.Environment, error) {
+		cenv := &counterEnv{closed: make(chan struct{})}
+		envs <- cenv
+		return cenv, nil
+	}
+	srv := httptest.NewServer(NewHandler(nil, root, srvCfg))
+	defer srv.Close()
+
+	c := NewClient(srv.URL)
+	sc := c.(SessionOpener)
+
+	incr := func(ctx context.Context) (int, error) {
+		req, err := cmds.NewRequest(ctx, []string{"incr"}, nil, nil, nil, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := c.(*client).send(req)
+		if err != nil {
+			return 0, err
+		}
+		v, err := res.Next()
+		if err != nil {
+			return 0, err
+		}
+		return *v.(*int), nil
+	}
+
+	if _, err := incr(context.Background()); err == nil {
+		t.Fatal("expected an error outside of a session")
+	}
+
+	id, err := sc.OpenSession(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := ContextWithSession(context.Background(), id)
+	for i := 1; i <= 3; i++ {
+		n, err := incr(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != i {
+			t.Errorf("expected %d, got %d", i, n)
+		}
+	}
+
+	if err := sc.CloseSession(context.Background(), id); err != nil {
+		t.Fatal(err)
+	}
+	<-(<-envs).closed
+	if _, err := incr(ctx); err == nil {
+		t.Error("expected an error in a closed session")
+	}
+	req, err := cmds.NewRequest(ctx, []string{"incr"}, nil, nil, nil, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.(Batcher).Batch(ctx, req); err == nil {
+		t.Error("expected an error batching in a closed session")
+	}
+	if err := sc.CloseSession(context.Background(), id); err == nil {
+		t.Error("expected an error closing a closed session")
+	}
+
+	// idle sessions expire
+	id, err = sc.OpenSession(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-(<-envs).closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("session did not expire")
+	}
+	if _, err := incr(ContextWithSession(context.Background(), id)); err == nil {
+		t.Error("expected an error in an expired session")
+	}
+}
+
+func TestSessionDisabled(t *testing.T) {
+	_, srv := getTestServer(t, nil, false)
+	defer srv.Close()
+
+	if _, err := NewClient(srv.URL).(SessionOpener).OpenSession(context.Background()); err == nil {
+		t.Fatal("expected an error when sessions are disabled")
+	}
+}
+
+func TestSessionLimits(t *testing.T) {
+	root := &cmds.Command{
+		Subcommands: map[string]*cmds.Command{
+			"noop": {
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+					return nil
+				},
+			},
+		},
+	}
+	srvCfg := originCfg(defaultOrigins)
+	srvCfg.MaxSessions = 1
+	srvCfg.SessionFactory = func(ctx context.Context, env cmds.Environment) (cmds.Environment, error) {
+		return env, nil
+	}
+	srvCfg.Identify = func(r *http.Request) string {
+		return r.Header.Get("X-Test-Identity")
+	}
+	srv := httptest.NewServer(NewHandler(nil, root, srvCfg))
+	defer srv.Close()
+
+	post := func(identity, path, session string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPost, srv.URL+"/"+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Test-Identity", identity)
+		if session != "" {
+			req.Header.Set(sessionHeader, session)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	resp := post("bob", SessionOpenPath, "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status %d", resp.StatusCode)
+	}
+	id := resp.Header.Get(sessionHeader)
+
+	if resp := post("bob", SessionOpenPath, ""); resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("expected %d for too many sessions, got %d", http.StatusTooManyRequests, resp.StatusCode)
+	}
+	// other identities have sessions of their own, and can't use others'
+	if resp := post("eve", SessionOpenPath, ""); resp.StatusCode != http.StatusOK {
+		t.Errorf("expected %d for the session of another identity, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if resp := post("eve", "noop", id); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected %d using the session of another identity, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+	if resp := post("eve", SessionClosePath, id); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected %d closing the session of another identity, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+
+	if resp := post("bob", "noop", id); resp.StatusCode != http.StatusOK {
+		t.Errorf("unexpected status %d in the session", resp.StatusCode)
+	}
+	if resp := post("bob", SessionClosePath, id); resp.StatusCode != http.StatusOK {
+		t.Errorf("unexpected status %d closing the session", resp.StatusCode)
+	}
+	if resp := post("bob", SessionOpenPath, ""); resp.StatusCode != http.StatusOK {
+		t.Errorf("expected a session to be opened once the other is closed, got %d", resp.StatusCode)
+	}
+}
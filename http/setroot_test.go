@@ -0,0 +1,98 @@
+package http
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+func TestHandlerSetRoot(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	mkRoot := func(names ...string) *cmds.Command {
+		root := &cmds.Command{
+			Options:     cmdRoot.Options,
+			Subcommands: map[string]*cmds.Command{},
+		}
+		for _, name := range names {
+			name := name
+			root.Subcommands[name] = &cmds.Command{
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+					if name == "block" {
+						close(started)
+						<-release
+					}
+					return cmds.EmitOnce(re, name)
+				},
+				Type: "",
+			}
+		}
+		return root
+	}
+
+	h := NewHandler(nil, mkRoot("block"), originCfg(defaultOrigins))
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	call := func(name string) (string, error) {
+		req, err := cmds.NewRequest(context.Background(), []string{name}, nil, nil, nil, mkRoot(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := NewClient(srv.URL).(*client).send(req)
+		if err != nil {
+			return "", err
+		}
+		v, err := res.Next()
+		if err != nil {
+			return "", err
+		}
+		if _, err := res.Next(); err != io.EOF {
+			t.Errorf("expected EOF, got %v", err)
+		}
+		return *v.(*string), nil
+	}
+
+	if _, err := call("plugin"); err == nil {
+		t.Fatal("expected an error calling a command that isn't loaded")
+	}
+
+	// start a request that is in flight while the root is replaced
+	done := make(chan error)
+	go func() {
+		out, err := call("block")
+		if err == nil && out != "block" {
+			t.Errorf("expected %q, got %q", "block", out)
+		}
+		done <- err
+	}()
+	<-started
+
+	h.UpdateRoot(func(old *cmds.Command) *cmds.Command {
+		if _, ok := old.Subcommands["block"]; !ok {
+			t.Error("expected the old root to be passed")
+		}
+		return mkRoot("plugin")
+	})
+	if _, ok := h.Root().Subcommands["plugin"]; !ok {
+		t.Error("expected the new root to be served")
+	}
+
+	if out, err := call("plugin"); err != nil || out != "plugin" {
+		t.Errorf("expected %q, got %q, %v", "plugin", out, err)
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Errorf("in-flight request failed: %s", err)
+	}
+
+	h.SetRoot(mkRoot())
+	if _, err := call("plugin"); err == nil {
+		t.Error("expected an error calling a removed command")
+	}
+}
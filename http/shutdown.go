@@ -0,0 +1,120 @@
+package http
+
+import (
+	"context"
+	"sync"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+// drain tracks the requests in flight, so the handler can stop taking new
+// ones and wait for the others to finish.
+type drain struct {
+	l        sync.Mutex
+	stopping bool
+	active   int
+	// idle is closed once the handler is stopping and no request is active.
+	idle chan struct{}
+	// done is closed once Shutdown has returned.
+	done     chan struct{}
+	doneOnce sync.Once
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+func newDrain() drain {
+	return drain{
+		idle: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+// enter registers a new request. It returns false if the handler is
+// stopping, in which case the request must be refused.
+func (d *drain) enter() bool {
+	d.l.Lock()
+	defer d.l.Unlock()
+	if d.stopping {
+		return false
+	}
+	d.active++
+	return true
+}
+
+func (d *drain) leave() {
+	d.l.Lock()
+	defer d.l.Unlock()
+	d.active--
+	if d.stopping && d.active == 0 {
+		close(d.idle)
+	}
+}
+
+func (d *drain) stop() {
+	d.l.Lock()
+	defer d.l.Unlock()
+	if d.stopping {
+		return
+	}
+	d.stopping = true
+	if d.active == 0 {
+		close(d.idle)
+	}
+}
+
+// Shutdown gracefully shuts down the handler. New requests are refused with
+// 503 Service Unavailable, and Shutdown waits until the requests in flight
+// have finished or ctx is done, whichever happens first. Once they have
+// finished, the environment of the handler is closed, see
+// cmds.CloseEnvironment. Shutdown doesn't close the listener or the
+// connections of the server serving the handler; daemons shut it down once
+// Done is closed.
+//
+// Commands calling Shutdown must not wait for it, as their own request is
+// in flight until they return.
+func (h *Handler) Shutdown(ctx context.Context) error {
+	d := &h.h.drain
+	d.stop()
+	defer d.doneOnce.Do(func() { close(d.done) })
+
+	// an idle handler shuts down even if ctx is already done
+	select {
+	case <-d.idle:
+		return h.closeEnvironment()
+	default:
+	}
+	select {
+	case <-d.idle:
+		return h.closeEnvironment()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// closeEnvironment closes the environment of the handler, the first time it
+// is called.
+func (h *Handler) closeEnvironment() error {
+	d := &h.h.drain
+	d.closeOnce.Do(func() {
+		d.closeErr = cmds.CloseEnvironment(h.h.env)
+	})
+	return d.closeErr
+}
+
+// Done returns a channel that is closed once Shutdown has returned.
+func (h *Handler) Done() <-chan struct{} {
+	return h.h.drain.done
+}
+
+// ActiveRequests returns the requests in flight, oldest first.
+func (h *Handler) ActiveRequests() []cmds.ActiveRequest {
+	return h.h.active.List()
+}
+
+// CancelRequest cancels the context of the request in flight with the given
+// ID, see cmds.RequestID. The command stops once it notices; its client gets
+// the cancellation error.
+func (h *Handler) CancelRequest(id string) error {
+	return h.h.active.Cancel(id)
+}
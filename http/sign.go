@@ -0,0 +1,193 @@
+package http
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	// SignatureHeader is the trailer carrying the signature of a response,
+	// see ServerConfig.SigningKey.
+	SignatureHeader = "X-Response-Signature"
+
+	// signatureNonceHeader carries a random value chosen by the client,
+	// which is covered by the signature, so old responses can't be
+	// replayed.
+	signatureNonceHeader = "X-Signature-Nonce"
+
+	signatureDomain = "fg-ipfs-cmds/response-signature/v1"
+)
+
+// ErrInvalidSignature is returned at the end of a response whose signature
+// is missing or doesn't match.
+var ErrInvalidSignature = errors.New("invalid response signature")
+
+// responseDigest hashes a response for signing. The digest covers the
+// request the response belongs to, the status, the headers that determine
+// how the body is decoded, the body as sent and the error trailer. Every
+// field is length-prefixed, so fields can't be shifted into each other.
+type responseDigest struct {
+	h hash.Hash
+}
+
+func newResponseDigest(nonce, path, query string) *responseDigest {
+	d := &responseDigest{h: sha256.New()}
+	d.field(signatureDomain)
+	d.field(nonce)
+	d.field(path)
+	d.field(query)
+	return d
+}
+
+func (d *responseDigest) field(s string) {
+	var size [8]byte
+	binary.BigEndian.PutUint64(size[:], uint64(len(s)))
+	d.h.Write(size[:])
+	d.h.Write([]byte(s))
+}
+
+func (d *responseDigest) header(status int, h http.Header) {
+	var st [8]byte
+	binary.BigEndian.PutUint64(st[:], uint64(status))
+	d.h.Write(st[:])
+	for _, k := range []string{contentTypeHeader, streamHeader, channelHeader, extraContentLengthHeader} {
+		d.field(h.Get(k))
+	}
+}
+
+// sum finishes the digest with the hash of the body and the error trailer.
+func (d *responseDigest) sum(body hash.Hash, streamErr string) []byte {
+	d.field(string(body.Sum(nil)))
+	d.field(streamErr)
+	return d.h.Sum(nil)
+}
+
+// signingResponseWriter signs everything written to it. The signature is
+// sent in the SignatureHeader trailer.
+type signingResponseWriter struct {
+	http.ResponseWriter
+	key ed25519.PrivateKey
+
+	digest      *responseDigest
+	body        hash.Hash
+	wroteHeader bool
+}
+
+func newSigningResponseWriter(w http.ResponseWriter, r *http.Request, key ed25519.PrivateKey) *signingResponseWriter {
+	return &signingResponseWriter{
+		ResponseWriter: w,
+		key:            key,
+		digest:         newResponseDigest(r.Header.Get(signatureNonceHeader), strings.Trim(r.URL.Path, "/"), r.URL.RawQuery),
+		body:           sha256.New(),
+	}
+}
+
+func (sw *signingResponseWriter) WriteHeader(status int) {
+	if sw.wroteHeader {
+		return
+	}
+	sw.wroteHeader = true
+
+	h := sw.Header()
+	// the emitter sets the Trailer header in its preamble, so add ours
+	// last
+	h.Add("Trailer", SignatureHeader)
+	// trailers are only sent with chunked bodies
+	h.Del(contentLengthHeader)
+	sw.digest.header(status, h)
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *signingResponseWriter) Write(p []byte) (int, error) {
+	sw.WriteHeader(http.StatusOK)
+	n, err := sw.ResponseWriter.Write(p)
+	sw.body.Write(p[:n])
+	return n, err
+}
+
+func (sw *signingResponseWriter) Flush() {
+	if f, ok := sw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// finish signs the response and sets the signature trailer.
+func (sw *signingResponseWriter) finish() {
+	sw.WriteHeader(http.StatusOK)
+	sig := ed25519.Sign(sw.key, sw.digest.sum(sw.body, sw.Header().Get(StreamErrHeader)))
+	sw.Header().Set(SignatureHeader, base64.StdEncoding.EncodeToString(sig))
+}
+
+// ClientWithVerifyKey makes the client verify the signatures of responses
+// with key, see ServerConfig.SigningKey. Responses are streamed, so values
+// are passed on before the signature has been checked: a missing or wrong
+// signature is reported as ErrInvalidSignature in place of the end of the
+// response.
+func ClientWithVerifyKey(key ed25519.PublicKey) ClientOpt {
+	return func(c *client) {
+		c.verifyKey = key
+	}
+}
+
+// setSignatureNonce adds a fresh nonce to httpReq and returns the digest
+// the response to httpReq is checked against.
+func (c *client) setSignatureNonce(httpReq *http.Request) (*responseDigest, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	nonce := base64.RawURLEncoding.EncodeToString(buf)
+	httpReq.Header.Set(signatureNonceHeader, nonce)
+
+	path := strings.Trim(strings.TrimPrefix(httpReq.URL.Path, c.apiPrefix), "/")
+	return newResponseDigest(nonce, path, httpReq.URL.RawQuery), nil
+}
+
+// verifyResponse makes the body of res check the signature once it has been
+// read.
+func verifyResponse(res *http.Response, digest *responseDigest, key ed25519.PublicKey) {
+	digest.header(res.StatusCode, res.Header)
+	res.Body = &verifyingBody{
+		ReadCloser: res.Body,
+		res:        res,
+		key:        key,
+		digest:     digest,
+		body:       sha256.New(),
+	}
+}
+
+type verifyingBody struct {
+	io.ReadCloser
+	res    *http.Response
+	key    ed25519.PublicKey
+	digest *responseDigest
+	body   hash.Hash
+	err    error
+}
+
+func (b *verifyingBody) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+
+	n, err := b.ReadCloser.Read(p)
+	b.body.Write(p[:n])
+	if err == io.EOF {
+		// the trailer is available once the body has been read
+		sig, decErr := base64.StdEncoding.DecodeString(b.res.Trailer.Get(SignatureHeader))
+		sum := b.digest.sum(b.body, b.res.Trailer.Get(StreamErrHeader))
+		if decErr != nil || !ed25519.Verify(b.key, sum, sig) {
+			err = ErrInvalidSignature
+		}
+		b.err = err
+	}
+	return n, err
+}
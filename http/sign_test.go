@@ -0,0 +1,139 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+func TestSignedResponses(t *testing.T) {
+	env, _ := getTestServer(t, nil, false)
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srvCfg := originCfg(defaultOrigins)
+	srvCfg.SigningKey = priv
+	srvCfg.AllowBatch = true
+	rec := &wireRecorder{h: NewHandler(env, cmdRoot, srvCfg)}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	// readAll returns the values of the response and the error ending it
+	readAll := func(c cmds.Executor, path string) ([]interface{}, error) {
+		req, err := cmds.NewRequest(context.Background(), []string{path}, nil, nil, nil, cmdRoot)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := c.(*client).send(req)
+		if err != nil {
+			return nil, err
+		}
+		var vs []interface{}
+		for {
+			v, err := res.Next()
+			if err == io.EOF {
+				return vs, nil
+			}
+			if err != nil {
+				return vs, err
+			}
+			vs = append(vs, v)
+		}
+	}
+
+	type testcase struct {
+		path   string
+		key    ed25519.PublicKey
+		tamper bool
+		n      int
+		err    string
+	}
+
+	tcs := []testcase{
+		{path: "version", key: pub, n: 1},
+		{path: "error", key: pub, err: "an error occurred"},
+		{path: "lateerror", key: pub, n: 1, err: "an error occurred"},
+		{path: "version", key: otherPub, n: 1, err: ErrInvalidSignature.Error()},
+		{path: "version", key: pub, tamper: true, n: 1, err: ErrInvalidSignature.Error()},
+	}
+
+	for _, tc := range tcs {
+		rec.tamper = nil
+		if tc.tamper {
+			rec.tamper = func(p []byte) []byte {
+				return bytes.Replace(p, []byte("0.1.2"), []byte("6.6.6"), 1)
+			}
+		}
+
+		vs, err := readAll(NewClient(srv.URL, ClientWithVerifyKey(tc.key)), tc.path)
+		if len(vs) != tc.n {
+			t.Errorf("%s: expected %d values, got %d", tc.path, tc.n, len(vs))
+		}
+		if (err == nil && tc.err != "") || (err != nil && err.Error() != tc.err) {
+			t.Errorf("%s: expected error %q, got %v", tc.path, tc.err, err)
+		}
+	}
+
+	// batches are signed as a whole
+	for _, tc := range []testcase{
+		{key: pub, n: 1},
+		{key: otherPub, n: 1, err: ErrInvalidSignature.Error()},
+		{key: pub, tamper: true, n: 1, err: ErrInvalidSignature.Error()},
+	} {
+		rec.tamper = nil
+		if tc.tamper {
+			rec.tamper = func(p []byte) []byte {
+				return bytes.Replace(p, []byte("0.1.2"), []byte("6.6.6"), 1)
+			}
+		}
+
+		req, err := cmds.NewRequest(context.Background(), []string{"version"}, nil, nil, nil, cmdRoot)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ress, err := NewClient(srv.URL, ClientWithVerifyKey(tc.key)).(Batcher).Batch(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var n int
+		for {
+			_, err = ress[0].Next()
+			if err != nil {
+				break
+			}
+			n++
+		}
+		if n != tc.n {
+			t.Errorf("batch: expected %d values, got %d", tc.n, n)
+		}
+		if tc.err == "" && err != io.EOF || tc.err != "" && err.Error() != tc.err {
+			t.Errorf("batch: expected error %q, got %v", tc.err, err)
+		}
+	}
+
+	// signatures are checked inside sealed responses
+	rec.tamper = nil
+	key := []byte("0123456789abcdef")
+	srvCfg.PayloadKey = key
+	sealedSrv := httptest.NewServer(NewHandler(env, cmdRoot, srvCfg))
+	defer sealedSrv.Close()
+
+	if _, err := readAll(NewClient(sealedSrv.URL, ClientWithPayloadKey(key), ClientWithVerifyKey(pub)), "lateerror"); err == nil || err.Error() != "an error occurred" {
+		t.Errorf("expected trailer error, got %v", err)
+	}
+	if _, err := readAll(NewClient(sealedSrv.URL, ClientWithPayloadKey(key), ClientWithVerifyKey(otherPub)), "version"); err != ErrInvalidSignature {
+		t.Errorf("expected %q, got %v", ErrInvalidSignature, err)
+	}
+}
@@ -0,0 +1,178 @@
+package http
+
+import (
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+// NoSkewWarningEnv is the environment variable that, set to a true value,
+// turns off the version skew warning of clients, like
+// ClientWithoutSkewWarning.
+const NoSkewWarningEnv = "CMDS_NO_SKEW_WARNING"
+
+// versionSkew is a difference between the command tree of the server and
+// the local one, found by the handshake.
+type versionSkew struct {
+	server, client string
+	// cmp compares the server version to the client version, and is 0 if
+	// they are equal or can't be compared.
+	cmp int
+}
+
+// relation describes how the server differs from the client.
+func (s *versionSkew) relation() string {
+	switch {
+	case s.cmp < 0:
+		return "server is older than this client"
+	case s.cmp > 0:
+		return "server is newer than this client"
+	default:
+		return "server has different commands than this client"
+	}
+}
+
+func (s *versionSkew) String() string {
+	msg := "version skew: " + s.relation()
+	if s.server != "" && s.client != "" {
+		msg += " (server " + s.server + ", client " + s.client + ")"
+	}
+	return msg
+}
+
+// annotate adds the skew to client errors, e.g. about unknown options, which
+// it likely causes.
+func (s *versionSkew) annotate(err error) error {
+	var e *cmds.Error
+	switch v := err.(type) {
+	case *cmds.Error:
+		e = v
+	case cmds.Error:
+		e = &v
+	default:
+		return err
+	}
+	if e.Code != cmds.ErrClient {
+		return err
+	}
+	annotated := *e
+	annotated.Message += " (version skew: " + s.relation() + ")"
+	return &annotated
+}
+
+// skew returns the skew between the server and root, whose version is
+// version, or nil if their command trees have the same commands and options.
+// Their docs, defaults and option types may differ.
+func (caps *Capabilities) skew(root *cmds.Command, version string) *versionSkew {
+	if caps.Tree == nil || root == nil {
+		return nil
+	}
+	if reflect.DeepEqual(treeShape(caps.Tree), treeShape(cmds.Describe(root))) {
+		return nil
+	}
+	return &versionSkew{
+		server: caps.Version,
+		client: version,
+		cmp:    compareVersions(caps.Version, version),
+	}
+}
+
+// treeShape returns the paths of the commands of the tree described by d, and
+// those of their options, sorted.
+func treeShape(d *cmds.CommandDescription) []string {
+	var shape []string
+	var walk func(d *cmds.CommandDescription)
+	walk = func(d *cmds.CommandDescription) {
+		path := "/" + strings.Join(d.Path, "/")
+		shape = append(shape, path)
+		for _, opt := range d.Options {
+			for _, name := range opt.Names {
+				shape = append(shape, path+" --"+name)
+			}
+		}
+		for _, sub := range d.Subcommands {
+			walk(sub)
+		}
+	}
+	walk(d)
+	sort.Strings(shape)
+	return shape
+}
+
+// compareVersions compares versions of the form v1.2.3, returning 0 if
+// either of them isn't one. Pre-release and build suffixes are ignored.
+func compareVersions(a, b string) int {
+	pa, ok := parseVersion(a)
+	if !ok {
+		return 0
+	}
+	pb, ok := parseVersion(b)
+	if !ok {
+		return 0
+	}
+	for len(pa) < len(pb) {
+		pa = append(pa, 0)
+	}
+	for len(pb) < len(pa) {
+		pb = append(pb, 0)
+	}
+	for i := range pa {
+		switch {
+		case pa[i] < pb[i]:
+			return -1
+		case pa[i] > pb[i]:
+			return 1
+		}
+	}
+	return 0
+}
+
+func parseVersion(v string) ([]int, bool) {
+	v = strings.TrimPrefix(v, "v")
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+	if v == "" {
+		return nil, false
+	}
+	parts := strings.Split(v, ".")
+	nums := make([]int, len(parts))
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return nil, false
+		}
+		nums[i] = n
+	}
+	return nums, true
+}
+
+// ClientWithoutSkewWarning turns off the warning added to the first response
+// of clients whose handshake finds that the server has different commands,
+// see ClientWithHandshake. Errors about unknown options still mention the
+// skew.
+func ClientWithoutSkewWarning() ClientOpt {
+	return func(c *client) {
+		c.noSkewWarning = true
+	}
+}
+
+// skewWarning returns the warning about the skew found by the handshake, the
+// first time it is called after it was found.
+func (c *client) skewWarning() string {
+	c.capsLk.Lock()
+	defer c.capsLk.Unlock()
+
+	if c.skew == nil || c.skewWarned || c.noSkewWarning {
+		return ""
+	}
+	c.skewWarned = true
+	if off, err := strconv.ParseBool(os.Getenv(NoSkewWarningEnv)); err == nil && off {
+		return ""
+	}
+	return c.skew.String()
+}
@@ -0,0 +1,121 @@
+package http
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+func TestVersionSkew(t *testing.T) {
+	newRoot := func(opts ...cmds.Option) *cmds.Command {
+		return &cmds.Command{
+			Subcommands: map[string]*cmds.Command{
+				"add": {
+					Options: opts,
+					Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+						return cmds.EmitOnce(re, "ok")
+					},
+					Type: "",
+				},
+			},
+		}
+	}
+	// the server has added an option, and changed the type of another
+	root := newRoot(cmds.IntOption("count", ""), cmds.BoolOption("quiet", ""))
+	localRoot := newRoot(cmds.StringOption("count", ""))
+
+	srvCfg := originCfg(defaultOrigins)
+	srvCfg.Version = "0.3.0"
+	srvCfg.ServeCommandTree = true
+	srvCfg.StrictValidation = true
+	srv := httptest.NewServer(NewHandler(nil, root, srvCfg))
+	defer srv.Close()
+
+	send := func(c cmds.Executor, root *cmds.Command, count string) ([]string, error) {
+		req, err := cmds.NewRequest(context.Background(), []string{"add"}, cmds.OptMap{"count": count}, nil, nil, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := c.(*client).send(req)
+		if err != nil {
+			return nil, err
+		}
+		var warnings []string
+		for {
+			_, err := res.Next()
+			for w, ok := cmds.NextWarning(res); ok; w, ok = cmds.NextWarning(res) {
+				warnings = append(warnings, w)
+			}
+			if err == io.EOF {
+				return warnings, nil
+			}
+			if err != nil {
+				return warnings, err
+			}
+		}
+	}
+
+	c := NewClient(srv.URL, ClientWithHandshake("0.2.0"))
+	warnings, err := send(c, localRoot, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"version skew: server is newer than this client (server 0.3.0, client 0.2.0)"}
+	if !reflect.DeepEqual(warnings, expected) {
+		t.Errorf("expected warnings %q, got %q", expected, warnings)
+	}
+
+	// the warning is only added once
+	if warnings, err := send(c, localRoot, "2"); err != nil || len(warnings) != 0 {
+		t.Errorf("expected no warnings, got %q, %v", warnings, err)
+	}
+
+	_, err = send(c, localRoot, "three")
+	msg := `count: invalid int value "three" (version skew: server is newer than this client)`
+	if err == nil || err.Error() != msg {
+		t.Errorf("expected error %q, got %v", msg, err)
+	}
+
+	// clients with the same tree, and those turning the warning off, don't
+	// get it
+	for _, c := range []struct {
+		exe  cmds.Executor
+		root *cmds.Command
+		env  string
+	}{
+		{exe: NewClient(srv.URL, ClientWithHandshake("0.2.0")), root: root},
+		// docs and types don't count
+		{exe: NewClient(srv.URL, ClientWithHandshake("0.2.0")), root: newRoot(cmds.StringOption("count", "The count"), cmds.BoolOption("quiet", ""))},
+		{exe: NewClient(srv.URL, ClientWithHandshake("0.2.0"), ClientWithoutSkewWarning()), root: localRoot},
+		{exe: NewClient(srv.URL, ClientWithHandshake("0.2.0")), root: localRoot, env: "1"},
+	} {
+		t.Setenv(NoSkewWarningEnv, c.env)
+		if warnings, err := send(c.exe, c.root, "1"); err != nil || len(warnings) != 0 {
+			t.Errorf("expected no warnings, got %q, %v", warnings, err)
+		}
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tcs := []struct {
+		a, b string
+		cmp  int
+	}{
+		{"0.1.2", "0.2.0", -1},
+		{"v1.10.0", "v1.9.3", 1},
+		{"1.2", "1.2.0", 0},
+		{"1.2.0-rc1", "1.2.0", 0},
+		{"1.3", "1.2.9+build", 1},
+		{"dev", "1.0.0", 0},
+		{"", "1.0.0", 0},
+	}
+	for _, tc := range tcs {
+		if cmp := compareVersions(tc.a, tc.b); cmp != tc.cmp {
+			t.Errorf("compareVersions(%q, %q) = %d, expected %d", tc.a, tc.b, cmp, tc.cmp)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package http
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// stallWriter calls stalled once a write or flush of the response blocks for
+// longer than timeout.
+type stallWriter struct {
+	http.ResponseWriter
+	timeout time.Duration
+
+	once    sync.Once
+	stalled func()
+}
+
+func newStallWriter(w http.ResponseWriter, timeout time.Duration, stalled func()) *stallWriter {
+	return &stallWriter{ResponseWriter: w, timeout: timeout, stalled: stalled}
+}
+
+// watch starts timing a write. The returned function must be called once the
+// write is done.
+func (sw *stallWriter) watch() func() bool {
+	t := time.AfterFunc(sw.timeout, func() {
+		sw.once.Do(sw.stalled)
+	})
+	return t.Stop
+}
+
+func (sw *stallWriter) Write(p []byte) (int, error) {
+	defer sw.watch()()
+	return sw.ResponseWriter.Write(p)
+}
+
+func (sw *stallWriter) Flush() {
+	if f, ok := sw.ResponseWriter.(http.Flusher); ok {
+		defer sw.watch()()
+		f.Flush()
+	}
+}
@@ -0,0 +1,96 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+func TestEmitTimeout(t *testing.T) {
+	canceled := make(chan struct{})
+	root := &cmds.Command{
+		Subcommands: map[string]*cmds.Command{
+			"flood": {
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+					go func() {
+						<-req.Context.Done()
+						close(canceled)
+					}()
+					chunk := strings.Repeat("x", 1<<16)
+					for {
+						if err := re.Emit(chunk); err != nil {
+							return err
+						}
+					}
+				},
+				Type: "",
+			},
+		},
+	}
+
+	srvCfg := originCfg(defaultOrigins)
+	srvCfg.EmitTimeout = 50 * time.Millisecond
+	srv := httptest.NewServer(NewHandler(nil, root, srvCfg))
+	defer srv.Close()
+
+	// the client never reads the body, so the server's writes block once
+	// the connection buffers are full
+	res, err := http.Post(srv.URL+"/flood?encoding=json", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-canceled:
+	case <-time.After(10 * time.Second):
+		t.Fatal("request context wasn't canceled after the write stalled")
+	}
+	res.Body.Close()
+}
+
+func TestBatchEmitTimeout(t *testing.T) {
+	canceled := make(chan struct{})
+	root := &cmds.Command{
+		Subcommands: map[string]*cmds.Command{
+			"flood": {
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+					go func() {
+						<-req.Context.Done()
+						close(canceled)
+					}()
+					chunk := strings.Repeat("x", 1<<16)
+					for {
+						if err := re.Emit(chunk); err != nil {
+							return err
+						}
+					}
+				},
+				Type: "",
+			},
+		},
+	}
+
+	srvCfg := originCfg(defaultOrigins)
+	srvCfg.AllowBatch = true
+	srvCfg.EmitTimeout = 50 * time.Millisecond
+	srv := httptest.NewServer(NewHandler(nil, root, srvCfg))
+	defer srv.Close()
+
+	// the client never reads the body, so the server's writes block once
+	// the connection buffers are full
+	res, err := http.Post(srv.URL+"/"+BatchPath, applicationJSON, strings.NewReader(`[{"ID":"0","Path":["flood"]}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-canceled:
+	case <-time.After(10 * time.Second):
+		t.Fatal("batched request context wasn't canceled after the write stalled")
+	}
+	res.Body.Close()
+}
@@ -0,0 +1,134 @@
+package http
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+// DefaultHeartbeatInterval is the default of ServerConfig.HeartbeatInterval.
+const DefaultHeartbeatInterval = 15 * time.Second
+
+// Responses of subscription commands can stay idle for a long time, so
+// proxies and load balancers may take them for dead connections. The
+// handler keeps them busy by sending a cmds.Heartbeat whenever nothing was
+// sent for the heartbeat interval. Heartbeats are only sent in JSON
+// responses, where clients can tell them from values, and are dropped by the
+// client.
+
+// heartbeatInterval returns the heartbeat interval of the response to req, or
+// zero if it doesn't get heartbeats.
+func (h *handler) heartbeatInterval(req *cmds.Request) time.Duration {
+	if !req.Command.Subscription {
+		return 0
+	}
+	if h.cfg.HeartbeatInterval > 0 {
+		return h.cfg.HeartbeatInterval
+	}
+	return DefaultHeartbeatInterval
+}
+
+// withHeartbeats returns a ResponseEmitterOption that makes the emitter send
+// heartbeats when it was idle for interval. Zero disables heartbeats.
+func withHeartbeats(interval time.Duration) ResponseEmitterOption {
+	return func(re *responseEmitter) {
+		re.heartbeat = interval
+	}
+}
+
+// sendHeartbeats sends a heartbeat whenever nothing was sent for interval,
+// until the emitter is closed or starts streaming a reader.
+func (re *responseEmitter) sendHeartbeats(interval time.Duration) {
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+		case <-re.req.Context.Done():
+			return
+		}
+
+		// like Emit, send the preamble before taking the lock
+		re.once.Do(func() { re.preamble(cmds.Heartbeat{}) })
+
+		re.l.Lock()
+		if re.closed || re.streaming || re.req.Context.Err() != nil {
+			re.l.Unlock()
+			return
+		}
+
+		next := interval - time.Since(re.lastSend)
+		if next <= 0 {
+			if err := re.enc.Encode(cmds.Heartbeat{}); err != nil {
+				re.l.Unlock()
+				return
+			}
+			if f, ok := re.w.(http.Flusher); ok {
+				f.Flush()
+			}
+			re.lastSend = time.Now()
+			next = interval
+		}
+		re.l.Unlock()
+
+		timer.Reset(next)
+	}
+}
+
+// Subscriber is implemented by executors that can expose the responses of
+// subscription commands as channels.
+type Subscriber interface {
+	// Subscribe sends req and returns the values of the response on a
+	// channel, without heartbeats.
+	Subscribe(req *cmds.Request) (*Subscription, error)
+}
+
+var _ Subscriber = &client{}
+
+// Subscription is the stream of values of a subscription command.
+type Subscription struct {
+	// C receives the values of the response. It is closed when the
+	// response ends or the request is cancelled.
+	C <-chan interface{}
+
+	err error
+}
+
+// Err returns the error that ended the subscription, if any. It must only be
+// called after C was closed.
+func (s *Subscription) Err() error {
+	return s.err
+}
+
+func (c *client) Subscribe(req *cmds.Request) (*Subscription, error) {
+	res, err := c.send(req)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan interface{})
+	s := &Subscription{C: ch}
+	go func() {
+		defer close(ch)
+		for {
+			v, err := res.Next()
+			if err != nil {
+				if err != io.EOF {
+					s.err = err
+				}
+				return
+			}
+
+			select {
+			case ch <- v:
+			case <-req.Context.Done():
+				s.err = req.Context.Err()
+				return
+			}
+		}
+	}()
+	return s, nil
+}
@@ -0,0 +1,93 @@
+package http
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+func TestSubscription(t *testing.T) {
+	const interval = 50 * time.Millisecond
+
+	root := &cmds.Command{
+		Options: []cmds.Option{cmds.OptionTimeout},
+		Subcommands: map[string]*cmds.Command{
+			"watch": {
+				Subscription: true,
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+					for i := 1; i <= 2; i++ {
+						if err := re.Emit(i); err != nil {
+							return err
+						}
+						time.Sleep(3 * interval)
+					}
+					<-req.Context.Done()
+					return req.Context.Err()
+				},
+				Type: 0,
+			},
+		},
+	}
+
+	srvCfg := originCfg(defaultOrigins)
+	srvCfg.HeartbeatInterval = interval
+	srvCfg.MaxRequestTimeout = interval
+	srv := httptest.NewServer(NewHandler(nil, root, srvCfg))
+	defer srv.Close()
+
+	// heartbeats are sent while the command is idle, to clients of version 2
+	// of the protocol
+	for _, version := range []string{"1", "2"} {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*interval)
+		httpReq, err := http.NewRequest("POST", srv.URL+"/watch", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		httpReq.Header.Set(protocolHeader, version)
+		httpRes, err := http.DefaultClient.Do(httpReq.WithContext(ctx))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(httpRes.Body)
+		httpRes.Body.Close()
+		cancel()
+		if !strings.HasPrefix(string(body), "1\n") {
+			t.Errorf("version %s: expected values, got %q", version, body)
+		}
+		if heartbeats := strings.Contains(string(body), `{"Type":"heartbeat"}`); heartbeats != (version == "2") {
+			t.Errorf("version %s: unexpected heartbeats in %q", version, body)
+		}
+	}
+
+	// the client drops the heartbeats, and timeouts don't apply
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := cmds.NewRequest(ctx, []string{"watch"}, cmds.OptMap{cmds.TimeoutOpt: "10ms"}, nil, nil, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := NewClient(srv.URL).(Subscriber).Subscribe(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 2; i++ {
+		v := <-sub.C
+		if n, ok := v.(*int); !ok || *n != i {
+			t.Fatalf("expected %d, got %#v", i, v)
+		}
+	}
+
+	cancel()
+	if v, ok := <-sub.C; ok {
+		t.Fatalf("expected the subscription to end, got %#v", v)
+	}
+	if sub.Err() == nil {
+		t.Error("expected the subscription to end with an error")
+	}
+}
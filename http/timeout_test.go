@@ -0,0 +1,94 @@
+package http
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+func TestClientDeadline(t *testing.T) {
+	wait := func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+		if _, ok := req.Context.Deadline(); !ok {
+			return cmds.Errorf(cmds.ErrClient, "no deadline")
+		}
+		<-req.Context.Done()
+		return req.Context.Err()
+	}
+
+	root := &cmds.Command{
+		Options: []cmds.Option{cmds.OptionTimeout},
+		Subcommands: map[string]*cmds.Command{
+			"wait": {
+				Run: wait,
+			},
+			"emitwait": {
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+					if err := re.Emit("started"); err != nil {
+						return err
+					}
+					return wait(req, re, env)
+				},
+				Type: "",
+			},
+		},
+	}
+
+	srvCfg := originCfg(defaultOrigins)
+	srvCfg.MaxRequestTimeout = time.Second
+	srv := httptest.NewServer(NewHandler(nil, root, srvCfg))
+	defer srv.Close()
+
+	type testcase struct {
+		path    string
+		timeout time.Duration
+		opts    cmds.OptMap
+		code    cmds.ErrorType
+	}
+
+	tcs := []testcase{
+		{path: "wait", timeout: 300 * time.Millisecond, code: cmds.ErrTimeout},
+		{path: "emitwait", timeout: 300 * time.Millisecond, code: cmds.ErrTimeout},
+		// the shorter of option and deadline is used
+		{path: "wait", timeout: time.Minute, opts: cmds.OptMap{cmds.TimeoutOpt: "100ms"}, code: cmds.ErrTimeout},
+		// long deadlines are capped
+		{path: "wait", timeout: time.Minute, code: cmds.ErrTimeout},
+		// without any deadline, nothing is enforced
+		{path: "wait", code: cmds.ErrClient},
+	}
+
+	for _, tc := range tcs {
+		ctx := context.Background()
+		if tc.timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, tc.timeout)
+			defer cancel()
+		}
+
+		req, err := cmds.NewRequest(ctx, []string{tc.path}, tc.opts, nil, nil, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		start := time.Now()
+		res, err := NewClient(srv.URL).(*client).send(req)
+		for err == nil {
+			_, err = res.Next()
+		}
+		if err == io.EOF {
+			t.Errorf("%s: expected error", tc.path)
+			continue
+		}
+
+		e, ok := err.(*cmds.Error)
+		if !ok || e.Code != tc.code {
+			t.Errorf("%s: expected error code %s, got %#v", tc.path, tc.code, err)
+		}
+		if d := time.Since(start); d > 3*time.Second {
+			t.Errorf("%s: took %s", tc.path, d)
+		}
+	}
+}
@@ -0,0 +1,582 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+const (
+	// UploadOpenPath, UploadChunkPath and UploadStatusPath are the paths,
+	// relative to the API prefix, of the endpoints of resumable uploads.
+	// They are only served if ServerConfig.UploadDir is set.
+	//
+	// An upload is opened first, then the body of the request is sent in
+	// chunks, each at the offset the server has reached, see UploadStatus.
+	// Chunks are stored entirely or not at all. Once all chunks are sent,
+	// the request itself is sent without a body, with the upload ID in the
+	// X-Upload-Id header.
+	UploadOpenPath   = "upload/open"
+	UploadChunkPath  = "upload/chunk"
+	UploadStatusPath = "upload/status"
+
+	// DefaultUploadTTL is the default time after which idle uploads
+	// expire.
+	DefaultUploadTTL = time.Hour
+
+	// DefaultMaxUploadSize is the default limit of the size of an upload.
+	DefaultMaxUploadSize = 4 << 30
+
+	// DefaultMaxUploads is the default limit of the uploads an identity
+	// has open at once.
+	DefaultMaxUploads = 16
+
+	uploadHeader = "X-Upload-Id"
+)
+
+// ErrUnknownUpload is returned for uploads that don't exist, either because
+// they were used or because they expired.
+var ErrUnknownUpload = errors.New("unknown or expired upload")
+
+var (
+	errUploadTooLarge = errors.New("upload exceeds the maximum size")
+	errTooManyUploads = errors.New("too many open uploads")
+)
+
+// UploadStatus is the state of an upload, returned by the upload endpoints.
+type UploadStatus struct {
+	ID string
+	// Offset is the number of bytes of the body received, where the next
+	// chunk starts.
+	Offset int64
+}
+
+type upload struct {
+	// owner is the identity of the client that opened the upload, see
+	// ServerConfig.Identify. Other clients can't use it.
+	owner string
+	path  string
+	size  int64
+	timer *time.Timer
+	// busy is set while a chunk is written.
+	busy bool
+}
+
+// uploadManager keeps track of the resumable uploads of a handler.
+type uploadManager struct {
+	dir        string
+	ttl        time.Duration
+	maxSize    int64
+	maxUploads int
+
+	l       sync.Mutex
+	uploads map[string]*upload
+}
+
+func newUploadManager(cfg *ServerConfig) *uploadManager {
+	m := &uploadManager{
+		dir:        cfg.UploadDir,
+		ttl:        cfg.UploadTTL,
+		maxSize:    cfg.MaxUploadSize,
+		maxUploads: cfg.MaxUploads,
+		uploads:    make(map[string]*upload),
+	}
+	if m.ttl <= 0 {
+		m.ttl = DefaultUploadTTL
+	}
+	if m.maxSize <= 0 {
+		m.maxSize = DefaultMaxUploadSize
+	}
+	if m.maxUploads <= 0 {
+		m.maxUploads = DefaultMaxUploads
+	}
+	return m
+}
+
+// get returns upload id if it belongs to owner. It is called with the lock
+// held.
+func (m *uploadManager) get(owner, id string) (*upload, bool) {
+	u, ok := m.uploads[id]
+	if !ok || u.owner != owner {
+		return nil, false
+	}
+	return u, true
+}
+
+func (m *uploadManager) open(owner string) (UploadStatus, error) {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	open := 0
+	for _, u := range m.uploads {
+		if u.owner == owner {
+			open++
+		}
+	}
+	if open >= m.maxUploads {
+		return UploadStatus{}, errTooManyUploads
+	}
+
+	f, err := ioutil.TempFile(m.dir, "upload-")
+	if err != nil {
+		return UploadStatus{}, err
+	}
+	f.Close()
+
+	id := newRequestID()
+	u := &upload{owner: owner, path: f.Name()}
+	m.uploads[id] = u
+	u.timer = time.AfterFunc(m.ttl, func() { m.expire(id) })
+	return UploadStatus{ID: id}, nil
+}
+
+func (m *uploadManager) status(owner, id string) (UploadStatus, error) {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	u, ok := m.get(owner, id)
+	if !ok {
+		return UploadStatus{}, ErrUnknownUpload
+	}
+	return UploadStatus{ID: id, Offset: u.size}, nil
+}
+
+// errUploadConflict is returned for chunks that don't start where the upload
+// is, or that are sent while another chunk is written.
+var errUploadConflict = errors.New("chunk doesn't start at the offset of the upload")
+
+// write appends the chunk r, which starts at offset, to upload id. The chunk
+// is dropped if it can't be read completely, or if it would make the upload
+// larger than the maximum size.
+func (m *uploadManager) write(owner, id string, offset int64, r io.Reader) (UploadStatus, error) {
+	m.l.Lock()
+	u, ok := m.get(owner, id)
+	if !ok {
+		m.l.Unlock()
+		return UploadStatus{}, ErrUnknownUpload
+	}
+	if u.busy || offset != u.size {
+		m.l.Unlock()
+		return UploadStatus{ID: id, Offset: u.size}, errUploadConflict
+	}
+	u.busy = true
+	u.timer.Stop()
+	m.l.Unlock()
+
+	n, err := appendChunk(u.path, u.size, m.maxSize-u.size, r)
+
+	m.l.Lock()
+	defer m.l.Unlock()
+	u.busy = false
+	u.timer.Reset(m.ttl)
+	if err != nil {
+		return UploadStatus{ID: id, Offset: u.size}, err
+	}
+	u.size += n
+	return UploadStatus{ID: id, Offset: u.size}, nil
+}
+
+// appendChunk writes r to the file at path after its first size bytes, and
+// returns the number of bytes written. If r fails, or has more than max
+// bytes, the file is truncated back to size.
+func appendChunk(path string, size, max int64, r io.Reader) (int64, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(size, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(f, io.LimitReader(r, max+1))
+	if err == nil && n > max {
+		err = errUploadTooLarge
+	}
+	if err != nil {
+		if terr := f.Truncate(size); terr != nil {
+			log.Error("error dropping incomplete chunk", "error", terr)
+		}
+		return 0, err
+	}
+	return n, nil
+}
+
+// take removes upload id, so it can be used as the body of a request.
+func (m *uploadManager) take(owner, id string) (*upload, error) {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	u, ok := m.get(owner, id)
+	if !ok || u.busy {
+		return nil, ErrUnknownUpload
+	}
+	delete(m.uploads, id)
+	u.timer.Stop()
+	return u, nil
+}
+
+func (m *uploadManager) expire(id string) {
+	m.l.Lock()
+	u, ok := m.uploads[id]
+	if !ok || u.busy {
+		m.l.Unlock()
+		return
+	}
+	delete(m.uploads, id)
+	m.l.Unlock()
+
+	log.Debug("upload expired", "upload", id)
+	removeUpload(u)
+}
+
+func removeUpload(u *upload) {
+	if err := os.Remove(u.path); err != nil {
+		log.Error("error removing upload", "error", err)
+	}
+}
+
+// uploadBody replaces the body of r by the upload named in its X-Upload-Id
+// header, if any. The returned function must be called once the request is
+// done; it removes the upload.
+func (h *handler) uploadBody(r *http.Request) (*http.Request, func(), error) {
+	id := r.Header.Get(uploadHeader)
+	if id == "" || h.uploads == nil {
+		return r, func() {}, nil
+	}
+
+	u, err := h.uploads.take(Identity(r.Context()), id)
+	if err != nil {
+		return r, nil, err
+	}
+	f, err := os.Open(u.path)
+	if err != nil {
+		removeUpload(u)
+		return r, nil, err
+	}
+
+	r.Body.Close()
+	r.Body = f
+	r.ContentLength = u.size
+	return r, func() {
+		f.Close()
+		removeUpload(u)
+	}, nil
+}
+
+func (h *handler) serveUpload(w http.ResponseWriter, r *http.Request, path string) {
+	if r.Method != http.MethodPost {
+		setAllowHeader(w, false)
+		http.Error(w, "405 - Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	for k, v := range h.cfg.Headers {
+		if !skipAPIHeader(k) {
+			w.Header()[k] = v
+		}
+	}
+
+	var (
+		status UploadStatus
+		err    error
+		id     = r.URL.Query().Get("id")
+		owner  = Identity(r.Context())
+	)
+	switch path {
+	case UploadOpenPath:
+		status, err = h.uploads.open(owner)
+	case UploadStatusPath:
+		status, err = h.uploads.status(owner, id)
+	case UploadChunkPath:
+		var offset int64
+		offset, err = strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid offset", http.StatusBadRequest)
+			return
+		}
+		status, err = h.uploads.write(owner, id, offset, r.Body)
+	}
+
+	code := http.StatusOK
+	switch err {
+	case nil:
+	case ErrUnknownUpload:
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errUploadTooLarge:
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	case errTooManyUploads:
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	case errUploadConflict:
+		// the client resumes at the offset of the upload
+		code = http.StatusConflict
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set(contentTypeHeader, applicationJSON)
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		log.Error("error sending upload status", "error", err)
+	}
+}
+
+// uploadAttempts is the number of times a chunk is sent before the upload
+// fails.
+const uploadAttempts = 5
+
+// ClientWithResumableUploads makes the client send the bodies of requests
+// with files as resumable uploads, in chunks of chunkSize bytes, before the
+// request itself. Chunks that fail, e.g. because the connection dropped, are
+// sent again, so a large upload doesn't start over from the first byte. The
+// server must have resumable uploads enabled, see ServerConfig.UploadDir.
+//
+// Progress is reported to the function set with ContextWithUploadProgress,
+// with tokens that resume the upload later, see ContextWithUploadToken.
+//
+// Uploads aren't sealed, so clients sealing their requests, see
+// ClientWithPayloadKey, send the bodies with the sealed requests instead.
+func ClientWithResumableUploads(chunkSize int) ClientOpt {
+	return func(c *client) {
+		c.uploadChunk = chunkSize
+	}
+}
+
+// UploadToken identifies a resumable upload and how far it got.
+type UploadToken struct {
+	ID string
+	// Offset is the number of bytes of the body uploaded.
+	Offset int64
+	// Boundary is the multipart boundary of the body.
+	Boundary string
+}
+
+type uploadProgressKey struct{}
+type uploadTokenKey struct{}
+
+// ContextWithUploadProgress returns a copy of ctx that makes resumable
+// uploads call fn after every chunk sent.
+func ContextWithUploadProgress(ctx context.Context, fn func(UploadToken)) context.Context {
+	return context.WithValue(ctx, uploadProgressKey{}, fn)
+}
+
+// ContextWithUploadToken returns a copy of ctx that makes the request resume
+// the upload of token, e.g. one reported before the client was restarted,
+// instead of starting a new one. The request must have the same files, with
+// the same contents, as the one that started the upload.
+func ContextWithUploadToken(ctx context.Context, token UploadToken) context.Context {
+	return context.WithValue(ctx, uploadTokenKey{}, token)
+}
+
+// upload sends the body of httpReq as a resumable upload, and replaces it by
+// a reference to the upload.
+func (c *client) upload(ctx context.Context, httpReq *http.Request) error {
+	// multipart readers only end properly when read with large enough
+	// buffers, chunks can be smaller
+	body := io.Reader(bufio.NewReaderSize(httpReq.Body, 64*1024))
+	defer httpReq.Body.Close()
+
+	_, params, _ := mime.ParseMediaType(httpReq.Header.Get(contentTypeHeader))
+	boundary := params["boundary"]
+
+	var (
+		status UploadStatus
+		err    error
+	)
+	if token, ok := ctx.Value(uploadTokenKey{}).(UploadToken); ok {
+		status, err = c.uploadRequest(ctx, UploadStatusPath, token.ID, 0, nil)
+		if err != nil {
+			return err
+		}
+		// the new body has a boundary of its own
+		if token.Boundary != "" && token.Boundary != boundary {
+			body = newReplaceReader(body, boundary, token.Boundary)
+			httpReq.Header.Set(contentTypeHeader, "multipart/form-data; boundary="+token.Boundary)
+			boundary = token.Boundary
+		}
+		if _, err := io.CopyN(ioutil.Discard, body, status.Offset); err != nil {
+			return fmt.Errorf("can't resume upload: %s", err)
+		}
+	} else {
+		status, err = c.uploadRequest(ctx, UploadOpenPath, "", 0, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	progress, _ := ctx.Value(uploadProgressKey{}).(func(UploadToken))
+	chunk := make([]byte, c.uploadChunk)
+	for {
+		n, readErr := io.ReadFull(body, chunk)
+		if n > 0 {
+			status, err = c.sendChunk(ctx, status, chunk[:n])
+			if err != nil {
+				return err
+			}
+			if progress != nil {
+				progress(UploadToken{ID: status.ID, Offset: status.Offset, Boundary: boundary})
+			}
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+
+	httpReq.Body = http.NoBody
+	httpReq.ContentLength = 0
+	httpReq.Header.Set(uploadHeader, status.ID)
+	return nil
+}
+
+// sendChunk sends chunk, which starts at the offset of status, retrying
+// until it is stored.
+func (c *client) sendChunk(ctx context.Context, status UploadStatus, chunk []byte) (UploadStatus, error) {
+	end := status.Offset + int64(len(chunk))
+
+	var err error
+	for attempt := 0; attempt < uploadAttempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(time.Duration(attempt) * 100 * time.Millisecond):
+			case <-ctx.Done():
+				return status, ctx.Err()
+			}
+
+			// the chunk may have been stored even though the response
+			// got lost
+			cur, serr := c.uploadRequest(ctx, UploadStatusPath, status.ID, 0, nil)
+			switch {
+			case serr != nil:
+				err = serr
+				continue
+			case cur.Offset == end:
+				return cur, nil
+			case cur.Offset != status.Offset:
+				return cur, fmt.Errorf("upload is at offset %d, expected %d", cur.Offset, status.Offset)
+			}
+		}
+
+		var res UploadStatus
+		res, err = c.uploadRequest(ctx, UploadChunkPath, status.ID, status.Offset, chunk)
+		if err == nil {
+			return res, nil
+		}
+		if e, ok := err.(*cmds.Error); ok && e.Code == cmds.ErrNotFound {
+			return status, err
+		}
+	}
+	return status, err
+}
+
+func (c *client) uploadRequest(ctx context.Context, path, id string, offset int64, chunk []byte) (UploadStatus, error) {
+	query := url.Values{}
+	if id != "" {
+		query.Set("id", id)
+	}
+	if path == UploadChunkPath {
+		query.Set("offset", strconv.FormatInt(offset, 10))
+	}
+
+	var body io.Reader
+	if chunk != nil {
+		body = bytes.NewReader(chunk)
+	}
+	url := fmt.Sprintf(ApiUrlFormat, c.serverAddress, c.apiPrefix, path, query.Encode())
+	httpReq, err := http.NewRequest(http.MethodPost, url, body)
+	if err != nil {
+		return UploadStatus{}, err
+	}
+	httpReq.Header.Set(uaHeader, c.ua)
+	c.setAuth(httpReq)
+	httpReq.Header.Set(contentTypeHeader, applicationOctetStream)
+	httpReq = httpReq.WithContext(ctx)
+
+	httpRes, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return UploadStatus{}, err
+	}
+	defer httpRes.Body.Close()
+
+	if httpRes.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(httpRes.Body)
+		code := cmds.ErrNormal
+		if httpRes.StatusCode == http.StatusNotFound {
+			code = cmds.ErrNotFound
+		}
+		return UploadStatus{}, &cmds.Error{
+			Message: fmt.Sprintf("upload request failed: %s", strings.TrimSpace(string(msg))),
+			Code:    code,
+		}
+	}
+
+	var status UploadStatus
+	if err := json.NewDecoder(httpRes.Body).Decode(&status); err != nil {
+		return UploadStatus{}, err
+	}
+	return status, nil
+}
+
+// replaceReader replaces old by new, which has the same length, in the data
+// read from r.
+type replaceReader struct {
+	r        io.Reader
+	old, new []byte
+
+	// pending may be the start of old, out is ready to be read.
+	pending []byte
+	out     []byte
+	err     error
+}
+
+func newReplaceReader(r io.Reader, old, new string) *replaceReader {
+	return &replaceReader{r: r, old: []byte(old), new: []byte(new)}
+}
+
+func (rr *replaceReader) Read(p []byte) (int, error) {
+	for len(rr.out) == 0 {
+		if rr.err != nil {
+			return 0, rr.err
+		}
+
+		buf := make([]byte, 32*1024)
+		n, err := rr.r.Read(buf)
+		rr.err = err
+		data := bytes.ReplaceAll(append(rr.pending, buf[:n]...), rr.old, rr.new)
+
+		keep := len(rr.old) - 1
+		if err != nil || keep > len(data) {
+			keep = 0
+			if err == nil {
+				keep = len(data)
+			}
+		}
+		rr.out = data[:len(data)-keep]
+		rr.pending = append([]byte(nil), data[len(data)-keep:]...)
+	}
+
+	n := copy(p, rr.out)
+	rr.out = rr.out[n:]
+	return n, nil
+}
@@ -0,0 +1,295 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+	files "github.com/fgeth/fg-ipfs-files"
+)
+
+func uploadRoot() *cmds.Command {
+	return &cmds.Command{
+		Subcommands: map[string]*cmds.Command{
+			"size": {
+				Arguments: []cmds.Argument{
+					cmds.FileArg("file", true, false, "a file"),
+				},
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+					it := req.Files.Entries()
+					if !it.Next() {
+						return it.Err()
+					}
+					data, err := ioutil.ReadAll(files.FileFromEntry(it))
+					if err != nil {
+						return err
+					}
+					return cmds.EmitOnce(re, string(data))
+				},
+				Type: "",
+			},
+		},
+	}
+}
+
+func uploadRequest(t *testing.T, ctx context.Context, root *cmds.Command, data string) *cmds.Request {
+	req, err := cmds.NewRequest(ctx, []string{"size"}, nil, nil, files.NewMapDirectory(map[string]files.Node{
+		"file": files.NewBytesFile([]byte(data)),
+	}), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+func TestResumableUpload(t *testing.T) {
+	root := uploadRoot()
+	cfg := originCfg(defaultOrigins)
+	cfg.UploadDir = t.TempDir()
+
+	h := NewHandler(nil, root, cfg)
+	var chunks int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the first chunk fails once, after being read
+		if strings.HasSuffix(r.URL.Path, "/"+UploadChunkPath) {
+			chunks++
+			if chunks == 1 {
+				ioutil.ReadAll(r.Body)
+				http.Error(w, "flaky", http.StatusBadGateway)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	data := strings.Repeat("0123456789", 100)
+
+	var tokens []UploadToken
+	ctx := ContextWithUploadProgress(context.Background(), func(tok UploadToken) {
+		tokens = append(tokens, tok)
+	})
+	res, err := NewClient(srv.URL, ClientWithResumableUploads(64)).(*client).send(uploadRequest(t, ctx, root, data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := res.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := *(v.(*string)); got != data {
+		t.Errorf("command received %d bytes, expected %d", len(got), len(data))
+	}
+
+	if len(tokens) < 2 {
+		t.Fatalf("expected progress for every chunk, got %d", len(tokens))
+	}
+	for i := 1; i < len(tokens); i++ {
+		if tokens[i].Offset-tokens[i-1].Offset != 64 && i != len(tokens)-1 {
+			t.Errorf("unexpected progress %d after %d", tokens[i].Offset, tokens[i-1].Offset)
+		}
+	}
+
+	// the upload is gone once used
+	entries, err := ioutil.ReadDir(cfg.UploadDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("expected uploads to be removed, found %d", len(entries))
+	}
+}
+
+func TestResumeUpload(t *testing.T) {
+	root := uploadRoot()
+	cfg := originCfg(defaultOrigins)
+	cfg.UploadDir = t.TempDir()
+	srv := httptest.NewServer(NewHandler(nil, root, cfg))
+	defer srv.Close()
+
+	data := strings.Repeat("abcdefghij", 50)
+	c := NewClient(srv.URL, ClientWithResumableUploads(100)).(*client)
+
+	// a first client gives up after two chunks
+	ctx, cancel := context.WithCancel(context.Background())
+	var last UploadToken
+	ctx = ContextWithUploadProgress(ctx, func(tok UploadToken) {
+		last = tok
+		if tok.Offset >= 200 {
+			cancel()
+		}
+	})
+	if _, err := c.send(uploadRequest(t, ctx, root, data)); err == nil {
+		t.Fatal("expected the canceled upload to fail")
+	}
+	if last.Offset != 200 {
+		t.Fatalf("expected the upload to stop at 200, got %d", last.Offset)
+	}
+
+	// another one resumes it, with a body of a different boundary
+	var offsets []int64
+	ctx = ContextWithUploadToken(context.Background(), last)
+	ctx = ContextWithUploadProgress(ctx, func(tok UploadToken) {
+		offsets = append(offsets, tok.Offset)
+	})
+	res, err := c.send(uploadRequest(t, ctx, root, data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := res.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := *(v.(*string)); got != data {
+		t.Errorf("command received %q, expected %q", got, data)
+	}
+	if len(offsets) == 0 || offsets[0] != 300 {
+		t.Errorf("expected the upload to resume after 200, got progress %v", offsets)
+	}
+}
+
+func TestUploadEndpoints(t *testing.T) {
+	root := uploadRoot()
+	cfg := originCfg(defaultOrigins)
+	cfg.UploadDir = t.TempDir()
+	srv := httptest.NewServer(NewHandler(nil, root, cfg))
+	defer srv.Close()
+
+	c := NewClient(srv.URL).(*client)
+	ctx := context.Background()
+
+	if _, err := c.uploadRequest(ctx, UploadStatusPath, "nope", 0, nil); err == nil {
+		t.Error("expected unknown uploads to fail")
+	} else if e, ok := err.(*cmds.Error); !ok || e.Code != cmds.ErrNotFound {
+		t.Errorf("expected a not found error, got %v", err)
+	}
+
+	status, err := c.uploadRequest(ctx, UploadOpenPath, "", 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.uploadRequest(ctx, UploadChunkPath, status.ID, 0, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	// chunks must start at the offset of the upload
+	resp, err := http.Post(srv.URL+"/"+UploadChunkPath+"?id="+status.ID+"&offset=2", applicationOctetStream, bytes.NewReader([]byte("x")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("expected %d, got %d", http.StatusConflict, resp.StatusCode)
+	}
+	if !strings.Contains(string(body), `"Offset":5`) {
+		t.Errorf("expected the current offset, got %s", body)
+	}
+
+	// requests naming unknown uploads are rejected
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/size", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(uploadHeader, "nope")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected %d, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+}
+
+func TestUploadLimits(t *testing.T) {
+	root := uploadRoot()
+	cfg := originCfg(defaultOrigins)
+	cfg.UploadDir = t.TempDir()
+	cfg.MaxUploadSize = 8
+	cfg.MaxUploads = 1
+	cfg.Identify = func(r *http.Request) string {
+		return r.Header.Get("X-Test-Identity")
+	}
+	srv := httptest.NewServer(NewHandler(nil, root, cfg))
+	defer srv.Close()
+
+	post := func(id, path string, body []byte) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPost, srv.URL+"/"+path, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Test-Identity", id)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	c := NewClient(srv.URL).(*client)
+	ctx := context.Background()
+	status, err := c.uploadRequest(ctx, UploadOpenPath, "", 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp := post("", UploadOpenPath, nil); resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("expected %d for too many uploads, got %d", http.StatusTooManyRequests, resp.StatusCode)
+	}
+	// other identities have uploads of their own, and can't use others'
+	if resp := post("eve", UploadOpenPath, nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("expected %d for the upload of another identity, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if resp := post("eve", UploadStatusPath+"?id="+status.ID, nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected %d for the upload of another identity, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+
+	if resp := post("", UploadChunkPath+"?id="+status.ID+"&offset=0", []byte("0123456789")); resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected %d for a chunk exceeding the maximum size, got %d", http.StatusRequestEntityTooLarge, resp.StatusCode)
+	}
+	if status, err = c.uploadRequest(ctx, UploadStatusPath, status.ID, 0, nil); err != nil || status.Offset != 0 {
+		t.Errorf("expected the chunk to be dropped, got offset %d (%v)", status.Offset, err)
+	}
+}
+
+func TestSealedUpload(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	root := uploadRoot()
+	cfg := originCfg(defaultOrigins)
+	cfg.UploadDir = t.TempDir()
+	cfg.PayloadKey = key
+	var uploads int
+	h := NewHandler(nil, root, cfg)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/"+UploadOpenPath) {
+			uploads++
+		}
+		h.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	// the body is sealed with the request rather than uploaded in the clear
+	c := NewClient(srv.URL, ClientWithPayloadKey(key), ClientWithResumableUploads(4)).(*client)
+	res, err := c.send(uploadRequest(t, context.Background(), root, "sealed body"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := res.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := v.(*string); s == nil || *s != "sealed body" {
+		t.Errorf("expected the body, got %v", v)
+	}
+	if uploads != 0 {
+		t.Errorf("expected no uploads, got %d", uploads)
+	}
+}
@@ -0,0 +1,134 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+// clientOptions are the options the client sends with every request, which
+// are accepted even if the command doesn't declare them, like the
+// cmds.ClientSideOptions.
+var clientOptions = map[string]bool{
+	cmds.EncLong: true,
+	cmds.ChanOpt: true,
+}
+
+// FieldError is a problem with an argument or option of a request.
+type FieldError = cmds.FieldError
+
+// ValidationError is the error of requests rejected by strict validation,
+// see ServerConfig.StrictValidation, or by the checks of cmds.NewRequest and
+// cmds.Command.CheckArguments. It lists all problems of the request. It is
+// sent like a cmds.Error of type cmds.ErrClient, with the problems in an
+// additional Fields member.
+type ValidationError = cmds.ValidationError
+
+// validateRequest checks the arguments and options of r against the
+// declarations of the command. Requests for unknown commands are left to
+// parseRequest.
+func validateRequest(r *http.Request, root *cmds.Command) *ValidationError {
+	pth := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	// like in parseRequest, the last element of the path is an argument if
+	// it isn't a sub command
+	var args []string
+	cmdPath, err := root.Resolve(pth)
+	if err != nil {
+		if cmdPath, err = root.Resolve(pth[:len(pth)-1]); err != nil {
+			return nil
+		}
+		args = append(args, pth[len(pth)-1])
+		pth = pth[:len(pth)-1]
+	}
+	cmd := cmdPath[len(cmdPath)-1]
+
+	optDefs, err := root.GetOptions(pth)
+	if err != nil {
+		return nil
+	}
+
+	verr := &ValidationError{}
+	query := r.URL.Query()
+	args = append(args, query["arg"]...)
+
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		if k != "arg" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		v := query[k]
+		optDef, ok := optDefs[k]
+		switch {
+		case clientOptions[k] || cmds.ClientSideOptions[k]:
+		case !ok:
+			if err := root.CheckOption(pth, k); err != nil {
+				verr.Add(k, "%s", err)
+			} else {
+				verr.Add(k, "unknown option")
+			}
+		case optDef.Type() == cmds.Strings:
+		case len(v) > 1:
+			verr.Add(k, "expected a single value, got %d", len(v))
+		default:
+			if _, err := optDef.Parse(v[0]); err != nil {
+				verr.Add(k, "invalid %s value %q", cmds.OptionTypeName(optDef), v[0])
+			}
+		}
+	}
+
+	validateArguments(verr, cmd, args)
+
+	if len(verr.Fields) == 0 {
+		return nil
+	}
+	return verr
+}
+
+// validateArguments checks the number of string arguments of a request.
+// File arguments are sent in the body and checked when it is read.
+func validateArguments(verr *ValidationError, cmd *cmds.Command, args []string) {
+	var (
+		max      int
+		variadic bool
+		missing  []string
+	)
+	for _, argDef := range cmd.Arguments {
+		if argDef.Type != cmds.ArgString {
+			continue
+		}
+		if argDef.Variadic {
+			variadic = true
+		}
+		max++
+		if argDef.Required && !argDef.SupportsStdin && len(args) < max {
+			missing = append(missing, argDef.Name)
+		}
+	}
+
+	for _, name := range missing {
+		verr.Add(name, "required argument is missing")
+	}
+	if !variadic {
+		for i := max; i < len(args); i++ {
+			verr.Add(fmt.Sprintf("arg[%d]", i), "unexpected argument %q", args[i])
+		}
+	}
+}
+
+// writeValidationError replies to a request rejected by validation.
+func writeValidationError(w http.ResponseWriter, verr *ValidationError) {
+	w.Header().Set(contentTypeHeader, applicationJSON)
+	w.WriteHeader(http.StatusBadRequest)
+	if err := json.NewEncoder(w).Encode(verr); err != nil {
+		log.Error("error sending validation error", "error", err)
+	}
+}
@@ -0,0 +1,168 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+func TestStrictValidation(t *testing.T) {
+	run := func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+		return cmds.EmitOnce(re, "ok")
+	}
+	root := &cmds.Command{
+		Options: []cmds.Option{cmds.BoolOption("verbose", "")},
+		Subcommands: map[string]*cmds.Command{
+			"add": {
+				Options: []cmds.Option{
+					cmds.IntOption("count", ""),
+					cmds.StringsOption("tag", ""),
+				},
+				Arguments: []cmds.Argument{
+					cmds.StringArg("name", true, false, ""),
+					cmds.StringArg("note", false, false, ""),
+				},
+				Run:  run,
+				Type: "",
+			},
+			"cat": {
+				Arguments: []cmds.Argument{cmds.StringArg("paths", false, true, "")},
+				Run:       run,
+				Type:      "",
+			},
+		},
+	}
+
+	srvCfg := originCfg(defaultOrigins)
+	srvCfg.StrictValidation = true
+	srv := httptest.NewServer(NewHandler(nil, root, srvCfg))
+	defer srv.Close()
+
+	type testcase struct {
+		query  string
+		fields []FieldError
+	}
+
+	tcs := []testcase{
+		{query: "add?arg=a&count=3&tag=x&tag=y&verbose=true&encoding=json&stream-channels=true"},
+		{query: "add/a?arg=b"},
+		{query: "cat?arg=a&arg=b&arg=c"},
+		{query: "add?count=three&verbose=maybe", fields: []FieldError{
+			{Field: "count", Message: `invalid int value "three"`},
+			{Field: "verbose", Message: `invalid bool value "maybe"`},
+			{Field: "name", Message: "required argument is missing"},
+		}},
+		{query: "add?arg=a&arg=b&arg=c&count=1&count=2&color=red", fields: []FieldError{
+			{Field: "color", Message: "unknown option"},
+			{Field: "count", Message: "expected a single value, got 2"},
+			{Field: "arg[2]", Message: `unexpected argument "c"`},
+		}},
+	}
+
+	for _, tc := range tcs {
+		httpRes, err := http.Post(srv.URL+"/"+tc.query, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if tc.fields == nil {
+			httpRes.Body.Close()
+			if httpRes.StatusCode != http.StatusOK {
+				t.Errorf("%s: expected status 200, got %d", tc.query, httpRes.StatusCode)
+			}
+			continue
+		}
+
+		var body struct {
+			Code   cmds.ErrorType
+			Fields []FieldError
+		}
+		err = json.NewDecoder(httpRes.Body).Decode(&body)
+		httpRes.Body.Close()
+		if err != nil {
+			t.Fatalf("%s: %s", tc.query, err)
+		}
+		if httpRes.StatusCode != http.StatusBadRequest || body.Code != cmds.ErrClient {
+			t.Errorf("%s: expected status 400 and a client error, got %d and %s", tc.query, httpRes.StatusCode, body.Code)
+		}
+		if !reflect.DeepEqual(body.Fields, tc.fields) {
+			t.Errorf("%s: expected fields %v, got %v", tc.query, tc.fields, body.Fields)
+		}
+	}
+}
+
+func TestValidationErrorFields(t *testing.T) {
+	root := &cmds.Command{
+		Subcommands: map[string]*cmds.Command{
+			"add": {
+				Options: []cmds.Option{
+					cmds.IntOption("count", ""),
+					cmds.IntOption("size", ""),
+				},
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+					return cmds.EmitOnce(re, "ok")
+				},
+				Type: "",
+			},
+			"check": {
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+					var verr cmds.ValidationError
+					verr.Add("a", "too long")
+					verr.Add("b", "too short")
+					return verr.Err()
+				},
+				Type: "",
+			},
+		},
+	}
+	srv := httptest.NewServer(NewHandler(nil, root, originCfg(defaultOrigins)))
+	defer srv.Close()
+
+	// all invalid options are reported, without strict validation
+	httpRes, err := http.Post(srv.URL+"/add?count=x&size=y", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body struct {
+		Code   cmds.ErrorType
+		Fields []FieldError
+	}
+	err = json.NewDecoder(httpRes.Body).Decode(&body)
+	httpRes.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []FieldError{
+		{Field: "count", Message: `could not convert value "x" to type "int"`},
+		{Field: "size", Message: `could not convert value "y" to type "int"`},
+	}
+	if httpRes.StatusCode != http.StatusBadRequest || body.Code != cmds.ErrClient {
+		t.Errorf("expected status 400 and a client error, got %d and %s", httpRes.StatusCode, body.Code)
+	}
+	if !reflect.DeepEqual(body.Fields, exp) {
+		t.Errorf("expected fields %v, got %v", exp, body.Fields)
+	}
+
+	// the client decodes the fields of errors of commands
+	req, err := cmds.NewRequest(context.Background(), []string{"check"}, nil, nil, nil, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := NewClient(srv.URL).(*client).send(req)
+	if err == nil {
+		_, err = res.Next()
+	}
+	exp = []FieldError{{Field: "a", Message: "too long"}, {Field: "b", Message: "too short"}}
+	if fields := cmds.FieldErrors(err); !reflect.DeepEqual(fields, exp) {
+		t.Errorf("expected fields %v, got %v (%v)", exp, fields, err)
+	}
+	if !errors.Is(err, cmds.ErrClient) {
+		t.Errorf("expected a client error, got %v", err)
+	}
+}
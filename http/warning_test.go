@@ -0,0 +1,140 @@
+package http
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+func TestWarnings(t *testing.T) {
+	root := &cmds.Command{
+		Subcommands: map[string]*cmds.Command{
+			"paint": {
+				Options: []cmds.Option{
+					cmds.RenamedOption(cmds.StringOption("color", "a color"), "colour"),
+				},
+				Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+					cmds.Warn(re, "early")
+					if err := re.Emit("a"); err != nil {
+						return err
+					}
+					cmds.Warn(re, "late")
+					return re.Emit("b")
+				},
+				Type: "",
+			},
+		},
+	}
+	srv := httptest.NewServer(NewHandler(nil, root, originCfg(defaultOrigins)))
+	defer srv.Close()
+
+	for _, envelope := range []bool{false, true} {
+		req, err := cmds.NewRequest(context.Background(), []string{"paint"},
+			cmds.OptMap{"colour": "red", cmds.EnvelopeOpt: envelope}, nil, nil, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// send the deprecated name, like older clients do
+		req.Options = cmds.OptMap{"colour": "red", cmds.EnvelopeOpt: envelope}
+
+		res, err := NewClient(srv.URL).(*client).send(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var values, warnings []string
+		for {
+			v, err := res.Next()
+			for w, ok := cmds.NextWarning(res); ok; w, ok = cmds.NextWarning(res) {
+				warnings = append(warnings, w)
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			values = append(values, *v.(*string))
+		}
+
+		if exp := []string{"a", "b"}; !reflect.DeepEqual(values, exp) {
+			t.Errorf("envelope %t: expected values %q, got %q", envelope, exp, values)
+		}
+		exp := []string{`option "colour" is deprecated, use "color" instead`, "early", "late"}
+		if !reflect.DeepEqual(warnings, exp) {
+			t.Errorf("envelope %t: expected warnings %q, got %q", envelope, exp, warnings)
+		}
+	}
+
+	// clients of version 1 get warnings in headers only
+	httpRes, err := http.Post(srv.URL+"/paint", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(httpRes.Body)
+	httpRes.Body.Close()
+	if strings.Contains(string(body), "warning") {
+		t.Errorf("expected no warnings in the body, got %q", body)
+	}
+	if ws := httpRes.Header.Values(WarningHeader); !reflect.DeepEqual(ws, []string{"early"}) {
+		t.Errorf("expected the early warning in the headers, got %q", ws)
+	}
+
+	// warnings that encodings can't carry are sent in trailers
+	httpRes, err = http.Post(srv.URL+"/paint?encoding=text", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = ioutil.ReadAll(httpRes.Body)
+	httpRes.Body.Close()
+	if string(body) != "ab" {
+		t.Errorf("expected the values as text, got %q", body)
+	}
+	if ws := httpRes.Trailer.Values(WarningHeader); !reflect.DeepEqual(ws, []string{"late"}) {
+		t.Errorf("expected the late warning in the trailer, got %q", ws)
+	}
+
+	// and the client reads them once the values are decoded
+	httpReq, err := http.NewRequest("POST", srv.URL+"/paint?encoding=cbor", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpReq.Header.Set(protocolHeader, strconv.Itoa(ProtocolVersion))
+	httpRes, err = http.DefaultClient.Do(httpReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer httpRes.Body.Close()
+	req, err := cmds.NewRequest(context.Background(), []string{"paint"}, nil, nil, nil, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := parseResponse(httpRes, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var warnings []string
+	for {
+		_, err := res.Next()
+		for w, ok := cmds.NextWarning(res); ok; w, ok = cmds.NextWarning(res) {
+			warnings = append(warnings, w)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if exp := []string{"early", "late"}; !reflect.DeepEqual(warnings, exp) {
+		t.Errorf("expected warnings %q, got %q", exp, warnings)
+	}
+}
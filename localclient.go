@@ -0,0 +1,73 @@
+package cmds
+
+import "strings"
+
+// NewLocalClient returns an Executor running the commands of root in this
+// process, the way the HTTP client of the http package runs them on a
+// server: arguments are checked and PreRun and PostRun are called with the
+// environment passed to Execute, while Run is called with env on a request
+// of its own, and commands marked NoRemote are refused. Applications and
+// tests thus switch between an embedded and a remote server by only
+// replacing the call to http.NewClient.
+func NewLocalClient(root *Command, env Environment) Executor {
+	return NewTransportExecutor(&localClient{root: root, env: env})
+}
+
+// localClient is the ClientTransport of NewLocalClient.
+type localClient struct {
+	root *Command
+	env  Environment
+}
+
+func (c *localClient) Send(req *Request) (Response, error) {
+	path, err := c.root.Resolve(req.Path)
+	if err != nil {
+		return nil, Errorf(ErrNotFound, "command not found: %q", strings.Join(req.Path, " "))
+	}
+	for _, cmd := range path {
+		if cmd.NoRemote {
+			return nil, Errorf(ErrNotFound, "command not available remotely: %q", strings.Join(req.Path, " "))
+		}
+	}
+
+	// The command runs on a request of its own, like it would on a server,
+	// so it can't change the request of the client.
+	sreq, err := NewRequest(req.Context, req.Path, copyOptions(req.Options), append([]string(nil), req.Arguments...), req.Files, c.root)
+	if err != nil {
+		return nil, err
+	}
+	sreq.bodyArgs = req.bodyArgs
+
+	re, res := NewChanResponsePair(sreq)
+	go c.root.Call(sreq, re, c.env)
+	return &localResponse{Response: res, req: req}, nil
+}
+
+// localResponse is the response to a request sent by a local client. It
+// returns the request of the client, so PostRun sees the request it was
+// given, like it does with the HTTP client.
+type localResponse struct {
+	Response
+	req *Request
+}
+
+func (res *localResponse) Request() *Request {
+	return res.req
+}
+
+// Trailer returns the trailer of the wrapped response, see GetTrailer.
+func (res *localResponse) Trailer() *Trailer {
+	return GetTrailer(res.Response)
+}
+
+// copyOptions returns a copy of opts.
+func copyOptions(opts OptMap) OptMap {
+	if opts == nil {
+		return nil
+	}
+	cp := make(OptMap, len(opts))
+	for k, v := range opts {
+		cp[k] = v
+	}
+	return cp
+}
@@ -0,0 +1,82 @@
+package cmds
+
+import (
+	"context"
+	"io"
+	"testing"
+)
+
+func TestLocalClient(t *testing.T) {
+	var preRunEnv, runEnv Environment
+	root := &Command{
+		Subcommands: map[string]*Command{
+			"echo": {
+				Arguments: []Argument{
+					StringArg("words", true, true, "words to echo"),
+				},
+				PreRun: func(req *Request, env Environment) error {
+					preRunEnv = env
+					return nil
+				},
+				Run: func(req *Request, re ResponseEmitter, env Environment) error {
+					runEnv = env
+					req.Arguments = nil
+					return re.Emit("echo")
+				},
+				Type: "",
+			},
+			"local": {
+				NoRemote: true,
+				Run: func(req *Request, re ResponseEmitter, env Environment) error {
+					return nil
+				},
+			},
+		},
+	}
+
+	x := NewLocalClient(root, "server")
+	run := func(path []string, args []string) (*Request, []interface{}, error) {
+		req, err := NewRequest(context.Background(), path, nil, args, nil, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		re, res := NewChanResponsePair(req)
+		go func() {
+			if err := x.Execute(req, re, "client"); err != nil {
+				re.CloseWithError(err)
+			}
+		}()
+		var values []interface{}
+		for {
+			v, err := res.Next()
+			if err == io.EOF {
+				return req, values, nil
+			}
+			if err != nil {
+				return req, values, err
+			}
+			values = append(values, v)
+		}
+	}
+
+	req, values, err := run([]string{"echo"}, []string{"a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 1 || values[0] != "echo" {
+		t.Errorf("unexpected values %v", values)
+	}
+	if preRunEnv != "client" || runEnv != "server" {
+		t.Errorf("expected PreRun to get the client environment and Run the server one, got %v and %v", preRunEnv, runEnv)
+	}
+	// the command runs on a request of its own
+	if len(req.Arguments) != 1 {
+		t.Errorf("the command changed the request of the client: %v", req.Arguments)
+	}
+
+	if _, _, err := run([]string{"local"}, nil); err == nil {
+		t.Error("expected commands marked NoRemote to be refused")
+	} else if e, ok := err.(*Error); !ok || e.Code != ErrNotFound {
+		t.Errorf("expected an error of type ErrNotFound, got %#v", err)
+	}
+}
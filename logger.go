@@ -0,0 +1,126 @@
+package cmds
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// Logger is the interface the library logs through. keysAndValues are
+// alternating keys and values that add structured fields to the message.
+type Logger interface {
+	Debug(msg string, keysAndValues ...interface{})
+	Info(msg string, keysAndValues ...interface{})
+	Warn(msg string, keysAndValues ...interface{})
+	Error(msg string, keysAndValues ...interface{})
+}
+
+var (
+	loggerLk sync.RWMutex
+	logger   Logger
+)
+
+// SetLogger makes the library log through l. Passing nil restores the
+// default, which logs through go-log, or through the standard library's log
+// package in builds with the cmds_minimal tag.
+func SetLogger(l Logger) {
+	loggerLk.Lock()
+	defer loggerLk.Unlock()
+	logger = l
+}
+
+// GetLogger returns the logger set with SetLogger, or nil.
+func GetLogger() Logger {
+	loggerLk.RLock()
+	defer loggerLk.RUnlock()
+	return logger
+}
+
+// SubsystemLogger returns a Logger that forwards to the logger set with
+// SetLogger. If none is set, it logs through the default logger of the
+// given subsystem, see SetLogger.
+func SubsystemLogger(subsystem string) Logger {
+	return &subsystemLogger{fallback: defaultLogger(subsystem)}
+}
+
+type subsystemLogger struct {
+	fallback Logger
+}
+
+func (l *subsystemLogger) get() Logger {
+	if gl := GetLogger(); gl != nil {
+		return gl
+	}
+	return l.fallback
+}
+
+func (l *subsystemLogger) Debug(msg string, kv ...interface{}) { l.get().Debug(msg, kv...) }
+func (l *subsystemLogger) Info(msg string, kv ...interface{})  { l.get().Info(msg, kv...) }
+func (l *subsystemLogger) Warn(msg string, kv ...interface{})  { l.get().Warn(msg, kv...) }
+func (l *subsystemLogger) Error(msg string, kv ...interface{}) { l.get().Error(msg, kv...) }
+
+type loggerKey struct{}
+
+// ContextWithLogger returns a copy of ctx that carries l. Requests with
+// such a context log through l instead of the global logger; the HTTP
+// handler uses this for per-handler loggers.
+func ContextWithLogger(ctx context.Context, l Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// ContextLogger returns the logger carried by ctx, or fallback if there is
+// none.
+func ContextLogger(ctx context.Context, fallback Logger) Logger {
+	if ctx != nil {
+		if l, ok := ctx.Value(loggerKey{}).(Logger); ok && l != nil {
+			return l
+		}
+	}
+	return fallback
+}
+
+// LoggerWith returns a Logger logging through l with keysAndValues added to
+// every message.
+func LoggerWith(l Logger, keysAndValues ...interface{}) Logger {
+	if len(keysAndValues) == 0 {
+		return l
+	}
+	if fl, ok := l.(*fieldLogger); ok {
+		return &fieldLogger{l: fl.l, fields: fl.with(keysAndValues)}
+	}
+	return &fieldLogger{l: l, fields: keysAndValues}
+}
+
+type fieldLogger struct {
+	l      Logger
+	fields []interface{}
+}
+
+// with returns the fields of l followed by kv.
+func (l *fieldLogger) with(kv []interface{}) []interface{} {
+	fields := make([]interface{}, 0, len(l.fields)+len(kv))
+	fields = append(fields, l.fields...)
+	return append(fields, kv...)
+}
+
+func (l *fieldLogger) Debug(msg string, kv ...interface{}) { l.l.Debug(msg, l.with(kv)...) }
+func (l *fieldLogger) Info(msg string, kv ...interface{})  { l.l.Info(msg, l.with(kv)...) }
+func (l *fieldLogger) Warn(msg string, kv ...interface{})  { l.l.Warn(msg, l.with(kv)...) }
+func (l *fieldLogger) Error(msg string, kv ...interface{}) { l.l.Error(msg, l.with(kv)...) }
+
+// LoggerFrom returns the logger of req: the logger of its context, see
+// ContextWithLogger, with the ID of the request and the path of its command
+// added to every message. Run functions log through it, so the messages of
+// a request can be told apart from those of others. The fields are added
+// when it's called: Call and the executors don't attach loggers to requests,
+// only the callers setting ContextWithLogger, such as the HTTP handler, do.
+func LoggerFrom(req *Request) Logger {
+	fields := []interface{}{"path", strings.Join(req.Path, "/")}
+	if req.Context == nil {
+		return LoggerWith(log, fields...)
+	}
+	if id := RequestID(req.Context); id != "" {
+		fields = append(fields, "requestId", id)
+	}
+	return LoggerWith(ContextLogger(req.Context, log), fields...)
+}
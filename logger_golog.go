@@ -0,0 +1,27 @@
+//go:build !cmds_minimal
+// +build !cmds_minimal
+
+package cmds
+
+import (
+	logging "github.com/ipfs/go-log"
+	"go.uber.org/zap"
+)
+
+func defaultLogger(subsystem string) Logger {
+	return ZapLogger(&logging.Logger(subsystem).SugaredLogger)
+}
+
+// ZapLogger adapts a zap SugaredLogger to Logger.
+func ZapLogger(l *zap.SugaredLogger) Logger {
+	return zapLogger{l.Desugar().WithOptions(zap.AddCallerSkip(1)).Sugar()}
+}
+
+type zapLogger struct {
+	l *zap.SugaredLogger
+}
+
+func (z zapLogger) Debug(msg string, kv ...interface{}) { z.l.Debugw(msg, kv...) }
+func (z zapLogger) Info(msg string, kv ...interface{})  { z.l.Infow(msg, kv...) }
+func (z zapLogger) Warn(msg string, kv ...interface{})  { z.l.Warnw(msg, kv...) }
+func (z zapLogger) Error(msg string, kv ...interface{}) { z.l.Errorw(msg, kv...) }
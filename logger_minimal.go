@@ -0,0 +1,34 @@
+//go:build cmds_minimal
+// +build cmds_minimal
+
+package cmds
+
+import (
+	"fmt"
+	stdlog "log"
+	"strings"
+)
+
+func defaultLogger(subsystem string) Logger {
+	return stdLogger{subsystem}
+}
+
+// stdLogger logs warnings and errors through the standard library's log
+// package. Debug and info messages are dropped.
+type stdLogger struct {
+	subsystem string
+}
+
+func (l stdLogger) print(level, msg string, kv []interface{}) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\t%s\t%s", level, l.subsystem, msg)
+	for i := 0; i+1 < len(kv); i += 2 {
+		fmt.Fprintf(&b, " %v=%v", kv[i], kv[i+1])
+	}
+	stdlog.Print(b.String())
+}
+
+func (l stdLogger) Debug(msg string, kv ...interface{}) {}
+func (l stdLogger) Info(msg string, kv ...interface{})  {}
+func (l stdLogger) Warn(msg string, kv ...interface{})  { l.print("WARN", msg, kv) }
+func (l stdLogger) Error(msg string, kv ...interface{}) { l.print("ERROR", msg, kv) }
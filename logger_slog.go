@@ -0,0 +1,22 @@
+//go:build go1.21
+// +build go1.21
+
+package cmds
+
+import (
+	"log/slog"
+)
+
+// SlogLogger adapts a slog Logger to Logger.
+func SlogLogger(l *slog.Logger) Logger {
+	return slogLogger{l}
+}
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+func (s slogLogger) Debug(msg string, kv ...interface{}) { s.l.Debug(msg, kv...) }
+func (s slogLogger) Info(msg string, kv ...interface{})  { s.l.Info(msg, kv...) }
+func (s slogLogger) Warn(msg string, kv ...interface{})  { s.l.Warn(msg, kv...) }
+func (s slogLogger) Error(msg string, kv ...interface{}) { s.l.Error(msg, kv...) }
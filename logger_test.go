@@ -0,0 +1,114 @@
+package cmds
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+type logEntry struct {
+	level, msg string
+	kv         []interface{}
+}
+
+type testLogger struct {
+	l       sync.Mutex
+	entries []logEntry
+}
+
+func (l *testLogger) log(level, msg string, kv []interface{}) {
+	l.l.Lock()
+	defer l.l.Unlock()
+	l.entries = append(l.entries, logEntry{level, msg, kv})
+}
+
+func (l *testLogger) Debug(msg string, kv ...interface{}) { l.log("debug", msg, kv) }
+func (l *testLogger) Info(msg string, kv ...interface{})  { l.log("info", msg, kv) }
+func (l *testLogger) Warn(msg string, kv ...interface{})  { l.log("warn", msg, kv) }
+func (l *testLogger) Error(msg string, kv ...interface{}) { l.log("error", msg, kv) }
+
+func TestLogger(t *testing.T) {
+	runErr := errors.New("failed")
+	root := &Command{
+		Subcommands: map[string]*Command{
+			"fail": {
+				Run: func(*Request, ResponseEmitter, Environment) error { return runErr },
+			},
+		},
+	}
+
+	call := func(ctx context.Context) {
+		req, err := NewRequest(ctx, []string{"fail"}, nil, nil, nil, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		re, err := NewWriterResponseEmitter(wc{&buf, nopCloser{}}, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		root.Call(req, re, nil)
+	}
+
+	expEntries := []logEntry{{"debug", "error occured in call, closing with error", []interface{}{"error", runErr}}}
+
+	global := new(testLogger)
+	SetLogger(global)
+	defer SetLogger(nil)
+
+	call(context.Background())
+	if len(global.entries) == 0 || !reflect.DeepEqual(global.entries[:1], expEntries) {
+		t.Errorf("expected global entries %v, got %v", expEntries, global.entries)
+	}
+
+	// the context logger takes precedence
+	global.entries = nil
+	local := new(testLogger)
+	call(ContextWithLogger(context.Background(), local))
+	if len(local.entries) == 0 || !reflect.DeepEqual(local.entries[:1], expEntries) {
+		t.Errorf("expected context entries %v, got %v", expEntries, local.entries)
+	}
+	if len(global.entries) != 0 {
+		t.Errorf("expected no global entries, got %v", global.entries)
+	}
+
+	SetLogger(nil)
+	if GetLogger() != nil {
+		t.Error("expected the global logger to be reset")
+	}
+}
+
+func TestLoggerFrom(t *testing.T) {
+	root := &Command{
+		Subcommands: map[string]*Command{
+			"add": {
+				Run: func(req *Request, re ResponseEmitter, env Environment) error {
+					LoggerFrom(req).Info("adding", "file", "a")
+					return nil
+				},
+			},
+		},
+	}
+
+	local := new(testLogger)
+	ctx := ContextWithRequestID(ContextWithLogger(context.Background(), local), "42")
+	ctx = ContextWithLogger(ctx, LoggerWith(local, "identity", "alice"))
+	req, err := NewRequest(ctx, []string{"add"}, nil, nil, nil, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	re, err := NewWriterResponseEmitter(wc{&buf, nopCloser{}}, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root.Call(req, re, nil)
+
+	exp := []logEntry{{"info", "adding", []interface{}{"identity", "alice", "path", "add", "requestId", "42", "file", "a"}}}
+	if !reflect.DeepEqual(local.entries, exp) {
+		t.Errorf("expected entries %v, got %v", exp, local.entries)
+	}
+}
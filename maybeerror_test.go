@@ -140,3 +140,26 @@ func TestMaybeError(t *testing.T) {
 		})
 	}
 }
+
+func TestMaybeErrorTrailer(t *testing.T) {
+	d := json.NewDecoder(strings.NewReader(`{"Bar":23}{"RequestID":"abc","Count":1,"Type":"trailer"}`))
+
+	m := &MaybeError{Value: &Foo{}}
+	if err := d.Decode(m); err != nil {
+		t.Fatal(err)
+	}
+	if m.IsTrailer() {
+		t.Fatal("value decoded as trailer")
+	}
+
+	m = &MaybeError{Value: &Foo{}}
+	if err := d.Decode(m); err != nil {
+		t.Fatal(err)
+	}
+	if !m.IsTrailer() {
+		t.Fatal("expected trailer")
+	}
+	if m.Trailer.RequestID != "abc" || m.Trailer.Count != 1 {
+		t.Errorf("unexpected trailer %+v", m.Trailer)
+	}
+}
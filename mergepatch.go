@@ -0,0 +1,213 @@
+package cmds
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// MergePatchSnapshotInterval is the number of values of a merge patch stream
+// after which a full value is sent again rather than a patch, so clients
+// don't accumulate errors forever, and streams can be followed from a
+// recent snapshot.
+const MergePatchSnapshotInterval = 100
+
+// patchFrame is a value of a merge patch stream sent as the JSON Merge Patch
+// (RFC 7386) turning the previous value into it.
+type patchFrame struct {
+	Type  string
+	Patch json.RawMessage
+}
+
+// isFrame reports whether v is sent as is in merge patch streams, rather
+// than diffed: the frames of JSON streams that aren't values.
+func isFrame(v interface{}) bool {
+	switch v.(type) {
+	case Warning, *Warning, Progress, *Progress, Trailer, *Trailer, Heartbeat, Error, *Error:
+		return true
+	}
+	return false
+}
+
+func newMergePatchEncoder(req *Request) func(io.Writer) Encoder {
+	return func(w io.Writer) Encoder {
+		return &mergePatchEncoder{enc: json.NewEncoder(w)}
+	}
+}
+
+// mergePatchEncoder sends the first value of a stream, and every
+// MergePatchSnapshotInterval values, as they are, and the others as the
+// merge patch from the previous value when it is smaller.
+type mergePatchEncoder struct {
+	enc *json.Encoder
+	// prev is the previous value, decoded from its JSON.
+	prev interface{}
+	// n is the number of values sent since the last full value.
+	n int
+}
+
+func (e *mergePatchEncoder) Encode(v interface{}) error {
+	if isFrame(v) {
+		return e.enc.Encode(v)
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	cur, err := decodeJSONValue(data)
+	if err != nil {
+		return err
+	}
+
+	prev := e.prev
+	e.prev = cur
+	e.n++
+	if patchable(prev, cur) && e.n < MergePatchSnapshotInterval {
+		patch, err := json.Marshal(mergePatch(prev.(map[string]interface{}), cur.(map[string]interface{})))
+		if err != nil {
+			return err
+		}
+		if len(patch) < len(data) {
+			return e.enc.Encode(patchFrame{Type: "patch", Patch: patch})
+		}
+	}
+	e.n = 0
+	return e.enc.Encode(json.RawMessage(data))
+}
+
+// patchable reports whether cur can be sent as a merge patch from prev: both
+// must be objects, and cur must not have null members, which merge patches
+// can't express.
+func patchable(prev, cur interface{}) bool {
+	_, ok := prev.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	m, ok := cur.(map[string]interface{})
+	return ok && !hasNullMember(m)
+}
+
+func hasNullMember(m map[string]interface{}) bool {
+	for _, v := range m {
+		if v == nil {
+			return true
+		}
+		if sub, ok := v.(map[string]interface{}); ok && hasNullMember(sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// mergePatch returns the merge patch turning prev into cur.
+func mergePatch(prev, cur map[string]interface{}) map[string]interface{} {
+	patch := make(map[string]interface{})
+	for k := range prev {
+		if _, ok := cur[k]; !ok {
+			patch[k] = nil
+		}
+	}
+	for k, v := range cur {
+		old, ok := prev[k]
+		if !ok {
+			patch[k] = v
+			continue
+		}
+		oldMap, oldIsMap := old.(map[string]interface{})
+		curMap, curIsMap := v.(map[string]interface{})
+		if oldIsMap && curIsMap {
+			if sub := mergePatch(oldMap, curMap); len(sub) > 0 {
+				patch[k] = sub
+			}
+			continue
+		}
+		if !reflect.DeepEqual(old, v) {
+			patch[k] = v
+		}
+	}
+	return patch
+}
+
+// applyMergePatch applies patch to doc, as described in RFC 7386.
+func applyMergePatch(doc, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	d, ok := doc.(map[string]interface{})
+	if !ok {
+		d = make(map[string]interface{})
+	}
+	out := make(map[string]interface{}, len(d))
+	for k, v := range d {
+		out[k] = v
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(out, k)
+		} else {
+			out[k] = applyMergePatch(out[k], v)
+		}
+	}
+	return out
+}
+
+// decodeJSONValue decodes data keeping numbers as they are written.
+func decodeJSONValue(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	err := dec.Decode(&v)
+	return v, err
+}
+
+func newMergePatchDecoder(r io.Reader) Decoder {
+	return &mergePatchDecoder{dec: json.NewDecoder(r)}
+}
+
+// mergePatchDecoder decodes merge patch streams, applying the patches to the
+// previous value.
+type mergePatchDecoder struct {
+	dec  *json.Decoder
+	prev interface{}
+}
+
+func (d *mergePatchDecoder) Decode(v interface{}) error {
+	var data json.RawMessage
+	if err := d.dec.Decode(&data); err != nil {
+		return err
+	}
+
+	var frame patchFrame
+	if bytes.Contains(data, []byte(`"patch"`)) && json.Unmarshal(data, &frame) == nil && frame.Type == "patch" {
+		if d.prev == nil {
+			return fmt.Errorf("merge patch stream: patch without a previous value")
+		}
+		patch, err := decodeJSONValue(frame.Patch)
+		if err != nil {
+			return err
+		}
+		if data, err = json.Marshal(applyMergePatch(d.prev, patch)); err != nil {
+			return err
+		}
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return err
+	}
+	if m, ok := v.(*MaybeError); ok {
+		if _, progress := m.Value.(*Progress); m.isError || m.IsTrailer() || m.IsWarning() || m.IsHeartbeat() || progress {
+			return nil
+		}
+	}
+
+	prev, err := decodeJSONValue(data)
+	if err != nil {
+		return err
+	}
+	d.prev = prev
+	return nil
+}
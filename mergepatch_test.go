@@ -0,0 +1,111 @@
+package cmds
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMergePatch(t *testing.T) {
+	type peer struct {
+		Addr    string
+		Latency int `json:",omitempty"`
+	}
+	type state struct {
+		Peers map[string]peer
+		Count int
+		Tags  []string
+		// About makes values larger than patches
+		About string
+	}
+	about := strings.Repeat("a large field ", 10)
+
+	states := []*state{
+		{Peers: map[string]peer{"a": {Addr: "/ip4/1.2.3.4", Latency: 10}, "b": {Addr: "/ip4/5.6.7.8"}}, Count: 2, Tags: []string{"x"}, About: about},
+		// a nested field changes
+		{Peers: map[string]peer{"a": {Addr: "/ip4/1.2.3.4", Latency: 12}, "b": {Addr: "/ip4/5.6.7.8"}}, Count: 2, Tags: []string{"x"}, About: about},
+		// members are removed and lists replaced
+		{Peers: map[string]peer{"a": {Addr: "/ip4/1.2.3.4"}}, Count: 1, Tags: []string{"x", "y"}, About: about},
+	}
+	for i := 0; i < MergePatchSnapshotInterval; i++ {
+		states = append(states, &state{Peers: map[string]peer{"a": {Addr: "/ip4/1.2.3.4", Latency: i}}, Count: 1, Tags: []string{"x", "y"}, About: about})
+	}
+
+	cmd := &Command{
+		Run: func(req *Request, re ResponseEmitter, env Environment) error {
+			for i, s := range states {
+				if i == 1 {
+					// frames are sent as they are
+					if err := re.Emit(&Progress{ItemsDone: 1}); err != nil {
+						return err
+					}
+				}
+				if err := re.Emit(s); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Type: state{},
+	}
+
+	req, err := NewRequest(context.Background(), nil, nil, nil, nil, cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	re, err := NewWriterResponseEmitter(writecloser{&buf, nopCloser{}}, req, StreamWithEncoding(MergePatch))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewExecutor(cmd).Execute(req, re, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != len(states)+1 {
+		t.Fatalf("expected %d lines, got %d", len(states)+1, len(lines))
+	}
+	if exp := `{"Type":"patch","Patch":{"Peers":{"a":{"Latency":12}}}}`; lines[2] != exp {
+		t.Errorf("expected patch %s, got %s", exp, lines[2])
+	}
+	if exp := `{"Type":"patch","Patch":{"Count":1,"Peers":{"a":{"Latency":null},"b":null},"Tags":["x","y"]}}`; lines[3] != exp {
+		t.Errorf("expected patch %s, got %s", exp, lines[3])
+	}
+	var snapshots []int
+	for i, line := range lines {
+		if strings.HasPrefix(line, `{"Peers"`) {
+			snapshots = append(snapshots, i)
+		}
+	}
+	// the progress report is the second line
+	if exp := []int{0, MergePatchSnapshotInterval + 1}; !reflect.DeepEqual(snapshots, exp) {
+		t.Errorf("expected snapshots at lines %v, got %v", exp, snapshots)
+	}
+
+	res, err := NewReaderResponse(&buf, req, StreamWithEncoding(MergePatch))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := res.Next(); err != nil || !reflect.DeepEqual(v, states[0]) {
+		t.Fatalf("expected the first state, got %+v (%v)", v, err)
+	}
+	if v, err := res.Next(); err != nil || !reflect.DeepEqual(v, &Progress{ItemsDone: 1}) {
+		t.Fatalf("expected the progress report, got %+v (%v)", v, err)
+	}
+	for i, exp := range states[1:] {
+		v, err := res.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(v, exp) {
+			t.Fatalf("%d: expected %+v, got %+v", i, exp, v)
+		}
+	}
+	if _, err := res.Next(); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+}
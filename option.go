@@ -243,3 +243,154 @@ func (s *stringsOption) Parse(v string) (interface{}, error) {
 
 	return strings.Split(v, s.delimiter), nil
 }
+
+// FileValueOption makes the string or strings option opt accept values read
+// from files. On the command line, "@path" stands for the contents of the
+// file at path, and "@-" for the contents of stdin. Values starting with "@"
+// are passed as "@@...".
+func FileValueOption(opt Option) Option {
+	if kind := opt.Type(); kind != String && kind != Strings {
+		panic(fmt.Errorf("cannot read values of %s options from files", kind))
+	}
+	return &fileValueOption{Option: opt}
+}
+
+type fileValueOption struct {
+	Option
+}
+
+func (o *fileValueOption) WithDefault(v interface{}) Option {
+	o.Option = o.Option.WithDefault(v)
+	return o
+}
+
+// AcceptsFileValue reports whether opt accepts values read from files, see
+// FileValueOption.
+func AcceptsFileValue(opt Option) bool {
+	for ; opt != nil; opt = unwrapOption(opt) {
+		if _, ok := opt.(*fileValueOption); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// RenamedOption makes opt accept the names it had before, oldNames, so
+// renaming an option doesn't break the scripts and clients using the old
+// names. Requests setting an option by an old name have it rewritten to the
+// name of opt, with a warning, see Request.Warnings. Old names are left out
+// of help texts.
+func RenamedOption(opt Option, oldNames ...string) Option {
+	return &renamedOption{Option: opt, oldNames: oldNames}
+}
+
+type renamedOption struct {
+	Option
+	oldNames []string
+}
+
+func (o *renamedOption) WithDefault(v interface{}) Option {
+	o.Option = o.Option.WithDefault(v)
+	return o
+}
+
+// DeprecatedNames returns the old names opt still accepts, see
+// RenamedOption.
+func DeprecatedNames(opt Option) []string {
+	for ; opt != nil; opt = unwrapOption(opt) {
+		if o, ok := opt.(*renamedOption); ok {
+			return o.oldNames
+		}
+	}
+	return nil
+}
+
+// IsDeprecatedName reports whether name is one of the DeprecatedNames of opt.
+func IsDeprecatedName(opt Option, name string) bool {
+	for _, n := range DeprecatedNames(opt) {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// SensitiveOption marks opt as carrying secrets, e.g. a password or an API
+// token, whose values are left out of the records of the command lines run,
+// see cli.History.
+func SensitiveOption(opt Option) Option {
+	return &sensitiveOption{Option: opt}
+}
+
+type sensitiveOption struct {
+	Option
+}
+
+func (o *sensitiveOption) WithDefault(v interface{}) Option {
+	o.Option = o.Option.WithDefault(v)
+	return o
+}
+
+// IsSensitive reports whether opt carries secrets, see SensitiveOption.
+func IsSensitive(opt Option) bool {
+	for ; opt != nil; opt = unwrapOption(opt) {
+		if _, ok := opt.(*sensitiveOption); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// GroupedOption returns opt labeled with group, e.g. "Output options", so
+// help lists it in a section of that name, and spec generators can section
+// long option lists too, see OptionDescription.Group. Options without a
+// group are listed first, and groups in the order they first appear.
+func GroupedOption(group string, opt Option) Option {
+	return &groupedOption{Option: opt, group: group}
+}
+
+type groupedOption struct {
+	Option
+	group string
+}
+
+func (o *groupedOption) WithDefault(v interface{}) Option {
+	o.Option = o.Option.WithDefault(v)
+	return o
+}
+
+// OptionGroup returns the group of opt, see GroupedOption, and "" if it has
+// none.
+func OptionGroup(opt Option) string {
+	for ; opt != nil; opt = unwrapOption(opt) {
+		if g, ok := opt.(*groupedOption); ok {
+			return g.group
+		}
+	}
+	return ""
+}
+
+// optionNames returns the names and deprecated names of opt.
+func optionNames(opt Option) []string {
+	old := DeprecatedNames(opt)
+	if len(old) == 0 {
+		return opt.Names()
+	}
+	return append(append([]string(nil), opt.Names()...), old...)
+}
+
+// unwrapOption returns the option wrapped by opt, and nil if opt doesn't
+// wrap an option.
+func unwrapOption(opt Option) Option {
+	switch o := opt.(type) {
+	case *fileValueOption:
+		return o.Option
+	case *renamedOption:
+		return o.Option
+	case *sensitiveOption:
+		return o.Option
+	case *groupedOption:
+		return o.Option
+	}
+	return nil
+}
@@ -1,6 +1,8 @@
 package cmds
 
 import (
+	"context"
+	"errors"
 	"math"
 	"reflect"
 	"strings"
@@ -96,3 +98,106 @@ func TestDescription(t *testing.T) {
 		}
 	}
 }
+
+type color struct{ r, g, b uint8 }
+
+func TestOptionKind(t *testing.T) {
+	RegisterOptionKind(OptionKind{
+		Name: "color",
+		Parse: func(s string) (interface{}, error) {
+			var c color
+			if len(s) != 3 {
+				return nil, errors.New("expected three digits")
+			}
+			for i, p := range []*uint8{&c.r, &c.g, &c.b} {
+				if s[i] < '0' || s[i] > '9' {
+					return nil, errors.New("expected three digits")
+				}
+				*p = s[i] - '0'
+			}
+			return c, nil
+		},
+		Format: func(v interface{}) string {
+			c := v.(color)
+			return string([]byte{'0' + c.r, '0' + c.g, '0' + c.b})
+		},
+	})
+	t.Cleanup(func() {
+		optionKindsLk.Lock()
+		defer optionKindsLk.Unlock()
+		delete(optionKinds, "color")
+	})
+
+	opt := KindOption("color", "c", "The color.").WithDefault(color{1, 2, 3})
+	if opt.Type() != String || OptionTypeName(opt) != "color" {
+		t.Errorf("expected a string option of kind color, got %s (%s)", opt.Type(), OptionTypeName(opt))
+	}
+	if d := opt.Description(); d != "The color. Default: 123." {
+		t.Errorf("unexpected description %q", d)
+	}
+	if _, err := opt.Parse("12"); err == nil {
+		t.Error("expected a parse error")
+	}
+
+	root := &Command{Options: []Option{opt}}
+	for _, v := range []interface{}{"456", color{4, 5, 6}} {
+		req, err := NewRequest(context.Background(), nil, OptMap{"c": v}, nil, nil, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c := req.Options["c"]; c != (color{4, 5, 6}) {
+			t.Errorf("%v: expected parsed color, got %#v", v, c)
+		}
+	}
+	if _, err := NewRequest(context.Background(), nil, OptMap{"c": "abc"}, nil, nil, root); err == nil {
+		t.Error("expected an error for an invalid color")
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected a panic for a duplicate kind")
+			}
+		}()
+		RegisterOptionKind(OptionKind{Name: "color", Parse: func(string) (interface{}, error) { return nil, nil }})
+	}()
+}
+
+func TestRenamedOption(t *testing.T) {
+	opt := RenamedOption(StringOption("color", "c", "The color."), "colour")
+	root := &Command{Options: []Option{opt}}
+
+	optDefs, err := root.GetOptions(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if optDefs["colour"] != opt {
+		t.Error("expected the option by its old name")
+	}
+	if names := DeprecatedNames(FileValueOption(opt)); !reflect.DeepEqual(names, []string{"colour"}) {
+		t.Errorf("unexpected deprecated names %v", names)
+	}
+	if d := Describe(root).Options[0]; !reflect.DeepEqual(d.Names, []string{"color", "c"}) || !reflect.DeepEqual(d.DeprecatedNames, []string{"colour"}) {
+		t.Errorf("unexpected description %+v", d)
+	}
+
+	req, err := NewRequest(context.Background(), nil, OptMap{"colour": "red"}, nil, nil, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := req.Options["colour"]; ok || req.Options["color"] != "red" {
+		t.Errorf("expected the option to be renamed, got %v", req.Options)
+	}
+	if w := req.Warnings(); len(w) != 1 || w[0] != `option "colour" is deprecated, use "color" instead` {
+		t.Errorf("unexpected warnings %q", w)
+	}
+
+	if _, err := NewRequest(context.Background(), nil, OptMap{"colour": "red", "color": "blue"}, nil, nil, root); err == nil {
+		t.Error("expected an error for an option set by both names")
+	}
+
+	dup := &Command{Options: []Option{opt, StringOption("colour", "")}}
+	if errs := dup.DebugValidate(); len(errs) == 0 {
+		t.Error("expected an error for an old name used by another option")
+	}
+}
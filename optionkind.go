@@ -0,0 +1,147 @@
+package cmds
+
+import (
+	"fmt"
+	"sync"
+)
+
+// OptionKind is a kind of option values beyond the built-in types, e.g.
+// multiaddrs or CIDs. Options of a kind are passed as strings on the command
+// line and over HTTP, and commands get the values returned by Parse.
+type OptionKind struct {
+	// Name identifies the kind, e.g. "multiaddr". It is shown in help texts
+	// and command descriptions instead of the type.
+	Name string
+	// Parse parses a value passed as a string.
+	Parse func(string) (interface{}, error)
+	// Validate checks the values set without parsing, e.g. when Commands
+	// are called from Go. It is optional.
+	Validate func(interface{}) error
+	// Format returns the string that Parse parses into v. When unset,
+	// values are formatted with fmt.Sprint.
+	Format func(v interface{}) string
+}
+
+// FormatValue returns v as a string, which Parse parses back into v. Strings
+// are returned as they are.
+func (k *OptionKind) FormatValue(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	if k.Format != nil {
+		return k.Format(v)
+	}
+	return fmt.Sprint(v)
+}
+
+// convert returns the value of v, which is either a string or a parsed value.
+func (k *OptionKind) convert(v interface{}) (interface{}, error) {
+	if s, ok := v.(string); ok {
+		return k.Parse(s)
+	}
+	if k.Validate != nil {
+		if err := k.Validate(v); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+var (
+	optionKindsLk sync.RWMutex
+	optionKinds   = make(map[string]*OptionKind)
+)
+
+// RegisterOptionKind registers kind, so options of that kind can be created
+// with KindOption. It panics if a kind of the same name is already
+// registered.
+func RegisterOptionKind(kind OptionKind) {
+	if kind.Name == "" || kind.Parse == nil {
+		panic("option kinds need a name and a Parse function")
+	}
+
+	optionKindsLk.Lock()
+	defer optionKindsLk.Unlock()
+	if _, ok := optionKinds[kind.Name]; ok {
+		panic(fmt.Errorf("option kind %q is already registered", kind.Name))
+	}
+	optionKinds[kind.Name] = &kind
+}
+
+// LookupOptionKind returns the registered kind called name.
+func LookupOptionKind(name string) (*OptionKind, bool) {
+	optionKindsLk.RLock()
+	defer optionKindsLk.RUnlock()
+	kind, ok := optionKinds[name]
+	return kind, ok
+}
+
+// KindOption returns an option taking values of the registered kind called
+// kind. Its Type is String, as its values are passed as strings; defaults
+// can be given either as strings or as parsed values. It panics if the kind
+// isn't registered.
+//
+// Like for the other {Type}Option functions, the last name is treated as the
+// description.
+func KindOption(kind string, names ...string) Option {
+	k, ok := LookupOptionKind(kind)
+	if !ok {
+		panic(fmt.Errorf("unknown option kind %q", kind))
+	}
+	return &kindOption{Option: NewOption(String, names...), kind: k}
+}
+
+type kindOption struct {
+	Option
+	kind       *OptionKind
+	defaultVal interface{}
+}
+
+func (o *kindOption) Parse(v string) (interface{}, error) {
+	val, err := o.kind.Parse(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q: %s", o.kind.Name, v, err)
+	}
+	return val, nil
+}
+
+func (o *kindOption) WithDefault(v interface{}) Option {
+	if v == nil {
+		panic(fmt.Errorf("cannot use nil as a default"))
+	}
+	val, err := o.kind.convert(v)
+	if err != nil {
+		panic(fmt.Errorf("invalid default for %s option: %s", o.kind.Name, err))
+	}
+	// the description shows the default as it is passed
+	o.Option = o.Option.WithDefault(o.kind.FormatValue(val))
+	o.defaultVal = val
+	return o
+}
+
+func (o *kindOption) Default() interface{} {
+	return o.defaultVal
+}
+
+// OptionKindOf returns the kind of opt, or nil if opt takes values of a
+// built-in type.
+func OptionKindOf(opt Option) *OptionKind {
+	for ; opt != nil; opt = unwrapOption(opt) {
+		if o, ok := opt.(*kindOption); ok {
+			return o.kind
+		}
+	}
+	return nil
+}
+
+// OptionTypeName returns the name of the type of the values of opt, as
+// shown in help texts and command descriptions.
+func OptionTypeName(opt Option) string {
+	if kind := OptionKindOf(opt); kind != nil {
+		return kind.Name
+	}
+	if opt.Type() == Strings {
+		return "strings"
+	}
+	return opt.Type().String()
+}
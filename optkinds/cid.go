@@ -0,0 +1,161 @@
+package optkinds
+
+import (
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// multihash code of sha2-256, the hash of CIDv0
+	sha256Code = 0x12
+	// multicodec of CIDs of peer IDs
+	libp2pKeyCodec = 0x72
+
+	base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+)
+
+var (
+	base32Lower = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+	base32Upper = base32.NewEncoding("ABCDEFGHIJKLMNOPQRSTUVWXYZ234567").WithPadding(base32.NoPadding)
+)
+
+// ParseCID checks that s is a CID, either a base58 CIDv0 or a CIDv1 in one
+// of the common multibase encodings.
+func ParseCID(s string) (CID, error) {
+	if _, err := decodeCID(s); err != nil {
+		return "", err
+	}
+	return CID(s), nil
+}
+
+// ParsePeerID checks that s is a peer ID, either a base58 multihash or a
+// CIDv1 of a libp2p key.
+func ParsePeerID(s string) (PeerID, error) {
+	if strings.HasPrefix(s, "Qm") || strings.HasPrefix(s, "1") {
+		b, err := decodeBase58(s)
+		if err != nil {
+			return "", err
+		}
+		if err := checkMultihash(b); err != nil {
+			return "", err
+		}
+		return PeerID(s), nil
+	}
+
+	codec, err := decodeCID(s)
+	if err != nil {
+		return "", err
+	}
+	if codec != libp2pKeyCodec {
+		return "", fmt.Errorf("CID of codec 0x%x isn't a peer ID", codec)
+	}
+	return PeerID(s), nil
+}
+
+// decodeCID checks that s is a CID and returns its codec.
+func decodeCID(s string) (uint64, error) {
+	if len(s) == 46 && strings.HasPrefix(s, "Qm") {
+		b, err := decodeBase58(s)
+		if err != nil {
+			return 0, err
+		}
+		if len(b) != 34 || b[0] != sha256Code || b[1] != 32 {
+			return 0, errors.New("invalid CIDv0")
+		}
+		// dag-pb
+		return 0x70, nil
+	}
+
+	b, err := decodeMultibase(s)
+	if err != nil {
+		return 0, err
+	}
+	version, n := binary.Uvarint(b)
+	if n <= 0 || version != 1 {
+		return 0, errors.New("unsupported CID version")
+	}
+	b = b[n:]
+	codec, n := binary.Uvarint(b)
+	if n <= 0 {
+		return 0, errors.New("invalid CID codec")
+	}
+	if err := checkMultihash(b[n:]); err != nil {
+		return 0, err
+	}
+	return codec, nil
+}
+
+// checkMultihash checks that b is exactly one multihash.
+func checkMultihash(b []byte) error {
+	_, n := binary.Uvarint(b)
+	if n <= 0 {
+		return errors.New("invalid multihash code")
+	}
+	b = b[n:]
+	length, n := binary.Uvarint(b)
+	if n <= 0 {
+		return errors.New("invalid multihash length")
+	}
+	if uint64(len(b[n:])) != length {
+		return fmt.Errorf("multihash digest is %d bytes, expected %d", len(b[n:]), length)
+	}
+	return nil
+}
+
+func decodeMultibase(s string) ([]byte, error) {
+	if s == "" {
+		return nil, errors.New("empty string")
+	}
+	data := s[1:]
+	switch s[0] {
+	case 'b':
+		return base32Lower.DecodeString(data)
+	case 'B':
+		return base32Upper.DecodeString(data)
+	case 'z':
+		return decodeBase58(data)
+	case 'f', 'F':
+		return hex.DecodeString(data)
+	default:
+		return nil, fmt.Errorf("unsupported multibase prefix %q", s[0])
+	}
+}
+
+func decodeBase58(s string) ([]byte, error) {
+	if s == "" {
+		return nil, errors.New("empty base58 string")
+	}
+
+	// leading ones are leading zero bytes
+	var zeros int
+	for zeros < len(s) && s[zeros] == '1' {
+		zeros++
+	}
+
+	// b holds the decoded number, least significant byte first
+	var b []byte
+	for i := 0; i < len(s); i++ {
+		carry := strings.IndexByte(base58Alphabet, s[i])
+		if carry < 0 {
+			return nil, fmt.Errorf("invalid base58 character %q", s[i])
+		}
+		for j := range b {
+			carry += int(b[j]) * 58
+			b[j] = byte(carry)
+			carry >>= 8
+		}
+		for ; carry > 0; carry >>= 8 {
+			b = append(b, byte(carry))
+		}
+	}
+
+	out := make([]byte, zeros, zeros+len(b))
+	for i := len(b) - 1; i >= 0; i-- {
+		out = append(out, b[i])
+	}
+	return out, nil
+}
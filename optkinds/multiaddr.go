@@ -0,0 +1,128 @@
+package optkinds
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// protocols are the multiaddr protocols with a value, and the checks of
+// their values.
+var protocols = map[string]func(string) error{
+	"ip4":      checkIP4,
+	"ip6":      checkIP6,
+	"ip6zone":  checkNonEmpty,
+	"ipcidr":   checkUint8,
+	"tcp":      checkPort,
+	"udp":      checkPort,
+	"dccp":     checkPort,
+	"sctp":     checkPort,
+	"dns":      checkNonEmpty,
+	"dns4":     checkNonEmpty,
+	"dns6":     checkNonEmpty,
+	"dnsaddr":  checkNonEmpty,
+	"sni":      checkNonEmpty,
+	"certhash": checkMultibase,
+	"p2p":      checkPeerID,
+	"ipfs":     checkPeerID,
+}
+
+// flagProtocols are the multiaddr protocols without a value.
+var flagProtocols = map[string]bool{
+	"quic":          true,
+	"quic-v1":       true,
+	"webtransport":  true,
+	"webrtc":        true,
+	"webrtc-direct": true,
+	"ws":            true,
+	"wss":           true,
+	"tls":           true,
+	"noise":         true,
+	"http":          true,
+	"https":         true,
+	"p2p-circuit":   true,
+	"utp":           true,
+	"udt":           true,
+}
+
+// ParseMultiaddr checks that s is a multiaddr of well-known protocols.
+func ParseMultiaddr(s string) (Multiaddr, error) {
+	if !strings.HasPrefix(s, "/") {
+		return "", errors.New("multiaddrs must begin with /")
+	}
+	parts := strings.Split(strings.TrimSuffix(s[1:], "/"), "/")
+	if len(parts) == 1 && parts[0] == "" {
+		return "", errors.New("empty multiaddr")
+	}
+
+	for i := 0; i < len(parts); i++ {
+		name := parts[i]
+		switch {
+		case name == "unix":
+			// the path is the rest of the address
+			if i+1 == len(parts) {
+				return "", errors.New("unix: missing path")
+			}
+			return Multiaddr(s), nil
+		case flagProtocols[name]:
+		case protocols[name] != nil:
+			if i+1 == len(parts) {
+				return "", fmt.Errorf("%s: missing value", name)
+			}
+			i++
+			if err := protocols[name](parts[i]); err != nil {
+				return "", fmt.Errorf("%s: %s", name, err)
+			}
+		default:
+			return "", fmt.Errorf("unknown protocol %q", name)
+		}
+	}
+	return Multiaddr(s), nil
+}
+
+func checkIP4(s string) error {
+	if ip := net.ParseIP(s); ip == nil || ip.To4() == nil || strings.Contains(s, ":") {
+		return fmt.Errorf("invalid IPv4 address %q", s)
+	}
+	return nil
+}
+
+func checkIP6(s string) error {
+	if ip := net.ParseIP(s); ip == nil || !strings.Contains(s, ":") {
+		return fmt.Errorf("invalid IPv6 address %q", s)
+	}
+	return nil
+}
+
+func checkPort(s string) error {
+	if _, err := strconv.ParseUint(s, 10, 16); err != nil {
+		return fmt.Errorf("invalid port %q", s)
+	}
+	return nil
+}
+
+func checkUint8(s string) error {
+	if _, err := strconv.ParseUint(s, 10, 8); err != nil {
+		return fmt.Errorf("invalid value %q", s)
+	}
+	return nil
+}
+
+func checkNonEmpty(s string) error {
+	if s == "" {
+		return errors.New("empty value")
+	}
+	return nil
+}
+
+func checkMultibase(s string) error {
+	_, err := decodeMultibase(s)
+	return err
+}
+
+func checkPeerID(s string) error {
+	_, err := ParsePeerID(s)
+	return err
+}
@@ -0,0 +1,98 @@
+// Package optkinds registers option kinds for the addresses and identifiers
+// of IPFS-adjacent applications: multiaddrs, CIDs and peer IDs. Importing it
+// registers the kinds, and options of these kinds are created with
+// MultiaddrOption, CIDOption and PeerIDOption. The values commands get are
+// validated, but kept in their string form as Multiaddr, CID and PeerID, so
+// the package doesn't depend on go-multiaddr or go-cid. Applications that do
+// can register kinds parsing into those types instead, see
+// cmds.RegisterOptionKind.
+package optkinds
+
+import (
+	"fmt"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+// Names of the kinds registered by the package.
+const (
+	MultiaddrKind = "multiaddr"
+	CIDKind       = "cid"
+	PeerIDKind    = "peerid"
+)
+
+// Multiaddr is a multiaddr in its string form, e.g. "/ip4/1.2.3.4/tcp/4001".
+type Multiaddr string
+
+func (m Multiaddr) String() string { return string(m) }
+
+// CID is a CID in its string form, e.g. "bafy...".
+type CID string
+
+func (c CID) String() string { return string(c) }
+
+// PeerID is a peer ID in its string form, e.g. "12D3KooW...".
+type PeerID string
+
+func (p PeerID) String() string { return string(p) }
+
+func init() {
+	cmds.RegisterOptionKind(cmds.OptionKind{
+		Name: MultiaddrKind,
+		Parse: func(s string) (interface{}, error) {
+			return ParseMultiaddr(s)
+		},
+		Validate: func(v interface{}) error {
+			m, ok := v.(Multiaddr)
+			if !ok {
+				return fmt.Errorf("expected a Multiaddr, got %T", v)
+			}
+			_, err := ParseMultiaddr(string(m))
+			return err
+		},
+	})
+	cmds.RegisterOptionKind(cmds.OptionKind{
+		Name: CIDKind,
+		Parse: func(s string) (interface{}, error) {
+			return ParseCID(s)
+		},
+		Validate: func(v interface{}) error {
+			c, ok := v.(CID)
+			if !ok {
+				return fmt.Errorf("expected a CID, got %T", v)
+			}
+			_, err := ParseCID(string(c))
+			return err
+		},
+	})
+	cmds.RegisterOptionKind(cmds.OptionKind{
+		Name: PeerIDKind,
+		Parse: func(s string) (interface{}, error) {
+			return ParsePeerID(s)
+		},
+		Validate: func(v interface{}) error {
+			p, ok := v.(PeerID)
+			if !ok {
+				return fmt.Errorf("expected a PeerID, got %T", v)
+			}
+			_, err := ParsePeerID(string(p))
+			return err
+		},
+	})
+}
+
+// MultiaddrOption is an option taking a Multiaddr. Like for the
+// cmds.{Type}Option functions, the last name is the description.
+func MultiaddrOption(names ...string) cmds.Option {
+	return cmds.KindOption(MultiaddrKind, names...)
+}
+
+// CIDOption is an option taking a CID.
+func CIDOption(names ...string) cmds.Option {
+	return cmds.KindOption(CIDKind, names...)
+}
+
+// PeerIDOption is an option taking a PeerID.
+func PeerIDOption(names ...string) cmds.Option {
+	return cmds.KindOption(PeerIDKind, names...)
+}
@@ -0,0 +1,80 @@
+package optkinds
+
+import (
+	"context"
+	"testing"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+func TestParse(t *testing.T) {
+	type testcase struct {
+		kind  string
+		value string
+		ok    bool
+	}
+
+	tcs := []testcase{
+		{kind: MultiaddrKind, value: "/ip4/127.0.0.1/tcp/4001", ok: true},
+		{kind: MultiaddrKind, value: "/ip6/::1/udp/4001/quic-v1", ok: true},
+		{kind: MultiaddrKind, value: "/dns4/example.com/tcp/443/wss/p2p/12D3KooWD3eckifWpRn9wQpMG9R9hX3sD158z7EqHWmweQAJU5SA", ok: true},
+		{kind: MultiaddrKind, value: "/unix/tmp/ipfs.sock", ok: true},
+		{kind: MultiaddrKind, value: "ip4/127.0.0.1"},
+		{kind: MultiaddrKind, value: "/ip4/::1"},
+		{kind: MultiaddrKind, value: "/ip4/127.0.0.1/tcp/70000"},
+		{kind: MultiaddrKind, value: "/ip4/127.0.0.1/tcp"},
+		{kind: MultiaddrKind, value: "/foo/bar"},
+
+		{kind: CIDKind, value: "QmYwAPJzv5CZsnA625s3Xf2nemtYgPpHdWEz79ojWnPbdG", ok: true},
+		{kind: CIDKind, value: "bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi", ok: true},
+		{kind: CIDKind, value: "QmYwAPJzv5CZsnA625s3Xf2nemtYgPpHdWEz79ojWnPb0G"},
+		{kind: CIDKind, value: "bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbz"},
+		{kind: CIDKind, value: "hello"},
+
+		{kind: PeerIDKind, value: "12D3KooWD3eckifWpRn9wQpMG9R9hX3sD158z7EqHWmweQAJU5SA", ok: true},
+		{kind: PeerIDKind, value: "QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC", ok: true},
+		// a CID, but not of a key
+		{kind: PeerIDKind, value: "bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi"},
+		{kind: PeerIDKind, value: "12D3KooW0"},
+	}
+
+	for _, tc := range tcs {
+		kind, ok := cmds.LookupOptionKind(tc.kind)
+		if !ok {
+			t.Fatalf("kind %s isn't registered", tc.kind)
+		}
+		v, err := kind.Parse(tc.value)
+		if tc.ok && err != nil {
+			t.Errorf("%s %q: unexpected error: %s", tc.kind, tc.value, err)
+		}
+		if !tc.ok && err == nil {
+			t.Errorf("%s %q: expected an error, got %v", tc.kind, tc.value, v)
+		}
+	}
+}
+
+func TestOptions(t *testing.T) {
+	root := &cmds.Command{
+		Options: []cmds.Option{
+			MultiaddrOption("addr", "The address to listen on."),
+			PeerIDOption("peer", "The peer to connect to."),
+		},
+	}
+
+	req, err := cmds.NewRequest(context.Background(), nil, cmds.OptMap{
+		"addr": "/ip4/0.0.0.0/tcp/4001",
+		"peer": PeerID("12D3KooWD3eckifWpRn9wQpMG9R9hX3sD158z7EqHWmweQAJU5SA"),
+	}, nil, nil, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr, ok := req.Options["addr"].(Multiaddr); !ok || addr != "/ip4/0.0.0.0/tcp/4001" {
+		t.Errorf("expected a Multiaddr, got %#v", req.Options["addr"])
+	}
+
+	for _, opts := range []cmds.OptMap{{"addr": "0.0.0.0:4001"}, {"peer": PeerID("bad")}} {
+		if _, err := cmds.NewRequest(context.Background(), nil, opts, nil, nil, root); err == nil {
+			t.Errorf("%v: expected an error", opts)
+		}
+	}
+}
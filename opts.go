@@ -4,6 +4,8 @@ package cmds
 const (
 	EncShort     = "enc"
 	EncLong      = "encoding"
+	EncPretty    = "enc-pretty"
+	EncCanonical = "enc-canonical"
 	RecShort     = "r"
 	RecLong      = "recursive"
 	ChanOpt      = "stream-channels"
@@ -16,10 +18,59 @@ const (
 	HiddenShort  = "H"
 	Ignore       = "ignore"
 	IgnoreRules  = "ignore-rules-path"
+	EnvelopeOpt  = "envelope"
+	LimitOpt     = "limit"
+	OffsetOpt    = "offset"
+	CursorOpt    = "cursor"
+	AllOpt       = "all"
+	DryRunOpt    = "dry-run"
+	CollectOpt   = "collect"
+	SelectOpt    = "select"
+	SecretsOpt   = "show-secrets"
+	ArrayOpt     = "stream-array"
+
+	TimestampsOpt   = "timestamps"
+	ShowDurationOpt = "show-duration"
+	LocaleOpt       = "locale"
+	ProgressRateOpt = "progress-rate"
+	TableOpt        = "table"
+	NoHeaderOpt     = "no-header"
+	MachineOutOpt   = "machine-output"
+	HelpWidthOpt    = "help-width"
+	HelpPlainOpt    = "help-plain"
 )
 
 // options that are used by this package
 var OptionEncodingType = StringOption(EncLong, EncShort, "The encoding type the output should be encoded with (json, xml, or text)").WithDefault("text")
+
+// ClientSideOptions are the options changing how values are written rather
+// than which values a command emits: OptionEncodingPretty,
+// OptionEncodingCanonical, OptionSelect and OptionStreamArray. The HTTP
+// handler honors them as query parameters even if the command tree doesn't
+// declare them, while the HTTP client doesn't send them: the CLI applies them
+// to the values it decodes itself. Applications supporting them add them to
+// the options of their root command.
+var ClientSideOptions = map[string]bool{
+	EncPretty:    true,
+	EncCanonical: true,
+	SelectOpt:    true,
+	ArrayOpt:     true,
+}
+
+// OptionEncodingPretty makes the global JSON encoder indent its output, so
+// commands get readable JSON without encoders of their own. The JSON
+// encoders of commands are left alone. It is one of the ClientSideOptions.
+var OptionEncodingPretty = BoolOption(EncPretty, "Indent JSON output")
+
+// OptionEncodingCanonical makes the global JSON and NDJSON encoders write
+// canonical JSON, which is byte for byte the same for equal values, e.g. to
+// diff the output of commands in CI: the keys of objects are sorted, numbers
+// are written in their shortest form, e.g. 1.50 as 1.5, and strings without
+// HTML escapes. It is compact unless OptionEncodingPretty indents it too.
+// The JSON encoders of commands are left alone. It is one of the
+// ClientSideOptions.
+var OptionEncodingCanonical = BoolOption(EncCanonical, "Output canonical JSON, with sorted keys, that is stable across runs")
+
 var OptionRecursivePath = BoolOption(RecLong, RecShort, "Add directory paths recursively")
 var OptionStreamChannels = BoolOption(ChanOpt, "Stream channel output")
 var OptionTimeout = StringOption(TimeoutOpt, "Set a global timeout on the command")
@@ -28,3 +79,62 @@ var OptionStdinName = StringOption(StdinName, "Assign a name if the file source
 var OptionHidden = BoolOption(Hidden, HiddenShort, "Include files that are hidden. Only takes effect on recursive add.")
 var OptionIgnore = StringsOption(Ignore, "A rule (.gitignore-stype) defining which file(s) should be ignored (variadic, experimental)")
 var OptionIgnoreRules = StringOption(IgnoreRules, "A path to a file with .gitignore-style ignore rules (experimental)")
+var OptionEnvelope = BoolOption(EnvelopeOpt, "Append a trailer with execution metadata to the response")
+var OptionLimit = IntOption(LimitOpt, "Return at most this many entries")
+var OptionOffset = IntOption(OffsetOpt, "Skip this many entries")
+var OptionCursor = StringOption(CursorOpt, "Continue listing where the previous page ended")
+var OptionAll = BoolOption(AllOpt, "Fetch all pages")
+var OptionDryRun = BoolOption(DryRunOpt, "Show what the command would do, without doing it")
+
+// OptionCollect makes the CLI buffer the output of a command and encode it
+// as one slice, e.g. to print totals or tables sized to their content.
+// Commands opt in by listing it in their options, and their encoders must
+// then accept slices of the values they emit.
+var OptionCollect = BoolOption(CollectOpt, "Buffer all output and print it at once")
+
+// OptionSelect makes the output of commands the part of every value at a
+// path in its JSON, e.g. .Name, .Entries[0] or .Entries[].Size, which selects
+// the Size of every entry, in any encoding. Missing fields select null. It
+// is one of the ClientSideOptions.
+var OptionSelect = StringOption(SelectOpt, "Output only the part of each value at this path, e.g. .Name or .Entries[].Size")
+
+// OptionStreamArray makes JSON output a single JSON array of all emitted
+// values, one per line, instead of a stream of values, for consumers that
+// need one parseable document. Warnings and progress reports are elements
+// like the values. It requires a JSON encoding. It is one of the
+// ClientSideOptions.
+var OptionStreamArray = BoolOption(ArrayOpt, "Output JSON as a single array of all values")
+
+// OptionShowSecrets makes encoders write the values of the fields tagged
+// `cmds:"redact"`, e.g. tokens, which they mask otherwise: strings read
+// Redacted, and other values are left out as zero values. The HTTP client
+// sends it, so servers send the secrets too. Applications supporting it add
+// it to the options of their root command.
+var OptionShowSecrets = BoolOption(SecretsOpt, "Show the values of secrets in the output")
+
+// OptionTimestamps makes the CLI prefix every line of output with the time
+// it was printed, and OptionShowDuration makes it print how long the command
+// took once it's done, e.g. when the output of long-running commands goes to
+// logs. Applications add them to the options of their root command.
+var OptionTimestamps = BoolOption(TimestampsOpt, "Prefix output lines with timestamps")
+var OptionShowDuration = BoolOption(ShowDurationOpt, "Print how long the command took")
+
+// OptionMachineOutput makes the CLI write the output of commands in JSON to
+// a file as well, while it prints it as usual, so wrapper scripts can capture
+// the results of interactive runs. The value is the path of the file, or
+// fd:N for the file descriptor N, e.g. fd:3. Applications supporting it add
+// it to the options of their root command.
+var OptionMachineOutput = StringOption(MachineOutOpt, "Also write the output as JSON to this file, or to fd:N")
+
+// OptionLocale selects the locale numbers, sizes and durations are formatted
+// in by Text encoders, see LocaleOf. Applications supporting it add it to the
+// options of their root command.
+var OptionLocale = StringOption(LocaleOpt, "Format numbers in text output for this locale, e.g. de or fr-CH")
+
+// OptionHelpWidth sets the width the CLI wraps help text at, instead of that
+// of the terminal, and OptionHelpPlain makes it write plain help: wrapped at
+// a fixed width whatever the terminal, and without ANSI escape sequences, so
+// help renders the same in docs pipelines and CI. Applications add them to
+// the options of their root command.
+var OptionHelpWidth = IntOption(HelpWidthOpt, "Wrap help text at this width")
+var OptionHelpPlain = BoolOption(HelpPlainOpt, "Print help without terminal detection and escape sequences")
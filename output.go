@@ -0,0 +1,298 @@
+package cmds
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"reflect"
+)
+
+// OutputType is a type of the values a command emits besides Command.Type,
+// e.g. the progress reports a command sends before its result. Values of
+// output types are tagged with their name in JSON streams, so clients decode
+// each value into its type, see Command.OutputTypes.
+type OutputType struct {
+	// Name tags the values of the type.
+	Name string
+	// Type is a value of the type, like Command.Type.
+	Type interface{}
+	// Encoders encode values of the type in encodings other than JSON.
+	// The global encoder is used for encodings it lacks.
+	Encoders EncoderMap
+}
+
+// baseType returns the type of v, without pointers.
+func baseType(v interface{}) reflect.Type {
+	t := reflect.TypeOf(v)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// outputType returns the output type of cmd v belongs to, if any.
+func outputType(cmd *Command, v interface{}) (*OutputType, bool) {
+	if cmd == nil || len(cmd.OutputTypes) == 0 {
+		return nil, false
+	}
+	t := baseType(v)
+	if t == nil {
+		return nil, false
+	}
+	for i := range cmd.OutputTypes {
+		if baseType(cmd.OutputTypes[i].Type) == t {
+			return &cmd.OutputTypes[i], true
+		}
+	}
+	return nil, false
+}
+
+// TagValue returns v as it is sent in JSON streams: tagged with the name of
+// its type if it is one of the output types of cmd, as is otherwise.
+func TagValue(cmd *Command, v interface{}) interface{} {
+	if ot, ok := outputType(cmd, v); ok {
+		return taggedValue{Name: ot.Name, Value: v}
+	}
+	return v
+}
+
+type untaggedKey struct{}
+
+// ContextWithoutTags returns a copy of ctx making the encoders of requests
+// with it send the values of output types as they are, and leave Progress
+// values out, for clients predating them.
+func ContextWithoutTags(ctx context.Context) context.Context {
+	return context.WithValue(ctx, untaggedKey{}, true)
+}
+
+// TagsDisabled reports whether values are sent without tags for requests
+// with ctx, see ContextWithoutTags.
+func TagsDisabled(ctx context.Context) bool {
+	untagged, _ := ctx.Value(untaggedKey{}).(bool)
+	return untagged
+}
+
+// taggedValue is a value of an output type in a JSON stream.
+type taggedValue struct {
+	Name  string
+	Value interface{}
+}
+
+func (t taggedValue) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Type  string
+		Name  string
+		Value interface{}
+	}{
+		Type:  "output",
+		Name:  t.Name,
+		Value: t.Value,
+	})
+}
+
+// decodeTagged decodes data into a new value of the output type it is
+// tagged with. It returns false if data isn't a tagged value.
+func decodeTagged(data []byte, outputs []OutputType) (interface{}, bool, error) {
+	if !bytes.Contains(data, []byte(`"output"`)) {
+		return nil, false, nil
+	}
+	var t struct {
+		Type  string
+		Name  string
+		Value json.RawMessage
+	}
+	if json.Unmarshal(data, &t) != nil || t.Type != "output" || t.Name == "" {
+		return nil, false, nil
+	}
+
+	var value interface{}
+	for _, ot := range outputs {
+		if ot.Name == t.Name {
+			if typ := baseType(ot.Type); typ != nil {
+				value = reflect.New(typ).Interface()
+			}
+			break
+		}
+	}
+	if value == nil {
+		// values of types the client doesn't know are decoded into
+		// whatever the json decoder finds appropriate
+		err := json.Unmarshal(t.Value, &value)
+		return value, true, err
+	}
+	err := json.Unmarshal(t.Value, value)
+	return value, true, err
+}
+
+// outputEncoder encodes the values of the output types of a command with
+// their encoders, Progress and Warning values with the global encoders, and
+// the other values with the encoder of the command.
+type outputEncoder struct {
+	req     *Request
+	w       io.Writer
+	encType EncodingType
+	def     Encoder
+
+	encs     map[string]Encoder
+	frameEnc Encoder
+
+	// untagged is set if tags are disabled, see ContextWithoutTags.
+	untagged bool
+	// defOnly is set if def encodes the values of output types too, as
+	// tables do, see OptionTable.
+	defOnly bool
+	// secrets is set if the values of fields holding secrets are shown,
+	// see OptionShowSecrets.
+	secrets bool
+
+	// sel encodes the values if the select option is set, see
+	// OptionSelect. selErr is the error of an invalid one.
+	sel    *selectEncoder
+	selErr error
+
+	// arr writes the values as one JSON array if the stream-array option is
+	// set, see OptionStreamArray. arrErr is the error of an encoding that
+	// isn't JSON.
+	arr    *arrayWriter
+	arrErr error
+}
+
+// newEncoder returns the encoder fn creates for req, wrapped to encode
+// Progress values and the values of the output types of the command.
+func newEncoder(req *Request, w io.Writer, encType EncodingType, fn EncoderFunc) Encoder {
+	var arr *arrayWriter
+	array, arrErr := streamArray(req)
+	if array {
+		if encType.IsJSON() {
+			arr = &arrayWriter{w: w}
+			w = arr
+		} else {
+			arrErr = Errorf(ErrClient, "%s needs a JSON encoding, not %s", ArrayOpt, encType)
+		}
+	}
+
+	e := &outputEncoder{
+		req:     req,
+		w:       w,
+		encType: encType,
+		def:     fn(req)(w),
+		encs:    make(map[string]Encoder),
+
+		untagged: req.Context != nil && TagsDisabled(req.Context),
+		secrets:  showSecrets(req),
+
+		arr:    arr,
+		arrErr: arrErr,
+	}
+	if s, ok := req.Options[SelectOpt].(string); ok && s != "" {
+		sel, err := parseSelector(s)
+		if err != nil {
+			e.selErr = Errorf(ErrClient, "%s", err)
+		} else {
+			e.sel, e.selErr = newSelectEncoder(req, w, encType, sel)
+		}
+	}
+	return e
+}
+
+func (e *outputEncoder) Encode(v interface{}) error {
+	if e.arrErr != nil {
+		return e.arrErr
+	}
+	if e.arr == nil {
+		return e.encode(v)
+	}
+	e.arr.begin()
+	err := e.encode(v)
+	if endErr := e.arr.end(); err == nil {
+		err = endErr
+	}
+	return err
+}
+
+func (e *outputEncoder) encode(v interface{}) error {
+	if _, warning := v.(Warning); warning || isProgress(v) {
+		// CBOR and protobuf streams carry values only, see
+		// MaybeError.UnmarshalCBOR
+		if e.untagged || e.encType == CBOR || e.encType == Protobuf {
+			return nil
+		}
+		if e.frameEnc == nil {
+			fn, ok := Encoders[e.encType]
+			if !ok {
+				return Errorf(ErrClient, "invalid encoding %s for progress reports and warnings", e.encType)
+			}
+			e.frameEnc = fn(e.req)(e.w)
+		}
+		return e.frameEnc.Encode(v)
+	}
+
+	if !e.secrets {
+		v = redact(v)
+	}
+	v, err := postProcess(e.req, e.encType, v)
+	if err != nil {
+		return err
+	}
+	if e.selErr != nil {
+		return e.selErr
+	}
+	if e.sel != nil {
+		return e.sel.Encode(v)
+	}
+
+	ot, ok := outputType(e.req.Command, v)
+	if !ok || e.defOnly {
+		return e.def.Encode(v)
+	}
+	if e.encType == MergePatch {
+		// values are patches from the previous one, whatever its type
+		if !e.untagged {
+			v = TagValue(e.req.Command, v)
+		}
+		return e.def.Encode(v)
+	}
+
+	enc, ok := e.encs[ot.Name]
+	if !ok {
+		fn, found := ot.Encoders[e.encType]
+		if e.encType.IsJSON() || !found {
+			fn, found = Encoders[e.encType]
+		}
+		if !found {
+			return Errorf(ErrClient, "invalid encoding %s for values of type %s", e.encType, ot.Name)
+		}
+		enc = fn(e.req)(e.w)
+		e.encs[ot.Name] = enc
+	}
+
+	if e.encType.IsJSON() && !e.untagged {
+		v = TagValue(e.req.Command, v)
+	}
+	return enc.Encode(v)
+}
+
+func (e *outputEncoder) Finish(err error) error {
+	ferr := FinishEncoder(e.def, err)
+	if e.sel != nil {
+		if selErr := FinishEncoder(e.sel.enc, err); selErr != nil && ferr == nil {
+			ferr = selErr
+		}
+	}
+	if e.req.Command != nil {
+		for _, ot := range e.req.Command.OutputTypes {
+			if enc, ok := e.encs[ot.Name]; ok {
+				if encErr := FinishEncoder(enc, err); encErr != nil && ferr == nil {
+					ferr = encErr
+				}
+			}
+		}
+	}
+	if e.arr != nil {
+		if arrErr := e.arr.close(); arrErr != nil && ferr == nil {
+			ferr = arrErr
+		}
+	}
+	return ferr
+}
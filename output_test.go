@@ -0,0 +1,113 @@
+package cmds
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+)
+
+type testProgress struct {
+	Done int
+}
+
+type testResult struct {
+	Hash string
+}
+
+var outputCmd = &Command{
+	Run: func(req *Request, re ResponseEmitter, env Environment) error {
+		for i := 1; i <= 2; i++ {
+			if err := re.Emit(&testProgress{Done: i}); err != nil {
+				return err
+			}
+		}
+		return re.Emit(&testResult{Hash: "Qm"})
+	},
+	Type: testResult{},
+	OutputTypes: []OutputType{{
+		Name: "progress",
+		Type: testProgress{},
+		Encoders: EncoderMap{
+			Text: MakeTypedEncoder(func(req *Request, w io.Writer, p *testProgress) error {
+				_, err := fmt.Fprintf(w, "progress: %d\n", p.Done)
+				return err
+			}),
+		},
+	}},
+	Encoders: EncoderMap{
+		Text: MakeTypedEncoder(func(req *Request, w io.Writer, r *testResult) error {
+			_, err := fmt.Fprintf(w, "added %s\n", r.Hash)
+			return err
+		}),
+	},
+}
+
+func TestOutputTypes(t *testing.T) {
+	run := func(encType EncodingType) *bytes.Buffer {
+		req, err := NewRequest(context.Background(), nil, nil, nil, nil, outputCmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		re, err := NewWriterResponseEmitter(writecloser{&buf, nopCloser{}}, req, StreamWithEncoding(encType))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := NewExecutor(outputCmd).Execute(req, re, nil); err != nil {
+			t.Fatal(err)
+		}
+		return &buf
+	}
+
+	// text values are encoded with the encoders of their type
+	if out, exp := run(Text).String(), "progress: 1\nprogress: 2\nadded Qm\n"; out != exp {
+		t.Errorf("expected text output %q, got %q", exp, out)
+	}
+
+	// JSON values are tagged with their type and decoded into it
+	buf := run(JSON)
+	if !bytes.Contains(buf.Bytes(), []byte(`{"Type":"output","Name":"progress","Value":{"Done":1}}`)) {
+		t.Errorf("progress values aren't tagged: %s", buf)
+	}
+
+	req, err := NewRequest(context.Background(), nil, nil, nil, nil, outputCmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := NewReaderResponse(buf, req, StreamWithEncoding(JSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var values []interface{}
+	for {
+		v, err := res.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		values = append(values, v)
+	}
+	exp := []interface{}{&testProgress{Done: 1}, &testProgress{Done: 2}, &testResult{Hash: "Qm"}}
+	if !reflect.DeepEqual(values, exp) {
+		t.Errorf("expected values %#v, got %#v", exp, values)
+	}
+}
+
+func TestDecodeUnknownOutputType(t *testing.T) {
+	m := &MaybeError{Value: testResult{}}
+	if err := m.UnmarshalJSON([]byte(`{"Type":"output","Name":"stats","Value":{"Peers":3}}`)); err != nil {
+		t.Fatal(err)
+	}
+	v, err := m.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := map[string]interface{}{"Peers": float64(3)}; !reflect.DeepEqual(v, exp) {
+		t.Errorf("expected %v, got %#v", exp, v)
+	}
+}
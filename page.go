@@ -0,0 +1,67 @@
+package cmds
+
+// PageInfo tells the client where a page of a list command's output ends.
+// It is sent in the response trailer.
+type PageInfo struct {
+	// More is set if there are entries after this page.
+	More bool
+	// NextCursor is the value of the cursor option that fetches the next
+	// page. Commands paging by offset leave it empty.
+	NextCursor string `json:",omitempty"`
+}
+
+// Pager is implemented by ResponseEmitters that can pass page info on to
+// the client.
+type Pager interface {
+	SetPage(PageInfo)
+}
+
+// SetPage reports where the page emitted to re ends. It has to be called
+// before re is closed and has no effect if re doesn't support page info.
+func SetPage(re ResponseEmitter, p PageInfo) {
+	if pager, ok := re.(Pager); ok {
+		pager.SetPage(p)
+	}
+}
+
+// PageParams are the paging options of a request.
+type PageParams struct {
+	Offset int
+	// Limit is the maximum number of entries to return. Zero means no
+	// limit.
+	Limit  int
+	Cursor string
+	// All is set if the client asked for all entries. Limit is zero then.
+	All bool
+}
+
+// GetPage returns the paging options of req, see OptionLimit, OptionOffset,
+// OptionCursor and OptionAll.
+func GetPage(req *Request) PageParams {
+	var p PageParams
+	p.Offset, _ = req.Options[OffsetOpt].(int)
+	p.Limit, _ = req.Options[LimitOpt].(int)
+	p.Cursor, _ = req.Options[CursorOpt].(string)
+	p.All, _ = req.Options[AllOpt].(bool)
+
+	if p.Offset < 0 {
+		p.Offset = 0
+	}
+	if p.Limit < 0 || p.All {
+		p.Limit = 0
+	}
+	return p
+}
+
+// Slice returns the bounds of the page of a list of n entries that is
+// paged by offset, and the page info to report with SetPage.
+func (p PageParams) Slice(n int) (start, end int, info PageInfo) {
+	start, end = p.Offset, n
+	if start > n {
+		start = n
+	}
+	if p.Limit > 0 && start+p.Limit < n {
+		end = start + p.Limit
+	}
+	return start, end, PageInfo{More: end < n}
+}
@@ -0,0 +1,68 @@
+package cmds
+
+import (
+	"context"
+	"io"
+	"testing"
+)
+
+func TestPageSlice(t *testing.T) {
+	type testcase struct {
+		opts       OptMap
+		n          int
+		start, end int
+		more       bool
+	}
+
+	tcs := []testcase{
+		{n: 5, start: 0, end: 5},
+		{opts: OptMap{LimitOpt: 2}, n: 5, start: 0, end: 2, more: true},
+		{opts: OptMap{LimitOpt: 2, OffsetOpt: 2}, n: 5, start: 2, end: 4, more: true},
+		{opts: OptMap{LimitOpt: 2, OffsetOpt: 4}, n: 5, start: 4, end: 5},
+		{opts: OptMap{LimitOpt: 2, OffsetOpt: 7}, n: 5, start: 5, end: 5},
+		{opts: OptMap{LimitOpt: 2, AllOpt: true}, n: 5, start: 0, end: 5},
+		{opts: OptMap{LimitOpt: -1, OffsetOpt: -1}, n: 5, start: 0, end: 5},
+	}
+
+	root := &Command{Options: []Option{OptionLimit, OptionOffset, OptionAll}}
+	for i, tc := range tcs {
+		req, err := NewRequest(context.Background(), nil, tc.opts, nil, nil, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		start, end, info := GetPage(req).Slice(tc.n)
+		if start != tc.start || end != tc.end || info.More != tc.more {
+			t.Errorf("%d: expected [%d:%d] more=%v, got [%d:%d] more=%v", i, tc.start, tc.end, tc.more, start, end, info.More)
+		}
+	}
+}
+
+func TestPageInfo(t *testing.T) {
+	req, err := NewRequest(context.Background(), nil, nil, nil, nil, &Command{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	re, res := NewChanResponsePair(req)
+	outRe, outRes := NewChanResponsePair(req)
+	go func() {
+		re.Emit("a")
+		SetPage(re, PageInfo{More: true, NextCursor: "b"})
+		re.Close()
+	}()
+	go Copy(outRe, res)
+
+	if v, err := outRes.Next(); err != nil || v != "a" {
+		t.Fatalf("expected %q, got %v, %v", "a", v, err)
+	}
+	if _, err := outRes.Next(); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+
+	// page info is forwarded by Copy
+	tr := GetTrailer(outRes)
+	if tr == nil || tr.Page == nil || *tr.Page != (PageInfo{More: true, NextCursor: "b"}) {
+		t.Errorf("unexpected trailer %+v", tr)
+	}
+}
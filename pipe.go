@@ -0,0 +1,56 @@
+package cmds
+
+import (
+	"context"
+	"errors"
+)
+
+// Pipe makes the values emitted by the command of from the input of the
+// command of to, see Request.Input, so commands can be chained in a process,
+// e.g. by daemons or a REPL, without encoding the values in between. The
+// command of from is started with the environment of to when to is run with
+// Command.Call or the Executor of NewExecutor, and is canceled once to is
+// done. Requests can be piped in turn, making longer chains.
+func Pipe(from, to *Request) error {
+	switch {
+	case from.Root == nil:
+		return errors.New("cannot pipe from a request without a root command")
+	case to.pipe != nil:
+		return errors.New("the request already has an input")
+	}
+	for req := from; req != nil; req = req.pipe {
+		if req == to {
+			return errors.New("cannot pipe a request into itself")
+		}
+	}
+	to.pipe = from
+	return nil
+}
+
+// Input returns the response of the request piped into req, see Pipe, while
+// the command of req runs, and nil if there is none. Commands read their
+// input values from it with Next.
+func (req *Request) Input() Response {
+	return req.input
+}
+
+// startInput starts the command of the request piped into req, if any, and
+// returns a function canceling it.
+func startInput(req *Request, env Environment) func() {
+	from := req.pipe
+	if from == nil {
+		return func() {}
+	}
+
+	ctx := from.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	from.Context = ctx
+
+	re, res := NewChanResponsePair(from)
+	req.input = res
+	go from.Root.Call(from, re, env)
+	return cancel
+}
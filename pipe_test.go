@@ -0,0 +1,126 @@
+package cmds
+
+import (
+	"context"
+	"io"
+	"testing"
+)
+
+func TestPipe(t *testing.T) {
+	root := &Command{
+		Subcommands: map[string]*Command{
+			"count": {
+				Run: func(req *Request, re ResponseEmitter, env Environment) error {
+					for i := 1; i <= env.(int); i++ {
+						if err := re.Emit(i); err != nil {
+							return err
+						}
+					}
+					return nil
+				},
+				Type: 0,
+			},
+			"double": {
+				Run: func(req *Request, re ResponseEmitter, env Environment) error {
+					in := req.Input()
+					if in == nil {
+						return Errorf(ErrClient, "no input")
+					}
+					for {
+						v, err := in.Next()
+						if err == io.EOF {
+							return nil
+						}
+						if err != nil {
+							return err
+						}
+						if err := re.Emit(2 * v.(int)); err != nil {
+							return err
+						}
+					}
+				},
+				Type: 0,
+			},
+			"first": {
+				Run: func(req *Request, re ResponseEmitter, env Environment) error {
+					v, err := req.Input().Next()
+					if err != nil {
+						return err
+					}
+					return re.Emit(v)
+				},
+				Type: 0,
+			},
+		},
+	}
+
+	newReq := func(name string) *Request {
+		req, err := NewRequest(context.Background(), []string{name}, nil, nil, nil, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return req
+	}
+	run := func(req *Request) ([]interface{}, error) {
+		re, res := NewChanResponsePair(req)
+		go root.Call(req, re, 3)
+		var values []interface{}
+		for {
+			v, err := res.Next()
+			if err == io.EOF {
+				return values, nil
+			}
+			if err != nil {
+				return values, err
+			}
+			values = append(values, v)
+		}
+	}
+
+	count, double, double2 := newReq("count"), newReq("double"), newReq("double")
+	if err := Pipe(count, double); err != nil {
+		t.Fatal(err)
+	}
+	if err := Pipe(double, double2); err != nil {
+		t.Fatal(err)
+	}
+	values, err := run(double2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 3 || values[0] != 4 || values[1] != 8 || values[2] != 12 {
+		t.Errorf("unexpected values %v", values)
+	}
+
+	// the input is canceled when the command returns
+	count, first := newReq("count"), newReq("first")
+	if err := Pipe(count, first); err != nil {
+		t.Fatal(err)
+	}
+	values, err = run(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 1 || values[0] != 1 {
+		t.Errorf("unexpected values %v", values)
+	}
+	<-count.Context.Done()
+
+	if _, err := run(newReq("double")); err == nil || err.Error() != "no input" {
+		t.Errorf("expected the command to fail without input, got %v", err)
+	}
+
+	if err := Pipe(double2, first); err == nil {
+		t.Error("expected piping into a piped request to fail")
+	}
+	if err := Pipe(double2, newReq("count")); err != nil {
+		t.Fatal(err)
+	}
+	a, b := newReq("double"), newReq("double")
+	if err := Pipe(a, b); err != nil {
+		t.Fatal(err)
+	}
+	if err := Pipe(b, a); err == nil {
+		t.Error("expected a cycle to fail")
+	}
+}
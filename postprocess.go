@@ -0,0 +1,53 @@
+package cmds
+
+import (
+	"reflect"
+	"sync"
+)
+
+// PostProcessor transforms a value emitted by a command before it is
+// encoded in encType, e.g. to humanize timestamps or localize the names of
+// enums. It returns the value to encode, which needn't be of the same type.
+//
+// Post-processors apply wherever the value is encoded: on the wire of HTTP
+// servers as well as in the output of the CLI. Those changing the shape of
+// values should leave encodings clients decode, like JSON, alone.
+type PostProcessor func(req *Request, encType EncodingType, v interface{}) (interface{}, error)
+
+var (
+	postProcessorsLk sync.RWMutex
+	postProcessors   = make(map[reflect.Type][]PostProcessor)
+)
+
+// RegisterPostProcessor registers fn as a post-processor of the values of
+// the type of example, so applications can change how values are encoded
+// across all commands rather than in the encoders of each. fn is passed
+// values of the same type as example. Post-processors of a type are applied
+// in the order they are registered, each to the value returned by the
+// previous one.
+func RegisterPostProcessor(example interface{}, fn PostProcessor) {
+	postProcessorsLk.Lock()
+	defer postProcessorsLk.Unlock()
+	typ := reflect.TypeOf(example)
+	postProcessors[typ] = append(postProcessors[typ], fn)
+}
+
+func getPostProcessors(typ reflect.Type) []PostProcessor {
+	postProcessorsLk.RLock()
+	defer postProcessorsLk.RUnlock()
+	return postProcessors[typ]
+}
+
+// postProcess applies the post-processors of the type of v to v.
+func postProcess(req *Request, encType EncodingType, v interface{}) (interface{}, error) {
+	if v == nil {
+		return v, nil
+	}
+	var err error
+	for _, fn := range getPostProcessors(reflect.TypeOf(v)) {
+		if v, err = fn(req, encType, v); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
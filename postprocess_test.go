@@ -0,0 +1,78 @@
+package cmds
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type ppTimestamp struct {
+	At time.Time
+}
+
+type ppState int
+
+func TestPostProcessor(t *testing.T) {
+	now := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	errBad := errors.New("bad state")
+	t.Cleanup(func() {
+		postProcessorsLk.Lock()
+		defer postProcessorsLk.Unlock()
+		delete(postProcessors, reflect.TypeOf(&ppTimestamp{}))
+		delete(postProcessors, reflect.TypeOf(ppState(0)))
+	})
+
+	// timestamps are humanized in text only
+	RegisterPostProcessor(&ppTimestamp{}, func(req *Request, encType EncodingType, v interface{}) (interface{}, error) {
+		if encType != Text {
+			return v, nil
+		}
+		return "at " + v.(*ppTimestamp).At.Format(time.Kitchen), nil
+	})
+	// post-processors are chained
+	RegisterPostProcessor(ppState(0), func(req *Request, encType EncodingType, v interface{}) (interface{}, error) {
+		if v.(ppState) < 0 {
+			return nil, errBad
+		}
+		return v.(ppState) * 10, nil
+	})
+	RegisterPostProcessor(ppState(0), func(req *Request, encType EncodingType, v interface{}) (interface{}, error) {
+		return fmt.Sprintf("state %d", v), nil
+	})
+
+	for _, tc := range []struct {
+		encType EncodingType
+		v       interface{}
+		out     string
+		err     error
+	}{
+		{encType: Text, v: &ppTimestamp{now}, out: "at 5:06AM"},
+		{encType: JSON, v: &ppTimestamp{now}, out: `{"At":"2021-03-04T05:06:07Z"}` + "\n"},
+		// only values of the registered type are post-processed
+		{encType: JSON, v: ppTimestamp{now}, out: `{"At":"2021-03-04T05:06:07Z"}` + "\n"},
+		{encType: JSON, v: ppState(2), out: `"state 20"` + "\n"},
+		{encType: JSON, v: ppState(-1), err: errBad},
+	} {
+		req, err := NewRequest(context.Background(), nil, nil, nil, nil, &Command{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		re, err := NewWriterResponseEmitter(writecloser{&buf, nopCloser{}}, req, StreamWithEncoding(tc.encType))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = re.Emit(tc.v)
+		if err != tc.err {
+			t.Errorf("%s %#v: expected error %v, got %v", tc.encType, tc.v, tc.err, err)
+		}
+		if buf.String() != tc.out {
+			t.Errorf("%s %#v: expected %q, got %q", tc.encType, tc.v, tc.out, buf.String())
+		}
+	}
+}
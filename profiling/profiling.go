@@ -0,0 +1,269 @@
+// Package profiling provides commands exposing the profiles and runtime
+// statistics of a daemon: the pprof profiles of its CPU, heap, goroutines and
+// so on, and a stream of statistics of its runtime. Daemons serving them get
+// debugging endpoints through their command API, with its encodings and
+// access control, instead of a separate pprof listener. Mount adds them to a
+// command tree.
+package profiling
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/pprof"
+	"time"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+)
+
+// Paths of the commands added by Mount: the command returned by NewCommand
+// is mounted at "diag profile".
+const (
+	DiagPath    = "diag"
+	ProfilePath = "profile"
+)
+
+// Options of the profiling commands.
+const (
+	// DurationOpt sets how long the CPU is profiled.
+	DurationOpt = "duration"
+	// DebugOpt selects the format of the other profiles, see
+	// pprof.Profile.WriteTo. Zero is the binary format of pprof.
+	DebugOpt = "debug"
+	// GCOpt runs a garbage collection before the heap is profiled.
+	GCOpt = "gc"
+	// IntervalOpt makes the stats command send statistics at this
+	// interval until the request is canceled.
+	IntervalOpt = "interval"
+)
+
+// DefaultDuration is the default of the duration option.
+const DefaultDuration = 30 * time.Second
+
+// MaxDuration is the longest the CPU is profiled for, as no other CPU profile
+// can be collected meanwhile.
+const MaxDuration = 5 * time.Minute
+
+// profiles are the profiles of the runtime served by NewCommand, besides the
+// CPU profile, with their descriptions.
+var profiles = map[string]string{
+	"heap":         "Profile the memory allocations of live objects.",
+	"allocs":       "Profile all past memory allocations.",
+	"goroutine":    "Dump the stack traces of all goroutines.",
+	"block":        "Profile the goroutines blocking on synchronization.",
+	"mutex":        "Profile the holders of contended mutexes.",
+	"threadcreate": "Dump the stack traces that created threads.",
+}
+
+// Mount adds the command returned by NewCommand to root, at "diag profile".
+// The diag command is created if root doesn't have one.
+func Mount(root *cmds.Command) {
+	if root.Subcommands == nil {
+		root.Subcommands = make(map[string]*cmds.Command)
+	}
+	diag, ok := root.Subcommands[DiagPath]
+	if !ok {
+		diag = &cmds.Command{
+			Helptext: cmds.HelpText{
+				Tagline: "Diagnose the daemon.",
+			},
+		}
+		root.Subcommands[DiagPath] = diag
+	}
+	if diag.Subcommands == nil {
+		diag.Subcommands = make(map[string]*cmds.Command)
+	}
+	diag.Subcommands[ProfilePath] = NewCommand()
+}
+
+// NewCommand returns a command whose subcommands profile the daemon: cpu,
+// stats, and one for each profile of runtime/pprof, e.g. heap. Profiles are
+// sent as they are written by runtime/pprof, to be read with go tool pprof.
+// As they reveal the memory and stacks of the daemon, the profiling commands
+// are marked Mutating, so read-only servers don't serve them.
+func NewCommand() *cmds.Command {
+	cmd := &cmds.Command{
+		Helptext: cmds.HelpText{
+			Tagline: "Profile the daemon.",
+			ShortDescription: `
+Collects the profiles and runtime statistics of the daemon. Profiles are sent
+in the format of pprof, unless the debug option asks for text.
+`,
+		},
+		Subcommands: map[string]*cmds.Command{
+			"cpu":   cpuCmd,
+			"stats": statsCmd,
+		},
+	}
+	for name, tagline := range profiles {
+		cmd.Subcommands[name] = newLookupCmd(name, tagline)
+	}
+	return cmd
+}
+
+var cpuCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Profile the CPU usage.",
+		ShortDescription: `
+Profiles the CPU usage of the daemon for the given duration, at most five
+minutes, and sends the profile. Only one CPU profile is collected at a time.
+`,
+	},
+	Options: []cmds.Option{
+		cmds.StringOption(DurationOpt, "How long to profile the CPU.").WithDefault(DefaultDuration.String()),
+	},
+	NoLocal:    true,
+	Mutating:   true,
+	Exclusive:  true,
+	FailIfBusy: true,
+	Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+		d, err := time.ParseDuration(req.Options[DurationOpt].(string))
+		if err != nil || d <= 0 {
+			return cmds.Errorf(cmds.ErrClient, "invalid duration: %s", req.Options[DurationOpt])
+		}
+		if d > MaxDuration {
+			return cmds.Errorf(cmds.ErrClient, "duration %s is longer than the maximum of %s", d, MaxDuration)
+		}
+
+		// the profile is only sent once it is complete, so a client going
+		// away doesn't leave the profiler blocked on its writer
+		var buf bytes.Buffer
+		if err := pprof.StartCPUProfile(&buf); err != nil {
+			return cmds.Errorf(cmds.ErrRateLimited, "%s", err)
+		}
+		t := time.NewTimer(d)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-req.Context.Done():
+		}
+		pprof.StopCPUProfile()
+		if err := req.Context.Err(); err != nil {
+			return err
+		}
+		return cmds.EmitOnce(re, &buf)
+	},
+}
+
+func newLookupCmd(name, tagline string) *cmds.Command {
+	options := []cmds.Option{
+		cmds.IntOption(DebugOpt, "Write the profile as text, with this level of detail.").WithDefault(0),
+	}
+	if name == "heap" {
+		options = append(options, cmds.BoolOption(GCOpt, "Collect garbage before profiling."))
+	}
+
+	return &cmds.Command{
+		Helptext: cmds.HelpText{
+			Tagline: tagline,
+		},
+		Options:  options,
+		NoLocal:  true,
+		Mutating: true,
+		Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+			p := pprof.Lookup(name)
+			if p == nil {
+				return fmt.Errorf("unknown profile %q", name)
+			}
+			if gc, _ := req.Options[GCOpt].(bool); gc {
+				runtime.GC()
+			}
+
+			var buf bytes.Buffer
+			if err := p.WriteTo(&buf, req.Options[DebugOpt].(int)); err != nil {
+				return err
+			}
+			return cmds.EmitOnce(re, &buf)
+		},
+	}
+}
+
+// Stats are statistics of the runtime of the daemon, see runtime.MemStats.
+type Stats struct {
+	Time       time.Time
+	GoVersion  string
+	NumCPU     int
+	GOMAXPROCS int
+	Goroutines int
+
+	HeapAlloc   uint64
+	HeapInuse   uint64
+	HeapObjects uint64
+	TotalAlloc  uint64
+	Sys         uint64
+
+	NumGC      uint32
+	PauseTotal time.Duration
+	LastGC     time.Time
+}
+
+func readStats() *Stats {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	s := &Stats{
+		Time:        time.Now(),
+		GoVersion:   runtime.Version(),
+		NumCPU:      runtime.NumCPU(),
+		GOMAXPROCS:  runtime.GOMAXPROCS(0),
+		Goroutines:  runtime.NumGoroutine(),
+		HeapAlloc:   m.HeapAlloc,
+		HeapInuse:   m.HeapInuse,
+		HeapObjects: m.HeapObjects,
+		TotalAlloc:  m.TotalAlloc,
+		Sys:         m.Sys,
+		NumGC:       m.NumGC,
+		PauseTotal:  time.Duration(m.PauseTotalNs),
+	}
+	if m.LastGC > 0 {
+		s.LastGC = time.Unix(0, int64(m.LastGC))
+	}
+	return s
+}
+
+var statsCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Show statistics of the runtime.",
+		ShortDescription: `
+Sends the memory, garbage collection and goroutine statistics of the daemon.
+With the interval option, they are sent at that interval until the request
+is canceled.
+`,
+	},
+	Options: []cmds.Option{
+		cmds.StringOption(IntervalOpt, "Send statistics at this interval."),
+	},
+	NoLocal: true,
+	Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+		intervalStr, ok := req.Options[IntervalOpt].(string)
+		if !ok {
+			return cmds.EmitOnce(re, readStats())
+		}
+		interval, err := time.ParseDuration(intervalStr)
+		if err != nil || interval <= 0 {
+			return cmds.Errorf(cmds.ErrClient, "invalid interval: %s", intervalStr)
+		}
+
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			if err := re.Emit(readStats()); err != nil {
+				return err
+			}
+			select {
+			case <-t.C:
+			case <-req.Context.Done():
+				return req.Context.Err()
+			}
+		}
+	},
+	Type: Stats{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, s *Stats) error {
+			_, err := fmt.Fprintf(w, "%s goroutines=%d heap=%d heap-objects=%d sys=%d gc=%d gc-pause=%s\n",
+				s.Time.Format(time.RFC3339), s.Goroutines, s.HeapAlloc, s.HeapObjects, s.Sys, s.NumGC, s.PauseTotal)
+			return err
+		}),
+	},
+}
@@ -0,0 +1,92 @@
+package profiling
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	cmds "github.com/fgeth/fg-ipfs-cmds"
+	cmdshttp "github.com/fgeth/fg-ipfs-cmds/http"
+)
+
+func TestProfiling(t *testing.T) {
+	root := &cmds.Command{}
+	Mount(root)
+	if errs := root.DebugValidate(); errs != nil {
+		t.Fatal(errs)
+	}
+	srv := httptest.NewServer(cmdshttp.NewHandler(nil, root, cmdshttp.NewServerConfig()))
+	defer srv.Close()
+
+	get := func(path string) []byte {
+		t.Helper()
+		res, err := http.Post(srv.URL+"/diag/profile/"+path, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("%s: unexpected status %d: %s", path, res.StatusCode, body)
+		}
+		return body
+	}
+
+	if body := get("goroutine?debug=1"); !bytes.HasPrefix(body, []byte("goroutine profile:")) {
+		t.Errorf("expected a goroutine profile, got %q", body)
+	}
+	// profiles are gzipped protocol buffers
+	if body := get("heap?gc=true"); !bytes.HasPrefix(body, []byte{0x1f, 0x8b}) {
+		t.Errorf("expected a heap profile, got %q", body)
+	}
+	if body := get("cpu?duration=100ms"); !bytes.HasPrefix(body, []byte{0x1f, 0x8b}) {
+		t.Errorf("expected a CPU profile, got %q", body)
+	}
+
+	var s Stats
+	if err := json.Unmarshal(get("stats"), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Goroutines == 0 || s.HeapAlloc == 0 || s.GoVersion == "" {
+		t.Errorf("unexpected stats %+v", s)
+	}
+}
+
+func TestProfilingRestricted(t *testing.T) {
+	root := &cmds.Command{}
+	Mount(root)
+
+	post := func(cfg *cmdshttp.ServerConfig, path string) int {
+		t.Helper()
+		srv := httptest.NewServer(cmdshttp.NewHandler(nil, root, cfg))
+		defer srv.Close()
+		res, err := http.Post(srv.URL+"/diag/profile/"+path, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	if code := post(cmdshttp.NewServerConfig(), "cpu?duration=1h"); code != http.StatusBadRequest {
+		t.Errorf("expected a duration over MaxDuration to be refused, got status %d", code)
+	}
+
+	cfg := cmdshttp.NewServerConfig()
+	cfg.ReadOnly = true
+	for _, path := range []string{"heap", "goroutine", "cpu?duration=100ms"} {
+		if code := post(cfg, path); code != http.StatusForbidden {
+			t.Errorf("%s: expected a read-only server to refuse it, got status %d", path, code)
+		}
+	}
+	if code := post(cfg, "stats"); code != http.StatusOK {
+		t.Errorf("stats: unexpected status %d", code)
+	}
+}
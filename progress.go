@@ -0,0 +1,173 @@
+package cmds
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Progress reports how far a command got, e.g. while adding files before
+// emitting their hashes. Commands emit it like any other value: clients
+// recognize it in JSON streams by its type tag, and the CLI renders it as a
+// progress bar on stderr instead of printing it. Totals are zero if they
+// aren't known.
+type Progress struct {
+	// Stage names what the command is doing, e.g. "hashing".
+	Stage string `json:",omitempty"`
+
+	BytesDone  uint64 `json:",omitempty"`
+	BytesTotal uint64 `json:",omitempty"`
+	ItemsDone  uint64 `json:",omitempty"`
+	ItemsTotal uint64 `json:",omitempty"`
+}
+
+func (p Progress) MarshalJSON() ([]byte, error) {
+	// marshal through a distinct type to avoid recursing into this method
+	type progress Progress
+	return json.Marshal(struct {
+		progress
+		Type string
+	}{
+		progress: progress(p),
+		Type:     "progress",
+	})
+}
+
+func (p *Progress) UnmarshalJSON(data []byte) error {
+	type progress Progress
+	var w struct {
+		progress
+		Type string
+	}
+
+	err := json.Unmarshal(data, &w)
+	if err != nil {
+		return err
+	}
+
+	if w.Type != "progress" {
+		return errors.New("not of type progress")
+	}
+
+	*p = Progress(w.progress)
+	return nil
+}
+
+// String formats p for humans, e.g. "hashing: 3/10 items, 512 B/2048 B".
+func (p Progress) String() string {
+	var parts []string
+	if p.ItemsDone > 0 || p.ItemsTotal > 0 {
+		parts = append(parts, formatProgress(p.ItemsDone, p.ItemsTotal, " items"))
+	}
+	if p.BytesDone > 0 || p.BytesTotal > 0 {
+		parts = append(parts, formatProgress(p.BytesDone, p.BytesTotal, " B"))
+	}
+	s := strings.Join(parts, ", ")
+	if p.Stage != "" {
+		s = strings.TrimSuffix(p.Stage+": "+s, ": ")
+	}
+	return s
+}
+
+func formatProgress(done, total uint64, unit string) string {
+	if total == 0 {
+		return fmt.Sprint(done, unit)
+	}
+	return fmt.Sprintf("%d/%d%s", done, total, unit)
+}
+
+// ProgressReporter is implemented by values of commands that report their
+// progress in a format of their own, e.g. go-ipfs-style events carrying a
+// byte count. Their wire format stays as it is, but the CLI renders them
+// and WatchProgress reports them like Progress values.
+type ProgressReporter interface {
+	// Progress returns the progress the value reports, and false if it
+	// isn't a progress report, e.g. the final result.
+	Progress() (Progress, bool)
+}
+
+// ProgressAdapter maps the values of a command onto Progress, like
+// ProgressReporter, for types that can't implement it, e.g. ones of other
+// packages. It returns false for values that aren't progress reports.
+type ProgressAdapter func(v interface{}) (Progress, bool)
+
+type progressAdapterKey struct{}
+
+// SetProgressAdapter makes AsProgress map the values of the command with
+// adapt.
+func (e *Extra) SetProgressAdapter(adapt ProgressAdapter) *Extra {
+	return e.SetValue(progressAdapterKey{}, adapt)
+}
+
+// AsProgress returns the progress v reports if it is a progress report of
+// cmd: a Progress, a ProgressReporter, or a value the progress adapter of cmd
+// maps, see SetProgressAdapter.
+func AsProgress(cmd *Command, v interface{}) (Progress, bool) {
+	switch p := v.(type) {
+	case Progress:
+		return p, true
+	case *Progress:
+		return *p, true
+	case ProgressReporter:
+		return p.Progress()
+	}
+	if cmd == nil {
+		return Progress{}, false
+	}
+	if adapt, ok := cmd.Extra.GetValue(progressAdapterKey{}); ok {
+		if adapt, ok := adapt.(ProgressAdapter); ok {
+			return adapt(v)
+		}
+	}
+	return Progress{}, false
+}
+
+// isProgress reports whether v is a Progress value, encoded the same way
+// whatever the command.
+func isProgress(v interface{}) bool {
+	switch v.(type) {
+	case Progress, *Progress:
+		return true
+	}
+	return false
+}
+
+// WatchProgress returns a Response calling fn with the progress reports of
+// res, see AsProgress, and returning its other values.
+func WatchProgress(res Response, fn func(Progress)) Response {
+	return &progressResponse{Response: res, fn: fn}
+}
+
+type progressResponse struct {
+	Response
+	fn func(Progress)
+}
+
+func (r *progressResponse) Next() (interface{}, error) {
+	for {
+		v, err := r.Response.Next()
+		if err != nil {
+			return v, err
+		}
+		var cmd *Command
+		if req := r.Request(); req != nil {
+			cmd = req.Command
+		}
+		p, ok := AsProgress(cmd, v)
+		if !ok {
+			return v, nil
+		}
+		r.fn(p)
+	}
+}
+
+// Trailer returns the trailer of the wrapped response, see GetTrailer.
+func (r *progressResponse) Trailer() *Trailer {
+	return GetTrailer(r.Response)
+}
+
+// NextWarning returns the warnings of the wrapped response, see NextWarning.
+func (r *progressResponse) NextWarning() (string, bool) {
+	return NextWarning(r.Response)
+}
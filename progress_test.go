@@ -0,0 +1,105 @@
+package cmds
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// addEvent is a go-ipfs-style event: progress reports and results share a
+// type.
+type addEvent struct {
+	Name  string
+	Hash  string `json:",omitempty"`
+	Bytes int64  `json:",omitempty"`
+}
+
+func TestProgress(t *testing.T) {
+	cmd := &Command{
+		Run: func(req *Request, re ResponseEmitter, env Environment) error {
+			if err := re.Emit(&Progress{Stage: "hashing", BytesDone: 3, BytesTotal: 6}); err != nil {
+				return err
+			}
+			if err := re.Emit(&addEvent{Name: "a", Bytes: 6}); err != nil {
+				return err
+			}
+			return re.Emit(&addEvent{Name: "a", Hash: "Qm"})
+		},
+		Type: addEvent{},
+		Extra: new(Extra).SetProgressAdapter(func(v interface{}) (Progress, bool) {
+			e, ok := v.(*addEvent)
+			if !ok || e.Hash != "" {
+				return Progress{}, false
+			}
+			return Progress{BytesDone: uint64(e.Bytes)}, true
+		}),
+	}
+
+	req, err := NewRequest(context.Background(), nil, nil, nil, nil, cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	re, err := NewWriterResponseEmitter(writecloser{&buf, nopCloser{}}, req, StreamWithEncoding(JSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewExecutor(cmd).Execute(req, re, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// adapted events keep their wire format
+	exp := `{"Stage":"hashing","BytesDone":3,"BytesTotal":6,"Type":"progress"}
+{"Name":"a","Bytes":6}
+{"Name":"a","Hash":"Qm"}
+`
+	if buf.String() != exp {
+		t.Errorf("expected %q, got %q", exp, buf.String())
+	}
+
+	res, err := NewReaderResponse(&buf, req, StreamWithEncoding(JSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reports []Progress
+	res = WatchProgress(res, func(p Progress) {
+		reports = append(reports, p)
+	})
+	var values []interface{}
+	for {
+		v, err := res.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		values = append(values, v)
+	}
+
+	expReports := []Progress{{Stage: "hashing", BytesDone: 3, BytesTotal: 6}, {BytesDone: 6}}
+	if !reflect.DeepEqual(reports, expReports) {
+		t.Errorf("expected progress %v, got %v", expReports, reports)
+	}
+	if expValues := []interface{}{&addEvent{Name: "a", Hash: "Qm"}}; !reflect.DeepEqual(values, expValues) {
+		t.Errorf("expected values %#v, got %#v", expValues, values)
+	}
+}
+
+func TestProgressString(t *testing.T) {
+	for _, tc := range []struct {
+		p   Progress
+		exp string
+	}{
+		{Progress{}, ""},
+		{Progress{Stage: "walking"}, "walking"},
+		{Progress{ItemsDone: 3}, "3 items"},
+		{Progress{Stage: "hashing", ItemsDone: 3, ItemsTotal: 10, BytesDone: 512, BytesTotal: 2048}, "hashing: 3/10 items, 512/2048 B"},
+	} {
+		if s := tc.p.String(); s != tc.exp {
+			t.Errorf("%#v: expected %q, got %q", tc.p, tc.exp, s)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+//go:build !cmds_minimal
+// +build !cmds_minimal
+
+package cmds
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"reflect"
+
+	"google.golang.org/protobuf/encoding/protodelim"
+	"google.golang.org/protobuf/proto"
+)
+
+var protoMessageType = reflect.TypeOf((*proto.Message)(nil)).Elem()
+
+// isProtoType reports whether the values of the type of v, or pointers to
+// them, are protobuf messages.
+func isProtoType(v interface{}) bool {
+	t := reflect.TypeOf(v)
+	if t == nil {
+		return false
+	}
+	if t.Kind() != reflect.Ptr {
+		t = reflect.PtrTo(t)
+	}
+	return t.Implements(protoMessageType)
+}
+
+// encodesProtobuf reports whether the global protobuf encoder applies to the
+// output of cmd: its Type must be a protobuf message, e.g. a generated
+// message such as &pb.Block{}.
+func encodesProtobuf(cmd *Command) bool {
+	return cmd != nil && isProtoType(cmd.Type)
+}
+
+// newProtobufEncoder encodes protobuf messages, each prefixed with its length
+// as a varint, see package protodelim.
+func newProtobufEncoder(req *Request) func(io.Writer) Encoder {
+	return func(w io.Writer) Encoder { return protobufEncoder{w: w} }
+}
+
+type protobufEncoder struct {
+	w io.Writer
+}
+
+func (e protobufEncoder) Encode(v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("cannot encode %T as protobuf: not a protobuf message", v)
+	}
+	_, err := protodelim.MarshalTo(e.w, m)
+	return err
+}
+
+// newProtobufDecoder decodes the messages written by the protobuf encoder.
+// Like CBOR streams, protobuf streams only carry values: the messages are
+// decoded into the value of the MaybeError passed, which is set from the
+// Type of the command.
+func newProtobufDecoder(r io.Reader) Decoder {
+	return &protobufDecoder{r: bufio.NewReader(r)}
+}
+
+type protobufDecoder struct {
+	r *bufio.Reader
+}
+
+func (d *protobufDecoder) Decode(v interface{}) error {
+	m, ok := v.(*MaybeError)
+	if !ok {
+		return d.decode(v)
+	}
+	if m.Value == nil {
+		return fmt.Errorf("cannot decode protobuf: the command has no message type")
+	}
+
+	// decode into a new message, not one that may be the Type itself
+	t := reflect.TypeOf(m.Value)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	m.Value = reflect.New(t).Interface()
+	return d.decode(m.Value)
+}
+
+func (d *protobufDecoder) decode(v interface{}) error {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("cannot decode protobuf into %T: not a protobuf message", v)
+	}
+	return protodelim.UnmarshalFrom(d.r, msg)
+}
@@ -0,0 +1,26 @@
+//go:build cmds_minimal
+// +build cmds_minimal
+
+package cmds
+
+import (
+	"errors"
+	"io"
+)
+
+// errNoProtobuf is returned by the protobuf encoders and decoders of builds
+// with the cmds_minimal tag, which don't depend on the protobuf runtime. No
+// command encodes protobuf in such builds.
+var errNoProtobuf = errors.New("protobuf is not available in builds with the cmds_minimal tag")
+
+func encodesProtobuf(cmd *Command) bool {
+	return false
+}
+
+func newProtobufEncoder(req *Request) func(io.Writer) Encoder {
+	return func(w io.Writer) Encoder { return unavailableCoder{errNoProtobuf} }
+}
+
+func newProtobufDecoder(r io.Reader) Decoder {
+	return unavailableCoder{errNoProtobuf}
+}
@@ -0,0 +1,73 @@
+//go:build !cmds_minimal
+// +build !cmds_minimal
+
+package cmds
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestProtobuf(t *testing.T) {
+	cmd := &Command{
+		Run: func(req *Request, re ResponseEmitter, env Environment) error {
+			if err := re.Emit(wrapperspb.String("a")); err != nil {
+				return err
+			}
+			// progress reports are left out of protobuf streams
+			if err := re.Emit(&Progress{ItemsDone: 1}); err != nil {
+				return err
+			}
+			return re.Emit(wrapperspb.String("b"))
+		},
+		Type: &wrapperspb.StringValue{},
+	}
+	if err := cmd.CheckEncoding(Protobuf); err != nil {
+		t.Fatal(err)
+	}
+	// commands whose type isn't a message can't be encoded as protobuf
+	if exp := []EncodingType{CBOR, JSON, MergePatch, NDJSON, Raw, Text, TextNewline, XML}; !reflect.DeepEqual((&Command{Type: ""}).SupportedEncodings(), exp) {
+		t.Errorf("expected encodings %v, got %v", exp, (&Command{Type: ""}).SupportedEncodings())
+	}
+
+	req, err := NewRequest(context.Background(), nil, nil, nil, nil, cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	re, err := NewWriterResponseEmitter(writecloser{&buf, nopCloser{}}, req, StreamWithEncoding(Protobuf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewExecutor(cmd).Execute(req, re, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := NewReaderResponse(&buf, req, StreamWithEncoding(Protobuf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var values []string
+	for {
+		v, err := res.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		values = append(values, v.(*wrapperspb.StringValue).GetValue())
+	}
+	if exp := []string{"a", "b"}; !reflect.DeepEqual(values, exp) {
+		t.Errorf("expected values %v, got %v", exp, values)
+	}
+	if v := cmd.Type.(*wrapperspb.StringValue).GetValue(); v != "" {
+		t.Errorf("values were decoded into the type of the command: %q", v)
+	}
+}
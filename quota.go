@@ -0,0 +1,171 @@
+package cmds
+
+import (
+	"sync"
+	"time"
+)
+
+// QuotaProvider is implemented by environments that limit how much the
+// identities making requests run commands, e.g. for public-facing APIs.
+// Command.Call and local executors consult it before running a command.
+type QuotaProvider interface {
+	// AcquireQuota returns an error if req may not run now, usually one
+	// made with QuotaExceeded. Otherwise the returned function is called
+	// once the command has finished.
+	AcquireQuota(req *Request) (release func(), err error)
+}
+
+// Names of the quotas of Quota.
+const (
+	QuotaExecutions = "executions"
+	QuotaStreams    = "streams"
+)
+
+// QuotaDetails are the details of the errors of requests exceeding a quota.
+type QuotaDetails struct {
+	// Quota names the quota exceeded, e.g. QuotaExecutions.
+	Quota string
+	Limit int
+	// Reset is when the quota admits requests again. It is zero for
+	// quotas on concurrent requests, which admit requests again as soon
+	// as one finishes.
+	Reset time.Time `json:",omitempty"`
+}
+
+// QuotaExceeded returns the error of requests exceeding the quota d. It is
+// of type ErrRateLimited, with d as its details.
+func QuotaExceeded(d QuotaDetails) *Error {
+	msg := "quota of " + d.Quota + " exceeded"
+	if !d.Reset.IsZero() {
+		msg += ", resets at " + d.Reset.Format(time.RFC3339)
+	}
+	return &Error{Message: msg, Code: ErrRateLimited, Details: d}
+}
+
+// acquireQuota asks env for the quota of req, if it is a QuotaProvider. Dry
+// runs don't count.
+func acquireQuota(req *Request, env Environment) (func(), error) {
+	qp, ok := env.(QuotaProvider)
+	if !ok || IsDryRun(req) {
+		return func() {}, nil
+	}
+	release, err := qp.AcquireQuota(req)
+	if err != nil {
+		return nil, err
+	}
+	if release == nil {
+		release = func() {}
+	}
+	return release, nil
+}
+
+// QuotaLimits are the limits of a Quota. Zero means no limit.
+type QuotaLimits struct {
+	// MaxExecutions is the number of commands an identity can run per
+	// Window, an hour if unset.
+	MaxExecutions int
+	Window        time.Duration
+
+	// MaxStreams is the number of streaming commands, see Cost, an
+	// identity can run at once.
+	MaxStreams int
+}
+
+// Quota is a QuotaProvider enforcing limits per identity, kept in memory.
+// Environments provide it by embedding it.
+type Quota struct {
+	limits   QuotaLimits
+	identify func(*Request) string
+
+	l         sync.Mutex
+	usage     map[string]*quotaUsage
+	lastSweep time.Time
+}
+
+// quotaUsage is the usage of an identity.
+type quotaUsage struct {
+	// executions were counted since start.
+	start      time.Time
+	executions int
+	streams    int
+}
+
+// NewQuota returns a Quota enforcing limits. Requests for which identify
+// returns the same identity share quotas, e.g. all requests of an API key.
+// A nil identify puts all requests under one quota.
+func NewQuota(limits QuotaLimits, identify func(req *Request) string) *Quota {
+	if limits.Window <= 0 {
+		limits.Window = time.Hour
+	}
+	return &Quota{
+		limits:    limits,
+		identify:  identify,
+		usage:     make(map[string]*quotaUsage),
+		lastSweep: time.Now(),
+	}
+}
+
+func (q *Quota) AcquireQuota(req *Request) (func(), error) {
+	var id string
+	if q.identify != nil {
+		id = q.identify(req)
+	}
+	streaming := req.Command != nil && CommandCost(req.Command).Streaming
+
+	q.l.Lock()
+	defer q.l.Unlock()
+
+	now := time.Now()
+	q.sweep(now)
+
+	u, ok := q.usage[id]
+	if !ok {
+		u = &quotaUsage{start: now}
+		q.usage[id] = u
+	}
+	if now.Sub(u.start) >= q.limits.Window {
+		u.start, u.executions = now, 0
+	}
+
+	if q.limits.MaxExecutions > 0 && u.executions >= q.limits.MaxExecutions {
+		return nil, QuotaExceeded(QuotaDetails{
+			Quota: QuotaExecutions,
+			Limit: q.limits.MaxExecutions,
+			Reset: u.start.Add(q.limits.Window),
+		})
+	}
+	if streaming && q.limits.MaxStreams > 0 && u.streams >= q.limits.MaxStreams {
+		return nil, QuotaExceeded(QuotaDetails{
+			Quota: QuotaStreams,
+			Limit: q.limits.MaxStreams,
+		})
+	}
+
+	u.executions++
+	if !streaming {
+		return func() {}, nil
+	}
+	u.streams++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			q.l.Lock()
+			defer q.l.Unlock()
+			u.streams--
+		})
+	}, nil
+}
+
+// sweep forgets the identities whose usage doesn't matter anymore, once per
+// window.
+func (q *Quota) sweep(now time.Time) {
+	if now.Sub(q.lastSweep) < q.limits.Window {
+		return
+	}
+	q.lastSweep = now
+	for id, u := range q.usage {
+		if u.streams == 0 && now.Sub(u.start) >= q.limits.Window {
+			delete(q.usage, id)
+		}
+	}
+}
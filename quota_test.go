@@ -0,0 +1,90 @@
+package cmds
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type quotaEnv struct {
+	*Quota
+}
+
+func TestQuota(t *testing.T) {
+	started := make(chan struct{})
+	stop := make(chan struct{})
+	root := &Command{
+		Subcommands: map[string]*Command{
+			"get": {
+				Run: func(req *Request, re ResponseEmitter, env Environment) error {
+					return nil
+				},
+			},
+			"tail": {
+				Run: func(req *Request, re ResponseEmitter, env Environment) error {
+					started <- struct{}{}
+					<-stop
+					return nil
+				},
+				Subscription: true,
+			},
+		},
+	}
+
+	env := quotaEnv{NewQuota(QuotaLimits{MaxExecutions: 3, Window: 200 * time.Millisecond, MaxStreams: 1}, func(req *Request) string {
+		id, _ := req.Options["id"].(string)
+		return id
+	})}
+	execute := func(path, id string) error {
+		req, err := NewRequest(context.Background(), []string{path}, OptMap{"id": id}, nil, nil, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return NewExecutor(root).Execute(req, newTestEmitter(t), env)
+	}
+	quotaErr := func(err error, quota string) *QuotaDetails {
+		e, ok := err.(*Error)
+		if !ok || e.Code != ErrRateLimited {
+			t.Fatalf("expected a quota error, got %v", err)
+		}
+		d, ok := e.Details.(QuotaDetails)
+		if !ok || d.Quota != quota {
+			t.Fatalf("expected the %s quota to be exceeded, got %#v", quota, e.Details)
+		}
+		return &d
+	}
+
+	// one stream at a time
+	done := make(chan error)
+	go func() { done <- execute("tail", "alice") }()
+	<-started
+	if d := quotaErr(execute("tail", "alice"), QuotaStreams); !d.Reset.IsZero() || d.Limit != 1 {
+		t.Errorf("unexpected details %#v", d)
+	}
+	// other identities have their own quotas
+	go func() { done <- execute("tail", "bob") }()
+	<-started
+	close(stop)
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// alice ran one stream, and had another one rejected
+	if err := execute("get", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := execute("get", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	d := quotaErr(execute("get", "alice"), QuotaExecutions)
+	if d.Limit != 3 || time.Until(d.Reset) <= 0 {
+		t.Errorf("unexpected details %#v", d)
+	}
+
+	time.Sleep(time.Until(d.Reset))
+	if err := execute("get", "alice"); err != nil {
+		t.Errorf("quota wasn't reset: %s", err)
+	}
+}
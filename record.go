@@ -0,0 +1,333 @@
+package cmds
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// ErrNotRecorded is returned by the replay executor for requests that are
+// not in the recording, or whose recorded responses have all been replayed.
+var ErrNotRecorded = errors.New("request not found in recording")
+
+// RecordedRequest is the part of a request that is recorded and matched on
+// replay.
+type RecordedRequest struct {
+	Path      []string
+	Options   map[string]interface{} `json:",omitempty"`
+	Arguments []string               `json:",omitempty"`
+}
+
+// recordEntry is a line of a recording. Every request starts with an entry
+// carrying Request and ends with one that has Done set; the entries of
+// concurrent requests are told apart by ID.
+type recordEntry struct {
+	ID      uint64
+	Request *RecordedRequest `json:",omitempty"`
+	Value   json.RawMessage  `json:",omitempty"`
+	Data    []byte           `json:",omitempty"`
+	Reader  bool             `json:",omitempty"`
+	Error   *Error           `json:",omitempty"`
+	// Returned is set if the error was returned by Execute rather than
+	// passed to CloseWithError.
+	Returned bool `json:",omitempty"`
+	Done     bool `json:",omitempty"`
+}
+
+func recordRequest(req *Request) *RecordedRequest {
+	return &RecordedRequest{
+		Path:      req.Path,
+		Options:   req.Options,
+		Arguments: req.Arguments,
+	}
+}
+
+func toError(err error) *Error {
+	switch e := err.(type) {
+	case nil:
+		return nil
+	case *Error:
+		return e
+	case Error:
+		return &e
+	default:
+		if err == io.EOF {
+			return nil
+		}
+		return &Error{Message: err.Error(), Code: ErrNormal}
+	}
+}
+
+// NewRecordingExecutor returns an Executor that executes requests with exe
+// and writes them, along with everything emitted in response, to w as
+// newline-delimited JSON. Values must be JSON encodable; emitted readers are
+// read into memory before they are passed on. Files sent with requests are
+// not recorded.
+func NewRecordingExecutor(exe Executor, w io.Writer) Executor {
+	return &recordingExecutor{exe: exe, enc: json.NewEncoder(w)}
+}
+
+type recordingExecutor struct {
+	exe Executor
+
+	l      sync.Mutex
+	enc    *json.Encoder
+	nextID uint64
+}
+
+func (x *recordingExecutor) write(e recordEntry) error {
+	x.l.Lock()
+	defer x.l.Unlock()
+	return x.enc.Encode(e)
+}
+
+func (x *recordingExecutor) Execute(req *Request, re ResponseEmitter, env Environment) error {
+	x.l.Lock()
+	id := x.nextID
+	x.nextID++
+	x.l.Unlock()
+
+	if err := x.write(recordEntry{ID: id, Request: recordRequest(req)}); err != nil {
+		return err
+	}
+
+	rre := &recordingEmitter{ResponseEmitter: re, x: x, id: id, cmd: req.Command}
+	var wrapped ResponseEmitter = rre
+	if typer, ok := re.(interface{ Type() PostRunType }); ok {
+		// keep PostRun functions working
+		wrapped = &typedRecordingEmitter{recordingEmitter: rre, typ: typer.Type()}
+	}
+
+	err := x.exe.Execute(req, wrapped, env)
+	if err != nil {
+		rre.finish(recordEntry{ID: id, Error: toError(err), Returned: true, Done: true})
+	}
+	return err
+}
+
+type recordingEmitter struct {
+	ResponseEmitter
+	x   *recordingExecutor
+	id  uint64
+	cmd *Command
+
+	l    sync.Mutex
+	done bool
+}
+
+// finish writes the last entry of the request, unless that has already
+// happened.
+func (re *recordingEmitter) finish(e recordEntry) {
+	re.l.Lock()
+	defer re.l.Unlock()
+
+	if re.done {
+		return
+	}
+	re.done = true
+	if err := re.x.write(e); err != nil {
+		log.Error("error writing recording", "error", err)
+	}
+}
+
+func (re *recordingEmitter) Emit(v interface{}) error {
+	if ch, ok := v.(chan interface{}); ok {
+		v = (<-chan interface{})(ch)
+	}
+	if ch, isChan := v.(<-chan interface{}); isChan {
+		return EmitChan(re, ch)
+	}
+
+	value := v
+	if single, ok := v.(Single); ok {
+		value = single.Value
+	}
+
+	e := recordEntry{ID: re.id}
+	if r, ok := value.(io.Reader); ok {
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		e.Data, e.Reader = data, true
+		value = bytes.NewReader(data)
+		if _, ok := v.(Single); ok {
+			v = Single{value}
+		} else {
+			v = value
+		}
+	} else {
+		data, err := json.Marshal(TagValue(re.cmd, value))
+		if err != nil {
+			return err
+		}
+		e.Value = data
+	}
+
+	if err := re.x.write(e); err != nil {
+		return err
+	}
+	return re.ResponseEmitter.Emit(v)
+}
+
+func (re *recordingEmitter) Close() error {
+	return re.CloseWithError(nil)
+}
+
+func (re *recordingEmitter) CloseWithError(err error) error {
+	// record before closing, so the recording is complete once the
+	// response has been read
+	re.finish(recordEntry{ID: re.id, Error: toError(err), Done: true})
+	return re.ResponseEmitter.CloseWithError(err)
+}
+
+type typedRecordingEmitter struct {
+	*recordingEmitter
+	typ PostRunType
+}
+
+func (re *typedRecordingEmitter) Type() PostRunType {
+	return re.typ
+}
+
+// recordedResponse holds the recorded entries of a single request.
+type recordedResponse struct {
+	req     *RecordedRequest
+	entries []recordEntry
+}
+
+// NewReplayExecutor returns an Executor that serves the responses recorded
+// by a recording executor and read from r. A request is answered with the
+// first recorded response to a request with the same path, options and
+// arguments that hasn't been replayed yet. PreRun and PostRun functions are
+// not run.
+func NewReplayExecutor(r io.Reader) (Executor, error) {
+	byID := make(map[uint64]*recordedResponse)
+	var order []*recordedResponse
+
+	dec := json.NewDecoder(r)
+	for {
+		var e recordEntry
+		err := dec.Decode(&e)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid recording: %s", err)
+		}
+
+		if e.Request != nil {
+			res := &recordedResponse{req: e.Request}
+			byID[e.ID] = res
+			order = append(order, res)
+			continue
+		}
+
+		res, ok := byID[e.ID]
+		if !ok {
+			return nil, fmt.Errorf("invalid recording: entry for unknown request %d", e.ID)
+		}
+		res.entries = append(res.entries, e)
+		if e.Done {
+			delete(byID, e.ID)
+		}
+	}
+
+	return &replayExecutor{responses: order}, nil
+}
+
+type replayExecutor struct {
+	l         sync.Mutex
+	responses []*recordedResponse
+}
+
+// take removes and returns the first recorded response matching req.
+func (x *replayExecutor) take(req *Request) (*recordedResponse, error) {
+	// normalize the options the way they were recorded
+	data, err := json.Marshal(recordRequest(req))
+	if err != nil {
+		return nil, err
+	}
+	var want RecordedRequest
+	if err := json.Unmarshal(data, &want); err != nil {
+		return nil, err
+	}
+
+	x.l.Lock()
+	defer x.l.Unlock()
+
+	for i, res := range x.responses {
+		if reflect.DeepEqual(*res.req, want) {
+			x.responses = append(x.responses[:i:i], x.responses[i+1:]...)
+			return res, nil
+		}
+	}
+	return nil, ErrNotRecorded
+}
+
+func (x *replayExecutor) Execute(req *Request, re ResponseEmitter, env Environment) error {
+	res, err := x.take(req)
+	if err == ErrNotRecorded {
+		return fmt.Errorf("%w: %s", err, strings.Join(req.Path, " "))
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, e := range res.entries {
+		if e.Done {
+			var err error
+			if e.Error != nil {
+				err = e.Error
+			}
+			if e.Returned {
+				return err
+			}
+			return re.CloseWithError(err)
+		}
+
+		var v interface{}
+		if e.Reader {
+			v = bytes.NewReader(e.Data)
+		} else {
+			v, err = decodeRecordedValue(req.Command, e.Value)
+			if err != nil {
+				return err
+			}
+		}
+		if err := re.Emit(v); err != nil {
+			return err
+		}
+	}
+
+	// the recording ended before the response did
+	return re.CloseWithError(io.ErrUnexpectedEOF)
+}
+
+// decodeRecordedValue decodes data into a new value of the type cmd emits.
+func decodeRecordedValue(cmd *Command, data []byte) (interface{}, error) {
+	var value interface{}
+	if cmd != nil {
+		if valueType := reflect.TypeOf(cmd.Type); valueType != nil {
+			if valueType.Kind() == reflect.Ptr {
+				valueType = valueType.Elem()
+			}
+			value = reflect.New(valueType).Interface()
+		}
+	}
+
+	m := &MaybeError{Value: value}
+	if cmd != nil {
+		m.Outputs = cmd.OutputTypes
+	}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	return m.Get()
+}
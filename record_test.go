@@ -0,0 +1,129 @@
+package cmds
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRecordReplay(t *testing.T) {
+	type value struct {
+		N int
+		S string
+	}
+
+	root := &Command{
+		Options: []Option{StringOption("opt", "")},
+		Subcommands: map[string]*Command{
+			"values": {
+				Arguments: []Argument{StringArg("arg", false, true, "")},
+				Run: func(req *Request, re ResponseEmitter, env Environment) error {
+					for i, arg := range req.Arguments {
+						if err := re.Emit(&value{N: i, S: arg}); err != nil {
+							return err
+						}
+					}
+					return errors.New("values failed")
+				},
+				Type: value{},
+			},
+			"reader": {
+				Run: func(req *Request, re ResponseEmitter, env Environment) error {
+					return re.Emit(strings.NewReader("some data"))
+				},
+			},
+		},
+	}
+
+	type testcase struct {
+		path []string
+		opts OptMap
+		args []string
+	}
+	tcs := []testcase{
+		{path: []string{"values"}, opts: OptMap{"opt": "x"}, args: []string{"a", "b"}},
+		{path: []string{"reader"}},
+		{path: []string{"values"}, args: []string{"c"}},
+	}
+
+	type result struct {
+		values []interface{}
+		data   string
+		err    error
+	}
+
+	run := func(exe Executor, tc testcase) result {
+		req, err := NewRequest(context.Background(), tc.path, tc.opts, tc.args, nil, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		re, res := NewChanResponsePair(req)
+		go func() {
+			if err := exe.Execute(req, re, nil); err != nil {
+				re.CloseWithError(err)
+			}
+		}()
+
+		var r result
+		for {
+			v, err := res.Next()
+			if err != nil {
+				if err != io.EOF {
+					r.err = err
+				}
+				return r
+			}
+			if rd, ok := v.(io.Reader); ok {
+				data, err := ioutil.ReadAll(rd)
+				if err != nil {
+					t.Fatal(err)
+				}
+				r.data = string(data)
+				continue
+			}
+			r.values = append(r.values, v)
+		}
+	}
+
+	var rec bytes.Buffer
+	recorder := NewRecordingExecutor(NewExecutor(root), &rec)
+	var recorded []result
+	for _, tc := range tcs {
+		recorded = append(recorded, run(recorder, tc))
+	}
+
+	replayer, err := NewReplayExecutor(&rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// replay in a different order
+	for _, i := range []int{2, 1, 0} {
+		r := run(replayer, tcs[i])
+		if !reflect.DeepEqual(r.values, recorded[i].values) || r.data != recorded[i].data {
+			t.Errorf("%d: expected %v %q, got %v %q", i, recorded[i].values, recorded[i].data, r.values, r.data)
+		}
+		if r.err == nil || recorded[i].err == nil {
+			if r.err != recorded[i].err {
+				t.Errorf("%d: expected error %v, got %v", i, recorded[i].err, r.err)
+			}
+		} else if r.err.Error() != recorded[i].err.Error() {
+			t.Errorf("%d: expected error %v, got %v", i, recorded[i].err, r.err)
+		}
+	}
+
+	// every response is replayed once
+	req, err := NewRequest(context.Background(), tcs[1].path, nil, nil, nil, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	re, _ := NewChanResponsePair(req)
+	if err := replayer.Execute(req, re, nil); !errors.Is(err, ErrNotRecorded) {
+		t.Errorf("expected %v, got %v", ErrNotRecorded, err)
+	}
+}
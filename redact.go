@@ -0,0 +1,231 @@
+package cmds
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Redacted replaces the values of string fields holding secrets in the
+// output of commands, see OptionShowSecrets.
+const Redacted = "[redacted]"
+
+// redactTag is the option of the cmds struct tag marking fields as holding
+// secrets, e.g.
+//
+//	Token string `json:",omitempty" cmds:"redact"`
+const redactTag = "redact"
+
+var (
+	secretTypesLk sync.RWMutex
+	secretTypes   = make(map[reflect.Type]bool)
+)
+
+// mayHoldSecrets reports whether values of typ may have fields tagged as
+// secrets: it has some, or interfaces whose values may.
+func mayHoldSecrets(typ reflect.Type) bool {
+	secretTypesLk.RLock()
+	may, ok := secretTypes[typ]
+	secretTypesLk.RUnlock()
+	if ok {
+		return may
+	}
+
+	may = typeHoldsSecrets(typ, make(map[reflect.Type]bool))
+	secretTypesLk.Lock()
+	secretTypes[typ] = may
+	secretTypesLk.Unlock()
+	return may
+}
+
+func typeHoldsSecrets(typ reflect.Type, visiting map[reflect.Type]bool) bool {
+	if visiting[typ] {
+		return false
+	}
+	visiting[typ] = true
+	defer delete(visiting, typ)
+
+	switch typ.Kind() {
+	case reflect.Interface:
+		return true
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		return typeHoldsSecrets(typ.Elem(), visiting)
+	case reflect.Struct:
+		for _, index := range encodedFields(typ) {
+			f := typ.FieldByIndex(index)
+			if isSecretField(f) || typeHoldsSecrets(f.Type, visiting) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// encodedFields returns the indexes of the fields of the struct type typ
+// that encoders write: the exported ones, including those of embedded
+// unexported structs.
+func encodedFields(typ reflect.Type) [][]int {
+	var fields [][]int
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		switch {
+		case f.PkgPath == "":
+			fields = append(fields, []int{i})
+		case f.Anonymous && f.Type.Kind() == reflect.Struct:
+			for _, index := range encodedFields(f.Type) {
+				fields = append(fields, append([]int{i}, index...))
+			}
+		}
+	}
+	return fields
+}
+
+func isSecretField(f reflect.StructField) bool {
+	for _, opt := range strings.Split(f.Tag.Get("cmds"), ",") {
+		if opt == redactTag {
+			return true
+		}
+	}
+	return false
+}
+
+// redact returns v with the values of the fields tagged as secrets masked:
+// strings are replaced by Redacted and other values by their zero value.
+// Values without secrets are returned as they are, and the others are
+// copied, so v isn't modified.
+func redact(v interface{}) interface{} {
+	if v == nil || !mayHoldSecrets(reflect.TypeOf(v)) {
+		return v
+	}
+	out, changed := redactValue(reflect.ValueOf(v), make(map[uintptr]bool))
+	if !changed {
+		return v
+	}
+	return out.Interface()
+}
+
+// redactValue returns a copy of v with its secrets masked and true, or v
+// and false if it has none. seen are the pointers being walked, as values
+// may be cyclic.
+func redactValue(v reflect.Value, seen map[uintptr]bool) (reflect.Value, bool) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() || seen[v.Pointer()] {
+			return v, false
+		}
+		seen[v.Pointer()] = true
+		defer delete(seen, v.Pointer())
+		elem, changed := redactValue(v.Elem(), seen)
+		if !changed {
+			return v, false
+		}
+		out := reflect.New(elem.Type())
+		out.Elem().Set(elem)
+		return out, true
+
+	case reflect.Interface:
+		if v.IsNil() {
+			return v, false
+		}
+		elem, changed := redactValue(v.Elem(), seen)
+		if !changed {
+			return v, false
+		}
+		out := reflect.New(v.Type()).Elem()
+		out.Set(elem)
+		return out, true
+
+	case reflect.Struct:
+		var out reflect.Value
+		for _, index := range encodedFields(v.Type()) {
+			field, changed := v.FieldByIndex(index), false
+			if isSecretField(v.Type().FieldByIndex(index)) {
+				field, changed = mask(field)
+			} else {
+				field, changed = redactValue(field, seen)
+			}
+			if !changed {
+				continue
+			}
+			if !out.IsValid() {
+				out = reflect.New(v.Type()).Elem()
+				out.Set(v)
+			}
+			out.FieldByIndex(index).Set(field)
+		}
+		if !out.IsValid() {
+			return v, false
+		}
+		return out, true
+
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return v, false
+		}
+		var out reflect.Value
+		for i := 0; i < v.Len(); i++ {
+			elem, changed := redactValue(v.Index(i), seen)
+			if !changed {
+				continue
+			}
+			if !out.IsValid() {
+				if v.Kind() == reflect.Slice {
+					out = reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+					reflect.Copy(out, v)
+				} else {
+					out = reflect.New(v.Type()).Elem()
+					out.Set(v)
+				}
+			}
+			out.Index(i).Set(elem)
+		}
+		if !out.IsValid() {
+			return v, false
+		}
+		return out, true
+
+	case reflect.Map:
+		if v.IsNil() {
+			return v, false
+		}
+		var out reflect.Value
+		iter := v.MapRange()
+		for iter.Next() {
+			elem, changed := redactValue(iter.Value(), seen)
+			if !changed {
+				continue
+			}
+			if !out.IsValid() {
+				out = reflect.MakeMapWithSize(v.Type(), v.Len())
+				for _, k := range v.MapKeys() {
+					out.SetMapIndex(k, v.MapIndex(k))
+				}
+			}
+			out.SetMapIndex(iter.Key(), elem)
+		}
+		if !out.IsValid() {
+			return v, false
+		}
+		return out, true
+	}
+	return v, false
+}
+
+// mask returns the masked value of a field holding a secret. Zero values
+// are left as they are, as they hide nothing.
+func mask(v reflect.Value) (reflect.Value, bool) {
+	if v.IsZero() {
+		return v, false
+	}
+	if v.Kind() == reflect.String {
+		return reflect.ValueOf(Redacted).Convert(v.Type()), true
+	}
+	return reflect.Zero(v.Type()), true
+}
+
+// showSecrets reports whether the output of req shows the values of fields
+// holding secrets, see OptionShowSecrets.
+func showSecrets(req *Request) bool {
+	show, _ := req.Options[SecretsOpt].(bool)
+	return show
+}
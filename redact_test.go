@@ -0,0 +1,104 @@
+package cmds
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"testing"
+)
+
+type redactCreds struct {
+	User  string
+	Token string `cmds:"redact"`
+	Key   []byte `json:",omitempty" cmds:"redact"`
+}
+
+type redactMeta struct {
+	Secret string `cmds:"redact"`
+}
+
+type redactAccount struct {
+	redactMeta
+	Name  string
+	Creds []*redactCreds
+	Extra map[string]interface{}
+}
+
+func TestRedact(t *testing.T) {
+	creds := &redactCreds{User: "bob", Token: "t0k3n", Key: []byte{1}}
+	account := redactAccount{
+		redactMeta: redactMeta{Secret: "s3cr3t"},
+		Name:       "a",
+		Creds:      []*redactCreds{creds, {User: "eve"}},
+		Extra:      map[string]interface{}{"creds": creds, "n": 1},
+	}
+
+	for _, tc := range []struct {
+		v       interface{}
+		encType EncodingType
+		show    bool
+		out     string
+	}{
+		{v: creds, encType: JSON, out: `{"User":"bob","Token":"[redacted]"}` + "\n"},
+		{v: creds, encType: JSON, show: true, out: `{"User":"bob","Token":"t0k3n","Key":"AQ=="}` + "\n"},
+		{v: *creds, encType: XML, out: "<redactCreds><User>bob</User><Token>[redacted]</Token><Key></Key></redactCreds>"},
+		// empty secrets are left as they are
+		{
+			v:       account,
+			encType: JSON,
+			out:     `{"Secret":"[redacted]","Name":"a","Creds":[{"User":"bob","Token":"[redacted]"},{"User":"eve","Token":""}],"Extra":{"creds":{"User":"bob","Token":"[redacted]"},"n":1}}` + "\n",
+		},
+		{v: []string{"no", "secrets"}, encType: JSON, out: `["no","secrets"]` + "\n"},
+	} {
+		req, err := NewRequest(context.Background(), nil, OptMap{SecretsOpt: tc.show}, nil, nil, &Command{Options: []Option{OptionShowSecrets}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		re, err := NewWriterResponseEmitter(writecloser{&buf, nopCloser{}}, req, StreamWithEncoding(tc.encType))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := re.Emit(tc.v); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != tc.out {
+			t.Errorf("%s, show=%t: expected %s, got %s", tc.encType, tc.show, tc.out, buf.String())
+		}
+	}
+
+	// values are copied rather than modified
+	if creds.Token != "t0k3n" || len(creds.Key) != 1 || account.Secret != "s3cr3t" {
+		t.Error("redaction modified the emitted value")
+	}
+}
+
+func TestRedactCommandEncoder(t *testing.T) {
+	cmd := &Command{
+		Encoders: EncoderMap{
+			Text: MakeTypedEncoder(func(req *Request, w io.Writer, c *redactCreds) error {
+				_, err := fmt.Fprintf(w, "%s:%s", c.User, c.Token)
+				return err
+			}),
+		},
+		Type: redactCreds{},
+	}
+	req, err := NewRequest(context.Background(), nil, nil, nil, nil, cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	re, err := NewWriterResponseEmitter(writecloser{&buf, nopCloser{}}, req, StreamWithEncoding(Text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := re.Emit(&redactCreds{User: "bob", Token: "t0k3n"}); err != nil {
+		t.Fatal(err)
+	}
+	if exp := "bob:[redacted]"; buf.String() != exp {
+		t.Errorf("expected %q, got %q", exp, buf.String())
+	}
+}
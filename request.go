@@ -4,10 +4,45 @@ import (
 	"context"
 	"fmt"
 	"reflect"
-
-	files "github.com/fgeth/fg-ipfs-files"
+	"sort"
+	"strconv"
 )
 
+type requestIDKey struct{}
+
+// ContextWithRequestID returns a context carrying the given request ID.
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID stored in ctx, or "" if there is none.
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+type identityKey struct{}
+
+// ContextWithIdentity returns a context carrying the identity a request is
+// made on behalf of, e.g. the owner of the API key sent with it.
+func ContextWithIdentity(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// Identity returns the identity stored in ctx, or "", the anonymous
+// identity, if there is none. Requests of different identities never share
+// executions, see Command.Singleflight.
+func Identity(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(identityKey{}).(string)
+	return id
+}
+
 // Request represents a call to a command from a consumer
 type Request struct {
 	Context       context.Context
@@ -17,9 +52,20 @@ type Request struct {
 	Arguments []string
 	Options   OptMap
 
-	Files files.Directory
+	Files Directory
 
 	bodyArgs *arguments
+
+	// tempDir is the scratch directory returned by TempDir.
+	tempDir string
+
+	// warnings are returned by Warnings.
+	warnings []string
+
+	// pipe is the request piped into this one, see Pipe, and input its
+	// response, returned by Input.
+	pipe  *Request
+	input Response
 }
 
 // NewRequest returns a request initialized with given arguments
@@ -28,7 +74,7 @@ func NewRequest(ctx context.Context,
 	path []string,
 	opts OptMap,
 	args []string,
-	file files.Directory,
+	file Directory,
 	root *Command,
 ) (*Request, error) {
 
@@ -37,11 +83,9 @@ func NewRequest(ctx context.Context,
 		return nil, err
 	}
 
-	options, err := checkAndConvertOptions(root, opts, path)
-
 	req := &Request{
 		Path:      path,
-		Options:   options,
+		Options:   opts,
 		Arguments: args,
 		Files:     file,
 		Root:      root,
@@ -49,9 +93,57 @@ func NewRequest(ctx context.Context,
 		Context:   ctx,
 	}
 
+	err = req.RenameDeprecatedOptions()
+	if err == nil {
+		req.Options, err = checkAndConvertOptions(root, req.Options, path)
+	}
+
 	return req, err
 }
 
+// Warnings returns the warnings about req for the user, e.g. about the
+// deprecated option names it used. Command.Call sends them to the client.
+func (req *Request) Warnings() []string {
+	return req.warnings
+}
+
+// RenameDeprecatedOptions rewrites the options of req set by deprecated
+// names, see RenamedOption, to their names, and adds warnings about them to
+// req. NewRequest calls it, parsers that set the options of requests
+// themselves should too.
+func (req *Request) RenameDeprecatedOptions() error {
+	optDefs, err := req.Root.GetOptions(req.Path)
+	if err != nil {
+		return err
+	}
+
+	var options OptMap
+	for k, v := range req.Options {
+		opt, ok := optDefs[k]
+		if !ok || !IsDeprecatedName(opt, k) {
+			continue
+		}
+
+		if options == nil {
+			options = make(OptMap, len(req.Options))
+			for k, v := range req.Options {
+				options[k] = v
+			}
+		}
+		name := opt.Name()
+		if _, ok := options[name]; ok {
+			return fmt.Errorf("duplicate command options were provided (%q and %q)", k, name)
+		}
+		delete(options, k)
+		options[name] = v
+		req.warnings = append(req.warnings, fmt.Sprintf("option %q is deprecated, use %q instead", k, name))
+	}
+	if options != nil {
+		req.Options = options
+	}
+	return nil
+}
+
 // BodyArgs returns a scanner that returns arguments passed in the body as tokens.
 //
 // Returns nil if there are no arguments to be consumed via stdin.
@@ -99,6 +191,9 @@ func (req *Request) SetOption(name string, value interface{}) {
 	req.Options[name] = value
 }
 
+// checkAndConvertOptions converts the values of the options in opts to the
+// types of the options, and checks them. The problems with all options are
+// returned together in a *ValidationError.
 func checkAndConvertOptions(root *Command, opts OptMap, path []string) (OptMap, error) {
 	optDefs, err := root.GetOptions(path)
 	options := make(OptMap)
@@ -110,24 +205,39 @@ func checkAndConvertOptions(root *Command, opts OptMap, path []string) (OptMap,
 		options[k] = v
 	}
 
-	for k, v := range opts {
+	// check the options in order, so the problems are reported in order
+	keys := make([]string, 0, len(opts))
+	for k := range opts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var verr ValidationError
+	for _, k := range keys {
+		v := opts[k]
 		opt, ok := optDefs[k]
 		if !ok {
 			continue
 		}
 
-		kind := reflect.TypeOf(v).Kind()
-		if kind != opt.Type() {
+		if optKind := OptionKindOf(opt); optKind != nil {
+			val, err := optKind.convert(v)
+			if err != nil {
+				verr.Add(k, "invalid %s value: %s", optKind.Name, err)
+				continue
+			}
+			options[k] = val
+		} else if kind := reflect.TypeOf(v).Kind(); kind != opt.Type() {
 			if opt.Type() == Strings {
 				if _, ok := v.([]string); !ok {
-					return options, fmt.Errorf("option %q should be type %q, but got type %q",
-						k, opt.Type().String(), kind.String())
+					verr.Add(k, "should be type %q, but got type %q", opt.Type().String(), kind.String())
+					continue
 				}
 			} else {
 				str, ok := v.(string)
 				if !ok {
-					return options, fmt.Errorf("option %q should be type %q, but got type %q",
-						k, opt.Type().String(), kind.String())
+					verr.Add(k, "should be type %q, but got type %q", opt.Type().String(), kind.String())
+					continue
 				}
 
 				val, err := opt.Parse(str)
@@ -136,22 +246,45 @@ func checkAndConvertOptions(root *Command, opts OptMap, path []string) (OptMap,
 					if len(str) == 0 {
 						value = "empty value"
 					}
-					return options, fmt.Errorf("could not convert %s to type %q (for option %q)",
-						value, opt.Type().String(), "-"+k)
+					verr.Add(k, "could not convert %s to type %q", value, opt.Type().String())
+					continue
 				}
 				options[k] = val
 			}
 		}
 
 		for _, name := range opt.Names() {
-			if _, ok := options[name]; name != k && ok {
-				return options, fmt.Errorf("duplicate command options were provided (%q and %q)",
-					k, name)
+			// each pair is reported once, with its first name
+			if _, ok := options[name]; name > k && ok {
+				verr.Add(k, "duplicate of option %q", name)
 			}
 		}
 	}
 
-	return options, nil
+	return options, verr.Err()
+}
+
+// boolOption returns the value of the boolean option name of req, false if
+// it isn't set. It is how options commands may not declare, such as the
+// ClientSideOptions, are read, as those arrive as strings in HTTP requests.
+func boolOption(req *Request, name string) (bool, error) {
+	if req == nil {
+		return false, nil
+	}
+	switch v := req.Options[name].(type) {
+	case nil:
+		return false, nil
+	case bool:
+		return v, nil
+	case string:
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return false, Errorf(ErrClient, "invalid value %q of option %s, expected a boolean", v, name)
+		}
+		return b, nil
+	default:
+		return false, Errorf(ErrClient, "invalid value of option %s, expected a boolean, got %T", name, v)
+	}
 }
 
 // GetEncoding returns the EncodingType set in a request, falling back to JSON
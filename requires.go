@@ -0,0 +1,95 @@
+package cmds
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// requiredTypes returns the interfaces listed in the Requires of cmd, and an
+// error for the entries that aren't nil pointers to an interface.
+func requiredTypes(cmd *Command) ([]reflect.Type, error) {
+	types := make([]reflect.Type, 0, len(cmd.Requires))
+	for _, r := range cmd.Requires {
+		t := reflect.TypeOf(r)
+		if t == nil || t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Interface {
+			return nil, fmt.Errorf("required environment %T is not a pointer to an interface", r)
+		}
+		types = append(types, t.Elem())
+	}
+	return types, nil
+}
+
+// missingRequirements returns the names of the interfaces required by cmd
+// that env doesn't implement.
+func missingRequirements(cmd *Command, env Environment) ([]string, error) {
+	types, err := requiredTypes(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []string
+	envType := reflect.TypeOf(env)
+	for _, t := range types {
+		if envType == nil || !envType.Implements(t) {
+			missing = append(missing, t.String())
+		}
+	}
+	return missing, nil
+}
+
+// checkRequirements fails if env lacks an interface required by the command
+// of req, so the command doesn't fail on a type assertion deep in its Run.
+func checkRequirements(cmd *Command, req *Request, env Environment) error {
+	missing, err := missingRequirements(cmd, env)
+	if err != nil {
+		return Errorf(ErrImplementation, "command %q: %s", strings.Join(req.Path, " "), err)
+	}
+	if len(missing) > 0 {
+		return Errorf(ErrNormal, "command %q unavailable: requires %s", strings.Join(req.Path, " "), strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// ValidateEnvironment checks that env implements the interfaces required by
+// the commands of the tree, see Command.Requires. It returns the errors by
+// command path, like DebugValidate, and is meant to be called once env is
+// built, e.g. when a program starts. Environments implementing Scoper are
+// only checked when commands run, as the environment they are passed
+// depends on their path.
+func ValidateEnvironment(root *Command, env Environment) map[string][]error {
+	if _, ok := env.(Scoper); ok {
+		return nil
+	}
+
+	errs := make(map[string][]error)
+	var visit func(path []string, cmd *Command)
+	visit = func(path []string, cmd *Command) {
+		key := "/" + strings.Join(path, "/")
+		if path == nil {
+			key = ""
+		}
+		missing, err := missingRequirements(cmd, env)
+		if err != nil {
+			errs[key] = append(errs[key], err)
+		}
+		for _, name := range missing {
+			errs[key] = append(errs[key], fmt.Errorf("command unavailable: requires %s", name))
+		}
+
+		names := make([]string, 0, len(cmd.Subcommands))
+		for name := range cmd.Subcommands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			visit(append(path[:len(path):len(path)], name), cmd.Subcommands[name])
+		}
+	}
+	visit(nil, root)
+	if len(errs) == 0 {
+		errs = nil
+	}
+	return errs
+}
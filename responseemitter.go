@@ -31,7 +31,9 @@ func EmitOnce(re ResponseEmitter, v interface{}) error {
 }
 
 // ResponseEmitter encodes and sends the command code's output to the client.
-// It is all a command can write to.
+// It is all a command can write to. ResponseEmitters aren't safe for
+// concurrent use: commands emitting from several goroutines do so through an
+// EmitGroup.
 type ResponseEmitter interface {
 	// Close closes the underlying transport.
 	Close() error
@@ -56,8 +58,13 @@ func Copy(re ResponseEmitter, res Response) error {
 
 	for {
 		v, err := res.Next()
+		forwardWarnings(re, res)
 		if err != nil {
 			if err == io.EOF {
+				if tr := GetTrailer(res); tr != nil && tr.Page != nil {
+					SetPage(re, *tr.Page)
+				}
+				applyStatus(re, res)
 				return re.Close()
 			}
 
@@ -29,6 +29,7 @@ func TestCopy(t *testing.T) {
 			t.Error(err)
 			return
 		}
+		SetStatus(re1, 3)
 
 		err = re1.Close()
 		if err != nil {
@@ -51,6 +52,10 @@ func TestCopy(t *testing.T) {
 	if err != io.EOF {
 		t.Fatalf("expected EOF but got err=%v", err)
 	}
+
+	if tr := GetTrailer(res2); tr == nil || tr.ExitStatus != 3 {
+		t.Errorf("expected the exit status to be copied, got trailer %+v", tr)
+	}
 }
 
 func TestCopyError(t *testing.T) {
@@ -0,0 +1,274 @@
+package cmds
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+)
+
+// RoundTripMismatch is a difference between a value a command emits and the
+// value clients decode from its encoding, see CheckRoundTrip.
+type RoundTripMismatch struct {
+	Encoding EncodingType
+	// Path is the path of the field that differs, e.g. "Links[0].Size",
+	// and empty if the whole value does.
+	Path string
+	// Sent and Received are the values of the field, and Err the error
+	// encoding or decoding the value, if any.
+	Sent, Received interface{}
+	Err            error
+}
+
+func (m RoundTripMismatch) String() string {
+	if m.Err != nil {
+		return fmt.Sprintf("%s: %s", m.Encoding, m.Err)
+	}
+	path := m.Path
+	if path == "" {
+		path = "value"
+	}
+	return fmt.Sprintf("%s: %s: sent %#v, received %#v", m.Encoding, path, m.Sent, m.Received)
+}
+
+// CheckRoundTrip encodes samples in every encoding the output of cmd
+// supports, with the encoders of cmd, and decodes them like clients do,
+// reporting the values that don't come back as they were sent, e.g. fields
+// the encoder leaves out or numbers losing precision. Encodings clients
+// can't decode, e.g. Text, are skipped.
+//
+// Without samples, values of the Type and OutputTypes of cmd are generated,
+// with every exported field set. It is meant to be called from the tests of
+// commands, to catch asymmetric wire formats before they are released.
+func CheckRoundTrip(cmd *Command, samples ...interface{}) []RoundTripMismatch {
+	if len(samples) == 0 {
+		for _, v := range append([]interface{}{cmd.Type}, outputTypeValues(cmd)...) {
+			if t := reflect.TypeOf(v); t != nil {
+				samples = append(samples, sampleValue(t, 0).Interface())
+			}
+		}
+	}
+
+	var mismatches []RoundTripMismatch
+	seen := make(map[EncodingType]bool)
+	for _, encType := range cmd.SupportedEncodings() {
+		// commands falling back to another encoding encode in that one
+		resolved, _, err := resolveEncoder(cmd, encType)
+		if err != nil || seen[resolved] {
+			continue
+		}
+		seen[resolved] = true
+		if _, ok := Decoders[resolved]; !ok {
+			continue
+		}
+
+		for _, sample := range samples {
+			received, err := roundTrip(cmd, resolved, sample)
+			if err != nil {
+				mismatches = append(mismatches, RoundTripMismatch{Encoding: resolved, Err: err})
+				continue
+			}
+			diffValues("", reflect.ValueOf(sample), reflect.ValueOf(received), func(path string, sent, received interface{}) {
+				mismatches = append(mismatches, RoundTripMismatch{
+					Encoding: resolved,
+					Path:     path,
+					Sent:     sent,
+					Received: received,
+				})
+			})
+		}
+	}
+	return mismatches
+}
+
+func outputTypeValues(cmd *Command) []interface{} {
+	values := make([]interface{}, len(cmd.OutputTypes))
+	for i, ot := range cmd.OutputTypes {
+		values[i] = ot.Type
+	}
+	return values
+}
+
+// roundTrip encodes v in encType like NewWriterResponseEmitter does, and
+// decodes it like NewReaderResponse does.
+func roundTrip(cmd *Command, encType EncodingType, v interface{}) (interface{}, error) {
+	// the response decodes into the Type of the command when it is a
+	// pointer, so it gets a fresh one
+	c := *cmd
+	if t := reflect.TypeOf(cmd.Type); t != nil && t.Kind() == reflect.Ptr {
+		c.Type = reflect.New(t.Elem()).Interface()
+	}
+	req := &Request{Context: context.Background(), Root: &c, Command: &c, Options: OptMap{}}
+
+	var buf bytes.Buffer
+	re, err := NewWriterResponseEmitter(bufferCloser{&buf}, req, StreamWithEncoding(encType))
+	if err != nil {
+		return nil, err
+	}
+	if err := re.Emit(v); err != nil {
+		return nil, err
+	}
+	if err := re.Close(); err != nil {
+		return nil, err
+	}
+
+	res, err := NewReaderResponse(&buf, req, StreamWithEncoding(encType))
+	if err != nil {
+		return nil, err
+	}
+	received, err := res.Next()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := res.Next(); err != io.EOF {
+		return nil, fmt.Errorf("expected one value, got more (%v)", err)
+	}
+	return received, nil
+}
+
+type bufferCloser struct {
+	io.Writer
+}
+
+func (bufferCloser) Close() error { return nil }
+
+// sampleValue returns a value of t with every exported field set, to values
+// that are likely to get lost, e.g. integers too large for a float64.
+func sampleValue(t reflect.Type, depth int) reflect.Value {
+	v := reflect.New(t).Elem()
+	if depth > 3 {
+		// leave recursive types alone below some depth
+		return v
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.String:
+		v.SetString("sample")
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(math.MaxInt64 >> (64 - t.Bits()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		v.SetUint(math.MaxUint64 >> (64 - t.Bits()))
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(0.1)
+	case reflect.Ptr:
+		v.Set(sampleValue(t.Elem(), depth+1).Addr())
+	case reflect.Slice:
+		v.Set(reflect.Append(reflect.MakeSlice(t, 0, 1), sampleValue(t.Elem(), depth+1)))
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			v.Index(i).Set(sampleValue(t.Elem(), depth+1))
+		}
+	case reflect.Map:
+		v.Set(reflect.MakeMap(t))
+		v.SetMapIndex(sampleValue(t.Key(), depth+1), sampleValue(t.Elem(), depth+1))
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if f := t.Field(i); f.PkgPath == "" {
+				v.Field(i).Set(sampleValue(f.Type, depth+1))
+			}
+		}
+	}
+	// complex numbers, channels, functions and interfaces are left unset
+	return v
+}
+
+// diffValues calls report with the paths of the fields of sent and received
+// that differ. Pointers and interfaces are compared by the values they hold.
+func diffValues(path string, sent, received reflect.Value, report func(path string, sent, received interface{})) {
+	sent, received = indirect(sent), indirect(received)
+	if !sent.IsValid() || !received.IsValid() {
+		if sent.IsValid() != received.IsValid() {
+			report(path, valueOf(sent), valueOf(received))
+		}
+		return
+	}
+	if sent.Type() != received.Type() {
+		report(path, valueOf(sent), valueOf(received))
+		return
+	}
+	if equal, ok := equalMethod(sent, received); ok {
+		if !equal {
+			report(path, valueOf(sent), valueOf(received))
+		}
+		return
+	}
+
+	switch sent.Kind() {
+	case reflect.Struct:
+		for i := 0; i < sent.NumField(); i++ {
+			f := sent.Type().Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			name := f.Name
+			if path != "" {
+				name = path + "." + name
+			}
+			diffValues(name, sent.Field(i), received.Field(i), report)
+		}
+	case reflect.Slice, reflect.Array:
+		if sent.Len() != received.Len() {
+			report(path, sent.Interface(), received.Interface())
+			return
+		}
+		for i := 0; i < sent.Len(); i++ {
+			diffValues(fmt.Sprintf("%s[%d]", path, i), sent.Index(i), received.Index(i), report)
+		}
+	case reflect.Map:
+		keys := sent.MapKeys()
+		for _, k := range received.MapKeys() {
+			if !sent.MapIndex(k).IsValid() {
+				keys = append(keys, k)
+			}
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+		})
+		for _, k := range keys {
+			diffValues(fmt.Sprintf("%s[%v]", path, k.Interface()), sent.MapIndex(k), received.MapIndex(k), report)
+		}
+	default:
+		if sent.CanInterface() && !reflect.DeepEqual(sent.Interface(), received.Interface()) {
+			report(path, sent.Interface(), received.Interface())
+		}
+	}
+}
+
+func indirect(v reflect.Value) reflect.Value {
+	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+func valueOf(v reflect.Value) interface{} {
+	if !v.IsValid() || !v.CanInterface() {
+		return nil
+	}
+	return v.Interface()
+}
+
+// equalMethod compares a and b with their Equal method, e.g. that of
+// time.Time, if they have one.
+func equalMethod(a, b reflect.Value) (bool, bool) {
+	if !a.CanInterface() {
+		return false, false
+	}
+	m := a.MethodByName("Equal")
+	if !m.IsValid() {
+		return false, false
+	}
+	mt := m.Type()
+	if mt.NumIn() != 1 || mt.In(0) != a.Type() || mt.NumOut() != 1 || mt.Out(0).Kind() != reflect.Bool {
+		return false, false
+	}
+	return m.Call([]reflect.Value{b})[0].Bool(), true
+}
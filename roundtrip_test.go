@@ -0,0 +1,58 @@
+//go:build !cmds_minimal
+// +build !cmds_minimal
+
+package cmds
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+)
+
+type roundTripObj struct {
+	Name   string
+	Size   uint64
+	Links  []roundTripLink
+	Secret string `json:"-" cbor:"-"`
+}
+
+type roundTripLink struct {
+	Hash string
+}
+
+func TestCheckRoundTrip(t *testing.T) {
+	cmd := &Command{
+		Type:      roundTripObj{},
+		Encodings: []EncodingType{JSON, CBOR, Text},
+	}
+
+	mismatches := CheckRoundTrip(cmd)
+	var got []string
+	for _, m := range mismatches {
+		got = append(got, m.String())
+	}
+	exp := []string{
+		`cbor: Secret: sent "sample", received ""`,
+		`json: Secret: sent "sample", received ""`,
+	}
+	if strings.Join(got, "\n") != strings.Join(exp, "\n") {
+		t.Errorf("expected mismatches\n%s\ngot\n%s", strings.Join(exp, "\n"), strings.Join(got, "\n"))
+	}
+
+	// an encoder sending the size as a float loses its precision
+	cmd.Encodings = []EncodingType{JSON}
+	cmd.Encoders = EncoderMap{
+		JSON: MakeTypedEncoder(func(req *Request, w io.Writer, v *roundTripObj) error {
+			return json.NewEncoder(w).Encode(map[string]interface{}{
+				"Name":  v.Name,
+				"Size":  float64(v.Size),
+				"Links": v.Links,
+			})
+		}),
+	}
+	mismatches = CheckRoundTrip(cmd, &roundTripObj{Name: "a", Size: 1<<53 + 1})
+	if len(mismatches) != 1 || mismatches[0].Path != "Size" || mismatches[0].Received != uint64(1<<53) {
+		t.Errorf("expected the size to lose precision, got %v", mismatches)
+	}
+}
@@ -0,0 +1,207 @@
+package cmds
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// selectStep is a step of a selector: a field of an object, an element of
+// an array, or every element of an array.
+type selectStep struct {
+	field   string
+	index   int
+	isIndex bool
+	each    bool
+}
+
+// selector is a path in the JSON of values, see OptionSelect.
+type selector []selectStep
+
+// parseSelector parses selectors like .Name, .Entries[0].Size or
+// .Entries[].Name.
+func parseSelector(s string) (selector, error) {
+	if !strings.HasPrefix(s, ".") {
+		return nil, fmt.Errorf("invalid selector %q: must start with '.'", s)
+	}
+
+	var sel selector
+	rest := s
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[]")
+			if end < 0 {
+				end = len(rest)
+			}
+			if end > 0 {
+				sel = append(sel, selectStep{field: rest[:end]})
+			} else if rest != "" && rest[0] == '.' {
+				return nil, fmt.Errorf("invalid selector %q: empty field name", s)
+			}
+			rest = rest[end:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid selector %q: missing ']'", s)
+			}
+			if end == 1 {
+				sel = append(sel, selectStep{each: true})
+			} else {
+				i, err := strconv.Atoi(rest[1:end])
+				if err != nil {
+					return nil, fmt.Errorf("invalid selector %q: invalid index %q", s, rest[1:end])
+				}
+				sel = append(sel, selectStep{index: i, isIndex: true})
+			}
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("invalid selector %q: unexpected %q", s, rest[0])
+		}
+	}
+	return sel, nil
+}
+
+// apply returns the parts of v the selector selects, in the JSON of v: one,
+// unless it iterates over arrays. Missing fields and elements select null.
+func (sel selector) apply(v interface{}) ([]interface{}, error) {
+	if len(sel) == 0 {
+		return []interface{}{v}, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := decodeJSONValue(data)
+	if err != nil {
+		return nil, err
+	}
+
+	values := []interface{}{doc}
+	for _, step := range sel {
+		var next []interface{}
+		for _, v := range values {
+			switch {
+			case step.each:
+				arr, ok := v.([]interface{})
+				if !ok {
+					return nil, fmt.Errorf("cannot iterate over %s", jsonKind(v))
+				}
+				next = append(next, arr...)
+			case step.isIndex:
+				if v == nil {
+					next = append(next, nil)
+					continue
+				}
+				arr, ok := v.([]interface{})
+				if !ok {
+					return nil, fmt.Errorf("cannot index %s with %d", jsonKind(v), step.index)
+				}
+				i := step.index
+				if i < 0 {
+					i += len(arr)
+				}
+				if i < 0 || i >= len(arr) {
+					next = append(next, nil)
+				} else {
+					next = append(next, arr[i])
+				}
+			default:
+				if v == nil {
+					next = append(next, nil)
+					continue
+				}
+				obj, ok := v.(map[string]interface{})
+				if !ok {
+					return nil, fmt.Errorf("cannot select field %q of %s", step.field, jsonKind(v))
+				}
+				next = append(next, objectField(obj, step.field))
+			}
+		}
+		values = next
+	}
+	return values, nil
+}
+
+// objectField returns the member name of obj, or the one whose name only
+// differs in case, so .name selects the Name field of structs.
+func objectField(obj map[string]interface{}, name string) interface{} {
+	if v, ok := obj[name]; ok {
+		return v
+	}
+	for k, v := range obj {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return nil
+}
+
+func jsonKind(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case map[string]interface{}:
+		return "an object"
+	case []interface{}:
+		return "an array"
+	case string:
+		return "a string"
+	case bool:
+		return "a boolean"
+	default:
+		return "a number"
+	}
+}
+
+// selectEncoder encodes the parts of values selected by the select option
+// with the global encoder of the encoding, as they no longer are of the
+// type of the command. Text encodings write scalars as they are, and the
+// other values in JSON.
+type selectEncoder struct {
+	sel     selector
+	encType EncodingType
+	enc     Encoder
+}
+
+func newSelectEncoder(req *Request, w io.Writer, encType EncodingType, sel selector) (*selectEncoder, error) {
+	fn, ok := Encoders[encType]
+	if !ok || encType == Protobuf || encType == Raw {
+		return nil, Errorf(ErrClient, "the select option can't be used with the %s encoding", encType)
+	}
+	return &selectEncoder{sel: sel, encType: encType, enc: fn(req)(w)}, nil
+}
+
+func (e *selectEncoder) Encode(v interface{}) error {
+	values, err := e.sel.apply(v)
+	if err != nil {
+		return Errorf(ErrClient, "select: %s", err)
+	}
+	for _, v := range values {
+		if e.encType == Text || e.encType == TextNewline {
+			v = selectText(v)
+		}
+		if err := e.enc.Encode(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func selectText(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(data)
+	}
+}
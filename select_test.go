@@ -0,0 +1,85 @@
+package cmds
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+type selectEntry struct {
+	Name string
+	Size int
+}
+
+type selectListing struct {
+	Dir     string `json:"dir"`
+	Entries []selectEntry
+}
+
+func TestSelect(t *testing.T) {
+	listing := &selectListing{
+		Dir:     "/a",
+		Entries: []selectEntry{{"x", 1}, {"y", 2}},
+	}
+
+	for _, tc := range []struct {
+		sel     string
+		encType EncodingType
+		out     string
+		err     string
+	}{
+		{sel: ".dir", encType: JSON, out: "\"/a\"\n"},
+		// names that only differ in case select fields too
+		{sel: ".Dir", encType: TextNewline, out: "/a\n"},
+		{sel: ".Entries[1]", encType: JSON, out: "{\"Name\":\"y\",\"Size\":2}\n"},
+		{sel: ".Entries[-1].name", encType: JSON, out: "\"y\"\n"},
+		{sel: ".Entries[].Size", encType: TextNewline, out: "1\n2\n"},
+		{sel: ".Entries[0]", encType: TextNewline, out: "{\"Name\":\"x\",\"Size\":1}\n"},
+		{sel: ".Missing.Field", encType: JSON, out: "null\n"},
+		{sel: ".Entries[5]", encType: JSON, out: "null\n"},
+		{sel: ".", encType: NDJSON, out: "{\"dir\":\"/a\",\"Entries\":[{\"Name\":\"x\",\"Size\":1},{\"Name\":\"y\",\"Size\":2}]}\n"},
+		{sel: ".dir[]", encType: JSON, err: "select: cannot iterate over a string"},
+		{sel: ".Entries.Name", encType: JSON, err: `select: cannot select field "Name" of an array`},
+	} {
+		req, err := NewRequest(context.Background(), nil, OptMap{SelectOpt: tc.sel}, nil, nil, &Command{Type: selectListing{}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		re, err := NewWriterResponseEmitter(writecloser{&buf, nopCloser{}}, req, StreamWithEncoding(tc.encType))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = re.Emit(listing)
+		if tc.err != "" {
+			if err == nil || err.Error() != tc.err {
+				t.Errorf("%s: expected error %q, got %v", tc.sel, tc.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %s", tc.sel, err)
+		}
+		if buf.String() != tc.out {
+			t.Errorf("%s, %s: expected %q, got %q", tc.sel, tc.encType, tc.out, buf.String())
+		}
+	}
+}
+
+func TestParseSelector(t *testing.T) {
+	for _, s := range []string{"Name", "..Name", ".Entries[", ".Entries[x]", ".a]"} {
+		if _, err := parseSelector(s); err == nil {
+			t.Errorf("expected selector %q to be invalid", s)
+		}
+	}
+
+	req, err := NewRequest(context.Background(), nil, OptMap{SelectOpt: "Name"}, nil, nil, &Command{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = GetEncoder(req, &bytes.Buffer{}, JSON)
+	if e, ok := err.(Error); !ok || e.Code != ErrClient {
+		t.Errorf("expected a client error for an invalid selector, got %v", err)
+	}
+}
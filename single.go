@@ -0,0 +1,78 @@
+package cmds
+
+import (
+	"strings"
+	"sync"
+)
+
+// singleOutput returns run enforcing the SingleOutput setting of cmd: the
+// value emitted is sent as Single, emitting another one fails, and so does
+// returning without emitting one.
+func singleOutput(cmd *Command, run Function) Function {
+	if !cmd.SingleOutput {
+		return run
+	}
+	return func(req *Request, re ResponseEmitter, env Environment) error {
+		sre := &singleEmitter{ResponseEmitter: re, req: req}
+		if err := run(req, sre, env); err != nil {
+			return err
+		}
+		if !sre.emitted() {
+			return Errorf(ErrImplementation, "command %q returned without emitting a value", strings.Join(req.Path, " "))
+		}
+		return nil
+	}
+}
+
+// singleEmitter emits one value as Single. Progress reports, see AsProgress,
+// are passed on as they are, before it.
+type singleEmitter struct {
+	ResponseEmitter
+	req *Request
+
+	l    sync.Mutex
+	done bool
+}
+
+func (re *singleEmitter) Emit(v interface{}) error {
+	if ch, ok := v.(chan interface{}); ok {
+		v = (<-chan interface{})(ch)
+	}
+	if ch, isChan := v.(<-chan interface{}); isChan {
+		return EmitChan(re, ch)
+	}
+
+	if single, ok := v.(Single); ok {
+		v = single.Value
+	}
+	if _, ok := AsProgress(re.req.Command, v); ok {
+		return re.ResponseEmitter.Emit(v)
+	}
+
+	re.l.Lock()
+	if re.done {
+		re.l.Unlock()
+		return Errorf(ErrImplementation, "command %q emitted more than one value", strings.Join(re.req.Path, " "))
+	}
+	re.done = true
+	re.l.Unlock()
+
+	return re.ResponseEmitter.Emit(Single{v})
+}
+
+// emitted reports whether the value was emitted.
+func (re *singleEmitter) emitted() bool {
+	re.l.Lock()
+	defer re.l.Unlock()
+	return re.done
+}
+
+// Warn passes the warning on to the wrapped emitter, see Warn.
+func (re *singleEmitter) Warn(msg string) {
+	Warn(re.ResponseEmitter, msg)
+}
+
+// SetStatus passes the status on to the wrapped emitter, see SetStatus.
+func (re *singleEmitter) SetStatus(code int) {
+	SetStatus(re.ResponseEmitter, code)
+}
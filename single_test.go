@@ -106,3 +106,66 @@ func TestSingleWriter(t *testing.T) {
 
 	wg.Wait()
 }
+
+func TestSingleOutput(t *testing.T) {
+	extraErr := make(chan error, 1)
+	root := &Command{
+		Subcommands: map[string]*Command{
+			"one": {
+				Run: func(req *Request, re ResponseEmitter, env Environment) error {
+					if err := re.Emit(&Progress{ItemsDone: 1}); err != nil {
+						return err
+					}
+					if err := re.Emit("a"); err != nil {
+						return err
+					}
+					extraErr <- re.Emit("b")
+					return nil
+				},
+				SingleOutput: true,
+			},
+			"none": {
+				Run: func(req *Request, re ResponseEmitter, env Environment) error {
+					return nil
+				},
+				SingleOutput: true,
+			},
+		},
+	}
+
+	run := func(path string) ([]interface{}, error) {
+		req, err := NewRequest(context.Background(), []string{path}, nil, nil, nil, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		re, res := NewChanResponsePair(req)
+		go root.Call(req, re, nil)
+		var values []interface{}
+		for {
+			v, err := res.Next()
+			if err == io.EOF {
+				return values, nil
+			}
+			if err != nil {
+				return values, err
+			}
+			values = append(values, v)
+		}
+	}
+
+	values, err := run("one")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 2 || values[1] != "a" {
+		t.Errorf("expected a progress report and one value, got %v", values)
+	}
+	if e, ok := (<-extraErr).(Error); !ok || e.Code != ErrImplementation {
+		t.Errorf("expected an ErrImplementation error emitting a second value, got %v", e)
+	}
+
+	_, err = run("none")
+	if e, ok := err.(*Error); !ok || e.Code != ErrImplementation {
+		t.Errorf("expected an ErrImplementation error without a value, got %v", err)
+	}
+}
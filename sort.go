@@ -0,0 +1,294 @@
+package cmds
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SortOpt is the name of the sort option.
+const SortOpt = "sort"
+
+// OptionSort makes a command sortable. Commands opt in by listing it in
+// their options; the framework then sorts the values emitted by the command
+// by the field named in the option, optionally followed by ",desc" or ",asc".
+//
+// Sorting needs all values, so emitted values are buffered until the command
+// returns: sorted output is never streamed. Emitted slices are sorted
+// element-wise, and readers can't be sorted.
+var OptionSort = StringOption(SortOpt, "Sort the output by the given field, e.g. name or size,desc")
+
+// LessFunc reports whether a sorts before b.
+type LessFunc func(a, b interface{}) bool
+
+type sortKey struct {
+	typ   reflect.Type
+	field string
+}
+
+var (
+	comparatorsLk sync.RWMutex
+	comparators   = make(map[sortKey]LessFunc)
+)
+
+// RegisterComparator registers less as the comparison of values of the type
+// of example when sorting by field. It takes precedence over the comparison
+// by reflection, and allows sorting by fields that can't be compared or
+// don't exist. less is passed values of the same type as example.
+func RegisterComparator(example interface{}, field string, less LessFunc) {
+	comparatorsLk.Lock()
+	defer comparatorsLk.Unlock()
+	comparators[sortKey{reflect.TypeOf(example), strings.ToLower(field)}] = less
+}
+
+func getComparator(typ reflect.Type, field string) (LessFunc, bool) {
+	comparatorsLk.RLock()
+	defer comparatorsLk.RUnlock()
+	less, ok := comparators[sortKey{typ, strings.ToLower(field)}]
+	return less, ok
+}
+
+// parseSort parses the value of the sort option.
+func parseSort(s string) (field string, desc bool, err error) {
+	field = s
+	if i := strings.IndexByte(s, ','); i >= 0 {
+		field = s[:i]
+		switch order := strings.ToLower(strings.TrimSpace(s[i+1:])); order {
+		case "desc":
+			desc = true
+		case "asc", "":
+		default:
+			return "", false, fmt.Errorf("invalid sort order %q, must be asc or desc", order)
+		}
+	}
+
+	field = strings.TrimSpace(field)
+	if field == "" {
+		return "", false, fmt.Errorf("missing sort field")
+	}
+	return field, desc, nil
+}
+
+// applySort wraps re in an emitter sorting the values emitted, if sorting is
+// requested and the command supports it. Otherwise re is returned.
+func applySort(req *Request, re ResponseEmitter) (ResponseEmitter, error) {
+	s, ok := req.Options[SortOpt].(string)
+	if !ok || s == "" || req.Root == nil {
+		return re, nil
+	}
+	if opts, err := req.Root.GetOptions(req.Path); err != nil || opts[SortOpt] == nil {
+		// the command didn't opt in
+		return re, nil
+	}
+
+	field, desc, err := parseSort(s)
+	if err != nil {
+		return re, Error{Message: err.Error(), Code: ErrClient}
+	}
+	return &sortEmitter{ResponseEmitter: re, field: field, desc: desc}, nil
+}
+
+// sortEmitter buffers all values and emits them sorted when it is closed.
+type sortEmitter struct {
+	ResponseEmitter
+	field string
+	desc  bool
+
+	l      sync.Mutex
+	values []interface{}
+	closed bool
+}
+
+func (re *sortEmitter) Emit(v interface{}) error {
+	if ch, ok := v.(chan interface{}); ok {
+		v = (<-chan interface{})(ch)
+	}
+	if ch, isChan := v.(<-chan interface{}); isChan {
+		return EmitChan(re, ch)
+	}
+
+	isSingle := false
+	if single, ok := v.(Single); ok {
+		v = single.Value
+		isSingle = true
+	}
+
+	if _, ok := v.(io.Reader); ok {
+		return Error{Message: "sorting is not supported for streamed output", Code: ErrClient}
+	}
+
+	re.l.Lock()
+	if re.closed {
+		re.l.Unlock()
+		return ErrClosedEmitter
+	}
+	re.values = append(re.values, v)
+	re.l.Unlock()
+
+	if isSingle {
+		return re.Close()
+	}
+	return nil
+}
+
+func (re *sortEmitter) Close() error {
+	return re.CloseWithError(nil)
+}
+
+func (re *sortEmitter) CloseWithError(err error) error {
+	re.l.Lock()
+	if re.closed {
+		re.l.Unlock()
+		return ErrClosingClosedEmitter
+	}
+	re.closed = true
+	values := re.values
+	re.values = nil
+	re.l.Unlock()
+
+	if sortErr := re.sort(values); sortErr != nil {
+		if err == nil || err == io.EOF {
+			err = sortErr
+		}
+		return re.ResponseEmitter.CloseWithError(err)
+	}
+
+	for _, v := range values {
+		if emitErr := re.ResponseEmitter.Emit(v); emitErr != nil {
+			return emitErr
+		}
+	}
+	return re.ResponseEmitter.CloseWithError(err)
+}
+
+// sort sorts values in place. A single slice is sorted element-wise.
+func (re *sortEmitter) sort(values []interface{}) error {
+	if len(values) == 1 {
+		rv := reflect.Indirect(reflect.ValueOf(values[0]))
+		if rv.Kind() == reflect.Slice {
+			// the elements refer to the slice, so they follow the swaps
+			elems := make([]reflect.Value, rv.Len())
+			for i := range elems {
+				elems[i] = rv.Index(i)
+			}
+			return re.sortValues(elems, reflect.Swapper(rv.Interface()))
+		}
+	}
+
+	elems := make([]reflect.Value, len(values))
+	for i, v := range values {
+		elems[i] = reflect.ValueOf(v)
+	}
+	return re.sortValues(elems, func(i, j int) {
+		values[i], values[j] = values[j], values[i]
+		elems[i], elems[j] = elems[j], elems[i]
+	})
+}
+
+func (re *sortEmitter) sortValues(elems []reflect.Value, swap func(i, j int)) error {
+	if len(elems) < 2 {
+		return nil
+	}
+
+	less, err := re.lessFunc(elems[0].Type())
+	if err != nil {
+		return err
+	}
+
+	var typeErr error
+	sort.Stable(sortable{
+		n: len(elems),
+		less: func(i, j int) bool {
+			a, b := elems[i], elems[j]
+			if a.Type() != b.Type() {
+				typeErr = fmt.Errorf("cannot sort values of different types %s and %s", a.Type(), b.Type())
+				return false
+			}
+			if re.desc {
+				return less(b.Interface(), a.Interface())
+			}
+			return less(a.Interface(), b.Interface())
+		},
+		swap: swap,
+	})
+	return typeErr
+}
+
+// lessFunc returns the comparison by re.field of values of type typ.
+func (re *sortEmitter) lessFunc(typ reflect.Type) (LessFunc, error) {
+	if less, ok := getComparator(typ, re.field); ok {
+		return less, nil
+	}
+
+	styp := typ
+	for styp.Kind() == reflect.Ptr {
+		styp = styp.Elem()
+	}
+	if styp.Kind() != reflect.Struct {
+		return nil, Errorf(ErrClient, "cannot sort values of type %s by field %q", typ, re.field)
+	}
+
+	field, ok := fieldByName(styp, re.field)
+	if !ok {
+		return nil, Errorf(ErrClient, "cannot sort by unknown field %q", re.field)
+	}
+	cmp, ok := compareKind(field.Type)
+	if !ok {
+		return nil, Errorf(ErrClient, "cannot sort by field %q of type %s", re.field, field.Type)
+	}
+
+	return func(a, b interface{}) bool {
+		av := reflect.Indirect(reflect.ValueOf(a)).FieldByIndex(field.Index)
+		bv := reflect.Indirect(reflect.ValueOf(b)).FieldByIndex(field.Index)
+		return cmp(av, bv)
+	}, nil
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// compareKind returns a less function for values of type t, if they can be
+// ordered.
+func compareKind(t reflect.Type) (func(a, b reflect.Value) bool, bool) {
+	if t == timeType {
+		return func(a, b reflect.Value) bool {
+			return a.Interface().(time.Time).Before(b.Interface().(time.Time))
+		}, true
+	}
+
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return func(a, b reflect.Value) bool { return a.Int() < b.Int() }, true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return func(a, b reflect.Value) bool { return a.Uint() < b.Uint() }, true
+	case reflect.Float32, reflect.Float64:
+		return func(a, b reflect.Value) bool { return a.Float() < b.Float() }, true
+	case reflect.String:
+		return func(a, b reflect.Value) bool { return a.String() < b.String() }, true
+	case reflect.Bool:
+		return func(a, b reflect.Value) bool { return !a.Bool() && b.Bool() }, true
+	default:
+		return nil, false
+	}
+}
+
+type sortable struct {
+	n    int
+	less func(i, j int) bool
+	swap func(i, j int)
+}
+
+func (s sortable) Len() int           { return s.n }
+func (s sortable) Less(i, j int) bool { return s.less(i, j) }
+func (s sortable) Swap(i, j int)      { s.swap(i, j) }
+
+func (re *sortEmitter) Warn(msg string) {
+	Warn(re.ResponseEmitter, msg)
+}
+
+func (re *sortEmitter) SetPage(p PageInfo) {
+	SetPage(re.ResponseEmitter, p)
+}
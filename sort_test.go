@@ -0,0 +1,139 @@
+package cmds
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type sortEntry struct {
+	Name string
+	Size int
+}
+
+func TestSort(t *testing.T) {
+	entries := []*sortEntry{{"b", 2}, {"c", 1}, {"a", 3}}
+
+	RegisterComparator(&sortEntry{}, "namelen", func(a, b interface{}) bool {
+		return len(a.(*sortEntry).Name) < len(b.(*sortEntry).Name)
+	})
+
+	root := &Command{
+		Subcommands: map[string]*Command{
+			"stream": {
+				Options: []Option{OptionSort},
+				Run: func(req *Request, re ResponseEmitter, env Environment) error {
+					for _, e := range entries {
+						if err := re.Emit(&sortEntry{e.Name, e.Size}); err != nil {
+							return err
+						}
+					}
+					return nil
+				},
+			},
+			"slice": {
+				Options: []Option{OptionSort},
+				Run: func(req *Request, re ResponseEmitter, env Environment) error {
+					out := make([]sortEntry, len(entries))
+					for i, e := range entries {
+						out[i] = *e
+					}
+					return EmitOnce(re, out)
+				},
+			},
+			"nosort": {
+				// doesn't opt in, the option is ignored
+				Run: func(req *Request, re ResponseEmitter, env Environment) error {
+					for _, e := range entries {
+						if err := re.Emit(e); err != nil {
+							return err
+						}
+					}
+					return nil
+				},
+			},
+		},
+	}
+
+	names := func(vs []interface{}) string {
+		var out []string
+		for _, v := range vs {
+			switch e := v.(type) {
+			case *sortEntry:
+				out = append(out, e.Name)
+			case []sortEntry:
+				for _, e := range e {
+					out = append(out, e.Name)
+				}
+			}
+		}
+		return strings.Join(out, "")
+	}
+
+	type testcase struct {
+		path string
+		sort string
+		exp  string
+		err  string
+	}
+
+	tcs := []testcase{
+		{path: "stream", exp: "bca"},
+		{path: "stream", sort: "name", exp: "abc"},
+		{path: "stream", sort: "Size,desc", exp: "abc"},
+		{path: "stream", sort: "size, asc", exp: "cba"},
+		{path: "stream", sort: "namelen", exp: "bca"},
+		{path: "stream", sort: "color", err: `cannot sort by unknown field "color"`},
+		{path: "stream", sort: "name,up", err: `invalid sort order "up", must be asc or desc`},
+		{path: "slice", sort: "size", exp: "cba"},
+		{path: "nosort", sort: "name", exp: "bca"},
+	}
+
+	for _, tc := range tcs {
+		for _, local := range []bool{true, false} {
+			req, err := NewRequest(context.Background(), []string{tc.path}, OptMap{SortOpt: tc.sort}, nil, nil, root)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			re, res := NewChanResponsePair(req)
+			if local {
+				go func() {
+					if err := NewExecutor(root).Execute(req, re, nil); err != nil {
+						re.CloseWithError(err)
+					}
+				}()
+			} else {
+				go root.Call(req, re, nil)
+			}
+
+			var vs []interface{}
+			for {
+				v, err := res.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					if err.Error() != tc.err {
+						t.Errorf("%s --sort=%q: expected error %q, got %q", tc.path, tc.sort, tc.err, err)
+					}
+					break
+				}
+				vs = append(vs, v)
+			}
+
+			if tc.err == "" {
+				if got := names(vs); got != tc.exp {
+					t.Errorf("%s --sort=%q: expected %q, got %q", tc.path, tc.sort, tc.exp, got)
+				}
+			}
+		}
+	}
+
+	// the emitted values are left alone
+	if exp := []*sortEntry{{"b", 2}, {"c", 1}, {"a", 3}}; !reflect.DeepEqual(entries, exp) {
+		t.Errorf("entries were modified: %v", entries)
+	}
+}
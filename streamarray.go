@@ -0,0 +1,62 @@
+package cmds
+
+import (
+	"bytes"
+	"io"
+)
+
+// arrayWriter writes the values encoded onto it as the elements of a JSON
+// array, see OptionStreamArray. The encoders write each value between begin
+// and end, in as many writes as they like.
+type arrayWriter struct {
+	w       io.Writer
+	buf     bytes.Buffer
+	inValue bool
+	started bool
+}
+
+func (a *arrayWriter) Write(p []byte) (int, error) {
+	if !a.inValue {
+		return a.w.Write(p)
+	}
+	return a.buf.Write(p)
+}
+
+func (a *arrayWriter) begin() {
+	a.inValue = true
+	a.buf.Reset()
+}
+
+// end writes the value encoded since begin as the next element, if there
+// is one: encoders drop some values, e.g. progress reports for clients
+// predating them.
+func (a *arrayWriter) end() error {
+	a.inValue = false
+	v := bytes.TrimRight(a.buf.Bytes(), "\n")
+	if len(v) == 0 {
+		return nil
+	}
+	sep := ",\n"
+	if !a.started {
+		sep = "[\n"
+		a.started = true
+	}
+	_, err := a.w.Write(append([]byte(sep), v...))
+	return err
+}
+
+// close ends the array, which is empty if no value was written.
+func (a *arrayWriter) close() error {
+	end := "\n]\n"
+	if !a.started {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(a.w, end)
+	return err
+}
+
+// streamArray reports whether req asks for the output to be one JSON array,
+// see OptionStreamArray.
+func streamArray(req *Request) (bool, error) {
+	return boolOption(req, ArrayOpt)
+}
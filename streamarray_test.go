@@ -0,0 +1,75 @@
+package cmds
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestStreamArray(t *testing.T) {
+	newEmitter := func(t *testing.T, encType EncodingType, opts OptMap) (ResponseEmitter, *bytes.Buffer) {
+		opts[ArrayOpt] = true
+		req, err := NewRequest(context.Background(), nil, opts, nil, nil, &Command{Type: selectEntry{}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		re, err := NewWriterResponseEmitter(writecloser{&buf, nopCloser{}}, req, StreamWithEncoding(encType))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return re, &buf
+	}
+
+	re, buf := newEmitter(t, JSON, OptMap{})
+	for _, v := range []selectEntry{{"x", 1}, {"y", 2}} {
+		if err := re.Emit(&v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	re.Close()
+	if exp := "[\n{\"Name\":\"x\",\"Size\":1},\n{\"Name\":\"y\",\"Size\":2}\n]\n"; buf.String() != exp {
+		t.Errorf("expected %q, got %q", exp, buf.String())
+	}
+
+	// no values make an empty array
+	re, buf = newEmitter(t, NDJSON, OptMap{})
+	re.Close()
+	if buf.String() != "[]\n" {
+		t.Errorf("expected an empty array, got %q", buf.String())
+	}
+
+	// indented values and errors are elements too
+	re, buf = newEmitter(t, JSON, OptMap{EncPretty: true})
+	if err := re.Emit(&selectEntry{"x", 1}); err != nil {
+		t.Fatal(err)
+	}
+	re.CloseWithError(errors.New("boom"))
+	var elems []json.RawMessage
+	if err := json.Unmarshal(buf.Bytes(), &elems); err != nil {
+		t.Fatalf("the output isn't a JSON array: %s\n%s", err, buf)
+	}
+	if len(elems) != 2 || !bytes.Contains(elems[1], []byte("boom")) {
+		t.Errorf("expected a value and an error, got %s", buf)
+	}
+	if !bytes.Contains(elems[0], []byte("\n  \"Name\": \"x\"")) {
+		t.Errorf("expected an indented value, got %s", elems[0])
+	}
+
+	re, _ = newEmitter(t, XML, OptMap{})
+	if err := re.Emit(&selectEntry{"x", 1}); err == nil {
+		t.Error("expected an error for an encoding that isn't JSON")
+	}
+
+	// the option arrives as a string in HTTP requests
+	for v, exp := range map[string]bool{"1": true, "true": true, "false": false} {
+		if array, err := streamArray(&Request{Options: OptMap{ArrayOpt: v}}); err != nil || array != exp {
+			t.Errorf("%q: expected %v, got %v, %v", v, exp, array, err)
+		}
+	}
+	if _, err := streamArray(&Request{Options: OptMap{ArrayOpt: "yes please"}}); err == nil {
+		t.Error("expected an error for an invalid value")
+	}
+}
@@ -0,0 +1,190 @@
+package cmds
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+const (
+	// DefaultMinBackoff and DefaultMaxBackoff bound the time Subscribe
+	// waits before re-executing a dropped request.
+	DefaultMinBackoff = 100 * time.Millisecond
+	DefaultMaxBackoff = 30 * time.Second
+)
+
+// Heartbeat is sent on idle streams of subscription commands to keep the
+// connection alive, see Command.Subscription. Responses drop heartbeats, they
+// are never returned by Next.
+type Heartbeat struct{}
+
+func (Heartbeat) MarshalJSON() ([]byte, error) {
+	return []byte(`{"Type":"heartbeat"}`), nil
+}
+
+// Sequenced is implemented by values that carry a sequence number. Subscribe
+// uses it to drop duplicates and detect gaps after reconnecting. Sequence
+// numbers must increase by one with every value.
+type Sequenced interface {
+	Seq() uint64
+}
+
+// SubscribeOptions configure Subscribe. The zero value is usable.
+type SubscribeOptions struct {
+	// MinBackoff is the delay before the first retry. It doubles with
+	// every failed attempt, up to MaxBackoff, and is reset once a value
+	// has been received.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// MaxRetries is the number of consecutive failed attempts after which
+	// Subscribe gives up. Zero means retrying forever.
+	MaxRetries int
+
+	// Retry decides whether the request is re-executed after it failed
+	// with err. By default, all errors but those of type ErrClient,
+	// ErrNotFound and ErrForbidden are retried.
+	Retry func(err error) bool
+
+	// Resume is called before the request is re-executed, with the
+	// sequence number of the last value received. It can be used to set an
+	// option that makes the server resume the stream.
+	Resume func(req *Request, last uint64)
+
+	// OnGap is called when values with sequence numbers from to to,
+	// inclusive, were missed.
+	OnGap func(from, to uint64)
+}
+
+// Subscribe executes req and calls fn with every value emitted. When the
+// stream drops, it re-executes req with exponential backoff until the
+// command ends normally, fn returns an error, retrying is given up or the
+// request context is cancelled. Values implementing Sequenced are
+// deduplicated across reconnects.
+func Subscribe(req *Request, exe Executor, env Environment, opts SubscribeOptions, fn func(interface{}) error) error {
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = DefaultMinBackoff
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = DefaultMaxBackoff
+	}
+	if opts.Retry == nil {
+		opts.Retry = defaultRetry
+	}
+
+	var (
+		last     uint64
+		seen     bool
+		backoff  = opts.MinBackoff
+		failures int
+	)
+
+	for {
+		if seen && opts.Resume != nil {
+			opts.Resume(req, last)
+		}
+
+		received, err := subscribeOnce(req, exe, env, func(v interface{}) error {
+			if s, ok := v.(Sequenced); ok {
+				seq := s.Seq()
+				if seen && seq <= last {
+					return nil
+				}
+				if seen && seq > last+1 && opts.OnGap != nil {
+					opts.OnGap(last+1, seq-1)
+				}
+				last, seen = seq, true
+			}
+			return fn(v)
+		})
+
+		switch e := err.(type) {
+		case nil:
+			return nil
+		case callbackError:
+			return e.err
+		}
+
+		if received {
+			backoff = opts.MinBackoff
+			failures = 0
+		}
+		failures++
+
+		if req.Context.Err() != nil {
+			return req.Context.Err()
+		}
+		if !opts.Retry(err) || (opts.MaxRetries > 0 && failures > opts.MaxRetries) {
+			return err
+		}
+
+		ContextLogger(req.Context, log).Debug("subscription dropped, reconnecting", "backoff", backoff, "error", err)
+		select {
+		case <-time.After(backoff):
+		case <-req.Context.Done():
+			return req.Context.Err()
+		}
+
+		backoff *= 2
+		if backoff > opts.MaxBackoff {
+			backoff = opts.MaxBackoff
+		}
+	}
+}
+
+// callbackError wraps errors returned by the callback passed to Subscribe,
+// which are never retried.
+type callbackError struct {
+	err error
+}
+
+func (e callbackError) Error() string {
+	return e.err.Error()
+}
+
+// subscribeOnce executes req once and passes the values to fn. It reports
+// whether any value was received.
+func subscribeOnce(req *Request, exe Executor, env Environment, fn func(interface{}) error) (bool, error) {
+	ctx, cancel := context.WithCancel(req.Context)
+	defer cancel()
+
+	r := *req
+	r.Context = ctx
+
+	re, res := NewChanResponsePair(&r)
+	go func() {
+		if err := exe.Execute(&r, re, env); err != nil {
+			re.CloseWithError(err)
+		}
+	}()
+
+	var received bool
+	for {
+		v, err := res.Next()
+		switch err {
+		case nil:
+		case io.EOF:
+			return received, nil
+		default:
+			return received, err
+		}
+		received = true
+
+		if err := fn(v); err != nil {
+			return received, callbackError{err}
+		}
+	}
+}
+
+// defaultRetry retries errors other than those of the request, which fail
+// again: client errors, including a *ValidationError, and those of commands
+// that don't exist or aren't allowed.
+func defaultRetry(err error) bool {
+	for _, code := range []ErrorType{ErrClient, ErrNotFound, ErrForbidden} {
+		if errors.Is(err, code) {
+			return false
+		}
+	}
+	return true
+}
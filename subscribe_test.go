@@ -0,0 +1,126 @@
+package cmds
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type seqValue uint64
+
+func (v seqValue) Seq() uint64 { return uint64(v) }
+
+type attemptsExecutor struct {
+	attempts [][]interface{}
+	errs     []error
+	n        int
+}
+
+func (x *attemptsExecutor) Execute(req *Request, re ResponseEmitter, env Environment) error {
+	i := x.n
+	x.n++
+	if i >= len(x.attempts) {
+		return errors.New("no more attempts")
+	}
+	for _, v := range x.attempts[i] {
+		if err := re.Emit(v); err != nil {
+			return err
+		}
+	}
+	return re.CloseWithError(x.errs[i])
+}
+
+func TestSubscribe(t *testing.T) {
+	req, err := NewRequest(context.Background(), nil, nil, nil, nil, &Command{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exe := &attemptsExecutor{
+		attempts: [][]interface{}{
+			{seqValue(1), seqValue(2), seqValue(3)},
+			{},
+			{seqValue(2), seqValue(3), seqValue(5)},
+		},
+		errs: []error{errors.New("stream dropped"), errors.New("connection refused"), nil},
+	}
+
+	var (
+		values  []interface{}
+		gaps    [][2]uint64
+		resumes []uint64
+	)
+	opts := SubscribeOptions{
+		MinBackoff: time.Millisecond,
+		Resume: func(req *Request, last uint64) {
+			resumes = append(resumes, last)
+		},
+		OnGap: func(from, to uint64) {
+			gaps = append(gaps, [2]uint64{from, to})
+		},
+	}
+
+	err = Subscribe(req, exe, nil, opts, func(v interface{}) error {
+		values = append(values, v)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if exp := []interface{}{seqValue(1), seqValue(2), seqValue(3), seqValue(5)}; !reflect.DeepEqual(values, exp) {
+		t.Errorf("expected values %v, got %v", exp, values)
+	}
+	if exp := [][2]uint64{{4, 4}}; !reflect.DeepEqual(gaps, exp) {
+		t.Errorf("expected gaps %v, got %v", exp, gaps)
+	}
+	if exp := []uint64{3, 3}; !reflect.DeepEqual(resumes, exp) {
+		t.Errorf("expected resumes %v, got %v", exp, resumes)
+	}
+}
+
+func TestSubscribeGiveUp(t *testing.T) {
+	req, err := NewRequest(context.Background(), nil, nil, nil, nil, &Command{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// client errors are not retried
+	verr := &ValidationError{}
+	verr.Add("count", "must not be negative")
+	for _, clientErr := range []error{
+		Errorf(ErrClient, "bad request"),
+		verr,
+		fmt.Errorf("wrapped: %w", Errorf(ErrForbidden, "forbidden")),
+	} {
+		exe := &attemptsExecutor{
+			attempts: [][]interface{}{{}},
+			errs:     []error{clientErr},
+		}
+		err = Subscribe(req, exe, nil, SubscribeOptions{MinBackoff: time.Millisecond}, func(interface{}) error { return nil })
+		if err == nil || err.Error() != clientErr.Error() || exe.n != 1 {
+			t.Errorf("expected error %q after one attempt, got %v after %d", clientErr, err, exe.n)
+		}
+	}
+
+	// retries are limited
+	exe := &attemptsExecutor{}
+	err = Subscribe(req, exe, nil, SubscribeOptions{MinBackoff: time.Millisecond, MaxRetries: 2}, func(interface{}) error { return nil })
+	if err == nil || exe.n != 3 {
+		t.Errorf("expected an error after 3 attempts, got %v after %d", err, exe.n)
+	}
+
+	// callback errors end the subscription
+	exe = &attemptsExecutor{
+		attempts: [][]interface{}{{seqValue(1)}},
+		errs:     []error{nil},
+	}
+	cbErr := errors.New("stop")
+	err = Subscribe(req, exe, nil, SubscribeOptions{}, func(interface{}) error { return cbErr })
+	if err != cbErr {
+		t.Errorf("expected %v, got %v", cbErr, err)
+	}
+}
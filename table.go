@@ -0,0 +1,301 @@
+package cmds
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"unicode/utf8"
+)
+
+// OptionTable makes text output a table of the fields of the structs emitted
+// named in the option, e.g. "name,size", matched like the sort option
+// matches them, so commands get readable listings without encoders of their
+// own. It takes precedence over the text encoders of commands, and is
+// ignored by the other encodings. Emitted slices make a row per element.
+// Applications supporting it add it to the options of their root command.
+var OptionTable = StringOption(TableOpt, "Print the output as a table of the given fields, e.g. name,size")
+
+// OptionNoHeader leaves the header out of tables, those of OptionTable and
+// of MakeTableEncoder, e.g. when the output is processed by scripts.
+// Applications supporting it add it to the options of their root command.
+var OptionNoHeader = BoolOption(NoHeaderOpt, "Print tables without their header")
+
+// tableColumns returns the columns of the table requested by req, or nil if
+// it doesn't ask for one.
+func tableColumns(req *Request) ([]string, error) {
+	s, _ := req.Options[TableOpt].(string)
+	if s == "" {
+		return nil, nil
+	}
+	cols := strings.Split(s, ",")
+	for i, col := range cols {
+		cols[i] = strings.TrimSpace(col)
+		if cols[i] == "" {
+			return nil, Errorf(ErrClient, "invalid table columns %q", s)
+		}
+	}
+	return cols, nil
+}
+
+// fieldByName returns the field of the struct type t named name, ignoring
+// case.
+func fieldByName(t reflect.Type, name string) (reflect.StructField, bool) {
+	return t.FieldByNameFunc(func(n string) bool {
+		return strings.EqualFold(n, name)
+	})
+}
+
+// tableEncoder writes values as the rows of a table of their fields.
+type tableEncoder struct {
+	cols []string
+	t    *table
+
+	typ    reflect.Type
+	fields [][]int
+}
+
+func newTableEncoder(req *Request, w io.Writer, cols []string) *tableEncoder {
+	return &tableEncoder{
+		cols: cols,
+		t:    newTable(req, w),
+	}
+}
+
+func (e *tableEncoder) Encode(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Slice {
+		for i := 0; i < rv.Len(); i++ {
+			if err := e.addRow(rv.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return e.addRow(rv)
+}
+
+// addRow adds the row of v, and sets the header with the first row.
+func (e *tableEncoder) addRow(v reflect.Value) error {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return Errorf(ErrClient, "cannot print values of type %s as a table", v.Type())
+	}
+
+	if e.typ == nil {
+		if err := e.setType(v.Type()); err != nil {
+			return err
+		}
+	} else if v.Type() != e.typ {
+		return Errorf(ErrClient, "cannot print values of different types %s and %s in one table", e.typ, v.Type())
+	}
+
+	cells := make([]string, len(e.fields))
+	for i, index := range e.fields {
+		cells[i] = fmt.Sprint(v.FieldByIndex(index).Interface())
+	}
+	e.t.add(cells)
+	return nil
+}
+
+// setType looks up the columns in the fields of typ, and sets the header.
+func (e *tableEncoder) setType(typ reflect.Type) error {
+	header := make([]string, len(e.cols))
+	for i, col := range e.cols {
+		field, ok := fieldByName(typ, col)
+		if !ok || field.PkgPath != "" {
+			return Errorf(ErrClient, "unknown table column %q", col)
+		}
+		e.fields = append(e.fields, field.Index)
+		header[i] = strings.ToUpper(col)
+	}
+	e.typ = typ
+	e.t.header = header
+	return nil
+}
+
+func (e *tableEncoder) Finish(err error) error {
+	return e.t.flush()
+}
+
+// MakeTableEncoder returns a Text encoder printing the values a command
+// emits as the rows of a table, under header. row returns the cells of the
+// row of a value, and is a function like func(*Request, *T) []string, whose
+// values it accepts like MakeTypedEncoder does; emitted slices of them make
+// a row per element, e.g. with OptionCollect.
+//
+// Rows are accumulated so the columns are aligned to their widest cell, and
+// the table is printed when the response ends. The header is left out if
+// the request sets OptionNoHeader, and the last column is truncated to fit
+// the width of the output, if it is known, see ContextWithOutputWidth. Like
+// the tables of OptionTable, tables without rows aren't printed.
+func MakeTableEncoder(header []string, row interface{}) func(*Request) func(io.Writer) Encoder {
+	val := reflect.ValueOf(row)
+	t := val.Type()
+	if t.Kind() != reflect.Func || t.NumIn() != 2 || t.In(0) != reflect.TypeOf(&Request{}) {
+		panic("MakeTableEncoder must receive a function matching func(*Request, ...) []string")
+	}
+	if t.NumOut() != 1 || t.Out(0) != reflect.TypeOf([]string(nil)) {
+		panic("MakeTableEncoder must receive a function returning []string")
+	}
+	valType := t.In(1)
+
+	return MakeStatefulEncoder(func(req *Request, w io.Writer) Encoder {
+		tab := newTable(req, w)
+		tab.header = header
+		return &rowEncoder{t: tab, req: req, row: val, valType: valType}
+	})
+}
+
+// rowEncoder adds rows to a table with the function passed to
+// MakeTableEncoder.
+type rowEncoder struct {
+	t       *table
+	req     *Request
+	row     reflect.Value
+	valType reflect.Type
+}
+
+func (e *rowEncoder) Encode(v interface{}) error {
+	if rv, ok := typedValue(e.valType, v); ok {
+		e.addRow(rv)
+		return nil
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice {
+		return fmt.Errorf("unexpected type %T, expected %v", v, e.valType)
+	}
+	rows := make([]reflect.Value, rv.Len())
+	for i := range rows {
+		elem, ok := typedValue(e.valType, rv.Index(i).Interface())
+		if !ok {
+			return fmt.Errorf("unexpected type %T, expected %v", v, e.valType)
+		}
+		rows[i] = elem
+	}
+	for _, elem := range rows {
+		e.addRow(elem)
+	}
+	return nil
+}
+
+func (e *rowEncoder) addRow(v reflect.Value) {
+	out := e.row.Call([]reflect.Value{reflect.ValueOf(e.req), v})
+	e.t.add(out[0].Interface().([]string))
+}
+
+func (e *rowEncoder) Finish(err error) error {
+	return e.t.flush()
+}
+
+// tablePadding is the space between the columns of tables, and
+// tableMinWidth the width the last column isn't truncated below.
+const (
+	tablePadding  = 2
+	tableMinWidth = 10
+)
+
+// table accumulates the rows of a table, and prints them with their columns
+// aligned once they are all known.
+type table struct {
+	w      io.Writer
+	header []string
+	rows   [][]string
+
+	// noHeader is set if the header isn't printed, see OptionNoHeader.
+	noHeader bool
+	// width is the width the rows are fit in, if it is positive.
+	width int
+}
+
+func newTable(req *Request, w io.Writer) *table {
+	t := &table{w: w}
+	t.noHeader, _ = req.Options[NoHeaderOpt].(bool)
+	if req.Context != nil {
+		t.width = OutputWidth(req.Context)
+	}
+	return t
+}
+
+func (t *table) add(cells []string) {
+	t.rows = append(t.rows, cells)
+}
+
+// flush prints the table. Tables without rows aren't printed.
+func (t *table) flush() error {
+	if len(t.rows) == 0 {
+		return nil
+	}
+	rows := t.rows
+	if !t.noHeader && t.header != nil {
+		rows = append([][]string{t.header}, rows...)
+	}
+	t.rows = nil
+
+	var widths []int
+	for _, row := range rows {
+		for i, cell := range row {
+			if i == len(widths) {
+				widths = append(widths, 0)
+			}
+			if n := utf8.RuneCountInString(cell); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+
+	// the last column is truncated if the table is too wide, as long as
+	// that leaves some of it
+	last := -1
+	if t.width > 0 && len(widths) > 0 {
+		used := 0
+		for _, n := range widths[:len(widths)-1] {
+			used += n + tablePadding
+		}
+		if avail := t.width - used; avail >= tableMinWidth && widths[len(widths)-1] > avail {
+			last = avail
+		}
+	}
+
+	var buf bytes.Buffer
+	for _, row := range rows {
+		for i, cell := range row {
+			if i == len(row)-1 {
+				if last > 0 && i == len(widths)-1 && utf8.RuneCountInString(cell) > last {
+					cell = string([]rune(cell)[:last-1]) + "…"
+				}
+				buf.WriteString(cell)
+				break
+			}
+			buf.WriteString(cell)
+			buf.WriteString(strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell)+tablePadding))
+		}
+		buf.WriteByte('\n')
+	}
+	_, err := t.w.Write(buf.Bytes())
+	return err
+}
+
+type outputWidthKey struct{}
+
+// ContextWithOutputWidth returns a copy of ctx telling the encoders of
+// requests with it the width of the output, e.g. that of the terminal the
+// CLI prints to, so tables are fit in it.
+func ContextWithOutputWidth(ctx context.Context, width int) context.Context {
+	return context.WithValue(ctx, outputWidthKey{}, width)
+}
+
+// OutputWidth returns the width of the output of requests with ctx, or 0 if
+// it isn't known, see ContextWithOutputWidth.
+func OutputWidth(ctx context.Context) int {
+	width, _ := ctx.Value(outputWidthKey{}).(int)
+	return width
+}
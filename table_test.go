@@ -0,0 +1,182 @@
+package cmds
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestTable(t *testing.T) {
+	type entry struct {
+		Name string
+		Size int
+		hash string
+	}
+
+	cmd := &Command{
+		Options: []Option{OptionTable, OptionEncodingType},
+		Encoders: EncoderMap{
+			Text: MakeTypedEncoder(func(req *Request, w *bytes.Buffer, e *entry) error {
+				_, err := w.WriteString("custom\n")
+				return err
+			}),
+		},
+		Type: entry{},
+	}
+
+	for _, tc := range []struct {
+		table, enc string
+		values     []interface{}
+		out        string
+		err        string
+	}{
+		{
+			table:  "size, name",
+			values: []interface{}{&entry{Name: "a", Size: 1}, entry{Name: "bbbb", Size: 1234}},
+			out:    "SIZE  NAME\n1     a\n1234  bbbb\n",
+		},
+		{
+			table:  "Name",
+			values: []interface{}{[]entry{{Name: "x"}, {Name: "yy"}}},
+			out:    "NAME\nx\nyy\n",
+		},
+		{
+			// no table without values
+			table: "name",
+			out:   "",
+		},
+		{
+			// the table only applies to text
+			table:  "name",
+			enc:    JSON,
+			values: []interface{}{entry{Name: "a"}},
+			out:    "{\"Name\":\"a\",\"Size\":0}\n",
+		},
+		{
+			values: []interface{}{&entry{Name: "a"}},
+			out:    "custom\n",
+		},
+		{table: "name,", err: `invalid table columns "name,"`},
+		{table: "color", values: []interface{}{entry{}}, err: `unknown table column "color"`},
+		{table: "hash", values: []interface{}{entry{}}, err: `unknown table column "hash"`},
+		{table: "name", values: []interface{}{"a"}, err: "cannot print values of type string as a table"},
+	} {
+		opts := map[string]interface{}{}
+		if tc.table != "" {
+			opts[TableOpt] = tc.table
+		}
+		if tc.enc != "" {
+			opts[EncLong] = tc.enc
+		}
+		req, err := NewRequest(context.Background(), nil, opts, nil, nil, cmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		_, enc, err := GetEncoder(req, &buf, Text)
+		if err == nil {
+			for _, v := range tc.values {
+				if err = enc.Encode(v); err != nil {
+					break
+				}
+			}
+		}
+		if err == nil {
+			err = FinishEncoder(enc, nil)
+		}
+
+		switch {
+		case tc.err != "":
+			if err == nil || err.Error() != tc.err {
+				t.Errorf("%q: expected error %q, got %v", tc.table, tc.err, err)
+			}
+		case err != nil:
+			t.Errorf("%q: unexpected error: %s", tc.table, err)
+		case buf.String() != tc.out:
+			t.Errorf("%q: expected output %q, got %q", tc.table, tc.out, buf.String())
+		}
+	}
+}
+
+func TestMakeTableEncoder(t *testing.T) {
+	type entry struct {
+		Name string
+		Path string
+	}
+
+	cmd := &Command{
+		Options: []Option{OptionNoHeader, OptionEncodingType},
+		Encoders: EncoderMap{
+			Text: MakeTableEncoder([]string{"NAME", "PATH"}, func(req *Request, e *entry) []string {
+				return []string{e.Name, e.Path}
+			}),
+		},
+		Type: entry{},
+	}
+
+	for _, tc := range []struct {
+		noHeader bool
+		width    int
+		values   []interface{}
+		out      string
+		err      string
+	}{
+		{
+			values: []interface{}{&entry{"a", "/a"}, entry{"bbbbbb", "/b/c"}},
+			out:    "NAME    PATH\na       /a\nbbbbbb  /b/c\n",
+		},
+		{
+			noHeader: true,
+			values:   []interface{}{[]entry{{"a", "/a"}, {"bb", "/b"}}},
+			out:      "a   /a\nbb  /b\n",
+		},
+		{
+			// the last column is truncated to fit the width
+			width:  16,
+			values: []interface{}{&entry{"a", "/a/long/path/here"}, &entry{"b", "/b"}},
+			out:    "NAME  PATH\na     /a/long/p…\nb     /b\n",
+		},
+		{
+			// unless too little of it would be left
+			width:  8,
+			values: []interface{}{&entry{"a", "/a/long/path/here"}},
+			out:    "NAME  PATH\na     /a/long/path/here\n",
+		},
+		{out: ""},
+		{values: []interface{}{"a"}, err: "unexpected type string, expected *cmds.entry"},
+	} {
+		ctx := context.Background()
+		if tc.width > 0 {
+			ctx = ContextWithOutputWidth(ctx, tc.width)
+		}
+		req, err := NewRequest(ctx, nil, map[string]interface{}{NoHeaderOpt: tc.noHeader}, nil, nil, cmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		_, enc, err := GetEncoder(req, &buf, Text)
+		if err == nil {
+			for _, v := range tc.values {
+				if err = enc.Encode(v); err != nil {
+					break
+				}
+			}
+		}
+		if err == nil {
+			err = FinishEncoder(enc, nil)
+		}
+
+		switch {
+		case tc.err != "":
+			if err == nil || err.Error() != tc.err {
+				t.Errorf("expected error %q, got %v", tc.err, err)
+			}
+		case err != nil:
+			t.Errorf("unexpected error: %s", err)
+		case buf.String() != tc.out:
+			t.Errorf("expected output %q, got %q", tc.out, buf.String())
+		}
+	}
+}
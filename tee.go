@@ -0,0 +1,233 @@
+package cmds
+
+import (
+	"io"
+)
+
+// Tee returns a ResponseEmitter sending the values emitted to re to other as
+// well, e.g. to save the output of a command while it is printed. Emitted
+// readers are copied to other as re reads them. Warnings and page info are
+// passed on to both, while the exit status and the type of the emitter,
+// which selects the PostRun function of commands, are those of re.
+//
+// Errors of re are returned first. Once other fails, it gets no more data
+// from the emitted readers, so re still gets all of it.
+func Tee(re, other ResponseEmitter) ResponseEmitter {
+	return &teeEmitter{re: re, other: other}
+}
+
+type teeEmitter struct {
+	re, other ResponseEmitter
+}
+
+func (t *teeEmitter) Emit(v interface{}) error {
+	// channel emission iteration
+	if ch, ok := v.(chan interface{}); ok {
+		v = (<-chan interface{})(ch)
+	}
+	if ch, isChan := v.(<-chan interface{}); isChan {
+		return EmitChan(t, ch)
+	}
+
+	var isSingle bool
+	if s, ok := v.(Single); ok {
+		v = s.Value
+		isSingle = true
+	}
+
+	var err error
+	if r, ok := v.(io.Reader); ok {
+		err = t.emitReader(r)
+	} else {
+		err = t.re.Emit(v)
+		if oerr := t.other.Emit(v); err == nil {
+			err = oerr
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if isSingle {
+		return t.Close()
+	}
+	return nil
+}
+
+// emitReader emits r to re, and the data re reads from it to other.
+func (t *teeEmitter) emitReader(r io.Reader) error {
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := t.other.Emit(pr)
+		// writes fail from now on, and are dropped
+		pr.CloseWithError(err)
+		done <- err
+	}()
+
+	err := t.re.Emit(io.TeeReader(r, &dropWriter{w: pw}))
+	pw.CloseWithError(err)
+	if oerr := <-done; err == nil {
+		err = oerr
+	}
+	return err
+}
+
+// dropWriter writes to w until it fails, and drops the data after that.
+type dropWriter struct {
+	w      io.Writer
+	failed bool
+}
+
+func (d *dropWriter) Write(p []byte) (int, error) {
+	if !d.failed {
+		if _, err := d.w.Write(p); err != nil {
+			d.failed = true
+		}
+	}
+	return len(p), nil
+}
+
+func (t *teeEmitter) Close() error {
+	err := t.re.Close()
+	if oerr := t.other.Close(); err == nil {
+		err = oerr
+	}
+	return err
+}
+
+func (t *teeEmitter) CloseWithError(err error) error {
+	cerr := t.re.CloseWithError(err)
+	if oerr := t.other.CloseWithError(err); cerr == nil {
+		cerr = oerr
+	}
+	return cerr
+}
+
+func (t *teeEmitter) SetLength(length uint64) {
+	t.re.SetLength(length)
+	t.other.SetLength(length)
+}
+
+func (t *teeEmitter) Warn(msg string) {
+	Warn(t.re, msg)
+	if w, ok := t.other.(Warner); ok {
+		w.Warn(msg)
+	}
+}
+
+func (t *teeEmitter) SetPage(p PageInfo) {
+	SetPage(t.re, p)
+	SetPage(t.other, p)
+}
+
+func (t *teeEmitter) SetStatus(code int) {
+	SetStatus(t.re, code)
+}
+
+func (t *teeEmitter) Status() int {
+	if s, ok := t.re.(interface{ Status() int }); ok {
+		return s.Status()
+	}
+	return 0
+}
+
+func (t *teeEmitter) Type() PostRunType {
+	if typer, ok := t.re.(interface{ Type() PostRunType }); ok {
+		return typer.Type()
+	}
+	return Undefined
+}
+
+// ValueTee is implemented by emitters sending the values of commands
+// elsewhere as well, e.g. the Tee of the CLI saving them to a file. When a
+// command has a PostRun function for such an emitter, the function's
+// output would go there instead of the values. So the executors pass the
+// values the function reads to the emitter TeeValues returns rather than
+// teeing its output.
+type ValueTee interface {
+	// TeeValues returns the emitter the values go to, which is closed once
+	// the PostRun function returns, and makes the emitter send what it is
+	// passed only where it would without the tee.
+	TeeValues() ResponseEmitter
+}
+
+// teeValues returns res, sending the values read from it to the emitter of
+// re if it is a ValueTee. The returned function must be called once res is
+// no longer read.
+func teeValues(re ResponseEmitter, res Response) (Response, func(error)) {
+	vt, ok := re.(ValueTee)
+	if !ok {
+		return res, func(error) {}
+	}
+	tres := &teeResponse{Response: res, other: vt.TeeValues()}
+	return tres, tres.finish
+}
+
+// teeResponse sends the values read from a response to other as well.
+// Readers are copied to other as they are read.
+type teeResponse struct {
+	Response
+	other ResponseEmitter
+
+	// pw and done are those of the reader being copied, if any.
+	pw     *io.PipeWriter
+	done   chan struct{}
+	closed bool
+}
+
+func (r *teeResponse) Next() (interface{}, error) {
+	r.endReader()
+
+	v, err := r.Response.Next()
+	if err != nil {
+		r.finish(err)
+		return v, err
+	}
+
+	// failures of other don't fail the response
+	if rd, ok := v.(io.Reader); ok {
+		pr, pw := io.Pipe()
+		r.pw, r.done = pw, make(chan struct{})
+		go func(done chan struct{}) {
+			defer close(done)
+			// writes fail from now on, and are dropped
+			pr.CloseWithError(r.other.Emit(pr))
+		}(r.done)
+		return io.TeeReader(rd, &dropWriter{w: pw}), nil
+	}
+	r.other.Emit(v)
+	return v, nil
+}
+
+// endReader ends the copy of the reader returned last, if any.
+func (r *teeResponse) endReader() {
+	if r.pw == nil {
+		return
+	}
+	r.pw.Close()
+	<-r.done
+	r.pw, r.done = nil, nil
+}
+
+// finish closes other, with err unless res ended normally.
+func (r *teeResponse) finish(err error) {
+	r.endReader()
+	if r.closed {
+		return
+	}
+	r.closed = true
+	if err == nil || err == io.EOF {
+		r.other.Close()
+	} else {
+		r.other.CloseWithError(err)
+	}
+}
+
+func (r *teeResponse) Trailer() *Trailer {
+	return GetTrailer(r.Response)
+}
+
+func (r *teeResponse) NextWarning() (string, bool) {
+	return NextWarning(r.Response)
+}
@@ -0,0 +1,66 @@
+package cmds
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) { return 0, errors.New("disk full") }
+func (failingWriter) Close() error                { return nil }
+
+func TestTee(t *testing.T) {
+	cmd := &Command{Type: ""}
+	req, err := NewRequest(context.Background(), nil, nil, nil, nil, cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var text, js bytes.Buffer
+	re, err := NewWriterResponseEmitter(bufferCloser{&text}, req, StreamWithEncoding(TextNewline))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewWriterResponseEmitter(bufferCloser{&js}, req, StreamWithEncoding(JSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tee := Tee(re, other)
+	if err := tee.Emit("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tee.Emit(strings.NewReader("raw\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := EmitOnce(tee, "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	if text.String() != "a\nraw\nb\n" {
+		t.Errorf("unexpected text output %q", text.String())
+	}
+	if js.String() != "\"a\"\nraw\n\"b\"\n" {
+		t.Errorf("unexpected JSON output %q", js.String())
+	}
+	if err := tee.Close(); err != ErrClosingClosedEmitter {
+		t.Errorf("expected the emitters to be closed, got %v", err)
+	}
+
+	// readers are read to the end whatever becomes of the other emitter
+	text.Reset()
+	re, _ = NewWriterResponseEmitter(bufferCloser{&text}, req, StreamWithEncoding(Text))
+	other, _ = NewWriterResponseEmitter(failingWriter{}, req, StreamWithEncoding(JSON))
+	tee = Tee(re, other)
+	data := strings.Repeat("x", 1<<20)
+	if err := tee.Emit(strings.NewReader(data)); err == nil || err.Error() != "disk full" {
+		t.Errorf("expected the error of the other emitter, got %v", err)
+	}
+	if text.String() != data {
+		t.Errorf("expected %d bytes, got %d", len(data), text.Len())
+	}
+}
@@ -0,0 +1,51 @@
+package cmds
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+)
+
+// tempDirLk guards the tempDir field of all requests.
+var tempDirLk sync.Mutex
+
+// TempDir returns a scratch directory for the request, creating it on first
+// use. The directory and its contents are removed once the command and its
+// PostRun have finished, even if they failed or were canceled, so commands
+// don't need to clean up after themselves.
+func (req *Request) TempDir() (string, error) {
+	tempDirLk.Lock()
+	defer tempDirLk.Unlock()
+
+	if req.tempDir == "" {
+		dir, err := ioutil.TempDir("", "cmds-"+strings.Join(req.Path, "-")+"-")
+		if err != nil {
+			return "", err
+		}
+		req.tempDir = dir
+	}
+	return req.tempDir, nil
+}
+
+// RemoveTempDir removes the scratch directory of the request, if TempDir
+// created one. Executors call it once the command and its PostRun have
+// finished.
+func (req *Request) RemoveTempDir() error {
+	tempDirLk.Lock()
+	dir := req.tempDir
+	req.tempDir = ""
+	tempDirLk.Unlock()
+
+	if dir == "" {
+		return nil
+	}
+	return os.RemoveAll(dir)
+}
+
+// removeTempDir removes the scratch directory of req, logging failures.
+func removeTempDir(req *Request) {
+	if err := req.RemoveTempDir(); err != nil {
+		ContextLogger(req.Context, log).Error("error removing request temp dir", "error", err)
+	}
+}
@@ -0,0 +1,96 @@
+package cmds
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTempDir(t *testing.T) {
+	errFailed := errors.New("failed")
+
+	var dir string
+	run := func(fail bool) Function {
+		return func(req *Request, re ResponseEmitter, env Environment) error {
+			var err error
+			if dir, err = req.TempDir(); err != nil {
+				return err
+			}
+			// the directory is the same for the whole request
+			if again, _ := req.TempDir(); again != dir {
+				t.Errorf("expected the same directory, got %q and %q", dir, again)
+			}
+			if err := ioutil.WriteFile(filepath.Join(dir, "upload"), []byte("data"), 0600); err != nil {
+				return err
+			}
+			if fail {
+				return errFailed
+			}
+			return re.Emit(dir)
+		}
+	}
+
+	var postRunSaw bool
+	root := &Command{
+		Subcommands: map[string]*Command{
+			"ok": {
+				Run:  run(false),
+				Type: "",
+				PostRun: PostRunMap{
+					CLI: func(res Response, re ResponseEmitter) error {
+						v, err := res.Next()
+						if err != nil {
+							return err
+						}
+						_, err = os.Stat(filepath.Join(v.(string), "upload"))
+						postRunSaw = err == nil
+						return nil
+					},
+				},
+			},
+			"fail": {
+				Run: run(true),
+			},
+		},
+	}
+
+	for _, path := range []string{"ok", "fail"} {
+		postRunSaw = false
+		req, err := NewRequest(context.Background(), []string{path}, nil, nil, nil, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		re, res := NewChanResponsePair(req)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			NewExecutor(root).Execute(req, cliMockEmitter{re}, nil)
+		}()
+		for err == nil {
+			_, err = res.Next()
+		}
+		<-done
+
+		if path == "ok" && !postRunSaw {
+			t.Errorf("%s: expected the temp dir to exist in PostRun", path)
+		}
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			t.Errorf("%s: expected temp dir to be removed, got %v", path, err)
+		}
+	}
+
+	// Call removes it too
+	req, err := NewRequest(context.Background(), []string{"fail"}, nil, nil, nil, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	re, _ := NewChanResponsePair(req)
+	root.Call(req, re, nil)
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("expected temp dir to be removed after Call, got %v", err)
+	}
+}
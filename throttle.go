@@ -0,0 +1,127 @@
+package cmds
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// DefaultProgressRate is the default of OptionProgressRate.
+const DefaultProgressRate = 10
+
+// OptionProgressRate limits the progress reports of commands, see
+// AsProgress, sent to the client per second, so commands reporting their
+// progress often don't saturate slow terminals and links. Reports in
+// between are dropped, but the last one is always delivered. Zero lets all
+// reports through. Applications supporting it add it to the options of
+// their root command.
+var OptionProgressRate = IntOption(ProgressRateOpt, "Send at most this many progress reports per second, 0 for all").WithDefault(DefaultProgressRate)
+
+// applyThrottle wraps re in an emitter limiting the progress reports
+// emitted, if the progress rate option is set. Otherwise re is returned.
+func applyThrottle(req *Request, re ResponseEmitter) ResponseEmitter {
+	rate, ok := req.Options[ProgressRateOpt].(int)
+	if !ok || rate <= 0 {
+		return re
+	}
+	return WrapEmitter(&throttleEmitter{
+		EmitterWrapper: EmitterWrapper{re},
+		cmd:            req.Command,
+		interval:       time.Second / time.Duration(rate),
+	}, re)
+}
+
+// throttleEmitter sends a progress report at most every interval. The last
+// report dropped is sent once the interval has passed, or before the next
+// other value.
+type throttleEmitter struct {
+	EmitterWrapper
+	cmd      *Command
+	interval time.Duration
+
+	l       sync.Mutex
+	last    time.Time
+	pending interface{}
+	timer   *time.Timer
+	closed  bool
+}
+
+func (re *throttleEmitter) Emit(v interface{}) error {
+	if ch, ok := v.(chan interface{}); ok {
+		v = (<-chan interface{})(ch)
+	}
+	if ch, isChan := v.(<-chan interface{}); isChan {
+		return EmitChan(re, ch)
+	}
+
+	re.l.Lock()
+	defer re.l.Unlock()
+
+	if re.closed {
+		return ErrClosedEmitter
+	}
+
+	if _, ok := AsProgress(re.cmd, v); ok {
+		if wait := re.interval - time.Since(re.last); wait > 0 {
+			re.pending = v
+			if re.timer == nil {
+				re.timer = time.AfterFunc(wait, re.flushPending)
+			}
+			return nil
+		}
+		re.pending = nil
+		re.last = time.Now()
+		return re.ResponseEmitter.Emit(v)
+	}
+
+	if err := re.sendPending(); err != nil {
+		return err
+	}
+	return re.ResponseEmitter.Emit(v)
+}
+
+// flushPending sends the pending report once its interval has passed.
+func (re *throttleEmitter) flushPending() {
+	re.l.Lock()
+	defer re.l.Unlock()
+
+	re.timer = nil
+	if !re.closed {
+		re.sendPending()
+	}
+}
+
+// sendPending sends the last report dropped, if any. It is called with the
+// lock held.
+func (re *throttleEmitter) sendPending() error {
+	if re.timer != nil {
+		re.timer.Stop()
+		re.timer = nil
+	}
+	if re.pending == nil {
+		return nil
+	}
+	v := re.pending
+	re.pending = nil
+	re.last = time.Now()
+	return re.ResponseEmitter.Emit(v)
+}
+
+func (re *throttleEmitter) Close() error {
+	return re.CloseWithError(nil)
+}
+
+func (re *throttleEmitter) CloseWithError(err error) error {
+	re.l.Lock()
+	defer re.l.Unlock()
+
+	if re.closed {
+		return ErrClosingClosedEmitter
+	}
+	re.closed = true
+
+	if sendErr := re.sendPending(); sendErr != nil && (err == nil || err == io.EOF) {
+		err = sendErr
+	}
+	return re.ResponseEmitter.CloseWithError(err)
+}
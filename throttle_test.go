@@ -0,0 +1,106 @@
+package cmds
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestProgressRate(t *testing.T) {
+	root := &Command{
+		Options: []Option{OptionProgressRate},
+		Subcommands: map[string]*Command{
+			"add": {
+				Run: func(req *Request, re ResponseEmitter, env Environment) error {
+					for i := uint64(1); i <= 1000; i++ {
+						if err := re.Emit(Progress{ItemsDone: i, ItemsTotal: 1000}); err != nil {
+							return err
+						}
+					}
+					return re.Emit("done")
+				},
+			},
+			"wait": {
+				Run: func(req *Request, re ResponseEmitter, env Environment) error {
+					for i := uint64(1); i <= 2; i++ {
+						if err := re.Emit(Progress{ItemsDone: i}); err != nil {
+							return err
+						}
+					}
+					<-req.Context.Done()
+					return nil
+				},
+			},
+		},
+	}
+
+	run := func(path string, opts OptMap) Response {
+		t.Helper()
+		req, err := NewRequest(context.Background(), []string{path}, opts, nil, nil, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := req.FillDefaults(); err != nil {
+			t.Fatal(err)
+		}
+		re, res := NewChanResponsePair(req)
+		go root.Call(req, re, nil)
+		return res
+	}
+
+	for _, rate := range []int{DefaultProgressRate, 0} {
+		var opts OptMap
+		if rate == 0 {
+			opts = OptMap{ProgressRateOpt: 0}
+		}
+		res := run("add", opts)
+
+		var reports []Progress
+		var last interface{}
+		for {
+			v, err := res.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p, ok := v.(Progress); ok {
+				reports = append(reports, p)
+			}
+			last = v
+		}
+
+		if last != "done" {
+			t.Errorf("rate %d: expected the result last, got %v", rate, last)
+		}
+		if rate == 0 && len(reports) != 1000 {
+			t.Errorf("rate %d: expected all reports, got %d", rate, len(reports))
+		}
+		if rate > 0 && (len(reports) > 5 || reports[len(reports)-1].ItemsDone != 1000) {
+			t.Errorf("rate %d: expected few reports ending with the final one, got %d ending with %v",
+				rate, len(reports), reports[len(reports)-1])
+		}
+	}
+
+	// the last report dropped is sent once the interval has passed
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := NewRequest(ctx, []string{"wait"}, OptMap{ProgressRateOpt: 10}, nil, nil, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	re, res := NewChanResponsePair(req)
+	go root.Call(req, re, nil)
+	for i := uint64(1); i <= 2; i++ {
+		v, err := res.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p := v.(Progress); p.ItemsDone != i {
+			t.Errorf("expected report %d, got %v", i, p)
+		}
+	}
+	cancel()
+}
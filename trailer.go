@@ -0,0 +1,191 @@
+package cmds
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Trailer carries metadata about a response that is only known once the
+// command has finished running. Emitters that support it send the trailer
+// after the last value.
+type Trailer struct {
+	// RequestID identifies the request on the server.
+	RequestID string `json:",omitempty"`
+	// ServerVersion is the version of the server that ran the command.
+	ServerVersion string `json:",omitempty"`
+
+	// Start and End are the times the command started and finished.
+	Start time.Time
+	End   time.Time
+
+	// Count is the number of values emitted.
+	Count uint64
+
+	// Truncated is set if the response was cut short because it exceeded
+	// a size limit.
+	Truncated bool `json:",omitempty"`
+
+	// Warnings holds the warnings sent while running the command.
+	Warnings []string `json:",omitempty"`
+
+	// Page is set by list commands that return a page of their entries.
+	Page *PageInfo `json:",omitempty"`
+
+	// ExitStatus is the exit status the command suggested, see SetStatus.
+	ExitStatus int `json:",omitempty"`
+}
+
+func (t Trailer) MarshalJSON() ([]byte, error) {
+	// marshal through a distinct type to avoid recursing into this method
+	type trailer Trailer
+	return json.Marshal(struct {
+		trailer
+		Type string
+	}{
+		trailer: trailer(t),
+		Type:    "trailer",
+	})
+}
+
+func (t *Trailer) UnmarshalJSON(data []byte) error {
+	type trailer Trailer
+	var w struct {
+		trailer
+		Type string
+	}
+
+	err := json.Unmarshal(data, &w)
+	if err != nil {
+		return err
+	}
+
+	if w.Type != "trailer" {
+		return errors.New("not of type trailer")
+	}
+
+	*t = Trailer(w.trailer)
+	return nil
+}
+
+// GetTrailer returns the trailer received with res, or nil if there is none.
+// It should only be called after Next has returned an error.
+func GetTrailer(res Response) *Trailer {
+	tres, ok := res.(interface {
+		Trailer() *Trailer
+	})
+	if !ok {
+		return nil
+	}
+	return tres.Trailer()
+}
+
+// Warner is implemented by ResponseEmitters that can pass warnings on to the
+// client without interrupting the stream of values.
+type Warner interface {
+	Warn(msg string)
+}
+
+// Warn sends a warning to the client if re supports it and logs it otherwise.
+func Warn(re ResponseEmitter, msg string) {
+	if w, ok := re.(Warner); ok {
+		w.Warn(msg)
+		return
+	}
+	log.Warn(msg)
+}
+
+// StatusSetter is implemented by ResponseEmitters that take the exit status
+// a command suggests: the CLI exits with it, and the HTTP handler passes it
+// on to the client.
+type StatusSetter interface {
+	SetStatus(code int)
+}
+
+// SetStatus suggests code as the exit status of the CLI running the command,
+// whether it runs the command itself or sends it to a server, so scripts see
+// the same status either way. It has no effect if re doesn't support it.
+func SetStatus(re ResponseEmitter, code int) {
+	if s, ok := re.(StatusSetter); ok {
+		s.SetStatus(code)
+	}
+}
+
+// applyStatus passes the exit status suggested with res on to re, unless re
+// has a status already, e.g. one set by PostRun. It is called once res has
+// been read.
+func applyStatus(re ResponseEmitter, res Response) {
+	tr := GetTrailer(res)
+	if tr == nil || tr.ExitStatus == 0 {
+		return
+	}
+	if s, ok := re.(interface{ Status() int }); ok && s.Status() != 0 {
+		return
+	}
+	SetStatus(re, tr.ExitStatus)
+}
+
+// Warning is a warning sent in a JSON stream between the values, see Warn.
+// Responses don't return warnings from Next, but from NextWarning.
+type Warning struct {
+	Message string
+}
+
+func (w Warning) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Message string
+		Type    string
+	}{
+		Message: w.Message,
+		Type:    "warning",
+	})
+}
+
+func (w *Warning) UnmarshalJSON(data []byte) error {
+	var v struct {
+		Message string
+		Type    string
+	}
+
+	err := json.Unmarshal(data, &v)
+	if err != nil {
+		return err
+	}
+
+	if v.Type != "warning" {
+		return errors.New("not of type warning")
+	}
+
+	w.Message = v.Message
+	return nil
+}
+
+// WarningIterator is implemented by responses that receive warnings apart
+// from their values, e.g. those of the HTTP client.
+type WarningIterator interface {
+	// NextWarning returns the next warning received so far, and false if
+	// there is none.
+	NextWarning() (string, bool)
+}
+
+// NextWarning returns the next warning received with res, and false if there
+// is none so far. Warnings arrive while the values are read, so they are
+// checked for after calls to Next; all of them have arrived once Next has
+// returned an error.
+func NextWarning(res Response) (string, bool) {
+	if it, ok := res.(WarningIterator); ok {
+		return it.NextWarning()
+	}
+	return "", false
+}
+
+// forwardWarnings passes the warnings received with res so far on to re.
+func forwardWarnings(re ResponseEmitter, res Response) {
+	for {
+		w, ok := NextWarning(res)
+		if !ok {
+			return
+		}
+		Warn(re, w)
+	}
+}
@@ -0,0 +1,169 @@
+package cmds
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrTransportClosed is returned by transports that were closed.
+var ErrTransportClosed = errors.New("transport closed")
+
+// ClientTransport is the client side of a transport, e.g. websockets,
+// libp2p streams or named pipes: it sends requests to a server and returns
+// their responses. NewTransportExecutor makes it an Executor.
+type ClientTransport interface {
+	Send(req *Request) (Response, error)
+}
+
+// ServerTransport is the server side of a transport: it accepts the requests
+// of clients, with the emitters their responses are sent back with. Serve
+// runs the commands of the requests.
+type ServerTransport interface {
+	// Accept waits for the next request. It returns ErrTransportClosed
+	// once the transport is closed.
+	Accept() (*Request, ResponseEmitter, error)
+	Close() error
+}
+
+// Transport carries requests and responses between clients and a server.
+// Transports only implement how requests and responses travel, and leave
+// running commands to Serve and NewTransportExecutor, so they can live in
+// packages of their own.
+//
+// The HTTP client is a ClientTransport. The HTTP handler is not a
+// ServerTransport: net/http calls it for every request, rather than it
+// accepting them, so it runs commands the way Serve does itself.
+type Transport interface {
+	ClientTransport
+	ServerTransport
+}
+
+// Serve runs the commands of root, with env, for the requests t accepts,
+// until t is closed. It returns once the commands running have finished.
+func Serve(t ServerTransport, root *Command, env Environment) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		req, re, err := t.Accept()
+		if err == ErrTransportClosed {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			root.Call(req, re, env)
+		}()
+	}
+}
+
+// NewTransportExecutor returns an Executor sending requests over t, like the
+// HTTP client does: arguments are checked and PreRun is called before the
+// request is sent, and the response is emitted with EmitResponse.
+func NewTransportExecutor(t ClientTransport) Executor {
+	return &transportExecutor{t: t}
+}
+
+type transportExecutor struct {
+	t ClientTransport
+}
+
+func (x *transportExecutor) Execute(req *Request, re ResponseEmitter, env Environment) error {
+	cmd := req.Command
+	defer removeTempDir(req)
+
+	if err := cmd.CheckArguments(req); err != nil {
+		return err
+	}
+	if cmd.PreRun != nil {
+		if err := cmd.PreRun(req, env); err != nil {
+			return err
+		}
+	}
+
+	res, err := x.t.Send(req)
+	if err != nil {
+		return err
+	}
+	return EmitResponse(res, re)
+}
+
+// EmitResponse emits the response to a request sent to a server to re: it is
+// passed to the PostRun of the command for the type of re if it has one, and
+// copied as is otherwise.
+func EmitResponse(res Response, re ResponseEmitter) error {
+	cmd := res.Request().Command
+	if cmd.PostRun != nil {
+		if typer, ok := re.(interface {
+			Type() PostRunType
+		}); ok && cmd.PostRun[typer.Type()] != nil {
+			res, finishTee := teeValues(re, res)
+			err := cmd.PostRun[typer.Type()](res, re)
+			finishTee(err)
+			applyStatus(re, res)
+			closeErr := re.CloseWithError(err)
+			if closeErr == ErrClosingClosedEmitter {
+				// ignore double close errors
+				return nil
+			}
+
+			return closeErr
+		}
+	}
+
+	return Copy(re, res)
+}
+
+// NewLocalTransport returns a Transport passing requests and responses
+// between the goroutines of a process, e.g. to embed a server or to test
+// commands the way clients run them.
+func NewLocalTransport() Transport {
+	return &localTransport{
+		reqs:   make(chan localRequest),
+		closed: make(chan struct{}),
+	}
+}
+
+type localTransport struct {
+	reqs chan localRequest
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+type localRequest struct {
+	req *Request
+	re  ResponseEmitter
+}
+
+func (t *localTransport) Send(req *Request) (Response, error) {
+	re, res := NewChanResponsePair(req)
+	select {
+	case t.reqs <- localRequest{req: req, re: re}:
+		return res, nil
+	case <-t.closed:
+		return nil, ErrTransportClosed
+	case <-req.Context.Done():
+		return nil, req.Context.Err()
+	}
+}
+
+func (t *localTransport) Accept() (*Request, ResponseEmitter, error) {
+	select {
+	case lr := <-t.reqs:
+		return lr.req, lr.re, nil
+	case <-t.closed:
+		return nil, nil, ErrTransportClosed
+	}
+}
+
+func (t *localTransport) Close() error {
+	t.closeOnce.Do(func() {
+		close(t.closed)
+	})
+	return nil
+}
@@ -0,0 +1,96 @@
+package cmds
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestLocalTransport(t *testing.T) {
+	root := &Command{
+		Subcommands: map[string]*Command{
+			"echo": {
+				Arguments: []Argument{
+					StringArg("words", true, true, "words to echo"),
+				},
+				Run: func(req *Request, re ResponseEmitter, env Environment) error {
+					for _, w := range req.Arguments {
+						if err := re.Emit(w + env.(string)); err != nil {
+							return err
+						}
+					}
+					return nil
+				},
+				Type: "",
+			},
+			"fail": {
+				Run: func(req *Request, re ResponseEmitter, env Environment) error {
+					return Errorf(ErrNotFound, "nothing here")
+				},
+			},
+		},
+	}
+
+	tr := NewLocalTransport()
+	served := make(chan error)
+	go func() {
+		served <- Serve(tr, root, "!")
+	}()
+
+	x := NewTransportExecutor(tr)
+	run := func(path []string, args []string) ([]interface{}, error) {
+		req, err := NewRequest(context.Background(), path, nil, args, nil, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		re, res := NewChanResponsePair(req)
+		go func() {
+			if err := x.Execute(req, re, nil); err != nil {
+				re.CloseWithError(err)
+			}
+		}()
+		var values []interface{}
+		for {
+			v, err := res.Next()
+			if err == io.EOF {
+				return values, nil
+			}
+			if err != nil {
+				return values, err
+			}
+			values = append(values, v)
+		}
+	}
+
+	values, err := run([]string{"echo"}, []string{"a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 2 || values[0] != "a!" || values[1] != "b!" {
+		t.Errorf("unexpected values %v", values)
+	}
+
+	// errors are passed on
+	if _, err := run([]string{"fail"}, nil); err == nil || err.Error() != "nothing here" {
+		t.Errorf("expected the error of the command, got %v", err)
+	}
+
+	// arguments are checked before requests are sent
+	if _, err := run([]string{"echo"}, nil); err == nil {
+		t.Error("expected a missing argument to fail")
+	}
+
+	tr.Close()
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("expected Serve to return nil once closed, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve didn't return")
+	}
+	if _, err := run([]string{"echo"}, []string{"a"}); err != ErrTransportClosed {
+		t.Errorf("expected %v, got %v", ErrTransportClosed, err)
+	}
+}
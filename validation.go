@@ -0,0 +1,92 @@
+package cmds
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// FieldError is a problem with an option or argument of a request.
+type FieldError struct {
+	// Field is the name of the option or argument, or "arg[i]" for the
+	// i-th argument if it has none.
+	Field   string
+	Message string
+}
+
+// ValidationError lists all problems with the options and arguments of a
+// request, so users can fix them at once rather than one per attempt.
+// NewRequest and CheckArguments return them, and PreRun functions checking
+// options of their own can too:
+//
+//	var verr cmds.ValidationError
+//	if n < 0 {
+//		verr.Add("count", "must not be negative, got %d", n)
+//	}
+//	...
+//	return verr.Err()
+//
+// They are errors of type ErrClient. The CLI prints their problems as a
+// list, and the HTTP handler sends them in the Fields member of errors.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+// Add adds a problem with field.
+func (e *ValidationError) Add(field, format string, args ...interface{}) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// Err returns e if it has problems, nil otherwise.
+func (e *ValidationError) Err() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Error returns the problem of the request, or lists them if there are
+// several.
+func (e *ValidationError) Error() string {
+	if len(e.Fields) == 1 {
+		return joinFieldErrors(e.Fields)
+	}
+	return "invalid request: " + joinFieldErrors(e.Fields)
+}
+
+// Unwrap returns ErrClient, see Error.Unwrap.
+func (e *ValidationError) Unwrap() error {
+	return ErrClient
+}
+
+func (e *ValidationError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&Error{Message: e.Error(), Code: ErrClient, Fields: e.Fields})
+}
+
+func joinFieldErrors(fields []FieldError) string {
+	msgs := make([]string, len(fields))
+	for i, f := range fields {
+		msgs[i] = f.Field + ": " + f.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// FieldErrors returns the problems with the options and arguments of a
+// request err lists: those of a ValidationError, or of an Error decoded from
+// one, e.g. by HTTP clients.
+func FieldErrors(err error) []FieldError {
+	var verr *ValidationError
+	if errors.As(err, &verr) {
+		return verr.Fields
+	}
+	var pe *Error
+	if errors.As(err, &pe) {
+		return pe.Fields
+	}
+	var e Error
+	if errors.As(err, &e) {
+		return e.Fields
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package cmds
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestValidationError(t *testing.T) {
+	var verr ValidationError
+	if verr.Err() != nil {
+		t.Fatal("expected no error without problems")
+	}
+	verr.Add("count", "must not be negative, got %d", -1)
+	if exp := "count: must not be negative, got -1"; verr.Error() != exp {
+		t.Errorf("expected %q, got %q", exp, verr.Error())
+	}
+	verr.Add("name", "argument is required")
+
+	err := verr.Err()
+	if exp := "invalid request: count: must not be negative, got -1; name: argument is required"; err.Error() != exp {
+		t.Errorf("expected %q, got %q", exp, err)
+	}
+	if !errors.Is(err, ErrClient) {
+		t.Error("expected a client error")
+	}
+
+	// the problems survive being sent as an Error
+	data, err := json.Marshal(err)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var e Error
+	if err := json.Unmarshal(data, &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Code != ErrClient || e.Message != verr.Error() {
+		t.Errorf("unexpected error %#v", e)
+	}
+	if fields := FieldErrors(&e); !reflect.DeepEqual(fields, verr.Fields) {
+		t.Errorf("expected fields %v, got %v", verr.Fields, fields)
+	}
+}
+
+func TestCheckArgumentsValidationError(t *testing.T) {
+	cmd := &Command{
+		Arguments: []Argument{
+			StringArg("src", true, false, ""),
+			StringArg("dst", true, false, ""),
+		},
+	}
+	req, err := NewRequest(context.Background(), nil, nil, nil, nil, cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []FieldError{
+		{Field: "src", Message: "argument is required"},
+		{Field: "dst", Message: "argument is required"},
+	}
+	if fields := FieldErrors(cmd.CheckArguments(req)); !reflect.DeepEqual(fields, exp) {
+		t.Errorf("expected fields %v, got %v", exp, fields)
+	}
+}
@@ -0,0 +1,73 @@
+package cmds
+
+import "io"
+
+// EmitterWrapper is embedded by ResponseEmitters wrapping another one, so
+// the optional methods of the wrapped emitter they don't override keep
+// working through them: those of Warner, StatusSetter and Pager, and the
+// Status and Type methods of the CLI emitters. They have no effect, or
+// return zero values, if the wrapped emitter doesn't support them. Wrappers
+// are returned through WrapEmitter, which adds the Stdout and Stderr methods
+// of CLI emitters.
+type EmitterWrapper struct {
+	ResponseEmitter
+}
+
+func (w EmitterWrapper) Warn(msg string) {
+	Warn(w.ResponseEmitter, msg)
+}
+
+func (w EmitterWrapper) SetStatus(code int) {
+	SetStatus(w.ResponseEmitter, code)
+}
+
+func (w EmitterWrapper) SetPage(p PageInfo) {
+	SetPage(w.ResponseEmitter, p)
+}
+
+func (w EmitterWrapper) Status() int {
+	if s, ok := w.ResponseEmitter.(interface{ Status() int }); ok {
+		return s.Status()
+	}
+	return 0
+}
+
+func (w EmitterWrapper) Type() PostRunType {
+	if typer, ok := w.ResponseEmitter.(interface{ Type() PostRunType }); ok {
+		return typer.Type()
+	}
+	return Undefined
+}
+
+// stdioEmitter is implemented by the emitters of the CLI, which give
+// commands the terminal to write to.
+type stdioEmitter interface {
+	Stdout() io.Writer
+	Stderr() io.Writer
+}
+
+// WrapEmitter returns wrapper, an emitter wrapping re that embeds an
+// EmitterWrapper, with the Stdout and Stderr methods of re if it has them,
+// so commands and PostRun functions asserting their emitter is a CLI one
+// still find it is. The methods are only added if re has them, as the
+// assertions would succeed otherwise.
+func WrapEmitter(wrapper, re ResponseEmitter) ResponseEmitter {
+	s, ok := re.(stdioEmitter)
+	if !ok {
+		return wrapper
+	}
+	return &stdioWrapper{EmitterWrapper: EmitterWrapper{wrapper}, stdio: s}
+}
+
+type stdioWrapper struct {
+	EmitterWrapper
+	stdio stdioEmitter
+}
+
+func (w *stdioWrapper) Stdout() io.Writer {
+	return w.stdio.Stdout()
+}
+
+func (w *stdioWrapper) Stderr() io.Writer {
+	return w.stdio.Stderr()
+}
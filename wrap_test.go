@@ -0,0 +1,55 @@
+package cmds
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// stdioEmitterStub is a CLI-like emitter recording what is set on it.
+type stdioEmitterStub struct {
+	ResponseEmitter
+	stdout bytes.Buffer
+	page   *PageInfo
+	status int
+	warned []string
+}
+
+func (re *stdioEmitterStub) Stdout() io.Writer      { return &re.stdout }
+func (re *stdioEmitterStub) Stderr() io.Writer      { return &re.stdout }
+func (re *stdioEmitterStub) SetPage(p PageInfo)     { re.page = &p }
+func (re *stdioEmitterStub) SetStatus(code int)     { re.status = code }
+func (re *stdioEmitterStub) Status() int            { return re.status }
+func (re *stdioEmitterStub) Warn(msg string)        { re.warned = append(re.warned, msg) }
+func (re *stdioEmitterStub) Type() PostRunType      { return CLI }
+func (re *stdioEmitterStub) Emit(interface{}) error { return nil }
+
+func TestWrapEmitter(t *testing.T) {
+	stub := &stdioEmitterStub{}
+	req := &Request{Options: OptMap{ProgressRateOpt: DefaultProgressRate}}
+	re := applyThrottle(req, stub)
+
+	SetPage(re, PageInfo{More: true, NextCursor: "abc"})
+	SetStatus(re, 3)
+	Warn(re, "w")
+	if stub.page == nil || stub.page.NextCursor != "abc" || stub.status != 3 || len(stub.warned) != 1 {
+		t.Errorf("the wrapper didn't forward the page, status and warning: %+v", stub)
+	}
+	if typer, ok := re.(interface{ Type() PostRunType }); !ok || typer.Type() != CLI {
+		t.Error("the wrapper didn't forward the type")
+	}
+	stdio, ok := re.(stdioEmitter)
+	if !ok {
+		t.Fatal("the wrapper of a CLI emitter has no Stdout")
+	}
+	io.WriteString(stdio.Stdout(), "out")
+	if stub.stdout.String() != "out" {
+		t.Errorf("expected the output on the stdout of the wrapped emitter, got %q", stub.stdout.String())
+	}
+
+	// other emitters aren't made to look like CLI ones
+	re = applyThrottle(req, &EmitterWrapper{})
+	if _, ok := re.(stdioEmitter); ok {
+		t.Error("the wrapper of an emitter without Stdout has one")
+	}
+}
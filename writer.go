@@ -1,6 +1,7 @@
 package cmds
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"io"
@@ -8,35 +9,102 @@ import (
 	"sync"
 )
 
+// StreamOption configures the response emitters returned by
+// NewWriterResponseEmitter and the responses returned by NewReaderResponse.
+type StreamOption func(*streamConfig)
+
+type streamConfig struct {
+	encType EncodingType
+	encoder EncoderFunc
+	decoder func(io.Reader) Decoder
+}
+
+func newStreamConfig(req *Request, opts []StreamOption) *streamConfig {
+	cfg := &streamConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if cfg.encType == Undefined {
+		cfg.encType = GetEncoding(req, Undefined)
+	}
+	return cfg
+}
+
+// StreamWithEncoding sets the encoding of the stream, instead of the one
+// set with the encoding option of the request.
+func StreamWithEncoding(encType EncodingType) StreamOption {
+	return func(cfg *streamConfig) {
+		cfg.encType = encType
+	}
+}
+
+// StreamWithEncoder sets the encoder of the values emitted, instead of the
+// one the command or Encoders have for the encoding of the stream.
+func StreamWithEncoder(fn EncoderFunc) StreamOption {
+	return func(cfg *streamConfig) {
+		cfg.encoder = fn
+	}
+}
+
+// StreamWithDecoder sets the decoder of the values of a response, instead of
+// the one Decoders has for the encoding of the stream.
+func StreamWithDecoder(fn func(io.Reader) Decoder) StreamOption {
+	return func(cfg *streamConfig) {
+		cfg.decoder = fn
+	}
+}
+
 // NewWriterResponseEmitter creates a response emitter that sends responses to
-// the given WriterCloser.
-func NewWriterResponseEmitter(w io.WriteCloser, req *Request) (ResponseEmitter, error) {
-	_, valEnc, err := GetEncoder(req, w, Undefined)
-	if err != nil {
-		return nil, err
+// the given WriterCloser, encoded like the CLI or HTTP layers would. It lets
+// programs run commands locally and send their output to files or sockets.
+// The emitted readers are copied to w as is.
+//
+// Errors are passed to w if it has a CloseWithError method, like the writer
+// of an io.Pipe. Otherwise they are encoded into JSON streams, where
+// NewReaderResponse returns them from Next.
+func NewWriterResponseEmitter(w io.WriteCloser, req *Request, opts ...StreamOption) (ResponseEmitter, error) {
+	cfg := newStreamConfig(req, opts)
+
+	fn := cfg.encoder
+	if fn == nil {
+		var err error
+		if cfg.encType, fn, err = resolveEncoder(req.Command, cfg.encType); err != nil {
+			return nil, err
+		}
 	}
 
 	re := &writerResponseEmitter{
-		w:   w,
-		c:   w,
-		req: req,
-		enc: valEnc,
+		w:       w,
+		c:       w,
+		req:     req,
+		enc:     newEncoder(req, w, cfg.encType, fn),
+		encType: cfg.encType,
 	}
 
 	return re, nil
 }
 
-// NewReaderResponse creates a Response from the given reader.
-func NewReaderResponse(r io.Reader, req *Request) (Response, error) {
-	encType := GetEncoding(req, Undefined)
-	dec, ok := Decoders[encType]
-	if !ok {
-		return nil, Errorf(ErrClient, "unknown encoding: %s", encType)
+// NewReaderResponse creates a Response from the given reader, which reads the
+// values written by an emitter returned by NewWriterResponseEmitter with the
+// same encoding.
+func NewReaderResponse(r io.Reader, req *Request, opts ...StreamOption) (Response, error) {
+	cfg := newStreamConfig(req, opts)
+	dec := cfg.decoder
+	if dec == nil {
+		// the values were encoded in the encoding the command falls
+		// back to, if any
+		if encType, _, err := resolveEncoder(req.Command, cfg.encType); err == nil {
+			cfg.encType = encType
+		}
+		var ok bool
+		if dec, ok = Decoders[cfg.encType]; !ok {
+			return nil, Errorf(ErrClient, "unknown encoding: %s", cfg.encType)
+		}
 	}
 	return &readerResponse{
 		req:     req,
 		r:       r,
-		encType: encType,
+		encType: cfg.encType,
 		dec:     dec(r),
 		emitted: make(chan struct{}),
 	}, nil
@@ -52,6 +120,10 @@ type readerResponse struct {
 	length uint64
 	err    error
 
+	// warnings are the warnings received and not yet returned by
+	// NextWarning.
+	warnings []string
+
 	emitted chan struct{}
 	once    sync.Once
 }
@@ -63,6 +135,9 @@ func (r *readerResponse) Request() *Request {
 func (r *readerResponse) Error() *Error {
 	<-r.emitted
 
+	if r.err == nil || r.err == io.EOF {
+		return nil
+	}
 	if err, ok := r.err.(*Error); ok {
 		return err
 	}
@@ -70,6 +145,15 @@ func (r *readerResponse) Error() *Error {
 	return &Error{Message: r.err.Error()}
 }
 
+func (r *readerResponse) NextWarning() (string, bool) {
+	if len(r.warnings) == 0 {
+		return "", false
+	}
+	w := r.warnings[0]
+	r.warnings = r.warnings[1:]
+	return w, true
+}
+
 func (r *readerResponse) Length() uint64 {
 	<-r.emitted
 
@@ -77,34 +161,47 @@ func (r *readerResponse) Length() uint64 {
 }
 
 func (r *readerResponse) Next() (interface{}, error) {
-	m := &MaybeError{Value: r.req.Command.Type}
+	defer r.once.Do(func() { close(r.emitted) })
+
+	m := &MaybeError{Value: r.req.Command.Type, Outputs: r.req.Command.OutputTypes}
 	err := r.dec.Decode(m)
+	if err == nil && m.IsWarning() {
+		r.warnings = append(r.warnings, m.Warning.Message)
+		return r.Next()
+	}
+	if err == nil && (m.IsTrailer() || m.IsHeartbeat()) {
+		return r.Next()
+	}
 	if err != nil {
+		r.err = err
 		return nil, err
 	}
 
-	r.once.Do(func() { close(r.emitted) })
-
 	v, err := m.Get()
+	if err != nil {
+		r.err = err
+		return nil, err
+	}
 
 	// because working with pointers to arrays is annoying
-	if t := reflect.TypeOf(v); t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Slice {
+	if t := reflect.TypeOf(v); t != nil && t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Slice {
 		v = reflect.ValueOf(v).Elem().Interface()
 	}
-	return v, err
+	return v, nil
 }
 
 type writerResponseEmitter struct {
-	// TODO maybe make those public?
-	w   io.Writer
-	c   io.Closer
-	enc Encoder
-	req *Request
+	w       io.Writer
+	c       io.Closer
+	enc     Encoder
+	encType EncodingType
+	req     *Request
 
-	length *uint64
+	length uint64
 
-	emitted bool
-	closed  bool
+	emitted  bool
+	closed   bool
+	finished bool
 }
 
 func (re *writerResponseEmitter) CloseWithError(err error) error {
@@ -120,10 +217,38 @@ func (re *writerResponseEmitter) CloseWithError(err error) error {
 		CloseWithError(error) error
 	})
 	if ok {
+		finErr := re.finish(err)
 		re.closed = true
-		return cwe.CloseWithError(err)
+		if err := cwe.CloseWithError(err); err != nil {
+			return err
+		}
+		return finErr
+	}
+
+	// send the error as a value, like the HTTP handler does. It is encoded
+	// before the encoder is finished, so it is part of JSON arrays, see
+	// OptionStreamArray.
+	if re.encType.IsJSON() {
+		e := &Error{Message: err.Error()}
+		switch err := err.(type) {
+		case Error:
+			e = &err
+		case *Error:
+			e = err
+		}
+		encErr := re.enc.Encode(e)
+		finErr := re.finish(err)
+		if closeErr := re.Close(); encErr == nil {
+			encErr = closeErr
+		}
+		if encErr == nil {
+			encErr = finErr
+		}
+		return encErr
 	}
 
+	re.finish(err)
+	re.Close()
 	return errors.New("provided closer does not support CloseWithError")
 }
 
@@ -132,7 +257,7 @@ func (re *writerResponseEmitter) SetLength(length uint64) {
 		return
 	}
 
-	*re.length = length
+	re.length = length
 }
 
 func (re *writerResponseEmitter) Close() error {
@@ -140,8 +265,21 @@ func (re *writerResponseEmitter) Close() error {
 		return ErrClosingClosedEmitter
 	}
 
+	finErr := re.finish(nil)
 	re.closed = true
-	return re.c.Close()
+	if err := re.c.Close(); err != nil {
+		return err
+	}
+	return finErr
+}
+
+// finish finishes the encoder once, when the emitter is closed.
+func (re *writerResponseEmitter) finish(err error) error {
+	if re.finished {
+		return nil
+	}
+	re.finished = true
+	return FinishEncoder(re.enc, err)
 }
 
 func (re *writerResponseEmitter) Emit(v interface{}) error {
@@ -165,7 +303,13 @@ func (re *writerResponseEmitter) Emit(v interface{}) error {
 		isSingle = true
 	}
 
-	err := re.enc.Encode(v)
+	var err error
+	if r, ok := v.(io.Reader); ok {
+		// readers are copied as is, like the CLI and HTTP layers do
+		_, err = io.Copy(re.w, r)
+	} else {
+		err = re.enc.Encode(v)
+	}
 	if err != nil {
 		return err
 	}
@@ -178,10 +322,32 @@ func (re *writerResponseEmitter) Emit(v interface{}) error {
 }
 
 type MaybeError struct {
-	Value interface{} // needs to be a pointer
-	Error *Error
+	Value   interface{} // needs to be a pointer
+	Error   *Error
+	Trailer *Trailer
+	Warning *Warning
+
+	// Outputs are the output types values tagged with their type are
+	// decoded into, see Command.OutputTypes.
+	Outputs []OutputType
+
+	isError     bool
+	isHeartbeat bool
+}
 
-	isError bool
+// IsTrailer returns whether the decoded value was a response trailer.
+func (m *MaybeError) IsTrailer() bool {
+	return m.Trailer != nil
+}
+
+// IsWarning returns whether the decoded value was a Warning.
+func (m *MaybeError) IsWarning() bool {
+	return m.Warning != nil
+}
+
+// IsHeartbeat returns whether the decoded value was a Heartbeat.
+func (m *MaybeError) IsHeartbeat() bool {
+	return m.isHeartbeat
 }
 
 func (m *MaybeError) Get() (interface{}, error) {
@@ -200,6 +366,40 @@ func (m *MaybeError) UnmarshalJSON(data []byte) error {
 		return nil
 	}
 
+	var t Trailer
+	if bytes.Contains(data, []byte(`"trailer"`)) && json.Unmarshal(data, &t) == nil {
+		m.Trailer = &t
+		return nil
+	}
+
+	var w Warning
+	if bytes.Contains(data, []byte(`"warning"`)) && json.Unmarshal(data, &w) == nil {
+		m.Warning = &w
+		return nil
+	}
+
+	if bytes.Contains(data, []byte(`"heartbeat"`)) {
+		var h struct{ Type string }
+		if json.Unmarshal(data, &h) == nil && h.Type == "heartbeat" {
+			m.isHeartbeat = true
+			return nil
+		}
+	}
+
+	// raw frames are left as they are, tags included
+	if _, raw := m.Value.(*json.RawMessage); !raw {
+		var p Progress
+		if bytes.Contains(data, []byte(`"progress"`)) && json.Unmarshal(data, &p) == nil {
+			m.Value = &p
+			return nil
+		}
+
+		if v, ok, err := decodeTagged(data, m.Outputs); ok {
+			m.Value = v
+			return err
+		}
+	}
+
 	if m.Value != nil {
 		// make sure we are working with a pointer here
 		v := reflect.ValueOf(m.Value)
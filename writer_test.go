@@ -0,0 +1,98 @@
+package cmds
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWriterResponseEmitter(t *testing.T) {
+	cmd := &Command{
+		Run: func(req *Request, re ResponseEmitter, env Environment) error {
+			if err := re.Emit("a"); err != nil {
+				return err
+			}
+			if err := re.Emit("b"); err != nil {
+				return err
+			}
+			return Errorf(ErrClient, "failed")
+		},
+	}
+
+	req, err := NewRequest(context.Background(), nil, nil, nil, nil, cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	re, err := NewWriterResponseEmitter(writecloser{&buf, nopCloser{}}, req, StreamWithEncoding(JSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewExecutor(cmd).Execute(req, re, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := NewReaderResponse(&buf, req, StreamWithEncoding(JSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var values []interface{}
+	for {
+		v, err := res.Next()
+		if err != nil {
+			if e, ok := err.(*Error); !ok || e.Code != ErrClient || e.Message != "failed" {
+				t.Errorf("expected client error %q, got %#v", "failed", err)
+			}
+			break
+		}
+		values = append(values, v)
+	}
+	if exp := []interface{}{"a", "b"}; !reflect.DeepEqual(values, exp) {
+		t.Errorf("expected values %v, got %v", exp, values)
+	}
+	if e := res.Error(); e == nil || e.Message != "failed" {
+		t.Errorf("expected response error %q, got %v", "failed", e)
+	}
+}
+
+func TestWriterResponseEmitterEncoder(t *testing.T) {
+	upper := func(req *Request) func(io.Writer) Encoder {
+		return MakeEncoder(func(req *Request, w io.Writer, v interface{}) error {
+			_, err := io.WriteString(w, strings.ToUpper(v.(string))+"\n")
+			return err
+		})(req)
+	}
+
+	req, err := NewRequest(context.Background(), nil, nil, nil, nil, &Command{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	re, err := NewWriterResponseEmitter(writecloser{&buf, nopCloser{}}, req, StreamWithEncoder(upper))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := re.Emit("value"); err != nil {
+		t.Fatal(err)
+	}
+	// readers are copied as is
+	if err := re.Emit(strings.NewReader("raw")); err != nil {
+		t.Fatal(err)
+	}
+	re.Close()
+
+	out, _ := ioutil.ReadAll(&buf)
+	if string(out) != "VALUE\nraw" {
+		t.Errorf("unexpected output %q", out)
+	}
+
+	if _, err := NewWriterResponseEmitter(writecloser{&buf, nopCloser{}}, req, StreamWithEncoding("foobar")); err == nil {
+		t.Error("expected error for unknown encoding")
+	}
+}